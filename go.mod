@@ -0,0 +1,8 @@
+module github.com/THuitema/Distributed-Systems-Tutorial
+
+go 1.25.5
+
+require (
+	github.com/google/uuid v1.6.0
+	github.com/jepsen-io/maelstrom/demo/go v0.0.0-20251128144731-cb7f07239012
+)
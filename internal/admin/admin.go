@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Admin RPCs
+
+The same operational surface on every service. Each module registers itself with Of(node).Register, under
+its package's name, with a status function summing up its state and, if it queues work, a flush function
+pushing that work out now. Register adds the handlers:
+  admin_status       the node, its workload, uptime, whether it's paused and every module's status
+  admin_config_get   the settings each module is running with (see internal/config), optionally just
+                     module's, and the overrides set since startup
+  admin_config_set   name, value: checks value against the setting's rules and overrides it; modules read
+                     their settings at startup, so it takes effect when the node is restarted with them
+  admin_pause        holds the node's background work, see lifecycle.Pause
+  admin_resume       lets it carry on
+  admin_flush        runs every module's flush, or only those listed in modules, within flushTimeout
+*/
+
+var logger = logging.For("admin")
+
+const flushTimeout = 5 * time.Second
+
+type Module struct {
+	// Returns a summary of the module's state for admin_status, nil if it has nothing to report
+	Status func() any
+
+	// Pushes out queued work, nil if there's none to push
+	Flush func(ctx context.Context) error
+}
+
+type Admin struct {
+	mu      sync.Mutex
+	modules map[string]Module
+}
+
+var (
+	mu     sync.Mutex
+	admins = map[*maelstrom.Node]*Admin{}
+)
+
+// Returns node's admin registry
+func Of(node *maelstrom.Node) *Admin {
+	mu.Lock()
+	defer mu.Unlock()
+
+	a, ok := admins[node]
+	if !ok {
+		a = &Admin{modules: make(map[string]Module)}
+		admins[node] = a
+	}
+	return a
+}
+
+// Adds a module to the admin RPCs' replies
+func (a *Admin) Register(name string, m Module) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.modules[name] = m
+}
+
+func (a *Admin) snapshot() map[string]Module {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.modules)
+}
+
+// Returns every module's status
+func (a *Admin) Status() map[string]any {
+	statuses := make(map[string]any)
+	for name, m := range a.snapshot() {
+		if m.Status != nil {
+			statuses[name] = m.Status()
+		} else {
+			statuses[name] = nil
+		}
+	}
+	return statuses
+}
+
+// Flushes the named modules, or every one with a flush if names is empty, and returns those flushed
+func (a *Admin) Flush(ctx context.Context, names []string) ([]string, error) {
+	modules := a.snapshot()
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(modules))
+	}
+
+	flushed := []string{}
+	var errs []error
+	for _, name := range names {
+		m, ok := modules[name]
+		if !ok {
+			return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("no module %q", name))
+		}
+		if m.Flush == nil {
+			continue
+		}
+
+		if err := m.Flush(ctx); err != nil {
+			logger.Error("flush failed", "module", name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		flushed = append(flushed, name)
+	}
+	return flushed, errors.Join(errs...)
+}
+
+type StatusRequestBody struct {
+	Type string `json:"type"`
+}
+
+type StatusResponseBody struct {
+	Type     string         `json:"type"`
+	Node     string         `json:"node"`
+	Workload string         `json:"workload"`
+	UptimeMs int64          `json:"uptime_ms"`
+	Paused   bool           `json:"paused"`
+	Modules  map[string]any `json:"modules"`
+}
+
+type ConfigGetRequestBody struct {
+	Type   string `json:"type"`
+	Module string `json:"module,omitempty"`
+}
+
+type ConfigGetResponseBody struct {
+	Type      string                    `json:"type"`
+	Config    map[string]map[string]any `json:"config"`
+	Overrides map[string]string         `json:"overrides"`
+}
+
+type ConfigSetRequestBody struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ConfigSetResponseBody struct {
+	Type   string `json:"type"`
+	Module string `json:"module"`
+}
+
+type PauseRequestBody struct {
+	Type string `json:"type"`
+}
+
+type PauseResponseBody struct {
+	Type   string `json:"type"`
+	Paused bool   `json:"paused"`
+}
+
+type FlushRequestBody struct {
+	Type    string   `json:"type"`
+	Modules []string `json:"modules,omitempty"`
+}
+
+type FlushResponseBody struct {
+	Type    string   `json:"type"`
+	Flushed []string `json:"flushed"`
+}
+
+// Registers the admin handlers on n, which runs workload
+func Register(n *maelstrom.Node, workload string) error {
+	a := Of(n)
+	l := lifecycle.Of(n)
+	started := time.Now()
+
+	handler.Handle(n, "admin_status", func(msg maelstrom.Message, body StatusRequestBody) (StatusResponseBody, error) {
+		return StatusResponseBody{
+			Node:     n.ID(),
+			Workload: workload,
+			UptimeMs: time.Since(started).Milliseconds(),
+			Paused:   l.Paused(),
+			Modules:  a.Status(),
+		}, nil
+	})
+
+	handler.Handle(n, "admin_config_get", func(msg maelstrom.Message, body ConfigGetRequestBody) (ConfigGetResponseBody, error) {
+		settings := config.Settings()
+		if body.Module != "" {
+			module, ok := settings[body.Module]
+			if !ok {
+				return ConfigGetResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("no module %q", body.Module))
+			}
+			settings = map[string]map[string]any{body.Module: module}
+		}
+		return ConfigGetResponseBody{Config: settings, Overrides: config.Overrides()}, nil
+	})
+
+	handler.Handle(n, "admin_config_set", func(msg maelstrom.Message, body ConfigSetRequestBody) (ConfigSetResponseBody, error) {
+		module, err := config.Check(body.Name, body.Value)
+		if err != nil {
+			return ConfigSetResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		config.Set(body.Name, body.Value)
+		logger.Info("setting overridden", "name", body.Name, "value", body.Value)
+		return ConfigSetResponseBody{Module: module}, nil
+	})
+
+	handler.Handle(n, "admin_pause", func(msg maelstrom.Message, body PauseRequestBody) (PauseResponseBody, error) {
+		l.Pause()
+		return PauseResponseBody{Paused: true}, nil
+	})
+
+	handler.Handle(n, "admin_resume", func(msg maelstrom.Message, body PauseRequestBody) (PauseResponseBody, error) {
+		l.Resume()
+		return PauseResponseBody{Paused: false}, nil
+	})
+
+	handler.Handle(n, "admin_flush", func(msg maelstrom.Message, body FlushRequestBody) (FlushResponseBody, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+
+		flushed, err := a.Flush(ctx, body.Modules)
+		if err != nil {
+			return FlushResponseBody{}, err
+		}
+		return FlushResponseBody{Flushed: flushed}, nil
+	})
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestFlush(t *testing.T) {
+	a := Of(maelstrom.NewNode())
+
+	var flushed []string
+	flusher := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			flushed = append(flushed, name)
+			return err
+		}
+	}
+	a.Register("b", Module{Flush: flusher("b", nil)})
+	a.Register("a", Module{Flush: flusher("a", nil)})
+	a.Register("status-only", Module{Status: func() any { return 1 }})
+
+	names, err := a.Flush(context.Background(), nil)
+	if err != nil || !slices.Equal(names, []string{"a", "b"}) || !slices.Equal(flushed, []string{"a", "b"}) {
+		t.Fatalf("Flush = %v, %v, flushed %v", names, err, flushed)
+	}
+
+	if _, err := a.Flush(context.Background(), []string{"missing"}); err == nil {
+		t.Error("flushed a module that isn't registered")
+	}
+
+	a.Register("broken", Module{Flush: flusher("broken", errors.New("disk full"))})
+	if _, err := a.Flush(context.Background(), []string{"broken", "a"}); err == nil {
+		t.Error("a failed flush wasn't reported")
+	}
+
+	if status := a.Status(); status["status-only"] != 1 || status["a"] != nil {
+		t.Errorf("status %v", status)
+	}
+}
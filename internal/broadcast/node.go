@@ -0,0 +1,227 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/crdt"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/membership"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Challenge #3: Broadcast
+
+The received messages are a grow-only set, spread to the topology neighbours by the gossip engine: new messages are batched and
+sent every BROADCAST_INTERVAL_MS (100 by default) until each neighbour acknowledges them, and a
+digest of the message set is compared every few rounds to repair anything lost along the way. With
+BROADCAST_SWIM set, dead neighbours are routed around (see overlay.go).
+
+Benchmarks (flooding each message to the neighbours on its own, before batching):
+
+Grid Topology:
+  Messages-per-op: 53.56
+  Median Latency: 451 ms
+  Max Latency: 799 ms
+
+Line Topology:
+  Messages-per-op: 23.56
+  Median Latency: 1572
+  Max Latency: 2433
+*/
+
+var logger = logging.For("broadcast")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// BROADCAST_INTERVAL_MS, how often new messages are sent to the neighbours (default 100)
+	Interval time.Duration `env:"BROADCAST_INTERVAL_MS" min:"1"`
+
+	// BROADCAST_SWIM, whether gossip follows the SWIM membership view rather than the topology alone
+	SWIM bool `env:"BROADCAST_SWIM"`
+}
+
+// Broadcast RPC
+type BroadcastRequestBody struct {
+	Type    string `json:"type"`
+	Message int    `json:"message"`
+}
+
+type BroadcastResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string `json:"type"`
+}
+
+type ReadResponseBody struct {
+	Type     string `json:"type"`
+	Messages []int  `json:"messages"`
+}
+
+// Topology RPC
+type TopologyRequestBody struct {
+	Type     string              `json:"type"`
+	Topology map[string][]string `json:"topology"`
+}
+
+type TopologyResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Registers the broadcast handlers on n and starts gossiping
+func Register(n *maelstrom.Node) error {
+
+	messages := crdt.NewGSet[int]()
+
+	cfg := Config{Interval: 100 * time.Millisecond}
+	if err := config.Load("broadcast", &cfg); err != nil {
+		return err
+	}
+
+	engine := gossip.New(n, gossip.Store[crdt.GSetState[int]](messages), gossip.Config{
+		Name:        "broadcast",
+		Interval:    cfg.Interval,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+
+	// Messages acknowledged to a client are journaled before the reply, and replayed on init, so a restarted
+	// node still has them (see internal/storage); the ones learnt through gossip come back through gossip
+	var journalMu sync.Mutex
+	var journal storage.Log
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		store, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+		log, records, err := store.OpenLog("broadcast.messages")
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			var message int
+			if err := json.Unmarshal(record, &message); err != nil {
+				return err
+			}
+			messages.Add(message)
+		}
+
+		journalMu.Lock()
+		journal = log
+		journalMu.Unlock()
+		return nil
+	})
+
+	// Journals a message and queues it for the neighbours, unless it's been seen, repeats are already on their way
+	remember := func(message int) error {
+		if messages.Contains(message) {
+			return nil
+		}
+
+		journalMu.Lock()
+		log := journal
+		journalMu.Unlock()
+
+		if log != nil {
+			if err := log.Append(strconv.AppendInt(nil, int64(message), 10)); err != nil {
+				return err
+			}
+		}
+		engine.Update(messages.Add(message))
+		return nil
+	}
+
+	// This message requests that a value be broadcast out to all nodes in the cluster
+	// Always an integer and unique
+	handler.Handle(n, "broadcast", func(msg maelstrom.Message, body BroadcastRequestBody) (BroadcastResponseBody, error) {
+		if err := remember(body.Message); err != nil {
+			return BroadcastResponseBody{}, err
+		}
+
+		return BroadcastResponseBody{}, nil
+	})
+
+	// Snapshots hold the message set, restoring one adds its messages as if they'd been broadcast here
+	snapshot.Of(n).Provide("broadcast", func() (any, error) {
+		return messages.Elements(), nil
+	}, func(data json.RawMessage) error {
+		var restored []int
+		if err := json.Unmarshal(data, &restored); err != nil {
+			return err
+		}
+		for _, message := range restored {
+			if err := remember(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// This message requests that a node return all values it has seen
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		return ReadResponseBody{
+			Messages: messages.Elements(),
+		}, nil
+	})
+
+	// The gossip peers are the topology neighbours, or with SWIM the overlay over the live ones
+	var members *membership.Membership
+	var topologyMu sync.Mutex
+	var topology map[string][]string
+	setPeers := func() {
+		topologyMu.Lock()
+		defer topologyMu.Unlock()
+
+		if members == nil {
+			engine.SetPeers(topology[n.ID()])
+		} else {
+			engine.SetPeers(overlay(topology, n.ID(), members.View()))
+		}
+	}
+
+	if cfg.SWIM {
+		var err error
+		if members, err = membership.New(n); err != nil {
+			return err
+		}
+		members.OnChange(func(view []string) { setPeers() })
+		lifecycle.Of(n).Go(members.Run)
+	}
+
+	// This message informs the node of who its neighboring nodes are
+	handler.Handle(n, "topology", func(msg maelstrom.Message, body TopologyRequestBody) (TopologyResponseBody, error) {
+		topologyMu.Lock()
+		topology = body.Topology
+		topologyMu.Unlock()
+		setPeers()
+
+		logger.InfoContext(logging.WithMessage(context.Background(), msg), "topology received")
+
+		return TopologyResponseBody{}, nil
+	})
+
+	// Gossip has its own entry, see internal/gossip
+	admin.Of(n).Register("broadcast", admin.Module{Status: func() any {
+		return map[string]any{"messages": len(messages.Elements())}
+	}})
+
+	lifecycle.Of(n).Go(engine.Run)
+
+	return nil
+}
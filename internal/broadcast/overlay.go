@@ -0,0 +1,35 @@
+package broadcast
+
+import "slices"
+
+/*
+Overlay repair
+
+With BROADCAST_SWIM set, the gossip peers follow the membership view (see internal/membership) instead of
+being the topology neighbours as given. A neighbour that's dead is dropped, and its own live neighbours
+are gossiped to in its place, so a line or a tree isn't cut in two by a dead node. Once it's back it's a
+peer again, and gossip sends it the whole message set.
+*/
+
+// Returns self's gossip peers: its live neighbours in topology, and the live neighbours of its dead ones
+func overlay(topology map[string][]string, self string, view []string) []string {
+	var peers []string
+	add := func(peer string) {
+		if peer != self && !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+
+	for _, neighbour := range topology[self] {
+		if slices.Contains(view, neighbour) {
+			add(neighbour)
+			continue
+		}
+		for _, bridge := range topology[neighbour] {
+			if slices.Contains(view, bridge) {
+				add(bridge)
+			}
+		}
+	}
+	return peers
+}
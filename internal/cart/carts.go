@@ -0,0 +1,234 @@
+package cart
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/crdt"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+)
+
+/*
+Carts
+
+Both modes keep every cart on every node and gossip changes as deltas keyed by cart ID, so any node can take
+any change and the deltas merge in any order:
+
+  orset  each cart is an observed-remove set of items (see crdt.ORSet). A remove only takes away the adds
+         of the item this node has seen, so an item added concurrently elsewhere survives it (default)
+  lww    each cart is a last-writer-wins register holding the whole item list, each change writing back
+         the list as this node sees it. Of two concurrent changes one wins outright: an item added on one
+         node and a different one removed on another either loses the add or brings the removed item back
+*/
+
+const (
+	ORSetMode = "orset"
+	LWWMode   = "lww"
+)
+
+// The carts of one mode, D being the delta gossiped between nodes
+type Carts[D any] interface {
+	Merge(delta D)
+	Join(a, b D) D
+	Full() D
+	Digest() uint64
+
+	// Add and Remove change a cart and return the delta changing it
+	Add(replica string, cart string, item string) D
+	Remove(replica string, cart string, item string) D
+
+	// Returns a cart's items in order, none if it's never been changed
+	Items(cart string) []string
+	Len() int
+}
+
+type ORSetCarts struct {
+	mu    sync.Mutex
+	carts map[string]*crdt.ORSet[string]
+}
+
+func NewORSetCarts() *ORSetCarts {
+	return &ORSetCarts{carts: map[string]*crdt.ORSet[string]{}}
+}
+
+// Returns cart's set, creating it empty if there isn't one yet
+func (c *ORSetCarts) cart(cart string) *crdt.ORSet[string] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	set, ok := c.carts[cart]
+	if !ok {
+		set = crdt.NewORSet[string]()
+		c.carts[cart] = set
+	}
+	return set
+}
+
+func (c *ORSetCarts) Add(replica string, cart string, item string) map[string]crdt.ORSetState[string] {
+	return map[string]crdt.ORSetState[string]{cart: c.cart(cart).Add(replica, item)}
+}
+
+func (c *ORSetCarts) Remove(replica string, cart string, item string) map[string]crdt.ORSetState[string] {
+	return map[string]crdt.ORSetState[string]{cart: c.cart(cart).Remove(item)}
+}
+
+func (c *ORSetCarts) Items(cart string) []string {
+	c.mu.Lock()
+	set, ok := c.carts[cart]
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return set.Elements()
+}
+
+func (c *ORSetCarts) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.carts)
+}
+
+func (c *ORSetCarts) Merge(delta map[string]crdt.ORSetState[string]) {
+	for cart, state := range delta {
+		c.cart(cart).Merge(state)
+	}
+}
+
+// Joins the carts in both a and b
+func (c *ORSetCarts) Join(a, b map[string]crdt.ORSetState[string]) map[string]crdt.ORSetState[string] {
+	joined := maps.Clone(a)
+	for cart, state := range b {
+		if other, ok := joined[cart]; ok {
+			state = crdt.NewORSet[string]().Join(other, state)
+		}
+		joined[cart] = state
+	}
+	return joined
+}
+
+func (c *ORSetCarts) Full() map[string]crdt.ORSetState[string] {
+	c.mu.Lock()
+	carts := maps.Clone(c.carts)
+	c.mu.Unlock()
+
+	full := map[string]crdt.ORSetState[string]{}
+	for cart, set := range carts {
+		full[cart] = set.Full()
+	}
+	return full
+}
+
+func (c *ORSetCarts) Digest() uint64 {
+	return digest(c.Full())
+}
+
+type LWWCarts struct {
+	mu    sync.Mutex
+	clock env.Clock
+	carts map[string]*crdt.LWWRegister[[]string]
+}
+
+// Returns carts stamping their changes with clock's time
+func NewLWWCarts(clock env.Clock) *LWWCarts {
+	return &LWWCarts{clock: clock, carts: map[string]*crdt.LWWRegister[[]string]{}}
+}
+
+// Returns cart's register, creating it unset if there isn't one yet
+func (c *LWWCarts) cart(cart string) *crdt.LWWRegister[[]string] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	register, ok := c.carts[cart]
+	if !ok {
+		register = crdt.NewLWWRegister[[]string]()
+		c.carts[cart] = register
+	}
+	return register
+}
+
+// Writes back the item list with item added, the naive read-modify-write the register allows
+func (c *LWWCarts) Add(replica string, cart string, item string) map[string]crdt.LWWRegisterState[[]string] {
+	register := c.cart(cart)
+	items, _ := register.Get()
+	if !slices.Contains(items, item) {
+		items = append(slices.Clone(items), item)
+		slices.Sort(items)
+	}
+	return map[string]crdt.LWWRegisterState[[]string]{cart: register.Set(replica, items, c.clock.Now().UnixMilli())}
+}
+
+// Writes back the item list without item
+func (c *LWWCarts) Remove(replica string, cart string, item string) map[string]crdt.LWWRegisterState[[]string] {
+	register := c.cart(cart)
+	items, _ := register.Get()
+	items = slices.DeleteFunc(slices.Clone(items), func(x string) bool { return x == item })
+	return map[string]crdt.LWWRegisterState[[]string]{cart: register.Set(replica, items, c.clock.Now().UnixMilli())}
+}
+
+func (c *LWWCarts) Items(cart string) []string {
+	c.mu.Lock()
+	register, ok := c.carts[cart]
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	items, _ := register.Get()
+	return slices.Clone(items)
+}
+
+func (c *LWWCarts) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.carts)
+}
+
+func (c *LWWCarts) Merge(delta map[string]crdt.LWWRegisterState[[]string]) {
+	for cart, state := range delta {
+		c.cart(cart).Merge(state)
+	}
+}
+
+// Keeps the later write of every cart in a and b
+func (c *LWWCarts) Join(a, b map[string]crdt.LWWRegisterState[[]string]) map[string]crdt.LWWRegisterState[[]string] {
+	joined := maps.Clone(a)
+	for cart, state := range b {
+		if other, ok := joined[cart]; ok {
+			state = crdt.NewLWWRegister[[]string]().Join(other, state)
+		}
+		joined[cart] = state
+	}
+	return joined
+}
+
+func (c *LWWCarts) Full() map[string]crdt.LWWRegisterState[[]string] {
+	c.mu.Lock()
+	carts := maps.Clone(c.carts)
+	c.mu.Unlock()
+
+	full := map[string]crdt.LWWRegisterState[[]string]{}
+	for cart, register := range carts {
+		full[cart] = register.Full()
+	}
+	return full
+}
+
+func (c *LWWCarts) Digest() uint64 {
+	return digest(c.Full())
+}
+
+// Hashes the JSON form of the carts, the same on every node holding the same carts since map keys are sorted
+func digest(carts any) uint64 {
+	data, err := json.Marshal(carts)
+	if err != nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,135 @@
+package cart
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/crdt"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Shopping carts
+
+Goal: shopping carts that stay available on every node, where an item a customer added is never lost to a
+remove made concurrently on another node
+
+Every node keeps a replica of every cart and answers add_item, remove_item and read_cart from it straight
+away, then gossips the change to the other nodes. Gossip also compares digests of the carts every tenth
+round (anti-entropy, see internal/gossip), so a change whose delta was lost still gets everywhere.
+
+CART_MODE=lww keeps the carts in last-writer-wins registers instead of observed-remove sets, to show what
+goes wrong without observed-remove semantics (see carts.go).
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// CART_MODE, how a cart is kept, orset (default) or lww
+	Mode string `env:"CART_MODE" oneof:"orset|lww"`
+
+	// CART_INTERVAL_MS, how often changes are gossiped to the other nodes (default 100)
+	Interval time.Duration `env:"CART_INTERVAL_MS" min:"1"`
+}
+
+// Add item RPC
+type AddItemRequestBody struct {
+	Type string `json:"type"`
+	Cart string `json:"cart"`
+	Item string `json:"item"`
+}
+
+func (b AddItemRequestBody) Validate() error {
+	if b.Item == "" {
+		return errors.New("item must not be empty")
+	}
+	return nil
+}
+
+type AddItemResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Remove item RPC
+type RemoveItemRequestBody struct {
+	Type string `json:"type"`
+	Cart string `json:"cart"`
+	Item string `json:"item"`
+}
+
+type RemoveItemResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Read cart RPC
+type ReadCartRequestBody struct {
+	Type string `json:"type"`
+	Cart string `json:"cart"`
+}
+
+type ReadCartResponseBody struct {
+	Type  string   `json:"type"`
+	Items []string `json:"items"`
+}
+
+// Registers the cart handlers on n and starts gossiping
+func Register(n *maelstrom.Node) error {
+	cfg := Config{Mode: ORSetMode, Interval: 100 * time.Millisecond}
+	if err := config.Load("cart", &cfg); err != nil {
+		return err
+	}
+
+	if cfg.Mode == LWWMode {
+		run[map[string]crdt.LWWRegisterState[[]string]](n, cfg, NewLWWCarts(env.Of(n).Clock))
+	} else {
+		run[map[string]crdt.ORSetState[string]](n, cfg, NewORSetCarts())
+	}
+	return nil
+}
+
+func run[D any](n *maelstrom.Node, cfg Config, carts Carts[D]) {
+	engine := gossip.New(n, gossip.Store[D](carts), gossip.Config{
+		Name:        "cart",
+		Interval:    cfg.Interval,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+
+	// Gossip with every other node
+	n.Handle("init", func(msg maelstrom.Message) error {
+		engine.SetPeers(slices.DeleteFunc(slices.Clone(n.NodeIDs()), func(id string) bool {
+			return id == n.ID()
+		}))
+		return nil
+	})
+
+	handler.Handle(n, "add_item", func(msg maelstrom.Message, body AddItemRequestBody) (AddItemResponseBody, error) {
+		engine.Update(carts.Add(n.ID(), body.Cart, body.Item))
+		return AddItemResponseBody{}, nil
+	})
+
+	handler.Handle(n, "remove_item", func(msg maelstrom.Message, body RemoveItemRequestBody) (RemoveItemResponseBody, error) {
+		engine.Update(carts.Remove(n.ID(), body.Cart, body.Item))
+		return RemoveItemResponseBody{}, nil
+	})
+
+	handler.Handle(n, "read_cart", func(msg maelstrom.Message, body ReadCartRequestBody) (ReadCartResponseBody, error) {
+		items := carts.Items(body.Cart)
+		if items == nil {
+			items = []string{}
+		}
+		return ReadCartResponseBody{Items: items}, nil
+	})
+
+	admin.Of(n).Register("cart", admin.Module{Status: func() any {
+		return map[string]any{"mode": cfg.Mode, "carts": carts.Len()}
+	}})
+
+	lifecycle.Of(n).Go(engine.Run)
+}
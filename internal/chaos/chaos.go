@@ -0,0 +1,241 @@
+package chaos
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Chaos
+
+Faults injected into a node's own message handling, to exercise retry and replication paths inside a
+Maelstrom run without an external nemesis. Register wraps the node's input and output, so it covers every
+handler, and must be called before lifecycle.Serve. Each request, chosen at random with its probability:
+  - is delayed by delay_ms before it reaches its handler
+  - is answered with an error_code error (Crash by default) without reaching its handler
+  - is handled, but has its reply dropped, so the sender sees the handler's effects without an answer
+
+The chaos admin RPC sets the faults, replacing the previous ones, and replies with them and how many of
+each have been injected; a chaos message without any probability turns them off. types limits them to
+requests of those types, otherwise every request but init and chaos itself is fair game. Replies to the
+node's own RPCs are never touched. Random choices and delays come from the node's environment (see
+internal/env), so simulated runs stay reproducible.
+*/
+
+var logger = logging.For("chaos")
+
+type Faults struct {
+	Types            []string `json:"types,omitempty"`
+	DelayMs          int      `json:"delay_ms,omitempty"`
+	DelayProbability float64  `json:"delay_probability,omitempty"`
+	DropProbability  float64  `json:"drop_probability,omitempty"`
+	ErrorProbability float64  `json:"error_probability,omitempty"`
+	ErrorCode        int      `json:"error_code,omitempty"`
+}
+
+type ChaosRequestBody struct {
+	Type string `json:"type"`
+	Faults
+}
+
+// Probabilities are between 0 and 1
+func (b ChaosRequestBody) Validate() error {
+	for _, p := range []float64{b.DelayProbability, b.DropProbability, b.ErrorProbability} {
+		if p < 0 || p > 1 {
+			return errors.New("probabilities must be between 0 and 1")
+		}
+	}
+	if b.DelayMs < 0 {
+		return errors.New("delay_ms can't be negative")
+	}
+	return nil
+}
+
+type ChaosResponseBody struct {
+	Type    string `json:"type"`
+	Faults  Faults `json:"faults"`
+	Delayed int64  `json:"delayed"`
+	Dropped int64  `json:"dropped"`
+	Errors  int64  `json:"errors"`
+}
+
+// A request whose reply is to be dropped
+type request struct {
+	src   string
+	msgID int
+}
+
+type Chaos struct {
+	node *maelstrom.Node
+	env  *env.Env
+
+	delayed, dropped, errored *metrics.Counter
+
+	mu       sync.Mutex
+	faults   Faults
+	dropping map[request]struct{}
+}
+
+// Sets the faults to inject from now on
+func (c *Chaos) Set(faults Faults) {
+	if faults.ErrorCode == 0 {
+		faults.ErrorCode = maelstrom.Crash
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = faults
+	logger.Info("faults set", "faults", faults)
+}
+
+// Returns whether a request of type typ is subject to faults, and the faults
+func (c *Chaos) target(typ string) (Faults, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if typ == "init" || typ == "chaos" {
+		return Faults{}, false
+	}
+	if len(c.faults.Types) > 0 && !slices.Contains(c.faults.Types, typ) {
+		return Faults{}, false
+	}
+	return c.faults, true
+}
+
+func (c *Chaos) roll(p float64) bool {
+	return p > 0 && c.env.Rand.Float64() < p
+}
+
+// Copies lines from r to w, injecting faults into the requests among them, and closes w at the end
+func (c *Chaos) filter(r io.Reader, w *io.PipeWriter) {
+	var wmu sync.Mutex
+	var pending sync.WaitGroup
+	write := func(line []byte) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		w.Write(line)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// The scanner reuses its buffer, so the line is copied
+		line := append(slices.Clone(scanner.Bytes()), '\n')
+
+		var msg maelstrom.Message
+		var body maelstrom.MessageBody
+		if json.Unmarshal(line, &msg) != nil || json.Unmarshal(msg.Body, &body) != nil || body.InReplyTo != 0 {
+			write(line)
+			continue
+		}
+
+		faults, ok := c.target(body.Type)
+		if !ok {
+			write(line)
+			continue
+		}
+
+		if c.roll(faults.ErrorProbability) {
+			c.errored.Inc()
+			if body.MsgID != 0 {
+				c.node.Reply(msg, maelstrom.NewRPCError(faults.ErrorCode, "injected fault"))
+			}
+			continue
+		}
+
+		if body.MsgID != 0 && c.roll(faults.DropProbability) {
+			c.mu.Lock()
+			c.dropping[request{src: msg.Src, msgID: body.MsgID}] = struct{}{}
+			c.mu.Unlock()
+		}
+
+		if c.roll(faults.DelayProbability) {
+			c.delayed.Inc()
+			pending.Add(1)
+			delay := c.env.Clock.After(time.Duration(faults.DelayMs) * time.Millisecond)
+			go func() {
+				defer pending.Done()
+				<-delay
+				write(line)
+			}()
+			continue
+		}
+
+		write(line)
+	}
+
+	pending.Wait()
+	w.CloseWithError(scanner.Err())
+}
+
+// Drops the replies to requests chosen for it on their way out
+type replyDropper struct {
+	w io.Writer
+	c *Chaos
+}
+
+func (d *replyDropper) Write(p []byte) (int, error) {
+	var msg maelstrom.Message
+	var body maelstrom.MessageBody
+	if json.Unmarshal(p, &msg) == nil && json.Unmarshal(msg.Body, &body) == nil && body.InReplyTo != 0 {
+		req := request{src: msg.Dest, msgID: body.InReplyTo}
+
+		d.c.mu.Lock()
+		_, drop := d.c.dropping[req]
+		delete(d.c.dropping, req)
+		d.c.mu.Unlock()
+
+		if drop {
+			d.c.dropped.Inc()
+			return len(p), nil
+		}
+	}
+	return d.w.Write(p)
+}
+
+// Wraps n's input and output to inject faults and registers the chaos handler
+// Must be called before the node starts running
+func Register(n *maelstrom.Node) error {
+	m := metrics.Of(n)
+	c := &Chaos{
+		node:     n,
+		env:      env.Of(n),
+		delayed:  m.Counter("chaos.delayed"),
+		dropped:  m.Counter("chaos.dropped"),
+		errored:  m.Counter("chaos.errors"),
+		dropping: make(map[request]struct{}),
+	}
+
+	input := n.Stdin
+	reader, writer := io.Pipe()
+	n.Stdin = reader
+	n.Stdout = &replyDropper{w: n.Stdout, c: c}
+	go c.filter(input, writer)
+
+	handler.Handle(n, "chaos", func(msg maelstrom.Message, body ChaosRequestBody) (ChaosResponseBody, error) {
+		c.Set(body.Faults)
+
+		c.mu.Lock()
+		faults := c.faults
+		c.mu.Unlock()
+
+		return ChaosResponseBody{
+			Faults:  faults,
+			Delayed: c.delayed.Value(),
+			Dropped: c.dropped.Value(),
+			Errors:  c.errored.Value(),
+		}, nil
+	})
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package chaos
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestFaults(t *testing.T) {
+	n := maelstrom.NewNode()
+	stdin, input := io.Pipe()
+	output, stdout := io.Pipe()
+	n.Stdin, n.Stdout = stdin, stdout
+
+	replies := make(chan maelstrom.MessageBody, 16)
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			var msg maelstrom.Message
+			var body maelstrom.MessageBody
+			json.Unmarshal(scanner.Bytes(), &msg)
+			json.Unmarshal(msg.Body, &body)
+			replies <- body
+		}
+	}()
+
+	handled := make(chan int, 16)
+	n.Handle("ping", func(msg maelstrom.Message) error {
+		var body maelstrom.MessageBody
+		json.Unmarshal(msg.Body, &body)
+		handled <- body.MsgID
+		return n.Reply(msg, map[string]any{"type": "ping_ok"})
+	})
+
+	if err := Register(n); err != nil {
+		t.Fatal(err)
+	}
+	go n.Run()
+
+	send := func(msgID int, body string) maelstrom.MessageBody {
+		t.Helper()
+		fmt.Fprintf(input, `{"src":"c1","dest":"n1","body":{"msg_id":%d,%s}}`+"\n", msgID, body)
+		select {
+		case reply := <-replies:
+			return reply
+		case <-time.After(time.Second):
+			return maelstrom.MessageBody{}
+		}
+	}
+
+	// Replies are dropped after the handler has run
+	if reply := send(1, `"type":"chaos","drop_probability":1`); reply.Type != "chaos_ok" {
+		t.Fatalf("reply %+v, want chaos_ok", reply)
+	}
+	if reply := send(2, `"type":"ping"`); reply.Type != "" {
+		t.Fatalf("reply %+v, want none", reply)
+	}
+	if msgID := <-handled; msgID != 2 {
+		t.Fatalf("handled %d, want 2", msgID)
+	}
+
+	// Errors stop requests before the handler
+	send(3, `"type":"chaos","error_probability":1,"error_code":11`)
+	if reply := send(4, `"type":"ping"`); reply.Type != "error" || reply.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("reply %+v, want a TemporarilyUnavailable error", reply)
+	}
+
+	// Other types are left alone
+	send(5, `"type":"chaos","types":["pong"],"error_probability":1`)
+	if reply := send(6, `"type":"ping"`); reply.Type != "ping_ok" {
+		t.Fatalf("reply %+v, want ping_ok", reply)
+	}
+
+	select {
+	case msgID := <-handled:
+		if msgID != 6 {
+			t.Fatalf("handled %d, want 6", msgID)
+		}
+	default:
+		t.Fatal("ping 6 wasn't handled")
+	}
+}
@@ -0,0 +1,284 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*
+Linearizability checker
+
+Check looks for an order of a history's operations that respects real time, an operation that completed
+before another was invoked coming first, in which each does what the model says given the ones before
+it. It's Wing and Gong's search with Lowe's memoization: try each operation that could go next, and
+remember which sets of operations already done, in which state, led nowhere. Operations on different
+keys don't affect each other, so each key is checked on its own. Failed operations are left out, and
+Unknown ones may take effect anywhere after their invocation, or not at all.
+
+When there's no such order, Check narrows the key's history down to the window that shows it. The window
+ends at the earliest point the history up to there, taking the operations still running then as Unknown,
+already has no order. It starts at the latest point before that where nothing was running and the
+operations from there on have no order whatever state they started in. So it holds the operations that
+contradict each other and nothing they don't need.
+*/
+
+// A data type operations are checked against
+// S is the state, which is compared to recognize where the search has already been
+type Model[S comparable] struct {
+	Init    S // state before the first operation
+	Unknown S // state at the start of a window, which the first read or successful cas makes known
+
+	// Applies op to state, returning false if its result isn't possible from state
+	Step func(state S, op Op) (S, bool)
+}
+
+type RegisterState struct {
+	Known  bool
+	Exists bool
+	Value  string // compacted JSON
+}
+
+// A register per key supporting read, write and cas, like Maelstrom's lin-kv
+var Register = Model[RegisterState]{
+	Init:    RegisterState{Known: true},
+	Unknown: RegisterState{},
+	Step:    stepRegister,
+}
+
+func stepRegister(s RegisterState, op Op) (RegisterState, bool) {
+	switch op.F {
+	case "read":
+		read := RegisterState{Known: true, Exists: op.Value != nil, Value: compact(op.Value)}
+		return read, !s.Known || s == read
+
+	case "write":
+		return RegisterState{Known: true, Exists: true, Value: compact(op.Value)}, true
+
+	case "cas":
+		to := RegisterState{Known: true, Exists: true, Value: compact(op.To)}
+		if !s.Known || !s.Exists && op.Create || s.Exists && s.Value == compact(op.From) {
+			return to, true
+		}
+		return s, false
+	}
+	return s, false
+}
+
+type CounterState struct {
+	Known bool
+	Value int64
+}
+
+// A single counter supporting add and read, like Maelstrom's grow-only counter workload
+var Counter = Model[CounterState]{
+	Init:    CounterState{Known: true},
+	Unknown: CounterState{},
+	Step:    stepCounter,
+}
+
+func stepCounter(s CounterState, op Op) (CounterState, bool) {
+	var v int64
+	if err := json.Unmarshal(op.Value, &v); err != nil {
+		return s, false
+	}
+
+	switch op.F {
+	case "add":
+		return CounterState{Known: s.Known, Value: s.Value + v}, true
+	case "read":
+		return CounterState{Known: true, Value: v}, !s.Known || s.Value == v
+	}
+	return s, false
+}
+
+type Result struct {
+	Ok     bool
+	Key    string // of the violation
+	Window []Op   // operations showing the violation, in the order they were invoked
+}
+
+func (r Result) String() string {
+	if r.Ok {
+		return "linearizable"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "not linearizable")
+	if r.Key != "" {
+		fmt.Fprintf(&b, " on key %s", r.Key)
+	}
+	fmt.Fprintf(&b, ", no order for these %d operations:", len(r.Window))
+	for _, op := range r.Window {
+		fmt.Fprintf(&b, "\n  %s", op)
+	}
+	return b.String()
+}
+
+// Checks whether ops are linearizable under model, returning the first key, in sorted order, that isn't
+func Check[S comparable](ops []Op, model Model[S]) Result {
+	byKey := make(map[string][]Op)
+	for _, op := range ops {
+		if op.Status == Fail || op.Status == Unknown && op.F == "read" {
+			continue
+		}
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ops := byKey[key]
+		slices.SortFunc(ops, func(a, b Op) int { return a.Invoke - b.Invoke })
+
+		if window := violation(ops, model); window != nil {
+			return Result{Key: key, Window: window}
+		}
+	}
+	return Result{Ok: true}
+}
+
+// Returns the window of ops, sorted by invocation, showing they aren't linearizable, nil if they are
+func violation[S comparable](ops []Op, model Model[S]) []Op {
+	if linearizable(ops, model.Init, model) {
+		return nil
+	}
+
+	var points []int
+	for _, op := range ops {
+		points = append(points, op.Invoke)
+		if op.Complete != never {
+			points = append(points, op.Complete)
+		}
+	}
+	slices.Sort(points)
+
+	// Cutting the history short only leaves fewer constraints, so the earliest end is found by bisection
+	end := points[sort.Search(len(points), func(i int) bool {
+		return !linearizable(cut(ops, 0, points[i]), model.Init, model)
+	})]
+
+	// Starts where nothing that was invoked before is still running
+	var starts []int
+	running := 0
+	for i, op := range ops {
+		if op.Invoke > end {
+			break
+		}
+		if i == 0 || running < op.Invoke {
+			starts = append(starts, i)
+		}
+		running = max(running, op.Complete)
+	}
+
+	for _, i := range slices.Backward(starts) {
+		start := ops[i].Invoke
+		state := model.Unknown
+		if i == 0 {
+			state = model.Init
+		}
+
+		if !linearizable(cut(ops, start, end), state, model) {
+			var window []Op
+			for _, op := range ops {
+				if op.Invoke >= start && op.Invoke <= end {
+					window = append(window, op)
+				}
+			}
+			return window
+		}
+	}
+	return ops
+}
+
+// Returns the ops invoked between start and end, with the ones still running at end as Unknown, dropping
+// reads among them since their results don't count
+func cut(ops []Op, start, end int) []Op {
+	var in []Op
+	for _, op := range ops {
+		if op.Invoke < start || op.Invoke > end {
+			continue
+		}
+		if op.Complete > end {
+			if op.F == "read" {
+				continue
+			}
+			op.Status, op.Complete = Unknown, never
+		}
+		in = append(in, op)
+	}
+	return in
+}
+
+// The operations done so far and the state they left, which the search never needs to reach twice
+type searchKey[S comparable] struct {
+	done  string
+	state S
+}
+
+// Returns whether ops, sorted by invocation, have an order starting from state that's valid under model
+func linearizable[S comparable](ops []Op, state S, model Model[S]) bool {
+	done := make([]byte, (len(ops)+7)/8)
+	seen := make(map[searchKey[S]]bool)
+
+	left := 0
+	for _, op := range ops {
+		if op.Status == Ok {
+			left++
+		}
+	}
+
+	var search func(state S, left int) bool
+	search = func(state S, left int) bool {
+		if left == 0 {
+			return true
+		}
+
+		key := searchKey[S]{done: string(done), state: state}
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+
+		// Only operations invoked before the first of the rest completes can go next
+		first := never
+		for i, op := range ops {
+			if done[i/8]&(1<<(i%8)) == 0 {
+				first = min(first, op.Complete)
+			}
+		}
+
+		for i, op := range ops {
+			if op.Invoke > first {
+				break
+			}
+			if done[i/8]&(1<<(i%8)) != 0 {
+				continue
+			}
+
+			next, ok := model.Step(state, op)
+			if !ok {
+				continue
+			}
+
+			done[i/8] |= 1 << (i % 8)
+			remaining := left
+			if op.Status == Ok {
+				remaining--
+			}
+			if search(next, remaining) {
+				return true
+			}
+			done[i/8] &^= 1 << (i % 8)
+		}
+		return false
+	}
+
+	return search(state, left)
+}
@@ -0,0 +1,259 @@
+package checker_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/checker"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/counter"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func write(client string, v int) checker.Op {
+	return checker.Op{Client: client, F: "write", Key: "1", Value: json.RawMessage(fmt.Sprint(v))}
+}
+
+func read(client string) checker.Op {
+	return checker.Op{Client: client, F: "read", Key: "1"}
+}
+
+func cas(client string, from, to int) checker.Op {
+	return checker.Op{Client: client, F: "cas", Key: "1", From: json.RawMessage(fmt.Sprint(from)), To: json.RawMessage(fmt.Sprint(to))}
+}
+
+func readCounter(client string) checker.Op {
+	return checker.Op{Client: client, F: "read"}
+}
+
+func add(client string, delta int) checker.Op {
+	return checker.Op{Client: client, F: "add", Value: json.RawMessage(fmt.Sprint(delta))}
+}
+
+func value(v int) json.RawMessage {
+	return json.RawMessage(fmt.Sprint(v))
+}
+
+func TestRegisterLinearizable(t *testing.T) {
+	h := checker.NewHistory()
+
+	// c2's read overlaps c1's write, so it may see either the key missing or the write
+	w := h.Invoke(write("c1", 1))
+	r1 := h.Invoke(read("c2"))
+	r2 := h.Invoke(read("c3"))
+	h.Complete(r2, checker.Ok, value(1), 0)
+	h.Complete(r1, checker.Ok, nil, 0)
+	h.Complete(w, checker.Ok, nil, 0)
+
+	c := h.Invoke(cas("c1", 1, 2))
+	h.Complete(c, checker.Ok, nil, 0)
+	c = h.Invoke(cas("c2", 1, 3))
+	h.Complete(c, checker.Fail, nil, maelstrom.PreconditionFailed)
+
+	r := h.Invoke(read("c3"))
+	h.Complete(r, checker.Ok, value(2), 0)
+
+	if result := checker.Check(h.Ops(), checker.Register); !result.Ok {
+		t.Fatal(result)
+	}
+}
+
+func TestStaleReadWindow(t *testing.T) {
+	h := checker.NewHistory()
+
+	for _, op := range []checker.Op{write("c1", 0), read("c2"), write("c1", 1), write("c1", 2)} {
+		id := h.Invoke(op)
+		h.Complete(id, checker.Ok, value(0), 0)
+	}
+	r := h.Invoke(read("c2"))
+	h.Complete(r, checker.Ok, value(1), 0)
+	r = h.Invoke(read("c3"))
+	h.Complete(r, checker.Ok, value(2), 0)
+
+	result := checker.Check(h.Ops(), checker.Register)
+	if result.Ok {
+		t.Fatal("stale read passed")
+	}
+
+	// The read of 1 after the write of 2 is the contradiction, neither the ops before nor the read after matter
+	if len(result.Window) != 2 || result.Window[0].F != "write" || string(result.Window[0].Value) != "2" || string(result.Window[1].Value) != "1" {
+		t.Fatalf("window is\n%s", result)
+	}
+}
+
+func TestUnknownWrite(t *testing.T) {
+	h := checker.NewHistory()
+
+	w := h.Invoke(write("c1", 1))
+	h.Complete(w, checker.Ok, nil, 0)
+	w = h.Invoke(write("c2", 2))
+	h.Complete(w, checker.Unknown, nil, maelstrom.Timeout)
+
+	// The timed out write may take effect any time after it was sent
+	r := h.Invoke(read("c3"))
+	h.Complete(r, checker.Ok, value(1), 0)
+	r = h.Invoke(read("c3"))
+	h.Complete(r, checker.Ok, value(2), 0)
+
+	if result := checker.Check(h.Ops(), checker.Register); !result.Ok {
+		t.Fatal(result)
+	}
+
+	// But only once
+	r = h.Invoke(read("c3"))
+	h.Complete(r, checker.Ok, value(1), 0)
+
+	if result := checker.Check(h.Ops(), checker.Register); result.Ok {
+		t.Fatal("write taking effect twice passed")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	h := checker.NewHistory()
+
+	a1 := h.Invoke(add("c1", 1))
+	a2 := h.Invoke(add("c2", 2))
+	r := h.Invoke(readCounter("c3"))
+	h.Complete(a1, checker.Ok, nil, 0)
+	h.Complete(r, checker.Ok, value(2), 0)
+	h.Complete(a2, checker.Ok, nil, 0)
+
+	r = h.Invoke(readCounter("c3"))
+	h.Complete(r, checker.Ok, value(3), 0)
+
+	if result := checker.Check(h.Ops(), checker.Counter); !result.Ok {
+		t.Fatal(result)
+	}
+
+	r = h.Invoke(readCounter("c1"))
+	h.Complete(r, checker.Ok, value(1), 0)
+
+	result := checker.Check(h.Ops(), checker.Counter)
+	if result.Ok {
+		t.Fatal("counter going down passed")
+	}
+	if len(result.Window) != 2 {
+		t.Fatalf("window is\n%s", result)
+	}
+}
+
+// Sends ops from concurrent clients to random nodes, recording them
+func run(t *testing.T, c *sim.Cluster, h *checker.History, clients int, ops func(rng *rand.Rand) any) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(i), 0))
+
+			for range 20 {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				node := c.NodeIDs()[rng.IntN(len(c.NodeIDs()))]
+				h.RPC(ctx, c, fmt.Sprintf("c%d", i+1), node, ops(rng))
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRaftKVLinearizable(t *testing.T) {
+	c, err := sim.Start([]string{"n0", "n1", "n2"}, raft.Register, sim.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Only the leader serves requests, the others fail them until an election settles
+	h := checker.NewHistory()
+	for deadline := time.Now().Add(5 * time.Second); okOps(h) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected")
+		}
+		for _, id := range c.NodeIDs() {
+			h.RPC(context.Background(), c, "c0", id, map[string]any{"type": "write", "key": 0, "value": 0})
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	run(t, c, h, 4, func(rng *rand.Rand) any {
+		key := rng.IntN(2)
+		switch rng.IntN(3) {
+		case 0:
+			return map[string]any{"type": "read", "key": key}
+		case 1:
+			return map[string]any{"type": "write", "key": key, "value": rng.IntN(5)}
+		default:
+			return map[string]any{"type": "cas", "key": key, "from": rng.IntN(5), "to": rng.IntN(5)}
+		}
+	})
+
+	if result := checker.Check(h.Ops(), checker.Register); !result.Ok {
+		t.Fatal(result)
+	}
+	t.Logf("%d of %d operations ok", okOps(h), len(h.Ops()))
+}
+
+func TestCounterLinearizable(t *testing.T) {
+	c, err := sim.Start([]string{"n0", "n1", "n2"}, counter.Register, sim.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	h := checker.NewHistory()
+	run(t, c, h, 4, func(rng *rand.Rand) any {
+		if rng.IntN(2) == 0 {
+			return map[string]any{"type": "read"}
+		}
+		return map[string]any{"type": "add", "delta": rng.IntN(5)}
+	})
+
+	if result := checker.Check(h.Ops(), checker.Counter); !result.Ok {
+		t.Fatal(result)
+	}
+}
+
+// The CRDT counter only promises reads catch up eventually, which the checker should catch
+func TestCRDTCounterNotLinearizable(t *testing.T) {
+	t.Setenv("COUNTER_MODE", "crdt")
+
+	c, err := sim.Start([]string{"n0", "n1"}, counter.Register, sim.Config{Deterministic: true, MinLatency: time.Millisecond, MaxLatency: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	h := checker.NewHistory()
+	ctx := context.Background()
+	if _, err := h.RPC(ctx, c, "c1", "n0", map[string]any{"type": "add", "delta": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RPC(ctx, c, "c1", "n1", map[string]any{"type": "read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := checker.Check(h.Ops(), checker.Counter)
+	if result.Ok {
+		t.Fatal("read on a node the add hadn't reached passed")
+	}
+	t.Log(result)
+}
+
+func okOps(h *checker.History) int {
+	n := 0
+	for _, op := range h.Ops() {
+		if op.Status == checker.Ok {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,215 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Operation histories
+
+A history is what clients asked for and what they got back, like the one Jepsen records: each operation
+is invoked, then completes with a result, fails without having taken effect, or is left unknown when
+the client timed out or got an error that doesn't say whether it happened. Invoking and completing are
+numbered in the order the history saw them, which is all the real-time order linearizability needs.
+History.RPC records the reads, writes, cases and adds clients send through a simulator cluster.
+*/
+
+type Status int
+
+const (
+	Ok      Status = iota
+	Fail           // definitely didn't take effect
+	Unknown        // may or may not have taken effect, and its result is lost
+)
+
+func (s Status) String() string {
+	switch s {
+	case Ok:
+		return "ok"
+	case Fail:
+		return "fail"
+	default:
+		return "unknown"
+	}
+}
+
+// Operations that never complete, or complete as Unknown, are treated as completing after everything else
+const never = math.MaxInt
+
+type Op struct {
+	ID     int
+	Client string
+	F      string          // read, write, cas or add
+	Key    string          // compacted JSON of the key, empty for the counter
+	Value  json.RawMessage // written, read (nil if the key didn't exist) or added value
+	From   json.RawMessage // cas only
+	To     json.RawMessage
+	Create bool // cas creates the key if it doesn't exist
+	Status Status
+	Code   int // error code of a failed operation
+
+	Invoke   int // position of the invocation in the history
+	Complete int // position of the completion, never if Unknown
+}
+
+func (op Op) String() string {
+	var s string
+	switch op.F {
+	case "cas":
+		s = fmt.Sprintf("%s cas %s %s->%s", op.Client, op.Key, op.From, op.To)
+	case "add":
+		s = fmt.Sprintf("%s add %s", op.Client, op.Value)
+	case "read":
+		s = strings.TrimSpace(fmt.Sprintf("%s read %s", op.Client, op.Key))
+		if op.Status == Ok {
+			value := string(op.Value)
+			if op.Value == nil {
+				value = "nil"
+			}
+			s += " -> " + value
+		}
+	default:
+		s = fmt.Sprintf("%s %s %s %s", op.Client, op.F, op.Key, op.Value)
+	}
+
+	end := "..."
+	if op.Complete != never {
+		end = fmt.Sprint(op.Complete)
+	}
+	return fmt.Sprintf("[%d, %s] %s (%s)", op.Invoke, end, s, op.Status)
+}
+
+type History struct {
+	mu  sync.Mutex
+	ops []Op
+	pos int
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+// Records the invocation of an operation, whose result Complete fills in, returning its ID
+func (h *History) Invoke(op Op) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pos++
+	op.ID = len(h.ops)
+	op.Invoke = h.pos
+	op.Complete = never
+	op.Status = Unknown
+	h.ops = append(h.ops, op)
+	return op.ID
+}
+
+// Records how the operation with the given ID completed, with the value a read returned
+// An Unknown operation stays open forever
+func (h *History) Complete(id int, status Status, value json.RawMessage, code int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	op := &h.ops[id]
+	op.Status = status
+	op.Code = code
+	if op.F == "read" {
+		op.Value = value
+	}
+	if status != Unknown {
+		h.pos++
+		op.Complete = h.pos
+	}
+}
+
+// Returns the operations recorded so far, in the order they were invoked
+func (h *History) Ops() []Op {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.ops)
+}
+
+type opRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	Value             json.RawMessage `json:"value"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists"`
+	Delta             json.RawMessage `json:"delta"`
+}
+
+type readResponseBody struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// Sends body from client to dest through the cluster like Cluster.RPC, recording the operation
+// A read of a missing key is recorded as reading nil, errors that say the operation didn't happen as Fail,
+// and any other error or a timeout as Unknown
+func (h *History) RPC(ctx context.Context, c *sim.Cluster, client, dest string, body any) (maelstrom.Message, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+	var req opRequestBody
+	if err := json.Unmarshal(buf, &req); err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	op := Op{Client: client, F: req.Type, Key: compact(req.Key)}
+	switch req.Type {
+	case "write":
+		op.Value = req.Value
+	case "cas":
+		op.From, op.To, op.Create = req.From, req.To, req.CreateIfNotExists
+	case "add":
+		op.Value = req.Delta
+	}
+	id := h.Invoke(op)
+
+	msg, err := c.RPC(ctx, client, dest, body)
+	switch code := maelstrom.ErrorCode(err); {
+	case err == nil:
+		var resp readResponseBody
+		json.Unmarshal(msg.Body, &resp)
+		h.Complete(id, Ok, resp.Value, 0)
+	case req.Type == "read" && code == maelstrom.KeyDoesNotExist:
+		h.Complete(id, Ok, nil, 0)
+	case definite(code):
+		h.Complete(id, Fail, nil, code)
+	default:
+		h.Complete(id, Unknown, nil, code)
+	}
+	return msg, err
+}
+
+// Returns whether an error code means the operation definitely didn't happen
+func definite(code int) bool {
+	switch code {
+	case maelstrom.NotSupported, maelstrom.TemporarilyUnavailable, maelstrom.MalformedRequest, maelstrom.Abort,
+		maelstrom.KeyDoesNotExist, maelstrom.KeyAlreadyExists, maelstrom.PreconditionFailed, maelstrom.TxnConflict:
+		return true
+	}
+	return false
+}
+
+// Returns JSON without insignificant whitespace, so equal values compare equal as strings
+func compact(raw json.RawMessage) string {
+	if raw == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Configuration
+
+Each module keeps its tunables in a struct whose fields are tagged with the environment variable setting
+them, and fills it in once at startup with Load, the values already in it being the defaults:
+
+	type Config struct {
+		Interval time.Duration `env:"BROADCAST_INTERVAL_MS" min:"1"`
+		Mode     string        `env:"COUNTER_MODE" oneof:"kv|crdt"`
+	}
+
+Fields can be strings, bools, ints, int64s, float64s, string slices (comma-separated) and durations,
+given in milliseconds if the variable's name ends in _MS and like "1.5s" otherwise. min bounds numbers and
+durations (in milliseconds or seconds, like the variable), oneof lists the values a string may take. A struct with a Validate
+method is checked with it afterwards, for rules spanning several fields. A value that doesn't parse or
+breaks a rule is an error naming the variable, so a misconfigured node fails at startup rather than
+quietly running on a default.
+
+Set overrides a variable, maelstrom-node's -set NAME=VALUE flags go through it. Every struct loaded is
+kept under its module's name, and the config RPC Register adds replies with all of them, each setting
+under its variable's name, so what a node actually runs with can be checked while it runs.
+*/
+
+var (
+	mu        sync.Mutex
+	overrides = map[string]string{}
+	loaded    = map[string]any{}
+)
+
+// Overrides the environment variable name for every Load from now on
+func Set(name, value string) {
+	mu.Lock()
+	defer mu.Unlock()
+	overrides[name] = value
+}
+
+// Returns the value of the variable name, an override taking precedence over the environment
+func lookup(name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if value, ok := overrides[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// Implemented by configs with rules spanning several fields
+type Validator interface {
+	Validate() error
+}
+
+// Fills in the tagged fields of the struct cfg points to from the environment, validates it and records
+// it as module's config
+func Load(module string, cfg any) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %s: %T isn't a pointer to a struct", module, cfg)
+	}
+	s := v.Elem()
+
+	for i := range s.NumField() {
+		field := s.Type().Field(i)
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+
+		raw := lookup(name)
+		if raw == "" {
+			continue
+		}
+		if err := parse(s.Field(i), field.Tag, name, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if validator, ok := cfg.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	loaded[module] = s.Interface()
+	return nil
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// Sets field to raw, the value of the variable name, checking field's rules
+func parse(field reflect.Value, tag reflect.StructTag, name string, raw string) error {
+	invalid := fmt.Errorf("invalid value %q", raw)
+
+	switch {
+	case field.Type() == durationType:
+		var d time.Duration
+		var n float64
+		if strings.HasSuffix(name, "_MS") {
+			ms, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return invalid
+			}
+			d, n = time.Duration(ms)*time.Millisecond, float64(ms)
+		} else {
+			var err error
+			if d, err = time.ParseDuration(raw); err != nil {
+				return invalid
+			}
+			n = d.Seconds()
+		}
+		if err := checkMin(tag, n); err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+
+	case field.Kind() == reflect.String:
+		if oneof := tag.Get("oneof"); oneof != "" && !slices.Contains(strings.Split(oneof, "|"), raw) {
+			return fmt.Errorf("%q isn't one of %s", raw, strings.ReplaceAll(oneof, "|", ", "))
+		}
+		field.SetString(raw)
+
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return invalid
+		}
+		field.SetBool(b)
+
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return invalid
+		}
+		if err := checkMin(tag, float64(n)); err != nil {
+			return err
+		}
+		field.SetInt(n)
+
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return invalid
+		}
+		if err := checkMin(tag, f); err != nil {
+			return err
+		}
+		field.SetFloat(f)
+
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+func checkMin(tag reflect.StructTag, n float64) error {
+	limit, ok := tag.Lookup("min")
+	if !ok {
+		return nil
+	}
+	bound, err := strconv.ParseFloat(limit, 64)
+	if err != nil {
+		return fmt.Errorf("invalid min tag %q", limit)
+	}
+	if n < bound {
+		return fmt.Errorf("%v is below the minimum of %s", n, limit)
+	}
+	return nil
+}
+
+// Returns every loaded config by module, each setting under its variable's name
+// Durations are in the variable's unit, milliseconds for _MS variables
+func Settings() map[string]map[string]any {
+	mu.Lock()
+	defer mu.Unlock()
+
+	settings := make(map[string]map[string]any)
+	for module, cfg := range loaded {
+		s := reflect.ValueOf(cfg)
+		values := make(map[string]any)
+
+		for i := range s.NumField() {
+			name := s.Type().Field(i).Tag.Get("env")
+			if name == "" {
+				continue
+			}
+
+			value := s.Field(i).Interface()
+			if d, ok := value.(time.Duration); ok {
+				if strings.HasSuffix(name, "_MS") {
+					value = d.Milliseconds()
+				} else {
+					value = d.String()
+				}
+			}
+			values[name] = value
+		}
+		settings[module] = values
+	}
+	return settings
+}
+
+// Checks value against the rules of the variable name in the configs loaded so far, and returns the module
+// whose config has it; rules spanning several fields are only checked when the config is next loaded
+func Check(name, value string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for module, cfg := range loaded {
+		t := reflect.TypeOf(cfg)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if field.Tag.Get("env") != name {
+				continue
+			}
+			if err := parse(reflect.New(field.Type).Elem(), field.Tag, name, value); err != nil {
+				return "", fmt.Errorf("%s: %w", name, err)
+			}
+			return module, nil
+		}
+	}
+	return "", fmt.Errorf("%s isn't a setting of any module", name)
+}
+
+// Returns the variables overridden with Set
+func Overrides() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(overrides)
+}
+
+type ConfigResponseBody struct {
+	Type   string                    `json:"type"`
+	Config map[string]map[string]any `json:"config"`
+}
+
+// Registers the config handler on n
+func Register(n *maelstrom.Node) error {
+	n.Handle("config", func(msg maelstrom.Message) error {
+		return n.Reply(msg, ConfigResponseBody{Type: "config_ok", Config: Settings()})
+	})
+	return nil
+}
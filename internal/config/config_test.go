@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Interval time.Duration `env:"TEST_INTERVAL_MS" min:"1"`
+	Timeout  time.Duration `env:"TEST_TIMEOUT"`
+	Mode     string        `env:"TEST_MODE" oneof:"a|b"`
+	Size     int           `env:"TEST_SIZE" min:"0"`
+	Fsync    bool          `env:"TEST_FSYNC"`
+	Nodes    []string      `env:"TEST_NODES"`
+	Unset    int           `env:"TEST_UNSET"`
+}
+
+type validatedConfig struct {
+	Low  int `env:"TEST_LOW"`
+	High int `env:"TEST_HIGH"`
+}
+
+func (c *validatedConfig) Validate() error {
+	if c.Low > c.High {
+		return errors.New("TEST_LOW is above TEST_HIGH")
+	}
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_INTERVAL_MS", "250")
+	t.Setenv("TEST_TIMEOUT", "1.5s")
+	t.Setenv("TEST_MODE", "b")
+	t.Setenv("TEST_SIZE", "3")
+	t.Setenv("TEST_FSYNC", "true")
+	t.Setenv("TEST_NODES", "n1, n2,")
+
+	cfg := testConfig{Mode: "a", Unset: 7}
+	if err := Load("test", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testConfig{
+		Interval: 250 * time.Millisecond,
+		Timeout:  1500 * time.Millisecond,
+		Mode:     "b",
+		Size:     3,
+		Fsync:    true,
+		Nodes:    []string{"n1", "n2"},
+		Unset:    7,
+	}
+	if cfg.Interval != want.Interval || cfg.Timeout != want.Timeout || cfg.Mode != want.Mode || cfg.Size != want.Size ||
+		cfg.Fsync != want.Fsync || !slices.Equal(cfg.Nodes, want.Nodes) || cfg.Unset != want.Unset {
+		t.Fatalf("loaded %+v, want %+v", cfg, want)
+	}
+
+	settings := Settings()["test"]
+	if settings["TEST_INTERVAL_MS"] != int64(250) || settings["TEST_TIMEOUT"] != "1.5s" || settings["TEST_MODE"] != "b" {
+		t.Errorf("settings %v", settings)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	for name, value := range map[string]string{
+		"TEST_INTERVAL_MS": "0",
+		"TEST_TIMEOUT":     "soon",
+		"TEST_MODE":        "c",
+		"TEST_SIZE":        "-1",
+		"TEST_FSYNC":       "maybe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+
+			var cfg testConfig
+			err := Load("test", &cfg)
+			if err == nil || !strings.HasPrefix(err.Error(), name+": ") {
+				t.Fatalf("error %v, want one naming %s", err, name)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("TEST_LOW", "5")
+	t.Setenv("TEST_HIGH", "2")
+
+	var cfg validatedConfig
+	if err := Load("validated", &cfg); err == nil {
+		t.Fatal("invalid config loaded")
+	}
+}
+
+func TestSetOverridesEnv(t *testing.T) {
+	t.Setenv("TEST_SIZE", "3")
+	Set("TEST_SIZE", "9")
+	defer func() {
+		mu.Lock()
+		delete(overrides, "TEST_SIZE")
+		mu.Unlock()
+	}()
+
+	var cfg testConfig
+	if err := Load("test", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Size != 9 {
+		t.Fatalf("size %d, want the override's 9", cfg.Size)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var cfg struct {
+		Level string `env:"TEST_CHECK_LEVEL" oneof:"low|high"`
+	}
+	if err := Load("checked", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if module, err := Check("TEST_CHECK_LEVEL", "low"); err != nil || module != "checked" {
+		t.Errorf("Check(TEST_CHECK_LEVEL, low) = %q, %v", module, err)
+	}
+	if _, err := Check("TEST_CHECK_LEVEL", "mid"); err == nil || !strings.Contains(err.Error(), "TEST_CHECK_LEVEL") {
+		t.Errorf("Check(TEST_CHECK_LEVEL, mid) = %v, want an error naming TEST_CHECK_LEVEL", err)
+	}
+	if _, err := Check("TEST_NOT_A_SETTING", "1"); err == nil {
+		t.Error("checked a variable no config has")
+	}
+}
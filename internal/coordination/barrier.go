@@ -0,0 +1,100 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Barriers
+
+A barrier holds its participants until parties of them have arrived, then lets them all through at once.
+It's kept at barrier/<name> as the number of parties, a generation and each participant waiting in it
+with its lease expiry. The arrival that makes up the numbers trips the barrier: it moves to the next
+generation, empty, so the same barrier can be used again for the next round. A participant waits until
+the generation it arrived in has passed.
+
+Like a permit, an arrival is a lease: a participant that crashed while waiting stops counting once its
+lease runs out, rather than tripping the barrier for the others with fewer of them actually there. A
+participant still waiting renews its arrival every time it polls, so it keeps counting however long it
+waits. Arriving again in the same generation only renews the arrival.
+
+The number of parties is set by the first arrival, and every later one must give the same while anyone
+is waiting. Once nobody is, after a trip or once every waiting arrival has expired, the next arrival may
+set a new number.
+*/
+
+// A barrier's record in the KV
+type Barrier struct {
+	Parties    int              `json:"parties"`
+	Generation int64            `json:"generation"`
+	Arrived    map[string]int64 `json:"arrived"` // each participant's lease expiry, Unix milliseconds
+}
+
+type Barriers struct {
+	kv    kvutil.KV
+	clock env.Clock
+}
+
+func barrierKey(name string) string {
+	return "barrier/" + name
+}
+
+// Records participant's arrival at name's barrier for ttl, and returns the generation it arrived in
+func (b *Barriers) Arrive(ctx context.Context, name string, parties int, participant string, ttl time.Duration) (int64, error) {
+	var generation int64
+	_, err := kvutil.Update(ctx, b.kv, barrierKey(name), Barrier{}, func(old Barrier) (Barrier, error) {
+		if len(live(old.Arrived, b.clock.Now())) > 0 && old.Parties != parties {
+			return old, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("barrier %s has %d parties", name, old.Parties))
+		}
+
+		generation = old.Generation
+		old.Parties = parties
+		return b.arrive(old, participant, ttl), nil
+	})
+	return generation, err
+}
+
+// Adds participant to a barrier's arrivals for ttl, tripping it if that makes up the numbers
+func (b *Barriers) arrive(old Barrier, participant string, ttl time.Duration) Barrier {
+	now := b.clock.Now()
+	arrived := live(old.Arrived, now)
+	arrived[participant] = now.Add(ttl).UnixMilli()
+
+	if len(arrived) >= old.Parties {
+		return Barrier{Parties: old.Parties, Generation: old.Generation + 1, Arrived: map[string]int64{}}
+	}
+	return Barrier{Parties: old.Parties, Generation: old.Generation, Arrived: arrived}
+}
+
+// Waits until name's barrier has moved past generation, or ctx is done
+// Each poll renews participant's arrival for ttl, so it can't expire while participant is still waiting
+func (b *Barriers) Await(ctx context.Context, name string, generation int64, participant string, ttl time.Duration) error {
+	backoff := minPoll
+	for {
+		barrier, err := kvutil.Update(ctx, b.kv, barrierKey(name), Barrier{}, func(old Barrier) (Barrier, error) {
+			if old.Generation != generation {
+				return old, kvutil.ErrNoChange
+			}
+			return b.arrive(old, participant, ttl), nil
+		})
+		if err != nil {
+			return err
+		}
+		if barrier.Generation > generation {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return maelstrom.NewRPCError(maelstrom.Timeout, fmt.Sprintf("barrier %s hasn't tripped", name))
+		case <-b.clock.After(backoff):
+		}
+		backoff = min(2*backoff, maxPoll)
+	}
+}
@@ -0,0 +1,184 @@
+package coordination
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func code(err error) int {
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	return -1
+}
+
+func TestSemaphore(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	s := &Semaphores{kv: fake.NewKV(), clock: clock}
+	ctx := context.Background()
+	ttl := time.Second
+
+	for _, holder := range []string{"c1", "c2"} {
+		if _, err := s.Acquire(ctx, "db", 2, holder, ttl, false); err != nil {
+			t.Fatalf("%s acquired: %v", holder, err)
+		}
+	}
+	if _, err := s.Acquire(ctx, "db", 2, "c3", ttl, false); code(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("third holder of 2 permits acquired: %v", err)
+	}
+	if _, err := s.Acquire(ctx, "db", 3, "c3", ttl, false); code(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("acquired with another limit: %v", err)
+	}
+
+	// Releasing hands the permit on, releasing it again does nothing
+	for range 2 {
+		if _, err := s.Release(ctx, "db", "c1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Acquire(ctx, "db", 2, "c3", ttl, false); err != nil {
+		t.Fatalf("acquired a released permit: %v", err)
+	}
+
+	// c2 crashes, c3 keeps renewing, and c2's permit goes to c4 once its lease runs out
+	clock.Advance(600 * time.Millisecond)
+	if _, err := s.Renew(ctx, "db", "c3", ttl); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(600 * time.Millisecond)
+	if _, err := s.Renew(ctx, "db", "c2", ttl); code(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("renewed an expired permit: %v", err)
+	}
+	sem, err := s.Acquire(ctx, "db", 2, "c4", ttl, false)
+	if err != nil {
+		t.Fatalf("acquired an expired permit: %v", err)
+	}
+	if _, ok := sem.Holders["c3"]; !ok || len(sem.Holders) != 2 {
+		t.Fatalf("holders %v, want c3 and c4", sem.Holders)
+	}
+
+	// Once every lease has run out the semaphore can be given a new limit
+	clock.Advance(2 * ttl)
+	if _, err := s.Acquire(ctx, "db", 3, "c5", ttl, false); err != nil {
+		t.Fatalf("acquired with a new limit once nobody held a permit: %v", err)
+	}
+}
+
+func TestSemaphoreWait(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	s := &Semaphores{kv: fake.NewKV(), clock: clock}
+	ctx := context.Background()
+
+	if _, err := s.Acquire(ctx, "db", 1, "c1", time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, err := s.Acquire(waitCtx, "db", 1, "c2", time.Second, true)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := s.Release(ctx, "db", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("waiting acquire: %v", err)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(shortCtx, "db", 1, "c3", time.Second, true); code(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("acquired a held permit: %v", err)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	b := &Barriers{kv: fake.NewKV(), clock: clock}
+	ctx := context.Background()
+	ttl := time.Second
+
+	// Three parties arrive, each waiting for the barrier to trip
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for _, p := range []string{"c1", "c2", "c3"} {
+		generation, err := b.Arrive(ctx, "start", 3, p, ttl)
+		if err != nil || generation != 0 {
+			t.Fatalf("%s arrived in generation %d, %v", p, generation, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			errs <- b.Await(waitCtx, "start", generation, p, ttl)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("awaited: %v", err)
+		}
+	}
+
+	// In the next round c1 arrives and crashes, so it no longer counts once its lease runs out
+	if _, err := b.Arrive(ctx, "start", 3, "c1", ttl); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * ttl)
+	for _, p := range []string{"c2", "c3"} {
+		if generation, err := b.Arrive(ctx, "start", 3, p, ttl); err != nil || generation != 1 {
+			t.Fatalf("%s arrived in generation %d, %v", p, generation, err)
+		}
+	}
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := b.Await(shortCtx, "start", 1, "c2", ttl); code(err) != maelstrom.Timeout {
+		t.Fatalf("barrier tripped with a crashed party: %v", err)
+	}
+
+	if _, err := b.Arrive(ctx, "start", 2, "c4", ttl); code(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("arrived with another number of parties: %v", err)
+	}
+
+	// Once every arrival has run out the barrier can be given a new number of parties, and a party that's
+	// waiting keeps renewing its arrival so it still counts after its ttl
+	clock.Advance(2 * ttl)
+	generation, err := b.Arrive(ctx, "start", 2, "c4", ttl)
+	if err != nil {
+		t.Fatalf("arrived with a new number of parties once nobody was waiting: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		done <- b.Await(waitCtx, "start", generation, "c4", ttl)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	clock.Advance(2 * ttl)
+	time.Sleep(2 * maxPoll)
+
+	if _, err := b.Arrive(ctx, "start", 2, "c5", ttl); err != nil {
+		t.Fatal(err)
+	}
+	barrier, err := kvutil.Read(ctx, b.kv, barrierKey("start"), Barrier{})
+	if err != nil || barrier.Generation != generation+1 {
+		t.Fatalf("barrier %+v, %v after the second party arrived, want it tripped", barrier, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("awaited: %v", err)
+	}
+}
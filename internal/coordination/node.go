@@ -0,0 +1,212 @@
+package coordination
+
+import (
+	"context"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Coordination recipes
+
+Goal: serve the building blocks clients use to coordinate with each other, counting semaphores
+(semaphore.go) and barriers (barrier.go), from records in Maelstrom's KV services, so no node keeps any
+state of its own and a client can go to any node.
+
+Everything a client holds is a lease of COORDINATION_LEASE_MS (default 10000), or the ttl_ms it asks for,
+so a client that crashes holding a permit or waiting at a barrier doesn't block the others for good. Each
+record lives in COORDINATION_KV, lin-kv (default) or seq-kv; with seq-kv every change is still a
+compare-and-swap, but a node may read a stale record and wait a little longer than it had to.
+
+Clients are identified by the holder or participant they name, by the message's source if they don't.
+semaphore_acquire and barrier_arrive wait up to wait_ms for a permit or for the barrier to trip, answering
+temporarily-unavailable or timeout when they run out.
+*/
+
+// How often a waiting request checks the record again, backing off from minPoll to maxPoll
+const (
+	minPoll = 10 * time.Millisecond
+	maxPoll = 200 * time.Millisecond
+)
+
+// How long a barrier_arrive waits for the barrier to trip if it doesn't say
+const defaultBarrierWait = 5 * time.Second
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// COORDINATION_KV, where the records are kept, lin-kv (default) or seq-kv
+	KV string `env:"COORDINATION_KV" oneof:"lin-kv|seq-kv"`
+
+	// COORDINATION_LEASE_MS, how long a permit or an arrival lasts without being renewed (default 10000)
+	Lease time.Duration `env:"COORDINATION_LEASE_MS" min:"1"`
+}
+
+// Semaphore acquire RPC
+type AcquireRequestBody struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Limit  int    `json:"limit"`
+	Holder string `json:"holder,omitempty"`
+	TTLMs  int64  `json:"ttl_ms,omitempty"`
+	WaitMs int64  `json:"wait_ms,omitempty"` // 0 to fail straight away while every permit is held
+}
+
+type AcquireResponseBody struct {
+	Type      string `json:"type"`
+	Holders   int    `json:"holders"`
+	ExpiresMs int64  `json:"expires_ms"`
+}
+
+// Semaphore renew RPC
+type RenewRequestBody struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Holder string `json:"holder,omitempty"`
+	TTLMs  int64  `json:"ttl_ms,omitempty"`
+}
+
+type RenewResponseBody struct {
+	Type      string `json:"type"`
+	ExpiresMs int64  `json:"expires_ms"`
+}
+
+// Semaphore release RPC
+type ReleaseRequestBody struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Holder string `json:"holder,omitempty"`
+}
+
+type ReleaseResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Barrier arrive RPC
+type ArriveRequestBody struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Parties     int    `json:"parties"`
+	Participant string `json:"participant,omitempty"`
+	TTLMs       int64  `json:"ttl_ms,omitempty"`
+	WaitMs      int64  `json:"wait_ms,omitempty"`
+}
+
+type ArriveResponseBody struct {
+	Type       string `json:"type"`
+	Generation int64  `json:"generation"` // the generation arrived in, which has now tripped
+}
+
+// Registers the semaphore and barrier handlers on n
+func Register(n *maelstrom.Node) error {
+	cfg := Config{KV: "lin-kv", Lease: 10 * time.Second}
+	if err := config.Load("coordination", &cfg); err != nil {
+		return err
+	}
+
+	var kv kvutil.KV = maelstrom.NewLinKV(n)
+	if cfg.KV == "seq-kv" {
+		kv = maelstrom.NewSeqKV(n)
+	}
+	clock := env.Of(n).Clock
+	semaphores := &Semaphores{kv: kv, clock: clock}
+	barriers := &Barriers{kv: kv, clock: clock}
+	ctx := context.Background()
+
+	registry := metrics.Of(n)
+	acquired := registry.Counter("coordination.acquired")
+	refused := registry.Counter("coordination.refused")
+	tripped := registry.Counter("coordination.tripped")
+
+	ttl := func(ms int64) time.Duration {
+		if ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		return cfg.Lease
+	}
+	client := func(named string, msg maelstrom.Message) string {
+		if named != "" {
+			return named
+		}
+		return msg.Src
+	}
+
+	handler.Handle(n, "semaphore_acquire", func(msg maelstrom.Message, body AcquireRequestBody) (AcquireResponseBody, error) {
+		if body.Name == "" || body.Limit < 1 {
+			return AcquireResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, "a semaphore needs a name and a limit of at least 1")
+		}
+		holder := client(body.Holder, msg)
+
+		acquireCtx, cancel := clock.WithTimeout(ctx, time.Second+time.Duration(body.WaitMs)*time.Millisecond)
+		defer cancel()
+
+		sem, err := semaphores.Acquire(acquireCtx, body.Name, body.Limit, holder, ttl(body.TTLMs), body.WaitMs > 0)
+		if err != nil {
+			refused.Inc()
+			return AcquireResponseBody{}, err
+		}
+		acquired.Inc()
+		return AcquireResponseBody{Holders: len(sem.Holders), ExpiresMs: sem.Holders[holder]}, nil
+	})
+
+	handler.Handle(n, "semaphore_renew", func(msg maelstrom.Message, body RenewRequestBody) (RenewResponseBody, error) {
+		holder := client(body.Holder, msg)
+
+		renewCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		sem, err := semaphores.Renew(renewCtx, body.Name, holder, ttl(body.TTLMs))
+		if err != nil {
+			return RenewResponseBody{}, err
+		}
+		return RenewResponseBody{ExpiresMs: sem.Holders[holder]}, nil
+	})
+
+	handler.Handle(n, "semaphore_release", func(msg maelstrom.Message, body ReleaseRequestBody) (ReleaseResponseBody, error) {
+		releaseCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		_, err := semaphores.Release(releaseCtx, body.Name, client(body.Holder, msg))
+		return ReleaseResponseBody{}, err
+	})
+
+	handler.Handle(n, "barrier_arrive", func(msg maelstrom.Message, body ArriveRequestBody) (ArriveResponseBody, error) {
+		if body.Name == "" || body.Parties < 1 {
+			return ArriveResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, "a barrier needs a name and at least 1 party")
+		}
+		wait := defaultBarrierWait
+		if body.WaitMs > 0 {
+			wait = time.Duration(body.WaitMs) * time.Millisecond
+		}
+
+		arriveCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		participant, lease := client(body.Participant, msg), ttl(body.TTLMs)
+		generation, err := barriers.Arrive(arriveCtx, body.Name, body.Parties, participant, lease)
+		if err != nil {
+			return ArriveResponseBody{}, err
+		}
+
+		awaitCtx, cancel := clock.WithTimeout(ctx, wait)
+		defer cancel()
+
+		if err := barriers.Await(awaitCtx, body.Name, generation, participant, lease); err != nil {
+			return ArriveResponseBody{}, err
+		}
+		tripped.Inc()
+		return ArriveResponseBody{Generation: generation}, nil
+	})
+
+	admin.Of(n).Register("coordination", admin.Module{Status: func() any {
+		return map[string]any{"kv": cfg.KV, "lease_ms": cfg.Lease.Milliseconds()}
+	}})
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package coordination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Counting semaphores
+
+A semaphore lets up to limit holders in at once. It's kept at semaphore/<name> as its limit and each
+holder's lease expiry, and every change is a compare-and-swap on that record, so any node can serve any
+request. A permit is a lease: it lasts ttl unless renewed, and expired holders are dropped the next time
+the record changes, so a client that crashed while holding a permit gives it back once its lease runs out.
+
+The limit is set by the first acquire and every later one must ask for the same, a semaphore doesn't
+change size while it has holders. Once the last lease is gone the next acquire may set a new limit.
+Acquiring a permit already held renews it.
+*/
+
+// A semaphore's record in the KV
+type Semaphore struct {
+	Limit   int              `json:"limit"`
+	Holders map[string]int64 `json:"holders"` // each holder's lease expiry, Unix milliseconds
+}
+
+// Returned while every permit is held
+var errFull = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "every permit is held")
+
+type Semaphores struct {
+	kv    kvutil.KV
+	clock env.Clock
+}
+
+func semaphoreKey(name string) string {
+	return "semaphore/" + name
+}
+
+// Returns the holders whose leases haven't expired by now
+func live(holders map[string]int64, now time.Time) map[string]int64 {
+	alive := make(map[string]int64)
+	for holder, expires := range holders {
+		if expires > now.UnixMilli() {
+			alive[holder] = expires
+		}
+	}
+	return alive
+}
+
+// Gives holder a permit of name's semaphore, or renews the one it holds, for ttl
+// Fails with errFull while every permit is held, waiting for one until ctx is done if wait is set
+func (s *Semaphores) Acquire(ctx context.Context, name string, limit int, holder string, ttl time.Duration, wait bool) (Semaphore, error) {
+	backoff := minPoll
+	for {
+		sem, err := kvutil.Update(ctx, s.kv, semaphoreKey(name), Semaphore{}, func(old Semaphore) (Semaphore, error) {
+			now := s.clock.Now()
+			holders := live(old.Holders, now)
+			if len(holders) > 0 && old.Limit != limit {
+				return old, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("semaphore %s has a limit of %d", name, old.Limit))
+			}
+			if _, held := holders[holder]; !held && len(holders) >= limit {
+				return old, errFull
+			}
+			holders[holder] = now.Add(ttl).UnixMilli()
+			return Semaphore{Limit: limit, Holders: holders}, nil
+		})
+		if !wait || !errors.Is(err, errFull) {
+			return sem, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return sem, err
+		case <-s.clock.After(backoff):
+		}
+		backoff = min(2*backoff, maxPoll)
+	}
+}
+
+// Extends the lease on holder's permit by ttl, fails if it's no longer held
+func (s *Semaphores) Renew(ctx context.Context, name string, holder string, ttl time.Duration) (Semaphore, error) {
+	return kvutil.Update(ctx, s.kv, semaphoreKey(name), Semaphore{}, func(old Semaphore) (Semaphore, error) {
+		now := s.clock.Now()
+		holders := live(old.Holders, now)
+		if _, held := holders[holder]; !held {
+			return old, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("%s doesn't hold a permit of %s", holder, name))
+		}
+		holders[holder] = now.Add(ttl).UnixMilli()
+		return Semaphore{Limit: old.Limit, Holders: holders}, nil
+	})
+}
+
+// Gives back holder's permit, releasing one that isn't held does nothing
+func (s *Semaphores) Release(ctx context.Context, name string, holder string) (Semaphore, error) {
+	return kvutil.Update(ctx, s.kv, semaphoreKey(name), Semaphore{}, func(old Semaphore) (Semaphore, error) {
+		if _, held := old.Holders[holder]; !held {
+			return old, kvutil.ErrNoChange
+		}
+		holders := maps.Clone(old.Holders)
+		delete(holders, holder)
+		return Semaphore{Limit: old.Limit, Holders: live(holders, s.clock.Now())}, nil
+	})
+}
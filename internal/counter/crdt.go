@@ -0,0 +1,136 @@
+package counter
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/crdt"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+CRDT mode
+
+With COUNTER_MODE=crdt the counter doesn't touch seq-kv: every node keeps a grow-only counter with
+one total per node, only ever adding to its own. Two states merge by taking the larger total for
+each node, so the gossip engine can spread them in any order. Reads are local and only eventually
+include adds made on other nodes. The state is saved after every add (see internal/storage) and picked
+up again on init, so a restarted node doesn't forget its own total. In the default mode the count lives
+in seq-kv rather than on the node, so only CRDT mode has a part in node snapshots (see internal/snapshot).
+*/
+
+// Storage key the counter's state is saved under
+const stateKey = "counter.crdt"
+
+func runCRDT(n *maelstrom.Node) {
+	counter := crdt.NewGCounter()
+
+	engine := gossip.New(n, gossip.Store[crdt.GCounterState](counter), gossip.Config{
+		Name:        "counter",
+		Interval:    100 * time.Millisecond,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+
+	// Saved after every add, one at a time so an older state never overwrites a newer one
+	var saveMu sync.Mutex
+	var store storage.Store
+
+	// Pick up the state saved before a restart, then gossip with every other node
+	n.Handle("init", func(msg maelstrom.Message) error {
+		s, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+
+		data, err := s.Get(stateKey)
+		if err == nil {
+			var state crdt.GCounterState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			counter.Merge(state)
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		saveMu.Lock()
+		store = s
+		saveMu.Unlock()
+
+		engine.SetPeers(slices.DeleteFunc(slices.Clone(n.NodeIDs()), func(id string) bool {
+			return id == n.ID()
+		}))
+		return nil
+	})
+
+	// Must be called with saveMu held
+	save := func() error {
+		if store == nil {
+			return nil
+		}
+		data, err := json.Marshal(counter.Full())
+		if err != nil {
+			return err
+		}
+		return store.Put(stateKey, data)
+	}
+
+	handler.Handle(n, "add", func(msg maelstrom.Message, body AddRequestBody) (AddResponseBody, error) {
+		saveMu.Lock()
+		defer saveMu.Unlock()
+
+		delta, err := counter.Increment(n.ID(), body.Delta)
+		if err != nil {
+			return AddResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		if err := save(); err != nil {
+			return AddResponseBody{}, err
+		}
+
+		engine.Update(delta)
+		return AddResponseBody{}, nil
+	})
+
+	// Snapshots hold every node's total, restoring one merges them in and gossips them on
+	snapshot.Of(n).Provide("counter", func() (any, error) {
+		return counter.Full(), nil
+	}, func(data json.RawMessage) error {
+		var state crdt.GCounterState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+
+		saveMu.Lock()
+		defer saveMu.Unlock()
+
+		counter.Merge(state)
+		if err := save(); err != nil {
+			return err
+		}
+		engine.Update(state)
+		return nil
+	})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		return ReadResponseBody{
+			Value: counter.Value(),
+		}, nil
+	})
+
+	admin.Of(n).Register("counter", admin.Module{Status: func() any {
+		return map[string]any{"mode": "crdt", "value": counter.Value()}
+	}})
+
+	lifecycle.Of(n).Go(engine.Run)
+}
@@ -0,0 +1,108 @@
+package counter
+
+/*
+Challenge #4: Grow-Only Counter
+
+Goal: implement a stateless, sequentially-consistent global counter
+
+Set COUNTER_MODE=crdt to gossip a grow-only counter between nodes instead of using seq-kv, or
+COUNTER_KV=local to keep using a KV but one run by the nodes themselves (see internal/seqkv)
+*/
+
+import (
+	"context"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/seqkv"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// COUNTER_MODE, kv (default) or crdt
+	Mode string `env:"COUNTER_MODE" oneof:"kv|crdt"`
+
+	// COUNTER_KV, the KV used in kv mode, seq-kv (default) or local
+	KV string `env:"COUNTER_KV" oneof:"seq-kv|local"`
+}
+
+type AddRequestBody struct {
+	Type  string `json:"type"`
+	Delta int    `json:"delta"`
+}
+
+type AddResponseBody struct {
+	Type string `json:"type"`
+}
+
+type ReadRequestBody struct {
+	Type string `json:"type"`
+}
+
+type ReadResponseBody struct {
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+}
+
+// Registers the counter handlers on n, backed by seq-kv or a CRDT depending on COUNTER_MODE
+func Register(n *maelstrom.Node) error {
+
+	cfg := Config{Mode: "kv", KV: "seq-kv"}
+	if err := config.Load("counter", &cfg); err != nil {
+		return err
+	}
+
+	if cfg.Mode == "crdt" {
+		runCRDT(n)
+	} else {
+		runKV(n, cfg.KV)
+	}
+
+	return nil
+}
+
+func runKV(n *maelstrom.Node, backend string) {
+	var kv kvutil.KV = maelstrom.NewSeqKV(n)
+	if backend == "local" {
+		kv = seqkv.New(n)
+	}
+
+	ctx := context.Background()
+
+	// The count lives in the KV, there's nothing on the node to report
+	admin.Of(n).Register("counter", admin.Module{Status: func() any {
+		return map[string]any{"mode": "kv", "kv": backend}
+	}})
+
+	handler.Handle(n, "add", func(msg maelstrom.Message, body AddRequestBody) (AddResponseBody, error) {
+		// Add to the current value (0 if it doesn't exist yet), retrying if another node wrote it meanwhile
+		_, err := kvutil.Update(ctx, kv, "global_total", 0, func(oldValue int) (int, error) {
+			return oldValue + body.Delta, nil
+		})
+
+		if err != nil {
+			return AddResponseBody{}, err
+		}
+
+		return AddResponseBody{
+			Type: "add_ok",
+		}, nil
+	})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		// If key doesn't exist, value is 0
+		value, err := kvutil.Read(ctx, kv, "global_total", 0)
+
+		if err != nil {
+			return ReadResponseBody{}, err
+		}
+
+		return ReadResponseBody{
+			Type:  "read_ok",
+			Value: value,
+		}, nil
+	})
+}
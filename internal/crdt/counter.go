@@ -0,0 +1,184 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"sync"
+)
+
+var ErrNegativeIncrement = errors.New("crdt: grow-only counter can't be decremented")
+
+// Grow-only counter, one total per replica that only that replica adds to
+type GCounter struct {
+	mu     sync.Mutex
+	totals GCounterState
+}
+
+// Total of every replica of a grow-only counter
+type GCounterState map[string]int
+
+func NewGCounter() *GCounter {
+	return &GCounter{totals: GCounterState{}}
+}
+
+// Adds a non-negative delta to replica's total and returns the delta carrying its new total
+func (c *GCounter) Increment(replica string, delta int) (GCounterState, error) {
+	if delta < 0 {
+		return nil, ErrNegativeIncrement
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.totals[replica] += delta
+	return GCounterState{replica: c.totals[replica]}, nil
+}
+
+// Returns the sum of every replica's total
+func (c *GCounter) Value() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totals.Value()
+}
+
+func (c *GCounter) Merge(delta GCounterState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for replica, total := range delta {
+		c.totals[replica] = max(c.totals[replica], total)
+	}
+}
+
+// Keeps the larger total of every replica
+func (c *GCounter) Join(a, b GCounterState) GCounterState {
+	return a.join(b)
+}
+
+func (c *GCounter) Full() GCounterState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.totals)
+}
+
+func (c *GCounter) Digest() uint64 {
+	return digest(c.Full())
+}
+
+func (c *GCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Full())
+}
+
+func (c *GCounter) UnmarshalJSON(data []byte) error {
+	var state GCounterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.totals = GCounterState{}
+	c.mu.Unlock()
+
+	c.Merge(state)
+	return nil
+}
+
+func (s GCounterState) Value() int {
+	value := 0
+	for _, total := range s {
+		value += total
+	}
+	return value
+}
+
+func (s GCounterState) join(other GCounterState) GCounterState {
+	joined := GCounterState{}
+	for replica, total := range s {
+		joined[replica] = total
+	}
+	for replica, total := range other {
+		joined[replica] = max(joined[replica], total)
+	}
+	return joined
+}
+
+// Counter that can also be decremented, a pair of grow-only counters for increments and decrements
+type PNCounter struct {
+	mu    sync.Mutex
+	state PNCounterState
+}
+
+type PNCounterState struct {
+	P GCounterState `json:"p"`
+	N GCounterState `json:"n"`
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{state: PNCounterState{P: GCounterState{}, N: GCounterState{}}}
+}
+
+// Adds delta, which may be negative, to replica's totals and returns the delta carrying the one changed
+func (c *PNCounter) Add(replica string, delta int) PNCounterState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if delta >= 0 {
+		c.state.P[replica] += delta
+		return PNCounterState{P: GCounterState{replica: c.state.P[replica]}}
+	}
+
+	c.state.N[replica] -= delta
+	return PNCounterState{N: GCounterState{replica: c.state.N[replica]}}
+}
+
+// Returns the increments minus the decrements
+func (c *PNCounter) Value() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.P.Value() - c.state.N.Value()
+}
+
+func (c *PNCounter) Merge(delta PNCounterState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for replica, total := range delta.P {
+		c.state.P[replica] = max(c.state.P[replica], total)
+	}
+	for replica, total := range delta.N {
+		c.state.N[replica] = max(c.state.N[replica], total)
+	}
+}
+
+func (c *PNCounter) Join(a, b PNCounterState) PNCounterState {
+	return PNCounterState{P: a.P.join(b.P), N: a.N.join(b.N)}
+}
+
+func (c *PNCounter) Full() PNCounterState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return PNCounterState{P: maps.Clone(c.state.P), N: maps.Clone(c.state.N)}
+}
+
+func (c *PNCounter) Digest() uint64 {
+	return digest(c.Full())
+}
+
+func (c *PNCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Full())
+}
+
+func (c *PNCounter) UnmarshalJSON(data []byte) error {
+	var state PNCounterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.state = PNCounterState{P: GCounterState{}, N: GCounterState{}}
+	c.mu.Unlock()
+
+	c.Merge(state)
+	return nil
+}
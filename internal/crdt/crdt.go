@@ -0,0 +1,32 @@
+package crdt
+
+import (
+	"encoding/json"
+	"hash/fnv"
+)
+
+/*
+CRDTs
+
+Conflict-free replicated data types: states that replicas change independently and merge in any
+order, any number of times, always ending up the same. Every type here is safe for concurrent use and
+has a plain state type that serves both as its JSON form and as a delta: the mutators return a delta
+holding just their change, which is merged on other replicas instead of the whole state.
+
+Each type has the Merge, Join, Full and Digest methods of a gossip.Store, so it can be handed to a
+gossip engine as is, with its mutators' deltas given to the engine's Update.
+*/
+
+// Hashes the JSON form of a state, which is the same for equal states since map keys are sorted
+// and every state type lists its elements in order. A state that can't be marshalled can't be
+// gossiped either, so it hashes to 0
+func digest(state any) uint64 {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,375 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type state[D any] interface {
+	Merge(delta D)
+	Join(a, b D) D
+	Full() D
+	Digest() uint64
+}
+
+func marshal(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Checks that merging deltas in any order, any number of times, or joined in any grouping first, all
+// give the same state and digest
+func checkConvergence[D any, S state[D]](t *testing.T, rng *rand.Rand, fresh func() S, deltas []D) {
+	t.Helper()
+
+	want := fresh()
+	for _, delta := range deltas {
+		want.Merge(delta)
+	}
+
+	// Commutativity and idempotence: a shuffled order with repeats
+	shuffled := fresh()
+	order := append(rng.Perm(len(deltas)), rng.Perm(len(deltas))[:len(deltas)/2]...)
+	rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	for _, i := range order {
+		shuffled.Merge(deltas[i])
+	}
+
+	// Associativity: deltas joined pairwise in a random grouping, then merged at once
+	joins := slices.Clone(deltas)
+	for len(joins) > 1 {
+		i := rng.Intn(len(joins) - 1)
+		joins = slices.Replace(joins, i, i+2, shuffled.Join(joins[i+1], joins[i]))
+	}
+	joined := fresh()
+	for _, delta := range joins {
+		joined.Merge(delta)
+	}
+
+	for name, got := range map[string]S{"shuffled": shuffled, "joined": joined} {
+		if marshal(t, got.Full()) != marshal(t, want.Full()) {
+			t.Errorf("%s state = %s, want %s", name, marshal(t, got.Full()), marshal(t, want.Full()))
+		}
+		if got.Digest() != want.Digest() {
+			t.Errorf("%s digest differs from in-order merge", name)
+		}
+	}
+}
+
+func TestGSetConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 100; trial++ {
+		s := NewGSet[int]()
+		var deltas []GSetState[int]
+		for i := 0; i < 20; i++ {
+			deltas = append(deltas, s.Add(rng.Intn(30)))
+		}
+		checkConvergence(t, rng, NewGSet[int], deltas)
+	}
+}
+
+func TestGCounterConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	replicas := []string{"n0", "n1", "n2"}
+	for trial := 0; trial < 100; trial++ {
+		counters := []*GCounter{NewGCounter(), NewGCounter(), NewGCounter()}
+		var deltas []GCounterState
+		want := 0
+		for i := 0; i < 20; i++ {
+			r := rng.Intn(len(replicas))
+			delta := rng.Intn(10)
+			want += delta
+
+			d, err := counters[r].Increment(replicas[r], delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deltas = append(deltas, d)
+		}
+		checkConvergence(t, rng, NewGCounter, deltas)
+
+		merged := NewGCounter()
+		for _, delta := range deltas {
+			merged.Merge(delta)
+		}
+		if merged.Value() != want {
+			t.Fatalf("value = %d, want %d", merged.Value(), want)
+		}
+	}
+}
+
+func TestGCounterRejectsDecrement(t *testing.T) {
+	if _, err := NewGCounter().Increment("n0", -1); !errors.Is(err, ErrNegativeIncrement) {
+		t.Errorf("err = %v, want ErrNegativeIncrement", err)
+	}
+}
+
+func TestPNCounterConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	replicas := []string{"n0", "n1", "n2"}
+	for trial := 0; trial < 100; trial++ {
+		counters := []*PNCounter{NewPNCounter(), NewPNCounter(), NewPNCounter()}
+		var deltas []PNCounterState
+		want := 0
+		for i := 0; i < 20; i++ {
+			r := rng.Intn(len(replicas))
+			delta := rng.Intn(21) - 10
+			want += delta
+			deltas = append(deltas, counters[r].Add(replicas[r], delta))
+		}
+		checkConvergence(t, rng, NewPNCounter, deltas)
+
+		merged := NewPNCounter()
+		for _, delta := range deltas {
+			merged.Merge(delta)
+		}
+		if merged.Value() != want {
+			t.Fatalf("value = %d, want %d", merged.Value(), want)
+		}
+	}
+}
+
+func TestORSetConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	replicas := []string{"n0", "n1", "n2"}
+	for trial := 0; trial < 100; trial++ {
+		sets := []*ORSet[string]{NewORSet[string](), NewORSet[string](), NewORSet[string]()}
+		var deltas []ORSetState[string]
+		for i := 0; i < 30; i++ {
+			r := rng.Intn(len(replicas))
+			x := string(rune('a' + rng.Intn(5)))
+
+			var delta ORSetState[string]
+			if rng.Intn(3) == 0 {
+				delta = sets[r].Remove(x)
+			} else {
+				delta = sets[r].Add(replicas[r], x)
+			}
+			deltas = append(deltas, delta)
+
+			// Deliver it to a random other replica, so removes see remote adds
+			sets[(r+1+rng.Intn(2))%3].Merge(delta)
+		}
+		checkConvergence(t, rng, NewORSet[string], deltas)
+	}
+}
+
+func TestORSetConcurrentAddWins(t *testing.T) {
+	a, b := NewORSet[string](), NewORSet[string]()
+	b.Merge(a.Add("n0", "x"))
+
+	// n1 removes the add it has seen while n0 adds x again
+	removed := b.Remove("x")
+	added := a.Add("n0", "x")
+	a.Merge(removed)
+	b.Merge(added)
+
+	if !a.Contains("x") || !b.Contains("x") {
+		t.Errorf("contains x = %v, %v, want the concurrent add to win", a.Contains("x"), b.Contains("x"))
+	}
+
+	a.Merge(a.Remove("x"))
+	if a.Contains("x") {
+		t.Errorf("x still present after removing every add of it")
+	}
+}
+
+func TestLWWRegisterConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	replicas := []string{"n0", "n1", "n2"}
+	for trial := 0; trial < 100; trial++ {
+		registers := []*LWWRegister[int]{NewLWWRegister[int](), NewLWWRegister[int](), NewLWWRegister[int]()}
+		var deltas []LWWRegisterState[int]
+		for i := 0; i < 20; i++ {
+			r := rng.Intn(len(replicas))
+			deltas = append(deltas, registers[r].Set(replicas[r], rng.Intn(100), int64(rng.Intn(10))))
+		}
+		checkConvergence(t, rng, NewLWWRegister[int], deltas)
+	}
+}
+
+func TestLWWRegisterTieBreaksByReplica(t *testing.T) {
+	a, b := NewLWWRegister[string](), NewLWWRegister[string]()
+	fromA := a.Set("n0", "a", 5)
+	fromB := b.Set("n1", "b", 5)
+	a.Merge(fromB)
+	b.Merge(fromA)
+
+	for _, r := range []*LWWRegister[string]{a, b} {
+		if value, _ := r.Get(); value != "b" {
+			t.Errorf("value = %q, want the higher replica's write to win", value)
+		}
+	}
+
+	if _, ok := NewLWWRegister[string]().Get(); ok {
+		t.Errorf("a new register reports a value")
+	}
+}
+
+func TestRGAConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	replicas := []string{"n0", "n1", "n2"}
+	for trial := 0; trial < 100; trial++ {
+		sequences := []*RGA[string]{NewRGA[string](), NewRGA[string](), NewRGA[string]()}
+		var deltas []RGAState[string]
+		for i := 0; i < 30; i++ {
+			r := rng.Intn(len(replicas))
+			length := sequences[r].Len()
+
+			var delta RGAState[string]
+			var err error
+			if length > 0 && rng.Intn(3) == 0 {
+				delta, err = sequences[r].Delete(replicas[r], rng.Intn(length), 1)
+			} else {
+				delta, err = sequences[r].Insert(replicas[r], rng.Intn(length+1), string(rune('a'+rng.Intn(26))))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			deltas = append(deltas, delta)
+
+			// Deliver it to a random other replica, so inserts follow remote elements
+			sequences[(r+1+rng.Intn(2))%3].Merge(delta)
+		}
+		checkConvergence(t, rng, NewRGA[string], deltas)
+
+		// The elements agree too, however the deltas arrived
+		want := NewRGA[string]()
+		shuffled := NewRGA[string]()
+		for i, j := range rng.Perm(len(deltas)) {
+			want.Merge(deltas[i])
+			shuffled.Merge(deltas[j])
+		}
+		if got, want := shuffled.Elements(), want.Elements(); !slices.Equal(got, want) {
+			t.Fatalf("elements = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRGAConcurrentInserts(t *testing.T) {
+	a, b := NewRGA[string](), NewRGA[string]()
+	typed, _ := a.Insert("n0", 0, "a", "c")
+	b.Merge(typed)
+
+	// Both insert between a and c, and n1 types two characters
+	fromA, _ := a.Insert("n0", 1, "x")
+	fromB, _ := b.Insert("n1", 1, "y", "z")
+	a.Merge(fromB)
+	b.Merge(fromA)
+
+	// n1's run stays together, ahead of n0's insert since its ID is newer
+	for _, s := range []*RGA[string]{a, b} {
+		if got := strings.Join(s.Elements(), ""); got != "ayzxc" {
+			t.Errorf("text = %q, want %q", got, "ayzxc")
+		}
+	}
+
+	deleted, err := a.Delete("n0", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Merge(deleted)
+	if got := strings.Join(b.Elements(), ""); got != "ac" {
+		t.Errorf("text after delete = %q, want %q", got, "ac")
+	}
+
+	if _, err := a.Insert("n0", 3, "q"); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("insert past the end: err = %v, want ErrOutOfRange", err)
+	}
+	if _, err := a.Delete("n0", 1, 2); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("delete past the end: err = %v, want ErrOutOfRange", err)
+	}
+}
+
+func TestRGAOutOfOrderDelivery(t *testing.T) {
+	a := NewRGA[string]()
+	first, _ := a.Insert("n0", 0, "a")
+	second, _ := a.Insert("n0", 1, "b")
+	removed, _ := a.Delete("n0", 0, 1)
+
+	// The delete and the insert after a arrive before a itself
+	b := NewRGA[string]()
+	b.Merge(removed)
+	b.Merge(second)
+	if got := b.Elements(); len(got) != 0 {
+		t.Errorf("elements = %v before their origin arrived, want none", got)
+	}
+	b.Merge(first)
+	if got := strings.Join(b.Elements(), ""); got != "b" {
+		t.Errorf("text = %q, want %q", got, "b")
+	}
+
+	// The log lists every op after the ones it refers to
+	log := b.Log()
+	if len(log) != 3 || log[0].Op != "insert" || log[1].Op != "insert" || log[2].Op != "delete" {
+		t.Errorf("log = %s", marshal(t, log))
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	gset := NewGSet[int]()
+	gset.Add(3)
+	gset.Add(1)
+
+	gcounter := NewGCounter()
+	gcounter.Increment("n0", 4)
+
+	pncounter := NewPNCounter()
+	pncounter.Add("n0", 4)
+	pncounter.Add("n1", -6)
+
+	orset := NewORSet[string]()
+	orset.Add("n0", "x")
+	orset.Remove("x")
+	orset.Add("n0", "y")
+
+	lww := NewLWWRegister[string]()
+	lww.Set("n0", "v", 7)
+
+	rga := NewRGA[string]()
+	rga.Insert("n0", 0, "a", "b")
+	rga.Delete("n0", 0, 1)
+
+	for _, pair := range [][2]json.Marshaler{
+		{gset, NewGSet[int]()},
+		{gcounter, NewGCounter()},
+		{pncounter, NewPNCounter()},
+		{orset, NewORSet[string]()},
+		{lww, NewLWWRegister[string]()},
+		{rga, NewRGA[string]()},
+	} {
+		data := marshal(t, pair[0])
+		if err := json.Unmarshal([]byte(data), pair[1]); err != nil {
+			t.Fatal(err)
+		}
+		if got := marshal(t, pair[1]); got != data {
+			t.Errorf("round trip = %s, want %s", got, data)
+		}
+	}
+}
+
+func TestORSetRestoredTagsAreNew(t *testing.T) {
+	s := NewORSet[string]()
+	s.Add("n0", "x")
+	s.Add("n0", "y")
+
+	restored := NewORSet[string]()
+	if err := json.Unmarshal([]byte(marshal(t, s)), restored); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tag reused after a restart would be hidden by the remove of the old add
+	s.Merge(restored.Remove("x"))
+	s.Merge(restored.Add("n0", "x"))
+	if !s.Contains("x") {
+		t.Errorf("x added after restoring is missing")
+	}
+}
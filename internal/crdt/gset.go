@@ -0,0 +1,88 @@
+package crdt
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Grow-only set, elements can be added but never removed
+type GSet[T cmp.Ordered] struct {
+	mu       sync.Mutex
+	elements map[T]struct{}
+}
+
+// Sorted elements of a grow-only set
+type GSetState[T cmp.Ordered] []T
+
+func NewGSet[T cmp.Ordered]() *GSet[T] {
+	return &GSet[T]{elements: map[T]struct{}{}}
+}
+
+// Adds x and returns the delta adding it
+func (s *GSet[T]) Add(x T) GSetState[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.elements[x] = struct{}{}
+	return GSetState[T]{x}
+}
+
+func (s *GSet[T]) Contains(x T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.elements[x]
+	return ok
+}
+
+// Returns the elements in order
+func (s *GSet[T]) Elements() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.elements))
+}
+
+func (s *GSet[T]) Merge(delta GSetState[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, x := range delta {
+		s.elements[x] = struct{}{}
+	}
+}
+
+// Returns the union of a and b
+func (s *GSet[T]) Join(a, b GSetState[T]) GSetState[T] {
+	joined := slices.Concat(a, b)
+	slices.Sort(joined)
+	return slices.Compact(joined)
+}
+
+func (s *GSet[T]) Full() GSetState[T] {
+	return s.Elements()
+}
+
+func (s *GSet[T]) Digest() uint64 {
+	return digest(s.Full())
+}
+
+func (s *GSet[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Full())
+}
+
+func (s *GSet[T]) UnmarshalJSON(data []byte) error {
+	var state GSetState[T]
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.elements = map[T]struct{}{}
+	s.mu.Unlock()
+
+	s.Merge(state)
+	return nil
+}
@@ -0,0 +1,84 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Last-writer-wins register, the value with the latest timestamp wins
+// Equal timestamps are ordered by replica, and a replica never reuses a timestamp, so every replica
+// picks the same winner
+type LWWRegister[T any] struct {
+	mu    sync.Mutex
+	state LWWRegisterState[T]
+}
+
+type LWWRegisterState[T any] struct {
+	Value     T      `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Replica   string `json:"replica"`
+}
+
+func NewLWWRegister[T any]() *LWWRegister[T] {
+	return &LWWRegister[T]{}
+}
+
+// Sets the value at timestamp, moved past the current one if it isn't already, and returns the delta setting it
+func (r *LWWRegister[T]) Set(replica string, value T, timestamp int64) LWWRegisterState[T] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state = LWWRegisterState[T]{
+		Value:     value,
+		Timestamp: max(timestamp, r.state.Timestamp+1),
+		Replica:   replica,
+	}
+	return r.state
+}
+
+// Returns the value, false if it was never set
+func (r *LWWRegister[T]) Get() (T, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.Value, r.state.Replica != ""
+}
+
+func (r *LWWRegister[T]) Merge(delta LWWRegisterState[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = r.Join(r.state, delta)
+}
+
+// Returns the later of a and b
+func (r *LWWRegister[T]) Join(a, b LWWRegisterState[T]) LWWRegisterState[T] {
+	if b.Timestamp > a.Timestamp || (b.Timestamp == a.Timestamp && b.Replica > a.Replica) {
+		return b
+	}
+	return a
+}
+
+func (r *LWWRegister[T]) Full() LWWRegisterState[T] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+func (r *LWWRegister[T]) Digest() uint64 {
+	return digest(r.Full())
+}
+
+func (r *LWWRegister[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Full())
+}
+
+func (r *LWWRegister[T]) UnmarshalJSON(data []byte) error {
+	var state LWWRegisterState[T]
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+	return nil
+}
@@ -0,0 +1,167 @@
+package crdt
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Observed-remove set, elements can be removed and added back
+// Every add is tagged uniquely, a remove tombstones the tags it has seen for the element, so an add
+// concurrent with a remove wins. Tags are the replica and a sequence number counted from the set's
+// creation, so a replica that restarts must restore its set's state before adding to it again
+type ORSet[T cmp.Ordered] struct {
+	mu      sync.Mutex
+	adds    map[string]T        // element of every tag not yet removed
+	removes map[string]struct{} // tags removed, kept so merges can't add them back
+	seq     int
+}
+
+type ORSetState[T cmp.Ordered] struct {
+	Adds    []ORSetAdd[T] `json:"adds"`    // sorted by tag
+	Removes []string      `json:"removes"` // sorted
+}
+
+type ORSetAdd[T cmp.Ordered] struct {
+	Tag     string `json:"tag"`
+	Element T      `json:"element"`
+}
+
+func NewORSet[T cmp.Ordered]() *ORSet[T] {
+	return &ORSet[T]{adds: map[string]T{}, removes: map[string]struct{}{}}
+}
+
+// Adds x under a new tag and returns the delta adding it
+func (s *ORSet[T]) Add(replica string, x T) ORSetState[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	tag := replica + ":" + strconv.Itoa(s.seq)
+	s.adds[tag] = x
+	return ORSetState[T]{Adds: []ORSetAdd[T]{{Tag: tag, Element: x}}}
+}
+
+// Removes x as far as this replica has seen it added and returns the delta removing it
+func (s *ORSet[T]) Remove(x T) ORSetState[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delta := ORSetState[T]{}
+	for _, tag := range slices.Sorted(maps.Keys(s.adds)) {
+		if s.adds[tag] == x {
+			delete(s.adds, tag)
+			s.removes[tag] = struct{}{}
+			delta.Removes = append(delta.Removes, tag)
+		}
+	}
+	return delta
+}
+
+func (s *ORSet[T]) Contains(x T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, element := range s.adds {
+		if element == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the elements in order
+func (s *ORSet[T]) Elements() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elements := slices.Sorted(maps.Values(s.adds))
+	return slices.Compact(elements)
+}
+
+func (s *ORSet[T]) Merge(delta ORSetState[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range delta.Removes {
+		delete(s.adds, tag)
+		s.removes[tag] = struct{}{}
+	}
+	for _, add := range delta.Adds {
+		if _, removed := s.removes[add.Tag]; !removed {
+			s.adds[add.Tag] = add.Element
+		}
+	}
+}
+
+// Returns the adds of a and b that neither removes, and the removes of both
+func (s *ORSet[T]) Join(a, b ORSetState[T]) ORSetState[T] {
+	removes := slices.Concat(a.Removes, b.Removes)
+	slices.Sort(removes)
+	removes = slices.Compact(removes)
+
+	adds := map[string]T{}
+	for _, add := range slices.Concat(a.Adds, b.Adds) {
+		if _, removed := slices.BinarySearch(removes, add.Tag); !removed {
+			adds[add.Tag] = add.Element
+		}
+	}
+
+	return ORSetState[T]{Adds: sortedAdds(adds), Removes: removes}
+}
+
+func (s *ORSet[T]) Full() ORSetState[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ORSetState[T]{Adds: sortedAdds(s.adds), Removes: slices.Sorted(maps.Keys(s.removes))}
+}
+
+func (s *ORSet[T]) Digest() uint64 {
+	return digest(s.Full())
+}
+
+func (s *ORSet[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Full())
+}
+
+// Restores a marshalled state, tags handed out afterwards are numbered past every tag in it
+func (s *ORSet[T]) UnmarshalJSON(data []byte) error {
+	var state ORSetState[T]
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.adds = map[string]T{}
+	s.removes = map[string]struct{}{}
+	s.seq = 0
+	for _, tag := range slices.Concat(state.Removes, tagsOf(state.Adds)) {
+		if seq, err := strconv.Atoi(tag[strings.LastIndex(tag, ":")+1:]); err == nil {
+			s.seq = max(s.seq, seq)
+		}
+	}
+	s.mu.Unlock()
+
+	s.Merge(state)
+	return nil
+}
+
+func sortedAdds[T cmp.Ordered](adds map[string]T) []ORSetAdd[T] {
+	sorted := []ORSetAdd[T]{}
+	for _, tag := range slices.Sorted(maps.Keys(adds)) {
+		sorted = append(sorted, ORSetAdd[T]{Tag: tag, Element: adds[tag]})
+	}
+	return sorted
+}
+
+func tagsOf[T cmp.Ordered](adds []ORSetAdd[T]) []string {
+	tags := []string{}
+	for _, add := range adds {
+		tags = append(tags, add.Tag)
+	}
+	return tags
+}
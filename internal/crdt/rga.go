@@ -0,0 +1,267 @@
+package crdt
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+)
+
+var ErrOutOfRange = errors.New("crdt: position out of range")
+
+// Replicated growable array, a sequence that elements can be inserted into and deleted from anywhere
+// Every element is inserted right after another one, its origin, or at the head, and gets an ID newer
+// than every ID its replica has seen. The sequence is the tree of elements under their origins, read
+// depth first with each element's children newest first: an element lands right after its origin, and
+// elements inserted concurrently after the same origin are ordered by their IDs, the same way on every
+// replica. A delete only tombstones its element, which stays in the tree for later inserts to follow.
+// IDs count on from the newest one seen, so a replica that restarts must restore its state before
+// changing the sequence again
+type RGA[T any] struct {
+	mu       sync.Mutex
+	inserts  map[RGAID]RGAInsert[T]
+	deletes  map[RGAID]RGADelete
+	deleted  map[RGAID]bool    // elements a delete has tombstoned
+	children map[RGAID][]RGAID // elements inserted after each element, newest first, the head under the zero ID
+	seq      int               // newest sequence number seen
+}
+
+// Identifies an insert or delete, the zero ID stands for the head of the sequence
+type RGAID struct {
+	Seq     int    `json:"seq"`
+	Replica string `json:"replica"`
+}
+
+// Orders IDs oldest first, by sequence number and then replica
+func (id RGAID) Compare(other RGAID) int {
+	return cmp.Or(cmp.Compare(id.Seq, other.Seq), cmp.Compare(id.Replica, other.Replica))
+}
+
+type RGAState[T any] struct {
+	Inserts []RGAInsert[T] `json:"inserts"` // sorted by ID
+	Deletes []RGADelete    `json:"deletes"` // sorted by ID
+}
+
+type RGAInsert[T any] struct {
+	ID     RGAID `json:"id"`
+	Origin RGAID `json:"origin"`
+	Value  T     `json:"value"`
+}
+
+type RGADelete struct {
+	ID     RGAID `json:"id"`
+	Target RGAID `json:"target"` // the insert deleted
+}
+
+// An insert or delete as it appears in the log, see Log
+type RGAOp[T any] struct {
+	Op     string `json:"op"` // insert or delete
+	ID     RGAID  `json:"id"`
+	Origin *RGAID `json:"origin,omitempty"`
+	Target *RGAID `json:"target,omitempty"`
+	Value  *T     `json:"value,omitempty"`
+}
+
+func NewRGA[T any]() *RGA[T] {
+	return &RGA[T]{
+		inserts:  map[RGAID]RGAInsert[T]{},
+		deletes:  map[RGAID]RGADelete{},
+		deleted:  map[RGAID]bool{},
+		children: map[RGAID][]RGAID{},
+	}
+}
+
+// Inserts values so the first is at position pos, and returns the delta inserting them
+// Fails with ErrOutOfRange unless 0 <= pos <= Len()
+func (s *RGA[T]) Insert(replica string, pos int, values ...T) (RGAState[T], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	visible := s.visible()
+	if pos < 0 || pos > len(visible) {
+		return RGAState[T]{}, ErrOutOfRange
+	}
+
+	var origin RGAID
+	if pos > 0 {
+		origin = visible[pos-1]
+	}
+
+	delta := RGAState[T]{Inserts: []RGAInsert[T]{}, Deletes: []RGADelete{}}
+	for _, value := range values {
+		insert := RGAInsert[T]{ID: RGAID{Seq: s.seq + 1, Replica: replica}, Origin: origin, Value: value}
+		s.insert(insert)
+		delta.Inserts = append(delta.Inserts, insert)
+		origin = insert.ID
+	}
+	return delta, nil
+}
+
+// Deletes the n elements from position pos on, and returns the delta deleting them
+// Fails with ErrOutOfRange unless they're all in the sequence
+func (s *RGA[T]) Delete(replica string, pos int, n int) (RGAState[T], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	visible := s.visible()
+	if pos < 0 || n < 0 || pos+n > len(visible) {
+		return RGAState[T]{}, ErrOutOfRange
+	}
+
+	delta := RGAState[T]{Inserts: []RGAInsert[T]{}, Deletes: []RGADelete{}}
+	for _, target := range visible[pos : pos+n] {
+		remove := RGADelete{ID: RGAID{Seq: s.seq + 1, Replica: replica}, Target: target}
+		s.delete(remove)
+		delta.Deletes = append(delta.Deletes, remove)
+	}
+	return delta, nil
+}
+
+// Returns the elements in sequence order
+func (s *RGA[T]) Elements() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elements := []T{}
+	for _, id := range s.visible() {
+		elements = append(elements, s.inserts[id].Value)
+	}
+	return elements
+}
+
+func (s *RGA[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.visible())
+}
+
+// Returns every insert and delete seen, oldest first. An op is always newer than the ones it refers to,
+// so applying them in this order never finds an origin or target missing
+func (s *RGA[T]) Log() []RGAOp[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := []RGAOp[T]{}
+	for _, insert := range s.inserts {
+		log = append(log, RGAOp[T]{Op: "insert", ID: insert.ID, Origin: &insert.Origin, Value: &insert.Value})
+	}
+	for _, remove := range s.deletes {
+		log = append(log, RGAOp[T]{Op: "delete", ID: remove.ID, Target: &remove.Target})
+	}
+	slices.SortFunc(log, func(a, b RGAOp[T]) int { return a.ID.Compare(b.ID) })
+	return log
+}
+
+func (s *RGA[T]) Merge(delta RGAState[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, insert := range delta.Inserts {
+		if _, ok := s.inserts[insert.ID]; !ok {
+			s.insert(insert)
+		}
+	}
+	for _, remove := range delta.Deletes {
+		s.delete(remove)
+	}
+}
+
+// Returns the inserts and deletes of both a and b
+func (s *RGA[T]) Join(a, b RGAState[T]) RGAState[T] {
+	inserts := map[RGAID]RGAInsert[T]{}
+	for _, insert := range slices.Concat(a.Inserts, b.Inserts) {
+		inserts[insert.ID] = insert
+	}
+	deletes := map[RGAID]RGADelete{}
+	for _, remove := range slices.Concat(a.Deletes, b.Deletes) {
+		deletes[remove.ID] = remove
+	}
+	return sortedRGAState(inserts, deletes)
+}
+
+func (s *RGA[T]) Full() RGAState[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRGAState(s.inserts, s.deletes)
+}
+
+func (s *RGA[T]) Digest() uint64 {
+	return digest(s.Full())
+}
+
+func (s *RGA[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Full())
+}
+
+// Restores a marshalled state, IDs handed out afterwards are newer than every ID in it
+func (s *RGA[T]) UnmarshalJSON(data []byte) error {
+	var state RGAState[T]
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.inserts = map[RGAID]RGAInsert[T]{}
+	s.deletes = map[RGAID]RGADelete{}
+	s.deleted = map[RGAID]bool{}
+	s.children = map[RGAID][]RGAID{}
+	s.seq = 0
+	s.mu.Unlock()
+
+	s.Merge(state)
+	return nil
+}
+
+// Adds an insert under its origin, which may not have arrived yet: until it does, the insert is kept
+// but not reachable from the head
+// Must be called with s.mu held
+func (s *RGA[T]) insert(insert RGAInsert[T]) {
+	s.inserts[insert.ID] = insert
+	s.seq = max(s.seq, insert.ID.Seq)
+
+	children := s.children[insert.Origin]
+	i, _ := slices.BinarySearchFunc(children, insert.ID, func(child, id RGAID) int { return id.Compare(child) })
+	s.children[insert.Origin] = slices.Insert(children, i, insert.ID)
+}
+
+// Must be called with s.mu held
+func (s *RGA[T]) delete(remove RGADelete) {
+	s.deletes[remove.ID] = remove
+	s.deleted[remove.Target] = true
+	s.seq = max(s.seq, remove.ID.Seq)
+}
+
+// Returns the IDs of the elements not deleted, in sequence order
+// Must be called with s.mu held
+func (s *RGA[T]) visible() []RGAID {
+	visible := []RGAID{}
+
+	// Depth first without recursion, a run of typed text is a chain as deep as it's long
+	stack := slices.Clone(s.children[RGAID{}])
+	slices.Reverse(stack)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !s.deleted[id] {
+			visible = append(visible, id)
+		}
+		for _, child := range slices.Backward(s.children[id]) {
+			stack = append(stack, child)
+		}
+	}
+	return visible
+}
+
+func sortedRGAState[T any](inserts map[RGAID]RGAInsert[T], deletes map[RGAID]RGADelete) RGAState[T] {
+	state := RGAState[T]{Inserts: []RGAInsert[T]{}, Deletes: []RGADelete{}}
+	for _, id := range slices.SortedFunc(maps.Keys(inserts), RGAID.Compare) {
+		state.Inserts = append(state.Inserts, inserts[id])
+	}
+	for _, id := range slices.SortedFunc(maps.Keys(deletes), RGAID.Compare) {
+		state.Deletes = append(state.Deletes, deletes[id])
+	}
+	return state
+}
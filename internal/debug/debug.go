@@ -0,0 +1,183 @@
+package debug
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Runtime introspection
+
+The debug RPC, answered by every workload, reports what the Go runtime is doing inside a node: how many
+goroutines it has, grouped by the function that started them, so a leak such as retries piling up shows
+up as one creator's count growing between calls, and its heap statistics.
+
+With profile set it also takes a pprof profile: any of the runtime's named profiles (goroutine, heap,
+allocs, threadcreate, block, mutex), or cpu, sampled for the given seconds (default 1, at most
+maxCPUSeconds). The profile comes back in the reply, base64 encoded, or with to_file set is written to
+DEBUG_PROFILE_DIR (the system's temp directory by default) and the reply has its path instead. debug is
+passed to the profile as pprof's debug level: 0, the default, for the binary format `go tool pprof`
+reads, 1 or 2 for text.
+*/
+
+const maxCPUSeconds = 30
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// DEBUG_PROFILE_DIR, where profiles requested with to_file are written (default the temp directory)
+	ProfileDir string `env:"DEBUG_PROFILE_DIR"`
+}
+
+type DebugRequestBody struct {
+	Type    string `json:"type"`
+	Profile string `json:"profile,omitempty"`
+	Seconds int    `json:"seconds,omitempty"`
+	Debug   int    `json:"debug,omitempty"`
+	ToFile  bool   `json:"to_file,omitempty"`
+}
+
+// Checks the profile exists before any work is done
+func (b *DebugRequestBody) Validate() error {
+	if b.Profile != "" && b.Profile != "cpu" && pprof.Lookup(b.Profile) == nil {
+		return fmt.Errorf("unknown profile %q", b.Profile)
+	}
+	if b.Seconds < 0 || b.Seconds > maxCPUSeconds {
+		return fmt.Errorf("seconds must be between 0 and %d", maxCPUSeconds)
+	}
+	return nil
+}
+
+type HeapStats struct {
+	Alloc        uint64 `json:"alloc"`
+	Inuse        uint64 `json:"inuse"`
+	Objects      uint64 `json:"objects"`
+	Sys          uint64 `json:"sys"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+type DebugResponseBody struct {
+	Type       string         `json:"type"`
+	Goroutines int            `json:"goroutines"`
+	CreatedBy  map[string]int `json:"goroutines_by_creator"`
+	Heap       HeapStats      `json:"heap"`
+	Profile    []byte         `json:"profile,omitempty"`
+	File       string         `json:"file,omitempty"`
+}
+
+// Returns the number of goroutines started by each function, "main" for the ones the runtime started
+func goroutinesByCreator() map[string]int {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	return countCreators(buf)
+}
+
+// Counts the goroutines in a dump from runtime.Stack by the function on their "created by" line
+func countCreators(dump []byte) map[string]int {
+	counts := make(map[string]int)
+	for _, stack := range bytes.Split(dump, []byte("\n\n")) {
+		if len(bytes.TrimSpace(stack)) == 0 {
+			continue
+		}
+
+		creator := "main"
+		for _, line := range strings.Split(string(stack), "\n") {
+			if fn, ok := strings.CutPrefix(line, "created by "); ok {
+				creator, _, _ = strings.Cut(fn, " in goroutine")
+			}
+		}
+		counts[creator]++
+	}
+	return counts
+}
+
+func heapStats() HeapStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return HeapStats{
+		Alloc:        m.HeapAlloc,
+		Inuse:        m.HeapInuse,
+		Objects:      m.HeapObjects,
+		Sys:          m.Sys,
+		TotalAlloc:   m.TotalAlloc,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+}
+
+// Returns the named profile, sampling the CPU for seconds if it's cpu
+func profile(ctx context.Context, name string, seconds int, debug int) ([]byte, error) {
+	var buf bytes.Buffer
+	if name != "cpu" {
+		err := pprof.Lookup(name).WriteTo(&buf, debug)
+		return buf.Bytes(), err
+	}
+
+	if seconds == 0 {
+		seconds = 1
+	}
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+// Registers the debug handler on n
+func Register(n *maelstrom.Node) error {
+	cfg := Config{ProfileDir: os.TempDir()}
+	if err := config.Load("debug", &cfg); err != nil {
+		return err
+	}
+
+	handler.Handle(n, "debug", func(msg maelstrom.Message, body DebugRequestBody) (DebugResponseBody, error) {
+		resp := DebugResponseBody{
+			Goroutines: runtime.NumGoroutine(),
+			CreatedBy:  goroutinesByCreator(),
+			Heap:       heapStats(),
+		}
+		if body.Profile == "" {
+			return resp, nil
+		}
+
+		data, err := profile(context.Background(), body.Profile, body.Seconds, body.Debug)
+		if err != nil {
+			return DebugResponseBody{}, err
+		}
+		if !body.ToFile {
+			resp.Profile = data
+			return resp, nil
+		}
+
+		resp.File = filepath.Join(cfg.ProfileDir, fmt.Sprintf("%s-%s-%d.pprof", n.ID(), body.Profile, time.Now().UnixNano()))
+		if err := os.WriteFile(resp.File, data, 0o644); err != nil {
+			return DebugResponseBody{}, err
+		}
+		return resp, nil
+	})
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package debug
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestCountCreators(t *testing.T) {
+	dump := []byte(`goroutine 1 [running]:
+main.main()
+	/src/main.go:10 +0x1d
+
+goroutine 7 [select]:
+github.com/x/gossip.(*Engine).retry(0xc000010000)
+	/src/gossip.go:40 +0x85
+created by github.com/x/gossip.(*Engine).send in goroutine 1
+	/src/gossip.go:30 +0x4f
+
+goroutine 8 [select]:
+github.com/x/gossip.(*Engine).retry(0xc000010000)
+	/src/gossip.go:40 +0x85
+created by github.com/x/gossip.(*Engine).send in goroutine 1
+	/src/gossip.go:30 +0x4f
+`)
+
+	want := map[string]int{"main": 1, "github.com/x/gossip.(*Engine).send": 2}
+	if got := countCreators(dump); !maps.Equal(got, want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+}
+
+func TestGoroutinesByCreatorCountsAll(t *testing.T) {
+	total := 0
+	for _, n := range goroutinesByCreator() {
+		total += n
+	}
+	if total == 0 {
+		t.Fatal("no goroutines counted")
+	}
+}
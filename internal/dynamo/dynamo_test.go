@@ -0,0 +1,97 @@
+package dynamo
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+)
+
+func version(value string, clock vclock.VClock) Version {
+	return Version{Value: json.RawMessage(value), Clock: clock}
+}
+
+func values(versions []Version) []string {
+	var values []string
+	for _, v := range versions {
+		values = append(values, string(v.Value))
+	}
+	return values
+}
+
+func TestMerge(t *testing.T) {
+	a := version("1", vclock.VClock{"n1": 1})
+	b := version("2", vclock.VClock{"n1": 2})
+	c := version("3", vclock.VClock{"n1": 1, "n2": 1})
+
+	// b and c both descend from a but not from each other, so they're siblings
+	if got := values(Merge([]Version{a}, []Version{b, c})); !slices.Equal(got, []string{"2", "3"}) {
+		t.Fatalf("merged %v, want siblings 2 and 3", got)
+	}
+	if got := values(Merge([]Version{b, c}, []Version{b})); !slices.Equal(got, []string{"2", "3"}) {
+		t.Fatalf("merging a version twice gave %v", got)
+	}
+
+	// A write whose context covers both siblings replaces them
+	resolved := Context([]Version{b, c})
+	resolved.Increment("n3")
+	if got := values(Merge([]Version{b, c}, []Version{version("4", resolved)})); !slices.Equal(got, []string{"4"}) {
+		t.Fatalf("merged %v, want only the resolving write", got)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	a := version("1", vclock.VClock{"n1": 1})
+	b := version("2", vclock.VClock{"n1": 2})
+	c := version("3", vclock.VClock{"n1": 1, "n2": 1})
+
+	// A replica holding b has nothing older to catch up on, only its sibling c
+	if got := values(Missing([]Version{b}, []Version{a, b, c})); !slices.Equal(got, []string{"3"}) {
+		t.Fatalf("missing %v, want only 3", got)
+	}
+	if got := Missing([]Version{b, c}, []Version{b, c}); len(got) != 0 {
+		t.Fatalf("an up to date replica is missing %v", values(got))
+	}
+	if got := values(Missing(nil, []Version{a})); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("an empty replica is missing %v, want 1", got)
+	}
+}
+
+func TestHints(t *testing.T) {
+	s := NewStore()
+	first := version("1", vclock.VClock{"n1": 1})
+	s.Hint("n2", "k", []Version{first})
+
+	// Reading as n2's stand-in sees the hint, reading the key itself doesn't
+	if got := values(s.read("n2", "k")); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("stand-in read %v, want the hint", got)
+	}
+	if got := s.read("", "k"); len(got) != 0 {
+		t.Fatalf("read %v, want nothing", values(got))
+	}
+
+	// A hint made while the handoff was running is kept
+	handed := s.Hints()["n2"]["k"]
+	s.Hint("n2", "k", []Version{version("2", vclock.VClock{"n1": 1, "n3": 1})})
+	s.Handed("n2", "k", handed)
+	if got := values(s.Hints()["n2"]["k"]); !slices.Equal(got, []string{"2"}) {
+		t.Fatalf("hints left %v, want the newer one", got)
+	}
+
+	s.Handed("n2", "k", s.Hints()["n2"]["k"])
+	if keys, hinted := s.Len(); keys != 0 || hinted != 0 || len(s.Hints()) != 0 {
+		t.Fatalf("%d keys and %d hinted left", keys, hinted)
+	}
+}
+
+func TestPreference(t *testing.T) {
+	nodes := []string{"n0", "n1", "n2", "n3", "n4"}
+	reversed := slices.Clone(nodes)
+	slices.Reverse(reversed)
+
+	first := preference("k", nodes)
+	if len(first) != len(nodes) || !slices.Equal(first, preference("k", reversed)) {
+		t.Fatalf("preference list %v isn't a fixed order of every node", first)
+	}
+}
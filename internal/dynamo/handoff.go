@@ -0,0 +1,52 @@
+package dynamo
+
+import (
+	"context"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+)
+
+/*
+Hinted handoff
+
+Every DYNAMO_HANDOFF_MS a node tries to hand each hint it holds to the replica it's meant for. A hint is
+dropped once its replica has stored it, and kept for the next round while the replica is still
+unreachable, so a replica that comes back gets the writes it missed without waiting for a client to read
+and repair them. Hints are only kept in memory: a stand-in that crashes loses them, and the replica stays
+behind until the keys are written again.
+*/
+
+// Hands hints off every interval, until ctx is cancelled
+func (d *Dynamo) Run(ctx context.Context) {
+	ticker := d.clock.NewTicker(d.cfg.Handoff)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				d.handoff(ctx)
+			}
+		}
+	}
+}
+
+func (d *Dynamo) handoff(ctx context.Context) {
+	for replica, keys := range d.store.Hints() {
+		for key, versions := range keys {
+			putCtx, cancel := d.clock.WithTimeout(ctx, d.cfg.Timeout)
+			err := d.put(putCtx, replica, PutRequestBody{Type: "dynamo_put", Key: key, Versions: versions})
+			cancel()
+
+			if err != nil {
+				// It's most likely still down, its other hints can wait for the next round too
+				logger.Debug("handoff failed", "replica", replica, "error", err)
+				break
+			}
+			d.store.Handed(replica, key, versions)
+			d.handedOff.Inc()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package dynamo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Dynamo-style quorum KV
+
+Goal: serve read, write and cas from N replicas per key with tunable read and write quorums (see
+quorum.go), versioning values with vector clocks rather than ordering the writes (see store.go), so
+writes stay available through partitions and concurrent ones are kept side by side instead of one being
+lost.
+
+A read answers with the key's value and its version, the clock of every version seen. When replicas hold
+concurrent versions, the value is the first of them and siblings lists them all, until a write resolves
+them. A write or cas can pass a version from an earlier read as its context, and then replaces exactly
+the versions that read saw; a write without one reads the key first and replaces whatever that finds. A
+cas compares against a read quorum's value and fails while there are siblings. Neither makes this
+linearizable, run against lin-kv it's expected to fail the checker. Reads repair the replicas they find
+behind (see quorum.go), counted by dynamo.read_repairs.
+
+DYNAMO_N (default 3) replicas hold each key, reads wait for DYNAMO_R (default 2) and writes for DYNAMO_W
+(default 2). DYNAMO_QUORUM picks sloppy (default) or strict quorums, DYNAMO_TIMEOUT_MS (default 500) is
+how long a replica has to answer and DYNAMO_HANDOFF_MS (default 500) how often hints are handed off.
+*/
+
+var logger = logging.For("dynamo")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// DYNAMO_N, how many replicas hold each key (default 3)
+	N int `env:"DYNAMO_N" min:"1"`
+
+	// DYNAMO_R, how many replicas a read waits for (default 2)
+	R int `env:"DYNAMO_R" min:"1"`
+
+	// DYNAMO_W, how many replicas a write waits for (default 2)
+	W int `env:"DYNAMO_W" min:"1"`
+
+	// DYNAMO_QUORUM, sloppy (default), letting other nodes stand in for replicas that don't answer, or strict
+	Quorum string `env:"DYNAMO_QUORUM" oneof:"sloppy|strict"`
+
+	// DYNAMO_TIMEOUT_MS, how long a replica has to answer (default 500)
+	Timeout time.Duration `env:"DYNAMO_TIMEOUT_MS" min:"1"`
+
+	// DYNAMO_HANDOFF_MS, how often hints are handed to their replicas (default 500)
+	Handoff time.Duration `env:"DYNAMO_HANDOFF_MS" min:"1"`
+}
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string          `json:"type"`
+	Key  json.RawMessage `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type     string            `json:"type"`
+	Value    json.RawMessage   `json:"value"`
+	Version  vclock.VClock     `json:"version"`
+	Siblings []json.RawMessage `json:"siblings,omitempty"` // every concurrent value, if there's more than one
+}
+
+// Write RPC
+type WriteRequestBody struct {
+	Type    string          `json:"type"`
+	Key     json.RawMessage `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Version vclock.VClock   `json:"version,omitempty"` // from an earlier read, the versions this write replaces
+}
+
+type WriteResponseBody struct {
+	Type    string        `json:"type"`
+	Version vclock.VClock `json:"version"`
+}
+
+// Compare-and-set RPC
+type CASRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type CASResponseBody struct {
+	Type    string        `json:"type"`
+	Version vclock.VClock `json:"version"`
+}
+
+// Registers the KV and replica handlers on n and starts handing off hints
+func Register(n *maelstrom.Node) error {
+	cfg := Config{N: 3, R: 2, W: 2, Quorum: "sloppy", Timeout: 500 * time.Millisecond, Handoff: 500 * time.Millisecond}
+	if err := config.Load("dynamo", &cfg); err != nil {
+		return err
+	}
+	if cfg.R > cfg.N || cfg.W > cfg.N {
+		return fmt.Errorf("dynamo: DYNAMO_R (%d) and DYNAMO_W (%d) can't be more than DYNAMO_N (%d)", cfg.R, cfg.W, cfg.N)
+	}
+
+	store := NewStore()
+	d := New(n, store, cfg)
+	ctx := context.Background()
+
+	handler.Handle(n, "dynamo_get", d.handleGet)
+	handler.Handle(n, "dynamo_put", d.handlePut)
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		readCtx, cancel := d.clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		versions, err := d.Read(readCtx, string(compact(body.Key)))
+		if err != nil {
+			return ReadResponseBody{}, err
+		}
+		if len(versions) == 0 {
+			return ReadResponseBody{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+
+		resp := ReadResponseBody{Value: versions[0].Value, Version: Context(versions)}
+		if len(versions) > 1 {
+			for _, v := range versions {
+				resp.Siblings = append(resp.Siblings, v.Value)
+			}
+		}
+		return resp, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body WriteRequestBody) (WriteResponseBody, error) {
+		writeCtx, cancel := d.clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		key := string(compact(body.Key))
+		seen := body.Version
+		if seen == nil {
+			versions, err := d.Read(writeCtx, key)
+			if err != nil {
+				return WriteResponseBody{}, err
+			}
+			seen = Context(versions)
+		}
+
+		version, err := d.Write(writeCtx, key, compact(body.Value), seen)
+		return WriteResponseBody{Version: version.Clock}, err
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body CASRequestBody) (CASResponseBody, error) {
+		casCtx, cancel := d.clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		key := string(compact(body.Key))
+		versions, err := d.Read(casCtx, key)
+		if err != nil {
+			return CASResponseBody{}, err
+		}
+
+		switch {
+		case len(versions) == 0 && !body.CreateIfNotExists:
+			return CASResponseBody{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		case len(versions) > 1:
+			return CASResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("key has %d concurrent versions", len(versions)))
+		case len(versions) == 1 && !bytes.Equal(versions[0].Value, compact(body.From)):
+			return CASResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, "expected "+string(compact(body.From))+", found "+string(versions[0].Value))
+		}
+
+		version, err := d.Write(casCtx, key, compact(body.To), Context(versions))
+		return CASResponseBody{Version: version.Clock}, err
+	})
+
+	admin.Of(n).Register("dynamo", admin.Module{Status: func() any {
+		keys, hinted := store.Len()
+		return map[string]any{"n": cfg.N, "r": cfg.R, "w": cfg.W, "quorum": cfg.Quorum, "keys": keys, "hinted_keys": hinted}
+	}, Flush: func(ctx context.Context) error {
+		d.handoff(ctx)
+		return nil
+	}})
+
+	lifecycle.Of(n).Go(d.Run)
+
+	return nil
+}
+
+// Returns the compacted JSON of a client's key or value, so spacing doesn't make two of them differ
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,301 @@
+package dynamo
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Quorums
+
+Each key has a preference list, every node ordered by a hash of the key and the node's ID (rendezvous
+hashing), and its replicas are the first N on it. The node a client asks coordinates: it sends a read to
+the replicas and answers once R of them have, merging the versions they hold, and sends a write to them and
+answers once W have stored it. With R + W > N a read quorum shares at least one replica with every write
+quorum, so as long as the replicas are up a read sees the latest acknowledged write.
+
+With DYNAMO_QUORUM=sloppy (the default), a replica that fails or doesn't answer within DYNAMO_TIMEOUT_MS is
+replaced by the next node down the preference list, so a write still gets W acks while replicas are down
+or cut off. The stand-in keeps the write as a hint for the replica it replaced and hands it over once that
+replica answers again (see handoff.go). A stand-in that's read from answers with the hints it holds for
+the replica it replaces, so a read sees the writes it took in that replica's place. That keeps writes available, but the quorums no longer have to
+overlap: a read can be answered by R nodes none of which saw the latest write, until the hints are handed
+off. With DYNAMO_QUORUM=strict only the N replicas count, and an operation fails without R or W of them.
+
+A read also repairs the replicas that answered it: any of them missing a version the others returned, one
+that missed a write or was only ever given it as a hint that's not yet handed off, is sent the versions it
+lacks once the read is answered. Replicas that weren't in the read quorum are left for a later read or
+the hinted handoff to bring up to date.
+*/
+
+// Get RPC, a coordinator reading a replica's versions of a key
+type GetRequestBody struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Hint string `json:"hint,omitempty"` // the replica whose hinted versions to read, if the receiver stands in for it
+}
+
+type GetResponseBody struct {
+	Type     string    `json:"type"`
+	Versions []Version `json:"versions"`
+}
+
+// Put RPC, a coordinator or a stand-in giving a replica versions of a key
+type PutRequestBody struct {
+	Type     string    `json:"type"`
+	Key      string    `json:"key"`
+	Versions []Version `json:"versions"`
+	Hint     string    `json:"hint,omitempty"` // the replica they're meant for, if the receiver stands in for it
+}
+
+type PutResponseBody struct {
+	Type string `json:"type"`
+}
+
+type Dynamo struct {
+	node  *maelstrom.Node
+	store *Store
+	cfg   Config
+	clock env.Clock
+
+	fallbacks   *metrics.Counter
+	hinted      *metrics.Counter
+	handedOff   *metrics.Counter
+	readRepairs *metrics.Counter
+}
+
+func New(n *maelstrom.Node, store *Store, cfg Config) *Dynamo {
+	registry := metrics.Of(n)
+	return &Dynamo{
+		node:        n,
+		store:       store,
+		cfg:         cfg,
+		clock:       env.Of(n).Clock,
+		fallbacks:   registry.Counter("dynamo.fallbacks"),
+		hinted:      registry.Counter("dynamo.hinted"),
+		handedOff:   registry.Counter("dynamo.handed_off"),
+		readRepairs: registry.Counter("dynamo.read_repairs"),
+	}
+}
+
+// Returns every node, in the order they're preferred as replicas of key
+func preference(key string, nodes []string) []string {
+	weight := func(node string) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(node))
+		return h.Sum64()
+	}
+
+	preferred := slices.Clone(nodes)
+	slices.SortFunc(preferred, func(a, b string) int {
+		return cmp.Or(cmp.Compare(weight(b), weight(a)), cmp.Compare(a, b))
+	})
+	return preferred
+}
+
+// Calls send for each of key's replicas until need of them succeed, or they've all failed
+// With sloppy quorums a replica that fails is replaced by the next node on the preference list, which send
+// is told to stand in for it. That carries on after this returns, so a write that got its W acks still
+// reaches N nodes; send may be running for some nodes after this returns, and isn't cancelled with ctx
+func (d *Dynamo) quorum(ctx context.Context, key string, need int, send func(ctx context.Context, node, hint string) error) error {
+	prefs := preference(key, d.node.NodeIDs())
+	n := min(d.cfg.N, len(prefs))
+
+	type result struct {
+		node, hint string
+		err        error
+	}
+	// Every node is sent to at most once, so no send blocks on results
+	results := make(chan result, len(prefs))
+	launch := func(node, hint string) {
+		go func() {
+			sendCtx, cancel := d.clock.WithTimeout(context.WithoutCancel(ctx), d.cfg.Timeout)
+			defer cancel()
+			results <- result{node, hint, send(sendCtx, node, hint)}
+		}()
+	}
+
+	for _, node := range prefs[:n] {
+		launch(node, "")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		next, pending, succeeded := n, n, 0
+		var errs []error
+		for pending > 0 {
+			r := <-results
+			pending--
+
+			if r.err == nil {
+				if succeeded++; succeeded == need {
+					done <- nil
+				}
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", r.node, r.err))
+
+			if d.cfg.Quorum == "sloppy" && next < len(prefs) {
+				hint := cmp.Or(r.hint, r.node)
+				d.fallbacks.Inc()
+				launch(prefs[next], hint)
+				next++
+				pending++
+			}
+		}
+
+		if succeeded < need {
+			done <- maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("%d of %d replicas answered: %v", succeeded, need, errors.Join(errs...)))
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns the versions of key held by a read quorum of its replicas
+// Replicas in the quorum missing any of them are repaired in the background
+func (d *Dynamo) Read(ctx context.Context, key string) ([]Version, error) {
+	var mu sync.Mutex
+	var versions []Version
+	held := make(map[string][]Version) // what each replica answered with
+
+	err := d.quorum(ctx, key, d.cfg.R, func(ctx context.Context, node, hint string) error {
+		replica, err := d.get(ctx, node, hint, key)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		versions = Merge(versions, replica)
+		// A stand-in only holds hints, it isn't one of key's replicas to repair
+		if hint == "" {
+			held[node] = replica
+		}
+		return nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err == nil {
+		d.repair(ctx, key, versions, held)
+	}
+	return versions, err
+}
+
+// Sends each replica the versions it's missing, without waiting for them to be stored
+func (d *Dynamo) repair(ctx context.Context, key string, versions []Version, held map[string][]Version) {
+	for node, replica := range held {
+		missing := Missing(replica, versions)
+		if len(missing) == 0 {
+			continue
+		}
+
+		go func() {
+			putCtx, cancel := d.clock.WithTimeout(context.WithoutCancel(ctx), d.cfg.Timeout)
+			defer cancel()
+
+			if err := d.put(putCtx, node, PutRequestBody{Type: "dynamo_put", Key: key, Versions: missing}); err != nil {
+				logger.Debug("read repair failed", "replica", node, "key", key, "error", err)
+				return
+			}
+			d.readRepairs.Inc()
+		}()
+	}
+}
+
+// Writes value to key as a version succeeding the versions seen, and returns the version written
+// A failed write may still have reached some replicas, and may yet show up in reads
+func (d *Dynamo) Write(ctx context.Context, key string, value json.RawMessage, seen vclock.VClock) (Version, error) {
+	clock := seen.Clone()
+	clock.Increment(d.node.ID())
+	version := Version{Value: value, Clock: clock}
+
+	err := d.quorum(ctx, key, d.cfg.W, func(ctx context.Context, node, hint string) error {
+		return d.put(ctx, node, PutRequestBody{Type: "dynamo_put", Key: key, Versions: []Version{version}, Hint: hint})
+	})
+	if err != nil {
+		return version, maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+	}
+	return version, nil
+}
+
+// Reads node's versions of key, or those it holds as hints for the replica hint if it's standing in for one
+// Reads from this node's own store if it's the one asked
+func (d *Dynamo) get(ctx context.Context, node, hint, key string) ([]Version, error) {
+	if node == d.node.ID() {
+		return d.store.read(hint, key), nil
+	}
+
+	msg, err := d.node.SyncRPC(ctx, node, GetRequestBody{Type: "dynamo_get", Key: key, Hint: hint})
+	if err != nil {
+		return nil, err
+	}
+
+	var body GetResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return nil, err
+	}
+	return body.Versions, nil
+}
+
+// Gives node the versions in put, storing them here if it's this node
+func (d *Dynamo) put(ctx context.Context, node string, put PutRequestBody) error {
+	if node == d.node.ID() {
+		d.store.apply(put)
+		if put.Hint != "" {
+			d.hinted.Inc()
+		}
+		return nil
+	}
+
+	_, err := d.node.SyncRPC(ctx, node, put)
+	return err
+}
+
+func (d *Dynamo) handleGet(msg maelstrom.Message, body GetRequestBody) (GetResponseBody, error) {
+	return GetResponseBody{Versions: d.store.read(body.Hint, body.Key)}, nil
+}
+
+func (d *Dynamo) handlePut(msg maelstrom.Message, body PutRequestBody) (PutResponseBody, error) {
+	d.store.apply(body)
+	if body.Hint != "" {
+		d.hinted.Inc()
+	}
+	return PutResponseBody{}, nil
+}
+
+// Returns the versions of key, the ones held as hints for hint if it's another replica
+func (s *Store) read(hint, key string) []Version {
+	if hint != "" {
+		return s.Hinted(hint, key)
+	}
+	return s.Get(key)
+}
+
+// Stores put's versions, as hints if they're meant for another replica
+func (s *Store) apply(put PutRequestBody) {
+	if put.Hint != "" {
+		s.Hint(put.Hint, put.Key, put.Versions)
+		return
+	}
+	s.Put(put.Key, put.Versions)
+}
@@ -0,0 +1,162 @@
+package dynamo
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+)
+
+/*
+Versions
+
+Every write is stamped with a vector clock: the clock of the versions the client read (its context), with
+the coordinating node's entry incremented. A replica keeps every version of a key that no other version it
+holds descends from, so two writes made without seeing each other, such as on either side of a partition,
+are both kept as siblings until a write whose context covers both replaces them.
+
+Replicas also keep hints: versions sent to them in place of a replica that didn't answer, held apart from
+their own keys, until they can be handed to the replica they were meant for (see handoff.go).
+*/
+
+type Version struct {
+	Value json.RawMessage `json:"value"`
+	Clock vclock.VClock   `json:"clock"`
+}
+
+type Store struct {
+	mu    sync.Mutex
+	keys  map[string][]Version
+	hints map[string]map[string][]Version // for each replica that missed them, its versions of each key
+}
+
+func NewStore() *Store {
+	return &Store{keys: make(map[string][]Version), hints: make(map[string]map[string][]Version)}
+}
+
+// Returns the versions of key, no two of which descend from each other
+func (s *Store) Get(key string) []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keys[key])
+}
+
+// Adds versions of key, dropping every version another descends from
+func (s *Store) Put(key string, versions []Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = Merge(s.keys[key], versions)
+}
+
+// Holds versions of key for replica until they're handed off
+func (s *Store) Hint(replica, key string, versions []Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hints[replica] == nil {
+		s.hints[replica] = make(map[string][]Version)
+	}
+	s.hints[replica][key] = Merge(s.hints[replica][key], versions)
+}
+
+// Returns the versions of key held for replica until they're handed off
+func (s *Store) Hinted(replica, key string) []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.hints[replica][key])
+}
+
+// Returns a copy of every hint held, by replica then key
+func (s *Store) Hints() map[string]map[string][]Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hints := make(map[string]map[string][]Version, len(s.hints))
+	for replica, keys := range s.hints {
+		hints[replica] = maps.Clone(keys)
+	}
+	return hints
+}
+
+// Drops the hints for replica's key that versions cover, once they've been handed off
+// Versions hinted since the handoff started are kept for the next one
+func (s *Store) Handed(replica, key string, versions []Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := slices.DeleteFunc(slices.Clone(s.hints[replica][key]), func(hint Version) bool {
+		return slices.ContainsFunc(versions, func(v Version) bool { return v.Clock.Descends(hint.Clock) })
+	})
+	if len(remaining) > 0 {
+		s.hints[replica][key] = remaining
+		return
+	}
+	delete(s.hints[replica], key)
+	if len(s.hints[replica]) == 0 {
+		delete(s.hints, replica)
+	}
+}
+
+// Returns the number of keys held and of hinted keys
+func (s *Store) Len() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hinted := 0
+	for _, keys := range s.hints {
+		hinted += len(keys)
+	}
+	return len(s.keys), hinted
+}
+
+// Returns the versions of a and b that no other version among them descends from, in a fixed order
+func Merge(a, b []Version) []Version {
+	all := append(slices.Clone(a), b...)
+
+	var merged []Version
+	for i, v := range all {
+		dominated := slices.ContainsFunc(all, func(other Version) bool {
+			return vclock.Compare(v.Clock, other.Clock) == vclock.Before
+		})
+		// Of equal versions only the first is kept
+		duplicate := slices.ContainsFunc(all[:i], func(other Version) bool {
+			return vclock.Compare(v.Clock, other.Clock) == vclock.Equal
+		})
+		if !dominated && !duplicate {
+			merged = append(merged, v)
+		}
+	}
+
+	slices.SortFunc(merged, func(a, b Version) int {
+		if c := bytes.Compare(a.Value, b.Value); c != 0 {
+			return c
+		}
+		ca, _ := json.Marshal(a.Clock)
+		cb, _ := json.Marshal(b.Clock)
+		return bytes.Compare(ca, cb)
+	})
+	return merged
+}
+
+// Returns the versions held has no version of, or of one descending from it
+func Missing(held, versions []Version) []Version {
+	var missing []Version
+	for _, v := range versions {
+		if !slices.ContainsFunc(held, func(h Version) bool { return h.Clock.Descends(v.Clock) }) {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// Returns a clock every one of versions happened before or equals, the context for a write replacing them
+func Context(versions []Version) vclock.VClock {
+	clock := vclock.New()
+	for _, v := range versions {
+		clock.Merge(v.Clock)
+	}
+	return clock
+}
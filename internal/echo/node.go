@@ -0,0 +1,41 @@
+package echo
+
+import (
+	"encoding/json"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Challenge #1: Echo
+
+Goal: reply to every echo message with the same payload, the smallest node to start from
+*/
+
+type EchoRequestBody struct {
+	Type string          `json:"type"`
+	Echo json.RawMessage `json:"echo"`
+}
+
+type EchoResponseBody struct {
+	Type string          `json:"type"`
+	Echo json.RawMessage `json:"echo"`
+}
+
+// Registers the echo handler on n
+func Register(n *maelstrom.Node) error {
+
+	// Echo the original payload back, the reply type defaults to echo_ok
+	handler.Handle(n, "echo", func(msg maelstrom.Message, body EchoRequestBody) (EchoResponseBody, error) {
+		return EchoResponseBody{
+			Echo: body.Echo,
+		}, nil
+	})
+
+	// Stateless, but still answers the admin RPCs like every other workload
+	admin.Of(n).Register("echo", admin.Module{})
+
+	return nil
+}
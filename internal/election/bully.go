@@ -0,0 +1,193 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Bully election
+
+Nodes rank by their IDs in sorted order, the first one ranking highest, and the highest-ranked node that
+is up leads. The leader sends <name>_coordinator to every other node each heartbeat. A node that hasn't
+heard one for the timeout holds an election: it sends <name>_election to every node ranked above it, and
+if none answers within the timeout, it takes over with the next term and announces itself. If one does
+answer, that node holds an election of its own, and the node waits a timeout for the result.
+
+An announcement from a node ranked below this one is answered by holding an election, which bullies it
+out of the way, so a leader that comes back takes over again.
+*/
+
+type ElectionRequestBody struct {
+	Type string `json:"type"`
+}
+
+type ElectionResponseBody struct {
+	Type string `json:"type"`
+}
+
+type CoordinatorMessageBody struct {
+	Type   string `json:"type"`
+	Leader string `json:"leader"`
+	Term   int64  `json:"term"`
+}
+
+type Bully struct {
+	state
+	node      *maelstrom.Node
+	env       *env.Env
+	heartbeat time.Duration
+	timeout   time.Duration
+
+	// Guarded by state.mu
+	heard time.Time // last announcement from a leader, or answer from a node ranked above this one
+
+	challenged chan struct{} // asks Run to hold an election now
+}
+
+// Returns an elector among n's nodes and registers its handlers, name sets their message types
+// Must be called before the node starts running
+func NewBully(n *maelstrom.Node, name string, callbacks Callbacks) (*Bully, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bully{
+		state:      state{name: name, callbacks: callbacks},
+		node:       n,
+		env:        env.Of(n),
+		heartbeat:  cfg.Heartbeat,
+		timeout:    cfg.Timeout,
+		challenged: make(chan struct{}, 1),
+	}
+
+	handler.Handle(n, name+"_election", func(msg maelstrom.Message, body ElectionRequestBody) (ElectionResponseBody, error) {
+		b.challenge()
+		return ElectionResponseBody{}, nil
+	})
+
+	n.Handle(name+"_coordinator", func(msg maelstrom.Message) error {
+		var body CoordinatorMessageBody
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		b.announced(body.Leader, body.Term)
+		return nil
+	})
+
+	admin.Of(n).Register("election."+name, admin.Module{Status: func() any { return b.Status() }})
+	return b, nil
+}
+
+func (b *Bully) IsLeader() bool {
+	return b.Status().Leading
+}
+
+// Makes Run hold an election, or announce this node again if it leads, without waiting for its next round
+func (b *Bully) challenge() {
+	select {
+	case b.challenged <- struct{}{}:
+	default:
+	}
+}
+
+// Handles leader's announcement
+func (b *Bully) announced(leader string, term int64) {
+	if leader > b.node.ID() {
+		b.challenge()
+		return
+	}
+
+	b.mu.Lock()
+	b.heard = b.env.Clock.Now()
+	b.mu.Unlock()
+	b.set(leader, term, false)
+}
+
+// Holds elections and announces this node while it leads, until ctx is cancelled
+func (b *Bully) Run(ctx context.Context) {
+	ticker := b.env.Clock.NewTicker(b.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		case <-b.challenged:
+		}
+
+		if len(b.node.NodeIDs()) == 0 || lifecycle.Paused(ctx) {
+			continue
+		}
+
+		if b.IsLeader() {
+			b.announce()
+			continue
+		}
+
+		b.mu.Lock()
+		silent := b.env.Clock.Now().Sub(b.heard) >= b.timeout
+		b.mu.Unlock()
+		if silent {
+			b.elect(ctx)
+		}
+	}
+}
+
+// Asks the nodes ranked above this one to take over, and takes over if none of them can
+func (b *Bully) elect(ctx context.Context) {
+	nodes := slices.Sorted(slices.Values(b.node.NodeIDs()))
+	above := nodes[:max(slices.Index(nodes, b.node.ID()), 0)]
+
+	electCtx, cancel := b.env.Clock.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	answered := make(chan struct{}, len(above))
+	for _, peer := range above {
+		wg.Go(func() {
+			if _, err := b.node.SyncRPC(electCtx, peer, ElectionRequestBody{Type: b.name + "_election"}); err == nil {
+				answered <- struct{}{}
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(answered) > 0 {
+		// A node ranked above is up, give it a timeout to announce itself
+		b.mu.Lock()
+		b.heard = b.env.Clock.Now()
+		b.mu.Unlock()
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	b.set(b.node.ID(), b.Status().Term+1, true)
+	b.announce()
+}
+
+// Tells every other node this one leads
+func (b *Bully) announce() {
+	status := b.Status()
+	for _, peer := range b.node.NodeIDs() {
+		if peer == b.node.ID() {
+			continue
+		}
+		if err := b.node.Send(peer, CoordinatorMessageBody{Type: b.name + "_coordinator", Leader: status.Leader, Term: status.Term}); err != nil {
+			logger.Warn("announcement failed", "election", b.name, "peer", peer, "error", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+)
+
+/*
+Leader election
+
+Picks one node to be in charge of something, for protocols that don't need all of Raft but do need a
+single node to act, such as handing out ID ranges or owning a log. Two electors, with the same interface:
+  - Lease (lease.go): the leader holds a record with an expiry in lin-kv, renewed with a compare-and-swap,
+    so there is at most one leader at a time as long as the nodes' clocks roughly agree
+  - Bully (bully.go): the nodes elect the first of them, in sorted order, that answers, with RPCs between
+    them and no outside service, but two nodes may both think they lead while a partition lasts
+
+Neither makes what the leader does safe on its own: a leader may be deposed between checking IsLeader and
+acting on it. They're for picking who should act, the action itself still has to be guarded, by a
+compare-and-swap or an epoch.
+
+Callbacks are called when this node gains or loses leadership, one at a time and from whichever goroutine
+noticed, so they must not block on the elector. An elector's Run campaigns in the background, started with
+lifecycle.Of(node).Go, and skips its rounds while the node is paused; a paused leader is deposed once it
+can't keep its lease or stops sending heartbeats. Each elector's state shows in admin_status as
+election.<name>, except the leases of a lease set (see leases.go), which show together under its name.
+
+ELECTION_LEASE_MS (default 2000) is how long a lease lasts, ELECTION_HEARTBEAT_MS (default 200) how often a
+bully leader announces itself and ELECTION_TIMEOUT_MS (default 1000) how long the others wait without an
+announcement before holding an election.
+*/
+
+var logger = logging.For("election")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// ELECTION_LEASE_MS, how long a leader's lease lasts without being renewed (default 2000)
+	Lease time.Duration `env:"ELECTION_LEASE_MS" min:"1"`
+
+	// ELECTION_HEARTBEAT_MS, how often a bully leader announces itself (default 200)
+	Heartbeat time.Duration `env:"ELECTION_HEARTBEAT_MS" min:"1"`
+
+	// ELECTION_TIMEOUT_MS, how long without an announcement before nodes elect a new leader (default 1000)
+	Timeout time.Duration `env:"ELECTION_TIMEOUT_MS" min:"1"`
+}
+
+func loadConfig() (Config, error) {
+	cfg := Config{Lease: 2 * time.Second, Heartbeat: 200 * time.Millisecond, Timeout: time.Second}
+	err := config.Load("election", &cfg)
+	return cfg, err
+}
+
+type Elector interface {
+	// Campaigns until ctx is cancelled
+	Run(ctx context.Context)
+
+	// Returns the leader as far as this node knows, "" if it doesn't know of one
+	Leader() string
+
+	// Returns whether this node is the leader
+	IsLeader() bool
+
+	Status() Status
+}
+
+type Callbacks struct {
+	// Called when this node becomes leader, with the term it leads in
+	Elected func(term int64)
+
+	// Called when this node stops being leader
+	Deposed func()
+}
+
+type Status struct {
+	Leader  string `json:"leader"`
+	Term    int64  `json:"term"`
+	Leading bool   `json:"leading"`
+}
+
+// What both electors know about the leader
+type state struct {
+	name      string
+	callbacks Callbacks
+
+	// Held through a change of leader and its callbacks, so they're called in order
+	transition sync.Mutex
+
+	mu      sync.Mutex
+	leader  string
+	term    int64
+	leading bool
+}
+
+// Returns the leader as far as this node knows
+func (s *state) Leader() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+func (s *state) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{Leader: s.leader, Term: s.term, Leading: s.leading}
+}
+
+// Records leader as the leader of term, calling the callbacks if this node gained or lost leadership
+func (s *state) set(leader string, term int64, leading bool) {
+	s.transition.Lock()
+	defer s.transition.Unlock()
+
+	s.mu.Lock()
+	was := s.leading
+	s.leader, s.term, s.leading = leader, max(s.term, term), leading
+	s.mu.Unlock()
+
+	switch {
+	case leading && !was:
+		logger.Info("elected", "election", s.name, "term", term)
+		if s.callbacks.Elected != nil {
+			s.callbacks.Elected(term)
+		}
+	case !leading && was:
+		logger.Info("deposed", "election", s.name, "leader", leader)
+		if s.callbacks.Deposed != nil {
+			s.callbacks.Deposed()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package election
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newNode(id string, ids []string, clock env.Clock) *maelstrom.Node {
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init(id, ids)
+	env.Set(n, &env.Env{Clock: clock, Rand: env.NewRand(1)})
+	return n
+}
+
+func TestLease(t *testing.T) {
+	kv := fake.NewKV()
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	ids := []string{"n1", "n2"}
+
+	var events []string
+	callbacks := func(id string) Callbacks {
+		return Callbacks{
+			Elected: func(term int64) { events = append(events, id+" elected") },
+			Deposed: func() { events = append(events, id+" deposed") },
+		}
+	}
+
+	a, err := NewLease(newNode("n1", ids, clock), kv, "test/leader", callbacks("n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewLease(newNode("n2", ids, clock), kv, "test/leader", callbacks("n2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	campaign := func(l *Lease, want string) {
+		t.Helper()
+		if leader, err := l.Campaign(ctx); err != nil || leader != want {
+			t.Fatalf("%s campaigned: leader %q, %v, want %q", l.node.ID(), leader, err, want)
+		}
+	}
+
+	campaign(a, "n1")
+	campaign(b, "n1")
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatal("n1 should lead alone")
+	}
+
+	// Renewed before it runs out, n1 keeps the lease
+	clock.Advance(a.ttl * 3 / 4)
+	campaign(a, "n1")
+	clock.Advance(a.ttl * 3 / 4)
+	campaign(b, "n1")
+
+	// Left to run out, n2 takes it over with the next term
+	clock.Advance(a.ttl + time.Millisecond)
+	if a.IsLeader() {
+		t.Fatal("n1 still leads after its lease ran out")
+	}
+	campaign(b, "n2")
+	campaign(a, "n2")
+
+	if status := b.Status(); status.Term != 2 || !status.Leading {
+		t.Fatalf("n2's status %+v, want leading in term 2", status)
+	}
+	want := []string{"n1 elected", "n2 elected", "n1 deposed"}
+	if len(events) != len(want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events %v, want %v", events, want)
+		}
+	}
+}
+
+func TestBullyAnnouncements(t *testing.T) {
+	n := newNode("n2", []string{"n1", "n2", "n3"}, env.Real{})
+
+	deposed := false
+	b, err := NewBully(n, "test", Callbacks{Deposed: func() { deposed = true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.set("n2", 1, true)
+
+	// Outranked by n1, n2 steps down
+	b.announced("n1", 2)
+	if b.IsLeader() || b.Leader() != "n1" || !deposed {
+		t.Fatalf("status %+v after n1's announcement, want n1 leading", b.Status())
+	}
+
+	// n3 ranks below n2, which holds an election instead of following it
+	b.announced("n3", 3)
+	if b.Leader() != "n1" {
+		t.Fatalf("followed %s, want n1", b.Leader())
+	}
+	select {
+	case <-b.challenged:
+	default:
+		t.Fatal("n3's announcement wasn't challenged")
+	}
+}
+
+func TestBullyAlone(t *testing.T) {
+	n := newNode("n1", []string{"n1"}, env.Real{})
+
+	var term int64
+	b, err := NewBully(n, "test", Callbacks{Elected: func(t int64) { term = t }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No node ranks above n1, so it takes over without asking
+	b.elect(context.Background())
+	if !b.IsLeader() || term != 1 {
+		t.Fatalf("status %+v, elected in term %d, want leading in term 1", b.Status(), term)
+	}
+}
@@ -0,0 +1,156 @@
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Lease-based election
+
+The leader is whoever holds the Record at the lease's key, until its expiry. A node takes the record over
+with a compare-and-swap once it has expired, and the holder renews it the same way before it does, so
+the key's history is the sequence of leaders and each new holder bumps the term.
+
+The holder stamps the expiry with its own clock, before reading the record, and stops counting itself as
+leader at that time, so it never leads past the expiry the others see unless their clocks are behind its
+own. Others only take over once the expiry has passed by their clock.
+
+Campaign is cheap to call before every use of the leadership: while more than half the lease is left it
+answers from what it last saw, without touching the KV, so a lease can also be kept without Run, by the
+node that needs it, and lapses once the node stops using it.
+*/
+
+// The value kept at a lease's key
+type Record struct {
+	Holder  string `json:"holder"`
+	Term    int64  `json:"term"`
+	Expires int64  `json:"expires"` // Unix milliseconds, by the holder's clock
+}
+
+type Lease struct {
+	state
+	node  *maelstrom.Node
+	kv    kvutil.KV
+	key   string
+	ttl   time.Duration
+	clock env.Clock
+
+	// Guarded by state.mu
+	until time.Time // when this node's lease runs out, or another's is expected to
+}
+
+// Returns an elector for whoever holds the lease at key in kv
+func NewLease(node *maelstrom.Node, kv kvutil.KV, key string, callbacks Callbacks) (*Lease, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLease(node, kv, key, cfg.Lease, callbacks)
+	admin.Of(node).Register("election."+key, admin.Module{Status: func() any { return l.Status() }})
+	return l, nil
+}
+
+func newLease(node *maelstrom.Node, kv kvutil.KV, key string, ttl time.Duration, callbacks Callbacks) *Lease {
+	return &Lease{
+		state: state{name: key, callbacks: callbacks},
+		node:  node,
+		kv:    kv,
+		key:   key,
+		ttl:   ttl,
+		clock: env.Of(node).Clock,
+	}
+}
+
+// Returns whether this node holds the lease and it hasn't run out
+func (l *Lease) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading && l.clock.Now().Before(l.until)
+}
+
+// Takes the lease if it's free or renews it if this node holds it, and returns the holder
+func (l *Lease) Campaign(ctx context.Context) (string, error) {
+	self := l.node.ID()
+	now := l.clock.Now()
+
+	// Nothing to do while this node's lease has more than half to go, or another's hasn't expired
+	l.mu.Lock()
+	leader, leading, until := l.leader, l.leading, l.until
+	l.mu.Unlock()
+	if leading && now.Before(until.Add(-l.ttl/2)) || !leading && leader != "" && now.Before(until) {
+		return leader, nil
+	}
+
+	record, err := kvutil.Update(ctx, l.kv, l.key, Record{}, func(old Record) (Record, error) {
+		if old.Holder != "" && old.Holder != self && now.UnixMilli() < old.Expires {
+			return old, kvutil.ErrNoChange
+		}
+
+		term := old.Term
+		if old.Holder != self {
+			term++
+		}
+		return Record{Holder: self, Term: term, Expires: now.Add(l.ttl).UnixMilli()}, nil
+	})
+	if err != nil {
+		// Whether the lease was renewed isn't known, it runs out on its own
+		l.expire()
+		return "", err
+	}
+
+	l.mu.Lock()
+	if record.Holder == self {
+		l.until = now.Add(l.ttl)
+	} else {
+		l.until = time.UnixMilli(record.Expires)
+	}
+	l.mu.Unlock()
+
+	l.set(record.Holder, record.Term, record.Holder == self)
+	return record.Holder, nil
+}
+
+// Deposes this node if its lease has run out
+func (l *Lease) expire() {
+	l.mu.Lock()
+	lapsed := l.leading && !l.clock.Now().Before(l.until)
+	term := l.term
+	l.mu.Unlock()
+
+	if lapsed {
+		l.set("", term, false)
+	}
+}
+
+// Keeps campaigning for the lease until ctx is cancelled
+func (l *Lease) Run(ctx context.Context) {
+	ticker := l.clock.NewTicker(l.ttl / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if l.node.ID() == "" || lifecycle.Paused(ctx) {
+			l.expire()
+			continue
+		}
+
+		campaignCtx, cancel := l.clock.WithTimeout(ctx, l.ttl/4)
+		if _, err := l.Campaign(campaignCtx); err != nil {
+			logger.Warn("campaign failed", "election", l.name, "error", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,73 @@
+package election
+
+import (
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Lease sets
+
+For something that needs a leader per key, such as an owner per kafka log, Leases keeps a lease for each
+key, created the first time it's asked for and dropped by the caller once it's no longer needed. Their
+state shows in admin_status together, as election.<name> keyed by the leases' keys, rather than as a
+module per lease.
+*/
+
+type Leases struct {
+	node *maelstrom.Node
+	kv   kvutil.KV
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	leases map[string]*Lease
+}
+
+// Returns an empty set of leases kept in kv, shown in admin_status as election.<name>
+func NewLeases(node *maelstrom.Node, kv kvutil.KV, name string) (*Leases, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Leases{node: node, kv: kv, ttl: cfg.Lease, leases: make(map[string]*Lease)}
+	admin.Of(node).Register("election."+name, admin.Module{Status: func() any { return s.Status() }})
+	return s, nil
+}
+
+// Returns the lease at key, creating it with callbacks if there isn't one
+func (s *Leases) Get(key string, callbacks Callbacks) *Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, ok := s.leases[key]
+	if !ok {
+		lease = newLease(s.node, s.kv, key, s.ttl, callbacks)
+		s.leases[key] = lease
+	}
+	return lease
+}
+
+// Drops the lease at key, a later Get creates a new one
+// A dropped lease isn't given up, it runs out once nothing campaigns for it
+func (s *Leases) Drop(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, key)
+}
+
+// Returns each lease's status by key
+func (s *Leases) Status() map[string]Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make(map[string]Status, len(s.leases))
+	for key, lease := range s.leases {
+		status[key] = lease.Status()
+	}
+	return status
+}
@@ -0,0 +1,115 @@
+package env
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Node environment
+
+The clock and random numbers a node's protocols run on. Normally that's the wall clock and a randomly
+seeded source, but the simulator's deterministic mode swaps in a virtual clock and a seeded source with
+Set before registering a workload, so a run with the same seed makes the same choices at the same
+(virtual) times. Code that should be reproducible that way gets its timers, timeouts and random numbers
+from Of(node) rather than from the time and rand packages.
+*/
+
+type Clock interface {
+	Now() time.Time
+
+	// Returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+
+	// Returns a ticker firing every d, which drops ticks for a slow receiver like time.Ticker
+	NewTicker(d time.Duration) Ticker
+
+	// Returns a copy of ctx that's cancelled with context.DeadlineExceeded once d has passed
+	WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type Env struct {
+	Clock Clock
+	Rand  *rand.Rand // safe for concurrent use
+}
+
+var (
+	mu   sync.Mutex
+	envs = map[*maelstrom.Node]*Env{}
+)
+
+// Sets node's environment, before anything has asked for it
+func Set(node *maelstrom.Node, e *Env) {
+	mu.Lock()
+	defer mu.Unlock()
+	envs[node] = e
+}
+
+// Returns node's environment, the wall clock and a random source unless Set gave it another
+func Of(node *maelstrom.Node) *Env {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, ok := envs[node]
+	if !ok {
+		e = &Env{Clock: Real{}, Rand: NewRand(rand.Uint64())}
+		envs[node] = e
+	}
+	return e
+}
+
+// Returns a generator seeded with seed that's safe for concurrent use
+func NewRand(seed uint64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewPCG(seed, seed)})
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+// The wall clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (Real) WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
@@ -0,0 +1,129 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Test doubles
+
+Stand-ins for the services workloads depend on, for unit tests that don't need a whole simulated cluster
+(see internal/sim): a KV kept in memory that answers like Maelstrom's, and a clock that only moves when the
+test advances it.
+*/
+
+// A KV in memory storing values as JSON, failing like Maelstrom's with KeyDoesNotExist and PreconditionFailed
+type KV struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func NewKV() *KV {
+	return &KV{data: map[string][]byte{}}
+}
+
+func (kv *KV) Read(ctx context.Context, key string) (any, error) {
+	var v any
+	if err := kv.ReadInto(ctx, key, &v); err != nil {
+		return nil, err
+	}
+
+	// Numbers come back as ints, like the maelstrom client
+	if f, ok := v.(float64); ok {
+		return int(f), nil
+	}
+	return v, nil
+}
+
+func (kv *KV) ReadInt(ctx context.Context, key string) (int, error) {
+	v, err := kv.Read(ctx, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+func (kv *KV) ReadInto(ctx context.Context, key string, v any) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	data, ok := kv.data[key]
+	if !ok {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (kv *KV) Write(ctx context.Context, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.data[key] = data
+	return nil
+}
+
+func (kv *KV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	data, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	current, ok := kv.data[key]
+	switch {
+	case !ok && !createIfNotExists:
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	case ok && !jsonEqual(current, from):
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "current value doesn't match")
+	}
+	kv.data[key] = data
+	return nil
+}
+
+// Compares a stored JSON value against a Go value after normalising both through JSON
+func jsonEqual(data []byte, v any) bool {
+	want, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+
+	var a, b any
+	if json.Unmarshal(data, &a) != nil || json.Unmarshal(want, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// An env.Clock whose Now only moves with Advance, its timers and tickers still run in real time
+type Clock struct {
+	env.Real
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,401 @@
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Gossip
+
+Spreads a state that only ever grows by merging, such as a set of messages or a grow-only counter, to
+every node without any of them waiting on the others. The state is described by a Store: deltas can be
+merged into it in any order and any number of times with the same result. The engine calls its methods
+from several goroutines at once.
+
+Local changes are given to Update, which merges them into the store and queues them for every peer.
+Every Interval the engine sends each of Fanout random peers (all of them if Fanout is 0) everything
+queued for it since its last successful round, joined into a single <name>_gossip message. Deltas for
+peers that weren't picked, or that didn't answer within Timeout, stay queued and go out with the next
+round they're picked for, so a batch retries until it's delivered.
+
+A delta that's lost for good (say the sender restarted) would leave peers apart forever, so every
+DigestEvery rounds a peer with nothing queued is sent a digest of the state instead. If the peer's
+digest differs, it answers with its full state, which is merged, and the full local state is queued
+for it in return. A store that's also Ranged keeps a Merkle tree over its keys (see internal/merkle) and
+uses its root as the digest; on a mismatch the two trees are compared with <name>_merkle, and only the
+buckets that differ are exchanged, with <name>_repair, instead of the full states.
+
+Rounds, deltas sent, failed sends and digest checks and mismatches are counted in the node's metrics as
+gossip.<name>.rounds, .sent, .failed, .digests and .mismatches, with .pending the peers waiting on a delta.
+
+When the node shuts down (see internal/lifecycle), whatever is still queued is flushed to every peer.
+*/
+
+type Store[D any] interface {
+	// Merges a delta, from this node or a peer, into the state
+	Merge(delta D)
+
+	// Returns a delta holding everything in both a and b
+	Join(a, b D) D
+
+	// Returns the whole state as a delta
+	Full() D
+
+	// Returns a hash of the state, equal on two nodes exactly when their states are
+	Digest() uint64
+}
+
+// Implemented by stores that keep a Merkle tree over their keys, whose root is their Digest
+type Ranged[D any] interface {
+	Tree() *merkle.Tree
+
+	// Returns the part of the state in the given buckets of the tree
+	Buckets(buckets []int) D
+}
+
+type Config struct {
+	Name        string        // prefix of the engine's message types
+	Interval    time.Duration // time between rounds
+	Fanout      int           // peers sent to per round, 0 for all of them
+	Timeout     time.Duration // how long a peer has to answer
+	DigestEvery int           // rounds between digest checks, 0 to never check
+}
+
+type Engine[D any] struct {
+	node   *maelstrom.Node
+	env    *env.Env
+	store  Store[D]
+	config Config
+
+	mu      sync.Mutex
+	peers   []string
+	pending map[string]D    // joined deltas not yet delivered to each peer
+	busy    map[string]bool // peers with a round in flight
+	rounds  int
+
+	roundsCount, sent, failed, digests, mismatches *metrics.Counter
+}
+
+type DeltaRequestBody[D any] struct {
+	Type  string `json:"type"`
+	Delta D      `json:"delta"`
+}
+
+type DeltaResponseBody struct {
+	Type string `json:"type"`
+}
+
+type DigestRequestBody struct {
+	Type string `json:"type"`
+
+	// A string, as RPC decodes requests into a map[string]any and a uint64 wouldn't survive as a float64
+	Digest uint64 `json:"digest,string"`
+}
+
+type DigestResponseBody[D any] struct {
+	Type  string `json:"type"`
+	Match bool   `json:"match"`
+	State *D     `json:"state,omitempty"`
+}
+
+type RepairRequestBody[D any] struct {
+	Type    string `json:"type"`
+	Buckets []int  `json:"buckets"`
+	Delta   D      `json:"delta"`
+}
+
+type RepairResponseBody[D any] struct {
+	Type  string `json:"type"`
+	Delta D      `json:"delta"`
+}
+
+// Creates an engine spreading store's state and registers its handlers on node
+func New[D any](node *maelstrom.Node, store Store[D], config Config) *Engine[D] {
+	e := &Engine[D]{
+		node:    node,
+		env:     env.Of(node),
+		store:   store,
+		config:  config,
+		pending: map[string]D{},
+		busy:    map[string]bool{},
+	}
+
+	registry := metrics.Of(node)
+	prefix := "gossip." + config.Name + "."
+	e.roundsCount = registry.Counter(prefix + "rounds")
+	e.sent = registry.Counter(prefix + "sent")
+	e.failed = registry.Counter(prefix + "failed")
+	e.digests = registry.Counter(prefix + "digests")
+	e.mismatches = registry.Counter(prefix + "mismatches")
+	registry.GaugeFunc(prefix+"pending", func() float64 {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return float64(len(e.pending))
+	})
+
+	handler.Handle(node, config.Name+"_gossip", func(msg maelstrom.Message, body DeltaRequestBody[D]) (DeltaResponseBody, error) {
+		e.store.Merge(body.Delta)
+		return DeltaResponseBody{}, nil
+	})
+
+	handler.Handle(node, config.Name+"_digest", func(msg maelstrom.Message, body DigestRequestBody) (DigestResponseBody[D], error) {
+		if body.Digest == e.store.Digest() {
+			return DigestResponseBody[D]{Match: true}, nil
+		}
+		if _, ok := e.store.(Ranged[D]); ok {
+			// The sender finds out what differs with <name>_merkle
+			return DigestResponseBody[D]{}, nil
+		}
+
+		state := e.store.Full()
+		return DigestResponseBody[D]{State: &state}, nil
+	})
+
+	if ranged, ok := store.(Ranged[D]); ok {
+		merkle.Handle(node, config.Name+"_merkle", ranged.Tree())
+
+		handler.Handle(node, config.Name+"_repair", func(msg maelstrom.Message, body RepairRequestBody[D]) (RepairResponseBody[D], error) {
+			// Taken before merging, so the sender gets back what it lacked
+			delta := ranged.Buckets(body.Buckets)
+			e.store.Merge(body.Delta)
+			return RepairResponseBody[D]{Delta: delta}, nil
+		})
+	}
+
+	lifecycle.Of(node).OnStop("gossip."+config.Name, e.Flush)
+	admin.Of(node).Register("gossip."+config.Name, admin.Module{Status: e.status, Flush: e.Flush})
+
+	return e
+}
+
+// Sets the nodes to gossip with, new ones are sent the whole state
+func (e *Engine[D]) SetPeers(peers []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, peer := range peers {
+		if !slices.Contains(e.peers, peer) {
+			e.pending[peer] = e.store.Full()
+		}
+	}
+	for peer := range e.pending {
+		if !slices.Contains(peers, peer) {
+			delete(e.pending, peer)
+		}
+	}
+	e.peers = slices.Clone(peers)
+}
+
+// Merges a local change into the store and queues it for every peer
+func (e *Engine[D]) Update(delta D) {
+	e.store.Merge(delta)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, peer := range e.peers {
+		e.queue(peer, delta)
+	}
+}
+
+// Must be called with e.mu held
+func (e *Engine[D]) queue(peer string, delta D) {
+	if pending, ok := e.pending[peer]; ok {
+		e.pending[peer] = e.store.Join(pending, delta)
+	} else {
+		e.pending[peer] = delta
+	}
+}
+
+// Sends every peer everything queued for it at once, without waiting for a round
+// Returns an error if any of them didn't acknowledge, their deltas are queued again
+func (e *Engine[D]) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = map[string]D{}
+	e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for peer, delta := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rpcCtx, cancel := e.env.Clock.WithTimeout(ctx, e.config.Timeout)
+			defer cancel()
+
+			_, err := e.node.SyncRPC(rpcCtx, peer, DeltaRequestBody[D]{Type: e.config.Name + "_gossip", Delta: delta})
+			e.sent.Inc()
+			if err == nil {
+				return
+			}
+			e.failed.Inc()
+
+			e.mu.Lock()
+			e.queue(peer, delta)
+			e.mu.Unlock()
+
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("flushing to %s: %w", peer, err))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Runs a round every interval until ctx is cancelled
+func (e *Engine[D]) Run(ctx context.Context) {
+	ticker := e.env.Clock.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				e.round()
+			}
+		}
+	}
+}
+
+// Returns the peers, how many of them have deltas waiting and the rounds so far, for admin_status
+func (e *Engine[D]) status() any {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return map[string]any{"peers": e.peers, "pending": len(e.pending), "rounds": e.rounds}
+}
+
+func (e *Engine[D]) round() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rounds++
+	e.roundsCount.Inc()
+	checkDigests := e.config.DigestEvery > 0 && e.rounds%e.config.DigestEvery == 0
+
+	peers := slices.Clone(e.peers)
+	e.env.Rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if e.config.Fanout > 0 {
+		peers = peers[:min(len(peers), e.config.Fanout)]
+	}
+
+	for _, peer := range peers {
+		// A peer still answering the last round gets everything queued since in the next one
+		if e.busy[peer] {
+			continue
+		}
+
+		if delta, ok := e.pending[peer]; ok {
+			delete(e.pending, peer)
+			e.busy[peer] = true
+			go e.send(peer, delta)
+		} else if checkDigests {
+			e.busy[peer] = true
+			go e.checkDigest(peer, e.store.Digest())
+		}
+	}
+}
+
+// Sends peer a delta, queueing it again if the peer doesn't acknowledge it
+func (e *Engine[D]) send(peer string, delta D) {
+	ctx, cancel := e.env.Clock.WithTimeout(context.Background(), e.config.Timeout)
+	defer cancel()
+
+	_, err := e.node.SyncRPC(ctx, peer, DeltaRequestBody[D]{Type: e.config.Name + "_gossip", Delta: delta})
+	e.sent.Inc()
+	if err != nil {
+		e.failed.Inc()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.busy, peer)
+	if err != nil && slices.Contains(e.peers, peer) {
+		e.queue(peer, delta)
+	}
+}
+
+// Compares digests with peer, exchanging full states if they differ
+func (e *Engine[D]) checkDigest(peer string, digest uint64) {
+	ctx, cancel := e.env.Clock.WithTimeout(context.Background(), e.config.Timeout)
+	defer cancel()
+
+	msg, err := e.node.SyncRPC(ctx, peer, DigestRequestBody{Type: e.config.Name + "_digest", Digest: digest})
+	e.digests.Inc()
+
+	var body DigestResponseBody[D]
+	if err == nil {
+		err = json.Unmarshal(msg.Body, &body)
+	}
+	if err == nil && body.State != nil {
+		e.mismatches.Inc()
+		e.store.Merge(*body.State)
+	}
+
+	ranged, isRanged := e.store.(Ranged[D])
+	if err == nil && !body.Match && isRanged {
+		e.mismatches.Inc()
+		if err := e.repair(peer, ranged); err != nil {
+			e.failed.Inc()
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.busy, peer)
+	if err == nil && !body.Match && !isRanged && slices.Contains(e.peers, peer) {
+		e.queue(peer, e.store.Full())
+	}
+}
+
+// Exchanges with peer the parts of the state in the buckets where their trees differ
+func (e *Engine[D]) repair(peer string, ranged Ranged[D]) error {
+	tree := ranged.Tree()
+
+	// One exchange per level of the tree and one for the repair itself
+	ctx, cancel := e.env.Clock.WithTimeout(context.Background(), time.Duration(tree.Depth()+2)*e.config.Timeout)
+	defer cancel()
+
+	buckets, err := tree.Diff(merkle.Remote(ctx, e.node, peer, e.config.Name+"_merkle"))
+	if err != nil || len(buckets) == 0 {
+		return err
+	}
+
+	msg, err := e.node.SyncRPC(ctx, peer, RepairRequestBody[D]{
+		Type:    e.config.Name + "_repair",
+		Buckets: buckets,
+		Delta:   ranged.Buckets(buckets),
+	})
+	if err != nil {
+		return err
+	}
+
+	var body RepairResponseBody[D]
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	e.store.Merge(body.Delta)
+	return nil
+}
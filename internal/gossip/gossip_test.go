@@ -0,0 +1,263 @@
+package gossip
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// A grow-only set of strings, its deltas sorted slices of them
+type set struct {
+	mu    sync.Mutex
+	items map[string]bool
+	tree  *merkle.Tree
+}
+
+func newSet() *set {
+	return &set{items: map[string]bool{}, tree: merkle.New(3)}
+}
+
+func (s *set) Merge(delta []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range delta {
+		s.items[item] = true
+		s.tree.Set(item, 1)
+	}
+}
+
+func (s *set) Join(a, b []string) []string {
+	joined := slices.Concat(a, b)
+	slices.Sort(joined)
+	return slices.Compact(joined)
+}
+
+func (s *set) Full() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]string, 0, len(s.items))
+	for item := range s.items {
+		items = append(items, item)
+	}
+	slices.Sort(items)
+	return items
+}
+
+func (s *set) Digest() uint64 {
+	return s.tree.Root()
+}
+
+// The same set, repaired bucket by bucket rather than with full states
+type rangedSet struct {
+	*set
+}
+
+func (s rangedSet) Tree() *merkle.Tree {
+	return s.tree
+}
+
+func (s rangedSet) Buckets(buckets []int) []string {
+	var items []string
+	for _, item := range s.Full() {
+		if slices.Contains(buckets, s.tree.Bucket(item)) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Starts a cluster of n0, n1 and n2 with an engine on each, whose rounds the test runs by hand
+func start(t *testing.T, config Config, ranged bool) (*sim.Cluster, []*Engine[[]string]) {
+	t.Helper()
+
+	config.Name = "test"
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+
+	var engines []*Engine[[]string]
+	c, err := sim.Start([]string{"n0", "n1", "n2"}, func(n *maelstrom.Node) error {
+		var store Store[[]string] = newSet()
+		if ranged {
+			store = rangedSet{store.(*set)}
+		}
+		engines = append(engines, New(n, store, config))
+		return nil
+	}, sim.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, engines
+}
+
+// Runs a round and waits for every exchange it started to finish
+func round(t *testing.T, e *Engine[[]string]) {
+	t.Helper()
+	e.round()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.mu.Lock()
+		busy := len(e.busy)
+		e.mu.Unlock()
+		if busy == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("round still in flight after 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func pending(e *Engine[[]string], peer string) ([]string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delta, ok := e.pending[peer]
+	return delta, ok
+}
+
+func items(e *Engine[[]string]) []string {
+	return e.store.Full()
+}
+
+func TestUpdateQueuesForEachPeer(t *testing.T) {
+	_, engines := start(t, Config{Fanout: 1}, false)
+	a := engines[0]
+	a.SetPeers([]string{"n1", "n2"})
+
+	a.Update([]string{"x"})
+	for _, peer := range []string{"n1", "n2"} {
+		if delta, _ := pending(a, peer); !slices.Equal(delta, []string{"x"}) {
+			t.Fatalf("queued %v for %s, want [x]", delta, peer)
+		}
+	}
+
+	// Only the peer picked is sent its delta, the other keeps it queued
+	round(t, a)
+	picked, skipped := engines[1], "n2"
+	if _, ok := pending(a, "n1"); ok {
+		picked, skipped = engines[2], "n1"
+		if _, ok := pending(a, "n2"); ok {
+			t.Fatal("neither peer was sent its delta")
+		}
+	}
+	if got := items(picked); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("picked peer has %v, want [x]", got)
+	}
+
+	// Later changes are joined onto what's still queued
+	a.Update([]string{"y"})
+	if delta, _ := pending(a, skipped); !slices.Equal(delta, []string{"x", "y"}) {
+		t.Errorf("queued %v for %s, want [x y]", delta, skipped)
+	}
+}
+
+func TestUnacknowledgedDeltasRetry(t *testing.T) {
+	c, engines := start(t, Config{Timeout: 100 * time.Millisecond}, false)
+	a, b := engines[0], engines[1]
+	a.SetPeers([]string{"n1"})
+
+	var mu sync.Mutex
+	cut := true
+	c.Filter(func(msg maelstrom.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !cut || msg.Dest != "n1"
+	})
+
+	a.Update([]string{"x"})
+	round(t, a)
+	if delta, _ := pending(a, "n1"); !slices.Equal(delta, []string{"x"}) {
+		t.Fatalf("queued %v after a lost delta, want [x] again", delta)
+	}
+	if a.failed.Value() != 1 {
+		t.Errorf("failed = %d, want 1", a.failed.Value())
+	}
+
+	mu.Lock()
+	cut = false
+	mu.Unlock()
+
+	// The retry carries the lost delta along with what came after it
+	a.Update([]string{"y"})
+	round(t, a)
+	if got := items(b); !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("n1 has %v, want [x y]", got)
+	}
+	if _, ok := pending(a, "n1"); ok {
+		t.Error("delta still queued after it was acknowledged")
+	}
+}
+
+func TestDigestsRepairLostDeltas(t *testing.T) {
+	for _, ranged := range []bool{false, true} {
+		_, engines := start(t, Config{DigestEvery: 1}, ranged)
+		a, b := engines[0], engines[1]
+		a.SetPeers([]string{"n1"})
+		b.SetPeers([]string{"n0"})
+		round(t, a)
+		round(t, b)
+
+		// Changes that never went through Update, as if their deltas were lost for good
+		a.store.Merge([]string{"a1", "a2"})
+		b.store.Merge([]string{"b1"})
+
+		round(t, a)
+		if ranged {
+			// Both sides get the differing buckets in the repair
+			if _, ok := pending(a, "n1"); ok {
+				t.Error("a Merkle repair queued the full state")
+			}
+		} else {
+			round(t, a)
+		}
+
+		want := []string{"a1", "a2", "b1"}
+		for i, e := range []*Engine[[]string]{a, b} {
+			if got := items(e); !slices.Equal(got, want) {
+				t.Errorf("ranged %t: n%d has %v, want %v", ranged, i, got, want)
+			}
+		}
+		if a.mismatches.Value() != 1 {
+			t.Errorf("ranged %t: mismatches = %d, want 1", ranged, a.mismatches.Value())
+		}
+
+		// With the states equal, the next check finds nothing to do
+		round(t, a)
+		if a.mismatches.Value() != 1 || a.digests.Value() < 2 {
+			t.Errorf("ranged %t: %d mismatches after %d digests, want still 1", ranged, a.mismatches.Value(), a.digests.Value())
+		}
+	}
+}
+
+func TestNewPeersAreSentTheFullState(t *testing.T) {
+	_, engines := start(t, Config{}, false)
+	a := engines[0]
+	a.Update([]string{"x", "y"})
+
+	a.SetPeers([]string{"n1"})
+	if delta, _ := pending(a, "n1"); !slices.Equal(delta, []string{"x", "y"}) {
+		t.Fatalf("queued %v for a new peer, want the full state", delta)
+	}
+
+	// A peer that's removed is dropped from the queue, one that stays isn't sent the state again
+	a.SetPeers([]string{"n2"})
+	if _, ok := pending(a, "n1"); ok {
+		t.Error("still queued for a removed peer")
+	}
+	round(t, a)
+	if got := items(engines[2]); !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("n2 has %v, want [x y]", got)
+	}
+	a.SetPeers([]string{"n2"})
+	if delta, ok := pending(a, "n2"); ok {
+		t.Errorf("queued %v for a peer it already had", delta)
+	}
+}
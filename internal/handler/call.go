@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Exact calls
+
+node.RPC round-trips a request through a map of float64s before sending it, so an integer beyond 2^53
+anywhere in it, a key in a forwarded client request or a value in a replicated log entry, arrives as a
+neighbouring one. Call sends the request's JSON as a string instead, in the body's "exact" field next to its
+type, and Handle decodes a body carrying one from that string, so the handler sees exactly what was sent.
+Notify does the same for a request whose reply nobody waits for.
+*/
+
+// The body Call sends in place of the request
+type exactBody struct {
+	Type  string `json:"type"`
+	Exact string `json:"exact"`
+}
+
+// Sends request to dest, waits for the reply until ctx is done and decodes it into resp
+// An error reply is returned as its *maelstrom.RPCError. The handler for request's type on dest must be
+// registered with Handle
+func Call(ctx context.Context, n *maelstrom.Node, dest string, request any, resp any) error {
+	body, err := exact(request)
+	if err != nil {
+		return err
+	}
+
+	msg, err := n.SyncRPC(ctx, dest, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Body, resp)
+}
+
+// Sends request to dest like Call, without waiting for the reply
+func Notify(n *maelstrom.Node, dest string, request any) error {
+	body, err := exact(request)
+	if err != nil {
+		return err
+	}
+	return n.RPC(dest, body, func(maelstrom.Message) error { return nil })
+}
+
+// Wraps request's JSON in the body Call sends
+func exact(request any) (exactBody, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return exactBody{}, err
+	}
+
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return exactBody{}, err
+	}
+	return exactBody{Type: body.Type, Exact: string(data)}, nil
+}
+
+// Returns the request carried by a body Call sent, with the body's msg_id, or data itself for any other body
+func unwrap(data json.RawMessage) (json.RawMessage, error) {
+	var envelope struct {
+		Exact *string         `json:"exact"`
+		MsgID json.RawMessage `json:"msg_id"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Exact == nil {
+		return data, err
+	}
+
+	request := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(*envelope.Exact), &request); err != nil {
+		return nil, err
+	}
+	if envelope.MsgID != nil {
+		request["msg_id"] = envelope.MsgID
+	}
+	return json.Marshal(request)
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Typed handlers
+
+Almost every handler starts by unmarshalling the body into its request struct and ends by replying with
+its response struct. Handle does both around a function that only sees the typed request:
+  - a body that doesn't unmarshal, or whose request struct has a Validate method that fails, is
+    answered with MalformedRequest without calling the function
+  - the function's response is sent as the reply, its type defaulting to "<type>_ok" if left empty
+  - an error from the function is sent as an RPC error: an *maelstrom.RPCError anywhere in its chain
+    keeps its code, an expired context becomes Timeout, anything else is Crash
+  - every request is counted, timed and, if answered with an error, counted again in the node's metrics
+    as handler.<type>.requests, .latency_ms and .errors, and logged at debug level
+  - a request sent with Call is decoded from the exact JSON it carries, see Call
+
+Replies keep large integers exact, unlike node.Reply, which round-trips bodies through float64.
+*/
+
+var logger = logging.For("handler")
+
+// Implemented by request bodies that check their own fields
+type Validator interface {
+	Validate() error
+}
+
+// Registers handle for messages of type typ on n
+func Handle[Req, Resp any](n *maelstrom.Node, typ string, handle func(msg maelstrom.Message, req Req) (Resp, error)) {
+	registry := metrics.Of(n)
+	requests := registry.Counter("handler." + typ + ".requests")
+	errs := registry.Counter("handler." + typ + ".errors")
+	latency := registry.Histogram("handler." + typ + ".latency_ms")
+
+	n.Handle(typ, func(msg maelstrom.Message) (err error) {
+		requests.Inc()
+		start := time.Now()
+		defer func() {
+			latency.Since(start)
+			if err != nil {
+				errs.Inc()
+			}
+
+			ctx := logging.WithMessage(context.Background(), msg)
+			if err != nil {
+				logger.DebugContext(ctx, "request failed", "duration_ms", latencyMs(start), "error", err)
+			} else {
+				logger.DebugContext(ctx, "request handled", "duration_ms", latencyMs(start))
+			}
+		}()
+
+		// A request sent with Call is decoded from the string it carries
+		if msg.Body, err = unwrap(msg.Body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		var req Req
+
+		if err := json.Unmarshal(msg.Body, &req); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		if err := validate(&req); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		resp, err := handle(msg, req)
+		if err != nil {
+			return RPCError(err)
+		}
+
+		return Reply(n, msg, typ+"_ok", resp)
+	})
+}
+
+func latencyMs(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+// Calls Validate if req has it, on either the value or the pointer
+func validate[Req any](req *Req) error {
+	if v, ok := any(req).(Validator); ok {
+		return v.Validate()
+	}
+	if v, ok := any(*req).(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// Returns err as the RPC error to reply with
+func RPCError(err error) *maelstrom.RPCError {
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return maelstrom.NewRPCError(maelstrom.Timeout, err.Error())
+	}
+	return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+}
+
+// Replies to msg with body, setting its type to typ if body doesn't have one
+func Reply(n *maelstrom.Node, msg maelstrom.Message, typ string, body any) error {
+	var req maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	// Numbers are kept as written, so the reply carries exactly what body did
+	reply := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&reply); err != nil {
+		return err
+	}
+
+	if t, _ := reply["type"].(string); t == "" {
+		reply["type"] = typ
+	}
+	reply["in_reply_to"] = req.MsgID
+
+	return n.Send(msg.Src, reply)
+}
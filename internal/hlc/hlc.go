@@ -0,0 +1,123 @@
+package hlc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+Hybrid logical clocks
+
+Timestamps that stay close to wall time but, like a logical clock, are ahead of every timestamp the
+clock has issued or received, so they order causally related events correctly even when the nodes'
+wall clocks disagree. Wall is the largest wall time seen, in milliseconds, and Logical counts events
+since it last moved forward. Node breaks the remaining ties, so timestamps of different nodes are never
+equal.
+
+A timestamp received from a node whose wall clock runs far ahead would drag every clock it reaches
+along with it. With a maximum drift set, Update refuses timestamps more than that ahead of the local
+wall clock, leaving the clock where it is, and counts them so the skew can be noticed.
+*/
+
+var ErrDrift = errors.New("hlc: timestamp too far ahead of the local clock")
+
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical int64  `json:"logical"`
+	Node    string `json:"node"`
+}
+
+// Returns true if t orders before other
+func (t Timestamp) Less(other Timestamp) bool {
+	if t.Wall != other.Wall {
+		return t.Wall < other.Wall
+	}
+	if t.Logical != other.Logical {
+		return t.Logical < other.Logical
+	}
+	return t.Node < other.Node
+}
+
+type Clock struct {
+	mu       sync.Mutex
+	node     string
+	last     Timestamp
+	maxDrift time.Duration // 0 for no limit
+	drifted  int64         // timestamps refused for drifting too far ahead
+	wall     func() int64  // milliseconds since the epoch
+}
+
+func New() *Clock {
+	return &Clock{wall: func() int64 { return time.Now().UnixMilli() }}
+}
+
+// Sets the wall clock timestamps follow, time.Now unless it's replaced
+func (c *Clock) SetWallClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = func() int64 { return now().UnixMilli() }
+}
+
+// Sets the node ID stamped on timestamps, called once the node is initialized
+func (c *Clock) SetNode(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.node = node
+}
+
+// Sets how far ahead of the local wall clock a received timestamp may be, 0 for no limit
+func (c *Clock) SetMaxDrift(maxDrift time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxDrift = maxDrift
+}
+
+// Returns a new timestamp for a local event
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wall := c.wall(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+
+	c.last.Node = c.node
+	return c.last
+}
+
+// Advances the clock past a timestamp received from another node and returns the receipt's timestamp
+// Returns ErrDrift without advancing the clock if t is further ahead of the wall clock than the maximum drift
+func (c *Clock) Update(t Timestamp) (Timestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.wall()
+	if c.maxDrift > 0 && t.Wall-wall > c.maxDrift.Milliseconds() {
+		c.drifted++
+		return c.last, ErrDrift
+	}
+
+	switch next := max(c.last.Wall, t.Wall, wall); {
+	case next == c.last.Wall && next == t.Wall:
+		c.last.Logical = max(c.last.Logical, t.Logical) + 1
+	case next == c.last.Wall:
+		c.last.Logical++
+	case next == t.Wall:
+		c.last = Timestamp{Wall: next, Logical: t.Logical + 1}
+	default:
+		c.last = Timestamp{Wall: next}
+	}
+
+	c.last.Node = c.node
+	return c.last, nil
+}
+
+// Returns how many received timestamps were refused for drifting too far ahead
+func (c *Clock) Drifted() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drifted
+}
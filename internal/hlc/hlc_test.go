@@ -0,0 +1,71 @@
+package hlc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Returns a clock for node n1 that last issued last, with its wall clock stopped at wall
+func newClock(last Timestamp, wall int64) *Clock {
+	c := New()
+	c.SetNode("n1")
+	c.SetWallClock(func() time.Time { return time.UnixMilli(wall) })
+	c.last = last
+	return c
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		last     Timestamp
+		wall     int64
+		received Timestamp
+		want     Timestamp
+	}{
+		{"local wall ahead", Timestamp{Wall: 100, Logical: 3}, 200, Timestamp{Wall: 150, Logical: 5}, Timestamp{Wall: 200}},
+		{"received ahead", Timestamp{Wall: 100, Logical: 3}, 100, Timestamp{Wall: 300, Logical: 5}, Timestamp{Wall: 300, Logical: 6}},
+		{"equal walls", Timestamp{Wall: 200, Logical: 3}, 100, Timestamp{Wall: 200, Logical: 7}, Timestamp{Wall: 200, Logical: 8}},
+		{"last ahead, logical bump", Timestamp{Wall: 300, Logical: 3}, 100, Timestamp{Wall: 200, Logical: 9}, Timestamp{Wall: 300, Logical: 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock(tt.last, tt.wall)
+			tt.received.Node = "n2"
+			tt.want.Node = "n1"
+
+			got, err := c.Update(tt.received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("update = %+v, want %+v", got, tt.want)
+			}
+			if !tt.received.Less(got) {
+				t.Fatalf("update %+v doesn't order after the received %+v", got, tt.received)
+			}
+		})
+	}
+}
+
+func TestMaxDrift(t *testing.T) {
+	last := Timestamp{Wall: 1000, Logical: 2, Node: "n1"}
+	c := newClock(last, 1000)
+	c.SetMaxDrift(50 * time.Millisecond)
+
+	got, err := c.Update(Timestamp{Wall: 1100, Node: "n2"})
+	if !errors.Is(err, ErrDrift) {
+		t.Fatalf("update 100ms ahead returned %v, want ErrDrift", err)
+	}
+	if got != last || c.Drifted() != 1 {
+		t.Fatalf("update %+v with %d drifted, want the clock left at %+v and 1 drifted", got, c.Drifted(), last)
+	}
+
+	if got, err := c.Update(Timestamp{Wall: 1040, Node: "n2"}); err != nil || got.Wall != 1040 {
+		t.Fatalf("update 40ms ahead = %+v, %v, want it taken", got, err)
+	}
+	if c.Drifted() != 1 {
+		t.Fatalf("%d drifted, want 1", c.Drifted())
+	}
+}
@@ -0,0 +1,342 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Broker
+
+All of the log logic lives here, independent of how messages reach the node.
+It only talks to the outside world through the KV and Clock interfaces, so tests can run it
+against an in-memory KV and a fixed clock instead of Maelstrom's lin-kv service.
+
+KV layout:
+  <key>/highest_offset                     highest offset reserved in the log
+  <key>/owner                              the log owner's lease, with KAFKA_OWNERSHIP=lease
+  <key>/start_offset                       first offset not yet removed by retention
+  <key>/data/<offset>                      LogEntry stored at an offset
+  <key>/committed_offset                   committed offset of the default consumer group
+  <key>/groups/<group>/committed_offset    committed offset of a named consumer group
+  logs                                     names of all logs, since lin-kv can't list its keys
+*/
+
+// KV key holding the names of all logs
+const logsKey = "logs"
+
+// Custom Maelstrom error code (codes 1000 and above are free for services to define)
+// returned when a stored entry fails its checksum
+const ChecksumMismatch = 1000
+
+// The subset of the maelstrom KV client used by the broker, satisfied by *maelstrom.KV
+type KV interface {
+	Read(ctx context.Context, key string) (any, error)
+	ReadInt(ctx context.Context, key string) (int, error)
+	ReadInto(ctx context.Context, key string, v any) error
+	Write(ctx context.Context, key string, value any) error
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
+
+// Source of the timestamps stamped on log entries
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Stamps entries with a hybrid logical clock's wall time, which never goes back, even after a log moves
+// to a node whose wall clock is behind its previous owner's, see Ownership.Receive
+type hybridClock struct {
+	clock *hlc.Clock
+}
+
+func (c hybridClock) Now() time.Time { return time.UnixMilli(c.clock.Now().Wall) }
+
+// A single message stored in the log, stamped with its append time for retention
+// and a CRC over its contents and position so corrupted or misplaced entries are detected on poll
+type LogEntry struct {
+	Message   int    `json:"msg"`
+	Timestamp int64  `json:"ts"`
+	Checksum  uint32 `json:"crc"`
+}
+
+type Broker struct {
+	kv        KV
+	clock     Clock
+	ownership *Ownership
+	mirror    *Mirror // nil if mirroring is disabled
+	stats     *Stats
+
+	mu         sync.Mutex
+	registered map[string]bool // logs this process has registered
+}
+
+func NewBroker(kv KV, clock Clock, ownership *Ownership, mirror *Mirror) *Broker {
+	return &Broker{
+		kv:         kv,
+		clock:      clock,
+		ownership:  ownership,
+		mirror:     mirror,
+		stats:      &Stats{},
+		registered: make(map[string]bool),
+	}
+}
+
+// Appends a message to a log owned by this node and returns its offset
+func (b *Broker) Send(ctx context.Context, key string, message int) (int, error) {
+	// Step 1: Reserve the next offset for this key
+	offset, err := b.ownership.NextOffset(ctx, key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Registered so background tasks can find the log, by the first send to it in every process rather
+	// than the one taking offset 0, which may have crashed in between
+	if err := b.register(ctx, key); err != nil {
+		return 0, err
+	}
+
+	// Step 2: Write the message as a new key-value pair with the updated offset
+	logEntryKey := fmt.Sprintf("%s/data/%d", key, offset)
+
+	err = b.kv.Write(ctx, logEntryKey, NewLogEntry(key, offset, message, b.clock.Now().UnixMilli()))
+
+	if err != nil {
+		return 0, err
+	}
+
+	b.stats.sent.Add(1)
+
+	// Step 3: Copy the message into the mirror in the background
+	if b.mirror != nil {
+		b.mirror.Enqueue(MirrorEntry{Key: key, Offset: offset, Message: message})
+	}
+
+	return offset, nil
+}
+
+// Returns up to three messages starting from the given offset for each log
+func (b *Broker) Poll(ctx context.Context, offsets map[string]int) (map[string][][]int, error) {
+	messages := make(map[string][][]int)
+
+	for key, startOffset := range offsets {
+		logMessages, err := readMessages(ctx, b.kv, key, startOffset, 3, false)
+
+		if err != nil {
+			b.stats.CountError(err)
+			return nil, err
+		}
+
+		messages[key] = logMessages
+	}
+
+	b.stats.polled.Add(1)
+
+	return messages, nil
+}
+
+// Returns the highest offset reserved in a log, -1 if nothing was ever sent to it
+// An offset may be reserved a little before its entry is written, or never written if the sender crashed
+func (b *Broker) HighestOffset(ctx context.Context, key string) (int, error) {
+	return kvutil.Read(ctx, b.kv, fmt.Sprintf("%s/highest_offset", key), -1)
+}
+
+// Sets the committed offset for each key
+// Keeps old committed offset if it is greater than the new offset
+func (b *Broker) CommitOffsets(ctx context.Context, group string, offsets map[string]int) error {
+	for key, newOffset := range offsets {
+		// New committed offset is greater of old and new
+		_, err := kvutil.Update(ctx, b.kv, committedOffsetKey(key, group), 0, func(oldCommittedOffset int) (int, error) {
+			return max(oldCommittedOffset, newOffset), nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the committed offset of each given key, omitting keys with nothing committed
+func (b *Broker) ListCommittedOffsets(ctx context.Context, group string, keys []string) map[string]int {
+	offsets := make(map[string]int)
+
+	for _, key := range keys {
+		committedOffset, err := b.kv.ReadInt(ctx, committedOffsetKey(key, group))
+
+		if err == nil {
+			offsets[key] = committedOffset
+		}
+	}
+
+	return offsets
+}
+
+// Returns the next batch of messages after the group's committed offset and commits past them in one step
+// If another consumer in the group commits first, the CAS fails and the batch is re-read from the new offset,
+// so each message is handed to exactly one successful consume call per group
+func (b *Broker) Consume(ctx context.Context, group string, keys []string, limit int) (map[string][][]int, map[string]int, error) {
+	if limit <= 0 {
+		limit = 3
+	}
+
+	messages := make(map[string][][]int)
+	offsets := make(map[string]int)
+
+	for _, key := range keys {
+		// Start after the committed offset, or from the beginning if nothing was committed yet
+		// Commit the last offset in the batch, but only if nobody else moved the offset meanwhile
+		// The batch ends at an offset that's reserved but not written yet, committing past it would mean
+		// the group never gets that message
+		lastOffset, err := kvutil.Update(ctx, b.kv, committedOffsetKey(key, group), -1, func(committedOffset int) (int, error) {
+			logMessages, err := readMessages(ctx, b.kv, key, committedOffset+1, limit, true)
+
+			if err != nil {
+				b.stats.CountError(err)
+				return 0, err
+			}
+
+			messages[key] = logMessages
+
+			if len(logMessages) == 0 {
+				return 0, kvutil.ErrNoChange
+			}
+
+			return logMessages[len(logMessages)-1][0], nil
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(messages[key]) > 0 {
+			offsets[key] = lastOffset
+		}
+	}
+
+	return messages, offsets, nil
+}
+
+// Returns the KV key holding a consumer group's committed offset for a log
+// The empty group is the default group used by Maelstrom's workload
+func committedOffsetKey(key string, group string) string {
+	if group == "" {
+		return fmt.Sprintf("%s/committed_offset", key)
+	}
+
+	return fmt.Sprintf("%s/groups/%s/committed_offset", key, group)
+}
+
+// Returns the KV key holding the first offset still retained in a log
+func logStartOffsetKey(key string) string {
+	return fmt.Sprintf("%s/start_offset", key)
+}
+
+// Returns up to limit [offset, message] pairs from a log, starting at startOffset
+// Offsets before the log start offset have been removed by retention and are skipped. An offset that's
+// reserved but has no entry yet is skipped too, or ends the list if contiguous is set
+// Returns an empty list if the log doesn't exist, and a ChecksumMismatch error if an entry is corrupt
+func readMessages(ctx context.Context, kv KV, key string, startOffset int, limit int, contiguous bool) ([][]int, error) {
+	logMessages := [][]int{}
+
+	// Read highest offset for key
+	offsetKey := fmt.Sprintf("%s/highest_offset", key)
+
+	highestOffset, err := kv.ReadInt(ctx, offsetKey)
+
+	if err != nil {
+		return logMessages, nil
+	}
+
+	if logStartOffset, err := kv.ReadInt(ctx, logStartOffsetKey(key)); err == nil {
+		startOffset = max(startOffset, logStartOffset)
+	}
+
+	// Iterate through all keys in offset range (startOffset, highestOffset), appending up to limit to list
+	for i := startOffset; i <= highestOffset; i++ {
+		logEntryKey := fmt.Sprintf("%s/data/%d", key, i)
+
+		var entry *LogEntry
+		err := kv.ReadInto(ctx, logEntryKey, &entry)
+
+		if err == nil && entry != nil {
+			if !entry.Verify(key, i) {
+				return nil, maelstrom.NewRPCError(ChecksumMismatch, fmt.Sprintf("checksum mismatch at %s offset %d", key, i))
+			}
+
+			logMessages = append(logMessages, []int{i, entry.Message})
+
+			if len(logMessages) == limit {
+				break
+			}
+		} else if contiguous {
+			break
+		}
+	}
+
+	return logMessages, nil
+}
+
+// Creates a log entry for the message at offset in the key's log
+func NewLogEntry(key string, offset int, message int, timestamp int64) LogEntry {
+	entry := LogEntry{Message: message, Timestamp: timestamp}
+	entry.Checksum = entry.checksum(key, offset)
+	return entry
+}
+
+// Returns true if the entry's checksum matches its contents at the given position
+func (e LogEntry) Verify(key string, offset int) bool {
+	return e.Checksum == e.checksum(key, offset)
+}
+
+func (e LogEntry) checksum(key string, offset int) uint32 {
+	return crc32.ChecksumIEEE(fmt.Appendf(nil, "%s/%d/%d/%d", key, offset, e.Message, e.Timestamp))
+}
+
+// Adds a log to the shared list of logs, if it isn't already there
+func registerLog(ctx context.Context, kv KV, key string) error {
+	_, err := kvutil.Update(ctx, kv, logsKey, nil, func(logs []string) ([]string, error) {
+		if slices.Contains(logs, key) {
+			return nil, kvutil.ErrNoChange
+		}
+		return append(slices.Clone(logs), key), nil
+	})
+	return err
+}
+
+// Registers a log unless this process already has
+func (b *Broker) register(ctx context.Context, key string) error {
+	b.mu.Lock()
+	registered := b.registered[key]
+	b.mu.Unlock()
+	if registered {
+		return nil
+	}
+
+	if err := registerLog(ctx, b.kv, key); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.registered[key] = true
+	b.mu.Unlock()
+	return nil
+}
+
+// Returns every log that has received at least one message
+func listLogs(ctx context.Context, kv KV) ([]string, error) {
+	return kvutil.Read[[]string](ctx, kv, logsKey, nil)
+}
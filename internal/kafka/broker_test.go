@@ -0,0 +1,444 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newTestBroker(kv KV) *Broker {
+	return NewBroker(kv, fake.NewClock(time.UnixMilli(1000)), NewOwnership(nil, kv, hlc.New()), nil)
+}
+
+type sendOp struct {
+	key string
+	msg int
+}
+
+func sendAll(t *testing.T, b *Broker, sends []sendOp) []int {
+	t.Helper()
+
+	offsets := []int{}
+	for _, s := range sends {
+		offset, err := b.Send(context.Background(), s.key, s.msg)
+		if err != nil {
+			t.Fatalf("send %v: %s", s, err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	return offsets
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		sends   []sendOp
+		offsets []int
+	}{
+		{"first message starts at zero", []sendOp{{"a", 10}}, []int{0}},
+		{"offsets increase per key", []sendOp{{"a", 10}, {"a", 11}, {"a", 12}}, []int{0, 1, 2}},
+		{"keys have independent offsets", []sendOp{{"a", 10}, {"b", 20}, {"a", 11}, {"b", 21}}, []int{0, 0, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBroker(fake.NewKV())
+
+			if got := sendAll(t, b, tt.sends); !reflect.DeepEqual(got, tt.offsets) {
+				t.Errorf("offsets = %v, want %v", got, tt.offsets)
+			}
+		})
+	}
+}
+
+func TestSendRegistersLogs(t *testing.T) {
+	kv := fake.NewKV()
+	b := newTestBroker(kv)
+	sendAll(t, b, []sendOp{{"a", 1}, {"b", 2}, {"a", 3}})
+
+	logs, err := listLogs(context.Background(), kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "b"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("logs = %v, want %v", logs, want)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	sends := []sendOp{{"a", 10}, {"a", 11}, {"a", 12}, {"a", 13}, {"b", 20}}
+
+	tests := []struct {
+		name     string
+		offsets  map[string]int
+		messages map[string][][]int
+	}{
+		{"from the start", map[string]int{"a": 0}, map[string][][]int{"a": {{0, 10}, {1, 11}, {2, 12}}}},
+		{"from the middle", map[string]int{"a": 2}, map[string][][]int{"a": {{2, 12}, {3, 13}}}},
+		{"past the end", map[string]int{"a": 4}, map[string][][]int{"a": {}}},
+		{"unknown key", map[string]int{"c": 0}, map[string][][]int{"c": {}}},
+		{"several keys", map[string]int{"a": 3, "b": 0}, map[string][][]int{"a": {{3, 13}}, "b": {{0, 20}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBroker(fake.NewKV())
+			sendAll(t, b, sends)
+
+			got, err := b.Poll(context.Background(), tt.offsets)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.messages) {
+				t.Errorf("messages = %v, want %v", got, tt.messages)
+			}
+		})
+	}
+}
+
+func TestPollSkipsRetainedOffsets(t *testing.T) {
+	kv := fake.NewKV()
+	b := newTestBroker(kv)
+	sendAll(t, b, []sendOp{{"a", 10}, {"a", 11}, {"a", 12}})
+
+	if err := kv.Write(context.Background(), logStartOffsetKey("a"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Poll(context.Background(), map[string]int{"a": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string][][]int{"a": {{2, 12}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
+
+func TestRetentionAdvancesStartOffset(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+	sendAll(t, newTestBroker(kv), []sendOp{{"a", 10}, {"a", 11}})
+	late := NewBroker(kv, fake.NewClock(time.UnixMilli(5000)), NewOwnership(nil, kv, hlc.New()), nil)
+	sendAll(t, late, []sendOp{{"a", 12}})
+
+	r, err := NewRetention(nil, kv, fake.NewClock(time.UnixMilli(6000)), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.expire(ctx, time.UnixMilli(2000)); err != nil {
+			t.Fatal(err)
+		}
+		if start, err := kv.ReadInt(ctx, logStartOffsetKey("a")); err != nil || start != 2 {
+			t.Fatalf("start offset = %d, %v, want 2", start, err)
+		}
+	}
+
+	got, err := late.Poll(ctx, map[string]int{"a": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][][]int{"a": {{2, 12}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+
+	// lin-kv can't delete, so the expired entries are overwritten with null
+	for _, key := range []string{"a/data/0", "a/data/1"} {
+		var entry *LogEntry
+		if err := kv.ReadInto(ctx, key, &entry); err != nil || entry != nil {
+			t.Errorf("expired entry %s = %+v, %v, want null", key, entry, err)
+		}
+	}
+	var entry *LogEntry
+	if err := kv.ReadInto(ctx, "a/data/2", &entry); err != nil || entry == nil || entry.Message != 12 {
+		t.Errorf("retained entry = %+v, %v, want message 12", entry, err)
+	}
+}
+
+func TestPollChecksumMismatch(t *testing.T) {
+	kv := fake.NewKV()
+	b := newTestBroker(kv)
+	sendAll(t, b, []sendOp{{"a", 10}})
+
+	// Corrupt the stored message without updating its checksum
+	entry := NewLogEntry("a", 0, 10, 1000)
+	entry.Message = 99
+	if err := kv.Write(context.Background(), "a/data/0", entry); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := b.Poll(context.Background(), map[string]int{"a": 0})
+	if code := maelstrom.ErrorCode(err); code != ChecksumMismatch {
+		t.Fatalf("error code = %d, want %d (err %v)", code, ChecksumMismatch, err)
+	}
+
+	if got := b.stats.Response().ChecksumMismatches; got != 1 {
+		t.Errorf("checksum mismatches = %d, want 1", got)
+	}
+}
+
+func TestCommitOffsets(t *testing.T) {
+	type commit struct {
+		group   string
+		offsets map[string]int
+	}
+
+	tests := []struct {
+		name    string
+		commits []commit
+		group   string
+		keys    []string
+		want    map[string]int
+	}{
+		{"nothing committed", nil, "", []string{"a"}, map[string]int{}},
+		{"single commit", []commit{{"", map[string]int{"a": 2}}}, "", []string{"a"}, map[string]int{"a": 2}},
+		{"keeps the highest offset", []commit{{"", map[string]int{"a": 5}}, {"", map[string]int{"a": 3}}}, "", []string{"a"}, map[string]int{"a": 5}},
+		{"only listed keys", []commit{{"", map[string]int{"a": 1, "b": 2}}}, "", []string{"b"}, map[string]int{"b": 2}},
+		{"groups are independent", []commit{{"g1", map[string]int{"a": 4}}, {"g2", map[string]int{"a": 7}}}, "g1", []string{"a"}, map[string]int{"a": 4}},
+		{"default group ignores named groups", []commit{{"g1", map[string]int{"a": 4}}}, "", []string{"a"}, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBroker(fake.NewKV())
+
+			for _, c := range tt.commits {
+				if err := b.CommitOffsets(context.Background(), c.group, c.offsets); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := b.ListCommittedOffsets(context.Background(), tt.group, tt.keys); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("committed offsets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsume(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBroker(fake.NewKV())
+	sendAll(t, b, []sendOp{{"a", 10}, {"a", 11}, {"a", 12}})
+
+	steps := []struct {
+		messages map[string][][]int
+		offsets  map[string]int
+	}{
+		{map[string][][]int{"a": {{0, 10}, {1, 11}}}, map[string]int{"a": 1}},
+		{map[string][][]int{"a": {{2, 12}}}, map[string]int{"a": 2}},
+		{map[string][][]int{"a": {}}, map[string]int{}},
+	}
+
+	for i, step := range steps {
+		messages, offsets, err := b.Consume(ctx, "g", []string{"a"}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(messages, step.messages) || !reflect.DeepEqual(offsets, step.offsets) {
+			t.Errorf("consume %d = %v %v, want %v %v", i, messages, offsets, step.messages, step.offsets)
+		}
+	}
+
+	if got := b.ListCommittedOffsets(ctx, "g", []string{"a"}); !reflect.DeepEqual(got, map[string]int{"a": 2}) {
+		t.Errorf("committed offsets = %v", got)
+	}
+}
+
+func TestConsumeStopsAtUnwrittenOffset(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+	b := newTestBroker(kv)
+	sendAll(t, b, []sendOp{{"a", 10}, {"a", 11}})
+
+	// Offset 2 is reserved by a send still in flight, offset 3 is already written
+	kv.Write(ctx, "a/highest_offset", 3)
+	kv.Write(ctx, "a/data/3", NewLogEntry("a", 3, 13, 1000))
+
+	consume := func() (map[string][][]int, map[string]int) {
+		t.Helper()
+		messages, offsets, err := b.Consume(ctx, "g", []string{"a"}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages, offsets
+	}
+
+	messages, offsets := consume()
+	if want := map[string][][]int{"a": {{0, 10}, {1, 11}}}; !reflect.DeepEqual(messages, want) || offsets["a"] != 1 {
+		t.Fatalf("consume = %v %v, want %v committed up to 1", messages, offsets, want)
+	}
+	if messages, offsets := consume(); len(messages["a"]) != 0 || len(offsets) != 0 {
+		t.Fatalf("consume = %v %v, want nothing until offset 2 is written", messages, offsets)
+	}
+
+	kv.Write(ctx, "a/data/2", NewLogEntry("a", 2, 12, 1000))
+	messages, offsets = consume()
+	if want := map[string][][]int{"a": {{2, 12}, {3, 13}}}; !reflect.DeepEqual(messages, want) || offsets["a"] != 3 {
+		t.Errorf("consume = %v %v, want %v committed up to 3", messages, offsets, want)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBroker(fake.NewKV())
+	sendAll(t, b, []sendOp{{"a", 10}, {"a", 11}, {"b", 20}})
+	if err := b.CommitOffsets(ctx, "", map[string]int{"a": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := b.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestBroker(fake.NewKV())
+	if err := restored.Restore(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := restored.Poll(ctx, map[string]int{"a": 0, "b": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][][]int{"a": {{0, 10}, {1, 11}}, "b": {{0, 20}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+	if got := restored.ListCommittedOffsets(ctx, "", []string{"a"}); got["a"] != 0 || len(got) != 1 {
+		t.Errorf("committed offsets = %v", got)
+	}
+
+	// Sends carry on after the restored messages
+	if offsets := sendAll(t, restored, []sendOp{{"a", 12}}); offsets[0] != 2 {
+		t.Errorf("offset after restore = %d, want 2", offsets[0])
+	}
+}
+
+func TestLeaseOwnership(t *testing.T) {
+	kv := fake.NewKV()
+	ctx := context.Background()
+
+	ownerships := make([]*Ownership, 2)
+	for i, id := range []string{"n1", "n2"} {
+		node := maelstrom.NewNode()
+		node.Init(id, []string{"n1", "n2"})
+		ownerships[i] = NewOwnership(node, kv, hlc.New())
+		if err := ownerships[i].UseLeases(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first node asked takes the log, the other forwards to it
+	for _, o := range ownerships {
+		if owner, err := o.Owner(ctx, "k1"); err != nil || owner != "n1" {
+			t.Fatalf("owner %q, %v, want n1", owner, err)
+		}
+	}
+	if owner, err := ownerships[1].Owner(ctx, "k2"); err != nil || owner != "n2" {
+		t.Fatalf("owner %q, %v, want n2", owner, err)
+	}
+}
+
+// A node only keeps the leases of logs it's been asked about lately
+func TestIdleLeasesDropped(t *testing.T) {
+	ctx := context.Background()
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	node := maelstrom.NewNode()
+	node.Init("n1", []string{"n1", "n2"})
+	env.Set(node, &env.Env{Clock: clock})
+
+	o := NewOwnership(node, fake.NewKV(), hlc.New())
+	if err := o.UseLeases(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if _, err := o.Owner(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock.Advance(ownerLeaseIdle / 2)
+	if _, err := o.Owner(ctx, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(ownerLeaseIdle / 2)
+	if _, err := o.Owner(ctx, "k3"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := o.leases.Status()
+	if _, ok := status["k1/owner"]; ok || len(status) != 2 {
+		t.Fatalf("leases %v, want k2's and k3's", status)
+	}
+}
+
+// Sends aren't held up by a mirror that has stopped copying, the appends it can't queue are dropped
+func TestMirrorDropsWhenFull(t *testing.T) {
+	kv := fake.NewKV()
+	mirror := NewMirror(maelstrom.NewNode(), kv, "mirror", nil)
+	b := NewBroker(kv, fake.NewClock(time.UnixMilli(1000)), NewOwnership(nil, kv, hlc.New()), mirror)
+
+	for i := range mirrorQueueSize + 2 {
+		if _, err := b.Send(context.Background(), "a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dropped := mirror.Dropped(); dropped != 2 {
+		t.Fatalf("%d appends dropped, want 2", dropped)
+	}
+	if lag := mirror.Lag()["a"]; lag != mirrorQueueSize+2 {
+		t.Fatalf("lag %d, want %d", lag, mirrorQueueSize+2)
+	}
+}
+
+// A KV whose compare-and-set always fails with err
+type failingCAS struct {
+	KV
+	err error
+}
+
+func (kv failingCAS) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	return kv.err
+}
+
+// Only a lost compare-and-set is retried when reserving an offset
+func TestNextOffsetGivesUp(t *testing.T) {
+	timeout := maelstrom.NewRPCError(maelstrom.Timeout, "timed out")
+	o := NewOwnership(nil, failingCAS{KV: fake.NewKV(), err: timeout}, hlc.New())
+	if _, err := o.NextOffset(context.Background(), "a"); err != timeout {
+		t.Fatalf("reserved an offset with %v, want the timeout", err)
+	}
+
+	conflict := maelstrom.NewRPCError(maelstrom.PreconditionFailed, "conflict")
+	o = NewOwnership(nil, failingCAS{KV: fake.NewKV(), err: conflict}, hlc.New())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := o.NextOffset(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("reserved an offset with %v, want the context's error", err)
+	}
+}
+
+// A log whose first send crashed after taking offset 0 is registered by the next send to it
+func TestSendRegistersLog(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+	kv.Write(ctx, "a/highest_offset", 0)
+
+	if offset, err := newTestBroker(kv).Send(ctx, "a", 1); err != nil || offset != 1 {
+		t.Fatalf("sent at offset %d, %v", offset, err)
+	}
+	if logs, err := listLogs(ctx, kv); err != nil || !reflect.DeepEqual(logs, []string{"a"}) {
+		t.Fatalf("logs %v, %v, want a", logs, err)
+	}
+}
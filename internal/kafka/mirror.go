@@ -0,0 +1,238 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Mirroring
+
+Every successful append can be copied asynchronously into a second namespace, which
+demonstrates cross-cluster replication on top of the log. The target is either:
+  - a key prefix in the same lin-kv service (MIRROR_PREFIX, e.g. "mirror" -> mirror/<key>/data/<offset>)
+  - a set of nodes that accept mirror_append messages (MIRROR_NODES, comma separated)
+
+Appends are queued and copied in order, so the mirror can only ever lag behind the source. Whatever is
+still queued when the node shuts down is copied before it exits. Queueing never holds up a send: an
+append that finds the queue full, while the mirror is paused or cut off say, or the node shutting down,
+isn't mirrored and counts as dropped.
+The lag per key and the number of appends dropped are exposed through the mirror_status RPC.
+*/
+
+const mirrorQueueSize = 1024
+
+type MirrorEntry struct {
+	Key     string `json:"key"`
+	Offset  int    `json:"offset"`
+	Message int    `json:"msg"`
+}
+
+type MirrorAppendRequestBody struct {
+	Type string `json:"type"`
+	MirrorEntry
+}
+
+type MirrorAppendResponseBody struct {
+	Type string `json:"type"`
+}
+
+type MirrorStatusRequestBody struct {
+	Type string `json:"type"`
+}
+
+type MirrorStatusResponseBody struct {
+	Type     string         `json:"type"`
+	Enabled  bool           `json:"enabled"`
+	Lag      map[string]int `json:"lag"`
+	TotalLag int            `json:"total_lag"`
+	Dropped  int            `json:"dropped"`
+}
+
+type Mirror struct {
+	node   *maelstrom.Node
+	kv     KV
+	prefix string
+	nodes  []string
+	queue  chan MirrorEntry
+
+	mu       sync.Mutex
+	appended map[string]int // highest offset appended to the source log, per key
+	mirrored map[string]int // highest offset copied into the mirror, per key
+	dropped  int            // appends that weren't queued
+}
+
+// Creates a mirror copying into the KV under prefix and to nodes
+// Returns nil if mirroring is disabled (neither is set)
+func NewMirror(node *maelstrom.Node, kv KV, prefix string, nodes []string) *Mirror {
+	if prefix == "" && len(nodes) == 0 {
+		return nil
+	}
+
+	// Mirror nodes write under the default prefix unless told otherwise
+	if prefix == "" {
+		prefix = "mirror"
+	}
+
+	return &Mirror{
+		node:     node,
+		kv:       kv,
+		prefix:   prefix,
+		nodes:    nodes,
+		queue:    make(chan MirrorEntry, mirrorQueueSize),
+		appended: make(map[string]int),
+		mirrored: make(map[string]int),
+	}
+}
+
+// Queues an appended message to be copied into the mirror
+// Drops it instead if the queue is full or the node is shutting down, it then stays in the key's lag
+func (m *Mirror) Enqueue(entry MirrorEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if offset, ok := m.appended[entry.Key]; !ok || entry.Offset > offset {
+		m.appended[entry.Key] = entry.Offset
+	}
+
+	if lifecycle.Of(m.node).Context().Err() == nil {
+		select {
+		case m.queue <- entry:
+			return
+		default:
+		}
+	}
+
+	m.dropped++
+	logger.Warn("append not mirrored", "key", entry.Key, "offset", entry.Offset)
+}
+
+// Copies queued entries into the mirror until ctx is cancelled
+func (m *Mirror) Run(ctx context.Context) {
+	for {
+		if lifecycle.AwaitResume(ctx) != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-m.queue:
+			m.copy(ctx, entry)
+		}
+	}
+}
+
+// Copies the entries still queued, for when the node stops
+func (m *Mirror) Flush(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%d entries not mirrored: %w", len(m.queue), err)
+		}
+
+		select {
+		case entry := <-m.queue:
+			m.copy(ctx, entry)
+		default:
+			return nil
+		}
+	}
+}
+
+// Copies entry to the mirror, retrying until it succeeds or ctx is done
+func (m *Mirror) copy(ctx context.Context, entry MirrorEntry) {
+	if len(m.nodes) == 0 {
+		if m.retry(ctx, func() error { return WriteMirrorEntry(ctx, m.kv, m.prefix, entry) }) != nil {
+			return
+		}
+	} else {
+		for _, dest := range m.nodes {
+			err := m.retry(ctx, func() error {
+				rpcCtx, cancel := context.WithTimeout(ctx, time.Second)
+				defer cancel()
+
+				_, err := m.node.SyncRPC(rpcCtx, dest, MirrorAppendRequestBody{
+					Type:        "mirror_append",
+					MirrorEntry: entry,
+				})
+				return err
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	m.mu.Lock()
+	if offset, ok := m.mirrored[entry.Key]; !ok || entry.Offset > offset {
+		m.mirrored[entry.Key] = entry.Offset
+	}
+	m.mu.Unlock()
+}
+
+// Retries fn until it succeeds or ctx is cancelled, so partitions only delay the mirror
+func (m *Mirror) retry(ctx context.Context, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		logger.Warn("mirror failed, retrying", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Returns the number of appends dropped rather than queued
+func (m *Mirror) Dropped() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropped
+}
+
+// Returns how many offsets each key's mirror is behind the source log
+func (m *Mirror) Lag() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lag := make(map[string]int)
+	for key, offset := range m.appended {
+		mirrored, ok := m.mirrored[key]
+		if !ok {
+			mirrored = -1
+		}
+		lag[key] = offset - mirrored
+	}
+
+	return lag
+}
+
+// Writes a single entry under prefix and advances the mirrored highest offset for its key
+func WriteMirrorEntry(ctx context.Context, kv KV, prefix string, entry MirrorEntry) error {
+	logEntryKey := fmt.Sprintf("%s/%s/data/%d", prefix, entry.Key, entry.Offset)
+
+	if err := kv.Write(ctx, logEntryKey, entry.Message); err != nil {
+		return err
+	}
+
+	// Entries may arrive out of order from different source nodes, so only ever move the offset forward
+	offsetKey := fmt.Sprintf("%s/%s/highest_offset", prefix, entry.Key)
+	_, err := kvutil.Update(ctx, kv, offsetKey, -1, func(oldOffset int) (int, error) {
+		if oldOffset >= entry.Offset {
+			return 0, kvutil.ErrNoChange
+		}
+		return entry.Offset, nil
+	})
+	return err
+}
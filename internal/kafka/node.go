@@ -0,0 +1,366 @@
+package kafka
+
+/*
+Challenge #5: Kafka-Style Log
+
+Goal: implement a replicated log service similar to Kafka
+
+Part a) Single-node log system
+Part b) Distributed log system utilizing a linearizable key-value service
+
+KAFKA_MODE=rsm replaces both with a log replicated on every node through Raft, Paxos or VR, see rsm.go.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lamport"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/membership"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metadata"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var logger = logging.For("kafka")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// RETENTION_MS, how old a message may get before polls skip it and its entry is deleted (default 0, kept forever)
+	Retention time.Duration `env:"RETENTION_MS" min:"0"`
+
+	// RETENTION_INTERVAL_MS, how often old messages are looked for (default 1000)
+	RetentionInterval time.Duration `env:"RETENTION_INTERVAL_MS" min:"1"`
+
+	// MIRROR_PREFIX and MIRROR_NODES, where appended messages are copied to (see mirror.go)
+	MirrorPrefix string   `env:"MIRROR_PREFIX"`
+	MirrorNodes  []string `env:"MIRROR_NODES"`
+
+	// MAX_CLOCK_DRIFT_MS, how far ahead of this node's clock another node's may be before the hybrid
+	// clock refuses to follow it (default 10000, 0 for no limit)
+	MaxClockDrift time.Duration `env:"MAX_CLOCK_DRIFT_MS" min:"0"`
+
+	// KAFKA_KV, where the logs are kept: lin-kv (default) or local, the node's own store (see storekv.go)
+	KV string `env:"KAFKA_KV" oneof:"lin-kv|local"`
+
+	// KAFKA_OWNERSHIP, how each log's owner is chosen: ring (default), by consistent hashing, or lease,
+	// by whichever node holds its lease (see ownership.go)
+	Ownership string `env:"KAFKA_OWNERSHIP" oneof:"ring|lease"`
+
+	// KAFKA_MODE, broker (default), logs kept in KAFKA_KV by their owners, or rsm, replicated on every node
+	// through a consensus log (see rsm.go)
+	Mode string `env:"KAFKA_MODE" oneof:"broker|rsm"`
+
+	// KAFKA_SWIM, whether the members logs are spread over follow the SWIM membership view, so a dead
+	// node's logs move to the others (see internal/membership)
+	SWIM bool `env:"KAFKA_SWIM"`
+}
+
+type SendRequestBody struct {
+	Type      string `json:"type"`
+	Key       string `json:"key"`
+	Message   int    `json:"msg"`
+	Forwarded bool   `json:"forwarded,omitempty"`
+}
+
+type SendResponseBody struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+}
+
+type PollRequestBody struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type PollResponseBody struct {
+	Type     string             `json:"type"`
+	Messages map[string][][]int `json:"msgs"`
+}
+
+type CommitOffsetsRequestBody struct {
+	Type    string         `json:"type"`
+	Group   string         `json:"group,omitempty"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type CommitOffsetsResponseBody struct {
+	Type string `json:"type"`
+}
+
+type ListCommittedOffsetsRequestBody struct {
+	Type  string   `json:"type"`
+	Group string   `json:"group,omitempty"`
+	Keys  []string `json:"keys"`
+}
+
+type ListCommittedOffsetsResponseBody struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type ConsumeRequestBody struct {
+	Type  string   `json:"type"`
+	Group string   `json:"group,omitempty"`
+	Keys  []string `json:"keys"`
+	Limit int      `json:"limit,omitempty"`
+}
+
+type ConsumeResponseBody struct {
+	Type     string             `json:"type"`
+	Messages map[string][][]int `json:"msgs"`
+	Offsets  map[string]int     `json:"offsets"`
+}
+
+// Registers the log handlers on node and starts retention and mirroring if they're configured
+func Register(node *maelstrom.Node) error {
+	clock := lamport.Attach(node)
+	ctx := context.Background()
+
+	cfg := Config{RetentionInterval: time.Second, MaxClockDrift: 10 * time.Second, KV: "lin-kv", Ownership: "ring", Mode: BrokerMode}
+	if err := config.Load("kafka", &cfg); err != nil {
+		return err
+	}
+
+	if cfg.Mode == RSMMode {
+		return registerRSM(node)
+	}
+
+	var kv KV = maelstrom.NewLinKV(node)
+	if cfg.KV == "local" {
+		kv = newStoreKV(node)
+	}
+
+	// Hybrid clock stamping log entries
+	hybrid := hlc.New()
+	hybrid.SetMaxDrift(cfg.MaxClockDrift)
+
+	ownership := NewOwnership(node, kv, hybrid)
+	if cfg.Ownership == "lease" {
+		if err := ownership.UseLeases(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.SWIM {
+		members, err := membership.New(node)
+		if err != nil {
+			return err
+		}
+		members.OnChange(func(view []string) {
+			if err := ownership.SetMembers(ctx, view); err != nil {
+				logger.Error("rebalance failed", "members", view, "error", err)
+			}
+		})
+		lifecycle.Of(node).Go(members.Run)
+	}
+
+	retention, err := NewRetention(node, kv, systemClock{}, cfg.Retention, cfg.RetentionInterval)
+	if err != nil {
+		return err
+	}
+	if retention != nil {
+		lifecycle.Of(node).Go(retention.Run)
+	}
+
+	mirror := NewMirror(node, kv, cfg.MirrorPrefix, cfg.MirrorNodes)
+	if mirror != nil {
+		lifecycle.Of(node).Go(mirror.Run)
+		lifecycle.Of(node).OnStop("kafka.mirror", mirror.Flush)
+	}
+
+	broker := NewBroker(kv, hybridClock{clock: hybrid}, ownership, mirror)
+
+	kafkaAdmin := admin.Module{Status: func() any {
+		return map[string]any{"kv": cfg.KV, "ownership": cfg.Ownership, "stats": broker.stats.Response()}
+	}}
+	if mirror != nil {
+		kafkaAdmin.Flush = mirror.Flush
+	}
+	admin.Of(node).Register("kafka", kafkaAdmin)
+
+	// Node snapshots hold every log, see snapshot.go
+	snapshot.Of(node).Provide("kafka", func() (any, error) {
+		return broker.Snapshot(ctx)
+	}, func(data json.RawMessage) error {
+		var logs map[string]LogSnapshot
+		if err := json.Unmarshal(data, &logs); err != nil {
+			return err
+		}
+		return broker.Restore(ctx, logs)
+	})
+
+	// Every node rebalances once a member set announced with set_members reaches it
+	metadata.Of(node).Watch(membersKey, func(value json.RawMessage) {
+		var members []string
+		if err := json.Unmarshal(value, &members); err != nil {
+			logger.Error("invalid member set", "members", string(value), "error", err)
+			return
+		}
+		if err := ownership.SetMembers(ctx, members); err != nil {
+			logger.Error("rebalance failed", "members", members, "error", err)
+		}
+	})
+
+	// The initial membership is every node in the cluster
+	node.Handle("init", func(msg maelstrom.Message) error {
+		hybrid.SetNode(node.ID())
+		lifecycle.Of(node).Go(metadata.Of(node).Run)
+		return ownership.SetMembers(ctx, node.NodeIDs())
+	})
+
+	// Admin RPC announcing a new member set, sent to any node and spread to the rest as cluster metadata
+	handler.Handle(node, "set_members", func(msg maelstrom.Message, body SetMembersRequestBody) (SetMembersResponseBody, error) {
+		if err := metadata.Of(node).Set(membersKey, body.Nodes); err != nil {
+			return SetMembersResponseBody{}, err
+		}
+
+		return SetMembersResponseBody{
+			Type: "set_members_ok",
+		}, nil
+	})
+
+	// Sent by a log's previous owner after a rebalance
+	handler.Handle(node, "migrate_key", func(msg maelstrom.Message, body MigrateKeyRequestBody) (MigrateKeyResponseBody, error) {
+		ownership.Receive(body.Key, body.Offset, body.Timestamp)
+
+		return MigrateKeyResponseBody{
+			Type: "migrate_key_ok",
+		}, nil
+	})
+
+	handler.Handle(node, "send", func(msg maelstrom.Message, body SendRequestBody) (SendResponseBody, error) {
+		// Sends are handled by the log's owner, forwarded sends are handled here regardless
+		// so nodes with briefly different views of the ring can't bounce a send back and forth
+		owner, err := ownership.Owner(ctx, body.Key)
+		if err != nil {
+			return SendResponseBody{}, err
+		}
+		if owner != node.ID() && !body.Forwarded {
+			body.Forwarded = true
+
+			rpcCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			resp, err := node.SyncRPC(rpcCtx, owner, body)
+			if err != nil {
+				return SendResponseBody{}, err
+			}
+
+			var respBody SendResponseBody
+			if err := json.Unmarshal(resp.Body, &respBody); err != nil {
+				return SendResponseBody{}, err
+			}
+
+			return respBody, nil
+		}
+
+		offset, err := broker.Send(ctx, body.Key, body.Message)
+
+		if err != nil {
+			return SendResponseBody{}, err
+		}
+
+		return SendResponseBody{
+			Type:   "send_ok",
+			Offset: offset,
+		}, nil
+	})
+
+	handler.Handle(node, "poll", func(msg maelstrom.Message, body PollRequestBody) (PollResponseBody, error) {
+		messages, err := broker.Poll(ctx, body.Offsets)
+
+		if err != nil {
+			return PollResponseBody{}, err
+		}
+
+		return PollResponseBody{
+			Type:     "poll_ok",
+			Messages: messages,
+		}, nil
+	})
+
+	handler.Handle(node, "commit_offsets", func(msg maelstrom.Message, body CommitOffsetsRequestBody) (CommitOffsetsResponseBody, error) {
+		if err := broker.CommitOffsets(ctx, body.Group, body.Offsets); err != nil {
+			return CommitOffsetsResponseBody{}, err
+		}
+
+		return CommitOffsetsResponseBody{
+			Type: "commit_offsets_ok",
+		}, nil
+	})
+
+	handler.Handle(node, "list_committed_offsets", func(msg maelstrom.Message, body ListCommittedOffsetsRequestBody) (ListCommittedOffsetsResponseBody, error) {
+		return ListCommittedOffsetsResponseBody{
+			Type:    "list_committed_offsets_ok",
+			Offsets: broker.ListCommittedOffsets(ctx, body.Group, body.Keys),
+		}, nil
+	})
+
+	handler.Handle(node, "consume", func(msg maelstrom.Message, body ConsumeRequestBody) (ConsumeResponseBody, error) {
+		messages, offsets, err := broker.Consume(ctx, body.Group, body.Keys, body.Limit)
+
+		if err != nil {
+			return ConsumeResponseBody{}, err
+		}
+
+		return ConsumeResponseBody{
+			Type:     "consume_ok",
+			Messages: messages,
+			Offsets:  offsets,
+		}, nil
+	})
+
+	// Answered by the standard stats RPC, see internal/metrics
+	metrics.Of(node).Report(func() any {
+		resp := broker.stats.Response()
+		resp.LamportTime = clock.Now()
+		resp.ClockDrifts = hybrid.Drifted()
+		return resp
+	})
+
+	// Sent by a source cluster that mirrors its appends into this node's namespace
+	handler.Handle(node, "mirror_append", func(msg maelstrom.Message, body MirrorAppendRequestBody) (MirrorAppendResponseBody, error) {
+		prefix := "mirror"
+		if mirror != nil {
+			prefix = mirror.prefix
+		}
+
+		if err := WriteMirrorEntry(ctx, kv, prefix, body.MirrorEntry); err != nil {
+			return MirrorAppendResponseBody{}, err
+		}
+
+		return MirrorAppendResponseBody{
+			Type: "mirror_append_ok",
+		}, nil
+	})
+
+	handler.Handle(node, "mirror_status", func(msg maelstrom.Message, body MirrorStatusRequestBody) (MirrorStatusResponseBody, error) {
+		response := MirrorStatusResponseBody{
+			Type: "mirror_status_ok",
+			Lag:  map[string]int{},
+		}
+
+		if mirror != nil {
+			response.Enabled = true
+			response.Lag = mirror.Lag()
+			response.Dropped = mirror.Dropped()
+
+			for _, lag := range response.Lag {
+				response.TotalLag += lag
+			}
+		}
+
+		return response, nil
+	})
+
+	return nil
+}
@@ -0,0 +1,380 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/election"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Key ownership
+
+Each log is owned by one node, chosen by consistent hashing over the current members.
+The owner keeps the log's highest offset in memory, so most sends skip the KV read that
+would otherwise precede the CAS. Sends arriving at other nodes are forwarded to the owner.
+
+When membership changes (on init, through the set_members admin RPC, or with KAFKA_SWIM when the
+membership view does) the ring is rebuilt. set_members can be sent to any node: the member set is kept
+in the cluster metadata under kafka.members (see internal/metadata), and every node rebuilds its ring once
+it arrives.
+Keys that moved are handed to their new owner with a migrate_key message, retried with a growing
+delay until it's acknowledged or the node shuts down, and the new owner holds writes to those keys until the handoff arrives (or times out, after which it reloads
+the offset from lin-kv, which is always the source of truth).
+
+The handoff also carries the previous owner's hybrid clock, so entries the new owner appends are never
+stamped earlier than the ones before them, which retention relies on.
+
+With KAFKA_OWNERSHIP=lease, a log is owned by whichever node holds the lease at <key>/owner in the KV
+instead (see internal/election): the first node to get a send for it takes the lease, and keeps it for as
+long as it keeps getting sends. There's nothing to hand off, a node that gains or loses a log drops its
+cached offset, and the next owner reads it from the KV. A node forgets a log's lease once it loses it, or
+once it hasn't been asked about the log for ownerLeaseIdle, so it only keeps leases for the logs in use.
+They show in admin_status together as election.kafka.owners. The ring is still kept but not used.
+*/
+
+const (
+	virtualNodesPerMember = 16
+	migrationTimeout      = time.Second
+
+	// How long a log's lease is kept after this node last asked who owns the log
+	ownerLeaseIdle = 10 * time.Second
+
+	// Delay between attempts at a handoff, doubling from minHandoffRetry to maxHandoffRetry
+	minHandoffRetry = 10 * time.Millisecond
+	maxHandoffRetry = time.Second
+
+	// Cluster metadata key of the member set announced with set_members
+	membersKey = "kafka.members"
+)
+
+type SetMembersRequestBody struct {
+	Type  string   `json:"type"`
+	Nodes []string `json:"nodes"`
+}
+
+type SetMembersResponseBody struct {
+	Type string `json:"type"`
+}
+
+type MigrateKeyRequestBody struct {
+	Type      string        `json:"type"`
+	Key       string        `json:"key"`
+	Offset    int           `json:"offset"`
+	Timestamp hlc.Timestamp `json:"ts"`
+}
+
+type MigrateKeyResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Consistent hash ring mapping keys to member nodes
+type Ring struct {
+	members []string
+	hashes  []uint32
+	owners  map[uint32]string
+}
+
+func NewRing(members []string) *Ring {
+	r := &Ring{
+		members: slices.Clone(members),
+		owners:  make(map[uint32]string),
+	}
+
+	for _, member := range members {
+		for i := 0; i < virtualNodesPerMember; i++ {
+			h := hashKey(fmt.Sprintf("%s#%d", member, i))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = member
+		}
+	}
+
+	slices.Sort(r.hashes)
+	return r
+}
+
+// Returns the member responsible for key, the first virtual node clockwise from its hash
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hashKey(key)
+	i, _ := slices.BinarySearch(r.hashes, h)
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+type Ownership struct {
+	node  *maelstrom.Node
+	kv    KV
+	clock *hlc.Clock
+	env   *env.Env
+
+	mu      sync.Mutex
+	ring    *Ring
+	offsets map[string]int           // cached highest offset of each owned log
+	pending map[string]chan struct{} // logs waiting for a handoff from their previous owner
+	leases  *election.Leases         // each log's owner lease, nil when owners come from the ring
+	used    map[string]time.Time     // when each log's lease was last asked for
+	swept   time.Time                // when idle leases were last dropped
+}
+
+func NewOwnership(node *maelstrom.Node, kv KV, clock *hlc.Clock) *Ownership {
+	return &Ownership{
+		node:    node,
+		kv:      kv,
+		clock:   clock,
+		env:     env.Of(node),
+		ring:    NewRing(nil),
+		offsets: make(map[string]int),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// Has each log owned by the holder of its lease rather than by its place on the ring
+func (o *Ownership) UseLeases() error {
+	leases, err := election.NewLeases(o.node, o.kv, "kafka.owners")
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.leases = leases
+	o.used = make(map[string]time.Time)
+	return nil
+}
+
+// Returns the node that currently owns key, taking its lease if it's free in lease mode
+func (o *Ownership) Owner(ctx context.Context, key string) (string, error) {
+	o.mu.Lock()
+	if o.leases == nil {
+		defer o.mu.Unlock()
+		return o.ring.Owner(key), nil
+	}
+
+	now := o.env.Clock.Now()
+	o.used[key] = now
+	o.dropIdleLocked(now)
+
+	// A log that changes hands may have been appended to since this node last owned it
+	forget := func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.offsets, key)
+	}
+	lease := o.leases.Get(key+"/owner", election.Callbacks{
+		Elected: func(term int64) { forget() },
+		Deposed: func() {
+			forget()
+			o.leases.Drop(key + "/owner")
+		},
+	})
+	o.mu.Unlock()
+
+	return lease.Campaign(ctx)
+}
+
+// Drops the leases of logs not asked about for ownerLeaseIdle, looking at most once every ownerLeaseIdle
+// Must be called with o.mu held
+func (o *Ownership) dropIdleLocked(now time.Time) {
+	if now.Sub(o.swept) < ownerLeaseIdle {
+		return
+	}
+	o.swept = now
+
+	for key, used := range o.used {
+		if now.Sub(used) >= ownerLeaseIdle {
+			// The lease is dropped without being deposed, so the cached offset goes with it
+			o.leases.Drop(key + "/owner")
+			delete(o.used, key)
+			delete(o.offsets, key)
+		}
+	}
+}
+
+// Rebuilds the ring for a new member set and migrates logs whose owner changed
+func (o *Ownership) SetMembers(ctx context.Context, members []string) error {
+	o.mu.Lock()
+	initial := len(o.ring.members) == 0
+	if o.leases != nil {
+		// Owners come from the leases, there's nothing to move
+		o.ring = NewRing(members)
+		o.mu.Unlock()
+		return nil
+	}
+	o.mu.Unlock()
+
+	// Logs can only move once there was a previous ring, so the initial ring skips the lookup
+	// It's done before taking the lock since the KV read is an RPC
+	var logs []string
+	if !initial {
+		var err error
+		if logs, err = listLogs(ctx, o.kv); err != nil {
+			return err
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	oldRing := o.ring
+	newRing := NewRing(members)
+	o.ring = newRing
+
+	// Hand off every cached log that now belongs to someone else
+	for key, offset := range o.offsets {
+		if owner := newRing.Owner(key); owner != o.node.ID() {
+			delete(o.offsets, key)
+			lifecycle.Of(o.node).Go(func(ctx context.Context) { o.handoff(ctx, owner, key, offset) })
+		}
+	}
+
+	// Hold writes to logs moving here until the previous owner's handoff arrives
+	// A previous owner that left the cluster can't send one, so its logs are reloaded from the KV instead
+	for _, key := range logs {
+		oldOwner := oldRing.Owner(key)
+		if newRing.Owner(key) == o.node.ID() && oldOwner != "" && oldOwner != o.node.ID() && slices.Contains(members, oldOwner) {
+			if _, ok := o.pending[key]; !ok {
+				o.pending[key] = make(chan struct{})
+			}
+		}
+	}
+
+	logger.Info("ownership rebalanced", "members", members)
+	return nil
+}
+
+// Sends a log's cached offset to its new owner, retrying until it's acknowledged or ctx is done
+func (o *Ownership) handoff(ctx context.Context, dest string, key string, offset int) {
+	backoff := minHandoffRetry
+	for {
+		rpcCtx, cancel := o.env.Clock.WithTimeout(ctx, migrationTimeout)
+		_, err := o.node.SyncRPC(rpcCtx, dest, MigrateKeyRequestBody{
+			Type:      "migrate_key",
+			Key:       key,
+			Offset:    offset,
+			Timestamp: o.clock.Now(),
+		})
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		logger.Warn("ownership handoff failed", "key", key, "dest", dest, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.env.Clock.After(backoff):
+		}
+		backoff = min(2*backoff, maxHandoffRetry)
+	}
+}
+
+// Installs a log handed off by its previous owner and releases any writes waiting on it
+func (o *Ownership) Receive(key string, offset int, timestamp hlc.Timestamp) {
+	if _, err := o.clock.Update(timestamp); err != nil {
+		logger.Warn("ownership handoff failed", "key", key, "error", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if cached, ok := o.offsets[key]; !ok || offset > cached {
+		o.offsets[key] = offset
+	}
+
+	if ch, ok := o.pending[key]; ok {
+		close(ch)
+		delete(o.pending, key)
+	}
+}
+
+// Reserves the next offset of an owned log
+// The cached offset is only a guess for the CAS, so a stale cache costs a retry rather than a duplicate offset
+// Only a CAS that lost to another is retried, any other error is returned, as is ctx's once it's done
+func (o *Ownership) NextOffset(ctx context.Context, key string) (int, error) {
+	o.awaitHandoff(key)
+
+	offsetKey := fmt.Sprintf("%s/highest_offset", key)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		o.mu.Lock()
+		oldOffset, cached := o.offsets[key]
+		o.mu.Unlock()
+
+		if !cached {
+			// If key doesn't exist the log is empty
+			var err error
+			oldOffset, err = kvutil.Read(ctx, o.kv, offsetKey, -1)
+
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err := o.kv.CompareAndSwap(ctx, offsetKey, oldOffset, oldOffset+1, true)
+
+		o.mu.Lock()
+		if err == nil {
+			if offset, ok := o.offsets[key]; !ok || oldOffset+1 > offset {
+				o.offsets[key] = oldOffset + 1
+			}
+		} else {
+			delete(o.offsets, key)
+		}
+		o.mu.Unlock()
+
+		if err == nil {
+			return oldOffset + 1, nil
+		} else if !kvutil.IsConflict(err) {
+			return 0, err
+		}
+	}
+}
+
+// Blocks until a pending handoff for key arrives, giving up after migrationTimeout
+func (o *Ownership) awaitHandoff(key string) {
+	o.mu.Lock()
+	ch, ok := o.pending[key]
+	o.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(migrationTimeout):
+		o.mu.Lock()
+		if o.pending[key] == ch {
+			delete(o.pending, key)
+		}
+		o.mu.Unlock()
+	}
+}
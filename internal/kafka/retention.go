@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/election"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Time-based retention
+
+Every message is stamped with its append time. When RETENTION_MS is set, a background task
+periodically advances each log's start offset past the messages older than that age, then
+deletes their entries, so long runs don't grow the KV state unboundedly. Polls, snapshots and
+retention itself all begin at the start offset, so moving it first is what removes the messages;
+the deletes only reclaim their space.
+
+The local KV deletes entries from the node's store. lin-kv has no delete operation, so there an
+entry is overwritten with null, which still leaves the key but drops the message it held.
+Only the holder of the lease at kafka.retention runs the task (see internal/election), the others would
+just race it on the same CAS. Every node campaigns for it on each tick, so another takes over once the
+holder stops renewing it.
+*/
+
+// KV key of the lease electing the node that runs retention
+const retentionLeaseKey = "kafka.retention"
+
+type Retention struct {
+	node     *maelstrom.Node
+	lease    *election.Lease
+	kv       KV
+	clock    Clock
+	maxAge   time.Duration
+	interval time.Duration
+}
+
+// Creates a retention task expiring messages older than maxAge every interval
+// Returns nil if retention is disabled (maxAge is 0)
+func NewRetention(node *maelstrom.Node, kv KV, clock Clock, maxAge, interval time.Duration) (*Retention, error) {
+	if maxAge <= 0 {
+		return nil, nil
+	}
+
+	lease, err := election.NewLease(node, kv, retentionLeaseKey, election.Callbacks{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Retention{
+		node:     node,
+		lease:    lease,
+		kv:       kv,
+		clock:    clock,
+		maxAge:   maxAge,
+		interval: interval,
+	}, nil
+}
+
+// Expires old messages on every tick until ctx is cancelled
+func (r *Retention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if lifecycle.Paused(ctx) {
+				continue
+			}
+			if leader, err := r.lease.Campaign(ctx); err != nil || leader != r.node.ID() {
+				continue
+			}
+
+			if err := r.expire(ctx, r.clock.Now().Add(-r.maxAge)); err != nil {
+				logger.Error("retention failed", "error", err)
+			}
+		}
+	}
+}
+
+// Removes every message appended before cutoff from all logs
+func (r *Retention) expire(ctx context.Context, cutoff time.Time) error {
+	logs, err := listLogs(ctx, r.kv)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range logs {
+		if err := r.expireLog(ctx, key, cutoff.UnixMilli()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Advances a log's start offset past entries older than cutoff, then deletes those entries
+func (r *Retention) expireLog(ctx context.Context, key string, cutoff int64) error {
+	highestOffset, err := r.kv.ReadInt(ctx, fmt.Sprintf("%s/highest_offset", key))
+	if err != nil {
+		return err
+	}
+
+	// Move the start offset first so polls stop reading the entries before they disappear
+	var oldStart int
+	newStart, err := kvutil.Update(ctx, r.kv, logStartOffsetKey(key), 0, func(start int) (int, error) {
+		oldStart = start
+
+		// Messages are appended in time order, so stop at the first one that's still young enough
+		// An entry that doesn't exist yet belongs to a send that's still in flight
+		newStart := start
+		for ; newStart <= highestOffset; newStart++ {
+			var entry *LogEntry
+			if err := r.kv.ReadInto(ctx, fmt.Sprintf("%s/data/%d", key, newStart), &entry); err != nil {
+				break
+			}
+
+			if entry != nil && entry.Timestamp >= cutoff {
+				break
+			}
+		}
+
+		if newStart == start {
+			return 0, kvutil.ErrNoChange
+		}
+		return newStart, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for offset := oldStart; offset < newStart; offset++ {
+		if err := deleteEntry(ctx, r.kv, fmt.Sprintf("%s/data/%d", key, offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Implemented by KVs that can remove a key outright, like the local KV
+type deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// Deletes a log entry where the KV can, and overwrites it with null where it can't
+func deleteEntry(ctx context.Context, kv KV, key string) error {
+	if d, ok := kv.(deleter); ok {
+		return d.Delete(ctx, key)
+	}
+	return kv.Write(ctx, key, nil)
+}
@@ -0,0 +1,235 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/rsm"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Replicated broker
+
+With KAFKA_MODE=rsm every log lives on every node as a replicated state machine (see internal/rsm)
+instead of in lin-kv: each send, poll and offset commit is a command in a consensus log, applied in the
+same order everywhere, so there's no owner to forward to and nothing to hand off, and offsets are simply
+positions in a slice. The entries are stamped and checksummed as in the KV (see broker.go), with the time
+of the node that submitted the send.
+
+Retention, mirroring, leases and set_members don't apply in this mode, there's one log for the whole
+cluster and it's never trimmed.
+*/
+
+const (
+	BrokerMode = "broker"
+	RSMMode    = "rsm"
+)
+
+// A broker operation as stored in the consensus log
+type LogCommand struct {
+	Op        string         `json:"op"`
+	Key       string         `json:"key,omitempty"`
+	Message   int            `json:"msg,omitempty"`
+	Timestamp int64          `json:"ts,omitempty"`
+	Group     string         `json:"group,omitempty"`
+	Offsets   map[string]int `json:"offsets,omitempty"`
+	Keys      []string       `json:"keys,omitempty"`
+	Limit     int            `json:"limit,omitempty"`
+}
+
+// Result of a consume command
+type ConsumeResult struct {
+	Messages map[string][][]int `json:"msgs"`
+	Offsets  map[string]int     `json:"offsets"`
+}
+
+// Every log and committed offset, the whole state of a replicated broker
+type LogMachineState struct {
+	Logs      map[string][]LogEntry     `json:"logs"`
+	Committed map[string]map[string]int `json:"committed"` // committed offset of each group's logs
+}
+
+// The broker as a state machine, applying log commands to logs kept in memory
+type LogMachine struct {
+	mu    sync.Mutex
+	state LogMachineState
+}
+
+func NewLogMachine() *LogMachine {
+	return &LogMachine{state: LogMachineState{Logs: map[string][]LogEntry{}, Committed: map[string]map[string]int{}}}
+}
+
+func (m *LogMachine) Apply(data json.RawMessage) (any, error) {
+	var cmd LogCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch cmd.Op {
+	case "send":
+		offset := len(m.state.Logs[cmd.Key])
+		m.state.Logs[cmd.Key] = append(m.state.Logs[cmd.Key], NewLogEntry(cmd.Key, offset, cmd.Message, cmd.Timestamp))
+		return offset, nil
+
+	case "poll":
+		messages := make(map[string][][]int)
+		for key, offset := range cmd.Offsets {
+			messages[key] = m.read(key, offset, 3)
+		}
+		return messages, nil
+
+	case "commit_offsets":
+		// Committed offsets only move forward, as in the KV
+		for key, offset := range cmd.Offsets {
+			if committed, ok := m.committed(cmd.Group)[key]; !ok || offset > committed {
+				m.committed(cmd.Group)[key] = offset
+			}
+		}
+		return nil, nil
+
+	case "list_committed_offsets":
+		offsets := make(map[string]int)
+		for _, key := range cmd.Keys {
+			if committed, ok := m.committed(cmd.Group)[key]; ok {
+				offsets[key] = committed
+			}
+		}
+		return offsets, nil
+
+	case "consume":
+		limit := cmd.Limit
+		if limit <= 0 {
+			limit = 3
+		}
+
+		// Commands apply one at a time, so no other consumer can commit between the read and the commit
+		result := ConsumeResult{Messages: map[string][][]int{}, Offsets: map[string]int{}}
+		for _, key := range cmd.Keys {
+			committed, ok := m.committed(cmd.Group)[key]
+			if !ok {
+				committed = -1
+			}
+
+			messages := m.read(key, committed+1, limit)
+			result.Messages[key] = messages
+			if len(messages) > 0 {
+				last := messages[len(messages)-1][0]
+				m.committed(cmd.Group)[key] = last
+				result.Offsets[key] = last
+			}
+		}
+		return result, nil
+	}
+
+	return nil, maelstrom.NewRPCError(maelstrom.NotSupported, "unknown operation "+cmd.Op)
+}
+
+// Returns up to limit [offset, message] pairs from a log, starting at offset
+// Must be called with m.mu held
+func (m *LogMachine) read(key string, offset int, limit int) [][]int {
+	messages := [][]int{}
+	log := m.state.Logs[key]
+	for i := max(offset, 0); i < len(log) && len(messages) < limit; i++ {
+		messages = append(messages, []int{i, log[i].Message})
+	}
+	return messages
+}
+
+// Returns a group's committed offsets, creating them if the group hasn't committed any yet
+// Must be called with m.mu held
+func (m *LogMachine) committed(group string) map[string]int {
+	offsets, ok := m.state.Committed[group]
+	if !ok {
+		offsets = make(map[string]int)
+		m.state.Committed[group] = offsets
+	}
+	return offsets
+}
+
+func (m *LogMachine) Snapshot() (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Marshalled here, the logs keep changing once the lock is released
+	data, err := json.Marshal(m.state)
+	return json.RawMessage(data), err
+}
+
+func (m *LogMachine) Restore(data json.RawMessage) error {
+	var state LogMachineState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	for key, log := range state.Logs {
+		for offset, entry := range log {
+			if !entry.Verify(key, offset) {
+				return fmt.Errorf("checksum mismatch at %s offset %d", key, offset)
+			}
+		}
+	}
+	if state.Logs == nil {
+		state.Logs = map[string][]LogEntry{}
+	}
+	if state.Committed == nil {
+		state.Committed = map[string]map[string]int{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+	return nil
+}
+
+// Registers the log handlers of a replicated broker on node
+func registerRSM(node *maelstrom.Node) error {
+	ctx := context.Background()
+	clock := env.Of(node).Clock
+
+	replicated, err := rsm.New(node, "kafka", NewLogMachine())
+	if err != nil {
+		return err
+	}
+
+	// The log needs the cluster's node IDs, so it only starts once they're known
+	node.Handle("init", func(msg maelstrom.Message) error {
+		lifecycle.Of(node).Go(replicated.Run)
+		return nil
+	})
+
+	handler.Handle(node, "send", func(msg maelstrom.Message, body SendRequestBody) (SendResponseBody, error) {
+		offset, err := rsm.Execute[int](ctx, replicated, LogCommand{Op: "send", Key: body.Key, Message: body.Message, Timestamp: clock.Now().UnixMilli()})
+		return SendResponseBody{Offset: offset}, err
+	})
+
+	handler.Handle(node, "poll", func(msg maelstrom.Message, body PollRequestBody) (PollResponseBody, error) {
+		messages, err := rsm.Execute[map[string][][]int](ctx, replicated, LogCommand{Op: "poll", Offsets: body.Offsets})
+		return PollResponseBody{Messages: messages}, err
+	})
+
+	handler.Handle(node, "commit_offsets", func(msg maelstrom.Message, body CommitOffsetsRequestBody) (CommitOffsetsResponseBody, error) {
+		_, err := rsm.Execute[any](ctx, replicated, LogCommand{Op: "commit_offsets", Group: body.Group, Offsets: body.Offsets})
+		return CommitOffsetsResponseBody{}, err
+	})
+
+	handler.Handle(node, "list_committed_offsets", func(msg maelstrom.Message, body ListCommittedOffsetsRequestBody) (ListCommittedOffsetsResponseBody, error) {
+		offsets, err := rsm.Execute[map[string]int](ctx, replicated, LogCommand{Op: "list_committed_offsets", Group: body.Group, Keys: body.Keys})
+		return ListCommittedOffsetsResponseBody{Offsets: offsets}, err
+	})
+
+	handler.Handle(node, "consume", func(msg maelstrom.Message, body ConsumeRequestBody) (ConsumeResponseBody, error) {
+		result, err := rsm.Execute[ConsumeResult](ctx, replicated, LogCommand{Op: "consume", Group: body.Group, Keys: body.Keys, Limit: body.Limit})
+		return ConsumeResponseBody{Messages: result.Messages, Offsets: result.Offsets}, err
+	})
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func apply(t *testing.T, m *LogMachine, cmd LogCommand) any {
+	t.Helper()
+	data, _ := json.Marshal(cmd)
+	result, err := m.Apply(data)
+	if err != nil {
+		t.Fatalf("%s: %v", cmd.Op, err)
+	}
+	return result
+}
+
+func TestLogMachine(t *testing.T) {
+	m := NewLogMachine()
+	for i := range 4 {
+		if offset := apply(t, m, LogCommand{Op: "send", Key: "k", Message: 100 + i, Timestamp: 1}); offset != i {
+			t.Fatalf("send %d got offset %v", i, offset)
+		}
+	}
+
+	polled := apply(t, m, LogCommand{Op: "poll", Offsets: map[string]int{"k": 2, "missing": 0}})
+	if want := map[string][][]int{"k": {{2, 102}, {3, 103}}, "missing": {}}; !reflect.DeepEqual(polled, want) {
+		t.Errorf("polled %v, want %v", polled, want)
+	}
+
+	// Committed offsets never go back
+	apply(t, m, LogCommand{Op: "commit_offsets", Offsets: map[string]int{"k": 2}})
+	apply(t, m, LogCommand{Op: "commit_offsets", Offsets: map[string]int{"k": 1}})
+	if offsets := apply(t, m, LogCommand{Op: "list_committed_offsets", Keys: []string{"k"}}); !reflect.DeepEqual(offsets, map[string]int{"k": 2}) {
+		t.Errorf("committed offsets %v, want k at 2", offsets)
+	}
+
+	// A group consumes from its own committed offset
+	consumed := apply(t, m, LogCommand{Op: "consume", Group: "g", Keys: []string{"k"}, Limit: 3}).(ConsumeResult)
+	if want := [][]int{{0, 100}, {1, 101}, {2, 102}}; !reflect.DeepEqual(consumed.Messages["k"], want) || consumed.Offsets["k"] != 2 {
+		t.Errorf("consumed %v, offsets %v", consumed.Messages, consumed.Offsets)
+	}
+
+	snapshot, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewLogMachine()
+	if err := restored.Restore(snapshot.(json.RawMessage)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.state, m.state) {
+		t.Errorf("restored %+v, want %+v", restored.state, m.state)
+	}
+
+	// An entry moved to another offset fails its checksum
+	var state LogMachineState
+	json.Unmarshal(snapshot.(json.RawMessage), &state)
+	state.Logs["k"][0], state.Logs["k"][1] = state.Logs["k"][1], state.Logs["k"][0]
+	data, _ := json.Marshal(state)
+	if err := restored.Restore(data); err == nil {
+		t.Error("restored a snapshot with swapped entries")
+	}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+)
+
+/*
+Snapshots
+
+A kafka node's part of a snapshot (see internal/snapshot) is every log in the KV: its retained entries,
+stored with their checksums, its start offset and the default group's committed offset. Named groups'
+offsets aren't included, the KV can't list them.
+
+Restoring writes the entries back at their offsets and only ever moves a log's highest, start and
+committed offsets forward, so restoring into a log that has moved on since keeps its newer messages.
+An owner's cached highest offset that falls behind fails its next compare-and-swap and is read again.
+*/
+
+type LogSnapshot struct {
+	StartOffset     int              `json:"start_offset"`
+	HighestOffset   int              `json:"highest_offset"`
+	Entries         map[int]LogEntry `json:"entries"`
+	CommittedOffset *int             `json:"committed_offset,omitempty"`
+}
+
+// Returns every log in the KV
+func (b *Broker) Snapshot(ctx context.Context) (map[string]LogSnapshot, error) {
+	logs, err := listLogs(ctx, b.kv)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]LogSnapshot)
+	for _, key := range logs {
+		log := LogSnapshot{Entries: make(map[int]LogEntry)}
+
+		if log.HighestOffset, err = kvutil.Read(ctx, b.kv, fmt.Sprintf("%s/highest_offset", key), -1); err != nil {
+			return nil, err
+		}
+		if log.StartOffset, err = kvutil.Read(ctx, b.kv, logStartOffsetKey(key), 0); err != nil {
+			return nil, err
+		}
+
+		// Offsets reserved by a send that never wrote its entry are skipped
+		for i := log.StartOffset; i <= log.HighestOffset; i++ {
+			var entry *LogEntry
+			if err := b.kv.ReadInto(ctx, fmt.Sprintf("%s/data/%d", key, i), &entry); err != nil && !kvutil.IsNotFound(err) {
+				return nil, err
+			}
+			if entry != nil {
+				log.Entries[i] = *entry
+			}
+		}
+
+		if committed, err := b.kv.ReadInt(ctx, committedOffsetKey(key, "")); err == nil {
+			log.CommittedOffset = &committed
+		} else if !kvutil.IsNotFound(err) {
+			return nil, err
+		}
+
+		snapshot[key] = log
+	}
+	return snapshot, nil
+}
+
+// Writes logs from a snapshot back to the KV
+func (b *Broker) Restore(ctx context.Context, logs map[string]LogSnapshot) error {
+	for key, log := range logs {
+		for offset, entry := range log.Entries {
+			if !entry.Verify(key, offset) {
+				return fmt.Errorf("checksum mismatch at %s offset %d", key, offset)
+			}
+		}
+
+		if err := registerLog(ctx, b.kv, key); err != nil {
+			return err
+		}
+
+		for offset, entry := range log.Entries {
+			if err := b.kv.Write(ctx, fmt.Sprintf("%s/data/%d", key, offset), entry); err != nil {
+				return err
+			}
+		}
+
+		// Offsets only move forward
+		forward := func(to int) func(old int) (int, error) {
+			return func(old int) (int, error) {
+				if old >= to {
+					return 0, kvutil.ErrNoChange
+				}
+				return to, nil
+			}
+		}
+
+		if _, err := kvutil.Update(ctx, b.kv, fmt.Sprintf("%s/highest_offset", key), -1, forward(log.HighestOffset)); err != nil {
+			return err
+		}
+		if _, err := kvutil.Update(ctx, b.kv, logStartOffsetKey(key), 0, forward(log.StartOffset)); err != nil {
+			return err
+		}
+		if log.CommittedOffset != nil {
+			if _, err := kvutil.Update(ctx, b.kv, committedOffsetKey(key, ""), -1, forward(*log.CommittedOffset)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"sync/atomic"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+type StatsResponseBody struct {
+	Type               string `json:"type"`
+	Sent               int64  `json:"sent"`
+	Polled             int64  `json:"polled"`
+	ChecksumMismatches int64  `json:"checksum_mismatches"`
+	LamportTime        int64  `json:"lamport_time"` // this node's Lamport clock, see internal/lamport
+	ClockDrifts        int64  `json:"clock_drifts"` // handoff timestamps refused for being too far ahead, see MAX_CLOCK_DRIFT_MS
+}
+
+// Operational counters reported by the stats RPC
+type Stats struct {
+	sent               atomic.Int64
+	polled             atomic.Int64
+	checksumMismatches atomic.Int64
+}
+
+// Counts an error returned while serving a request, if it's one the stats track
+func (s *Stats) CountError(err error) {
+	if maelstrom.ErrorCode(err) == ChecksumMismatch {
+		s.checksumMismatches.Add(1)
+	}
+}
+
+func (s *Stats) Response() StatsResponseBody {
+	return StatsResponseBody{
+		Type:               "stats_ok",
+		Sent:               s.sent.Load(),
+		Polled:             s.polled.Load(),
+		ChecksumMismatches: s.checksumMismatches.Load(),
+	}
+}
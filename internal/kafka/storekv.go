@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Local KV
+
+A KV kept in the node's own store (see internal/storage) instead of lin-kv, picked with KAFKA_KV=local.
+Nothing is shared with other nodes, so it's only correct for a single-node log (Part a), but with the
+file backend the logs and committed offsets survive the node restarting. Values are stored as JSON and
+read back the way the maelstrom client returns them, with numbers as ints.
+*/
+
+type storeKV struct {
+	node *maelstrom.Node
+
+	// Held across a compare-and-swap's read and write
+	mu sync.Mutex
+}
+
+func newStoreKV(node *maelstrom.Node) *storeKV {
+	return &storeKV{node: node}
+}
+
+// Returns the raw JSON stored under key, a KeyDoesNotExist error if there's none
+func (kv *storeKV) get(key string) ([]byte, error) {
+	store, err := storage.Of(kv.node)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := store.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return raw, err
+}
+
+func (kv *storeKV) put(key string, value any) error {
+	store, err := storage.Of(kv.node)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.Put(key, raw)
+}
+
+func (kv *storeKV) Read(ctx context.Context, key string) (any, error) {
+	kv.mu.Lock()
+	raw, err := kv.get(key)
+	kv.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+
+	// Numbers come back as ints, like the maelstrom client
+	if f, ok := v.(float64); ok {
+		return int(f), nil
+	}
+	return v, nil
+}
+
+func (kv *storeKV) ReadInt(ctx context.Context, key string) (int, error) {
+	v, err := kv.Read(ctx, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+func (kv *storeKV) ReadInto(ctx context.Context, key string, v any) error {
+	kv.mu.Lock()
+	raw, err := kv.get(key)
+	kv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (kv *storeKV) Write(ctx context.Context, key string, value any) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.put(key, value)
+}
+
+// Removes key from the store, used by retention (see retention.go)
+func (kv *storeKV) Delete(ctx context.Context, key string) error {
+	store, err := storage.Of(kv.node)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return store.Delete(key)
+}
+
+func (kv *storeKV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	raw, err := kv.get(key)
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.KeyDoesNotExist && createIfNotExists {
+		return kv.put(key, to)
+	} else if err != nil {
+		return err
+	}
+
+	if !sameJSON(raw, from) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "current value does not match")
+	}
+	return kv.put(key, to)
+}
+
+// Compares a stored JSON value against a Go value after normalising both through JSON
+func sameJSON(raw []byte, v any) bool {
+	fromRaw, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+
+	var a, b any
+	if json.Unmarshal(raw, &a) != nil || json.Unmarshal(fromRaw, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,109 @@
+package kvutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Read-modify-write on Maelstrom's key/value services
+
+Updating a key in seq-kv or lin-kv means reading it, working out the new value and writing it with a
+compare-and-swap, then starting over if another node changed the key in between. Update does that
+loop: a missing key reads as a default value and is created by the swap, conflicts are retried with
+jittered exponential backoff (from minBackoff up to maxBackoff) until the context ends, and any other
+error is returned straight away.
+*/
+
+const (
+	minBackoff = time.Millisecond
+	maxBackoff = 100 * time.Millisecond
+)
+
+// The subset of the maelstrom KV client Update needs, satisfied by *maelstrom.KV
+type KV interface {
+	ReadInto(ctx context.Context, key string, v any) error
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
+
+// Returned by an update function to leave the key as it is, Update then returns the current value
+var ErrNoChange = errors.New("kvutil: no change")
+
+// Returned by Update when the context ends while other writers keep winning the compare-and-swap
+type ConflictError struct {
+	Key      string
+	Attempts int
+	Err      error // the context's error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("kvutil: %s still contended after %d attempts: %s", e.Key, e.Attempts, e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// Returns whether err is Maelstrom's KeyDoesNotExist
+func IsNotFound(err error) bool {
+	var rpcErr *maelstrom.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.KeyDoesNotExist
+}
+
+// Returns whether err is Maelstrom's PreconditionFailed, a compare-and-swap that lost
+func IsConflict(err error) bool {
+	var rpcErr *maelstrom.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.PreconditionFailed
+}
+
+// Reads key, or returns def if it doesn't exist
+func Read[T any](ctx context.Context, kv KV, key string, def T) (T, error) {
+	var value T
+	if err := kv.ReadInto(ctx, key, &value); err != nil {
+		if IsNotFound(err) {
+			return def, nil
+		}
+		return value, err
+	}
+	return value, nil
+}
+
+// Replaces key's value with update(old value), def if it doesn't exist, and returns the value written
+// update may be called several times, once per attempt, so it shouldn't have side effects that outlive one
+func Update[T any](ctx context.Context, kv KV, key string, def T, update func(old T) (T, error)) (T, error) {
+	backoff := minBackoff
+
+	for attempts := 1; ; attempts++ {
+		old, err := Read(ctx, kv, key, def)
+		if err != nil {
+			return old, err
+		}
+
+		value, err := update(old)
+		if errors.Is(err, ErrNoChange) {
+			return old, nil
+		} else if err != nil {
+			return old, err
+		}
+
+		err = kv.CompareAndSwap(ctx, key, old, value, true)
+		if err == nil {
+			return value, nil
+		} else if !IsConflict(err) {
+			return old, err
+		}
+
+		// Someone else wrote the key first, wait a little so the writers don't keep colliding
+		select {
+		case <-ctx.Done():
+			return old, &ConflictError{Key: key, Attempts: attempts, Err: ctx.Err()}
+		case <-time.After(rand.N(backoff) + 1):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -0,0 +1,119 @@
+package kvutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+)
+
+// A KV another writer changes behind the caller's back before each of its first swaps
+type contended struct {
+	*fake.KV
+	interfere int
+}
+
+func (kv *contended) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if kv.interfere > 0 {
+		kv.interfere--
+		current, _ := Read(ctx, kv.KV, key, 0)
+		kv.Write(ctx, key, current+100)
+	}
+	return kv.KV.CompareAndSwap(ctx, key, from, to, createIfNotExists)
+}
+
+func TestReadDefault(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+
+	if value, err := Read(ctx, kv, "k", 7); err != nil || value != 7 {
+		t.Errorf("missing key read %d, %v, want the default 7", value, err)
+	}
+
+	kv.Write(ctx, "k", 3)
+	if value, err := Read(ctx, kv, "k", 7); err != nil || value != 3 {
+		t.Errorf("read %d, %v, want 3", value, err)
+	}
+}
+
+func TestUpdateCreatesMissingKey(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+
+	value, err := Update(ctx, kv, "k", 10, func(old int) (int, error) { return old + 1, nil })
+	if err != nil || value != 11 {
+		t.Fatalf("update returned %d, %v, want 11", value, err)
+	}
+	if stored, _ := Read(ctx, kv, "k", 0); stored != 11 {
+		t.Errorf("stored %d, want 11", stored)
+	}
+}
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	ctx := context.Background()
+	kv := &contended{KV: fake.NewKV(), interfere: 2}
+
+	// Each retry starts over from the other writer's value
+	var seen []int
+	value, err := Update(ctx, kv, "k", 0, func(old int) (int, error) {
+		seen = append(seen, old)
+		return old + 1, nil
+	})
+	if err != nil || value != 201 {
+		t.Fatalf("update returned %d, %v, want 201", value, err)
+	}
+	if len(seen) != 3 || seen[0] != 0 || seen[1] != 100 || seen[2] != 200 {
+		t.Errorf("update saw %v, want 0, 100 then 200", seen)
+	}
+}
+
+func TestUpdateNoChange(t *testing.T) {
+	ctx := context.Background()
+	kv := fake.NewKV()
+	kv.Write(ctx, "k", 5)
+
+	value, err := Update(ctx, kv, "k", 0, func(old int) (int, error) { return 0, ErrNoChange })
+	if err != nil || value != 5 {
+		t.Errorf("update returned %d, %v, want the current 5", value, err)
+	}
+
+	// Nothing is written, not even the default of a missing key
+	if _, err := Update(ctx, kv, "missing", 1, func(old int) (int, error) { return 0, ErrNoChange }); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := kv.ReadInto(ctx, "missing", &v); !IsNotFound(err) {
+		t.Errorf("missing key read %d, %v, want it still missing", v, err)
+	}
+}
+
+func TestUpdateReturnsOtherErrors(t *testing.T) {
+	failed := errors.New("invalid")
+
+	attempts := 0
+	_, err := Update(context.Background(), fake.NewKV(), "k", 0, func(old int) (int, error) {
+		attempts++
+		return 0, failed
+	})
+	if !errors.Is(err, failed) || attempts != 1 {
+		t.Errorf("err = %v after %d attempts, want the update's error after 1", err, attempts)
+	}
+}
+
+func TestUpdateConflictOnContextExpiry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	kv := &contended{KV: fake.NewKV(), interfere: 1 << 30}
+
+	_, err := Update(ctx, kv, "k", 0, func(old int) (int, error) { return old + 1, nil })
+
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Key != "k" || conflict.Attempts < 2 {
+		t.Fatalf("err = %v, want a ConflictError for k after several attempts", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want it to wrap the context's error", err)
+	}
+}
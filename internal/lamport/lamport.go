@@ -0,0 +1,154 @@
+package lamport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Lamport clocks
+
+A logical clock that orders events across nodes: every message a node sends to another node carries the
+sender's time in a lamport field of its body, and the receiver moves its clock past it before handling
+the message. So an event that could have caused another (by sending it a message, directly or through
+other nodes) always has a smaller time, though a smaller time doesn't mean one event caused the other.
+
+The txn and kafka workloads attach one and report its time in their stats, for following the causal
+order through a run. They don't order anything by it: txn's last-writer-wins and kafka's handoffs use
+hybrid logical clocks (see internal/hlc), which order events the same way but also stay close to wall
+time, which TTLs and retention count in.
+
+Attach hooks the clock into a node's input and output, so messages are stamped and observed without any
+changes to the code sending or handling them. Messages to and from clients and services are left alone.
+*/
+
+type Clock struct {
+	mu   sync.Mutex
+	time int64
+}
+
+// Returns the current time without advancing it
+func (c *Clock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.time
+}
+
+// Advances the clock for a local event and returns its time
+func (c *Clock) Tick() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.time++
+	return c.time
+}
+
+// Advances the clock past a time received from another node and returns the receipt's time
+func (c *Clock) Witness(time int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.time = max(c.time, time) + 1
+	return c.time
+}
+
+// Returns the sender's time stamped on msg, 0 if it came from a client or service
+func Stamp(msg maelstrom.Message) int64 {
+	var body struct {
+		Lamport int64 `json:"lamport"`
+	}
+	json.Unmarshal(msg.Body, &body)
+	return body.Lamport
+}
+
+// Creates a clock stamping every message node sends to another node and witnessing every stamp it
+// receives, must be called before node.Run
+func Attach(node *maelstrom.Node) *Clock {
+	c := &Clock{}
+	node.Stdin = &reader{r: bufio.NewReader(node.Stdin), clock: c}
+	node.Stdout = &writer{w: node.Stdout, node: node, clock: c}
+	return c
+}
+
+// Witnesses the stamp of every line read through it
+type reader struct {
+	r     *bufio.Reader
+	clock *Clock
+	line  []byte // rest of the last line read, not yet returned
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(r.line) == 0 {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+
+		var msg maelstrom.Message
+		if json.Unmarshal(line, &msg) == nil {
+			if time := Stamp(msg); time > 0 {
+				r.clock.Witness(time)
+			}
+		}
+		r.line = line
+	}
+
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+	return n, nil
+}
+
+// Stamps every message written through it that's addressed to another node
+// The node writes a message and its newline separately under its own lock, so lines are buffered whole
+type writer struct {
+	w     io.Writer
+	node  *maelstrom.Node
+	clock *Clock
+	buf   []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := append(w.stamp(w.buf[:i]), '\n')
+		w.buf = w.buf[i+1:]
+
+		if _, err := w.w.Write(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Returns line with the clock's time added to its body, or unchanged if it isn't for another node
+func (w *writer) stamp(line []byte) []byte {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil || !slices.Contains(w.node.NodeIDs(), msg.Dest) {
+		return slices.Clone(line)
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return slices.Clone(line)
+	}
+
+	body["lamport"], _ = json.Marshal(w.clock.Tick())
+	msg.Body, _ = json.Marshal(body)
+
+	stamped, err := json.Marshal(msg)
+	if err != nil {
+		return slices.Clone(line)
+	}
+	return stamped
+}
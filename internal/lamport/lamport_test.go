@@ -0,0 +1,72 @@
+package lamport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestClock(t *testing.T) {
+	var c Clock
+	steps := []struct {
+		name string
+		step func() int64
+		want int64
+	}{
+		{"tick", c.Tick, 1},
+		{"witness a later time", func() int64 { return c.Witness(5) }, 6},
+		{"witness an earlier time", func() int64 { return c.Witness(2) }, 7},
+		{"tick after witnessing", c.Tick, 8},
+	}
+
+	for _, s := range steps {
+		if got := s.step(); got != s.want {
+			t.Fatalf("%s: time %d, want %d", s.name, got, s.want)
+		}
+	}
+	if now := c.Now(); now != 8 {
+		t.Fatalf("now %d, want 8", now)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	var out bytes.Buffer
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1"})
+	node.Stdin = strings.NewReader(`{"src":"n1","dest":"n0","body":{"type":"ping","lamport":5}}` + "\n" +
+		`{"src":"c1","dest":"n0","body":{"type":"read"}}` + "\n")
+	node.Stdout = &out
+	clock := Attach(node)
+
+	// Stamps are witnessed, and the lines passed on as they are
+	in, err := io.ReadAll(node.Stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(in), "\n"); lines != 2 {
+		t.Fatalf("read %d lines, want 2", lines)
+	}
+	if now := clock.Now(); now != 6 {
+		t.Fatalf("time %d after receiving a stamp of 5, want 6", now)
+	}
+
+	// Only messages to other nodes are stamped
+	node.Send("n1", map[string]any{"type": "ping"})
+	node.Send("c1", map[string]any{"type": "read_ok"})
+
+	var stamps []int64
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg maelstrom.Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("wrote %q: %v", line, err)
+		}
+		stamps = append(stamps, Stamp(msg))
+	}
+	if len(stamps) != 2 || stamps[0] != 7 || stamps[1] != 0 {
+		t.Fatalf("stamps %v, want 7 for the node and none for the client", stamps)
+	}
+}
@@ -0,0 +1,342 @@
+package lifecycle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Graceful shutdown
+
+A node's background work and what has to happen before it exits. Workloads start their long-running
+goroutines with Go, which hands them a context cancelled at shutdown and lets the shutdown wait for them,
+and register with OnStop whatever has to be done once they've stopped, such as flushing queued gossip or
+writing a last checkpoint.
+
+Serve runs the node in place of Node.Run. On SIGTERM or SIGINT, or once stdin is closed, it shuts down:
+ 1. new requests are answered with TemporarilyUnavailable without reaching a handler, while replies keep
+    being delivered, so handlers waiting on an RPC can still finish
+ 2. once every request already accepted has been answered, the context is cancelled and the goroutines
+    started with Go are waited for
+ 3. the OnStop hooks run, the last registered first
+ 4. Serve returns
+
+SHUTDOWN_TIMEOUT_MS (default 5000) bounds steps 1 and 2, the hooks run regardless and get a context
+that's done by then, so anything that only needs the disk still happens.
+
+Pause holds a node's background work until Resume, for an operator to look at a node that isn't changing
+underneath them (see internal/admin). It's up to each goroutine started with Go to honour it: periodic
+tasks skip their ticks while Paused returns true for their context, queue consumers block in AwaitResume.
+Requests are still handled while paused.
+*/
+
+var logger = logging.For("lifecycle")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// SHUTDOWN_TIMEOUT_MS, how long in-flight requests and background goroutines get to finish (default 5000)
+	Timeout time.Duration `env:"SHUTDOWN_TIMEOUT_MS" min:"1"`
+}
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// A request accepted by a handler and not answered yet
+type request struct {
+	src   string
+	msgID int
+}
+
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	hooks    []hook
+	draining bool
+	inflight map[request]struct{}
+	idle     chan struct{} // closed once draining with nothing in flight
+	paused   bool
+	resumed  chan struct{} // closed while not paused
+}
+
+// Key of the lifecycle in the contexts it hands out
+type lifecycleKey struct{}
+
+var (
+	mu         sync.Mutex
+	lifecycles = map[*maelstrom.Node]*Lifecycle{}
+)
+
+// Returns node's lifecycle
+func Of(node *maelstrom.Node) *Lifecycle {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := lifecycles[node]
+	if !ok {
+		l = &Lifecycle{inflight: map[request]struct{}{}, idle: make(chan struct{}), resumed: make(chan struct{})}
+		close(l.resumed)
+		l.ctx, l.cancel = context.WithCancel(context.WithValue(context.Background(), lifecycleKey{}, l))
+		lifecycles[node] = l
+	}
+	return l
+}
+
+// Returns the context cancelled once in-flight requests are drained
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Runs run in a goroutine with the lifecycle's context, shutdown waits for it to return
+func (l *Lifecycle) Go(run func(ctx context.Context)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		run(l.ctx)
+	}()
+}
+
+// Holds the node's background work until Resume
+func (l *Lifecycle) Pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.paused {
+		l.paused = true
+		l.resumed = make(chan struct{})
+		logger.Info("background work paused")
+	}
+}
+
+func (l *Lifecycle) Resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.paused {
+		l.paused = false
+		close(l.resumed)
+		logger.Info("background work resumed")
+	}
+}
+
+func (l *Lifecycle) Paused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paused
+}
+
+// Returns whether the background work of the lifecycle ctx came from is paused, false for other contexts
+func Paused(ctx context.Context) bool {
+	l, ok := ctx.Value(lifecycleKey{}).(*Lifecycle)
+	return ok && l.Paused()
+}
+
+// Waits until the background work of the lifecycle ctx came from isn't paused, or ctx is done
+func AwaitResume(ctx context.Context) error {
+	l, ok := ctx.Value(lifecycleKey{}).(*Lifecycle)
+	if !ok {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	resumed := l.resumed
+	l.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Registers stop to run at shutdown, after the goroutines started with Go have returned
+func (l *Lifecycle) OnStop(name string, stop func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook{name: name, stop: stop})
+}
+
+// Drains requests, stops the background goroutines and runs the hooks, waiting up to timeout
+// Returns the hooks' errors
+func (l *Lifecycle) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	l.mu.Lock()
+	l.draining = true
+	l.checkIdle()
+	l.mu.Unlock()
+
+	select {
+	case <-l.idle:
+	case <-ctx.Done():
+		l.mu.Lock()
+		logger.Warn("requests still in flight", "requests", len(l.inflight))
+		l.mu.Unlock()
+	}
+
+	l.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warn("background goroutines still running")
+	}
+
+	l.mu.Lock()
+	hooks := l.hooks
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].stop(ctx); err != nil {
+			logger.Error("stop hook failed", "hook", hooks[i].name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Must be called with l.mu held
+func (l *Lifecycle) checkIdle() {
+	if l.draining && len(l.inflight) == 0 {
+		select {
+		case <-l.idle:
+		default:
+			close(l.idle)
+		}
+	}
+}
+
+// Records a request being handed to a handler, false if it's refused because the node is shutting down
+func (l *Lifecycle) accept(req request) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.draining {
+		return false
+	}
+	if req.msgID != 0 {
+		l.inflight[req] = struct{}{}
+	}
+	return true
+}
+
+func (l *Lifecycle) answered(req request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.inflight, req)
+	l.checkIdle()
+}
+
+// Runs n until a signal or the end of its input, then shuts it down
+func Serve(n *maelstrom.Node) error {
+	cfg := Config{Timeout: 5 * time.Second}
+	if err := config.Load("lifecycle", &cfg); err != nil {
+		return err
+	}
+	l := Of(n)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// The node reads from a pipe fed by forward, which holds back requests once draining starts
+	input := n.Stdin
+	reader, writer := io.Pipe()
+	n.Stdin = reader
+	n.Stdout = &replyWatcher{w: n.Stdout, l: l}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- l.forward(n, input, writer)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- n.Run()
+	}()
+
+	select {
+	case sig := <-signals:
+		logger.Info("shutting down", "reason", sig.String())
+	case err := <-closed:
+		if err != nil {
+			return err
+		}
+		logger.Info("shutting down", "reason", "stdin closed")
+	case err := <-done:
+		return err
+	}
+
+	err := l.Shutdown(cfg.Timeout)
+	writer.Close()
+	return err
+}
+
+// Copies lines from r to w, answering new requests itself once the node is draining
+func (l *Lifecycle) forward(n *maelstrom.Node, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var msg maelstrom.Message
+		var body maelstrom.MessageBody
+		if err := json.Unmarshal(line, &msg); err == nil {
+			json.Unmarshal(msg.Body, &body)
+		}
+
+		// Replies are always delivered, a handler may be waiting on one to finish
+		if body.InReplyTo == 0 && !l.accept(request{src: msg.Src, msgID: body.MsgID}) {
+			if body.MsgID != 0 {
+				n.Reply(msg, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "node is shutting down"))
+			}
+			continue
+		}
+
+		// The scanner reuses its buffer, so the line is copied rather than appended to
+		if _, err := w.Write(append(slices.Clip(line), '\n')); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Marks requests answered as the node's replies go out
+type replyWatcher struct {
+	w io.Writer
+	l *Lifecycle
+}
+
+func (r *replyWatcher) Write(p []byte) (int, error) {
+	var msg maelstrom.Message
+	var body maelstrom.MessageBody
+	if json.Unmarshal(p, &msg) == nil && json.Unmarshal(msg.Body, &body) == nil && body.InReplyTo != 0 {
+		defer r.l.answered(request{src: msg.Dest, msgID: body.InReplyTo})
+	}
+	return r.w.Write(p)
+}
@@ -0,0 +1,128 @@
+package lifecycle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestServeDrainsBeforeStopping(t *testing.T) {
+	n := maelstrom.NewNode()
+	stdin, input := io.Pipe()
+	output, stdout := io.Pipe()
+	n.Stdin, n.Stdout = stdin, stdout
+
+	replies := make(chan maelstrom.MessageBody, 16)
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			var msg maelstrom.Message
+			var body maelstrom.MessageBody
+			json.Unmarshal(scanner.Bytes(), &msg)
+			json.Unmarshal(msg.Body, &body)
+			replies <- body
+		}
+	}()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	n.Handle("slow", func(msg maelstrom.Message) error {
+		close(started)
+		<-release
+		return n.Reply(msg, map[string]any{"type": "slow_ok"})
+	})
+	n.Handle("fast", func(msg maelstrom.Message) error {
+		return n.Reply(msg, map[string]any{"type": "fast_ok"})
+	})
+
+	var stopped atomic.Bool
+	l := Of(n)
+	l.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		stopped.Store(true)
+	})
+	hookRan := make(chan bool, 1)
+	l.OnStop("check", func(ctx context.Context) error {
+		hookRan <- stopped.Load()
+		return nil
+	})
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(n) }()
+
+	send := func(typ string, msgID int) {
+		fmt.Fprintf(input, `{"src":"c1","dest":"n1","body":{"type":%q,"msg_id":%d}}`+"\n", typ, msgID)
+	}
+
+	send("slow", 1)
+	<-started
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests keep being handled until the signal is seen, then they're refused
+	deadline := time.After(5 * time.Second)
+refused:
+	for msgID := 2; ; msgID++ {
+		send("fast", msgID)
+		select {
+		case body := <-replies:
+			if body.Type == "error" && body.Code == maelstrom.TemporarilyUnavailable {
+				break refused
+			}
+		case <-deadline:
+			t.Fatal("new requests still accepted")
+		}
+	}
+
+	select {
+	case <-hookRan:
+		t.Fatal("stopped with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if body := <-replies; body.Type != "slow_ok" {
+		t.Fatalf("reply %+v, want slow_ok", body)
+	}
+	if !<-hookRan {
+		t.Fatal("hook ran before the background goroutine stopped")
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	l := Of(maelstrom.NewNode())
+	ctx := l.Context()
+
+	if Paused(ctx) || Paused(context.Background()) {
+		t.Fatal("paused before Pause")
+	}
+
+	l.Pause()
+	if !Paused(ctx) {
+		t.Fatal("not paused after Pause")
+	}
+
+	resumed := make(chan error, 1)
+	go func() { resumed <- AwaitResume(ctx) }()
+	select {
+	case <-resumed:
+		t.Fatal("AwaitResume returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.Resume()
+	if err := <-resumed; err != nil || Paused(ctx) {
+		t.Fatalf("AwaitResume = %v after Resume", err)
+	}
+}
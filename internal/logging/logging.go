@@ -0,0 +1,192 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Structured logging
+
+Every module logs through its own logger from For, which writes JSON lines to stderr (Maelstrom keeps
+stderr as the node's log) with the module's name, the node's ID once Setup knows the node, and, when
+the context passed to a *Context method came from WithMessage, the type, msg_id and src of the message
+being handled, so a line can be matched to the message in Maelstrom's logs.
+
+LOG_LEVEL sets how much each module logs: a default level followed by overrides per module, e.g.
+"warn,raft=debug,maelstrom=error". Levels are debug, info, warn and error, info if unset. Setup also
+sends the standard log package, which the Maelstrom library logs every message it sends and receives
+through, to the maelstrom module.
+*/
+
+type state struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(&state{handler: slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})})
+}
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// LOG_LEVEL, the default level and per-module overrides, e.g. "warn,raft=debug"
+	Level string `env:"LOG_LEVEL"`
+}
+
+// The levels have to parse
+func (c *Config) Validate() error {
+	if _, _, err := ParseLevels(c.Level); err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	return nil
+}
+
+// Configures logging for the process, which runs n, from LOG_LEVEL
+func Setup(n *maelstrom.Node) error {
+	var cfg Config
+	if err := config.Load("logging", &cfg); err != nil {
+		return err
+	}
+	level, levels, _ := ParseLevels(cfg.Level)
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	current.Store(&state{handler: &nodeHandler{Handler: handler, node: n}, level: level, levels: levels})
+
+	log.SetFlags(0)
+	log.SetOutput(&lineWriter{logger: For("maelstrom")})
+	return nil
+}
+
+// Parses a default level and per-module overrides like "warn,raft=debug"
+func ParseLevels(s string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	levels := map[string]slog.Level{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		module, name, found := strings.Cut(part, "=")
+		if !found {
+			module, name = "", part
+		}
+
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return 0, nil, err
+		}
+		if module == "" {
+			level = l
+		} else {
+			levels[module] = l
+		}
+	}
+
+	return level, levels, nil
+}
+
+// Returns the logger of module
+func For(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module})
+}
+
+type messageKey struct{}
+
+// Returns a copy of ctx that adds msg's type, msg_id and src to every line logged with it
+func WithMessage(ctx context.Context, msg maelstrom.Message) context.Context {
+	return context.WithValue(ctx, messageKey{}, msg)
+}
+
+// Looks up the configuration on every call, so loggers made before Setup (say in package variables)
+// follow it
+type moduleHandler struct {
+	module string
+	wrap   []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	c := current.Load()
+
+	threshold, ok := c.levels[h.module]
+	if !ok {
+		threshold = c.level
+	}
+	return level >= threshold
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	var handler slog.Handler = current.Load().handler
+	handler = handler.WithAttrs([]slog.Attr{slog.String("module", h.module)})
+
+	if msg, ok := ctx.Value(messageKey{}).(maelstrom.Message); ok {
+		var body maelstrom.MessageBody
+		json.Unmarshal(msg.Body, &body)
+		handler = handler.WithAttrs([]slog.Attr{slog.String("type", body.Type), slog.Int("msg_id", body.MsgID), slog.String("src", msg.Src)})
+	}
+
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	return &moduleHandler{module: h.module, wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], wrap)}
+}
+
+// Adds the node's ID, once it has one
+type nodeHandler struct {
+	slog.Handler
+	node *maelstrom.Node
+}
+
+func (h *nodeHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := h.node.ID(); id != "" {
+		r.AddAttrs(slog.String("node", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *nodeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &nodeHandler{Handler: h.Handler.WithAttrs(attrs), node: h.node}
+}
+
+func (h *nodeHandler) WithGroup(name string) slog.Handler {
+	return &nodeHandler{Handler: h.Handler.WithGroup(name), node: h.node}
+}
+
+// Logs each line written to it at info
+type lineWriter struct {
+	logger *slog.Logger
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.logger.Info(string(line))
+	}
+	return len(p), nil
+}
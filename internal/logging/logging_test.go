@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	level, levels, err := ParseLevels("warn, raft=debug,maelstrom=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != slog.LevelWarn || levels["raft"] != slog.LevelDebug || levels["maelstrom"] != slog.LevelError {
+		t.Fatalf("got %v %v", level, levels)
+	}
+
+	if level, _, _ := ParseLevels(""); level != slog.LevelInfo {
+		t.Fatalf("default level %v, want info", level)
+	}
+	if _, _, err := ParseLevels("raft=loud"); err == nil {
+		t.Fatal("unknown level accepted")
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	old := current.Load()
+	defer current.Store(old)
+
+	current.Store(&state{handler: old.handler, level: slog.LevelWarn, levels: map[string]slog.Level{"raft": slog.LevelDebug}})
+
+	ctx := context.Background()
+	if !For("raft").Enabled(ctx, slog.LevelDebug) {
+		t.Error("raft debug disabled")
+	}
+	if For("txn").Enabled(ctx, slog.LevelInfo) {
+		t.Error("txn info enabled below the default level")
+	}
+}
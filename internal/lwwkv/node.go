@@ -0,0 +1,143 @@
+package lwwkv
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Eventually consistent KV
+
+Goal: serve read, write and cas from each node's own copy and gossip writes to the others, never waiting on
+another node. Concurrent writes to a key are settled by the resolver picked with LWWKV_RESOLVER (see
+store.go), so every node ends up with the same value once gossip catches up.
+
+The contrast piece to the raft and seq-kv workloads: always available, even to a node cut off from the
+rest, but a read can return anything written to the key recently, and cas is only best effort: it compares
+against this node's copy, so two nodes can both swap from the same value and one of the writes is lost.
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// LWWKV_RESOLVER, how concurrent writes are settled, lww (default), fww or max
+	Resolver string `env:"LWWKV_RESOLVER" oneof:"lww|fww|max"`
+}
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string          `json:"type"`
+	Key  json.RawMessage `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Write RPC
+type WriteRequestBody struct {
+	Type  string          `json:"type"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type WriteResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Compare-and-set RPC
+type CASRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type CASResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Registers the KV handlers on n and starts gossiping writes
+func Register(n *maelstrom.Node) error {
+
+	cfg := Config{Resolver: LastWriterWins}
+	if err := config.Load("lwwkv", &cfg); err != nil {
+		return err
+	}
+
+	resolve, err := NewResolver(cfg.Resolver)
+	if err != nil {
+		return err
+	}
+
+	clock := hlc.New()
+	clock.SetWallClock(env.Of(n).Clock.Now)
+	store := NewLWWStore(clock, resolve)
+
+	engine := gossip.New(n, gossip.Store[map[string]Version](store), gossip.Config{
+		Name:        "lwwkv",
+		Interval:    100 * time.Millisecond,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+
+	// Gossip with every other node
+	n.Handle("init", func(msg maelstrom.Message) error {
+		clock.SetNode(n.ID())
+		engine.SetPeers(slices.DeleteFunc(slices.Clone(n.NodeIDs()), func(id string) bool {
+			return id == n.ID()
+		}))
+		return nil
+	})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		v, ok := store.Get(string(compact(body.Key)))
+		if !ok {
+			return ReadResponseBody{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+
+		return ReadResponseBody{
+			Value: v.Value,
+		}, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body WriteRequestBody) (WriteResponseBody, error) {
+		engine.Update(store.Write(string(compact(body.Key)), body.Value))
+		return WriteResponseBody{}, nil
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body CASRequestBody) (CASResponseBody, error) {
+		key := string(compact(body.Key))
+
+		v, ok := store.Get(key)
+		if !ok && !body.CreateIfNotExists {
+			return CASResponseBody{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		if ok && !bytes.Equal(v.Value, compact(body.From)) {
+			return CASResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, "expected "+string(compact(body.From))+", found "+string(v.Value))
+		}
+
+		engine.Update(store.Write(key, body.To))
+		return CASResponseBody{}, nil
+	})
+
+	admin.Of(n).Register("lwwkv", admin.Module{Status: func() any {
+		return map[string]any{"resolver": cfg.Resolver, "keys": len(store.Full())}
+	}})
+
+	lifecycle.Of(n).Go(engine.Run)
+
+	return nil
+}
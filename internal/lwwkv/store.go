@@ -0,0 +1,188 @@
+package lwwkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+)
+
+/*
+Conflict resolution
+
+Every write is stamped with the writing node's hybrid logical clock, and when two nodes wrote the same key
+concurrently, a resolver picks which version every node keeps. For all nodes to end up with the same value
+a resolver must be a total order over versions, so each one falls back to comparing timestamps, which
+differ for every write since they include the node:
+
+  lww   the version with the later timestamp wins (default)
+  fww   the version with the earlier timestamp wins, the first write of a key sticks
+  max   the larger value wins, numbers by value and anything else by its JSON, so a key only ever grows
+*/
+
+const (
+	LastWriterWins  = "lww"
+	FirstWriterWins = "fww"
+	MaxValueWins    = "max"
+)
+
+// A value and the timestamp of the write that set it
+type Version struct {
+	Value     json.RawMessage `json:"value"`
+	Timestamp hlc.Timestamp   `json:"ts"`
+}
+
+// Returns true if b should replace a
+type Resolver func(a, b Version) bool
+
+func NewResolver(name string) (Resolver, error) {
+	switch name {
+	case LastWriterWins, "":
+		return func(a, b Version) bool { return a.Timestamp.Less(b.Timestamp) }, nil
+	case FirstWriterWins:
+		return func(a, b Version) bool { return b.Timestamp.Less(a.Timestamp) }, nil
+	case MaxValueWins:
+		return func(a, b Version) bool {
+			if c := compareValues(a.Value, b.Value); c != 0 {
+				return c < 0
+			}
+			return a.Timestamp.Less(b.Timestamp)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown resolver %q", name)
+}
+
+// Orders numbers numerically, before anything else, and everything else by its JSON
+func compareValues(a, b json.RawMessage) int {
+	var x, y float64
+	aNumber, bNumber := json.Unmarshal(a, &x) == nil, json.Unmarshal(b, &y) == nil
+
+	switch {
+	case aNumber && bNumber && x != y:
+		if x < y {
+			return -1
+		}
+		return 1
+	case aNumber != bNumber:
+		if aNumber {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+// Levels of the Merkle tree below its root, see Digest
+const merkleDepth = 8
+
+// Every key's winning version, gossiped as maps of key to version
+type LWWStore struct {
+	clock   *hlc.Clock
+	resolve Resolver
+	tree    *merkle.Tree
+
+	mu   sync.Mutex
+	data map[string]Version
+}
+
+func NewLWWStore(clock *hlc.Clock, resolve Resolver) *LWWStore {
+	return &LWWStore{clock: clock, resolve: resolve, tree: merkle.New(merkleDepth), data: make(map[string]Version)}
+}
+
+// Returns key's winning version, false if it was never written
+func (s *LWWStore) Get(key string) (Version, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Returns the delta writing value to key now
+func (s *LWWStore) Write(key string, value json.RawMessage) map[string]Version {
+	return map[string]Version{key: {Value: compact(value), Timestamp: s.clock.Now()}}
+}
+
+// Keeps the winner of each key, and moves the clock past every timestamp seen
+func (s *LWWStore) Merge(delta map[string]Version) {
+	for _, v := range delta {
+		s.clock.Update(v.Timestamp)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, v := range delta {
+		if current, ok := s.data[key]; !ok || s.resolve(current, v) {
+			s.data[key] = v
+			s.tree.Set(key, versionHash(v))
+		}
+	}
+}
+
+func (s *LWWStore) Join(a, b map[string]Version) map[string]Version {
+	joined := maps.Clone(a)
+	for key, v := range b {
+		if current, ok := joined[key]; !ok || s.resolve(current, v) {
+			joined[key] = v
+		}
+	}
+	return joined
+}
+
+func (s *LWWStore) Full() map[string]Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.data)
+}
+
+// Returns the root of the Merkle tree over every key's version, kept up to date by Merge, so two nodes with
+// different keys compare only the parts of the tree that differ (see internal/gossip)
+func (s *LWWStore) Digest() uint64 {
+	return s.tree.Root()
+}
+
+func (s *LWWStore) Tree() *merkle.Tree {
+	return s.tree
+}
+
+// Returns the versions of the keys in the given buckets of the tree
+func (s *LWWStore) Buckets(buckets []int) map[string]Version {
+	wanted := make(map[int]bool)
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delta := make(map[string]Version)
+	for key, v := range s.data {
+		if wanted[s.tree.Bucket(key)] {
+			delta[key] = v
+		}
+	}
+	return delta
+}
+
+func versionHash(v Version) uint64 {
+	h := fnv.New64a()
+	h.Write(v.Value)
+	binary.Write(h, binary.LittleEndian, []int64{v.Timestamp.Wall, v.Timestamp.Logical})
+	h.Write([]byte(v.Timestamp.Node))
+	return h.Sum64()
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,64 @@
+package lwwkv
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+)
+
+func version(value string, wall int64, node string) Version {
+	return Version{Value: json.RawMessage(value), Timestamp: hlc.Timestamp{Wall: wall, Node: node}}
+}
+
+func TestResolversMergeInAnyOrder(t *testing.T) {
+	tests := []struct {
+		resolver string
+		versions []Version
+		want     string
+	}{
+		{LastWriterWins, []Version{version(`1`, 10, "n0"), version(`2`, 20, "n1"), version(`3`, 15, "n2")}, `2`},
+		{LastWriterWins, []Version{version(`1`, 10, "n0"), version(`2`, 10, "n1")}, `2`}, // same time, higher node
+		{FirstWriterWins, []Version{version(`1`, 10, "n0"), version(`2`, 20, "n1"), version(`3`, 5, "n2")}, `3`},
+		{FirstWriterWins, []Version{version(`1`, 10, "n1"), version(`2`, 10, "n0")}, `2`},
+		{MaxValueWins, []Version{version(`9`, 10, "n0"), version(`10`, 5, "n1"), version(`2`, 20, "n2")}, `10`},
+		{MaxValueWins, []Version{version(`"a"`, 10, "n0"), version(`100`, 20, "n1")}, `"a"`}, // numbers sort first
+		{MaxValueWins, []Version{version(`5`, 10, "n0"), version(`5`, 20, "n1")}, `5`},
+	}
+
+	for _, test := range tests {
+		resolve, err := NewResolver(test.resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every node must keep the same version whichever order the writes reach it in
+		for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 0, 2}} {
+			store := NewLWWStore(hlc.New(), resolve)
+			for _, i := range order {
+				if i < len(test.versions) {
+					store.Merge(map[string]Version{"k": test.versions[i]})
+				}
+			}
+
+			if v, _ := store.Get("k"); string(v.Value) != test.want {
+				t.Errorf("%s merging %v in order %v kept %s, want %s", test.resolver, test.versions, order, v.Value, test.want)
+			}
+		}
+	}
+}
+
+func TestMaxValueWinsBreaksTiesByTimestamp(t *testing.T) {
+	resolve, _ := NewResolver(MaxValueWins)
+	earlier, later := version(`5`, 10, "n0"), version(`5`, 20, "n1")
+
+	if !resolve(earlier, later) || resolve(later, earlier) {
+		t.Error("equal values aren't settled by the later timestamp")
+	}
+}
+
+func TestUnknownResolver(t *testing.T) {
+	if _, err := NewResolver("random"); err == nil {
+		t.Error("an unknown resolver was accepted")
+	}
+}
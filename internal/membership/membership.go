@@ -0,0 +1,290 @@
+package membership
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Membership
+
+Which nodes are up, as far as this one can tell, found with the SWIM protocol (see swim.go) rather than
+taken from init's node list. Every member is alive, suspect or dead, with an incarnation number only the
+member itself raises. View returns the members that aren't dead, this node included, and OnChange
+subscribes to changes to it, so an overlay can route around a dead node and an owner can be found for
+whatever it held.
+
+Changes spread by riding on the protocol's own messages: each ping and ack carries up to maxPiggyback
+of the most recent updates, and each update is carried retransmitMult * log2(cluster size) times before
+it's dropped. An update overrides what a node knows of a member when it's newer:
+  - alive overrides anything with a lower incarnation, dead included, so a member that comes back rejoins
+  - suspect overrides alive with the same or a lower incarnation, and suspect with a lower one
+  - dead overrides alive and suspect with the same or a lower incarnation
+A node that hears it's suspected or dead refutes it by raising its incarnation and spreading that it's
+alive.
+
+SWIM_INTERVAL_MS (default 500) is the time between probes, SWIM_PING_TIMEOUT_MS (default 100) how long a
+ping has to be answered, SWIM_INDIRECT_CHECKS (default 3) how many nodes are asked to ping a member that
+didn't answer, and SWIM_SUSPECT_MS (default 2000) how long a member stays suspect before it's declared
+dead. The node's metrics count pings, indirect pings, suspicions and deaths as membership.pings,
+.ping_reqs, .suspicions and .deaths.
+*/
+
+var logger = logging.For("membership")
+
+const (
+	maxPiggyback   = 8
+	retransmitMult = 3
+)
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// SWIM_INTERVAL_MS, how often a member is probed (default 500)
+	Interval time.Duration `env:"SWIM_INTERVAL_MS" min:"1"`
+
+	// SWIM_PING_TIMEOUT_MS, how long a probed member has to answer (default 100)
+	PingTimeout time.Duration `env:"SWIM_PING_TIMEOUT_MS" min:"1"`
+
+	// SWIM_INDIRECT_CHECKS, how many members are asked to probe one that didn't answer (default 3)
+	IndirectChecks int `env:"SWIM_INDIRECT_CHECKS" min:"0"`
+
+	// SWIM_SUSPECT_MS, how long a member is suspected before it's declared dead (default 2000)
+	SuspectTimeout time.Duration `env:"SWIM_SUSPECT_MS" min:"1"`
+}
+
+type State string
+
+const (
+	Alive   State = "alive"
+	Suspect State = "suspect"
+	Dead    State = "dead"
+)
+
+// A member as this node knows it, also the update spreading that knowledge
+type Member struct {
+	ID          string `json:"id"`
+	State       State  `json:"state"`
+	Incarnation int64  `json:"incarnation"`
+}
+
+// Returns whether u is newer than what's known of the member, cur
+func (u Member) overrides(cur Member) bool {
+	switch u.State {
+	case Alive:
+		return u.Incarnation > cur.Incarnation
+	case Suspect:
+		return cur.State == Alive && u.Incarnation >= cur.Incarnation ||
+			cur.State == Suspect && u.Incarnation > cur.Incarnation
+	case Dead:
+		return cur.State != Dead && u.Incarnation >= cur.Incarnation
+	}
+	return false
+}
+
+// An update waiting to be piggybacked, with how many more messages should carry it
+type update struct {
+	Member
+	left int
+}
+
+type Membership struct {
+	node *maelstrom.Node
+	env  *env.Env
+	cfg  Config
+
+	mu        sync.Mutex
+	members   map[string]Member // this node included, empty until the node is initialized
+	suspected map[string]time.Time
+	updates   []update
+	probes    []string // members left to probe this pass, in a random order
+	listeners []func(view []string)
+
+	// Held while listeners are called, so they see views in order
+	notifyMu sync.Mutex
+
+	pings, pingReqs, suspicions, deaths *metrics.Counter
+}
+
+// Creates n's membership and registers its handlers, Run starts probing
+// Must be called before the node starts running
+func New(n *maelstrom.Node) (*Membership, error) {
+	cfg := Config{
+		Interval:       500 * time.Millisecond,
+		PingTimeout:    100 * time.Millisecond,
+		IndirectChecks: 3,
+		SuspectTimeout: 2 * time.Second,
+	}
+	if err := config.Load("membership", &cfg); err != nil {
+		return nil, err
+	}
+
+	registry := metrics.Of(n)
+	m := &Membership{
+		node:       n,
+		env:        env.Of(n),
+		cfg:        cfg,
+		members:    make(map[string]Member),
+		suspected:  make(map[string]time.Time),
+		pings:      registry.Counter("membership.pings"),
+		pingReqs:   registry.Counter("membership.ping_reqs"),
+		suspicions: registry.Counter("membership.suspicions"),
+		deaths:     registry.Counter("membership.deaths"),
+	}
+
+	handler.Handle(n, "swim_ping", m.handlePing)
+	handler.Handle(n, "swim_ping_req", m.handlePingReq)
+
+	admin.Of(n).Register("membership", admin.Module{Status: func() any {
+		return map[string]any{"members": m.Members(), "view": m.View()}
+	}})
+	return m, nil
+}
+
+// Fills in the members from init's node list the first time it's known, must be called with m.mu held
+func (m *Membership) ensure() bool {
+	if len(m.members) > 0 {
+		return true
+	}
+	for _, id := range m.node.NodeIDs() {
+		m.members[id] = Member{ID: id, State: Alive}
+	}
+	return len(m.members) > 0
+}
+
+// Returns every member, dead ones included, sorted by ID
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	members := make([]Member, 0, len(m.members))
+	for _, id := range slices.Sorted(maps.Keys(m.members)) {
+		members = append(members, m.members[id])
+	}
+	return members
+}
+
+// Returns the members that aren't dead, sorted
+func (m *Membership) View() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.view()
+}
+
+// Must be called with m.mu held
+func (m *Membership) view() []string {
+	m.ensure()
+
+	view := []string{}
+	for id, member := range m.members {
+		if member.State != Dead {
+			view = append(view, id)
+		}
+	}
+	slices.Sort(view)
+	return view
+}
+
+// Calls listener with the new view whenever a member joins or dies
+func (m *Membership) OnChange(listener func(view []string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+func (m *Membership) notify() {
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+
+	m.mu.Lock()
+	view := m.view()
+	listeners := slices.Clone(m.listeners)
+	m.mu.Unlock()
+
+	logger.Info("view changed", "view", view)
+	for _, listener := range listeners {
+		listener(view)
+	}
+}
+
+// Applies updates heard from another node, and tells the listeners if the view changed
+func (m *Membership) apply(updates []Member) {
+	m.mu.Lock()
+	changed := false
+	if m.ensure() {
+		for _, u := range updates {
+			changed = m.applyLocked(u) || changed
+		}
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.notify()
+	}
+}
+
+// Applies u if it's newer than what's known and queues it to spread, returns whether the view changed
+// Must be called with m.mu held
+func (m *Membership) applyLocked(u Member) bool {
+	self := m.node.ID()
+	if u.ID == self {
+		// Refute anything but alive about this node with a higher incarnation
+		if cur := m.members[self]; u.State != Alive && u.Incarnation >= cur.Incarnation {
+			refuted := Member{ID: self, State: Alive, Incarnation: u.Incarnation + 1}
+			m.members[self] = refuted
+			m.queue(refuted)
+			logger.Info("refuted", "state", u.State, "incarnation", refuted.Incarnation)
+		}
+		return false
+	}
+
+	cur, known := m.members[u.ID]
+	if known && !u.overrides(cur) {
+		return false
+	}
+	m.members[u.ID] = u
+	m.queue(u)
+
+	switch u.State {
+	case Suspect:
+		m.suspected[u.ID] = m.env.Clock.Now()
+	case Dead:
+		delete(m.suspected, u.ID)
+		logger.Warn("member died", "member", u.ID, "incarnation", u.Incarnation)
+	default:
+		delete(m.suspected, u.ID)
+	}
+	return !known || (cur.State == Dead) != (u.State == Dead)
+}
+
+// Queues u to be piggybacked, replacing any older update about the same member, must be called with m.mu held
+func (m *Membership) queue(u Member) {
+	m.updates = slices.DeleteFunc(m.updates, func(queued update) bool { return queued.ID == u.ID })
+	transmissions := retransmitMult * int(math.Ceil(math.Log2(float64(len(m.members)+1))))
+	m.updates = append(m.updates, update{Member: u, left: transmissions})
+}
+
+// Returns the updates the next message should carry, the most recent first
+func (m *Membership) piggyback() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var members []Member
+	for i := len(m.updates) - 1; i >= 0 && len(members) < maxPiggyback; i-- {
+		members = append(members, m.updates[i].Member)
+		m.updates[i].left--
+	}
+	m.updates = slices.DeleteFunc(m.updates, func(u update) bool { return u.left <= 0 })
+	return members
+}
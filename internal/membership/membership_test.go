@@ -0,0 +1,104 @@
+package membership
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newMembership(t *testing.T, clock env.Clock) *Membership {
+	t.Helper()
+
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init("n1", []string{"n1", "n2", "n3"})
+	env.Set(n, &env.Env{Clock: clock, Rand: env.NewRand(1)})
+
+	m, err := New(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		update, cur Member
+		want        bool
+	}{
+		{Member{State: Alive, Incarnation: 1}, Member{State: Alive, Incarnation: 0}, true},
+		{Member{State: Alive, Incarnation: 0}, Member{State: Suspect, Incarnation: 0}, false},
+		{Member{State: Alive, Incarnation: 2}, Member{State: Dead, Incarnation: 1}, true},
+		{Member{State: Suspect, Incarnation: 0}, Member{State: Alive, Incarnation: 0}, true},
+		{Member{State: Suspect, Incarnation: 0}, Member{State: Alive, Incarnation: 1}, false},
+		{Member{State: Suspect, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, false},
+		{Member{State: Suspect, Incarnation: 1}, Member{State: Dead, Incarnation: 0}, false},
+		{Member{State: Dead, Incarnation: 0}, Member{State: Suspect, Incarnation: 0}, true},
+		{Member{State: Dead, Incarnation: 0}, Member{State: Alive, Incarnation: 1}, false},
+	}
+	for _, test := range tests {
+		if got := test.update.overrides(test.cur); got != test.want {
+			t.Errorf("%+v overrides %+v: %v, want %v", test.update, test.cur, got, test.want)
+		}
+	}
+}
+
+func TestRefute(t *testing.T) {
+	m := newMembership(t, env.Real{})
+
+	m.apply([]Member{{ID: "n1", State: Suspect, Incarnation: 0}})
+
+	updates := m.piggyback()
+	want := Member{ID: "n1", State: Alive, Incarnation: 1}
+	if !slices.Contains(updates, want) {
+		t.Fatalf("piggybacked %v, want the refutation %+v", updates, want)
+	}
+	if members := m.Members(); members[0] != want {
+		t.Fatalf("n1 is %+v, want %+v", members[0], want)
+	}
+}
+
+func TestSuspectDies(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	m := newMembership(t, clock)
+
+	var views [][]string
+	m.OnChange(func(view []string) { views = append(views, view) })
+
+	m.apply([]Member{{ID: "n2", State: Suspect, Incarnation: 0}})
+	m.expire()
+	if len(views) != 0 {
+		t.Fatalf("views %v before the suspicion ran out", views)
+	}
+
+	clock.Advance(m.cfg.SuspectTimeout)
+	m.expire()
+
+	// n2 comes back with a higher incarnation and rejoins
+	m.apply([]Member{{ID: "n2", State: Alive, Incarnation: 1}})
+
+	want := [][]string{{"n1", "n3"}, {"n1", "n2", "n3"}}
+	if len(views) != len(want) || !slices.Equal(views[0], want[0]) || !slices.Equal(views[1], want[1]) {
+		t.Fatalf("views %v, want %v", views, want)
+	}
+}
+
+func TestPiggybackRetransmits(t *testing.T) {
+	m := newMembership(t, env.Real{})
+	m.apply([]Member{{ID: "n2", State: Suspect, Incarnation: 0}})
+
+	// Three members, so each update rides on 3 * log2(4) messages
+	for i := range 6 {
+		if updates := m.piggyback(); len(updates) != 1 {
+			t.Fatalf("message %d carried %v, want the suspicion", i, updates)
+		}
+	}
+	if updates := m.piggyback(); len(updates) != 0 {
+		t.Fatalf("carried %v after the suspicion was spread", updates)
+	}
+}
@@ -0,0 +1,229 @@
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+SWIM
+
+Failure detection with a constant load per node, however big the cluster. Every Interval a node probes
+the next member, going through them all in a random order before shuffling again:
+ 1. it sends the member swim_ping, which it has PingTimeout to answer
+ 2. if it doesn't, IndirectChecks other members are sent swim_ping_req, asking them to ping it in turn,
+    so a member cut off from this node alone isn't taken for dead
+ 3. if none of them gets an answer either, the member becomes suspect, and is declared dead once it's
+    been suspect for SuspectTimeout, unless it refutes the suspicion before then
+Pings, acks and indirect pings all carry the latest membership updates (see membership.go).
+*/
+
+type PingRequestBody struct {
+	Type    string   `json:"type"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type PingResponseBody struct {
+	Type    string   `json:"type"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type PingReqRequestBody struct {
+	Type    string   `json:"type"`
+	Target  string   `json:"target"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type PingReqResponseBody struct {
+	Type    string   `json:"type"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+func (m *Membership) handlePing(msg maelstrom.Message, body PingRequestBody) (PingResponseBody, error) {
+	m.apply(body.Updates)
+	return PingResponseBody{Updates: m.piggyback()}, nil
+}
+
+func (m *Membership) handlePingReq(msg maelstrom.Message, body PingReqRequestBody) (PingReqResponseBody, error) {
+	m.apply(body.Updates)
+
+	if err := m.ping(context.Background(), body.Target); err != nil {
+		return PingReqResponseBody{}, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("%s didn't answer: %v", body.Target, err))
+	}
+	return PingReqResponseBody{Updates: m.piggyback()}, nil
+}
+
+// Probes a member every interval and declares suspects dead once their time is up, until ctx is cancelled
+func (m *Membership) Run(ctx context.Context) {
+	ticker := m.env.Clock.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				m.probe(ctx)
+				m.expire()
+			}
+		}
+	}
+}
+
+// Probes the next member, directly then through others, and suspects it if nobody reaches it
+func (m *Membership) probe(ctx context.Context) {
+	target, ok := m.next()
+	if !ok {
+		return
+	}
+	if m.ping(ctx, target) == nil {
+		return
+	}
+
+	helpers := m.helpers(target)
+	reached := make(chan struct{}, len(helpers))
+	var wg sync.WaitGroup
+	for _, helper := range helpers {
+		wg.Go(func() {
+			if m.pingReq(ctx, helper, target) == nil {
+				reached <- struct{}{}
+			}
+		})
+	}
+	wg.Wait()
+	if len(reached) > 0 || ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	cur := m.members[target]
+	changed := false
+	if cur.State == Alive {
+		m.suspicions.Inc()
+		logger.Info("member suspected", "member", target, "incarnation", cur.Incarnation)
+		changed = m.applyLocked(Member{ID: target, State: Suspect, Incarnation: cur.Incarnation})
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.notify()
+	}
+}
+
+// Returns the next member to probe, starting a new pass in a fresh random order when one ends
+func (m *Membership) next() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.ensure() {
+		return "", false
+	}
+
+	for {
+		if len(m.probes) == 0 {
+			for id, member := range m.members {
+				if id != m.node.ID() && member.State != Dead {
+					m.probes = append(m.probes, id)
+				}
+			}
+			if len(m.probes) == 0 {
+				return "", false
+			}
+			slices.Sort(m.probes)
+			m.env.Rand.Shuffle(len(m.probes), func(i, j int) {
+				m.probes[i], m.probes[j] = m.probes[j], m.probes[i]
+			})
+		}
+
+		target := m.probes[0]
+		m.probes = m.probes[1:]
+		// Members that died since the pass started are skipped
+		if m.members[target].State != Dead {
+			return target, true
+		}
+	}
+}
+
+// Returns up to IndirectChecks random members other than this node and target that aren't dead
+func (m *Membership) helpers(target string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var candidates []string
+	for id, member := range m.members {
+		if id != m.node.ID() && id != target && member.State != Dead {
+			candidates = append(candidates, id)
+		}
+	}
+	slices.Sort(candidates)
+	m.env.Rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return candidates[:min(len(candidates), m.cfg.IndirectChecks)]
+}
+
+// Declares dead the members suspected for longer than SuspectTimeout
+func (m *Membership) expire() {
+	m.mu.Lock()
+	changed := false
+	now := m.env.Clock.Now()
+	for id, since := range m.suspected {
+		if now.Sub(since) < m.cfg.SuspectTimeout {
+			continue
+		}
+		m.deaths.Inc()
+		changed = m.applyLocked(Member{ID: id, State: Dead, Incarnation: m.members[id].Incarnation}) || changed
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.notify()
+	}
+}
+
+// Pings target and applies the updates its ack carries
+func (m *Membership) ping(ctx context.Context, target string) error {
+	m.pings.Inc()
+
+	ctx, cancel := m.env.Clock.WithTimeout(ctx, m.cfg.PingTimeout)
+	defer cancel()
+
+	msg, err := m.node.SyncRPC(ctx, target, PingRequestBody{Type: "swim_ping", Updates: m.piggyback()})
+	if err != nil {
+		return err
+	}
+
+	var body PingResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	m.apply(body.Updates)
+	return nil
+}
+
+// Asks helper to ping target, and applies the updates its answer carries
+func (m *Membership) pingReq(ctx context.Context, helper, target string) error {
+	m.pingReqs.Inc()
+
+	// The helper's own ping has PingTimeout, its answer gets as long again to come back
+	ctx, cancel := m.env.Clock.WithTimeout(ctx, 2*m.cfg.PingTimeout)
+	defer cancel()
+
+	msg, err := m.node.SyncRPC(ctx, helper, PingReqRequestBody{Type: "swim_ping_req", Target: target, Updates: m.piggyback()})
+	if err != nil {
+		return err
+	}
+
+	var body PingReqResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	m.apply(body.Updates)
+	return nil
+}
@@ -0,0 +1,204 @@
+package merkle
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Merkle trees
+
+A summary of a keyspace two nodes can compare to find where they differ without sending it. The space of
+key hashes is cut into 2^depth buckets, each a contiguous range of it, and the buckets are the leaves of a
+binary tree: each inner node covers the range of its two children and hashes their hashes together, the
+root covers every key. Nodes holding the same keys with the same versions have the same tree.
+
+The owner of the keys calls Set with a hash of a key's current version whenever it changes, and Delete
+when the key goes away. A bucket's hash combines those of its keys by XOR, so an update only rehashes the
+key's bucket and the depth nodes above it, rather than the whole keyspace.
+
+Diff finds the buckets where the tree differs from another node's: it fetches the other root, and while
+hashes differ fetches the children of the nodes that differ, one level at a time, so a handful of
+differing keys costs depth+1 small exchanges. Handle answers those fetches with a typed RPC and Remote
+makes them; what happens to the differing buckets is up to the caller, typically each side sending the
+other its keys in them.
+*/
+
+type Tree struct {
+	depth int
+
+	mu     sync.Mutex
+	keys   map[string]uint64 // each key's hash as given to Set
+	levels [][]uint64        // the root first, the buckets last
+}
+
+// Returns an empty tree with 2^depth buckets
+func New(depth int) *Tree {
+	t := &Tree{depth: depth, keys: make(map[string]uint64), levels: make([][]uint64, depth+1)}
+	for level := range t.levels {
+		t.levels[level] = make([]uint64, 1<<level)
+	}
+	return t
+}
+
+// Returns the level of the buckets, 0 being the root's
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// Returns the bucket key falls in, by the top bits of its hash
+func (t *Tree) Bucket(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() >> (64 - t.depth))
+}
+
+// Records hash as key's current version
+func (t *Tree) Set(key string, hash uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.Bucket(key)
+	if old, ok := t.keys[key]; ok {
+		t.levels[t.depth][bucket] ^= contribution(key, old)
+	}
+	t.keys[key] = hash
+	t.levels[t.depth][bucket] ^= contribution(key, hash)
+	t.rehash(bucket)
+}
+
+// Removes key from the tree
+func (t *Tree) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, ok := t.keys[key]
+	if !ok {
+		return
+	}
+	bucket := t.Bucket(key)
+	delete(t.keys, key)
+	t.levels[t.depth][bucket] ^= contribution(key, old)
+	t.rehash(bucket)
+}
+
+// Mixes the key into its hash, so two keys swapping versions still change their bucket
+func contribution(key string, hash uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	binary.Write(h, binary.LittleEndian, hash)
+	return h.Sum64()
+}
+
+// Recomputes the nodes above bucket, must be called with t.mu held
+func (t *Tree) rehash(bucket int) {
+	index := bucket
+	for level := t.depth - 1; level >= 0; level-- {
+		index /= 2
+		left, right := t.levels[level+1][2*index], t.levels[level+1][2*index+1]
+
+		// An empty range hashes to 0 however it got empty, like one that never held a key
+		if left == 0 && right == 0 {
+			t.levels[level][index] = 0
+			continue
+		}
+		h := fnv.New64a()
+		binary.Write(h, binary.LittleEndian, []uint64{left, right})
+		t.levels[level][index] = h.Sum64()
+	}
+}
+
+func (t *Tree) Root() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.levels[0][0]
+}
+
+// Returns the hashes of the given nodes of a level, 0 for any that don't exist
+func (t *Tree) Hashes(level int, indices []int) []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hashes := make([]uint64, len(indices))
+	for i, index := range indices {
+		if level >= 0 && level <= t.depth && index >= 0 && index < len(t.levels[level]) {
+			hashes[i] = t.levels[level][index]
+		}
+	}
+	return hashes
+}
+
+// Fetches the hashes of the given nodes of a level of another node's tree
+type Fetch func(level int, indices []int) ([]uint64, error)
+
+// Returns the buckets where this tree and the one behind remote differ, in ascending order
+func (t *Tree) Diff(remote Fetch) ([]int, error) {
+	differing := []int{0}
+	for level := 0; len(differing) > 0; level++ {
+		theirs, err := remote(level, differing)
+		if err != nil {
+			return nil, err
+		}
+		ours := t.Hashes(level, differing)
+
+		next := []int{}
+		for i, index := range differing {
+			if i < len(theirs) && theirs[i] == ours[i] {
+				continue
+			}
+			if level == t.depth {
+				next = append(next, index)
+			} else {
+				next = append(next, 2*index, 2*index+1)
+			}
+		}
+
+		if level == t.depth {
+			slices.Sort(next)
+			return next, nil
+		}
+		differing = next
+	}
+	return nil, nil
+}
+
+type DigestRequestBody struct {
+	Type    string `json:"type"`
+	Level   int    `json:"level"` // 0 is the root, the tree's depth the buckets
+	Indices []int  `json:"indices"`
+}
+
+type DigestResponseBody struct {
+	Type   string   `json:"type"`
+	Hashes []uint64 `json:"hashes"`
+}
+
+// Answers typ requests on n with hashes from tree
+func Handle(n *maelstrom.Node, typ string, tree *Tree) {
+	handler.Handle(n, typ, func(msg maelstrom.Message, body DigestRequestBody) (DigestResponseBody, error) {
+		return DigestResponseBody{Hashes: tree.Hashes(body.Level, body.Indices)}, nil
+	})
+}
+
+// Returns a Fetch of peer's tree, asking for its hashes with typ requests within ctx
+func Remote(ctx context.Context, n *maelstrom.Node, peer, typ string) Fetch {
+	return func(level int, indices []int) ([]uint64, error) {
+		msg, err := n.SyncRPC(ctx, peer, DigestRequestBody{Type: typ, Level: level, Indices: indices})
+		if err != nil {
+			return nil, err
+		}
+
+		var body DigestResponseBody
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return nil, err
+		}
+		return body.Hashes, nil
+	}
+}
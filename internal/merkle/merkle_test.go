@@ -0,0 +1,71 @@
+package merkle
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// Fetches from another tree in the same process
+func local(t *Tree) Fetch {
+	return func(level int, indices []int) ([]uint64, error) {
+		return t.Hashes(level, indices), nil
+	}
+}
+
+func TestIncrementalUpdates(t *testing.T) {
+	a, b := New(6), New(6)
+	for i := range 100 {
+		a.Set(strconv.Itoa(i), uint64(i))
+	}
+	// b gets the same keys in another order, with a detour through other versions and a deleted key
+	for i := 99; i >= 0; i-- {
+		b.Set(strconv.Itoa(i), uint64(1000+i))
+		b.Set(strconv.Itoa(i), uint64(i))
+	}
+	b.Set("extra", 1)
+	b.Delete("extra")
+
+	if a.Root() != b.Root() {
+		t.Fatal("roots differ for the same keys")
+	}
+	if New(6).Root() == a.Root() {
+		t.Fatal("root of 100 keys is the empty tree's")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := New(8), New(8)
+	for i := range 500 {
+		a.Set(strconv.Itoa(i), uint64(i))
+		b.Set(strconv.Itoa(i), uint64(i))
+	}
+
+	if buckets, err := a.Diff(local(b)); err != nil || len(buckets) != 0 {
+		t.Fatalf("equal trees differ in %v, %v", buckets, err)
+	}
+
+	// b missed a write, and has a key a doesn't
+	a.Set("7", 70)
+	b.Set("new", 1)
+
+	want := []int{a.Bucket("7"), a.Bucket("new")}
+	slices.Sort(want)
+	want = slices.Compact(want)
+
+	fetches := 0
+	remote := func(level int, indices []int) ([]uint64, error) {
+		fetches++
+		return b.Hashes(level, indices), nil
+	}
+	buckets, err := a.Diff(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(buckets, want) {
+		t.Fatalf("differing buckets %v, want %v", buckets, want)
+	}
+	if fetches != a.Depth()+1 {
+		t.Fatalf("diffed in %d fetches, want one per level", fetches)
+	}
+}
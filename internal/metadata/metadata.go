@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Cluster metadata
+
+Small values every node should agree on, such as a config version, who owns which keys or a member list,
+kept under string keys and spread to every node by the gossip engine (see internal/gossip), so a feature
+that needs one doesn't have to send it to each node itself and retry the ones that missed it. Any node can
+Set a key, and Watch calls back on every node once the new value reaches it. The workload starts the gossip
+with Run from its init handler, once the cluster's nodes are known.
+
+Each value carries a version vector (see internal/vclock): a Set counts a new event of the setting node on
+top of the version it replaces, so a value set after another has seen it always replaces it. When two
+values were set concurrently on different nodes, neither version descends the other, and the one that
+stays is picked the same way on every node: the one with more events in its version, then the larger JSON.
+That's a total order, so values merge in any order, any number of times, with the same result. Concurrent
+sets are counted in the node's metrics as metadata.conflicts.
+
+Nothing is saved, a node that restarts gets the current values back from the others through gossip.
+*/
+
+var logger = logging.For("metadata")
+
+// A value and the version of the Set that wrote it
+type Entry struct {
+	Value   json.RawMessage `json:"value"`
+	Version vclock.VClock   `json:"version"`
+}
+
+// Orders entries so that one whose version descends another's is greater
+func compare(a, b Entry) int {
+	return cmp.Or(
+		cmp.Compare(events(a.Version), events(b.Version)),
+		bytes.Compare(a.Value, b.Value),
+		bytes.Compare(marshal(a.Version), marshal(b.Version)),
+	)
+}
+
+func events(v vclock.VClock) int64 {
+	var sum int64
+	for _, count := range v {
+		sum += count
+	}
+	return sum
+}
+
+func marshal(v vclock.VClock) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+type Metadata struct {
+	node   *maelstrom.Node
+	env    *env.Env
+	engine *gossip.Engine[map[string]Entry]
+
+	mu       sync.Mutex
+	entries  map[string]Entry
+	watchers map[string][]func(value json.RawMessage)
+
+	// Held while watchers are called, so they see values in order
+	notifyMu sync.Mutex
+
+	// Held by Set, so two sets of a key on this node get different versions
+	setMu sync.Mutex
+
+	conflicts *metrics.Counter
+}
+
+var (
+	mu    sync.Mutex
+	nodes = map[*maelstrom.Node]*Metadata{}
+)
+
+// Returns node's metadata, registering its handlers the first time
+// Must first be called before the node starts running, its gossip is then started with Run
+func Of(node *maelstrom.Node) *Metadata {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if m, ok := nodes[node]; ok {
+		return m
+	}
+
+	m := &Metadata{
+		node:      node,
+		env:       env.Of(node),
+		entries:   map[string]Entry{},
+		watchers:  map[string][]func(json.RawMessage){},
+		conflicts: metrics.Of(node).Counter("metadata.conflicts"),
+	}
+	m.engine = gossip.New(node, gossip.Store[map[string]Entry](m), gossip.Config{
+		Name:        "metadata",
+		Interval:    100 * time.Millisecond,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+	admin.Of(node).Register("metadata", admin.Module{Status: func() any { return m.Full() }})
+
+	nodes[node] = m
+	return m
+}
+
+// Gossips with every other node until ctx is cancelled, must be called once the node is initialized since
+// the peers are the cluster's node IDs
+func (m *Metadata) Run(ctx context.Context) {
+	m.engine.SetPeers(slices.DeleteFunc(slices.Clone(m.node.NodeIDs()), func(id string) bool {
+		return id == m.node.ID()
+	}))
+	m.engine.Run(ctx)
+}
+
+// Sets key to value's JSON on every node
+func (m *Metadata) Set(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	m.setMu.Lock()
+	defer m.setMu.Unlock()
+
+	m.mu.Lock()
+	version := m.entries[key].Version.Clone()
+	version.Increment(m.node.ID())
+	m.mu.Unlock()
+
+	m.engine.Update(map[string]Entry{key: {Value: data, Version: version}})
+	return nil
+}
+
+// Returns key's value and version, false if it's never been set
+func (m *Metadata) Get(key string) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+// Calls watcher with key's value, now if it's set and then whenever it changes
+// Watchers are called one at a time and must not call Set
+func (m *Metadata) Watch(key string, watcher func(value json.RawMessage)) {
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+
+	m.mu.Lock()
+	m.watchers[key] = append(m.watchers[key], watcher)
+	entry, ok := m.entries[key]
+	m.mu.Unlock()
+
+	if ok {
+		watcher(entry.Value)
+	}
+}
+
+// Keeps the greater entry of every key, and tells the watchers of the keys whose value changed
+func (m *Metadata) Merge(delta map[string]Entry) {
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+
+	type change struct {
+		value    json.RawMessage
+		watchers []func(json.RawMessage)
+	}
+	var changes []change
+
+	m.mu.Lock()
+	for _, key := range slices.Sorted(maps.Keys(delta)) {
+		entry := delta[key]
+		current, ok := m.entries[key]
+		if ok && vclock.Compare(current.Version, entry.Version) == vclock.Concurrent {
+			m.conflicts.Inc()
+			logger.Warn("concurrent metadata sets", "key", key, "value", string(current.Value), "other", string(entry.Value))
+		}
+		if ok && compare(entry, current) <= 0 {
+			continue
+		}
+
+		m.entries[key] = entry
+		if !ok || !bytes.Equal(entry.Value, current.Value) {
+			changes = append(changes, change{value: entry.Value, watchers: slices.Clone(m.watchers[key])})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		for _, watcher := range change.watchers {
+			watcher(change.value)
+		}
+	}
+}
+
+// Keeps the greater entry of every key in a and b
+func (m *Metadata) Join(a, b map[string]Entry) map[string]Entry {
+	joined := maps.Clone(a)
+	for key, entry := range b {
+		if current, ok := joined[key]; !ok || compare(entry, current) > 0 {
+			joined[key] = entry
+		}
+	}
+	return joined
+}
+
+func (m *Metadata) Full() map[string]Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.entries)
+}
+
+// Hashes the JSON form of the entries, the same on every node holding the same ones since map keys are sorted
+func (m *Metadata) Digest() uint64 {
+	data, err := json.Marshal(m.Full())
+	if err != nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newMetadata(id string) *Metadata {
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init(id, []string{"n0", "n1", "n2"})
+	env.Set(n, &env.Env{Clock: env.Real{}, Rand: env.NewRand(1)})
+	return Of(n)
+}
+
+func value(t *testing.T, m *Metadata, key string) string {
+	t.Helper()
+	entry, ok := m.Get(key)
+	if !ok {
+		t.Fatalf("%s is not set", key)
+	}
+	return string(entry.Value)
+}
+
+func TestSetReplacesWhatItHasSeen(t *testing.T) {
+	a, b := newMetadata("n0"), newMetadata("n1")
+
+	var seen []string
+	b.Watch("members", func(value json.RawMessage) { seen = append(seen, string(value)) })
+
+	if err := a.Set("members", []string{"n0", "n1", "n2"}); err != nil {
+		t.Fatal(err)
+	}
+	b.Merge(a.Full())
+
+	// n1 has seen n0's value, so its own set replaces it everywhere, even with a smaller value
+	if err := b.Set("members", []string{"n0"}); err != nil {
+		t.Fatal(err)
+	}
+	a.Merge(b.Full())
+	b.Merge(a.Full())
+
+	for _, m := range []*Metadata{a, b} {
+		if got := value(t, m, "members"); got != `["n0"]` {
+			t.Errorf("members = %s, want [\"n0\"]", got)
+		}
+	}
+	if len(seen) != 2 || seen[0] != `["n0","n1","n2"]` || seen[1] != `["n0"]` {
+		t.Errorf("watcher saw %v, want each value once", seen)
+	}
+
+	// A new watcher is called with the current value straight away
+	var current string
+	a.Watch("members", func(value json.RawMessage) { current = string(value) })
+	if current != `["n0"]` {
+		t.Errorf("new watcher got %q", current)
+	}
+}
+
+func TestConcurrentSetsConverge(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		replicas := []*Metadata{newMetadata("n0"), newMetadata("n1"), newMetadata("n2")}
+
+		var deltas []map[string]Entry
+		for i := 0; i < 10; i++ {
+			r := replicas[rng.Intn(len(replicas))]
+			if err := r.Set("config", rng.Intn(5)); err != nil {
+				t.Fatal(err)
+			}
+			entry, _ := r.Get("config")
+			deltas = append(deltas, map[string]Entry{"config": entry})
+
+			// Sometimes deliver it to another replica, so later sets descend it
+			if rng.Intn(2) == 0 {
+				replicas[rng.Intn(len(replicas))].Merge(deltas[len(deltas)-1])
+			}
+		}
+
+		// Every replica ends up with the same entry, whatever order the rest arrive in
+		var want Entry
+		for i, r := range replicas {
+			for _, j := range rng.Perm(len(deltas)) {
+				r.Merge(deltas[j])
+			}
+			got, _ := r.Get("config")
+			if i == 0 {
+				want = got
+			} else if string(got.Value) != string(want.Value) || vclock.Compare(got.Version, want.Version) != vclock.Equal {
+				t.Fatalf("replica %d has %s %v, replica 0 has %s %v", i, got.Value, got.Version, want.Value, want.Version)
+			}
+		}
+
+		joined := replicas[0].Join(deltas[0], deltas[len(deltas)-1])
+		for _, delta := range deltas[1:] {
+			joined = replicas[0].Join(delta, joined)
+		}
+		if string(joined["config"].Value) != string(want.Value) {
+			t.Fatalf("joined deltas have %s, replicas have %s", joined["config"].Value, want.Value)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Metrics
+
+Every workload registers its counters, gauges and histograms into its node's Registry, under dotted names
+starting with the package that owns them (raft.term, gossip.broadcast.rounds, ...), so every service
+reports the same kinds of numbers the same way. Typed handlers (see internal/handler) already count
+requests and errors and time them per message type, as handler.<type>.requests, .errors and .latency_ms.
+
+Register adds the standard stats RPC, which replies with a snapshot of the registry, along with the fields
+any Report function returns, for workloads with figures that aren't plain numbers. With METRICS_DUMP_MS
+set the snapshot is also logged to stderr that often.
+*/
+
+var logger = logging.For("metrics")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// METRICS_DUMP_MS, how often the metrics are logged to stderr (default 0, never)
+	DumpInterval time.Duration `env:"METRICS_DUMP_MS" min:"0"`
+}
+
+type Counter struct {
+	v atomic.Int64
+}
+
+func (c *Counter) Add(delta int64) {
+	c.v.Add(delta)
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Bucket upper bounds of every histogram, doubling from 1µs to about 9 minutes in milliseconds
+var bounds = func() []float64 {
+	var b []float64
+	for v := 0.001; v < 1e6; v *= 2 {
+		b = append(b, v)
+	}
+	return b
+}()
+
+type Histogram struct {
+	mu       sync.Mutex
+	counts   []int64 // per bucket, the last for anything above the largest bound
+	count    int64
+	sum      float64
+	min, max float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.counts == nil {
+		h.counts = make([]int64, len(bounds)+1)
+	}
+	h.counts[bucket(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// Observes a duration in milliseconds
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(float64(time.Since(start)) / float64(time.Millisecond))
+}
+
+// Returns the bucket v falls in
+func bucket(v float64) int {
+	i, _ := slices.BinarySearch(bounds, v)
+	return i
+}
+
+type HistogramSnapshot struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+
+	// Upper bounds of the buckets the quantiles fall in, capped at Max
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := HistogramSnapshot{Count: h.count, Sum: h.sum, Min: h.min, Max: h.max}
+	if h.count == 0 {
+		return s
+	}
+	s.Mean = h.sum / float64(h.count)
+	s.P50, s.P90, s.P99 = h.quantile(0.5), h.quantile(0.9), h.quantile(0.99)
+	return s
+}
+
+func (h *Histogram) quantile(q float64) float64 {
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && i < len(bounds) {
+			return min(bounds[i], h.max)
+		}
+	}
+	return h.max
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	funcs      map[string]func() float64
+	histograms map[string]*Histogram
+	reports    []func() any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		funcs:      make(map[string]func() float64),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+var (
+	mu         sync.Mutex
+	registries = map[*maelstrom.Node]*Registry{}
+)
+
+// Returns node's registry
+func Of(node *maelstrom.Node) *Registry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r, ok := registries[node]
+	if !ok {
+		r = NewRegistry()
+		registries[node] = r
+	}
+	return r
+}
+
+// Returns the counter with the given name, creating it if it's new
+func (r *Registry) Counter(name string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Returns the gauge with the given name, creating it if it's new
+func (r *Registry) Gauge(name string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.gauges[name]
+	if !ok {
+		g = &Gauge{}
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Reports the gauge with the given name as whatever value returns at the time, for figures a workload
+// already keeps
+func (r *Registry) GaugeFunc(name string, value func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs[name] = value
+}
+
+// Returns the histogram with the given name, creating it if it's new
+func (r *Registry) Histogram(name string) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.histograms[name]
+	if !ok {
+		h = &Histogram{}
+		r.histograms[name] = h
+	}
+	return h
+}
+
+// Adds the fields of what report returns, a struct or map, to every stats reply
+func (r *Registry) Report(report func() any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+type Snapshot struct {
+	Counters   map[string]int64             `json:"counters"`
+	Gauges     map[string]float64           `json:"gauges"`
+	Histograms map[string]HistogramSnapshot `json:"histograms"`
+}
+
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	counters, gauges, funcs, histograms := maps.Clone(r.counters), maps.Clone(r.gauges), maps.Clone(r.funcs), maps.Clone(r.histograms)
+	r.mu.Unlock()
+
+	s := Snapshot{
+		Counters:   make(map[string]int64),
+		Gauges:     make(map[string]float64),
+		Histograms: make(map[string]HistogramSnapshot),
+	}
+	for name, c := range counters {
+		s.Counters[name] = c.Value()
+	}
+	for name, g := range gauges {
+		s.Gauges[name] = g.Value()
+	}
+	for name, value := range funcs {
+		s.Gauges[name] = value()
+	}
+	for name, h := range histograms {
+		s.Histograms[name] = h.Snapshot()
+	}
+	return s
+}
+
+// Returns the stats reply: the workload's reported fields, then the snapshot's
+func (r *Registry) Stats() (map[string]any, error) {
+	r.mu.Lock()
+	reports := slices.Clone(r.reports)
+	r.mu.Unlock()
+
+	resp := map[string]any{}
+	for _, report := range reports {
+		data, err := json.Marshal(report())
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+	}
+
+	s := r.Snapshot()
+	resp["type"] = "stats_ok"
+	resp["counters"], resp["gauges"], resp["histograms"] = s.Counters, s.Gauges, s.Histograms
+	return resp, nil
+}
+
+// Registers the stats handler on n and, if METRICS_DUMP_MS is set, starts dumping the metrics to stderr
+func Register(n *maelstrom.Node) error {
+	r := Of(n)
+
+	n.Handle("stats", func(msg maelstrom.Message) error {
+		resp, err := r.Stats()
+		if err != nil {
+			return err
+		}
+		return n.Reply(msg, resp)
+	})
+
+	var cfg Config
+	if err := config.Load("metrics", &cfg); err != nil {
+		return err
+	}
+	if cfg.DumpInterval > 0 {
+		clock := env.Of(n).Clock
+		lifecycle.Of(n).Go(func(ctx context.Context) { r.dump(ctx, clock, cfg.DumpInterval) })
+	}
+
+	return nil
+}
+
+// Logs a snapshot every interval until ctx is cancelled
+func (r *Registry) dump(ctx context.Context, clock env.Clock, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s := r.Snapshot()
+			logger.Info("metrics", "counters", s.Counters, "gauges", s.Gauges, "histograms", s.Histograms)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import "testing"
+
+func TestHistogramQuantiles(t *testing.T) {
+	h := &Histogram{}
+	for v := 1; v <= 100; v++ {
+		h.Observe(float64(v))
+	}
+
+	s := h.Snapshot()
+	if s.Count != 100 || s.Min != 1 || s.Max != 100 || s.Mean != 50.5 {
+		t.Fatalf("snapshot %+v", s)
+	}
+
+	// Quantiles are bucket bounds, so within a factor of two above the true value
+	for _, q := range []struct{ got, want float64 }{{s.P50, 50}, {s.P90, 90}, {s.P99, 99}} {
+		if q.got < q.want || q.got > 2*q.want {
+			t.Errorf("quantile %v, want about %v", q.got, q.want)
+		}
+	}
+}
+
+func TestStatsMergesReports(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("a.requests").Add(3)
+	r.Gauge("a.size").Set(1.5)
+	r.GaugeFunc("a.term", func() float64 { return 7 })
+	r.Report(func() any { return map[string]any{"type": "custom_ok", "scheme": "uuid4"} })
+
+	resp, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp["type"] != "stats_ok" || resp["scheme"] != "uuid4" {
+		t.Errorf("reply %v", resp)
+	}
+	if counters := resp["counters"].(map[string]int64); counters["a.requests"] != 3 {
+		t.Errorf("counters %v", counters)
+	}
+	if gauges := resp["gauges"].(map[string]float64); gauges["a.size"] != 1.5 || gauges["a.term"] != 7 {
+		t.Errorf("gauges %v", gauges)
+	}
+}
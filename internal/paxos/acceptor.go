@@ -0,0 +1,159 @@
+package paxos
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+)
+
+/*
+Acceptors
+
+Every node is an acceptor. It promises not to accept anything below the highest ballot it has been
+prepared with, and remembers the value and ballot it last accepted in each slot. One promise covers every
+slot, which is what lets a multi-Paxos leader run phase 1 once for the whole log rather than once per
+command.
+
+Promises and accepted values are appended to the node's storage log (see internal/storage) before the
+acceptor answers, and replayed when it restarts: an acceptor that forgot a promise could accept a value a
+lower ballot proposes after a higher one has been chosen, and one that forgot an accepted value could let
+a new proposer choose something else in a slot that was already decided. The log only grows, it's never
+compacted.
+*/
+
+// Storage log the acceptor's promises and accepted values are kept in
+const acceptorLog = "paxos.acceptor"
+
+// Numbers a proposal: higher rounds win, and the proposer's node ID breaks ties so no two are equal
+type Ballot struct {
+	Round int64  `json:"round"`
+	Node  string `json:"node"`
+}
+
+func (b Ballot) Compare(other Ballot) int {
+	return cmp.Or(cmp.Compare(b.Round, other.Round), cmp.Compare(b.Node, other.Node))
+}
+
+func (b Ballot) String() string {
+	return fmt.Sprintf("%d.%s", b.Round, b.Node)
+}
+
+// A value accepted in a slot and the ballot it was accepted in
+type Accepted struct {
+	Ballot Ballot          `json:"ballot"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// A record in the acceptor's log, either a promise or a value accepted in Slot
+type record struct {
+	Promised *Ballot   `json:"promised,omitempty"`
+	Slot     int64     `json:"slot,omitempty"`
+	Accepted *Accepted `json:"accepted,omitempty"`
+}
+
+type Acceptor struct {
+	mu       sync.Mutex
+	log      storage.Log // nil until Open, the acceptor refuses everything until then
+	promised Ballot
+	accepted map[int64]Accepted
+}
+
+func NewAcceptor() *Acceptor {
+	return &Acceptor{accepted: make(map[int64]Accepted)}
+}
+
+// Replays the acceptor's log from store and starts answering
+func (a *Acceptor) Open(store storage.Store) error {
+	log, records, err := store.OpenLog(acceptorLog)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, data := range records {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("paxos: acceptor log: %w", err)
+		}
+		if r.Promised != nil {
+			a.promised = *r.Promised
+		} else {
+			a.accepted[r.Slot] = *r.Accepted
+			if r.Accepted.Ballot.Compare(a.promised) > 0 {
+				a.promised = r.Accepted.Ballot
+			}
+		}
+	}
+	a.log = log
+	return nil
+}
+
+// Promises ballot if it's higher than any promised so far, returning the values accepted from slot on
+// Otherwise returns false and the ballot already promised
+func (a *Acceptor) Prepare(ballot Ballot, from int64) (bool, Ballot, map[int64]Accepted, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return false, a.promised, nil, errNotOpen
+	}
+	if ballot.Compare(a.promised) <= 0 {
+		return false, a.promised, nil, nil
+	}
+
+	if err := a.append(record{Promised: &ballot}); err != nil {
+		return false, a.promised, nil, err
+	}
+	a.promised = ballot
+
+	accepted := make(map[int64]Accepted)
+	for slot, acc := range a.accepted {
+		if slot >= from {
+			accepted[slot] = acc
+		}
+	}
+	return true, ballot, accepted, nil
+}
+
+// Accepts value in slot unless a higher ballot has been promised, returning whether it did and the promise
+func (a *Acceptor) Accept(ballot Ballot, slot int64, value json.RawMessage) (bool, Ballot, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return false, a.promised, errNotOpen
+	}
+	if ballot.Compare(a.promised) < 0 {
+		return false, a.promised, nil
+	}
+
+	acc := Accepted{Ballot: ballot, Value: value}
+	if err := a.append(record{Slot: slot, Accepted: &acc}); err != nil {
+		return false, a.promised, err
+	}
+	// Accepting a ballot also promises it, a lower one mustn't be accepted in another slot after this
+	a.promised = ballot
+	a.accepted[slot] = acc
+	return true, ballot, nil
+}
+
+// Returns the highest ballot promised
+func (a *Acceptor) Promised() Ballot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.promised
+}
+
+// Must be called with a.mu held
+func (a *Acceptor) append(r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return a.log.Append(data)
+}
@@ -0,0 +1,183 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Paxos
+
+Goal: the same linearizable key-value store as the Raft capstone, serving lin-kv with the same state
+machine (see internal/raft/kv.go), but with its operations chosen by Paxos (see paxos.go) so the two can be
+run against the same workload and compared.
+
+In multi mode only the leader runs operations and other nodes forward them to the leader they know of, as
+with Raft; in single mode every node runs its own.
+
+PAXOS_MODE picks multi (default) or single, election timeouts are drawn from
+[PAXOS_ELECTION_TIMEOUT_MS, 2*PAXOS_ELECTION_TIMEOUT_MS) (default 500), and a leader sends paxos_heartbeat
+at least every PAXOS_HEARTBEAT_MS (default 100). Acceptors keep their state in the node's storage, set
+STORAGE_BACKEND=file for it to survive a restart.
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// PAXOS_MODE, multi (default), a leader running phase 1 once for every slot, or single, every node
+	// running both phases for each of its commands
+	Mode string `env:"PAXOS_MODE" oneof:"multi|single"`
+
+	// PAXOS_ELECTION_TIMEOUT_MS, the minimum election timeout, each is drawn from [timeout, 2*timeout) (default 500)
+	ElectionTimeout time.Duration `env:"PAXOS_ELECTION_TIMEOUT_MS" min:"1"`
+
+	// PAXOS_HEARTBEAT_MS, how often the leader sends heartbeats (default 100)
+	HeartbeatInterval time.Duration `env:"PAXOS_HEARTBEAT_MS" min:"1"`
+
+	RPCTimeout time.Duration
+}
+
+// Paxos status RPC, for watching elections and how far the log is applied
+type PaxosStatusRequestBody struct {
+	Type string `json:"type"`
+}
+
+type PaxosStatusResponseBody struct {
+	Type     string `json:"type"`
+	Mode     string `json:"mode"`
+	Ballot   string `json:"ballot"`
+	Promised string `json:"promised"`
+	Leading  bool   `json:"leading"`
+	Leader   string `json:"leader"`
+	Applied  int64  `json:"applied"`
+	Keys     int    `json:"keys"`
+}
+
+// Returns the Paxos settings, the defaults overridden by the environment
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Mode:              "multi",
+		ElectionTimeout:   500 * time.Millisecond,
+		HeartbeatInterval: 100 * time.Millisecond,
+		RPCTimeout:        500 * time.Millisecond,
+	}
+	err := config.Load("paxos", &cfg)
+	return cfg, err
+}
+
+// Registers the Paxos and KV handlers on n, the acceptor opens and elections start once n is initialized
+func Register(n *maelstrom.Node) error {
+	ctx := context.Background()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	kv := raft.NewKVStore()
+	paxos := New(n, kv, cfg)
+
+	// Storage is per node, so the acceptor can only be opened once the node's ID is known
+	n.Handle("init", func(msg maelstrom.Message) error {
+		store, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+		if err := paxos.Acceptor().Open(store); err != nil {
+			return err
+		}
+
+		lifecycle.Of(n).Go(paxos.Run)
+		return nil
+	})
+
+	status := func() PaxosStatusResponseBody {
+		ballot, leading, leader, applied := paxos.State()
+
+		return PaxosStatusResponseBody{
+			Mode:     cfg.Mode,
+			Ballot:   ballot.String(),
+			Promised: paxos.Acceptor().Promised().String(),
+			Leading:  leading,
+			Leader:   leader,
+			Applied:  applied,
+			Keys:     kv.Len(),
+		}
+	}
+
+	handler.Handle(n, "paxos_status", func(msg maelstrom.Message, body PaxosStatusRequestBody) (PaxosStatusResponseBody, error) {
+		return status(), nil
+	})
+
+	// Pausing stops elections and heartbeats, so pausing a leader lets the others elect a new one
+	admin.Of(n).Register("paxos", admin.Module{Status: func() any { return status() }})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body raft.ReadRequestBody) (raft.ReadResponseBody, error) {
+		value, err := execute(ctx, n, paxos, msg, raft.KVCommand{Op: "read", Key: body.Key})
+		if err != nil {
+			return raft.ReadResponseBody{}, err
+		}
+
+		return raft.ReadResponseBody{
+			Value: value,
+		}, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body raft.WriteRequestBody) (raft.WriteResponseBody, error) {
+		_, err := execute(ctx, n, paxos, msg, raft.KVCommand{Op: "write", Key: body.Key, Value: body.Value})
+		return raft.WriteResponseBody{}, err
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body raft.CASRequestBody) (raft.CASResponseBody, error) {
+		_, err := execute(ctx, n, paxos, msg, raft.KVCommand{Op: "cas", Key: body.Key, Value: body.To, From: body.From, CreateIfNotExists: body.CreateIfNotExists})
+		return raft.CASResponseBody{}, err
+	})
+
+	return nil
+}
+
+// Gets cmd chosen and returns the value it produced
+// On a node that isn't the leader the request is forwarded to the leader instead, once: a forwarded
+// request that finds the leader has changed again fails as temporarily unavailable, and the client retries
+func execute(ctx context.Context, n *maelstrom.Node, paxos *Paxos, msg maelstrom.Message, cmd raft.KVCommand) (json.RawMessage, error) {
+	ctx, cancel := paxos.env.Clock.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	result, err := paxos.Submit(ctx, cmd)
+	if err == nil {
+		kvResult := result.(raft.KVResult)
+		return kvResult.Value, kvResult.Err
+	}
+	if !errors.Is(err, ErrNotLeader) {
+		return nil, err
+	}
+
+	// Raw values, so the leader sees the client's keys and values exactly
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Body, &request); err != nil {
+		return nil, err
+	}
+
+	_, _, leader, _ := paxos.State()
+	if leader == "" || leader == n.ID() || string(request["forwarded"]) == "true" {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("not the leader, try %q", leader))
+	}
+
+	request["forwarded"] = json.RawMessage("true")
+
+	var body raft.ReadResponseBody
+	if err := handler.Call(ctx, n, leader, request, &body); err != nil {
+		return nil, err
+	}
+	return body.Value, nil
+}
@@ -0,0 +1,590 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Paxos log
+
+Commands are chosen one per slot of a log, each slot an instance of Paxos (see proposer.go), and every node
+applies them to its state machine in slot order once it has learned them. Whoever gets a value chosen
+tells the other nodes with paxos_decide.
+
+With PAXOS_MODE=single every node proposes its clients' commands itself, running both phases in the first
+slot it doesn't know a value for and moving on to the next slot if another command was chosen there. A
+node that's missing a slot others have moved past learns it by proposing a no-op there, which gets back
+whatever was already chosen. Nodes proposing at the same time preempt each other, and throughput collapses
+under contention.
+
+With PAXOS_MODE=multi (the default) one node leads. A node that hasn't heard from a leader for its
+election timeout runs phase 1 with a new ballot for every slot from the first it hasn't applied, and leads
+once a majority promise it. It first proposes again every value the promises reported, since any of them
+might have been chosen, and no-ops in the slots between them; after that each command only needs phase 2,
+a single round trip to a majority. The leader sends paxos_heartbeat every PAXOS_HEARTBEAT_MS, which keeps
+the others from running elections, and sends them the slots they report they haven't applied yet. A leader
+learns it has been replaced when an acceptor refuses it, and other nodes forward commands to it.
+
+Unlike Raft, nothing stops a node with an out of date log from leading: it learns what it's missing from
+the promises. Chosen values are kept in memory only, a node that restarts relearns them from the leader,
+or in single mode by proposing no-ops, and never forgets one once learned.
+
+The node's metrics count elections started and won (paxos.elections, paxos.elections_won) and phases that
+lost to a higher ballot (paxos.preempted), and report the last slot applied as paxos.applied_index.
+*/
+
+var logger = logging.For("paxos")
+
+const (
+	paxosTickInterval = 10 * time.Millisecond
+	decideBatchSize   = 64
+)
+
+var ErrNotLeader = errors.New("paxos: not the leader")
+
+// Applies chosen commands, in slot order, on every node
+type StateMachine interface {
+	Apply(command json.RawMessage) any
+}
+
+// The value chosen in a slot
+type Entry struct {
+	ID      string          `json:"id,omitempty"` // unique to the Submit call that proposed it, empty for a no-op
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// A slot's value, as sent to nodes that haven't learned it
+type Decision struct {
+	Slot  int64 `json:"slot"`
+	Entry Entry `json:"entry"`
+}
+
+// Decide RPC, telling a node the values chosen in some slots
+type DecideRequestBody struct {
+	Type      string     `json:"type"`
+	Decisions []Decision `json:"decisions"`
+}
+
+type DecideResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Heartbeat RPC, from the multi-Paxos leader
+type HeartbeatRequestBody struct {
+	Type   string `json:"type"`
+	Ballot Ballot `json:"ballot"`
+}
+
+type HeartbeatResponseBody struct {
+	Type     string `json:"type"`
+	OK       bool   `json:"ok"`
+	Promised Ballot `json:"promised"`
+	Applied  int64  `json:"applied"` // the last slot the node has applied, the leader sends it the ones after
+}
+
+// Result of applying an entry, handed to the Submit call waiting on it
+type applied struct {
+	chosen bool // false if another entry was chosen in the slot it was proposed in
+	result any
+}
+
+type Paxos struct {
+	node     *maelstrom.Node
+	env      *env.Env
+	sm       StateMachine
+	cfg      Config
+	acceptor *Acceptor
+
+	mu      sync.Mutex
+	ballot  Ballot // the highest ballot this node has proposed with
+	seq     int64  // numbers the entries this node proposes
+	chosen  map[int64]Entry
+	applied int64 // the last slot applied, slots start at 1
+
+	waiters map[string]chan applied // Submit calls waiting for their entry to be applied, by entry ID
+	pending map[int64]string        // the entry this node proposed in each slot it leads that's not applied yet
+
+	// Multi-Paxos
+	leading   bool   // whether phase 1 of ballot succeeded and no higher ballot has been seen since
+	electing  bool   // whether phase 1 is running
+	leader    string // the leader this node knows of, empty if none
+	next      int64  // the slot the leader proposes the next command in
+	deadline  time.Time
+	heartbeat time.Time
+
+	// Single-decree
+	stalled time.Time // when applying started waiting on a slot that later slots were chosen past, zero if it's not
+	filling bool      // whether a no-op is being proposed in that slot
+
+	elections, electionsWon, preempted *metrics.Counter
+}
+
+func New(node *maelstrom.Node, sm StateMachine, cfg Config) *Paxos {
+	p := &Paxos{
+		node:     node,
+		env:      env.Of(node),
+		sm:       sm,
+		cfg:      cfg,
+		acceptor: NewAcceptor(),
+		chosen:   make(map[int64]Entry),
+		waiters:  make(map[string]chan applied),
+		pending:  make(map[int64]string),
+	}
+	p.resetElectionTimer()
+
+	registry := metrics.Of(node)
+	p.elections = registry.Counter("paxos.elections")
+	p.electionsWon = registry.Counter("paxos.elections_won")
+	p.preempted = registry.Counter("paxos.preempted")
+	registry.GaugeFunc("paxos.applied_index", func() float64 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return float64(p.applied)
+	})
+
+	handler.Handle(node, "paxos_prepare", func(msg maelstrom.Message, body PrepareRequestBody) (PrepareResponseBody, error) {
+		return p.handlePrepare(body)
+	})
+
+	handler.Handle(node, "paxos_accept", func(msg maelstrom.Message, body AcceptRequestBody) (AcceptResponseBody, error) {
+		return p.handleAccept(body)
+	})
+
+	handler.Handle(node, "paxos_decide", func(msg maelstrom.Message, body DecideRequestBody) (DecideResponseBody, error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, d := range body.Decisions {
+			p.learn(d.Slot, d.Entry)
+		}
+		return DecideResponseBody{}, nil
+	})
+
+	handler.Handle(node, "paxos_heartbeat", func(msg maelstrom.Message, body HeartbeatRequestBody) (HeartbeatResponseBody, error) {
+		return p.handleHeartbeat(body), nil
+	})
+
+	return p
+}
+
+// Returns the acceptor, which has to be opened before the node can take part
+func (p *Paxos) Acceptor() *Acceptor {
+	return p.acceptor
+}
+
+// Runs elections, heartbeats and catching up until ctx is cancelled, must be called once the node is initialized
+func (p *Paxos) Run(ctx context.Context) {
+	ticker := p.env.Clock.NewTicker(paxosTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				p.tick()
+			}
+		}
+	}
+}
+
+func (p *Paxos) tick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.env.Clock.Now()
+	switch {
+	case p.cfg.Mode == "single":
+		p.fillGap(now)
+	case p.leading:
+		if now.After(p.heartbeat) {
+			p.sendHeartbeats()
+		}
+	case !p.electing && now.After(p.deadline):
+		p.electing = true
+		go p.elect()
+	}
+}
+
+// Gets command chosen in a slot and blocks until it's applied, returning the state machine's result
+// In multi mode returns ErrNotLeader if this node isn't the leader, or another command took the slot
+func (p *Paxos) Submit(ctx context.Context, command any) (any, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.cfg.Mode == "multi" && !p.leading {
+		p.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	p.seq++
+	entry := Entry{ID: fmt.Sprintf("%s-%d", p.node.ID(), p.seq), Command: data}
+	done := make(chan applied, 1)
+	p.waiters[entry.ID] = done
+
+	if p.cfg.Mode == "multi" {
+		slot, ballot := p.next, p.ballot
+		p.next++
+		p.pending[slot] = entry.ID
+		go p.commit(ballot, slot, entry)
+	}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.waiters, entry.ID)
+		p.mu.Unlock()
+	}()
+
+	if p.cfg.Mode == "single" {
+		if err := p.proposeEntry(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case result := <-done:
+		if !result.chosen {
+			return nil, ErrNotLeader
+		}
+		return result.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the highest ballot this node has proposed with, whether it leads, the leader it knows of and
+// the last slot applied
+func (p *Paxos) State() (Ballot, bool, string, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ballot, p.leading, p.leader, p.applied
+}
+
+// Single-decree: proposes entry in the first slot this node doesn't know a value for, then the next, until
+// it's chosen in one of them
+func (p *Paxos) proposeEntry(ctx context.Context, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	for {
+		slot := p.frontier()
+		chosen, err := p.propose(ctx, slot, value)
+		if err != nil {
+			return err
+		}
+
+		var decided Entry
+		if err := json.Unmarshal(chosen, &decided); err != nil {
+			return err
+		}
+		p.decide(slot, decided)
+		if decided.ID == entry.ID {
+			return nil
+		}
+	}
+}
+
+// Returns the slot after the last one this node knows a value for
+func (p *Paxos) frontier() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := p.applied
+	for slot := range p.chosen {
+		last = max(last, slot)
+	}
+	return last + 1
+}
+
+// Multi-Paxos: runs phase 1 for every slot from the first not applied, and leads if a majority promise
+func (p *Paxos) elect() {
+	p.elections.Inc()
+	ballot := p.nextBallot()
+
+	p.mu.Lock()
+	from := p.applied + 1
+	p.mu.Unlock()
+	logger.Info("starting election", "ballot", ballot.String(), "from", from)
+
+	ctx, cancel := p.env.Clock.WithTimeout(context.Background(), p.cfg.ElectionTimeout)
+	accepted, err := p.prepare(ctx, ballot, from)
+	cancel()
+
+	var preempted *PreemptedError
+	if errors.As(err, &preempted) {
+		p.preempted.Inc()
+		p.promised(preempted.Promised)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.electing = false
+	p.resetElectionTimer()
+	// A higher ballot may have been promised while the promises came in
+	if err != nil || p.ballot != ballot || p.acceptor.Promised().Compare(ballot) > 0 {
+		logger.Debug("election failed", "ballot", ballot.String(), "error", err)
+		return
+	}
+
+	logger.Info("elected leader", "ballot", ballot.String())
+	p.electionsWon.Inc()
+	p.leading = true
+	p.leader = p.node.ID()
+
+	// Any value a promise reported might have been chosen, so it's proposed again in its slot, and the
+	// slots between them get no-ops so the log can be applied past them
+	last := p.applied
+	for slot := range accepted {
+		last = max(last, slot)
+	}
+	for slot := range p.chosen {
+		last = max(last, slot)
+	}
+	for slot := p.applied + 1; slot <= last; slot++ {
+		if _, ok := p.chosen[slot]; ok {
+			continue
+		}
+
+		var entry Entry
+		if acc, ok := accepted[slot]; ok {
+			if err := json.Unmarshal(acc.Value, &entry); err != nil {
+				logger.Error("accepted value doesn't decode", "slot", slot, "error", err)
+			}
+		}
+		go p.commit(ballot, slot, entry)
+	}
+	p.next = last + 1
+
+	p.sendHeartbeats()
+}
+
+// Multi-Paxos: runs phase 2 for entry in slot until it's chosen or a higher ballot preempts ballot
+func (p *Paxos) commit(ballot Ballot, slot int64, entry Entry) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("entry doesn't encode", "slot", slot, "error", err)
+		return
+	}
+
+	for p.leads(ballot) {
+		ctx, cancel := p.env.Clock.WithTimeout(context.Background(), p.cfg.RPCTimeout)
+		err := p.accept(ctx, ballot, slot, value)
+		cancel()
+
+		if err == nil {
+			p.decide(slot, entry)
+			return
+		}
+
+		var preempted *PreemptedError
+		if errors.As(err, &preempted) {
+			p.preempted.Inc()
+			p.promised(preempted.Promised)
+			return
+		}
+
+		// Not enough acceptors answered, try again once they might
+		logger.Debug("accept failed", "slot", slot, "ballot", ballot.String(), "error", err)
+		<-p.env.Clock.After(p.cfg.HeartbeatInterval)
+	}
+}
+
+// Learns that entry was chosen in slot, and tells every other node
+func (p *Paxos) decide(slot int64, entry Entry) {
+	p.mu.Lock()
+	p.learn(slot, entry)
+	p.mu.Unlock()
+
+	req := DecideRequestBody{Type: "paxos_decide", Decisions: []Decision{{Slot: slot, Entry: entry}}}
+	for _, peer := range p.peers() {
+		handler.Notify(p.node, peer, req)
+	}
+}
+
+// Stops leading, and leaves running an election to the sender for a while, once an acceptor has promised
+// a higher ballot than this node's
+func (p *Paxos) promised(ballot Ballot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ballot.Compare(p.ballot) <= 0 {
+		return
+	}
+	if p.leading {
+		logger.Info("stepping down", "ballot", ballot.String())
+	}
+	p.leading = false
+	p.leader = ballot.Node
+	p.resetElectionTimer()
+}
+
+func (p *Paxos) handleHeartbeat(body HeartbeatRequestBody) HeartbeatResponseBody {
+	promised := p.acceptor.Promised()
+	if body.Ballot.Compare(promised) < 0 {
+		return HeartbeatResponseBody{Promised: promised}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if body.Ballot.Compare(p.ballot) > 0 {
+		p.leading = false
+	}
+	p.leader = body.Ballot.Node
+	p.resetElectionTimer()
+	return HeartbeatResponseBody{OK: true, Promised: promised, Applied: p.applied}
+}
+
+func (p *Paxos) peers() []string {
+	return slices.DeleteFunc(slices.Clone(p.node.NodeIDs()), func(id string) bool {
+		return id == p.node.ID()
+	})
+}
+
+// Returns whether this node still leads with ballot
+func (p *Paxos) leads(ballot Ballot) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leading && p.ballot == ballot
+}
+
+// The following must be called with p.mu held
+
+func (p *Paxos) resetElectionTimer() {
+	timeout := p.cfg.ElectionTimeout + time.Duration(p.env.Rand.Int64N(int64(p.cfg.ElectionTimeout)))
+	p.deadline = p.env.Clock.Now().Add(timeout)
+}
+
+// Records entry as chosen in slot and applies every slot after the last applied that's been chosen
+func (p *Paxos) learn(slot int64, entry Entry) {
+	if _, ok := p.chosen[slot]; ok || slot <= p.applied {
+		return
+	}
+	p.chosen[slot] = entry
+
+	for {
+		entry, ok := p.chosen[p.applied+1]
+		if !ok {
+			return
+		}
+		p.applied++
+
+		var result any
+		if entry.Command != nil {
+			result = p.sm.Apply(entry.Command)
+		}
+
+		if id, ok := p.pending[p.applied]; ok {
+			delete(p.pending, p.applied)
+			if done, waiting := p.waiters[id]; waiting && id != entry.ID {
+				done <- applied{}
+				delete(p.waiters, id)
+			}
+		}
+		if done, ok := p.waiters[entry.ID]; ok && entry.ID != "" {
+			done <- applied{chosen: true, result: result}
+			delete(p.waiters, entry.ID)
+		}
+	}
+}
+
+// Multi-Paxos: sends every peer a heartbeat, and the slots it hasn't applied if it answers that it's behind
+func (p *Paxos) sendHeartbeats() {
+	p.heartbeat = p.env.Clock.Now().Add(p.cfg.HeartbeatInterval)
+
+	req := HeartbeatRequestBody{Type: "paxos_heartbeat", Ballot: p.ballot}
+	for _, peer := range p.peers() {
+		go func() {
+			ctx, cancel := p.env.Clock.WithTimeout(context.Background(), p.cfg.RPCTimeout)
+			defer cancel()
+
+			var resp HeartbeatResponseBody
+			if err := handler.Call(ctx, p.node, peer, req, &resp); err != nil {
+				return
+			}
+			if !resp.OK {
+				p.promised(resp.Promised)
+				return
+			}
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			var decisions []Decision
+			for slot := resp.Applied + 1; slot <= min(p.applied, resp.Applied+decideBatchSize); slot++ {
+				decisions = append(decisions, Decision{Slot: slot, Entry: p.chosen[slot]})
+			}
+			if len(decisions) > 0 {
+				handler.Notify(p.node, peer, DecideRequestBody{Type: "paxos_decide", Decisions: decisions})
+			}
+		}()
+	}
+}
+
+// Single-decree: proposes a no-op in the slot applying waits on, once it has waited an election timeout,
+// which learns the value chosen there or chooses the no-op
+func (p *Paxos) fillGap(now time.Time) {
+	slot := p.applied + 1
+	gap := false
+	for s := range p.chosen {
+		gap = gap || s > p.applied
+	}
+
+	if !gap {
+		p.stalled = time.Time{}
+		return
+	}
+	if p.stalled.IsZero() {
+		p.stalled = now
+	}
+	if p.filling || now.Sub(p.stalled) < p.cfg.ElectionTimeout {
+		return
+	}
+
+	p.filling = true
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.filling = false
+			p.stalled = time.Time{}
+			p.mu.Unlock()
+		}()
+
+		ctx, cancel := p.env.Clock.WithTimeout(context.Background(), p.cfg.ElectionTimeout)
+		defer cancel()
+
+		noop, _ := json.Marshal(Entry{})
+		chosen, err := p.propose(ctx, slot, noop)
+		if err != nil {
+			logger.Debug("filling gap failed", "slot", slot, "error", err)
+			return
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(chosen, &entry); err != nil {
+			logger.Error("chosen value doesn't decode", "slot", slot, "error", err)
+			return
+		}
+		p.decide(slot, entry)
+	}()
+}
@@ -0,0 +1,50 @@
+package paxos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+)
+
+func TestAcceptor(t *testing.T) {
+	store := storage.NewMemory()
+	a := NewAcceptor()
+	if ok, _, _, err := a.Prepare(Ballot{Round: 1, Node: "n1"}, 1); ok || err == nil {
+		t.Fatal("an acceptor that isn't open promised")
+	}
+	if err := a.Open(store); err != nil {
+		t.Fatal(err)
+	}
+
+	low, high := Ballot{Round: 1, Node: "n1"}, Ballot{Round: 1, Node: "n2"}
+	if ok, _, _, err := a.Prepare(high, 1); !ok || err != nil {
+		t.Fatalf("refused the first prepare: %v", err)
+	}
+	if ok, promised, _, _ := a.Prepare(low, 1); ok || promised != high {
+		t.Fatalf("promised a lower ballot, or reported %v as promised", promised)
+	}
+	if ok, _, _ := a.Accept(low, 1, json.RawMessage(`"a"`)); ok {
+		t.Fatal("accepted below the promise")
+	}
+	if ok, _, _ := a.Accept(high, 1, json.RawMessage(`"b"`)); !ok {
+		t.Fatal("refused to accept the promised ballot")
+	}
+
+	// A restarted acceptor keeps its promise and reports what it accepted
+	a = NewAcceptor()
+	if err := a.Open(store); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Promised(); got != high {
+		t.Fatalf("promised %v after reopening, want %v", got, high)
+	}
+	higher := Ballot{Round: 2, Node: "n1"}
+	ok, _, accepted, err := a.Prepare(higher, 1)
+	if !ok || err != nil || string(accepted[1].Value) != `"b"` || accepted[1].Ballot != high {
+		t.Fatalf("prepare after reopening returned %v, %v, %v", ok, accepted, err)
+	}
+	if _, _, accepted, _ := a.Prepare(Ballot{Round: 3, Node: "n1"}, 2); len(accepted) != 0 {
+		t.Fatalf("prepare from slot 2 returned %v", accepted)
+	}
+}
@@ -0,0 +1,238 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Proposers
+
+A proposer gets a value chosen in a slot in two phases, each needing a majority of acceptors:
+  1. prepare: it picks a ballot higher than any it has seen and asks the acceptors to promise it. Each
+     promise comes with the values the acceptor has accepted, and if any of them accepted a value in the
+     slot, the proposer must propose the one with the highest ballot rather than its own, since it may
+     already have been chosen
+  2. accept: it asks the acceptors to accept the value with its ballot. Once a majority have, the value is
+     chosen: any later proposer's prepare reaches at least one of them and so proposes it again
+
+An acceptor that has promised a higher ballot refuses either phase, and the proposer starts over with a
+higher ballot. Two proposers can keep preempting each other that way, so each waits a random backoff
+before retrying; that makes it unlikely, not impossible, which is why multi-Paxos picks a leader.
+*/
+
+// Prepare RPC, phase 1
+type PrepareRequestBody struct {
+	Type   string `json:"type"`
+	Ballot Ballot `json:"ballot"`
+	From   int64  `json:"from"` // the first slot the proposer wants accepted values of
+}
+
+type PrepareResponseBody struct {
+	Type     string             `json:"type"`
+	OK       bool               `json:"ok"`
+	Promised Ballot             `json:"promised"`
+	Accepted map[int64]Accepted `json:"accepted,omitempty"`
+}
+
+// Accept RPC, phase 2
+type AcceptRequestBody struct {
+	Type   string          `json:"type"`
+	Ballot Ballot          `json:"ballot"`
+	Slot   int64           `json:"slot"`
+	Value  json.RawMessage `json:"value"`
+}
+
+type AcceptResponseBody struct {
+	Type     string `json:"type"`
+	OK       bool   `json:"ok"`
+	Promised Ballot `json:"promised"`
+}
+
+// Returned when an acceptor has promised a higher ballot than the proposer's
+type PreemptedError struct {
+	Promised Ballot
+}
+
+func (e *PreemptedError) Error() string {
+	return "paxos: preempted by ballot " + e.Promised.String()
+}
+
+var errNotOpen = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "paxos: acceptor isn't open yet")
+
+// Single-decree Paxos: gets a value chosen in slot and returns it, which is value unless another was
+// already accepted there. Starts over with a higher ballot whenever it's preempted, until ctx is done
+func (p *Paxos) propose(ctx context.Context, slot int64, value json.RawMessage) (json.RawMessage, error) {
+	for {
+		ballot := p.nextBallot()
+		accepted, err := p.prepare(ctx, ballot, slot)
+		if err == nil {
+			chosen := value
+			if acc, ok := accepted[slot]; ok {
+				chosen = acc.Value
+			}
+			if err = p.accept(ctx, ballot, slot, chosen); err == nil {
+				return chosen, nil
+			}
+		}
+		p.observe(err)
+		logger.Debug("proposal failed", "slot", slot, "ballot", ballot.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.env.Clock.After(p.backoff()):
+		}
+	}
+}
+
+// Phase 1: has a majority promise ballot, returning the value with the highest ballot any of them
+// accepted in each slot from on
+func (p *Paxos) prepare(ctx context.Context, ballot Ballot, from int64) (map[int64]Accepted, error) {
+	var mu sync.Mutex
+	accepted := make(map[int64]Accepted)
+
+	err := p.majority(ctx, func(ctx context.Context, node string) error {
+		resp, err := p.sendPrepare(ctx, node, PrepareRequestBody{Type: "paxos_prepare", Ballot: ballot, From: from})
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return &PreemptedError{Promised: resp.Promised}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for slot, acc := range resp.Accepted {
+			if current, ok := accepted[slot]; !ok || acc.Ballot.Compare(current.Ballot) > 0 {
+				accepted[slot] = acc
+			}
+		}
+		return nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(accepted), err
+}
+
+// Phase 2: has a majority accept value in slot with ballot, which chooses it
+func (p *Paxos) accept(ctx context.Context, ballot Ballot, slot int64, value json.RawMessage) error {
+	return p.majority(ctx, func(ctx context.Context, node string) error {
+		resp, err := p.sendAccept(ctx, node, AcceptRequestBody{Type: "paxos_accept", Ballot: ballot, Slot: slot, Value: value})
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return &PreemptedError{Promised: resp.Promised}
+		}
+		return nil
+	})
+}
+
+// Calls send for every node, this one included, until a majority succeed
+// Fails with the first PreemptedError any of them returns, or once too many have failed for a majority
+func (p *Paxos) majority(ctx context.Context, send func(ctx context.Context, node string) error) error {
+	nodes := p.node.NodeIDs()
+	need := len(nodes)/2 + 1
+
+	// Buffered so no send blocks once this has returned
+	results := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func() {
+			sendCtx, cancel := p.env.Clock.WithTimeout(ctx, p.cfg.RPCTimeout)
+			defer cancel()
+			results <- send(sendCtx, node)
+		}()
+	}
+
+	succeeded := 0
+	var errs []error
+	for range nodes {
+		err := <-results
+		if err == nil {
+			if succeeded++; succeeded >= need {
+				return nil
+			}
+			continue
+		}
+
+		var preempted *PreemptedError
+		if errors.As(err, &preempted) {
+			return err
+		}
+		if errs = append(errs, err); len(nodes)-len(errs) < need {
+			break
+		}
+	}
+	return fmt.Errorf("paxos: %d of %d acceptors answered: %w", succeeded, need, errors.Join(errs...))
+}
+
+// Returns a ballot higher than any this node has proposed or promised
+func (p *Paxos) nextBallot() Ballot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	round := max(p.ballot.Round, p.acceptor.Promised().Round) + 1
+	p.ballot = Ballot{Round: round, Node: p.node.ID()}
+	return p.ballot
+}
+
+// Counts a phase that lost to a higher ballot
+func (p *Paxos) observe(err error) {
+	var preempted *PreemptedError
+	if errors.As(err, &preempted) {
+		p.preempted.Inc()
+	}
+}
+
+// Returns a random wait in [0, PAXOS_ELECTION_TIMEOUT_MS), so proposers that preempted each other retry
+// at different times
+func (p *Paxos) backoff() time.Duration {
+	return time.Duration(p.env.Rand.Int64N(int64(p.cfg.ElectionTimeout)))
+}
+
+// Prepares node's acceptor, this node's own without a message
+func (p *Paxos) sendPrepare(ctx context.Context, node string, req PrepareRequestBody) (PrepareResponseBody, error) {
+	if node == p.node.ID() {
+		return p.handlePrepare(req)
+	}
+	var resp PrepareResponseBody
+	return resp, handler.Call(ctx, p.node, node, req, &resp)
+}
+
+// Asks node's acceptor to accept, this node's own without a message
+func (p *Paxos) sendAccept(ctx context.Context, node string, req AcceptRequestBody) (AcceptResponseBody, error) {
+	if node == p.node.ID() {
+		return p.handleAccept(req)
+	}
+	var resp AcceptResponseBody
+	return resp, handler.Call(ctx, p.node, node, req, &resp)
+}
+
+func (p *Paxos) handlePrepare(body PrepareRequestBody) (PrepareResponseBody, error) {
+	ok, promised, accepted, err := p.acceptor.Prepare(body.Ballot, body.From)
+	if err != nil {
+		return PrepareResponseBody{}, err
+	}
+	if ok {
+		p.promised(body.Ballot)
+	}
+	return PrepareResponseBody{OK: ok, Promised: promised, Accepted: accepted}, nil
+}
+
+func (p *Paxos) handleAccept(body AcceptRequestBody) (AcceptResponseBody, error) {
+	ok, promised, err := p.acceptor.Accept(body.Ballot, body.Slot, body.Value)
+	if err != nil {
+		return AcceptResponseBody{}, err
+	}
+	return AcceptResponseBody{OK: ok, Promised: promised}, nil
+}
@@ -0,0 +1,122 @@
+package percolator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Percolator
+
+Goal: serve the txn-rw-register workload with snapshot isolation, as the txn workload does, but with
+Percolator's design: the nodes keep no state of their own, every transaction runs against lin-kv, and the
+only coordination is through locks kept next to the data (see row.go and percolator.go).
+
+Any node runs any transaction. A transaction that conflicts with another is answered with txn-conflict
+and the client retries it.
+*/
+
+var logger = logging.For("percolator")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// PERCOLATOR_LOCK_TTL_MS, how long a transaction's locks last before others may roll it back (default 1000)
+	LockTTL time.Duration `env:"PERCOLATOR_LOCK_TTL_MS" min:"1"`
+
+	RetryDelay time.Duration
+}
+
+type TransactionRequestBody struct {
+	Type        string          `json:"type"`
+	Transaction txn.Transaction `json:"txn"`
+}
+
+func (b TransactionRequestBody) Validate() error {
+	for _, op := range b.Transaction {
+		if f := op[0]; f != "r" && f != "w" {
+			return fmt.Errorf("unsupported micro-op %v", f)
+		}
+		if _, ok := txn.KeyOf(op[1]); !ok {
+			return fmt.Errorf("invalid key %v", op[1])
+		}
+	}
+	return nil
+}
+
+type TransactionResponseBody struct {
+	Type        string  `json:"type"`
+	Transaction [][]any `json:"txn"`
+}
+
+// Registers the transaction handler on n
+func Register(n *maelstrom.Node) error {
+	cfg := Config{
+		LockTTL:    time.Second,
+		RetryDelay: 10 * time.Millisecond,
+	}
+	if err := config.Load("percolator", &cfg); err != nil {
+		return err
+	}
+
+	client := NewClient(n, maelstrom.NewLinKV(n), cfg)
+
+	handler.Handle(n, "txn", func(msg maelstrom.Message, body TransactionRequestBody) (TransactionResponseBody, error) {
+		ctx, cancel := client.env.Clock.WithTimeout(context.Background(), 2*cfg.LockTTL)
+		defer cancel()
+
+		result, err := run(ctx, client, body.Transaction)
+		if errors.Is(err, ErrConflict) {
+			return TransactionResponseBody{}, maelstrom.NewRPCError(maelstrom.TxnConflict, err.Error())
+		}
+		return TransactionResponseBody{Transaction: result}, err
+	})
+
+	return nil
+}
+
+// Runs a transaction's micro-ops and commits it, returning the micro-ops with their reads filled in
+func run(ctx context.Context, client *Client, transaction txn.Transaction) ([][]any, error) {
+	t, err := client.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]any, 0, len(transaction))
+	for _, op := range transaction {
+		op = slices.Clone(op)
+		key, _ := txn.KeyOf(op[1])
+
+		if op[0] == "r" {
+			value, found, err := t.Read(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			op[2] = nil
+			if found {
+				op[2] = value.Any()
+			}
+		} else {
+			value, err := txn.ValueOf(op[2])
+			if err != nil {
+				return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+			}
+			t.Write(key, value)
+		}
+
+		result = append(result, op)
+	}
+
+	if err := t.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
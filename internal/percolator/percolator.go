@@ -0,0 +1,275 @@
+package percolator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Transactions
+
+A transaction gets its start ts from the timestamp oracle, a counter in lin-kv under ts, reads every key
+as of it and buffers its writes. To commit it:
+  1. prewrites every key it wrote, the first one first: the key's value goes in data and a lock in lock,
+     both at its start ts, unless the row has a commit after the start ts (a write-write conflict, which
+     is what snapshot isolation rules out) or another transaction's lock. The first key's lock is the
+     primary, every other lock points at it
+  2. gets a commit ts from the oracle
+  3. commits the primary: replaces its lock with a write record at the commit ts. This is the commit point,
+     a transaction whose primary has a write record has committed
+  4. commits the other keys the same way, in the background. A node that crashes before it does leaves
+     their locks behind, which the next transaction to find them cleans up
+
+A transaction that fails before its commit point rolls back the keys it prewrote, the primary first.
+
+Locks are cleaned up lazily, by whichever transaction runs into one and can't get past it: a read at a
+start ts after the lock's, which can't know whether the locked value is committed before it, or a
+prewrite. It looks at the lock's primary. If the primary has a write record the transaction committed,
+and the lock is rolled forward to a write record at the same commit ts. If the primary's lock has expired,
+or is gone without a write record, the transaction is rolled back, its primary first, so that it can't
+commit afterwards. Otherwise the lock's owner may still be running: a read waits for it, a prewrite fails.
+Locks expire PERCOLATOR_LOCK_TTL_MS after they're taken, so a transaction has that long to commit.
+*/
+
+// The lin-kv key of the timestamp oracle
+const oracleKey = "ts"
+
+// Returned by resolve while the lock's transaction may still commit
+var errLockHeld = errors.New("percolator: lock held")
+
+type Client struct {
+	kv  kvutil.KV
+	env *env.Env
+	cfg Config
+
+	commits       *metrics.Counter
+	aborts        *metrics.Counter
+	rolledForward *metrics.Counter
+	rolledBack    *metrics.Counter
+}
+
+func NewClient(n *maelstrom.Node, kv kvutil.KV, cfg Config) *Client {
+	registry := metrics.Of(n)
+	return &Client{
+		kv:            kv,
+		env:           env.Of(n),
+		cfg:           cfg,
+		commits:       registry.Counter("percolator.commits"),
+		aborts:        registry.Counter("percolator.aborts"),
+		rolledForward: registry.Counter("percolator.locks_rolled_forward"),
+		rolledBack:    registry.Counter("percolator.locks_rolled_back"),
+	}
+}
+
+// A running transaction
+type Txn struct {
+	client  *Client
+	startTS int64
+	writes  map[string]txn.Value
+	order   []string // written keys in the order they were first written, the first holds the primary lock
+}
+
+// Starts a transaction, reading as of a fresh timestamp
+func (c *Client) Begin(ctx context.Context) (*Txn, error) {
+	startTS, err := c.timestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{client: c, startTS: startTS, writes: make(map[string]txn.Value)}, nil
+}
+
+// Returns the next timestamp from the oracle
+func (c *Client) timestamp(ctx context.Context) (int64, error) {
+	return kvutil.Update(ctx, c.kv, oracleKey, int64(0), func(ts int64) (int64, error) {
+		return ts + 1, nil
+	})
+}
+
+func rowKey(key string) string {
+	return "row/" + key
+}
+
+// Returns key's value as of the transaction's start ts, its own write if it has written key
+// Waits for locks that may commit before the start ts, and fails with ErrConflict if ctx ends first
+func (t *Txn) Read(ctx context.Context, key string) (txn.Value, bool, error) {
+	if value, ok := t.writes[key]; ok {
+		return value, true, nil
+	}
+
+	for {
+		row, err := kvutil.Read(ctx, t.client.kv, rowKey(key), Row{})
+		if err != nil {
+			return txn.Value{}, false, err
+		}
+
+		value, found, err := row.Read(key, t.startTS)
+		var locked *LockedError
+		if !errors.As(err, &locked) {
+			return value, found, err
+		}
+
+		if err := t.client.resolve(ctx, locked); err != nil && !errors.Is(err, errLockHeld) {
+			return txn.Value{}, false, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return txn.Value{}, false, fmt.Errorf("%w: %w", ErrConflict, locked)
+		case <-t.client.env.Clock.After(t.client.cfg.RetryDelay):
+		}
+	}
+}
+
+// Buffers a write until the transaction commits
+func (t *Txn) Write(key string, value txn.Value) {
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = value
+}
+
+// Commits the transaction's writes, see the steps above
+// Returns ErrConflict if it aborted. Any other error leaves its outcome unknown if it came after the commit
+// point was attempted
+func (t *Txn) Commit(ctx context.Context) error {
+	if len(t.order) == 0 {
+		return nil
+	}
+
+	c := t.client
+	primary := t.order[0]
+	lock := Lock{StartTS: t.startTS, Primary: primary, Expires: c.env.Clock.Now().Add(c.cfg.LockTTL).UnixMilli()}
+
+	for i, key := range t.order {
+		if err := t.prewrite(ctx, key, lock); err != nil {
+			t.rollback(t.order[:i+1])
+			c.aborts.Inc()
+			return err
+		}
+	}
+
+	commitTS, err := c.timestamp(ctx)
+	if err != nil {
+		t.rollback(t.order)
+		c.aborts.Inc()
+		return err
+	}
+
+	_, err = kvutil.Update(ctx, c.kv, rowKey(primary), Row{}, func(row Row) (Row, error) {
+		return row.Commit(t.startTS, commitTS)
+	})
+	if errors.Is(err, ErrConflict) {
+		// Rolled back by another transaction, the locks had expired
+		t.rollback(t.order)
+		c.aborts.Inc()
+		return err
+	} else if err != nil {
+		return err
+	}
+	c.commits.Inc()
+
+	go func() {
+		ctx, cancel := c.env.Clock.WithTimeout(context.Background(), c.cfg.LockTTL)
+		defer cancel()
+
+		for _, key := range t.order[1:] {
+			_, err := kvutil.Update(ctx, c.kv, rowKey(key), Row{}, func(row Row) (Row, error) {
+				return row.Commit(t.startTS, commitTS)
+			})
+			if err != nil && !errors.Is(err, ErrConflict) {
+				logger.Warn("secondary commit failed, leaving it to lock cleanup", "key", key, "start_ts", t.startTS, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Prewrites key under lock, cleaning up the lock in the way if it's abandoned
+func (t *Txn) prewrite(ctx context.Context, key string, lock Lock) error {
+	_, err := kvutil.Update(ctx, t.client.kv, rowKey(key), Row{}, func(row Row) (Row, error) {
+		return row.Prewrite(key, t.writes[key], lock)
+	})
+
+	var locked *LockedError
+	if errors.As(err, &locked) {
+		if err := t.client.resolve(ctx, locked); err != nil && !errors.Is(err, errLockHeld) {
+			logger.Warn("lock cleanup failed", "key", key, "start_ts", locked.Lock.StartTS, "error", err)
+		}
+		return fmt.Errorf("%w: %w", ErrConflict, locked)
+	}
+	return err
+}
+
+// Rolls back the transaction's prewrites of keys, the primary first so it can't commit afterwards
+// Failures are only logged, the locks left behind expire and are cleaned up by other transactions
+func (t *Txn) rollback(keys []string) {
+	ctx, cancel := t.client.env.Clock.WithTimeout(context.Background(), t.client.cfg.LockTTL)
+	defer cancel()
+
+	for _, key := range keys {
+		_, err := kvutil.Update(ctx, t.client.kv, rowKey(key), Row{}, func(row Row) (Row, error) {
+			return row.Rollback(t.startTS)
+		})
+		if err != nil {
+			logger.Warn("rollback failed", "key", key, "start_ts", t.startTS, "error", err)
+			return
+		}
+	}
+}
+
+// Cleans up the lock in locked: rolls it forward if its transaction committed, or rolls the transaction
+// back if its primary lock has expired or is gone
+// Returns errLockHeld if the transaction may still commit
+func (c *Client) resolve(ctx context.Context, locked *LockedError) error {
+	lock := locked.Lock
+
+	for {
+		primary, err := kvutil.Read(ctx, c.kv, rowKey(lock.Primary), Row{})
+		if err != nil {
+			return err
+		}
+
+		if commitTS, ok := primary.CommitTS(lock.StartTS); ok {
+			_, err := kvutil.Update(ctx, c.kv, rowKey(locked.Key), Row{}, func(row Row) (Row, error) {
+				return row.Commit(lock.StartTS, commitTS)
+			})
+			if err == nil {
+				c.rolledForward.Inc()
+			}
+			return err
+		}
+
+		if primary.Lock != nil && primary.Lock.StartTS == lock.StartTS && c.env.Clock.Now().UnixMilli() < primary.Lock.Expires {
+			return errLockHeld
+		}
+
+		_, err = kvutil.Update(ctx, c.kv, rowKey(lock.Primary), Row{}, func(row Row) (Row, error) {
+			return row.Rollback(lock.StartTS)
+		})
+		if errors.Is(err, errCommitted) {
+			// Committed since it was read, roll forward instead
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if locked.Key != lock.Primary {
+			_, err = kvutil.Update(ctx, c.kv, rowKey(locked.Key), Row{}, func(row Row) (Row, error) {
+				return row.Rollback(lock.StartTS)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		c.rolledBack.Inc()
+		return nil
+	}
+}
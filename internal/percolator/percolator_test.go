@@ -0,0 +1,176 @@
+package percolator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newClient(ttl time.Duration) *Client {
+	return NewClient(maelstrom.NewNode(), fake.NewKV(), Config{LockTTL: ttl, RetryDelay: time.Millisecond})
+}
+
+func TestRowVersions(t *testing.T) {
+	var row Row
+	row, err := row.Prewrite("k", txn.Value{Int: 1}, Lock{StartTS: 1, Primary: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader after the prewrite can't tell if it commits before it, one before can ignore it
+	var locked *LockedError
+	if _, _, err := row.Read("k", 2); !errors.As(err, &locked) {
+		t.Fatalf("read past a lock returned %v", err)
+	}
+	if _, found, err := row.Read("k", 0); found || err != nil {
+		t.Fatalf("read before a lock returned %v, %v", found, err)
+	}
+
+	row, err = row.Commit(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := row.Read("k", 2); found {
+		t.Fatal("read before the commit ts saw the value")
+	}
+	if value, found, _ := row.Read("k", 3); !found || value.Int != 1 {
+		t.Fatalf("read at the commit ts got %v, %v", value, found)
+	}
+
+	// A transaction that started before the commit conflicts with it
+	if _, err := row.Prewrite("k", txn.Value{Int: 2}, Lock{StartTS: 2, Primary: "k"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("prewrite under a later commit returned %v", err)
+	}
+}
+
+func TestRowRollback(t *testing.T) {
+	var row Row
+	row, err := row.Rollback(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A prewrite landing after its rollback can't take the lock
+	if _, err := row.Prewrite("k", txn.Value{Int: 1}, Lock{StartTS: 5, Primary: "k"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("prewrite after rollback returned %v", err)
+	}
+	if _, err := row.Commit(5, 6); !errors.Is(err, ErrConflict) {
+		t.Fatalf("commit after rollback returned %v", err)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(time.Second)
+
+	t1, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1.Write("a", txn.Value{Int: 1})
+	t1.Write("b", txn.Value{Int: 2})
+
+	// t2 starts before t1 commits, so it reads neither write, and conflicts with t1 on b
+	t2, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := t1.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := t2.Read(ctx, "a"); found || err != nil {
+		t.Fatalf("t2 read a as %v, %v", found, err)
+	}
+	t2.Write("b", txn.Value{Int: 3})
+	if err := t2.Commit(ctx); !errors.Is(err, ErrConflict) {
+		t.Fatalf("t2 commit returned %v, want a conflict", err)
+	}
+
+	t3, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"a": 1, "b": 2} {
+		value, found, err := t3.Read(ctx, key)
+		if err != nil || !found || value.Int != want {
+			t.Fatalf("t3 read %s as %v, %v, %v", key, value, found, err)
+		}
+	}
+}
+
+// Locks left by transactions that stopped part way are rolled back once they expire, or rolled forward
+// if the primary committed
+func TestLockCleanup(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(20 * time.Millisecond)
+
+	// Prewrites both keys and stops
+	abandoned, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abandoned.Write("a", txn.Value{Int: 1})
+	abandoned.Write("b", txn.Value{Int: 1})
+	lock := Lock{StartTS: abandoned.startTS, Primary: "a", Expires: time.Now().Add(20 * time.Millisecond).UnixMilli()}
+	for _, key := range abandoned.order {
+		if err := abandoned.prewrite(ctx, key, lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Commits its primary and stops
+	halfDone, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	halfDone.Write("c", txn.Value{Int: 2})
+	halfDone.Write("d", txn.Value{Int: 2})
+	lock = Lock{StartTS: halfDone.startTS, Primary: "c", Expires: time.Now().Add(time.Hour).UnixMilli()}
+	for _, key := range halfDone.order {
+		if err := halfDone.prewrite(ctx, key, lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitTS, err := c.timestamp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvutil.Update(ctx, c.kv, rowKey("c"), Row{}, func(row Row) (Row, error) {
+		return row.Commit(halfDone.startTS, commitTS)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := reader.Read(ctx, "b"); found || err != nil {
+		t.Fatalf("read of the abandoned write got %v, %v", found, err)
+	}
+	if value, found, err := reader.Read(ctx, "d"); !found || value.Int != 2 || err != nil {
+		t.Fatalf("read of the committed secondary got %v, %v, %v", value, found, err)
+	}
+
+	for key, rolledBack := range map[string]bool{"a": true, "b": true, "d": false} {
+		row, err := kvutil.Read(ctx, c.kv, rowKey(key), Row{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.Lock != nil {
+			t.Errorf("%s still locked", key)
+		}
+		if _, committed := row.CommitTS(lock.StartTS); key == "d" && !committed {
+			t.Error("d wasn't rolled forward")
+		}
+		if rolledBack && !row.Write[abandoned.startTS].Rollback {
+			t.Errorf("%s wasn't rolled back", key)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package percolator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+)
+
+/*
+Rows
+
+Percolator keeps each key as a Bigtable row with three columns, every cell in them tagged with a timestamp,
+and relies on Bigtable changing a single row atomically. Here a row is one lin-kv key, row/<key>, changed
+with compare-and-swap (see kvutil.Update), which gives the same single-row atomicity:
+  data   start ts → the value a transaction that started then wrote
+  lock   the lock of the transaction that has prewritten the row and not yet committed or rolled back:
+         its start ts, the key of its primary lock and when it expires
+  write  commit ts → the start ts of the transaction that committed then, whose data is the key's value
+         from then on. A transaction rolled back has a rollback record at its start ts instead, so its
+         prewrite can't land after the rollback
+
+Nothing is ever removed from data or write, a row keeps every version of its key.
+*/
+
+var (
+	// A write-write conflict, or a transaction that's been rolled back
+	ErrConflict = errors.New("percolator: conflict")
+
+	// The transaction being rolled back has committed already
+	errCommitted = errors.New("percolator: committed")
+)
+
+// Returned when a row is locked by a transaction that may commit before the reader's start ts
+type LockedError struct {
+	Key  string
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("percolator: %s locked by the transaction started at %d", e.Key, e.Lock.StartTS)
+}
+
+type Row struct {
+	Data  map[int64]txn.Value `json:"data,omitempty"`
+	Lock  *Lock               `json:"lock,omitempty"`
+	Write map[int64]Write     `json:"write,omitempty"`
+}
+
+type Lock struct {
+	StartTS int64  `json:"start_ts"`
+	Primary string `json:"primary"`
+	Expires int64  `json:"expires"` // Unix milliseconds, after which another transaction may roll it back
+}
+
+type Write struct {
+	StartTS  int64 `json:"start_ts"`
+	Rollback bool  `json:"rollback,omitempty"`
+}
+
+// Returns the key's value as of ts, and whether it had one
+// Returns a LockedError if a transaction that started before ts holds the row's lock, as it may commit
+// before ts
+func (r Row) Read(key string, ts int64) (txn.Value, bool, error) {
+	if r.Lock != nil && r.Lock.StartTS <= ts {
+		return txn.Value{}, false, &LockedError{Key: key, Lock: *r.Lock}
+	}
+
+	latest, found := int64(-1), false
+	for commitTS, write := range r.Write {
+		if commitTS <= ts && commitTS > latest && !write.Rollback {
+			latest, found = commitTS, true
+		}
+	}
+	if !found {
+		return txn.Value{}, false, nil
+	}
+	return r.Data[r.Write[latest].StartTS], true, nil
+}
+
+// Returns when the transaction that started at startTS committed, if it did
+func (r Row) CommitTS(startTS int64) (int64, bool) {
+	for commitTS, write := range r.Write {
+		if write.StartTS == startTS && !write.Rollback {
+			return commitTS, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the row with value prewritten under lock
+// Fails with ErrConflict if a transaction committed the row after lock's start ts or the transaction has
+// been rolled back, and with a LockedError if another transaction holds the row's lock
+func (r Row) Prewrite(key string, value txn.Value, lock Lock) (Row, error) {
+	if r.Lock != nil {
+		if r.Lock.StartTS == lock.StartTS {
+			return r, kvutil.ErrNoChange
+		}
+		return r, &LockedError{Key: key, Lock: *r.Lock}
+	}
+	for ts := range r.Write {
+		if ts >= lock.StartTS {
+			return r, ErrConflict
+		}
+	}
+
+	r = r.clone()
+	r.Data[lock.StartTS] = value
+	r.Lock = &lock
+	return r, nil
+}
+
+// Returns the row with the lock of the transaction that started at startTS replaced by its commit record
+// Fails with ErrConflict if the transaction has been rolled back
+func (r Row) Commit(startTS int64, commitTS int64) (Row, error) {
+	if r.Lock == nil || r.Lock.StartTS != startTS {
+		if _, ok := r.CommitTS(startTS); ok {
+			return r, kvutil.ErrNoChange
+		}
+		return r, ErrConflict
+	}
+
+	r = r.clone()
+	r.Lock = nil
+	r.Write[commitTS] = Write{StartTS: startTS}
+	return r, nil
+}
+
+// Returns the row with the transaction that started at startTS rolled back: its lock and data dropped,
+// if it got as far as prewriting, and a rollback record left in their place
+// Fails with errCommitted if the transaction has committed
+func (r Row) Rollback(startTS int64) (Row, error) {
+	if _, ok := r.CommitTS(startTS); ok {
+		return r, errCommitted
+	}
+	if write, ok := r.Write[startTS]; ok && write.Rollback {
+		return r, kvutil.ErrNoChange
+	}
+
+	r = r.clone()
+	if r.Lock != nil && r.Lock.StartTS == startTS {
+		r.Lock = nil
+		delete(r.Data, startTS)
+	}
+	r.Write[startTS] = Write{StartTS: startTS, Rollback: true}
+	return r, nil
+}
+
+// Copies the row, so the copy can change without touching the value read from lin-kv
+func (r Row) clone() Row {
+	r.Data = maps.Clone(r.Data)
+	if r.Data == nil {
+		r.Data = make(map[int64]txn.Value)
+	}
+	r.Write = maps.Clone(r.Write)
+	if r.Write == nil {
+		r.Write = make(map[int64]Write)
+	}
+	return r
+}
@@ -0,0 +1,159 @@
+package primarybackup
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Monitor
+
+The view service: one node, PB_MONITOR, decides who is primary and who are backups, and every other node
+pings it every PB_PING_MS to say it's alive and learn the current view. A node that hasn't pinged for
+PB_DEAD_MS is taken for dead. Views are numbered, and the monitor moves to the next one when:
+  - the primary is dead: the first live backup the primary reported as synced is promoted, the other live
+    nodes become its backups
+  - a backup died, or a node that isn't in the view is alive: the live nodes other than the primary
+    become the backups
+The first node to ping becomes the first primary.
+
+Two rules keep the monitor from making things worse. It only leaves a view once the primary has pinged
+with it, so a primary always knows about a view before the next one replaces it. And it only promotes a
+backup the primary said holds every write, not one still waiting for a state transfer. Both cost
+availability: a primary that dies before acknowledging its view, or with no synced backup, leaves the
+service stuck until it comes back.
+
+The monitor itself is a single point of failure, and its idea of dead is only "hasn't pinged me lately":
+a primary cut off from the monitor but not from clients carries on, see node.go.
+*/
+
+// Who is primary and who are backups, as of a view number
+type View struct {
+	Number  int64    `json:"number"`
+	Primary string   `json:"primary"`
+	Backups []string `json:"backups"`
+}
+
+// Ping RPC, sent to the monitor
+type PingRequestBody struct {
+	Type   string   `json:"type"`
+	View   int64    `json:"view"`             // the latest view the node knows
+	Synced []string `json:"synced,omitempty"` // from the primary, the backups holding every write
+}
+
+type PingResponseBody struct {
+	Type string `json:"type"`
+	View View   `json:"view"`
+}
+
+type Monitor struct {
+	clock env.Clock
+	dead  time.Duration
+	tick  time.Duration
+
+	mu       sync.Mutex
+	view     View
+	acked    bool                 // whether the primary has pinged with the current view
+	synced   []string             // the backups the primary last reported as synced
+	lastPing map[string]time.Time // when each node last pinged
+}
+
+func NewMonitor(clock env.Clock, tick, dead time.Duration) *Monitor {
+	return &Monitor{clock: clock, tick: tick, dead: dead, lastPing: make(map[string]time.Time)}
+}
+
+// Records a ping from a node that knows view number, and returns the current view
+func (m *Monitor) Ping(from string, number int64, synced []string) View {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPing[from] = m.clock.Now()
+	if m.view.Number == 0 {
+		m.view = View{Number: 1, Primary: from}
+		logger.Info("first view", "view", m.view)
+	}
+	if from == m.view.Primary && number == m.view.Number {
+		m.acked = true
+		m.synced = synced
+	}
+	return m.view
+}
+
+func (m *Monitor) handlePing(msg maelstrom.Message, body PingRequestBody) (PingResponseBody, error) {
+	return PingResponseBody{View: m.Ping(msg.Src, body.View, body.Synced)}, nil
+}
+
+// Returns the current view
+func (m *Monitor) View() View {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.view
+}
+
+// Moves to a new view whenever the live nodes call for one, until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := m.clock.NewTicker(m.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				m.check()
+			}
+		}
+	}
+}
+
+func (m *Monitor) check() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	var live []string
+	for node, last := range m.lastPing {
+		if now.Sub(last) < m.dead {
+			live = append(live, node)
+		}
+	}
+	slices.Sort(live)
+
+	if view, ok := nextView(m.view, m.acked, live, m.synced); ok {
+		logger.Info("new view", "view", view, "live", live)
+		m.view, m.acked, m.synced = view, false, nil
+	} else if m.acked && !slices.Contains(live, m.view.Primary) {
+		logger.Warn("primary is dead with no synced backup to promote", "view", m.view, "live", live)
+	}
+}
+
+// Returns the view to move to from view, given the live nodes in sorted order, and whether to move at all
+func nextView(view View, acked bool, live []string, synced []string) (View, bool) {
+	if view.Number == 0 || !acked {
+		return view, false
+	}
+
+	primary := view.Primary
+	if !slices.Contains(live, primary) {
+		i := slices.IndexFunc(view.Backups, func(backup string) bool {
+			return slices.Contains(live, backup) && slices.Contains(synced, backup)
+		})
+		if i < 0 {
+			return view, false
+		}
+		primary = view.Backups[i]
+	}
+
+	backups := slices.DeleteFunc(slices.Clone(live), func(node string) bool { return node == primary })
+	if primary == view.Primary && slices.Equal(backups, view.Backups) {
+		return view, false
+	}
+	return View{Number: view.Number + 1, Primary: primary, Backups: backups}, true
+}
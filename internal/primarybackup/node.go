@@ -0,0 +1,201 @@
+package primarybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Primary/backup replication
+
+Goal: serve the lin-kv workload the classic way, from one primary that orders the writes and copies each
+to its backups before answering (see replica.go), with a monitor that promotes a backup when the primary
+stops pinging it (see monitor.go). Other nodes forward clients' requests to the primary they know of.
+
+It's simpler than Raft, and it's here to show where it goes wrong. Whether a node is primary is only its
+own belief, from the last view it heard of, so when the monitor loses touch with a primary that clients
+can still reach there are two primaries for a while:
+  - reads: the old primary keeps answering them from its own copy, missing every write the new one takes,
+    which Maelstrom's checker reports as stale reads. Raft sends reads through its log to rule this out
+  - writes: with PB_FENCING=view (the default) the old primary's writes fail at the first backup that has
+    moved to the new view. With PB_FENCING=none they succeed, so each primary overwrites the other's
+    backups, and acknowledged writes vanish once one of them wins
+  - a primary with no backups, or cut off together with its backups, takes writes alone and none of them
+    survive the new view
+Fencing only narrows the window: it needs the backups to hear of the new view before the old primary's
+next write. Quorum protocols close it by never letting a decision stand on fewer than a majority.
+
+PB_MONITOR (default the first node ID in sorted order) runs the monitor, and only forwards clients'
+requests, holding no copy itself. The other nodes ping it every PB_PING_MS (default 100) and are taken for
+dead after PB_DEAD_MS (default 500) without a ping.
+*/
+
+var logger = logging.For("primarybackup")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// PB_MONITOR, the node running the monitor (default the first node ID in sorted order)
+	Monitor string `env:"PB_MONITOR"`
+
+	// PB_PING_MS, how often nodes ping the monitor (default 100)
+	Ping time.Duration `env:"PB_PING_MS" min:"1"`
+
+	// PB_DEAD_MS, how long without a ping before the monitor takes a node for dead (default 500)
+	Dead time.Duration `env:"PB_DEAD_MS" min:"1"`
+
+	// PB_FENCING, whether backups refuse writes from outside their view, view (default) or none
+	Fencing string `env:"PB_FENCING" oneof:"view|none"`
+}
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string          `json:"type"`
+	Key  json.RawMessage `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Write RPC
+type WriteRequestBody struct {
+	Type  string          `json:"type"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type WriteResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Compare-and-set RPC
+type CASRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type CASResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Registers the KV, replication and monitor handlers on n, pinging starts once n is initialized
+func Register(n *maelstrom.Node) error {
+	ctx := context.Background()
+
+	cfg := Config{Ping: 100 * time.Millisecond, Dead: 500 * time.Millisecond, Fencing: "view"}
+	if err := config.Load("primarybackup", &cfg); err != nil {
+		return err
+	}
+
+	replica := NewReplica(n, cfg.Fencing == "view", cfg.Dead)
+	handler.Handle(n, "pb_replicate", replica.handleReplicate)
+	handler.Handle(n, "pb_transfer", replica.handleTransfer)
+
+	// Every node can tell which one is the monitor, but only that one runs it
+	monitorID := func() string {
+		if cfg.Monitor != "" {
+			return cfg.Monitor
+		}
+		return slices.Min(n.NodeIDs())
+	}
+	monitor := NewMonitor(replica.clock, cfg.Ping, cfg.Dead)
+	handler.Handle(n, "pb_ping", func(msg maelstrom.Message, body PingRequestBody) (PingResponseBody, error) {
+		if monitorID() != n.ID() {
+			return PingResponseBody{}, maelstrom.NewRPCError(maelstrom.NotSupported, "not the monitor, try "+monitorID())
+		}
+		return monitor.handlePing(msg, body)
+	})
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		if monitorID() != n.ID() {
+			lifecycle.Of(n).Go(func(ctx context.Context) {
+				replica.Run(ctx, monitorID(), cfg.Ping, nil)
+			})
+			return nil
+		}
+
+		lifecycle.Of(n).Go(monitor.Run)
+		lifecycle.Of(n).Go(func(ctx context.Context) {
+			replica.Run(ctx, n.ID(), cfg.Ping, monitor)
+		})
+		return nil
+	})
+
+	admin.Of(n).Register("primarybackup", admin.Module{Status: func() any {
+		seq, keys := replica.Stats()
+		view := replica.View()
+		return map[string]any{
+			"view":    view,
+			"primary": view.Primary == n.ID(),
+			"synced":  replica.Synced(),
+			"seq":     seq,
+			"keys":    keys,
+			"fencing": cfg.Fencing,
+		}
+	}})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		value, err := execute(ctx, n, replica, msg, Op{Op: "read", Key: body.Key})
+		return ReadResponseBody{Value: value}, err
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body WriteRequestBody) (WriteResponseBody, error) {
+		_, err := execute(ctx, n, replica, msg, Op{Op: "write", Key: body.Key, Value: body.Value})
+		return WriteResponseBody{}, err
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body CASRequestBody) (CASResponseBody, error) {
+		_, err := execute(ctx, n, replica, msg, Op{Op: "cas", Key: body.Key, Value: body.To, From: body.From, CreateIfNotExists: body.CreateIfNotExists})
+		return CASResponseBody{}, err
+	})
+
+	return nil
+}
+
+// Runs op on the primary: here if this node thinks it's the primary, otherwise by forwarding the request
+// to the one it knows of, once, like raft's execute
+func execute(ctx context.Context, n *maelstrom.Node, replica *Replica, msg maelstrom.Message, op Op) (json.RawMessage, error) {
+	ctx, cancel := replica.clock.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	value, err := replica.Execute(ctx, op)
+	if err != errNotPrimary {
+		return value, err
+	}
+
+	var request map[string]any
+	if err := json.Unmarshal(msg.Body, &request); err != nil {
+		return nil, err
+	}
+
+	primary := replica.View().Primary
+	if primary == "" || request["forwarded"] == true {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("not the primary, try %q", primary))
+	}
+
+	request["forwarded"] = true
+	resp, err := n.SyncRPC(ctx, primary, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var body ReadResponseBody
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		return nil, err
+	}
+	return body.Value, nil
+}
@@ -0,0 +1,105 @@
+package primarybackup
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestNextView(t *testing.T) {
+	view := View{Number: 3, Primary: "n1", Backups: []string{"n2", "n3"}}
+
+	for _, test := range []struct {
+		name   string
+		acked  bool
+		live   []string
+		synced []string
+		want   *View
+	}{
+		{"unchanged", true, []string{"n1", "n2", "n3"}, []string{"n2", "n3"}, nil},
+		{"not acked", false, []string{"n2", "n3"}, []string{"n2", "n3"}, nil},
+		{"backup died", true, []string{"n1", "n3"}, nil, &View{4, "n1", []string{"n3"}}},
+		{"node joined", true, []string{"n1", "n2", "n3", "n4"}, nil, &View{4, "n1", []string{"n2", "n3", "n4"}}},
+		{"promote first synced", true, []string{"n2", "n3"}, []string{"n3"}, &View{4, "n3", []string{"n2"}}},
+		{"nobody to promote", true, []string{"n2", "n3"}, nil, nil},
+	} {
+		got, ok := nextView(view, test.acked, test.live, test.synced)
+		if test.want == nil && ok {
+			t.Errorf("%s: moved to %+v", test.name, got)
+		}
+		if test.want != nil && (!ok || got.Number != test.want.Number || got.Primary != test.want.Primary || !slices.Equal(got.Backups, test.want.Backups)) {
+			t.Errorf("%s: moved to %+v, %v, want %+v", test.name, got, ok, *test.want)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	m := NewMonitor(clock, 100*time.Millisecond, 500*time.Millisecond)
+
+	// The first node to ping is primary, and the view only moves on once it has acknowledged it
+	if view := m.Ping("n2", 0, nil); view.Number != 1 || view.Primary != "n2" {
+		t.Fatalf("first view %+v", view)
+	}
+	m.Ping("n1", 0, nil)
+	m.check()
+	if m.View().Number != 1 {
+		t.Fatalf("moved on from an unacknowledged view to %+v", m.View())
+	}
+	m.Ping("n2", 1, nil)
+	m.check()
+	if view := m.View(); view.Number != 2 || !slices.Equal(view.Backups, []string{"n1"}) {
+		t.Fatalf("view with a backup %+v", view)
+	}
+
+	// n2 stops pinging after reporting n1 synced, and n1 takes over
+	m.Ping("n2", 2, []string{"n1"})
+	clock.Advance(300 * time.Millisecond)
+	m.Ping("n1", 2, nil)
+	clock.Advance(300 * time.Millisecond)
+	m.Ping("n1", 2, nil)
+	m.check()
+	if view := m.View(); view.Number != 3 || view.Primary != "n1" || len(view.Backups) != 0 {
+		t.Fatalf("view after the primary died %+v", view)
+	}
+}
+
+func TestFencing(t *testing.T) {
+	for _, fencing := range []bool{true, false} {
+		n := maelstrom.NewNode()
+		n.Stdout = io.Discard
+		n.Init("n2", []string{"n1", "n2", "n3"})
+		env.Set(n, &env.Env{Clock: env.Real{}, Rand: env.NewRand(1)})
+
+		backup := NewReplica(n, fencing, time.Second)
+		backup.SetView(View{Number: 2, Primary: "n1", Backups: []string{"n2"}})
+
+		write := func(src string, view, seq int64, value string) error {
+			_, err := backup.handleReplicate(maelstrom.Message{Src: src}, ReplicateRequestBody{View: view, Seq: seq, Key: `"k"`, Value: json.RawMessage(value)})
+			return err
+		}
+
+		if err := write("n1", 2, 1, "1"); err != nil {
+			t.Fatalf("fencing %v: primary's write refused: %v", fencing, err)
+		}
+		// A deposed primary still in view 1
+		err := write("n3", 1, 2, "2")
+		if fencing != (err != nil) {
+			t.Fatalf("fencing %v: old primary's write got %v", fencing, err)
+		}
+
+		want := "1"
+		if !fencing {
+			want = "2"
+		}
+		if got := string(backup.kv[`"k"`]); got != want {
+			t.Fatalf("fencing %v: backup holds %s, want %s", fencing, got, want)
+		}
+	}
+}
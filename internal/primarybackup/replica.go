@@ -0,0 +1,341 @@
+package primarybackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Replicas
+
+Every node holds a copy of the KV and the latest view it got from the monitor. The primary runs each write
+alone, in the order they reach it: it sends the write to every backup, numbered, and only applies it and
+answers the client once they've all applied it. A backup applies writes strictly in order, and refuses a
+write that skips one, after which the primary sends it the whole KV instead. The same transfer brings a
+backup new to the view up to date before its first write.
+
+With PB_FENCING=view (the default) a backup only takes writes and transfers from the primary of the view
+it's in, stamped with that view's number. A deposed primary that hasn't heard yet finds out from the first
+backup that has, and its writes fail instead of forking the data. With PB_FENCING=none backups take
+whatever they're sent, and two primaries happily write over each other's backups.
+*/
+
+// Replicate RPC, from the primary to a backup
+type ReplicateRequestBody struct {
+	Type  string          `json:"type"`
+	View  int64           `json:"view"`
+	Seq   int64           `json:"seq"` // the write's number, one more than the last
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type ReplicateResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Transfer RPC, from the primary to a backup that missed writes
+type TransferRequestBody struct {
+	Type string                     `json:"type"`
+	View int64                      `json:"view"`
+	Seq  int64                      `json:"seq"` // the number of the last write in the KV
+	KV   map[string]json.RawMessage `json:"kv"`
+}
+
+type TransferResponseBody struct {
+	Type string `json:"type"`
+}
+
+// A client's operation, as run by the primary
+type Op struct {
+	Op                string // read, write or cas
+	Key               json.RawMessage
+	Value             json.RawMessage
+	From              json.RawMessage
+	CreateIfNotExists bool
+}
+
+// Returned by Execute on a node that doesn't think it's the primary
+var errNotPrimary = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not the primary")
+
+type Replica struct {
+	node    *maelstrom.Node
+	clock   env.Clock
+	fencing bool
+	timeout time.Duration // for each RPC to a backup
+
+	order sync.Mutex // held by the primary while it runs a write, so they run one at a time
+
+	mu     sync.Mutex
+	view   View
+	kv     map[string]json.RawMessage
+	seq    int64           // the number of the last write applied
+	synced map[string]bool // on the primary, the backups known to hold every write
+
+	transferred int64 // the view of the last transfer taken, on a backup
+
+	replicated *metrics.Counter
+	transfers  *metrics.Counter
+	fenced     *metrics.Counter
+}
+
+func NewReplica(n *maelstrom.Node, fencing bool, timeout time.Duration) *Replica {
+	registry := metrics.Of(n)
+	return &Replica{
+		node:       n,
+		clock:      env.Of(n).Clock,
+		fencing:    fencing,
+		timeout:    timeout,
+		kv:         make(map[string]json.RawMessage),
+		synced:     make(map[string]bool),
+		replicated: registry.Counter("primarybackup.replicated"),
+		transfers:  registry.Counter("primarybackup.transfers"),
+		fenced:     registry.Counter("primarybackup.fenced"),
+	}
+}
+
+// Returns the latest view this node knows
+func (r *Replica) View() View {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.view
+}
+
+// Moves to view if it's newer than the one this node is in
+func (r *Replica) SetView(view View) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if view.Number <= r.view.Number {
+		return
+	}
+	// A new primary knows nothing of its backups, a continuing one still knows which it kept in step
+	if view.Primary != r.view.Primary {
+		r.synced = make(map[string]bool)
+	}
+	for backup := range r.synced {
+		if !slices.Contains(view.Backups, backup) {
+			delete(r.synced, backup)
+		}
+	}
+	r.view = view
+}
+
+// Returns the backups that hold every write, for the primary's pings
+func (r *Replica) Synced() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.view.Primary != r.node.ID() {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(r.synced))
+}
+
+// Pings the monitor every interval and follows the views it returns, until ctx is cancelled
+// On the monitor's own node, which holds no copy, it only follows the monitor's view, to forward requests
+func (r *Replica) Run(ctx context.Context, monitor string, interval time.Duration, local *Monitor) {
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if lifecycle.Paused(ctx) {
+				continue
+			}
+			if local != nil {
+				r.SetView(local.View())
+				continue
+			}
+			r.ping(ctx, monitor, interval)
+		}
+	}
+}
+
+func (r *Replica) ping(ctx context.Context, monitor string, timeout time.Duration) {
+	ctx, cancel := r.clock.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg, err := r.node.SyncRPC(ctx, monitor, PingRequestBody{Type: "pb_ping", View: r.View().Number, Synced: r.Synced()})
+	if err != nil {
+		logger.Debug("monitor didn't answer", "monitor", monitor, "error", err)
+		return
+	}
+
+	var body PingResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		logger.Warn("bad ping reply", "error", err)
+		return
+	}
+	r.SetView(body.View)
+}
+
+// Runs op if this node is the primary, replicating writes to every backup before applying them
+// Reads are answered from the primary's own copy without asking anyone, see node.go
+func (r *Replica) Execute(ctx context.Context, op Op) (json.RawMessage, error) {
+	r.order.Lock()
+	defer r.order.Unlock()
+
+	r.mu.Lock()
+	view, seq := r.view, r.seq
+	key := string(compact(op.Key))
+	current, exists := r.kv[key]
+	r.mu.Unlock()
+
+	if view.Primary != r.node.ID() {
+		return nil, errNotPrimary
+	}
+
+	switch op.Op {
+	case "read":
+		if !exists {
+			return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return current, nil
+	case "cas":
+		if !exists && !op.CreateIfNotExists {
+			return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		if exists && !bytes.Equal(current, compact(op.From)) {
+			return nil, maelstrom.NewRPCError(maelstrom.PreconditionFailed, "expected "+string(compact(op.From))+", found "+string(current))
+		}
+	}
+
+	write := ReplicateRequestBody{Type: "pb_replicate", View: view.Number, Seq: seq + 1, Key: key, Value: compact(op.Value)}
+	var err error
+	for i, backup := range view.Backups {
+		if err = r.replicate(ctx, backup, write); err != nil {
+			// Refused by the first backup, so nobody has it: most likely this node isn't the primary any more
+			if i == 0 && isRefusal(err) {
+				return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("backup %s refused the write: %v", backup, err))
+			}
+			err = maelstrom.NewRPCError(maelstrom.Crash, fmt.Sprintf("backup %s didn't apply the write: %v", backup, err))
+			break
+		}
+	}
+
+	// Once any backup may have the write the primary applies it too, so the next write's number is never
+	// one a backup already took for another write; the backups that missed it get a transfer next time
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kv[key] = write.Value
+	r.seq = write.Seq
+	return nil, err
+}
+
+// Returns whether err is a backup turning a write down, rather than not answering
+func isRefusal(err error) bool {
+	var rpcErr *maelstrom.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.PreconditionFailed
+}
+
+// Sends write to backup, with the whole KV first if it may have missed earlier ones
+func (r *Replica) replicate(ctx context.Context, backup string, write ReplicateRequestBody) error {
+	ctx, cancel := r.clock.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	r.mu.Lock()
+	synced := r.synced[backup]
+	r.mu.Unlock()
+
+	if !synced {
+		r.mu.Lock()
+		transfer := TransferRequestBody{Type: "pb_transfer", View: write.View, Seq: r.seq, KV: maps.Clone(r.kv)}
+		r.mu.Unlock()
+
+		if _, err := r.node.SyncRPC(ctx, backup, transfer); err != nil {
+			return err
+		}
+		r.transfers.Inc()
+	}
+
+	_, err := r.node.SyncRPC(ctx, backup, write)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.synced[backup] = err == nil
+	if err == nil {
+		r.replicated.Inc()
+	}
+	return err
+}
+
+// Returns an error if a backup shouldn't take writes from src in view number, nil with fencing off
+// Must be called with r.mu held
+func (r *Replica) fence(src string, number int64) error {
+	if !r.fencing || number == r.view.Number && src == r.view.Primary {
+		return nil
+	}
+	r.fenced.Inc()
+	return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("in view %d with primary %s", r.view.Number, r.view.Primary))
+}
+
+func (r *Replica) handleReplicate(msg maelstrom.Message, body ReplicateRequestBody) (ReplicateResponseBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.fence(msg.Src, body.View); err != nil {
+		return ReplicateResponseBody{}, err
+	}
+	if r.fencing {
+		if body.Seq <= r.seq {
+			return ReplicateResponseBody{}, nil // a retry of a write already applied
+		}
+		if body.Seq != r.seq+1 {
+			return ReplicateResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("missed writes %d to %d", r.seq+1, body.Seq-1))
+		}
+	}
+
+	r.kv[body.Key] = body.Value
+	r.seq = body.Seq
+	return ReplicateResponseBody{}, nil
+}
+
+func (r *Replica) handleTransfer(msg maelstrom.Message, body TransferRequestBody) (TransferResponseBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.fence(msg.Src, body.View); err != nil {
+		return TransferResponseBody{}, err
+	}
+	// A transfer that arrives after later writes from the same view would only undo them
+	if r.fencing && body.View == r.transferred && body.Seq < r.seq {
+		return TransferResponseBody{}, nil
+	}
+	r.transferred = body.View
+	r.kv = body.KV
+	if r.kv == nil {
+		r.kv = make(map[string]json.RawMessage)
+	}
+	r.seq = body.Seq
+	return TransferResponseBody{}, nil
+}
+
+// Returns the number of the last write applied and the number of keys
+func (r *Replica) Stats() (int64, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq, len(r.kv)
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
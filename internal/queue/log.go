@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kafka"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Message storage
+
+A queue's messages are appended to a log and never change after, only the delivery state kept beside them
+does (see queue.go). Two logs, picked with QUEUE_BACKEND:
+  - kvLog: a counter at queue/<name>/next handing out IDs and each message at queue/<name>/data/<id>
+  - kafkaLog: the kafka workload's broker, with the queue as the log queue/<name> (see internal/kafka)
+
+Both reserve an ID before writing the message, so for a moment, or for good if the sender crashes in
+between, an ID below End has nothing at it; Read returns errUnwritten for those.
+*/
+
+// Where a queue's messages are appended, IDs counting up from 0
+type Log interface {
+	Append(ctx context.Context, queue string, message int) (int, error)
+
+	// Returns the message with the given ID
+	Read(ctx context.Context, queue string, id int) (int, error)
+
+	// Returns the number of IDs handed out so far
+	End(ctx context.Context, queue string) (int, error)
+}
+
+// The KV operations kvLog needs, satisfied by *maelstrom.KV
+type KV interface {
+	kvutil.KV
+	Write(ctx context.Context, key string, value any) error
+}
+
+func errUnwritten(queue string, id int) error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("message %d of %s isn't written", id, queue))
+}
+
+type kvLog struct {
+	kv KV
+}
+
+func (l kvLog) Append(ctx context.Context, queue string, message int) (int, error) {
+	next, err := kvutil.Update(ctx, l.kv, "queue/"+queue+"/next", 0, func(next int) (int, error) {
+		return next + 1, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id := next - 1
+	return id, l.kv.Write(ctx, fmt.Sprintf("queue/%s/data/%d", queue, id), message)
+}
+
+func (l kvLog) Read(ctx context.Context, queue string, id int) (int, error) {
+	var message int
+	if err := l.kv.ReadInto(ctx, fmt.Sprintf("queue/%s/data/%d", queue, id), &message); err != nil {
+		if kvutil.IsNotFound(err) {
+			return 0, errUnwritten(queue, id)
+		}
+		return 0, err
+	}
+	return message, nil
+}
+
+func (l kvLog) End(ctx context.Context, queue string) (int, error) {
+	return kvutil.Read(ctx, l.kv, "queue/"+queue+"/next", 0)
+}
+
+type kafkaLog struct {
+	broker *kafka.Broker
+}
+
+func (l kafkaLog) Append(ctx context.Context, queue string, message int) (int, error) {
+	return l.broker.Send(ctx, "queue/"+queue, message)
+}
+
+func (l kafkaLog) Read(ctx context.Context, queue string, id int) (int, error) {
+	key := "queue/" + queue
+	messages, err := l.broker.Poll(ctx, map[string]int{key: id})
+	if err != nil {
+		return 0, err
+	}
+
+	// Poll skips over offsets with nothing at them, so the first message may be a later one
+	if logMessages := messages[key]; len(logMessages) > 0 && logMessages[0][0] == id {
+		return logMessages[0][1], nil
+	}
+	return 0, errUnwritten(queue, id)
+}
+
+func (l kafkaLog) End(ctx context.Context, queue string) (int, error) {
+	highest, err := l.broker.HighestOffset(ctx, "queue/"+queue)
+	return highest + 1, err
+}
@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kafka"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Work queue
+
+Goal: serve named work queues, where each message is handed to one consumer, redelivered if the consumer
+doesn't ack it within its visibility timeout, and dead-lettered after QUEUE_MAX_DELIVERIES (default 5)
+deliveries without one. The counterpart to the kafka workload's log: a log keeps every message for every
+consumer group to read in order, a queue spreads its messages over the consumers and drops them once handled.
+
+Every node serves every queue, the messages and their delivery state live in lin-kv (see log.go and
+queue.go). QUEUE_BACKEND picks where the messages are appended, lin-kv (default) or kafka, a log kept by
+the kafka workload's broker. A message is invisible for QUEUE_VISIBILITY_MS (default 5000) after it's
+delivered, or the visibility_ms the dequeue asks for.
+*/
+
+var logger = logging.For("queue")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// QUEUE_BACKEND, where messages are appended, lin-kv (default) or kafka
+	Backend string `env:"QUEUE_BACKEND" oneof:"lin-kv|kafka"`
+
+	// QUEUE_VISIBILITY_MS, how long a delivered message is hidden from other consumers (default 5000)
+	Visibility time.Duration `env:"QUEUE_VISIBILITY_MS" min:"1"`
+
+	// QUEUE_MAX_DELIVERIES, how many times a message is delivered before it's dead-lettered (default 5)
+	MaxDeliveries int `env:"QUEUE_MAX_DELIVERIES" min:"1"`
+}
+
+// Enqueue RPC
+type EnqueueRequestBody struct {
+	Type  string `json:"type"`
+	Queue string `json:"queue"`
+	Msg   int    `json:"msg"`
+}
+
+type EnqueueResponseBody struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// Dequeue RPC
+type DequeueRequestBody struct {
+	Type         string `json:"type"`
+	Queue        string `json:"queue"`
+	VisibilityMs int64  `json:"visibility_ms,omitempty"`
+}
+
+type DequeueResponseBody struct {
+	Type    string   `json:"type"`
+	Message *Message `json:"message"` // null if there's nothing to deliver
+}
+
+// Ack and nack RPCs
+type SettleRequestBody struct {
+	Type    string `json:"type"`
+	Queue   string `json:"queue"`
+	ID      int    `json:"id"`
+	Attempt int    `json:"attempt"`
+}
+
+type SettleResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Dead letters RPC
+type DeadLettersRequestBody struct {
+	Type  string `json:"type"`
+	Queue string `json:"queue"`
+}
+
+type DeadLettersResponseBody struct {
+	Type     string       `json:"type"`
+	Messages []DeadLetter `json:"messages"`
+}
+
+// Registers the queue handlers on n
+func Register(n *maelstrom.Node) error {
+	cfg := Config{Backend: "lin-kv", Visibility: 5 * time.Second, MaxDeliveries: 5}
+	if err := config.Load("queue", &cfg); err != nil {
+		return err
+	}
+
+	kv := maelstrom.NewLinKV(n)
+	clock := env.Of(n).Clock
+
+	var log Log = kvLog{kv: kv}
+	if cfg.Backend == "kafka" {
+		// Any node may append to a queue's log, the offsets are reserved with a compare-and-swap
+		log = kafkaLog{broker: kafka.NewBroker(kv, clock, kafka.NewOwnership(n, kv, hlc.New()), nil)}
+	}
+	queues := &Queues{kv: kv, log: log, clock: clock, maxDeliveries: cfg.MaxDeliveries}
+	ctx := context.Background()
+
+	registry := metrics.Of(n)
+	enqueued := registry.Counter("queue.enqueued")
+	delivered := registry.Counter("queue.delivered")
+	redelivered := registry.Counter("queue.redelivered")
+	acked := registry.Counter("queue.acked")
+	deadLettered := registry.Counter("queue.dead_lettered")
+
+	handler.Handle(n, "queue_enqueue", func(msg maelstrom.Message, body EnqueueRequestBody) (EnqueueResponseBody, error) {
+		enqueueCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		id, err := queues.Enqueue(enqueueCtx, body.Queue, body.Msg)
+		if err != nil {
+			return EnqueueResponseBody{}, err
+		}
+		enqueued.Inc()
+		return EnqueueResponseBody{ID: id}, nil
+	})
+
+	handler.Handle(n, "queue_dequeue", func(msg maelstrom.Message, body DequeueRequestBody) (DequeueResponseBody, error) {
+		visibility := cfg.Visibility
+		if body.VisibilityMs > 0 {
+			visibility = time.Duration(body.VisibilityMs) * time.Millisecond
+		}
+
+		dequeueCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		message, dead, err := queues.Dequeue(dequeueCtx, body.Queue, visibility)
+		if len(dead) > 0 {
+			deadLettered.Add(int64(len(dead)))
+			logger.Warn("dead-lettered messages", "queue", body.Queue, "ids", dead, "deliveries", cfg.MaxDeliveries)
+		}
+		if err != nil {
+			return DequeueResponseBody{}, err
+		}
+
+		if message != nil {
+			delivered.Inc()
+			if message.Attempt > 1 {
+				redelivered.Inc()
+			}
+		}
+		return DequeueResponseBody{Message: message}, nil
+	})
+
+	handler.Handle(n, "queue_ack", func(msg maelstrom.Message, body SettleRequestBody) (SettleResponseBody, error) {
+		ackCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		if err := queues.Ack(ackCtx, body.Queue, body.ID, body.Attempt); err != nil {
+			return SettleResponseBody{}, err
+		}
+		acked.Inc()
+		return SettleResponseBody{}, nil
+	})
+
+	handler.Handle(n, "queue_nack", func(msg maelstrom.Message, body SettleRequestBody) (SettleResponseBody, error) {
+		nackCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		return SettleResponseBody{}, queues.Nack(nackCtx, body.Queue, body.ID, body.Attempt)
+	})
+
+	handler.Handle(n, "queue_dead_letters", func(msg maelstrom.Message, body DeadLettersRequestBody) (DeadLettersResponseBody, error) {
+		readCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		letters, err := queues.DeadLetters(readCtx, body.Queue)
+		return DeadLettersResponseBody{Messages: letters}, err
+	})
+
+	admin.Of(n).Register("queue", admin.Module{Status: func() any {
+		return map[string]any{
+			"backend":        cfg.Backend,
+			"visibility_ms":  cfg.Visibility.Milliseconds(),
+			"max_deliveries": cfg.MaxDeliveries,
+		}
+	}})
+
+	return nil
+}
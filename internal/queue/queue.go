@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Delivery
+
+Unlike a kafka log, where each consumer group reads every message in order from its own offset, a queue
+hands each message to one consumer at a time and forgets it once it's handled. Which messages are where is
+kept in one record per queue at queue/<name>/state, changed with a compare-and-swap by whichever node
+serves the request:
+  - next: the first message never delivered, every one below it is in flight, acked or dead
+  - in_flight: the messages delivered but not acked, with how many times and until when
+  - dead: the messages dead-lettered, in the order they were
+
+A delivered message is invisible to other consumers until its visibility timeout runs out. If the consumer
+acks it by then it's done; if it doesn't, because it crashed or is slow or nacked it, the message is
+delivered again, so every message is handled at least once and a consumer must expect repeats. A message
+that's been delivered maxDeliveries times without an ack is dead-lettered instead of going round again, so
+a message that crashes every consumer doesn't keep the queue busy for good.
+
+Each delivery of a message is numbered, and only the latest delivery may ack or nack it, so a consumer
+that was too slow can't ack a message that's since been handed to someone else.
+*/
+
+// A queue's delivery state
+type State struct {
+	Next     int              `json:"next"`
+	InFlight map[int]Delivery `json:"in_flight"`
+	Dead     []int            `json:"dead"`
+}
+
+type Delivery struct {
+	Attempt  int   `json:"attempt"`  // how many times the message has been delivered, this time included
+	Deadline int64 `json:"deadline"` // when it's delivered again if not acked, Unix milliseconds
+}
+
+// A message handed to a consumer
+type Message struct {
+	ID      int `json:"id"`
+	Msg     int `json:"msg"`
+	Attempt int `json:"attempt"` // the delivery's number, which acking or nacking it must give
+}
+
+// A dead-lettered message, Msg is nil if it was never written
+type DeadLetter struct {
+	ID  int  `json:"id"`
+	Msg *int `json:"msg"`
+}
+
+type Queues struct {
+	kv            kvutil.KV
+	log           Log
+	clock         env.Clock
+	maxDeliveries int
+}
+
+func stateKey(name string) string {
+	return "queue/" + name + "/state"
+}
+
+// Appends message to name's queue and returns its ID
+func (q *Queues) Enqueue(ctx context.Context, name string, message int) (int, error) {
+	return q.log.Append(ctx, name, message)
+}
+
+// Delivers the next message of name's queue, hiding it from other consumers for visibility
+// A message whose visibility timeout ran out goes before any never delivered; returns nil if there's none
+// Also returns the IDs of the messages dead-lettered on the way
+func (q *Queues) Dequeue(ctx context.Context, name string, visibility time.Duration) (*Message, []int, error) {
+	end, err := q.log.End(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var delivered *Message
+	var dead []int
+	_, err = kvutil.Update(ctx, q.kv, stateKey(name), State{}, func(old State) (State, error) {
+		delivered, dead = nil, nil
+		now := q.clock.Now()
+		deadline := now.Add(visibility).UnixMilli()
+
+		state := State{Next: old.Next, InFlight: maps.Clone(old.InFlight), Dead: slices.Clone(old.Dead)}
+		if state.InFlight == nil {
+			state.InFlight = make(map[int]Delivery)
+		}
+
+		for _, id := range slices.Sorted(maps.Keys(old.InFlight)) {
+			d := old.InFlight[id]
+			if d.Deadline > now.UnixMilli() {
+				continue
+			}
+			if d.Attempt >= q.maxDeliveries {
+				delete(state.InFlight, id)
+				state.Dead = append(state.Dead, id)
+				dead = append(dead, id)
+				continue
+			}
+			if delivered == nil {
+				delivered = &Message{ID: id, Attempt: d.Attempt + 1}
+				state.InFlight[id] = Delivery{Attempt: delivered.Attempt, Deadline: deadline}
+			}
+		}
+
+		if delivered == nil && state.Next < end {
+			delivered = &Message{ID: state.Next, Attempt: 1}
+			state.InFlight[state.Next] = Delivery{Attempt: 1, Deadline: deadline}
+			state.Next++
+		}
+
+		if delivered == nil && len(dead) == 0 {
+			return old, kvutil.ErrNoChange
+		}
+		return state, nil
+	})
+	if err != nil || delivered == nil {
+		return nil, dead, err
+	}
+
+	// A message that isn't written yet stays in flight, and is dead-lettered if it never is
+	delivered.Msg, err = q.log.Read(ctx, name, delivered.ID)
+	if err != nil {
+		return nil, dead, err
+	}
+	return delivered, dead, nil
+}
+
+// Changes the state of message id, delivered for the attempt-th time, with change
+// Acking a message that's already acked does nothing
+func (q *Queues) settle(ctx context.Context, name string, id, attempt int, change func(state *State, d Delivery)) error {
+	_, err := kvutil.Update(ctx, q.kv, stateKey(name), State{}, func(old State) (State, error) {
+		d, ok := old.InFlight[id]
+		if !ok {
+			if id < old.Next && !slices.Contains(old.Dead, id) {
+				return old, errAcked
+			}
+			return old, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("message %d of %s isn't in flight", id, name))
+		}
+		if d.Attempt != attempt {
+			return old, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("message %d of %s was delivered again since", id, name))
+		}
+
+		state := State{Next: old.Next, InFlight: maps.Clone(old.InFlight), Dead: old.Dead}
+		change(&state, d)
+		return state, nil
+	})
+	return err
+}
+
+// Returned by settle for a message that's already been acked
+var errAcked = errors.New("queue: already acked")
+
+// Marks message id as handled, so it's never delivered again
+func (q *Queues) Ack(ctx context.Context, name string, id, attempt int) error {
+	err := q.settle(ctx, name, id, attempt, func(state *State, d Delivery) {
+		delete(state.InFlight, id)
+	})
+	if errors.Is(err, errAcked) {
+		return nil
+	}
+	return err
+}
+
+// Gives message id back without handling it, so it's delivered again straight away
+func (q *Queues) Nack(ctx context.Context, name string, id, attempt int) error {
+	err := q.settle(ctx, name, id, attempt, func(state *State, d Delivery) {
+		d.Deadline = q.clock.Now().UnixMilli()
+		state.InFlight[id] = d
+	})
+	if errors.Is(err, errAcked) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("message %d of %s is already acked", id, name))
+	}
+	return err
+}
+
+// Returns the messages dead-lettered from name's queue
+func (q *Queues) DeadLetters(ctx context.Context, name string) ([]DeadLetter, error) {
+	state, err := kvutil.Read(ctx, q.kv, stateKey(name), State{})
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(state.Dead))
+	for _, id := range state.Dead {
+		letter := DeadLetter{ID: id}
+		if message, err := q.log.Read(ctx, name, id); err == nil {
+			letter.Msg = &message
+		} else if !isUnwritten(err) {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func isUnwritten(err error) bool {
+	var rpcErr *maelstrom.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.TemporarilyUnavailable
+}
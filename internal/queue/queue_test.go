@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+)
+
+func TestDelivery(t *testing.T) {
+	kv := fake.NewKV()
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	q := &Queues{kv: kv, log: kvLog{kv: kv}, clock: clock, maxDeliveries: 3}
+	ctx := context.Background()
+	visibility := time.Second
+
+	for _, msg := range []int{10, 11} {
+		if _, err := q.Enqueue(ctx, "jobs", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dequeue := func(want *Message) {
+		t.Helper()
+		got, _, err := q.Dequeue(ctx, "jobs", visibility)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == nil && got != nil || want != nil && (got == nil || *got != *want) {
+			t.Fatalf("dequeued %+v, want %+v", got, want)
+		}
+	}
+
+	// Each message goes to one consumer, and isn't delivered again while it's invisible
+	dequeue(&Message{ID: 0, Msg: 10, Attempt: 1})
+	dequeue(&Message{ID: 1, Msg: 11, Attempt: 1})
+	dequeue(nil)
+
+	// Acking is for good, and can be repeated
+	for range 2 {
+		if err := q.Ack(ctx, "jobs", 0, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The unacked message comes back once it's visible again, and its first consumer can no longer ack it
+	clock.Advance(visibility)
+	dequeue(&Message{ID: 1, Msg: 11, Attempt: 2})
+	if err := q.Ack(ctx, "jobs", 1, 1); err == nil {
+		t.Fatal("acked an earlier delivery")
+	}
+
+	// A nacked message comes back straight away
+	if err := q.Nack(ctx, "jobs", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	dequeue(&Message{ID: 1, Msg: 11, Attempt: 3})
+
+	// On its third delivery running out it's dead-lettered
+	clock.Advance(visibility)
+	message, dead, err := q.Dequeue(ctx, "jobs", visibility)
+	if err != nil || message != nil || len(dead) != 1 || dead[0] != 1 {
+		t.Fatalf("dequeued %+v, dead-lettered %v, %v", message, dead, err)
+	}
+	letters, err := q.DeadLetters(ctx, "jobs")
+	if err != nil || len(letters) != 1 || letters[0].ID != 1 || *letters[0].Msg != 11 {
+		t.Fatalf("dead letters %+v, %v", letters, err)
+	}
+	dequeue(nil)
+}
+
+func TestUnwritten(t *testing.T) {
+	kv := fake.NewKV()
+	clock := fake.NewClock(time.UnixMilli(1_000_000))
+	q := &Queues{kv: kv, log: kvLog{kv: kv}, clock: clock, maxDeliveries: 1}
+	ctx := context.Background()
+
+	// A sender reserved ID 0 and crashed before writing the message
+	kv.Write(ctx, "queue/jobs/next", 1)
+
+	if _, _, err := q.Dequeue(ctx, "jobs", time.Second); !isUnwritten(err) {
+		t.Fatalf("dequeued an unwritten message: %v", err)
+	}
+	clock.Advance(time.Second)
+	if _, dead, err := q.Dequeue(ctx, "jobs", time.Second); err != nil || len(dead) != 1 {
+		t.Fatalf("dead-lettered %v, %v", dead, err)
+	}
+	letters, err := q.DeadLetters(ctx, "jobs")
+	if err != nil || len(letters) != 1 || letters[0].Msg != nil {
+		t.Fatalf("dead letters %+v, %v", letters, err)
+	}
+}
@@ -0,0 +1,150 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Linearizable KV
+
+The lin-kv workload's read, write and cas operations, each appended to the Raft log and executed when it's
+applied, so every node executes them in the same order. Reads go through the log too: a leader answering
+from its own state could have been deposed without knowing it and serve a stale value.
+
+Keys and values are kept as compacted JSON, so a cas compares exactly what the client sent.
+
+With RAFT_COMMIT_WAIT set, the node submitting a write or cas stamps it with a TrueTime commit timestamp
+(see internal/truetime), and the store raises any timestamp that isn't past the previous one it applied, so
+timestamps follow the log's order even across leaders whose clocks disagree. The commit timestamp is
+returned to the client as ts once commit wait has made sure it's in the past.
+*/
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string          `json:"type"`
+	Key  json.RawMessage `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Write RPC
+type WriteRequestBody struct {
+	Type  string          `json:"type"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type WriteResponseBody struct {
+	Type string `json:"type"`
+	TS   int64  `json:"ts,omitempty"` // commit timestamp in Unix microseconds, with commit wait
+}
+
+// Compare-and-set RPC
+type CASRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type CASResponseBody struct {
+	Type string `json:"type"`
+	TS   int64  `json:"ts,omitempty"` // commit timestamp in Unix microseconds, with commit wait
+}
+
+// An operation as stored in the Raft log
+type KVCommand struct {
+	Op                string          `json:"op"`
+	Key               json.RawMessage `json:"key"`
+	Value             json.RawMessage `json:"value,omitempty"`
+	From              json.RawMessage `json:"from,omitempty"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+	Timestamp         int64           `json:"ts,omitempty"` // proposed commit timestamp, with commit wait
+}
+
+// Outcome of applying a command, the value read or the error to return to the client
+type KVResult struct {
+	Value     json.RawMessage
+	Err       error
+	Timestamp int64 // commit timestamp of a stamped write or cas
+}
+
+type KVStore struct {
+	mu     sync.Mutex
+	kv     map[string]json.RawMessage
+	lastTS int64 // latest commit timestamp applied
+}
+
+func NewKVStore() *KVStore {
+	return &KVStore{kv: make(map[string]json.RawMessage)}
+}
+
+func (s *KVStore) Apply(data json.RawMessage) any {
+	var cmd KVCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return KVResult{Err: maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(compact(cmd.Key))
+	value, exists := s.kv[key]
+
+	switch cmd.Op {
+	case "read":
+		if !exists {
+			return KVResult{Err: maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")}
+		}
+		return KVResult{Value: value}
+
+	case "write":
+		s.kv[key] = compact(cmd.Value)
+		return KVResult{Timestamp: s.stamp(cmd)}
+
+	case "cas":
+		if !exists && !cmd.CreateIfNotExists {
+			return KVResult{Err: maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")}
+		}
+		if exists && !bytes.Equal(value, compact(cmd.From)) {
+			return KVResult{Err: maelstrom.NewRPCError(maelstrom.PreconditionFailed, "expected "+string(compact(cmd.From))+", found "+string(value))}
+		}
+		s.kv[key] = compact(cmd.Value)
+		return KVResult{Timestamp: s.stamp(cmd)}
+	}
+
+	return KVResult{Err: maelstrom.NewRPCError(maelstrom.NotSupported, "unknown operation "+cmd.Op)}
+}
+
+// Returns the commit timestamp of a stamped command, at least one past the previous one, 0 if it isn't stamped
+// Must be called with s.mu held
+func (s *KVStore) stamp(cmd KVCommand) int64 {
+	if cmd.Timestamp == 0 {
+		return 0
+	}
+	s.lastTS = max(cmd.Timestamp, s.lastTS+1)
+	return s.lastTS
+}
+
+// Returns the number of keys stored
+func (s *KVStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.kv)
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,191 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/truetime"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Capstone: Raft
+
+Goal: replicate a log of commands across the cluster with Raft (see raft.go) and apply it to a state
+machine on every node, here a linearizable key-value store serving the lin-kv workload (see kv.go)
+
+Only the leader runs operations, other nodes forward them to the leader they know of.
+
+Election timeouts are drawn from [RAFT_ELECTION_TIMEOUT_MS, 2*RAFT_ELECTION_TIMEOUT_MS) (default 500), and
+a leader sends append_entries at least every RAFT_HEARTBEAT_MS (default 100). Set RAFT_COMMIT_WAIT=true
+to stamp writes with commit timestamps ordered the same way as the writes happened (see kv.go). The term,
+vote and log are kept in the node's storage, set STORAGE_BACKEND=file for them to survive a restart.
+*/
+
+// Raft status RPC, for watching elections and replication progress
+type RaftStatusRequestBody struct {
+	Type string `json:"type"`
+}
+
+type RaftStatusResponseBody struct {
+	Type        string `json:"type"`
+	Term        int64  `json:"term"`
+	Role        string `json:"role"`
+	Leader      string `json:"leader"`
+	LastIndex   int64  `json:"last_index"`
+	CommitIndex int64  `json:"commit_index"`
+	LastApplied int64  `json:"last_applied"`
+	Keys        int    `json:"keys"`
+}
+
+// Returns the Raft settings, the defaults overridden by the environment
+func LoadConfig() (RaftConfig, error) {
+	cfg := RaftConfig{
+		ElectionTimeout:   500 * time.Millisecond,
+		HeartbeatInterval: 100 * time.Millisecond,
+		RPCTimeout:        time.Second,
+	}
+	err := config.Load("raft", &cfg)
+	return cfg, err
+}
+
+// Registers the Raft and KV handlers on n, the elections start once n is initialized
+func Register(n *maelstrom.Node) error {
+	ctx := context.Background()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	kv := NewKVStore()
+	raft := NewRaft(n, kv, cfg)
+
+	var tt *truetime.Clock
+	if cfg.CommitWait {
+		if tt, err = truetime.Of(n); err != nil {
+			return err
+		}
+	}
+
+	// Elections need the cluster's node IDs and storage is per node, so both wait until they're known
+	n.Handle("init", func(msg maelstrom.Message) error {
+		store, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+		if err := raft.Open(store); err != nil {
+			return err
+		}
+
+		lifecycle.Of(n).Go(raft.Run)
+		return nil
+	})
+
+	status := func() RaftStatusResponseBody {
+		term, role, leader := raft.State()
+		last, commit, applied := raft.Indexes()
+
+		return RaftStatusResponseBody{
+			Term:        term,
+			Role:        role.String(),
+			Leader:      leader,
+			LastIndex:   last,
+			CommitIndex: commit,
+			LastApplied: applied,
+			Keys:        kv.Len(),
+		}
+	}
+
+	handler.Handle(n, "raft_status", func(msg maelstrom.Message, body RaftStatusRequestBody) (RaftStatusResponseBody, error) {
+		return status(), nil
+	})
+
+	// Pausing stops the election and heartbeat timers, so pausing a leader lets the others elect a new one
+	admin.Of(n).Register("raft", admin.Module{Status: func() any { return status() }})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		result, err := execute(ctx, n, raft, tt, msg, KVCommand{Op: "read", Key: body.Key})
+		if err != nil {
+			return ReadResponseBody{}, err
+		}
+
+		return ReadResponseBody{
+			Value: result.Value,
+		}, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body WriteRequestBody) (WriteResponseBody, error) {
+		result, err := execute(ctx, n, raft, tt, msg, KVCommand{Op: "write", Key: body.Key, Value: body.Value})
+		return WriteResponseBody{TS: result.Timestamp}, err
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body CASRequestBody) (CASResponseBody, error) {
+		result, err := execute(ctx, n, raft, tt, msg, KVCommand{Op: "cas", Key: body.Key, Value: body.To, From: body.From, CreateIfNotExists: body.CreateIfNotExists})
+		return CASResponseBody{TS: result.Timestamp}, err
+	})
+
+	return nil
+}
+
+// Runs cmd through the Raft log and returns what it produced
+// On a node that isn't the leader the request is forwarded to the leader instead, once: a forwarded
+// request that finds the leader has changed again fails as temporarily unavailable, and the client retries
+// With tt set writes and cas are stamped with a commit timestamp, and only answered once it has passed
+func execute(ctx context.Context, n *maelstrom.Node, raft *Raft, tt *truetime.Clock, msg maelstrom.Message, cmd KVCommand) (KVResult, error) {
+	ctx, cancel := raft.env.Clock.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	if tt != nil && cmd.Op != "read" {
+		cmd.Timestamp = tt.Now().Latest.UnixMicro()
+	}
+
+	result, err := raft.Submit(ctx, cmd)
+	if err == nil {
+		kvResult := result.(KVResult)
+		if kvResult.Err != nil {
+			return KVResult{}, kvResult.Err
+		}
+		// Commit wait: nobody hears of the write until its timestamp is in the past on every node
+		if kvResult.Timestamp != 0 {
+			if err := tt.WaitUntilAfter(ctx, time.UnixMicro(kvResult.Timestamp)); err != nil {
+				return KVResult{}, err
+			}
+		}
+		return kvResult, nil
+	}
+	if !errors.Is(err, ErrNotLeader) {
+		return KVResult{}, err
+	}
+
+	// Raw values, so the leader sees the client's keys and values exactly
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Body, &request); err != nil {
+		return KVResult{}, err
+	}
+
+	_, _, leader := raft.State()
+	if leader == "" || leader == n.ID() || string(request["forwarded"]) == "true" {
+		return KVResult{}, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("not the leader, try %q", leader))
+	}
+
+	request["forwarded"] = json.RawMessage("true")
+
+	// A read's reply has the value, a write's or cas's the timestamp if it has one
+	var body struct {
+		Value json.RawMessage `json:"value"`
+		TS    int64           `json:"ts"`
+	}
+	if err := handler.Call(ctx, n, leader, request, &body); err != nil {
+		return KVResult{}, err
+	}
+	return KVResult{Value: body.Value, Timestamp: body.TS}, nil
+}
@@ -0,0 +1,95 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Persistent state
+
+The term, the vote and the log are appended to the node's storage log (see internal/storage) before the
+node answers a request_vote or append_entries, starts an election or accepts a command, and replayed when
+it restarts. A node that forgot its term and vote could grant a second vote in a term it already voted
+in, electing two leaders, and one that forgot its log could lose entries the leader already counted
+toward a commit. The commit index and the state machine aren't kept: a restarted node applies its log
+again from the start as the leader tells it how far it's committed. The log only grows, it's never
+compacted.
+*/
+
+// Storage log the term, vote and log entries are kept in
+const raftLog = "raft"
+
+var errNotOpen = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "raft: storage isn't open yet")
+
+// A record in the storage log, either the term and vote or entries replacing the log from index From on
+type record struct {
+	State   *hardState `json:"state,omitempty"`
+	From    int64      `json:"from,omitempty"`
+	Entries []Entry    `json:"entries,omitempty"`
+}
+
+type hardState struct {
+	Term     int64  `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// Replays the term, vote and log from store, must be called before Run
+func (r *Raft) Open(store storage.Store) error {
+	wal, records, err := store.OpenLog(raftLog)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, data := range records {
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("raft: storage log: %w", err)
+		}
+		if rec.State != nil {
+			r.term, r.votedFor = rec.State.Term, rec.State.VotedFor
+		} else {
+			r.log = append(r.log[:rec.From], rec.Entries...)
+		}
+	}
+	r.wal = wal
+	return nil
+}
+
+// The following must be called with r.mu held
+
+// Records a new term and vote, r.term and r.votedFor are only set once it's stored
+func (r *Raft) saveState(term int64, votedFor string) error {
+	if err := r.appendRecord(record{State: &hardState{Term: term, VotedFor: votedFor}}); err != nil {
+		return err
+	}
+	r.term, r.votedFor = term, votedFor
+	return nil
+}
+
+// Records entries replacing the log from index from on, r.log is only changed once they're stored
+func (r *Raft) saveEntries(from int64, entries []Entry) error {
+	if err := r.appendRecord(record{From: from, Entries: entries}); err != nil {
+		return err
+	}
+	r.log = append(r.log[:from], entries...)
+	return nil
+}
+
+func (r *Raft) appendRecord(rec record) error {
+	if r.wal == nil {
+		return errNotOpen
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.wal.Append(data)
+}
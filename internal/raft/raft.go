@@ -0,0 +1,612 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Raft
+
+Every node keeps a log of commands and applies them, in log order, to its state machine once they're
+committed. One node at a time is the leader: clients' commands are appended to its log and it replicates
+the log to the others with append_entries, which doubles as a heartbeat. An entry is committed once it's
+on a majority of nodes, and the leader tells the others how far the log is committed with the next
+append_entries.
+
+Terms number the leaders. A follower that hasn't heard from a leader for its election timeout (randomized
+so nodes rarely time out together) starts a new term and asks for votes with request_vote; a node votes
+for at most one candidate per term, and only for one whose log is at least as up to date as its own, so
+the winner of a majority has every committed entry. A node that sees a higher term in any message steps
+down to follower in that term.
+
+A leader only counts replicas to commit entries of its own term; earlier entries are committed along with
+them. A follower whose log disagrees with the leader's answers append_entries with the first index of the
+conflicting term (or the end of its log), so the leader skips back a term at a time rather than an entry.
+
+The term, vote and log are stored before a node acts on them, so it keeps them across a restart, see
+persist.go.
+
+The node's metrics count elections started and won (raft.elections, raft.elections_won) and report its
+term, role (0 follower, 1 candidate, 2 leader) and last, commit and applied indexes as gauges.
+*/
+
+var logger = logging.For("raft")
+
+const (
+	appendBatchSize  = 64
+	raftTickInterval = 10 * time.Millisecond
+)
+
+var ErrNotLeader = errors.New("raft: not the leader")
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	return [...]string{"follower", "candidate", "leader"}[r]
+}
+
+// Applies committed commands, in log order, on every node
+type StateMachine interface {
+	Apply(command json.RawMessage) any
+}
+
+type Entry struct {
+	Term    int64           `json:"term"`
+	Command json.RawMessage `json:"command"`
+}
+
+// Request vote RPC
+type RequestVoteRequestBody struct {
+	Type         string `json:"type"`
+	Term         int64  `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex int64  `json:"last_log_index"`
+	LastLogTerm  int64  `json:"last_log_term"`
+}
+
+type RequestVoteResponseBody struct {
+	Type        string `json:"type"`
+	Term        int64  `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// Append entries RPC, also the leader's heartbeat
+type AppendEntriesRequestBody struct {
+	Type         string  `json:"type"`
+	Term         int64   `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex int64   `json:"prev_log_index"`
+	PrevLogTerm  int64   `json:"prev_log_term"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit int64   `json:"leader_commit"`
+}
+
+type AppendEntriesResponseBody struct {
+	Type    string `json:"type"`
+	Term    int64  `json:"term"`
+	Success bool   `json:"success"`
+
+	// On success the index of the last entry now matching the leader's, otherwise where to retry from
+	Index int64 `json:"index"`
+}
+
+type RaftConfig struct {
+	ElectionTimeout   time.Duration `env:"RAFT_ELECTION_TIMEOUT_MS" min:"1"` // minimum, each timeout is drawn from [ElectionTimeout, 2*ElectionTimeout)
+	HeartbeatInterval time.Duration `env:"RAFT_HEARTBEAT_MS" min:"1"`
+	CommitWait        bool          `env:"RAFT_COMMIT_WAIT"` // stamp writes with TrueTime and wait the stamps out, see kv.go
+	RPCTimeout        time.Duration
+}
+
+// Result of applying an entry, handed to the Submit call waiting on it
+type applied struct {
+	term   int64
+	result any
+}
+
+type Raft struct {
+	node   *maelstrom.Node
+	env    *env.Env
+	sm     StateMachine
+	config RaftConfig
+
+	mu       sync.Mutex
+	role     Role
+	term     int64
+	votedFor string
+	leader   string      // leader of the current term, empty if not known yet
+	log      []Entry     // log[0] is a sentinel of term 0, so real entries start at index 1
+	wal      storage.Log // where term, votedFor and log are stored, nil until Open
+
+	commitIndex int64
+	lastApplied int64
+	commitCond  *sync.Cond // broadcast when commitIndex advances
+
+	deadline  time.Time // when a follower or candidate starts the next election
+	heartbeat time.Time // when a leader sends the next round of append_entries
+
+	// Leader state, reset on every election won
+	nextIndex  map[string]int64
+	matchIndex map[string]int64
+	inFlight   map[string]bool // peers with an append_entries outstanding
+
+	waiters map[int64]chan applied // Submit calls waiting for the entry at each index
+
+	elections, electionsWon *metrics.Counter
+}
+
+func NewRaft(node *maelstrom.Node, sm StateMachine, config RaftConfig) *Raft {
+	r := &Raft{
+		node:       node,
+		env:        env.Of(node),
+		sm:         sm,
+		config:     config,
+		log:        []Entry{{}},
+		nextIndex:  make(map[string]int64),
+		matchIndex: make(map[string]int64),
+		inFlight:   make(map[string]bool),
+		waiters:    make(map[int64]chan applied),
+	}
+	r.commitCond = sync.NewCond(&r.mu)
+	r.resetElectionTimer()
+
+	registry := metrics.Of(node)
+	r.elections = registry.Counter("raft.elections")
+	r.electionsWon = registry.Counter("raft.elections_won")
+	registry.GaugeFunc("raft.term", func() float64 {
+		term, _, _ := r.State()
+		return float64(term)
+	})
+	registry.GaugeFunc("raft.role", func() float64 {
+		_, role, _ := r.State()
+		return float64(role)
+	})
+	for i, name := range []string{"raft.last_index", "raft.commit_index", "raft.applied_index"} {
+		registry.GaugeFunc(name, func() float64 {
+			last, commit, applied := r.Indexes()
+			return float64([]int64{last, commit, applied}[i])
+		})
+	}
+
+	handler.Handle(node, "request_vote", func(msg maelstrom.Message, body RequestVoteRequestBody) (RequestVoteResponseBody, error) {
+		return r.handleRequestVote(body)
+	})
+
+	handler.Handle(node, "append_entries", func(msg maelstrom.Message, body AppendEntriesRequestBody) (AppendEntriesResponseBody, error) {
+		return r.handleAppendEntries(body)
+	})
+
+	return r
+}
+
+// Runs elections, heartbeats and the apply loop until ctx is cancelled, must be called once the node is
+// initialized and Open has replayed the stored state
+func (r *Raft) Run(ctx context.Context) {
+	go r.applyLoop(ctx)
+
+	ticker := r.env.Clock.NewTicker(raftTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.commitCond.Broadcast()
+			r.mu.Unlock()
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				r.tick()
+			}
+		}
+	}
+}
+
+func (r *Raft) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.env.Clock.Now()
+	if r.role == Leader {
+		if now.After(r.heartbeat) {
+			r.broadcastAppend()
+		}
+	} else if now.After(r.deadline) {
+		r.startElection()
+	}
+}
+
+// Appends command to the log and blocks until it's applied, returning the state machine's result
+// Returns ErrNotLeader if this node isn't the leader or loses leadership before the entry commits
+func (r *Raft) Submit(ctx context.Context, command any) (any, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.role != Leader {
+		r.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	term := r.term
+	index := r.lastIndex() + 1
+	if err := r.saveEntries(index, []Entry{{Term: term, Command: data}}); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	done := make(chan applied, 1)
+	r.waiters[index] = done
+
+	// A single node cluster commits on its own
+	r.advanceCommit()
+	r.broadcastAppend()
+	r.mu.Unlock()
+
+	select {
+	case result := <-done:
+		// Another leader's entry took the index, ours was never committed
+		if result.term != term {
+			return nil, ErrNotLeader
+		}
+		return result.result, nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.waiters, index)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the current term, this node's role and the leader it knows of
+func (r *Raft) State() (int64, Role, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.term, r.role, r.leader
+}
+
+// Returns the index of the last entry, the commit index and the index of the last entry applied
+func (r *Raft) Indexes() (last int64, commit int64, applied int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastIndex(), r.commitIndex, r.lastApplied
+}
+
+// The following must be called with r.mu held
+
+func (r *Raft) lastIndex() int64 {
+	return int64(len(r.log) - 1)
+}
+
+func (r *Raft) peers() []string {
+	return slices.DeleteFunc(slices.Clone(r.node.NodeIDs()), func(id string) bool {
+		return id == r.node.ID()
+	})
+}
+
+func (r *Raft) majority() int {
+	return len(r.node.NodeIDs())/2 + 1
+}
+
+func (r *Raft) resetElectionTimer() {
+	timeout := r.config.ElectionTimeout + time.Duration(r.env.Rand.Int64N(int64(r.config.ElectionTimeout)))
+	r.deadline = r.env.Clock.Now().Add(timeout)
+}
+
+// Moves to a higher term seen in a message, as a follower that hasn't voted in it yet
+// Changes nothing if the new term can't be stored
+func (r *Raft) stepDown(term int64) error {
+	if term > r.term {
+		if err := r.saveState(term, ""); err != nil {
+			return err
+		}
+		r.leader = ""
+	}
+
+	if r.role != Follower {
+		logger.Info("stepping down to follower", "term", r.term)
+	}
+	r.role = Follower
+	return nil
+}
+
+// Steps down to a higher term seen in a reply, which has nobody to report a storage error to
+func (r *Raft) stepDownOnReply(term int64) {
+	if err := r.stepDown(term); err != nil {
+		logger.Error("storing term failed", "term", term, "error", err)
+	}
+	r.resetElectionTimer()
+}
+
+func (r *Raft) startElection() {
+	r.resetElectionTimer()
+	if err := r.saveState(r.term+1, r.node.ID()); err != nil {
+		logger.Error("storing term failed", "term", r.term+1, "error", err)
+		return
+	}
+	r.elections.Inc()
+	r.role = Candidate
+	r.leader = ""
+
+	term := r.term
+	request := RequestVoteRequestBody{
+		Type:         "request_vote",
+		Term:         term,
+		Candidate:    r.node.ID(),
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.log[r.lastIndex()].Term,
+	}
+	logger.Info("starting election", "term", term)
+
+	votes := 1
+	if votes >= r.majority() {
+		r.becomeLeader()
+		return
+	}
+
+	for _, peer := range r.peers() {
+		go func(peer string) {
+			ctx, cancel := r.env.Clock.WithTimeout(context.Background(), r.config.RPCTimeout)
+			defer cancel()
+
+			var resp RequestVoteResponseBody
+			if err := handler.Call(ctx, r.node, peer, request, &resp); err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if resp.Term > r.term {
+				r.stepDownOnReply(resp.Term)
+				return
+			}
+
+			// Votes from an earlier election, or arriving after this one was decided, don't count
+			if r.term != term || r.role != Candidate || !resp.VoteGranted {
+				return
+			}
+
+			if votes++; votes >= r.majority() {
+				r.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (r *Raft) becomeLeader() {
+	logger.Info("elected leader", "term", r.term)
+	r.electionsWon.Inc()
+
+	r.role = Leader
+	r.leader = r.node.ID()
+	for _, peer := range r.peers() {
+		r.nextIndex[peer] = r.lastIndex() + 1
+		r.matchIndex[peer] = 0
+	}
+
+	r.broadcastAppend()
+}
+
+// Sends every peer without an outstanding request the entries it's missing, or a heartbeat
+func (r *Raft) broadcastAppend() {
+	r.heartbeat = r.env.Clock.Now().Add(r.config.HeartbeatInterval)
+
+	for _, peer := range r.peers() {
+		if !r.inFlight[peer] {
+			r.inFlight[peer] = true
+			go r.replicate(peer, r.appendRequest(peer))
+		}
+	}
+}
+
+func (r *Raft) appendRequest(peer string) AppendEntriesRequestBody {
+	prev := r.nextIndex[peer] - 1
+	end := min(r.lastIndex()+1, prev+1+appendBatchSize)
+
+	return AppendEntriesRequestBody{
+		Type:         "append_entries",
+		Term:         r.term,
+		Leader:       r.node.ID(),
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.log[prev].Term,
+		Entries:      slices.Clone(r.log[prev+1 : end]),
+		LeaderCommit: r.commitIndex,
+	}
+}
+
+// Sends peer a single append_entries and records the outcome
+func (r *Raft) replicate(peer string, request AppendEntriesRequestBody) {
+	ctx, cancel := r.env.Clock.WithTimeout(context.Background(), r.config.RPCTimeout)
+	defer cancel()
+
+	var resp AppendEntriesResponseBody
+	err := handler.Call(ctx, r.node, peer, request, &resp)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.inFlight, peer)
+
+	// The next heartbeat retries
+	if err != nil {
+		return
+	}
+
+	if resp.Term > r.term {
+		r.stepDownOnReply(resp.Term)
+		return
+	}
+
+	if r.role != Leader || r.term != request.Term {
+		return
+	}
+
+	if resp.Success {
+		r.matchIndex[peer] = max(r.matchIndex[peer], resp.Index)
+		r.nextIndex[peer] = r.matchIndex[peer] + 1
+		r.advanceCommit()
+	} else {
+		r.nextIndex[peer] = max(1, min(resp.Index, r.nextIndex[peer]-1))
+	}
+
+	// Keep going while the peer is behind rather than waiting for the next heartbeat
+	if r.nextIndex[peer] <= r.lastIndex() {
+		r.inFlight[peer] = true
+		go r.replicate(peer, r.appendRequest(peer))
+	}
+}
+
+// Commits the highest entry of the current term that a majority has
+func (r *Raft) advanceCommit() {
+	for index := r.lastIndex(); index > r.commitIndex && r.log[index].Term == r.term; index-- {
+		replicas := 1
+		for _, match := range r.matchIndex {
+			if match >= index {
+				replicas++
+			}
+		}
+
+		if replicas >= r.majority() {
+			r.commitIndex = index
+			r.commitCond.Broadcast()
+			return
+		}
+	}
+}
+
+func (r *Raft) handleRequestVote(body RequestVoteRequestBody) (RequestVoteResponseBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.Term > r.term {
+		if err := r.stepDown(body.Term); err != nil {
+			return RequestVoteResponseBody{}, err
+		}
+	}
+
+	// The candidate's log must be at least as up to date: a later last term, or the same and at least as long
+	lastTerm := r.log[r.lastIndex()].Term
+	upToDate := body.LastLogTerm > lastTerm || (body.LastLogTerm == lastTerm && body.LastLogIndex >= r.lastIndex())
+
+	granted := body.Term == r.term && (r.votedFor == "" || r.votedFor == body.Candidate) && upToDate
+	if granted {
+		// The vote is stored before it's granted, so a restart can't hand out a second one in this term
+		if err := r.saveState(r.term, body.Candidate); err != nil {
+			return RequestVoteResponseBody{}, err
+		}
+		r.resetElectionTimer()
+	}
+
+	return RequestVoteResponseBody{Term: r.term, VoteGranted: granted}, nil
+}
+
+func (r *Raft) handleAppendEntries(body AppendEntriesRequestBody) (AppendEntriesResponseBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.Term < r.term {
+		return AppendEntriesResponseBody{Term: r.term}, nil
+	}
+
+	// A candidate that loses to the sender, or a follower hearing of a new term, follows it
+	if err := r.stepDown(body.Term); err != nil {
+		return AppendEntriesResponseBody{}, err
+	}
+	r.leader = body.Leader
+	r.resetElectionTimer()
+
+	// Missing entries before the new ones, retry from the end of the log
+	if body.PrevLogIndex > r.lastIndex() {
+		return AppendEntriesResponseBody{Term: r.term, Index: r.lastIndex() + 1}, nil
+	}
+
+	// The entry before the new ones disagrees, retry from the first entry of its term
+	if term := r.log[body.PrevLogIndex].Term; term != body.PrevLogTerm {
+		index := body.PrevLogIndex
+		for index > 1 && r.log[index-1].Term == term {
+			index--
+		}
+		return AppendEntriesResponseBody{Term: r.term, Index: index}, nil
+	}
+
+	// Only truncate at an actual conflict, a delayed request must not drop entries a later one appended
+	for i, entry := range body.Entries {
+		index := body.PrevLogIndex + 1 + int64(i)
+		if index <= r.lastIndex() && r.log[index].Term == entry.Term {
+			continue
+		}
+
+		// Stored before the reply, the leader counts this node toward committing them
+		if err := r.saveEntries(index, body.Entries[i:]); err != nil {
+			return AppendEntriesResponseBody{}, err
+		}
+		r.failWaiters(index)
+		break
+	}
+
+	match := body.PrevLogIndex + int64(len(body.Entries))
+	if commit := min(body.LeaderCommit, match); commit > r.commitIndex {
+		r.commitIndex = commit
+		r.commitCond.Broadcast()
+	}
+
+	return AppendEntriesResponseBody{Term: r.term, Success: true, Index: match}, nil
+}
+
+// Tells Submit calls waiting on truncated entries that they'll never commit
+func (r *Raft) failWaiters(from int64) {
+	for index, done := range r.waiters {
+		if index >= from {
+			done <- applied{term: -1}
+			delete(r.waiters, index)
+		}
+	}
+}
+
+// Applies committed entries in order as the commit index advances
+func (r *Raft) applyLoop(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for ctx.Err() == nil {
+		if r.lastApplied == r.commitIndex {
+			r.commitCond.Wait()
+			continue
+		}
+
+		r.lastApplied++
+		index := r.lastApplied
+		entry := r.log[index]
+
+		// Applied without the lock so handlers aren't blocked on a slow state machine, entries are only
+		// applied from here so the order holds
+		r.mu.Unlock()
+		result := r.sm.Apply(entry.Command)
+		r.mu.Lock()
+
+		if done, ok := r.waiters[index]; ok {
+			done <- applied{term: entry.Term, result: result}
+			delete(r.waiters, index)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package raft
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newRaft(store storage.Store) (*Raft, error) {
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init("n0", []string{"n0", "n1", "n2"})
+
+	r := NewRaft(n, NewKVStore(), RaftConfig{ElectionTimeout: time.Second, HeartbeatInterval: time.Second, RPCTimeout: time.Second})
+	return r, r.Open(store)
+}
+
+func TestPersistentState(t *testing.T) {
+	store := storage.NewMemory()
+	vote := func(r *Raft, request RequestVoteRequestBody) bool {
+		t.Helper()
+		resp, err := r.handleRequestVote(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.VoteGranted
+	}
+
+	r, err := newRaft(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vote(r, RequestVoteRequestBody{Term: 2, Candidate: "n1"}) {
+		t.Fatal("refused the first vote in term 2")
+	}
+	entries := []Entry{{Term: 1, Command: json.RawMessage(`"a"`)}, {Term: 2, Command: json.RawMessage(`"b"`)}}
+	if resp, err := r.handleAppendEntries(AppendEntriesRequestBody{Term: 2, Leader: "n1", Entries: entries}); err != nil || !resp.Success {
+		t.Fatalf("append_entries = %+v, %v", resp, err)
+	}
+	// A new leader overwrites the conflicting entry
+	if !vote(r, RequestVoteRequestBody{Term: 3, Candidate: "n2", LastLogIndex: 2, LastLogTerm: 2}) {
+		t.Fatal("refused the first vote in term 3")
+	}
+	replaced := []Entry{{Term: 3, Command: json.RawMessage(`"c"`)}}
+	if resp, err := r.handleAppendEntries(AppendEntriesRequestBody{Term: 3, Leader: "n2", PrevLogIndex: 1, PrevLogTerm: 1, Entries: replaced}); err != nil || !resp.Success {
+		t.Fatalf("append_entries = %+v, %v", resp, err)
+	}
+
+	// A restarted node keeps its term and log, and doesn't vote again in a term it voted in
+	r, err = newRaft(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if term, _, _ := r.State(); term != 3 {
+		t.Fatalf("term %d after reopening, want 3", term)
+	}
+	if last, _, _ := r.Indexes(); last != 2 || string(r.log[1].Command) != `"a"` || string(r.log[2].Command) != `"c"` {
+		t.Fatalf("log %v after reopening, want a then c", r.log)
+	}
+	if vote(r, RequestVoteRequestBody{Term: 3, Candidate: "n1", LastLogIndex: 2, LastLogTerm: 3}) {
+		t.Fatal("voted twice in term 3")
+	}
+
+	// Nothing is answered before the stored state is replayed
+	unopened := NewRaft(maelstrom.NewNode(), NewKVStore(), RaftConfig{ElectionTimeout: time.Second})
+	if _, err := unopened.handleRequestVote(RequestVoteRequestBody{Term: 1, Candidate: "n1"}); err == nil {
+		t.Fatal("voted before opening its storage")
+	}
+}
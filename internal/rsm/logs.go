@@ -0,0 +1,180 @@
+package rsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/paxos"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vr"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Logs
+
+Each consensus module already replicates a log of JSON commands and applies them to a state machine with
+Apply(command) any, the same interface in all three, so the logs here only adapt how each is run and who
+it says leads. Each keeps its own settings and handlers (see internal/raft, internal/paxos and
+internal/vr), and the same metrics it has serving lin-kv.
+
+  raft   the leader of the current term (default). The term, vote and log are kept in the node's storage
+  paxos  the multi-Paxos leader, or the node itself in single mode, where every node proposes its own
+         commands. The acceptor keeps its state in the node's storage
+  vr     the primary of the current view, none during a view change
+*/
+
+var ErrNotLeader = errors.New("rsm: not the leader")
+
+// A consensus log applying committed commands to a state machine on every node
+type Log interface {
+	// Runs the log until ctx is cancelled, called once the node is initialized
+	Run(ctx context.Context)
+
+	// Appends command to the log and blocks until it's applied, returning the state machine's result
+	// Returns ErrNotLeader if this node can't get commands committed
+	Submit(ctx context.Context, command any) (any, error)
+
+	// Returns the node commands should be submitted on, empty if it isn't known
+	Leader() string
+
+	// Returns how far the log has been applied on this node
+	Applied() int64
+}
+
+// The state machine every log applies commands to
+type logStateMachine interface {
+	Apply(command json.RawMessage) any
+}
+
+func newLog(node *maelstrom.Node, name string, sm logStateMachine) (Log, error) {
+	switch name {
+	case "raft":
+		cfg, err := raft.LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		return raftLog{node: node, raft: raft.NewRaft(node, sm, cfg)}, nil
+
+	case "paxos":
+		cfg, err := paxos.LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		return paxosLog{node: node, paxos: paxos.New(node, sm, cfg)}, nil
+
+	case "vr":
+		cfg, err := vr.LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		return vrLog{vr.NewReplica(node, sm, cfg)}, nil
+	}
+	return nil, fmt.Errorf("unknown log %q", name)
+}
+
+type raftLog struct {
+	node *maelstrom.Node
+	raft *raft.Raft
+}
+
+// Opens the stored term, vote and log before running, storage is per node so it can't be opened any earlier
+func (l raftLog) Run(ctx context.Context) {
+	store, err := storage.Of(l.node)
+	if err == nil {
+		err = l.raft.Open(store)
+	}
+	if err != nil {
+		logger.Error("raft storage failed to open", "error", err)
+		return
+	}
+
+	l.raft.Run(ctx)
+}
+
+func (l raftLog) Submit(ctx context.Context, command any) (any, error) {
+	result, err := l.raft.Submit(ctx, command)
+	if errors.Is(err, raft.ErrNotLeader) {
+		return nil, ErrNotLeader
+	}
+	return result, err
+}
+
+func (l raftLog) Leader() string {
+	_, _, leader := l.raft.State()
+	return leader
+}
+
+func (l raftLog) Applied() int64 {
+	_, _, applied := l.raft.Indexes()
+	return applied
+}
+
+type paxosLog struct {
+	node  *maelstrom.Node
+	paxos *paxos.Paxos
+}
+
+// Opens the acceptor before running, storage is per node so it can't be opened any earlier
+func (l paxosLog) Run(ctx context.Context) {
+	store, err := storage.Of(l.node)
+	if err == nil {
+		err = l.paxos.Acceptor().Open(store)
+	}
+	if err != nil {
+		logger.Error("paxos acceptor failed to open", "error", err)
+		return
+	}
+
+	l.paxos.Run(ctx)
+}
+
+func (l paxosLog) Submit(ctx context.Context, command any) (any, error) {
+	result, err := l.paxos.Submit(ctx, command)
+	if errors.Is(err, paxos.ErrNotLeader) {
+		return nil, ErrNotLeader
+	}
+	return result, err
+}
+
+func (l paxosLog) Leader() string {
+	_, _, leader, _ := l.paxos.State()
+	return leader
+}
+
+func (l paxosLog) Applied() int64 {
+	_, _, _, applied := l.paxos.State()
+	return applied
+}
+
+type vrLog struct {
+	replica *vr.Replica
+}
+
+func (l vrLog) Run(ctx context.Context) {
+	l.replica.Run(ctx)
+}
+
+func (l vrLog) Submit(ctx context.Context, command any) (any, error) {
+	result, err := l.replica.Submit(ctx, command)
+	if errors.Is(err, vr.ErrNotPrimary) {
+		return nil, ErrNotLeader
+	}
+	return result, err
+}
+
+func (l vrLog) Leader() string {
+	_, status, primary := l.replica.State()
+	if status != vr.Normal {
+		return ""
+	}
+	return primary
+}
+
+func (l vrLog) Applied() int64 {
+	_, _, applied := l.replica.Indexes()
+	return applied
+}
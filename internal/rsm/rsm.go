@@ -0,0 +1,240 @@
+package rsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Replicated state machines
+
+A service made strongly consistent by running every operation through a consensus log: Raft, Paxos or
+Viewstamped Replication, picked with RSM_LOG (see logs.go). The service only writes a StateMachine, whose
+Apply is handed each committed command in log order on every node, so every node goes through the same
+states; the log, leader forwarding and snapshots are the same for every service.
+
+Execute submits a command and returns what Apply returned for it on this node. A node that isn't the
+leader forwards the command to the leader it knows of with rsm_submit, once: a leader that has changed
+again by then fails it as temporarily unavailable, and the client retries. Reads go through the log too,
+like the lin-kv workloads' (see internal/raft/kv.go). Apply has to be deterministic, anything that differs
+between nodes, like the time, belongs in the command.
+
+The state machine's Snapshot and Restore are the node's snapshot part under the service's name (see
+internal/snapshot). Restoring doesn't touch the state directly: the snapshot is submitted as a command of
+its own, so every node restores it at the same point in the log. The log itself is never compacted.
+*/
+
+var logger = logging.For("rsm")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// RSM_LOG, the consensus log commands are replicated with: raft (default), paxos or vr
+	Log string `env:"RSM_LOG" oneof:"raft|paxos|vr"`
+
+	// RSM_TIMEOUT_MS, how long a command may take to be applied, forwarding included (default 1000)
+	Timeout time.Duration `env:"RSM_TIMEOUT_MS" min:"1"`
+}
+
+// A service's state, replicated by applying the same commands in the same order on every node
+type StateMachine interface {
+	// Applies a committed command, returning its result for the node that submitted it
+	// Must be deterministic: an error is part of the result and every node returns the same one
+	Apply(command json.RawMessage) (any, error)
+
+	// Returns the whole state, to be marshalled to JSON
+	Snapshot() (any, error)
+
+	// Installs a state Snapshot returned, the same way on every node
+	Restore(data json.RawMessage) error
+}
+
+// Submit RPC, a node forwarding a command to the leader
+type SubmitRequestBody struct {
+	Type    string `json:"type"`
+	Command Entry  `json:"command"`
+}
+
+type SubmitResponseBody struct {
+	Type   string          `json:"type"`
+	Result json.RawMessage `json:"result"`
+}
+
+type StatusResponseBody struct {
+	Log     string `json:"log"`
+	Leader  string `json:"leader"`
+	Applied int64  `json:"applied"`
+}
+
+// An entry of the log, a service's command or a snapshot to restore
+type Entry struct {
+	Command json.RawMessage `json:"command,omitempty"`
+	Restore json.RawMessage `json:"restore,omitempty"`
+}
+
+// What applying an entry returned
+type result struct {
+	value any
+	err   error
+}
+
+// Adapts a StateMachine to the logs', which return a single value
+type machine struct {
+	sm StateMachine
+}
+
+func (m machine) Apply(data json.RawMessage) any {
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return result{err: maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())}
+	}
+
+	if entry.Restore != nil {
+		return result{err: m.sm.Restore(entry.Restore)}
+	}
+
+	value, err := m.sm.Apply(entry.Command)
+	return result{value: value, err: err}
+}
+
+type RSM struct {
+	node   *maelstrom.Node
+	env    *env.Env
+	log    Log
+	config Config
+}
+
+// Replicates sm on node with the log RSM_LOG picks, registering the log's handlers, rsm_submit and name's
+// snapshot part, the service then starts the log with Run
+func New(node *maelstrom.Node, name string, sm StateMachine) (*RSM, error) {
+	cfg := Config{Log: "raft", Timeout: time.Second}
+	if err := config.Load("rsm", &cfg); err != nil {
+		return nil, err
+	}
+
+	log, err := newLog(node, cfg.Log, machine{sm: sm})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RSM{node: node, env: env.Of(node), log: log, config: cfg}
+
+	handler.Handle(node, "rsm_submit", func(msg maelstrom.Message, body SubmitRequestBody) (SubmitResponseBody, error) {
+		ctx, cancel := r.env.Clock.WithTimeout(context.Background(), r.config.Timeout)
+		defer cancel()
+
+		// Forwarded commands aren't forwarded again
+		value, err := r.submit(ctx, body.Command)
+		if errors.Is(err, ErrNotLeader) {
+			return SubmitResponseBody{}, r.notLeader()
+		} else if err != nil {
+			return SubmitResponseBody{}, err
+		}
+
+		data, err := json.Marshal(value)
+		return SubmitResponseBody{Result: data}, err
+	})
+
+	admin.Of(node).Register("rsm", admin.Module{Status: func() any { return r.Status() }})
+
+	snapshot.Of(node).Provide(name, sm.Snapshot, func(data json.RawMessage) error {
+		_, err := r.execute(context.Background(), Entry{Restore: data})
+		return err
+	})
+
+	return r, nil
+}
+
+// Runs the log until ctx is cancelled, must be called once the node is initialized since the log needs the
+// cluster's node IDs
+func (r *RSM) Run(ctx context.Context) {
+	logger.Info("rsm started", "log", r.config.Log)
+	r.log.Run(ctx)
+}
+
+// Returns the log in use, the leader this node knows of and the index of the last entry it applied
+func (r *RSM) Status() StatusResponseBody {
+	return StatusResponseBody{
+		Log:     r.config.Log,
+		Leader:  r.log.Leader(),
+		Applied: r.log.Applied(),
+	}
+}
+
+// Runs command through the log and returns what Apply returned for it, decoded into R if it was forwarded
+func Execute[R any](ctx context.Context, r *RSM, command any) (R, error) {
+	var value R
+
+	data, err := json.Marshal(command)
+	if err != nil {
+		return value, err
+	}
+
+	result, err := r.execute(ctx, Entry{Command: data})
+	if err != nil {
+		return value, err
+	}
+
+	// Applied here the result is what Apply returned, applied on the leader it's that result's JSON
+	switch result := result.(type) {
+	case nil:
+		return value, nil
+	case R:
+		return result, nil
+	case json.RawMessage:
+		err := json.Unmarshal(result, &value)
+		return value, err
+	}
+	return value, fmt.Errorf("rsm: result %T isn't a %T", result, value)
+}
+
+// Submits entry on this node, or forwards it to the leader if this node isn't it
+func (r *RSM) execute(ctx context.Context, entry Entry) (any, error) {
+	ctx, cancel := r.env.Clock.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	value, err := r.submit(ctx, entry)
+	if !errors.Is(err, ErrNotLeader) {
+		return value, err
+	}
+
+	leader := r.log.Leader()
+	if leader == "" || leader == r.node.ID() {
+		return nil, r.notLeader()
+	}
+
+	resp, err := r.node.SyncRPC(ctx, leader, SubmitRequestBody{Type: "rsm_submit", Command: entry})
+	if err != nil {
+		return nil, err
+	}
+
+	var body SubmitResponseBody
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		return nil, err
+	}
+	return body.Result, nil
+}
+
+func (r *RSM) submit(ctx context.Context, entry Entry) (any, error) {
+	applied, err := r.log.Submit(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	result := applied.(result)
+	return result.value, result.err
+}
+
+func (r *RSM) notLeader() error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("not the leader, try %q", r.log.Leader()))
+}
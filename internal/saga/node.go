@@ -0,0 +1,115 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Sagas
+
+Goal: run multi-step workflows that change several keys in lin-kv, an order that bumps a counter, writes
+a key and appends to a list say, so that either every step happens or, if one of them fails, the ones
+before it are undone, without locking anything across the steps (see saga.go).
+
+A saga is sent to any node as a list of steps (see step.go) and answered once it has committed or been
+compensated. The saga log is kept in the node's storage, set STORAGE_BACKEND=file for sagas to be finished
+after a restart.
+*/
+
+var logger = logging.For("saga")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// SAGA_STEP_TIMEOUT_MS, how long a step can take before the saga fails and is compensated (default 1000)
+	StepTimeout time.Duration `env:"SAGA_STEP_TIMEOUT_MS" min:"1"`
+
+	RetryDelay time.Duration
+}
+
+type SagaRequestBody struct {
+	Type  string `json:"type"`
+	Steps []Step `json:"steps"`
+}
+
+func (b SagaRequestBody) Validate() error {
+	if len(b.Steps) == 0 {
+		return errors.New("saga without steps")
+	}
+	for _, step := range b.Steps {
+		if err := step.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type SagaResponseBody struct {
+	Type string `json:"type"`
+	Result
+}
+
+type ReadRequestBody struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+	List  []int  `json:"list"`
+}
+
+// Registers the saga handlers on n, the saga log opens once n is initialized
+func Register(n *maelstrom.Node) error {
+	ctx := context.Background()
+
+	cfg := Config{
+		StepTimeout: time.Second,
+		RetryDelay:  100 * time.Millisecond,
+	}
+	if err := config.Load("saga", &cfg); err != nil {
+		return err
+	}
+
+	kv := maelstrom.NewLinKV(n)
+	orchestrator := NewOrchestrator(n, kv, cfg)
+
+	// Storage is per node, so the log can only be opened once the node's ID is known
+	n.Handle("init", func(msg maelstrom.Message) error {
+		store, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+		return orchestrator.Open(store)
+	})
+
+	admin.Of(n).Register("saga", admin.Module{Status: func() any {
+		return map[string]any{"running": orchestrator.Running()}
+	}})
+
+	handler.Handle(n, "saga", func(msg maelstrom.Message, body SagaRequestBody) (SagaResponseBody, error) {
+		result, err := orchestrator.Run(body.Steps)
+		return SagaResponseBody{Result: result}, err
+	})
+
+	// The values without the marks, for checking what sagas left behind
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		cell, err := kvutil.Read(ctx, kv, body.Key, Cell{})
+		if err != nil {
+			return ReadResponseBody{}, err
+		}
+		return ReadResponseBody{Value: cell.Value, List: cell.List}, nil
+	})
+
+	return nil
+}
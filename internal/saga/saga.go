@@ -0,0 +1,370 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Orchestration
+
+The node a saga is sent to runs it: it applies the steps one after another, and if one fails it
+compensates the steps before it, and the failed one, in reverse order. A step that doesn't succeed within
+SAGA_STEP_TIMEOUT_MS fails the saga too, though it may still have been applied, which its compensation
+takes care of (see step.go). Compensations can't fail, so each is retried until lin-kv takes it.
+
+No locks are held between steps, so other sagas can see a saga's early steps before its later ones run,
+and before they're compensated: sagas are atomic in the end, not isolated.
+
+Every saga is recorded in a durable saga log in the node's storage: its steps when it starts, each step
+once it's applied, the step it failed at when it starts compensating, each compensation and how it ended.
+A node that restarts replays the log and finishes the sagas it was running. One that had applied every
+step only needs its end recorded, any other is compensated from the step it had got to, as its outcome
+isn't known. Once a saga is over, and before its end is recorded, its marks are removed from the keys
+its steps touched, so a restart removes whatever a crash left behind. Saga IDs carry the number of times the log has been opened so they aren't reused after a
+restart, and once no saga is running the log is cut back to that count.
+*/
+
+type Status string
+
+const (
+	Committed   Status = "committed"
+	Compensated Status = "compensated"
+)
+
+// How a saga ended
+type Result struct {
+	ID         string `json:"id"`
+	Status     Status `json:"status"`
+	FailedStep *int   `json:"failed_step,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// A saga log record
+type record struct {
+	Epoch  int64  `json:"epoch,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Event  string `json:"event,omitempty"` // begin, applied, abort, compensated or end
+	Steps  []Step `json:"steps,omitempty"` // begin
+	Step   int    `json:"step,omitempty"`  // applied, abort, compensated
+	Error  string `json:"error,omitempty"` // abort
+	Status Status `json:"status,omitempty"`
+}
+
+// A saga the log shows hadn't ended
+type unfinished struct {
+	steps   []Step
+	applied int  // steps applied, in order
+	aborted bool // compensating, from undo down
+	undo    int
+	err     string
+}
+
+type Orchestrator struct {
+	kv     kvutil.KV
+	node   *maelstrom.Node
+	env    *env.Env
+	cfg    Config
+	nextID atomic.Int64
+
+	mu      sync.Mutex
+	log     storage.Log // nil until opened
+	epoch   int64       // times the log has been opened, part of every saga ID
+	running map[string]bool
+
+	committed     *metrics.Counter
+	compensated   *metrics.Counter
+	compensations *metrics.Counter
+	recovered     *metrics.Counter
+}
+
+func NewOrchestrator(n *maelstrom.Node, kv kvutil.KV, cfg Config) *Orchestrator {
+	registry := metrics.Of(n)
+	return &Orchestrator{
+		kv:            kv,
+		node:          n,
+		env:           env.Of(n),
+		cfg:           cfg,
+		running:       make(map[string]bool),
+		committed:     registry.Counter("saga.committed"),
+		compensated:   registry.Counter("saga.compensated"),
+		compensations: registry.Counter("saga.compensations"),
+		recovered:     registry.Counter("saga.recovered"),
+	}
+}
+
+// Opens the saga log in store and finishes, in the background, the sagas it shows were still running
+func (o *Orchestrator) Open(store storage.Store) error {
+	log, data, err := store.OpenLog("saga")
+	if err != nil {
+		return err
+	}
+
+	records := make([]record, len(data))
+	for i := range data {
+		if err := json.Unmarshal(data[i], &records[i]); err != nil {
+			log.Close()
+			return fmt.Errorf("saga log record %d: %w", i, err)
+		}
+	}
+	epoch, sagas := replay(records)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.log = log
+	o.epoch = epoch + 1
+	if err := o.appendLocked(record{Epoch: o.epoch}); err != nil {
+		return err
+	}
+
+	for id, saga := range sagas {
+		logger.Info("resuming saga", "id", id, "applied", saga.applied, "aborted", saga.aborted)
+		o.running[id] = true
+		o.recovered.Inc()
+		go o.resume(id, saga)
+	}
+
+	return nil
+}
+
+// Returns the latest open count in records and the sagas that hadn't ended
+func replay(records []record) (int64, map[string]*unfinished) {
+	epoch := int64(0)
+	sagas := make(map[string]*unfinished)
+
+	for _, r := range records {
+		if r.Epoch > 0 {
+			epoch = max(epoch, r.Epoch)
+			continue
+		}
+
+		saga := sagas[r.ID]
+		if saga == nil && r.Event != "begin" {
+			continue
+		}
+
+		switch r.Event {
+		case "begin":
+			sagas[r.ID] = &unfinished{steps: r.Steps}
+		case "applied":
+			saga.applied = r.Step + 1
+		case "abort":
+			saga.aborted, saga.undo, saga.err = true, r.Step, r.Error
+		case "compensated":
+			saga.undo = r.Step - 1
+		case "end":
+			delete(sagas, r.ID)
+		}
+	}
+
+	return epoch, sagas
+}
+
+// Runs a saga's steps, compensating them if one fails, and returns how it ended
+// Only fails if the saga couldn't be started, it then had no effects
+func (o *Orchestrator) Run(steps []Step) (Result, error) {
+	o.mu.Lock()
+	id := fmt.Sprintf("%s-%d.%d", o.node.ID(), o.epoch, o.nextID.Add(1))
+	err := o.appendLocked(record{ID: id, Event: "begin", Steps: steps})
+	if err == nil {
+		o.running[id] = true
+	}
+	o.mu.Unlock()
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	for i, step := range steps {
+		if err := o.apply(id, i, step); err != nil {
+			logger.Info("saga step failed, compensating", "id", id, "step", i, "error", err)
+			o.record(record{ID: id, Event: "abort", Step: i, Error: err.Error()})
+			o.compensate(id, steps, i)
+
+			return Result{ID: id, Status: Compensated, FailedStep: &i, Error: err.Error()}, nil
+		}
+		o.record(record{ID: id, Event: "applied", Step: i})
+	}
+
+	o.end(id, steps, Committed)
+	return Result{ID: id, Status: Committed}, nil
+}
+
+// Finishes a saga found unfinished in the log
+func (o *Orchestrator) resume(id string, saga *unfinished) {
+	if !saga.aborted && saga.applied == len(saga.steps) {
+		o.end(id, saga.steps, Committed)
+		return
+	}
+
+	undo := saga.undo
+	if !saga.aborted {
+		// The step after the last one applied may or may not have been
+		undo = saga.applied
+		o.record(record{ID: id, Event: "abort", Step: undo, Error: "orchestrator restarted"})
+	}
+	o.compensate(id, saga.steps, undo)
+}
+
+// Applies a step, retrying while its outcome isn't known until the step timeout
+func (o *Orchestrator) apply(id string, i int, step Step) error {
+	ctx, cancel := o.env.Clock.WithTimeout(context.Background(), o.cfg.StepTimeout)
+	defer cancel()
+
+	stepID := fmt.Sprintf("%s/%d", id, i)
+	for {
+		_, err := kvutil.Update(ctx, o.kv, step.Key, Cell{}, func(cell Cell) (Cell, error) {
+			return Apply(cell, stepID, step)
+		})
+
+		var failed *StepFailedError
+		if err == nil || errors.As(err, &failed) || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Warn("saga step failed, retrying", "id", id, "step", i, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-o.env.Clock.After(o.cfg.RetryDelay):
+		}
+	}
+}
+
+// Compensates steps from down to the first, then records the saga compensated
+func (o *Orchestrator) compensate(id string, steps []Step, from int) {
+	for i := from; i >= 0; i-- {
+		stepID := fmt.Sprintf("%s/%d", id, i)
+
+		for {
+			ctx, cancel := o.env.Clock.WithTimeout(context.Background(), o.cfg.StepTimeout)
+			_, err := kvutil.Update(ctx, o.kv, steps[i].Key, Cell{}, func(cell Cell) (Cell, error) {
+				return Compensate(cell, stepID, steps[i])
+			})
+			cancel()
+
+			if err == nil {
+				break
+			}
+
+			logger.Warn("saga compensation failed, retrying", "id", id, "step", i, "error", err)
+			<-o.env.Clock.After(o.cfg.RetryDelay)
+		}
+
+		o.compensations.Inc()
+		o.record(record{ID: id, Event: "compensated", Step: i})
+	}
+
+	o.end(id, steps, Compensated)
+}
+
+// Removes a saga's marks from its keys, then records how it ended and cuts the log back once no saga is
+// running
+func (o *Orchestrator) end(id string, steps []Step, status Status) {
+	o.forget(id, steps)
+
+	if status == Committed {
+		o.committed.Inc()
+	} else {
+		o.compensated.Inc()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.running, id)
+	if err := o.appendLocked(record{ID: id, Event: "end", Status: status}); err != nil {
+		logger.Error("saga log append failed", "id", id, "error", err)
+		return
+	}
+	o.compactLocked()
+}
+
+// Removes a finished saga's marks from each key its steps touched, retrying until lin-kv takes it
+func (o *Orchestrator) forget(id string, steps []Step) {
+	keys := make([]string, len(steps))
+	for i, step := range steps {
+		keys[i] = step.Key
+	}
+	slices.Sort(keys)
+
+	for _, key := range slices.Compact(keys) {
+		for {
+			ctx, cancel := o.env.Clock.WithTimeout(context.Background(), o.cfg.StepTimeout)
+			_, err := kvutil.Update(ctx, o.kv, key, Cell{}, func(cell Cell) (Cell, error) {
+				return Forget(cell, id)
+			})
+			cancel()
+
+			if err == nil {
+				break
+			}
+
+			logger.Warn("saga marks not removed, retrying", "id", id, "key", key, "error", err)
+			<-o.env.Clock.After(o.cfg.RetryDelay)
+		}
+	}
+}
+
+// Appends r to the saga log
+// A step's outcome is already in lin-kv by the time it's recorded, so a record that can't be appended is
+// only logged: a restart then redoes or compensates the step again, which changes nothing
+func (o *Orchestrator) record(r record) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.appendLocked(r); err != nil {
+		logger.Error("saga log append failed", "id", r.ID, "event", r.Event, "error", err)
+	}
+}
+
+// Returns the number of sagas running
+func (o *Orchestrator) Running() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.running)
+}
+
+// The following must be called with o.mu held
+
+// Appends r to the saga log, if it's open
+func (o *Orchestrator) appendLocked(r record) error {
+	if o.log == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return o.log.Append(data)
+}
+
+// Cuts the saga log back to the open count once no saga is running
+// The count is appended again before the records ahead of it are dropped, so a crash part way loses nothing
+func (o *Orchestrator) compactLocked() {
+	if o.log == nil || len(o.running) > 0 {
+		return
+	}
+
+	offset := o.log.Size()
+	if err := o.appendLocked(record{Epoch: o.epoch}); err != nil {
+		logger.Error("saga log append failed", "error", err)
+		return
+	}
+	if err := o.log.Discard(offset); err != nil {
+		logger.Error("saga log discard failed", "error", err)
+	}
+}
@@ -0,0 +1,218 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestApplyAndCompensate(t *testing.T) {
+	from := 5
+	tests := []struct {
+		name  string
+		cell  Cell
+		step  Step
+		after Cell // the value and list once applied
+	}{
+		{"write", Cell{Value: 5}, Step{Op: "write", Key: "k", Value: 7, From: &from}, Cell{Value: 7}},
+		{"add", Cell{Value: 5}, Step{Op: "add", Key: "k", Delta: -2}, Cell{Value: 3}},
+		{"append", Cell{List: []int{1, 2}}, Step{Op: "append", Key: "k", Msg: 3}, Cell{List: []int{1, 2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied, err := Apply(tt.cell, "s/0", tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied.Value != tt.after.Value || !slices.Equal(applied.List, tt.after.List) {
+				t.Fatalf("applied %+v, want %+v", applied, tt.after)
+			}
+			if _, err := Apply(applied, "s/0", tt.step); !errors.Is(err, kvutil.ErrNoChange) {
+				t.Fatalf("applying twice returned %v", err)
+			}
+
+			compensated, err := Compensate(applied, "s/0", tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compensated.Value != tt.cell.Value || !slices.Equal(compensated.List, tt.cell.List) {
+				t.Fatalf("compensated %+v, want %+v", compensated, tt.cell)
+			}
+			if _, err := Compensate(compensated, "s/0", tt.step); !errors.Is(err, kvutil.ErrNoChange) {
+				t.Fatalf("compensating twice returned %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyFails(t *testing.T) {
+	from := 4
+	for _, step := range []Step{
+		{Op: "write", Key: "k", Value: 7, From: &from},
+		{Op: "add", Key: "k", Delta: -6},
+	} {
+		var failed *StepFailedError
+		if _, err := Apply(Cell{Value: 5}, "s/0", step); !errors.As(err, &failed) {
+			t.Errorf("%s returned %v, want a failed step", step.Op, err)
+		}
+	}
+}
+
+// A compensation that gets to a key before its step makes the step a no-op
+func TestCompensateBeforeApply(t *testing.T) {
+	step := Step{Op: "add", Key: "k", Delta: 3}
+
+	cell, err := Compensate(Cell{Value: 1}, "s/0", step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(cell, "s/0", step); !errors.Is(err, kvutil.ErrNoChange) {
+		t.Fatalf("late apply returned %v", err)
+	}
+	if cell.Value != 1 {
+		t.Fatalf("value %d, want 1", cell.Value)
+	}
+}
+
+// A write undone after the key has been written again leaves the later value
+func TestCompensateOverwrittenWrite(t *testing.T) {
+	step := Step{Op: "write", Key: "k", Value: 7}
+
+	cell, err := Apply(Cell{Value: 5}, "s/0", step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell, err = Apply(cell, "t/0", Step{Op: "write", Key: "k", Value: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell, err = Compensate(cell, "s/0", step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != 9 {
+		t.Fatalf("value %d, want 9", cell.Value)
+	}
+}
+
+// Forgetting a saga leaves other sagas' marks, and a request that expected the cell before it changes nothing
+func TestForget(t *testing.T) {
+	before := Cell{Value: 1, Marks: map[string]Mark{"s1/0": {}}}
+	cell, err := Apply(before, "s/1", Step{Op: "add", Key: "k", Delta: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell, err = Compensate(cell, "s/1", Step{Op: "add", Key: "k", Delta: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cell, err = Forget(cell, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cell.Marks["s1/0"]; !ok || len(cell.Marks) != 1 {
+		t.Fatalf("marks %v, want only s1/0's", cell.Marks)
+	}
+	if cell.Value != before.Value || cell.Version == before.Version {
+		t.Fatalf("cell %+v, want the value it started with at a later version", cell)
+	}
+	if _, err := Forget(cell, "s"); !errors.Is(err, kvutil.ErrNoChange) {
+		t.Fatalf("forgetting twice returned %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	steps := []Step{{Op: "add", Key: "a", Delta: 1}, {Op: "append", Key: "b", Msg: 2}, {Op: "write", Key: "c", Value: 3}}
+
+	epoch, sagas := replay([]record{
+		{Epoch: 1},
+		{ID: "done", Event: "begin", Steps: steps},
+		{ID: "done", Event: "applied", Step: 0},
+		{ID: "done", Event: "end", Status: Committed},
+		{ID: "running", Event: "begin", Steps: steps},
+		{ID: "running", Event: "applied", Step: 0},
+		{ID: "running", Event: "applied", Step: 1},
+		{ID: "undoing", Event: "begin", Steps: steps},
+		{ID: "undoing", Event: "applied", Step: 0},
+		{ID: "undoing", Event: "abort", Step: 1, Error: "failed"},
+		{ID: "undoing", Event: "compensated", Step: 1},
+		{Epoch: 2},
+	})
+
+	if epoch != 2 {
+		t.Errorf("epoch %d, want 2", epoch)
+	}
+	if len(sagas) != 2 {
+		t.Fatalf("%d unfinished sagas, want 2", len(sagas))
+	}
+	if s := sagas["running"]; s.aborted || s.applied != 2 {
+		t.Errorf("running saga replayed as %+v", s)
+	}
+	if s := sagas["undoing"]; !s.aborted || s.undo != 0 || s.err != "failed" {
+		t.Errorf("compensating saga replayed as %+v", s)
+	}
+}
+
+// A saga that was running when its node stopped is compensated, including the step it was on, which here
+// had been applied without being recorded
+func TestOpenResumes(t *testing.T) {
+	kv := fake.NewKV()
+	kv.Write(context.Background(), "stock", json.RawMessage(`{"value":2,"marks":{"n0-1.1/0":{}}}`))
+	kv.Write(context.Background(), "orders", json.RawMessage(`{"value":0,"list":[7],"marks":{"n0-1.1/1":{}}}`))
+
+	store := storage.NewMemory()
+	log, _, err := store.OpenLog("saga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{
+		`{"epoch":1}`,
+		`{"id":"n0-1.1","event":"begin","steps":[{"op":"add","key":"stock","delta":2},{"op":"append","key":"orders","msg":7},{"op":"write","key":"price","value":3}]}`,
+		`{"id":"n0-1.1","event":"applied"}`,
+	} {
+		if err := log.Append([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := NewOrchestrator(maelstrom.NewNode(), kv, Config{StepTimeout: time.Second, RetryDelay: time.Millisecond})
+	if err := o.Open(store); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for o.Running() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("saga still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for key, want := range map[string]Cell{"stock": {}, "orders": {}} {
+		cell, err := kvutil.Read(context.Background(), kv, key, Cell{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cell.Value != want.Value || !slices.Equal(cell.List, want.List) || len(cell.Marks) != 0 {
+			t.Errorf("%s is %+v after resuming, want it undone without marks", key, cell)
+		}
+	}
+
+	_, records, err := store.OpenLog("saga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || string(records[0]) != `{"epoch":2}` {
+		t.Errorf("log %q once the saga ended, want just the open count", records)
+	}
+}
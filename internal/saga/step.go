@@ -0,0 +1,163 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kvutil"
+)
+
+/*
+Steps
+
+Each step changes one key in lin-kv, and has a compensation that undoes it:
+  - write sets the key to value, and fails if from is given and the key holds something else. Undone by
+    putting back the value it replaced, unless the key has been written again since
+  - add adds delta to a counter, and fails if that would take it below zero. Undone by taking delta off
+    again, which may take the counter below zero
+  - append adds msg to the end of a list, it never fails. Undone by removing msg from the list
+
+The keys are the saga workload's own, the steps are shaped after the other workloads' operations (write
+like a txn write, add like a counter add, append like a kafka send) but don't reach those workloads. Each
+of them runs as its own Maelstrom test, with its own nodes and KV services, so there's no kafka log, txn
+store or counter for a saga to change, and they couldn't be compensated if there were: a kafka message may
+have been polled as soon as it was sent, and the counter only grows.
+
+A step can reach lin-kv more than once: the orchestrator retries one whose outcome it doesn't know, and a
+request sent before a crash can land after the orchestrator has moved on. So every key is kept as a Cell,
+its value with a mark for each step that has touched it, keyed by saga and step. Applying a step that's
+already marked changes nothing, and compensating a step marks it compensated whether it was applied or
+not, so an apply that lands after its compensation changes nothing either.
+
+Once a saga is over its marks are removed from the keys it touched (see saga.go). A request for one of
+its steps that lands after that still changes nothing: every change bumps the cell's version, so the cell
+never again holds the value the request's compare-and-set expects.
+*/
+
+// Returned when a step can't be applied to the key as it is, which fails the saga
+type StepFailedError struct {
+	Reason string
+}
+
+func (e *StepFailedError) Error() string {
+	return e.Reason
+}
+
+type Step struct {
+	Op    string `json:"op"` // write, add or append
+	Key   string `json:"key"`
+	Value int    `json:"value,omitempty"` // write
+	From  *int   `json:"from,omitempty"`  // write, if set the key has to hold it
+	Delta int    `json:"delta,omitempty"` // add
+	Msg   int    `json:"msg,omitempty"`   // append
+}
+
+func (s Step) Validate() error {
+	if s.Key == "" {
+		return errors.New("step without a key")
+	}
+	if !slices.Contains([]string{"write", "add", "append"}, s.Op) {
+		return fmt.Errorf("unknown step op %q", s.Op)
+	}
+	return nil
+}
+
+// A key's state in lin-kv
+type Cell struct {
+	Value   int             `json:"value"`          // set by write, changed by add
+	List    []int           `json:"list,omitempty"` // appended to by append
+	Marks   map[string]Mark `json:"marks,omitempty"`
+	Version int64           `json:"version,omitempty"` // bumped on every change
+}
+
+// A step that has touched a cell
+type Mark struct {
+	Compensated bool `json:"compensated,omitempty"`
+	Prev        int  `json:"prev,omitempty"` // write, the value it replaced
+}
+
+// Returns cell with step applied, kvutil.ErrNoChange if step has already been applied or compensated
+func Apply(cell Cell, stepID string, step Step) (Cell, error) {
+	if _, ok := cell.Marks[stepID]; ok {
+		return cell, kvutil.ErrNoChange
+	}
+
+	cell = cell.clone()
+	mark := Mark{}
+
+	switch step.Op {
+	case "write":
+		if step.From != nil && cell.Value != *step.From {
+			return cell, &StepFailedError{Reason: fmt.Sprintf("%s holds %d, not %d", step.Key, cell.Value, *step.From)}
+		}
+		mark.Prev = cell.Value
+		cell.Value = step.Value
+	case "add":
+		if cell.Value+step.Delta < 0 {
+			return cell, &StepFailedError{Reason: fmt.Sprintf("%s is %d, can't add %d", step.Key, cell.Value, step.Delta)}
+		}
+		cell.Value += step.Delta
+	case "append":
+		cell.List = append(cell.List, step.Msg)
+	}
+
+	cell.Marks[stepID] = mark
+	cell.Version++
+	return cell, nil
+}
+
+// Returns cell with step undone if it was applied, and marked compensated either way
+// Returns kvutil.ErrNoChange if step has already been compensated
+func Compensate(cell Cell, stepID string, step Step) (Cell, error) {
+	mark, applied := cell.Marks[stepID]
+	if mark.Compensated {
+		return cell, kvutil.ErrNoChange
+	}
+
+	cell = cell.clone()
+
+	if applied {
+		switch step.Op {
+		case "write":
+			if cell.Value == step.Value {
+				cell.Value = mark.Prev
+			}
+		case "add":
+			cell.Value -= step.Delta
+		case "append":
+			if i := slices.Index(cell.List, step.Msg); i >= 0 {
+				cell.List = slices.Delete(cell.List, i, i+1)
+			}
+		}
+	}
+
+	cell.Marks[stepID] = Mark{Compensated: true}
+	cell.Version++
+	return cell, nil
+}
+
+// Returns cell without the marks of saga id's steps, kvutil.ErrNoChange if it has none
+func Forget(cell Cell, id string) (Cell, error) {
+	marks := len(cell.Marks)
+	cell = cell.clone()
+	maps.DeleteFunc(cell.Marks, func(stepID string, _ Mark) bool { return strings.HasPrefix(stepID, id+"/") })
+	if len(cell.Marks) == marks {
+		return cell, kvutil.ErrNoChange
+	}
+
+	cell.Version++
+	return cell, nil
+}
+
+// Copies the cell, so the copy can change without touching the value read from lin-kv
+func (c Cell) clone() Cell {
+	c.List = slices.Clone(c.List)
+	c.Marks = maps.Clone(c.Marks)
+	if c.Marks == nil {
+		c.Marks = make(map[string]Mark)
+	}
+	return c
+}
@@ -0,0 +1,320 @@
+package seqkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Sequentially consistent KV
+
+A stand-in for Maelstrom's seq-kv service run by the cluster's own nodes. Every key has a primary, picked by
+hashing it over the node IDs, that orders all writes to it: writes and compare-and-swaps on other nodes are
+sent to the key's primary, which numbers each new value with the key's next version and copies it to every
+other node in the background.
+
+Reads are served from the local copy when it's at least as new as anything this node has already seen of
+the key, and from the primary otherwise, so a node's reads never go back in time and always see its own
+writes. They can still miss other nodes' recent writes, which sequential consistency allows: all nodes see
+each key's writes in the same order, just not necessarily right away.
+
+That's the cost of it: every write is a round trip to the primary, and while a primary is unreachable its
+keys can't be written, or read by nodes that are behind.
+*/
+
+var logger = logging.For("seqkv")
+
+const replicateRetryInterval = 100 * time.Millisecond
+
+type entry struct {
+	value   json.RawMessage
+	version int64
+}
+
+// Read RPC, answered by the key's primary
+type ReadRequestBody struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type    string          `json:"type"`
+	Value   json.RawMessage `json:"value"`
+	Version int64           `json:"version"`
+}
+
+// Write and compare-and-swap RPC, answered by the key's primary
+type UpdateRequestBody struct {
+	Type              string          `json:"type"`
+	Key               string          `json:"key"`
+	From              json.RawMessage `json:"from,omitempty"` // only compared if Compare is set
+	To                json.RawMessage `json:"to"`
+	Compare           bool            `json:"compare,omitempty"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type UpdateResponseBody struct {
+	Type    string `json:"type"`
+	Version int64  `json:"version"`
+}
+
+// Replicate RPC, a primary's new value for a key
+type ReplicateRequestBody struct {
+	Type    string          `json:"type"`
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Version int64           `json:"version"`
+}
+
+type ReplicateResponseBody struct {
+	Type string `json:"type"`
+}
+
+type KV struct {
+	node *maelstrom.Node
+	env  *env.Env
+
+	mu   sync.Mutex
+	data map[string]entry // this node's copy of every key, the authoritative one for keys it's primary of
+	seen map[string]int64 // newest version of each key this node has read or written
+}
+
+// Creates a KV served by node and the other nodes of its cluster and registers its handlers
+func New(node *maelstrom.Node) *KV {
+	kv := &KV{
+		node: node,
+		env:  env.Of(node),
+		data: make(map[string]entry),
+		seen: make(map[string]int64),
+	}
+
+	handler.Handle(node, "seqkv_read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		e, err := kv.readLocal(body.Key)
+		if err != nil {
+			return ReadResponseBody{}, err
+		}
+		return ReadResponseBody{Value: e.value, Version: e.version}, nil
+	})
+
+	handler.Handle(node, "seqkv_update", func(msg maelstrom.Message, body UpdateRequestBody) (UpdateResponseBody, error) {
+		version, err := kv.apply(body)
+		if err != nil {
+			return UpdateResponseBody{}, err
+		}
+		return UpdateResponseBody{Version: version}, nil
+	})
+
+	handler.Handle(node, "seqkv_replicate", func(msg maelstrom.Message, body ReplicateRequestBody) (ReplicateResponseBody, error) {
+		kv.install(body.Key, entry{value: body.Value, version: body.Version})
+		return ReplicateResponseBody{}, nil
+	})
+
+	return kv
+}
+
+// Reads key into v, failing with KeyDoesNotExist if it was never written
+func (kv *KV) ReadInto(ctx context.Context, key string, v any) error {
+	e, err := kv.read(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(e.value, v)
+}
+
+func (kv *KV) Read(ctx context.Context, key string) (any, error) {
+	var value any
+	err := kv.ReadInto(ctx, key, &value)
+	return value, err
+}
+
+func (kv *KV) Write(ctx context.Context, key string, value any) error {
+	to, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return kv.update(ctx, UpdateRequestBody{Key: key, To: to})
+}
+
+// Sets key to to if it's currently from, failing with PreconditionFailed if it isn't
+func (kv *KV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	fromJSON, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	toJSON, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+	return kv.update(ctx, UpdateRequestBody{Key: key, From: fromJSON, To: toJSON, Compare: true, CreateIfNotExists: createIfNotExists})
+}
+
+func (kv *KV) primary(key string) string {
+	ids := slices.Sorted(slices.Values(kv.node.NodeIDs()))
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return ids[h.Sum32()%uint32(len(ids))]
+}
+
+func (kv *KV) read(ctx context.Context, key string) (entry, error) {
+	primary := kv.primary(key)
+
+	kv.mu.Lock()
+	local, ok := kv.data[key]
+	fresh := primary == kv.node.ID() || local.version >= kv.seen[key]
+	kv.mu.Unlock()
+
+	if fresh {
+		if !ok {
+			return entry{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		kv.observe(key, local.version)
+		return local, nil
+	}
+
+	msg, err := kv.node.SyncRPC(ctx, primary, ReadRequestBody{Type: "seqkv_read", Key: key})
+	if err != nil {
+		return entry{}, err
+	}
+
+	var body ReadResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return entry{}, err
+	}
+
+	e := entry{value: body.Value, version: body.Version}
+	kv.install(key, e)
+	kv.observe(key, e.version)
+	return e, nil
+}
+
+func (kv *KV) update(ctx context.Context, body UpdateRequestBody) error {
+	primary := kv.primary(body.Key)
+	if primary == kv.node.ID() {
+		version, err := kv.apply(body)
+		if err != nil {
+			return err
+		}
+		kv.observe(body.Key, version)
+		return nil
+	}
+
+	body.Type = "seqkv_update"
+	msg, err := kv.node.SyncRPC(ctx, primary, body)
+	if err != nil {
+		// The local copy is behind the primary's, so a retry that reads it first gets the primary's instead
+		var rpcErr *maelstrom.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.PreconditionFailed {
+			kv.mu.Lock()
+			kv.seen[body.Key] = max(kv.seen[body.Key], kv.data[body.Key].version+1)
+			kv.mu.Unlock()
+		}
+		return err
+	}
+
+	var resp UpdateResponseBody
+	if err := json.Unmarshal(msg.Body, &resp); err != nil {
+		return err
+	}
+
+	// Keep the new value so reads here don't have to wait for the primary's replicate message
+	kv.install(body.Key, entry{value: compact(body.To), version: resp.Version})
+	kv.observe(body.Key, resp.Version)
+	return nil
+}
+
+// Reads key from this node's copy, ignoring what this node has seen, for the primary
+func (kv *KV) readLocal(key string) (entry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	e, ok := kv.data[key]
+	if !ok {
+		return entry{}, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return e, nil
+}
+
+// Applies a write or compare-and-swap on the key's primary and returns the new version
+func (kv *KV) apply(body UpdateRequestBody) (int64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	current, exists := kv.data[body.Key]
+	if body.Compare {
+		if !exists && !body.CreateIfNotExists {
+			return 0, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		if exists && !bytes.Equal(current.value, compact(body.From)) {
+			return 0, maelstrom.NewRPCError(maelstrom.PreconditionFailed, "expected "+string(compact(body.From))+", found "+string(current.value))
+		}
+	}
+
+	next := entry{value: compact(body.To), version: current.version + 1}
+	kv.data[body.Key] = next
+
+	for _, peer := range kv.node.NodeIDs() {
+		if peer != kv.node.ID() {
+			go kv.replicate(peer, body.Key, next)
+		}
+	}
+	return next.version, nil
+}
+
+// Sends peer a new value of key until it acknowledges it or a newer one replaces it
+func (kv *KV) replicate(peer string, key string, e entry) {
+	for {
+		ctx, cancel := kv.env.Clock.WithTimeout(context.Background(), time.Second)
+		_, err := kv.node.SyncRPC(ctx, peer, ReplicateRequestBody{Type: "seqkv_replicate", Key: key, Value: e.value, Version: e.version})
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		kv.mu.Lock()
+		superseded := kv.data[key].version > e.version
+		kv.mu.Unlock()
+
+		if superseded {
+			return
+		}
+
+		logger.Warn("replication failed, retrying", "key", key, "peer", peer, "error", err)
+		<-kv.env.Clock.After(replicateRetryInterval)
+	}
+}
+
+// Keeps e as this node's copy of key if it's newer than the one it has
+func (kv *KV) install(key string, e entry) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if e.version > kv.data[key].version {
+		kv.data[key] = e
+	}
+}
+
+func (kv *KV) observe(key string, version int64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.seen[key] = max(kv.seen[key], version)
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
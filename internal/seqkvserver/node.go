@@ -0,0 +1,110 @@
+package seqkvserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/seqkv"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Sequentially consistent KV service
+
+Goal: serve read, write and cas like Maelstrom's seq-kv service, but from the cluster's own nodes (see
+internal/seqkv), to show what sequential consistency takes to provide. Keys can be any JSON value, they're
+stored under their compacted JSON.
+
+Run against the lin-kv workload, this fails the linearizability check on purpose: a read on a node other than
+the key's primary can miss a write acknowledged elsewhere. The counter can use the same store instead of
+Maelstrom's with COUNTER_KV=local.
+*/
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string          `json:"type"`
+	Key  json.RawMessage `json:"key"`
+}
+
+type ReadResponseBody struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// Write RPC
+type WriteRequestBody struct {
+	Type  string          `json:"type"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type WriteResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Compare-and-set RPC
+type CASRequestBody struct {
+	Type              string          `json:"type"`
+	Key               json.RawMessage `json:"key"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists,omitempty"`
+}
+
+type CASResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Serves seq-kv's read, write and cas to clients, from a KV run by n and its peers
+func Register(n *maelstrom.Node) error {
+	kv := seqkv.New(n)
+	clock := env.Of(n).Clock
+	ctx := context.Background()
+
+	// The keys are held by seqkv's replicas, see internal/seqkv
+	admin.Of(n).Register("seqkv", admin.Module{})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		readCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		var value json.RawMessage
+		if err := kv.ReadInto(readCtx, key(body.Key), &value); err != nil {
+			return ReadResponseBody{}, err
+		}
+
+		return ReadResponseBody{
+			Value: value,
+		}, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body WriteRequestBody) (WriteResponseBody, error) {
+		writeCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		return WriteResponseBody{}, kv.Write(writeCtx, key(body.Key), body.Value)
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body CASRequestBody) (CASResponseBody, error) {
+		casCtx, cancel := clock.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		return CASResponseBody{}, kv.CompareAndSwap(casCtx, key(body.Key), body.From, body.To, body.CreateIfNotExists)
+	})
+
+	return nil
+}
+
+// Returns the compacted JSON of a client's key, so 1 and 1.0 stay different keys but spacing doesn't matter
+func key(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+)
+
+// The clock of a deterministic run, which only moves when the cluster fires its next timers or delivers
+// its next message
+// Each of those starts an epoch, and timers due at the same time fire in the order of the epochs that set
+// them. Timers set in the same epoch, by goroutines racing each other, fire together.
+type virtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	epoch  int
+	seq    int
+	timers timerHeap
+}
+
+type timer struct {
+	at     time.Time
+	epoch  int
+	seq    int
+	period time.Duration // for tickers, 0 for one-off timers
+	fire   func(now time.Time)
+	index  int // position in the heap, -1 once it's stopped or fired
+}
+
+func newVirtualClock(start time.Time) *virtualClock {
+	return &virtualClock{now: start}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.add(d, 0, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *virtualClock) NewTicker(d time.Duration) env.Ticker {
+	ch := make(chan time.Time, 1)
+	t := c.add(d, d, func(now time.Time) {
+		select {
+		case ch <- now:
+		default:
+		}
+	})
+	return &virtualTicker{clock: c, timer: t, ch: ch}
+}
+
+func (c *virtualClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx := &timeoutContext{Context: parent, deadline: c.Now().Add(d), done: make(chan struct{})}
+
+	t := c.add(d, 0, func(time.Time) { ctx.cancel(context.DeadlineExceeded) })
+	stop := context.AfterFunc(parent, func() { ctx.cancel(parent.Err()) })
+
+	return ctx, func() {
+		stop()
+		c.stop(t)
+		ctx.cancel(context.Canceled)
+	}
+}
+
+func (c *virtualClock) add(d time.Duration, period time.Duration, fire func(now time.Time)) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t := &timer{at: c.now.Add(d), epoch: c.epoch, seq: c.seq, period: period, fire: fire}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+func (c *virtualClock) stop(t *timer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.index >= 0 {
+		heap.Remove(&c.timers, t.index)
+	}
+}
+
+// Returns when the next timer is due, false if none is set
+func (c *virtualClock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].at, true
+}
+
+// Starts the epoch of the next message or timers
+func (c *virtualClock) nextEpoch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+}
+
+// Moves the clock forward to the next timer and fires it along with every other timer due then that was set
+// in the same epoch, starting a new one
+func (c *virtualClock) fireDue() {
+	c.mu.Lock()
+	c.epoch++
+
+	var due []*timer
+	for len(c.timers) > 0 && (len(due) == 0 || c.timers[0].at.Equal(due[0].at) && c.timers[0].epoch == due[0].epoch) {
+		due = append(due, heap.Pop(&c.timers).(*timer))
+	}
+	if len(due) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.now = due[0].at
+
+	for _, t := range due {
+		if t.period > 0 {
+			c.seq++
+			t.at, t.epoch, t.seq = t.at.Add(t.period), c.epoch, c.seq
+			heap.Push(&c.timers, t)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, t := range due {
+		t.fire(c.now)
+	}
+}
+
+// Moves the clock forward to at, which must not be past the next timer
+func (c *virtualClock) advance(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if at.After(c.now) {
+		c.now = at
+	}
+}
+
+type virtualTicker struct {
+	clock *virtualClock
+	timer *timer
+	ch    chan time.Time
+}
+
+func (t *virtualTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *virtualTicker) Stop() {
+	t.clock.stop(t.timer)
+}
+
+// A context cancelled by its virtual clock, its parent or its cancel function, whichever comes first
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (ctx *timeoutContext) Deadline() (time.Time, bool) {
+	return ctx.deadline, true
+}
+
+func (ctx *timeoutContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *timeoutContext) Err() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.err
+}
+
+func (ctx *timeoutContext) cancel(err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.err == nil {
+		ctx.err = err
+		close(ctx.done)
+	}
+}
+
+// Timers ordered by when they're due, then by the epoch they were set in
+type timerHeap []*timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+	if h[i].epoch != h[j].epoch {
+		return h[i].epoch < h[j].epoch
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
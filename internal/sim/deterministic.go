@@ -0,0 +1,280 @@
+package sim
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Deterministic runs
+
+With Config.Deterministic the cluster runs on a virtual clock and does one thing at a time: it delivers
+the next message or fires the next timers (see clock.go for which fire together), then waits until every goroutine of the process is blocked
+again before looking at what that caused. The messages sent meanwhile are sorted, so goroutines racing
+to send them can't change the order, then numbered, and each is given a latency and, between nodes, a
+chance of being lost, drawn from a generator seeded with Config.Seed. Every node's env gets the virtual
+clock and a generator of its own seeded from the same one. Time only passes while a client waits for a
+reply or during Advance, so nothing happens behind the test's back between two requests.
+
+So two runs with the same seed and the same requests, sent from a single goroutine, send the same
+messages at the same virtual times, as far as the workload's protocols use their env rather than the
+time and rand packages (the gossip engine, Raft, the LWW-KV and the self-hosted seq-kv do; txn, kafka
+and unique-ids still run on the wall clock and only get the deterministic network). Trace returns what
+a run sent, and Dropped the messages it lost: a failing run can be replayed with exactly those losses
+through Config.Drops, and Shrink cuts them down to a few that still make it fail.
+*/
+
+// A message sent during a deterministic run
+type Event struct {
+	Seq     int             `json:"seq"`  // numbered in the order they were sent
+	Sent    time.Duration   `json:"sent"` // virtual time since the run started
+	Src     string          `json:"src"`
+	Dest    string          `json:"dest"`
+	Body    json.RawMessage `json:"body"` // without msg_id and in_reply_to, which depend on goroutine scheduling
+	Dropped bool            `json:"dropped,omitempty"`
+}
+
+// A message waiting for its delivery time
+type scheduled struct {
+	at  time.Time
+	seq int
+	msg maelstrom.Message
+}
+
+type deliveryHeap []scheduled
+
+func (h deliveryHeap) Len() int {
+	return len(h)
+}
+
+func (h deliveryHeap) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h deliveryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *deliveryHeap) Push(x any) {
+	*h = append(*h, x.(scheduled))
+}
+
+func (h *deliveryHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// Returns the events of a deterministic run so far
+func (c *Cluster) Trace() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.trace)
+}
+
+// Returns the sequence numbers of the messages a deterministic run lost, to replay through Config.Drops
+func (c *Cluster) Dropped() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.dropped)
+}
+
+// Lets a deterministic run's virtual time pass by d, delivering messages and firing timers on the way
+func (c *Cluster) Advance(d time.Duration) {
+	if c.clock == nil {
+		time.Sleep(d)
+		return
+	}
+
+	c.driving.Lock()
+	defer c.driving.Unlock()
+
+	until := c.clock.Now().Add(d)
+	for c.step(until) {
+	}
+	c.clock.advance(until)
+}
+
+// Waits for the cluster to settle, schedules what it sent, then delivers the next message or fires the next
+// timer if that's due by until, returning false if neither is
+func (c *Cluster) step(until time.Time) bool {
+	settle()
+	c.schedule()
+
+	c.mu.Lock()
+	var nextMsg *scheduled
+	if len(c.deliveries) > 0 {
+		nextMsg = &c.deliveries[0]
+	}
+	c.mu.Unlock()
+	nextTimer, timerSet := c.clock.next()
+
+	switch {
+	case nextMsg != nil && !nextMsg.at.After(until) && (!timerSet || !nextTimer.Before(nextMsg.at)):
+		c.mu.Lock()
+		s := heap.Pop(&c.deliveries).(scheduled)
+		c.mu.Unlock()
+
+		c.clock.advance(s.at)
+		c.clock.nextEpoch()
+		c.deliver(s.msg)
+		return true
+
+	case timerSet && !nextTimer.After(until):
+		c.clock.fireDue()
+		return true
+	}
+
+	return false
+}
+
+// Numbers the messages sent since the last step in a canonical order and gives each its delivery time
+func (c *Cluster) schedule() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type pending struct {
+		msg  maelstrom.Message
+		body json.RawMessage
+	}
+
+	var sent []pending
+	for _, msg := range c.outgoing {
+		sent = append(sent, pending{msg: msg, body: canonicalBody(msg.Body)})
+	}
+	c.outgoing = nil
+
+	slices.SortFunc(sent, func(a, b pending) int {
+		return cmp.Or(cmp.Compare(a.msg.Src, b.msg.Src), cmp.Compare(a.msg.Dest, b.msg.Dest), bytes.Compare(a.body, b.body))
+	})
+
+	now := c.clock.Now()
+	for _, p := range sent {
+		c.seq++
+
+		// Always draw both, so replaying with Config.Drops keeps every other latency the same
+		latency := c.config.MinLatency
+		if spread := c.config.MaxLatency - c.config.MinLatency; spread > 0 {
+			latency += time.Duration(c.rng.Int64N(int64(spread) + 1))
+		}
+		lost := c.rng.Float64() < c.config.DropRate
+		if c.config.Drops != nil {
+			lost = slices.Contains(c.config.Drops, c.seq)
+		}
+
+		// Only messages between nodes are lost, clients and services are always reachable
+		lost = lost && c.inboxes[p.msg.Src] != nil && c.inboxes[p.msg.Dest] != nil
+
+		c.trace = append(c.trace, Event{Seq: c.seq, Sent: now.Sub(c.start), Src: p.msg.Src, Dest: p.msg.Dest, Body: p.body, Dropped: lost})
+		if lost {
+			c.dropped = append(c.dropped, c.seq)
+			continue
+		}
+
+		heap.Push(&c.deliveries, scheduled{at: now.Add(latency), seq: c.seq, msg: p.msg})
+	}
+}
+
+// Returns a message body without the fields that depend on the order goroutines sent messages in
+func canonicalBody(body json.RawMessage) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	delete(fields, "msg_id")
+	delete(fields, "in_reply_to")
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return canonical
+}
+
+// Waits until no goroutine but the caller's can run, all the others being blocked on a channel, a lock or
+// a timer, so whatever the last event set off has happened
+// The scheduler's metrics are cheap but only approximate, so once they look quiet a dump of every goroutine
+// confirms it. Gives up after a second, say if another test's goroutines run on the wall clock
+func settle() {
+	samples := []metrics.Sample{
+		{Name: "/sched/goroutines/running:goroutines"},
+		{Name: "/sched/goroutines/runnable:goroutines"},
+		{Name: "/sched/goroutines/not-in-go:goroutines"},
+	}
+	buf := make([]byte, 1<<16)
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		runtime.Gosched()
+
+		// The caller is the one goroutine running
+		metrics.Read(samples)
+		if samples[0].Value.Uint64()+samples[1].Value.Uint64()+samples[2].Value.Uint64() > 1 {
+			continue
+		}
+
+		n := runtime.Stack(buf, true)
+		for n == len(buf) {
+			buf = make([]byte, 2*len(buf))
+			n = runtime.Stack(buf, true)
+		}
+		if !busy(buf[:n]) {
+			return
+		}
+	}
+}
+
+// Returns whether any goroutine but the first, the caller's, in a dump of all of them is running or ready to
+func busy(dump []byte) bool {
+	for i, header := range bytes.Split(dump, []byte("\n\n")) {
+		if i == 0 {
+			continue
+		}
+
+		start, end := bytes.IndexByte(header, '['), bytes.IndexAny(header, ",]")
+		if start < 0 || end < start {
+			continue
+		}
+
+		switch string(header[start+1 : end]) {
+		case "running", "runnable", "syscall", "preempted":
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a subset of drops, as small as removing them in halves, then quarters and so on down to one at
+// a time finds, for which fails still returns true
+// Dropping fewer messages shifts the sequence numbers of the ones sent after, so a drop that no longer
+// means the same message is simply removed like any other that doesn't matter
+func Shrink(drops []int, fails func(drops []int) bool) []int {
+	drops = slices.Clone(drops)
+
+	for chunk := max(len(drops)/2, 1); chunk >= 1 && len(drops) > 0; chunk /= 2 {
+		for i := 0; i < len(drops); {
+			end := min(i+chunk, len(drops))
+			without := slices.Concat(drops[:i], drops[end:])
+
+			if fails(without) {
+				drops = without
+			} else {
+				i = end
+			}
+		}
+	}
+
+	return drops
+}
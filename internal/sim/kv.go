@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Stands in for Maelstrom's KV services, answering read, write and cas one at a time
+type kvService struct {
+	mu   sync.Mutex
+	data map[string]json.RawMessage // values by the compacted JSON of their key
+}
+
+type KVRequestBody struct {
+	Type              string          `json:"type"`
+	MsgID             int             `json:"msg_id"`
+	Key               json.RawMessage `json:"key"`
+	Value             json.RawMessage `json:"value"`
+	From              json.RawMessage `json:"from"`
+	To                json.RawMessage `json:"to"`
+	CreateIfNotExists bool            `json:"create_if_not_exists"`
+}
+
+type KVResponseBody struct {
+	Type      string          `json:"type"`
+	InReplyTo int             `json:"in_reply_to"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Code      int             `json:"code,omitempty"`
+	Text      string          `json:"text,omitempty"`
+}
+
+func newKVService() *kvService {
+	return &kvService{data: make(map[string]json.RawMessage)}
+}
+
+// Applies a request and returns the reply to it, false if it isn't a request
+func (kv *kvService) handle(msg maelstrom.Message) (maelstrom.Message, bool) {
+	var body KVRequestBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil || body.MsgID == 0 {
+		return maelstrom.Message{}, false
+	}
+
+	resp := kv.apply(body)
+	resp.InReplyTo = body.MsgID
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		return maelstrom.Message{}, false
+	}
+	return maelstrom.Message{Src: msg.Dest, Dest: msg.Src, Body: respJSON}, true
+}
+
+func (kv *kvService) apply(body KVRequestBody) KVResponseBody {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	key := string(compact(body.Key))
+	current, ok := kv.data[key]
+
+	switch body.Type {
+	case "read":
+		if !ok {
+			return kvError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return KVResponseBody{Type: "read_ok", Value: current}
+
+	case "write":
+		kv.data[key] = compact(body.Value)
+		return KVResponseBody{Type: "write_ok"}
+
+	case "cas":
+		if !ok && !body.CreateIfNotExists {
+			return kvError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		if ok && !equal(current, body.From) {
+			return kvError(maelstrom.PreconditionFailed, "expected "+string(compact(body.From))+", found "+string(current))
+		}
+		kv.data[key] = compact(body.To)
+		return KVResponseBody{Type: "cas_ok"}
+	}
+
+	return kvError(maelstrom.NotSupported, "unsupported operation "+body.Type)
+}
+
+func kvError(code int, text string) KVResponseBody {
+	return KVResponseBody{Type: "error", Code: code, Text: text}
+}
+
+// Compares two JSON values by what they decode to, so spacing and key order don't matter
+func equal(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(compact(a), compact(b))
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
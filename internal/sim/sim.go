@@ -0,0 +1,398 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+In-process Maelstrom
+
+Runs a cluster of nodes inside one process so a workload's handlers can be tested with go test, no Maelstrom
+or JVM needed. Every node is a real maelstrom.Node running the workload's Register, only its Stdin and
+Stdout are swapped for in-memory mailboxes: a line a node writes is routed to another node's mailbox, to a
+waiting client, or to one of Maelstrom's KV services. lin-kv, seq-kv and lww-kv are all served by the same
+linearizable in-memory store, which is a valid (if stricter than required) seq-kv and lww-kv too.
+
+Clients are any IDs that aren't nodes or services, RPC sends a request from one and waits for the reply.
+Filter lets a test drop messages nodes send, to cut nodes off from each other or lose a share of traffic.
+By default everything runs on the wall clock, as fast as the goroutines go; see deterministic.go for
+runs that repeat exactly.
+*/
+
+// Maelstrom's KV services, all served by the same linearizable store
+var services = []string{"lin-kv", "seq-kv", "lww-kv"}
+
+type Config struct {
+	Deterministic bool   // run on a virtual clock with the network driven by Seed, see deterministic.go
+	Seed          uint64 // seeds the latencies, losses and every node's env in a deterministic run
+
+	// The rest only apply to deterministic runs
+	MinLatency time.Duration // each message takes between MinLatency and MaxLatency to arrive
+	MaxLatency time.Duration
+	DropRate   float64       // share of messages between nodes that are lost
+	Drops      []int         // if set, exactly the messages with these sequence numbers are lost instead
+	RPCTimeout time.Duration // virtual time a client waits for a reply, 10s if 0
+}
+
+type Cluster struct {
+	config Config
+
+	mu        sync.Mutex
+	ids       []string
+	nodes     map[string]*maelstrom.Node
+	inboxes   map[string]*mailbox
+	kv        *kvService
+	waiting   map[reply]chan maelstrom.Message // client requests waiting for their reply
+	nextMsgID int
+	filter    func(msg maelstrom.Message) bool
+	errs      []error
+	closed    bool
+
+	// Deterministic runs only
+	driving    sync.Mutex // held by whoever is moving the virtual clock
+	clock      *virtualClock
+	rng        *rand.Rand
+	start      time.Time
+	outgoing   []maelstrom.Message // sent since the last step, not scheduled yet
+	deliveries deliveryHeap
+	seq        int
+	trace      []Event
+	dropped    []int
+}
+
+// A reply to a client's request, identified by the client and the request's msg_id
+type reply struct {
+	client string
+	msgID  int
+}
+
+type InitRequestBody struct {
+	Type    string   `json:"type"`
+	NodeID  string   `json:"node_id"`
+	NodeIDs []string `json:"node_ids"`
+}
+
+// Starts a node for each of ids with its handlers registered by register, and waits for all of them
+// to be initialized
+func Start(ids []string, register func(n *maelstrom.Node) error, config Config) (*Cluster, error) {
+	if config.RPCTimeout == 0 {
+		config.RPCTimeout = 10 * time.Second
+	}
+
+	c := &Cluster{
+		config:  config,
+		ids:     ids,
+		nodes:   make(map[string]*maelstrom.Node),
+		inboxes: make(map[string]*mailbox),
+		kv:      newKVService(),
+		waiting: make(map[reply]chan maelstrom.Message),
+	}
+
+	if config.Deterministic {
+		c.start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		c.clock = newVirtualClock(c.start)
+		c.rng = env.NewRand(config.Seed)
+	}
+
+	for _, id := range ids {
+		n := maelstrom.NewNode()
+		inbox := newMailbox()
+		n.Stdin = inbox
+		n.Stdout = &outbox{cluster: c}
+
+		if c.clock != nil {
+			env.Set(n, &env.Env{Clock: c.clock, Rand: env.NewRand(c.rng.Uint64())})
+		}
+
+		if err := register(n); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("sim: registering %s: %w", id, err)
+		}
+
+		c.nodes[id] = n
+		c.inboxes[id] = inbox
+
+		go func() {
+			if err := n.Run(); err != nil {
+				c.mu.Lock()
+				c.errs = append(c.errs, fmt.Errorf("sim: %s: %w", id, err))
+				c.mu.Unlock()
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, id := range ids {
+		if _, err := c.RPC(ctx, "c0", id, InitRequestBody{Type: "init", NodeID: id, NodeIDs: ids}); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("sim: initializing %s: %w", id, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Returns the IDs of the cluster's nodes
+func (c *Cluster) NodeIDs() []string {
+	return c.ids
+}
+
+// Returns the node with the given ID, nil if there's none
+func (c *Cluster) Node(id string) *maelstrom.Node {
+	return c.nodes[id]
+}
+
+// Sets a function deciding which messages sent by nodes are delivered, nil delivers them all
+func (c *Cluster) Filter(filter func(msg maelstrom.Message) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = filter
+}
+
+// Sends body from client to dest and waits for the reply, returning it along with an *maelstrom.RPCError
+// if it's an error
+func (c *Cluster) RPC(ctx context.Context, client, dest string, body any) (maelstrom.Message, error) {
+	// Unlike the node's own RPC, keep the body's values as they are so large numbers survive
+	b := make(map[string]json.RawMessage)
+	if buf, err := json.Marshal(body); err != nil {
+		return maelstrom.Message{}, err
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	replyCh := make(chan maelstrom.Message, 1)
+
+	c.mu.Lock()
+	c.nextMsgID++
+	key := reply{client: client, msgID: c.nextMsgID}
+	c.waiting[key] = replyCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.waiting, key)
+		c.mu.Unlock()
+	}()
+
+	b["msg_id"] = json.RawMessage(strconv.Itoa(key.msgID))
+	bodyJSON, err := json.Marshal(b)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+	msg := maelstrom.Message{Src: client, Dest: dest, Body: bodyJSON}
+
+	if c.clock != nil {
+		return c.awaitReply(ctx, msg, replyCh)
+	}
+	c.deliver(msg)
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case msg := <-replyCh:
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	}
+}
+
+// Sends a client's request in a deterministic run and moves the virtual clock until the reply arrives, or
+// until RPCTimeout has passed
+func (c *Cluster) awaitReply(ctx context.Context, msg maelstrom.Message, replyCh chan maelstrom.Message) (maelstrom.Message, error) {
+	c.driving.Lock()
+	defer c.driving.Unlock()
+
+	c.enqueue(msg)
+	deadline := c.clock.Now().Add(c.config.RPCTimeout)
+
+	for {
+		select {
+		case reply := <-replyCh:
+			if err := reply.RPCError(); err != nil {
+				return reply, err
+			}
+			return reply, nil
+		default:
+		}
+
+		if ctx.Err() != nil {
+			return maelstrom.Message{}, ctx.Err()
+		}
+		if !c.step(deadline) {
+			c.clock.advance(deadline)
+			return maelstrom.Message{}, context.DeadlineExceeded
+		}
+	}
+}
+
+// Returns the errors nodes stopped with, nil if they're all still running
+func (c *Cluster) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return errors.Join(c.errs...)
+}
+
+// Stops delivering messages and closes every node's input, so their Run returns once their handlers do
+// Background goroutines a workload started aren't stopped, they just can't reach anyone anymore
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	for _, inbox := range c.inboxes {
+		inbox.close()
+	}
+}
+
+// Routes a message sent by a node, dropping it if the filter says so
+func (c *Cluster) send(msg maelstrom.Message) {
+	c.mu.Lock()
+	filter := c.filter
+	c.mu.Unlock()
+
+	if filter != nil && !filter(msg) {
+		return
+	}
+	if c.clock != nil {
+		c.enqueue(msg)
+		return
+	}
+	c.deliver(msg)
+}
+
+// Holds a message of a deterministic run until the next step schedules it
+func (c *Cluster) enqueue(msg maelstrom.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outgoing = append(c.outgoing, msg)
+}
+
+func (c *Cluster) deliver(msg maelstrom.Message) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	inbox := c.inboxes[msg.Dest]
+	c.mu.Unlock()
+
+	if inbox != nil {
+		line, err := json.Marshal(msg)
+		if err == nil {
+			inbox.push(line)
+		}
+		return
+	}
+
+	for _, service := range services {
+		if msg.Dest == service {
+			if resp, ok := c.kv.handle(msg); ok && c.clock != nil {
+				c.enqueue(resp)
+			} else if ok {
+				c.deliver(resp)
+			}
+			return
+		}
+	}
+
+	// Anything else is a client, which only ever receives replies
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	replyCh, ok := c.waiting[reply{client: msg.Dest, msgID: body.InReplyTo}]
+	delete(c.waiting, reply{client: msg.Dest, msgID: body.InReplyTo})
+	c.mu.Unlock()
+
+	if ok {
+		replyCh <- msg
+	}
+}
+
+// A node's Stdin, lines pushed to it are read in order until it's closed
+type mailbox struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newMailbox() *mailbox {
+	m := &mailbox{}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *mailbox) push(line []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.buf.Write(line)
+	m.buf.WriteByte('\n')
+	m.cond.Signal()
+}
+
+func (m *mailbox) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.cond.Broadcast()
+}
+
+func (m *mailbox) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.buf.Len() == 0 && !m.closed {
+		m.cond.Wait()
+	}
+	if m.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return m.buf.Read(p)
+}
+
+// A node's Stdout, every complete line written to it is routed as a message
+// The node writes a message and its newline separately, but always under its own lock
+type outbox struct {
+	cluster *Cluster
+	buf     []byte
+}
+
+func (o *outbox) Write(p []byte) (int, error) {
+	o.buf = append(o.buf, p...)
+
+	for {
+		i := bytes.IndexByte(o.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		var msg maelstrom.Message
+		if err := json.Unmarshal(o.buf[:i], &msg); err == nil {
+			o.cluster.send(msg)
+		}
+		o.buf = o.buf[i+1:]
+	}
+}
@@ -0,0 +1,1223 @@
+package sim_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/broadcast"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/cart"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/counter"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/dynamo"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/echo"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kafka"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lwwkv"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metadata"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/paxos"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/percolator"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/primarybackup"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/saga"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/seqkvserver"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/sim"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/text"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/uniqueids"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vr"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func start(t *testing.T, n int, register func(*maelstrom.Node) error) *sim.Cluster {
+	return startWith(t, n, register, sim.Config{})
+}
+
+func startWith(t *testing.T, n int, register func(*maelstrom.Node) error, config sim.Config) *sim.Cluster {
+	t.Helper()
+
+	var ids []string
+	for i := range n {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+
+	c, err := sim.Start(ids, register, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Err(); err != nil {
+			t.Error(err)
+		}
+		c.Close()
+	})
+	return c
+}
+
+// Sends body to dest from client c1 and decodes the reply into a T
+func call[T any](t *testing.T, c *sim.Cluster, dest string, body any) (T, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var resp T
+	msg, err := c.RPC(ctx, "c1", dest, body)
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(msg.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp, nil
+}
+
+// Retries check until it passes or timeout runs out
+func eventually(t *testing.T, timeout time.Duration, check func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func errorCode(err error) int {
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	return 0
+}
+
+func TestEcho(t *testing.T) {
+	c := start(t, 1, echo.Register)
+
+	resp, err := call[echo.EchoResponseBody](t, c, "n0", map[string]any{"type": "echo", "echo": json.RawMessage(`12345678901234567890`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "echo_ok" || string(resp.Echo) != "12345678901234567890" {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+func TestBroadcastConvergesWithLoss(t *testing.T) {
+	c := start(t, 3, broadcast.Register)
+
+	// Lose a third of the messages between nodes
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(1))
+	c.Filter(func(msg maelstrom.Message) bool {
+		if slices.Contains(c.NodeIDs(), msg.Src) && slices.Contains(c.NodeIDs(), msg.Dest) {
+			mu.Lock()
+			defer mu.Unlock()
+			return rng.Intn(3) > 0
+		}
+		return true
+	})
+
+	topology := map[string][]string{"n0": {"n1"}, "n1": {"n0", "n2"}, "n2": {"n1"}}
+	for _, id := range c.NodeIDs() {
+		if _, err := call[map[string]any](t, c, id, map[string]any{"type": "topology", "topology": topology}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []int
+	for i := range 30 {
+		want = append(want, i)
+		if _, err := call[map[string]any](t, c, c.NodeIDs()[i%3], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range c.NodeIDs() {
+		eventually(t, 5*time.Second, func() error {
+			resp, err := call[broadcast.ReadResponseBody](t, c, id, map[string]any{"type": "read"})
+			if err != nil {
+				return err
+			}
+			got := slices.Sorted(slices.Values(resp.Messages))
+			if !slices.Equal(got, want) {
+				return fmt.Errorf("%s read %v, want %v", id, got, want)
+			}
+			return nil
+		})
+	}
+}
+
+func TestCounterOnSeqKV(t *testing.T) {
+	c := start(t, 3, counter.Register)
+
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := call[map[string]any](t, c, c.NodeIDs()[i%3], map[string]any{"type": "add", "delta": i}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range c.NodeIDs() {
+		resp, err := call[counter.ReadResponseBody](t, c, id, map[string]any{"type": "read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Value != 435 {
+			t.Fatalf("%s read %d, want 435", id, resp.Value)
+		}
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	c := start(t, 3, uniqueids.Register)
+
+	seen := map[string]bool{}
+	for i := range 100 {
+		resp, err := call[map[string]any](t, c, c.NodeIDs()[i%3], map[string]any{"type": "generate"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := fmt.Sprint(resp["id"])
+		if seen[id] {
+			t.Fatalf("duplicate id %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestUniqueIDsCoordinatorTakeover(t *testing.T) {
+	t.Setenv("ID_SCHEME", "coordinator")
+	c := start(t, 3, uniqueids.Register)
+
+	// Asking different nodes for blocks makes each take over from the last, and a deposed one take over again
+	type block struct{ start, end int64 }
+	var blocks []block
+	for i := range 12 {
+		resp, err := call[uniqueids.RangeLeaseResponseBody](t, c, c.NodeIDs()[i%2], map[string]any{"type": "range_lease", "size": 10})
+		if errorCode(err) == maelstrom.TemporarilyUnavailable {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block{resp.Start, resp.End})
+	}
+
+	if len(blocks) < 6 {
+		t.Fatalf("only %d of 12 leases granted", len(blocks))
+	}
+	for i, a := range blocks {
+		for _, b := range blocks[:i] {
+			if a.start < b.end && b.start < a.end {
+				t.Fatalf("blocks %v and %v overlap", a, b)
+			}
+		}
+	}
+}
+
+func TestRaftKV(t *testing.T) {
+	c := start(t, 3, raft.Register)
+
+	// Writes are retried until an election settles
+	eventually(t, 5*time.Second, func() error {
+		_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10})
+		return err
+	})
+
+	resp, err := call[raft.ReadResponseBody](t, c, "n2", map[string]any{"type": "read", "key": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "10" {
+		t.Fatalf("read %s, want 10", resp.Value)
+	}
+
+	_, err = call[map[string]any](t, c, "n1", map[string]any{"type": "cas", "key": 1, "from": 5, "to": 6})
+	if code := errorCode(err); code != maelstrom.PreconditionFailed {
+		t.Fatalf("cas from a stale value returned %v, want code %d", err, maelstrom.PreconditionFailed)
+	}
+
+	_, err = call[map[string]any](t, c, "n1", map[string]any{"type": "read", "key": 2})
+	if code := errorCode(err); code != maelstrom.KeyDoesNotExist {
+		t.Fatalf("read of a missing key returned %v, want code %d", err, maelstrom.KeyDoesNotExist)
+	}
+}
+
+// Writes keys and values beyond 2^53 through every node of a lin-kv cluster, checking none of them is
+// rounded on the way to the leader or its followers
+func checkLargeIntegers(t *testing.T, c *sim.Cluster) {
+	t.Helper()
+
+	const key = json.Number("9007199254740993")
+	for i, node := range c.NodeIDs() {
+		value := json.Number(fmt.Sprintf("900719925474099%d", 5+i))
+		eventually(t, 5*time.Second, func() error {
+			_, err := call[map[string]any](t, c, node, map[string]any{"type": "write", "key": key, "value": value})
+			return err
+		})
+		for _, reader := range c.NodeIDs() {
+			resp, err := call[raft.ReadResponseBody](t, c, reader, map[string]any{"type": "read", "key": key})
+			if err != nil || string(resp.Value) != string(value) {
+				t.Fatalf("read %s from %s after writing through %s, %v, want %s", resp.Value, reader, node, err, value)
+			}
+		}
+	}
+}
+
+func TestRaftLargeIntegers(t *testing.T) {
+	checkLargeIntegers(t, start(t, 3, raft.Register))
+}
+
+func TestRaftCommitWait(t *testing.T) {
+	t.Setenv("RAFT_COMMIT_WAIT", "true")
+	c := start(t, 3, raft.Register)
+
+	eventually(t, 5*time.Second, func() error {
+		_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10})
+		return err
+	})
+
+	// Each acknowledged write's timestamp is later than the last, whichever node it went through
+	var last int64
+	for i, node := range []string{"n0", "n1", "n2", "n1"} {
+		resp, err := call[raft.WriteResponseBody](t, c, node, map[string]any{"type": "write", "key": 1, "value": 20 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.TS <= last {
+			t.Fatalf("write %d through %s got timestamp %d, after %d", i, node, resp.TS, last)
+		}
+		last = resp.TS
+	}
+}
+
+func TestPaxosKV(t *testing.T) {
+	for _, mode := range []string{"multi", "single"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv("PAXOS_MODE", mode)
+			c := start(t, 3, paxos.Register)
+
+			// Writes are retried until an election settles, in single mode the first one goes through
+			eventually(t, 5*time.Second, func() error {
+				_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10})
+				return err
+			})
+			if _, err := call[map[string]any](t, c, "n1", map[string]any{"type": "cas", "key": 1, "from": 10, "to": 11}); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := call[raft.ReadResponseBody](t, c, "n2", map[string]any{"type": "read", "key": 1})
+			if err != nil || string(resp.Value) != "11" {
+				t.Fatalf("read %s, %v, want 11", resp.Value, err)
+			}
+
+			_, err = call[map[string]any](t, c, "n0", map[string]any{"type": "cas", "key": 1, "from": 10, "to": 12})
+			if code := errorCode(err); code != maelstrom.PreconditionFailed {
+				t.Fatalf("cas from a stale value returned %v, want code %d", err, maelstrom.PreconditionFailed)
+			}
+		})
+	}
+}
+
+func TestPaxosLargeIntegers(t *testing.T) {
+	for _, mode := range []string{"multi", "single"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv("PAXOS_MODE", mode)
+			checkLargeIntegers(t, start(t, 3, paxos.Register))
+		})
+	}
+}
+
+func TestPaxosLeaderFailover(t *testing.T) {
+	c := start(t, 3, paxos.Register)
+
+	// A write that times out is answered with code 0, which call doesn't count as an error
+	write := func(node string, value int) error {
+		resp, err := call[map[string]any](t, c, node, map[string]any{"type": "write", "key": 1, "value": value})
+		if err == nil && resp["type"] != "write_ok" {
+			return fmt.Errorf("write answered %v", resp)
+		}
+		return err
+	}
+
+	eventually(t, 5*time.Second, func() error { return write("n0", 10) })
+	status, err := call[paxos.PaxosStatusResponseBody](t, c, "n0", map[string]any{"type": "paxos_status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := status.Leader
+
+	// Cut the leader off from the other nodes, they elect one of themselves and carry on
+	var mu sync.Mutex
+	cut := true
+	c.Filter(func(msg maelstrom.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !cut || !slices.Contains(c.NodeIDs(), msg.Dest) || msg.Src != old && msg.Dest != old
+	})
+	other := c.NodeIDs()[(slices.Index(c.NodeIDs(), old)+1)%3]
+	eventually(t, 5*time.Second, func() error { return write(other, 20) })
+
+	// The old leader can't get anything chosen without a majority
+	if err := write(old, 30); err == nil {
+		t.Fatal("a cut off leader's write succeeded")
+	}
+
+	// Once it's back it learns it was replaced, and reads go through the new leader's log
+	mu.Lock()
+	cut = false
+	mu.Unlock()
+	eventually(t, 5*time.Second, func() error {
+		resp, err := call[raft.ReadResponseBody](t, c, old, map[string]any{"type": "read", "key": 1})
+		if err != nil {
+			return err
+		}
+		if string(resp.Value) != "20" {
+			return fmt.Errorf("read %s, want 20", resp.Value)
+		}
+		return nil
+	})
+}
+
+func TestVRLargeIntegers(t *testing.T) {
+	checkLargeIntegers(t, start(t, 3, vr.Register))
+}
+
+func TestVRViewChange(t *testing.T) {
+	c := start(t, 3, vr.Register)
+
+	write := func(node string, value int) error {
+		resp, err := call[map[string]any](t, c, node, map[string]any{"type": "write", "key": 1, "value": value})
+		if err == nil && resp["type"] != "write_ok" {
+			return fmt.Errorf("write answered %v", resp)
+		}
+		return err
+	}
+
+	// n0 is the primary of view 0
+	if err := write("n2", 10); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := call[raft.ReadResponseBody](t, c, "n1", map[string]any{"type": "read", "key": 1})
+	if err != nil || string(resp.Value) != "10" {
+		t.Fatalf("read %s, %v, want 10", resp.Value, err)
+	}
+
+	// Cut the primary off, the backups change to view 1 with n1 as primary
+	var mu sync.Mutex
+	cut := true
+	c.Filter(func(msg maelstrom.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !cut || !slices.Contains(c.NodeIDs(), msg.Dest) || msg.Src != "n0" && msg.Dest != "n0"
+	})
+	eventually(t, 5*time.Second, func() error { return write("n2", 20) })
+	status, err := call[vr.VRStatusResponseBody](t, c, "n2", map[string]any{"type": "vr_status"})
+	if err != nil || status.View == 0 || status.Primary == "n0" {
+		t.Fatalf("status %+v, %v, want a later view", status, err)
+	}
+	if err := write("n0", 30); err == nil {
+		t.Fatal("a cut off primary's write succeeded")
+	}
+
+	// Once it's back the old primary moves to the new view and fetches the ops it missed
+	mu.Lock()
+	cut = false
+	mu.Unlock()
+	eventually(t, 5*time.Second, func() error {
+		status, err := call[vr.VRStatusResponseBody](t, c, "n0", map[string]any{"type": "vr_status"})
+		if err != nil {
+			return err
+		}
+		if status.Status != "normal" || status.Applied < 2 {
+			return fmt.Errorf("old primary %+v, want normal with both writes applied", status)
+		}
+		return nil
+	})
+	resp, err = call[raft.ReadResponseBody](t, c, "n0", map[string]any{"type": "read", "key": 1})
+	if err != nil || string(resp.Value) != "20" {
+		t.Fatalf("read %s, %v, want 20", resp.Value, err)
+	}
+}
+
+func TestKafkaOnLinKV(t *testing.T) {
+	c := start(t, 2, kafka.Register)
+
+	for i := range 5 {
+		resp, err := call[kafka.SendResponseBody](t, c, c.NodeIDs()[i%2], map[string]any{"type": "send", "key": "k", "msg": 100 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Offset != i {
+			t.Fatalf("send %d got offset %d", i, resp.Offset)
+		}
+	}
+
+	resp, err := call[kafka.PollResponseBody](t, c, "n1", map[string]any{"type": "poll", "offsets": map[string]int{"k": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int{{2, 102}, {3, 103}, {4, 104}}; !slices.EqualFunc(resp.Messages["k"], want, slices.Equal) {
+		t.Fatalf("polled %v, want %v", resp.Messages["k"], want)
+	}
+}
+
+// Waits until node's cluster metadata has key set to want's JSON
+func awaitMetadata(t *testing.T, c *sim.Cluster, node string, key string, want any) {
+	t.Helper()
+
+	data, _ := json.Marshal(want)
+	eventually(t, 5*time.Second, func() error {
+		if entry, _ := metadata.Of(c.Node(node)).Get(key); string(entry.Value) != string(data) {
+			return fmt.Errorf("%s has %s = %s, want %s", node, key, entry.Value, data)
+		}
+		return nil
+	})
+}
+
+func TestKafkaSetMembersSpreads(t *testing.T) {
+	c := start(t, 3, kafka.Register)
+
+	send := func(node string, i int) {
+		t.Helper()
+		resp, err := call[kafka.SendResponseBody](t, c, node, map[string]any{"type": "send", "key": "k", "msg": 100 + i})
+		if err != nil || resp.Offset != i {
+			t.Fatalf("send %d through %s got offset %d, %v", i, node, resp.Offset, err)
+		}
+	}
+	send("n2", 0)
+
+	// Sent to n0 alone, the member set reaches the others as cluster metadata
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "set_members", "nodes": []string{"n0", "n1"}}); err != nil {
+		t.Fatal(err)
+	}
+	awaitMetadata(t, c, "n2", "kafka.members", []string{"n0", "n1"})
+
+	for i, node := range []string{"n2", "n1", "n0"} {
+		send(node, i+1)
+	}
+}
+
+func TestKafkaRSM(t *testing.T) {
+	for _, log := range []string{"raft", "paxos", "vr"} {
+		t.Run(log, func(t *testing.T) {
+			t.Setenv("KAFKA_MODE", "rsm")
+			t.Setenv("RSM_LOG", log)
+			c := start(t, 3, kafka.Register)
+
+			// Sends are retried until the log has a leader
+			eventually(t, 5*time.Second, func() error {
+				_, err := call[kafka.SendResponseBody](t, c, "n0", map[string]any{"type": "send", "key": "k", "msg": 100})
+				return err
+			})
+
+			// Whichever node a send goes through, it's appended to the same log
+			for i, node := range []string{"n1", "n2", "n0"} {
+				resp, err := call[kafka.SendResponseBody](t, c, node, map[string]any{"type": "send", "key": "k", "msg": 101 + i})
+				if err != nil || resp.Offset != i+1 {
+					t.Fatalf("send %d through %s got offset %d, %v", i+1, node, resp.Offset, err)
+				}
+			}
+
+			consume := func(node string) kafka.ConsumeResponseBody {
+				t.Helper()
+				resp, err := call[kafka.ConsumeResponseBody](t, c, node, map[string]any{"type": "consume", "group": "g", "keys": []string{"k"}, "limit": 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp
+			}
+
+			// Consumers of a group on different nodes get consecutive batches
+			if resp := consume("n2"); !slices.EqualFunc(resp.Messages["k"], [][]int{{0, 100}, {1, 101}}, slices.Equal) {
+				t.Fatalf("first consume got %v", resp.Messages["k"])
+			}
+			if resp := consume("n1"); !slices.EqualFunc(resp.Messages["k"], [][]int{{2, 102}, {3, 103}}, slices.Equal) || resp.Offsets["k"] != 3 {
+				t.Fatalf("second consume got %v, offsets %v", resp.Messages["k"], resp.Offsets)
+			}
+
+			resp, err := call[kafka.ListCommittedOffsetsResponseBody](t, c, "n0", map[string]any{"type": "list_committed_offsets", "group": "g", "keys": []string{"k", "other"}})
+			if err != nil || !reflect.DeepEqual(resp.Offsets, map[string]int{"k": 3}) {
+				t.Fatalf("committed offsets %v, %v", resp.Offsets, err)
+			}
+		})
+	}
+}
+
+func TestPrimaryBackupFailover(t *testing.T) {
+	c := start(t, 3, primarybackup.Register)
+
+	// n0 is the monitor, which of n1 and n2 is primary depends on whose ping lands first
+	eventually(t, 5*time.Second, func() error {
+		_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10})
+		return err
+	})
+	// Once the backup is in the view, a second write brings it up to date, and the primary reports it synced
+	time.Sleep(500 * time.Millisecond)
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	primary, backup := "n1", "n2"
+	if _, err := call[map[string]any](t, c, primary, map[string]any{"type": "read", "key": 1, "forwarded": true}); err != nil {
+		primary, backup = backup, primary
+	}
+
+	// Cut the primary off from the other nodes, though not from clients
+	c.Filter(func(msg maelstrom.Message) bool {
+		return !slices.Contains(c.NodeIDs(), msg.Dest) || msg.Src != primary && msg.Dest != primary
+	})
+
+	// The backup is promoted with the write, and takes new ones
+	// Timeouts have error code 0, which the client takes for a reply, so the reply's type is checked too
+	eventually(t, 5*time.Second, func() error {
+		resp, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 11})
+		if err == nil && resp["type"] != "write_ok" {
+			err = fmt.Errorf("write got %v", resp)
+		}
+		return err
+	})
+	resp, err := call[primarybackup.ReadResponseBody](t, c, backup, map[string]any{"type": "read", "key": 1})
+	if err != nil || string(resp.Value) != "11" {
+		t.Fatalf("new primary read %s, %v, want 11", resp.Value, err)
+	}
+
+	// The old primary still thinks it's primary: it serves a stale read, and fencing fails its writes
+	resp, err = call[primarybackup.ReadResponseBody](t, c, primary, map[string]any{"type": "read", "key": 1})
+	if err != nil || string(resp.Value) != "10" {
+		t.Fatalf("old primary read %s, %v, want the stale 10", resp.Value, err)
+	}
+	if _, err := call[map[string]any](t, c, primary, map[string]any{"type": "write", "key": 1, "value": 12}); err == nil {
+		t.Fatal("old primary took a write")
+	}
+}
+
+func TestDynamoHintedHandoff(t *testing.T) {
+	c := start(t, 4, dynamo.Register)
+
+	// Returns the value node holds for key 1 as a replica, "" if none
+	held := func(node string) string {
+		resp, err := call[dynamo.GetResponseBody](t, c, node, map[string]any{"type": "dynamo_get", "key": "1"})
+		if err != nil || len(resp.Versions) != 1 {
+			return ""
+		}
+		return string(resp.Versions[0].Value)
+	}
+
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10}); err != nil {
+		t.Fatal(err)
+	}
+	var replicas []string
+	eventually(t, 5*time.Second, func() error {
+		replicas = slices.DeleteFunc(slices.Clone(c.NodeIDs()), func(node string) bool { return held(node) != "10" })
+		if len(replicas) != 3 {
+			return fmt.Errorf("replicas %v, want 3", replicas)
+		}
+		return nil
+	})
+
+	// Cut one replica off from the other nodes, the write goes to the fourth node as a hint instead
+	down := replicas[0]
+	cut := true
+	var mu sync.Mutex
+	c.Filter(func(msg maelstrom.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !cut || !slices.Contains(c.NodeIDs(), msg.Dest) || msg.Src != down && msg.Dest != down
+	})
+
+	coordinator := replicas[1]
+	if _, err := call[map[string]any](t, c, coordinator, map[string]any{"type": "write", "key": 1, "value": 11}); err != nil {
+		t.Fatal(err)
+	}
+	// The write answers after two acks, its put to the cut off replica may not have been sent yet
+	time.Sleep(100 * time.Millisecond)
+	if got := held(down); got != "10" {
+		t.Fatalf("cut off replica holds %q", got)
+	}
+
+	// Once it's back, the stand-in hands the write over
+	mu.Lock()
+	cut = false
+	mu.Unlock()
+	eventually(t, 5*time.Second, func() error {
+		if got := held(down); got != "11" {
+			return fmt.Errorf("recovered replica holds %q, want 11", got)
+		}
+		return nil
+	})
+
+	resp, err := call[dynamo.ReadResponseBody](t, c, down, map[string]any{"type": "read", "key": 1})
+	if err != nil || string(resp.Value) != "11" || len(resp.Siblings) != 0 {
+		t.Fatalf("read %+v, %v, want 11 with no siblings", resp, err)
+	}
+}
+
+func TestDynamoReadRepair(t *testing.T) {
+	// Strict quorums leave no hints to hand off, and every read asks all three replicas
+	t.Setenv("DYNAMO_QUORUM", "strict")
+	t.Setenv("DYNAMO_R", "3")
+	c := start(t, 3, dynamo.Register)
+
+	held := func(node string) string {
+		resp, err := call[dynamo.GetResponseBody](t, c, node, map[string]any{"type": "dynamo_get", "key": "1"})
+		if err != nil || len(resp.Versions) != 1 {
+			return ""
+		}
+		return string(resp.Versions[0].Value)
+	}
+
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10, "version": map[string]int{}}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, func() error {
+		if got := held("n2"); got != "10" {
+			return fmt.Errorf("n2 holds %q", got)
+		}
+		return nil
+	})
+
+	// n2 misses the second write, and nothing brings it back until a read sees it's behind
+	var mu sync.Mutex
+	cut := true
+	c.Filter(func(msg maelstrom.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !cut || !slices.Contains(c.NodeIDs(), msg.Dest) || msg.Src != "n2" && msg.Dest != "n2"
+	})
+	resp, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 11, "version": map[string]int{"n0": 1}})
+	if err != nil || resp["type"] != "write_ok" {
+		t.Fatalf("write %v, %v", resp, err)
+	}
+	// The write answers after two acks, its put to n2 may not have been sent yet
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	cut = false
+	mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+	if got := held("n2"); got != "10" {
+		t.Fatalf("n2 holds %q before any read", got)
+	}
+
+	read, err := call[dynamo.ReadResponseBody](t, c, "n1", map[string]any{"type": "read", "key": 1})
+	if err != nil || string(read.Value) != "11" {
+		t.Fatalf("read %+v, %v, want 11", read, err)
+	}
+	eventually(t, 5*time.Second, func() error {
+		if got := held("n2"); got != "11" {
+			return fmt.Errorf("n2 holds %q after the read, want 11", got)
+		}
+		return nil
+	})
+}
+
+func TestSagaCompensation(t *testing.T) {
+	c := start(t, 2, saga.Register)
+
+	order := []map[string]any{
+		{"op": "add", "key": "stock", "delta": 5},
+		{"op": "write", "key": "price", "value": 10},
+		{"op": "append", "key": "orders", "msg": 1},
+	}
+	resp, err := call[saga.SagaResponseBody](t, c, "n0", map[string]any{"type": "saga", "steps": order})
+	if err != nil || resp.Status != saga.Committed {
+		t.Fatalf("first saga %+v, %v", resp, err)
+	}
+
+	// The last step fails, so the two before it are undone
+	failing := []map[string]any{
+		{"op": "append", "key": "orders", "msg": 2},
+		{"op": "add", "key": "stock", "delta": -2},
+		{"op": "write", "key": "price", "value": 12, "from": 11},
+	}
+	resp, err = call[saga.SagaResponseBody](t, c, "n1", map[string]any{"type": "saga", "steps": failing})
+	if err != nil || resp.Status != saga.Compensated || resp.FailedStep == nil || *resp.FailedStep != 2 {
+		t.Fatalf("failing saga %+v, %v", resp, err)
+	}
+
+	for key, want := range map[string]saga.ReadResponseBody{
+		"stock":  {Value: 5},
+		"price":  {Value: 10},
+		"orders": {List: []int{1}},
+	} {
+		got, err := call[saga.ReadResponseBody](t, c, "n0", map[string]any{"type": "read", "key": key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Value != want.Value || !slices.Equal(got.List, want.List) {
+			t.Fatalf("%s is %d %v, want %d %v", key, got.Value, got.List, want.Value, want.List)
+		}
+	}
+}
+
+func TestPercolator(t *testing.T) {
+	c := start(t, 2, percolator.Register)
+
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "txn", "txn": [][]any{{"w", 1, 10}, {"w", 2, 20}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The secondary commit happens in the background, so n1 may find its lock and roll it forward
+	resp, err := call[map[string]any](t, c, "n1", map[string]any{"type": "txn", "txn": [][]any{{"r", 1, nil}, {"r", 2, nil}, {"w", 1, 11}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(resp["txn"]); got != "[[r 1 10] [r 2 20] [w 1 11]]" {
+		t.Fatalf("n1 got %s", got)
+	}
+
+	resp, err = call[map[string]any](t, c, "n0", map[string]any{"type": "txn", "txn": [][]any{{"r", 1, nil}}})
+	if err != nil || fmt.Sprint(resp["txn"]) != "[[r 1 11]]" {
+		t.Fatalf("n0 got %v, %v", resp["txn"], err)
+	}
+}
+
+func TestTextConcurrentEdits(t *testing.T) {
+	c := start(t, 3, text.Register)
+
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "insert", "pos": 0, "text": "helo"}); err != nil {
+		t.Fatal(err)
+	}
+	read := func(node string) (string, error) {
+		resp, err := call[text.ReadResponseBody](t, c, node, map[string]any{"type": "read"})
+		return resp.Value, err
+	}
+	eventually(t, 5*time.Second, func() error {
+		for _, node := range []string{"n1", "n2"} {
+			if value, err := read(node); err != nil || value != "helo" {
+				return fmt.Errorf("%s read %q, %v", node, value, err)
+			}
+		}
+		return nil
+	})
+
+	// n1 fixes the typo while n2 adds to the end and n0 deletes the h
+	edits := map[string]map[string]any{
+		"n1": {"type": "insert", "pos": 3, "text": "l"},
+		"n2": {"type": "insert", "pos": 4, "text": "!"},
+		"n0": {"type": "delete", "pos": 0, "length": 1},
+	}
+	for node, edit := range edits {
+		if _, err := call[map[string]any](t, c, node, edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, 5*time.Second, func() error {
+		for _, node := range []string{"n0", "n1", "n2"} {
+			if value, err := read(node); err != nil || value != "ello!" {
+				return fmt.Errorf("%s read %q, %v", node, value, err)
+			}
+		}
+		return nil
+	})
+
+	log, err := call[text.ReadLogResponseBody](t, c, "n2", map[string]any{"type": "read_log"})
+	if err != nil || len(log.Ops) != 7 {
+		t.Fatalf("log %+v, %v, want 7 ops", log.Ops, err)
+	}
+
+	_, err = call[map[string]any](t, c, "n0", map[string]any{"type": "delete", "pos": 4, "length": 2})
+	if code := errorCode(err); code != maelstrom.PreconditionFailed {
+		t.Fatalf("delete past the end returned %v, want code %d", err, maelstrom.PreconditionFailed)
+	}
+}
+
+func TestCartConcurrentAddAndRemove(t *testing.T) {
+	for _, mode := range []string{cart.ORSetMode, cart.LWWMode} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv("CART_MODE", mode)
+			c := start(t, 2, cart.Register)
+
+			read := func(node string) ([]string, error) {
+				resp, err := call[cart.ReadCartResponseBody](t, c, node, map[string]any{"type": "read_cart", "cart": "c1"})
+				return resp.Items, err
+			}
+			// Waits for both nodes to have the same items, and returns them
+			converged := func() []string {
+				var items []string
+				eventually(t, 5*time.Second, func() error {
+					a, err := read("n0")
+					if err != nil {
+						return err
+					}
+					b, err := read("n1")
+					if err != nil || !slices.Equal(a, b) {
+						return fmt.Errorf("n0 has %v, n1 has %v, %v", a, b, err)
+					}
+					items = a
+					return nil
+				})
+				return items
+			}
+
+			if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "add_item", "cart": "c1", "item": "apple"}); err != nil {
+				t.Fatal(err)
+			}
+			if items := converged(); !slices.Equal(items, []string{"apple"}) {
+				t.Fatalf("cart has %v, want [apple]", items)
+			}
+
+			// With the nodes cut off from each other, n0 adds bread while n1 removes the apple
+			var mu sync.Mutex
+			cut := true
+			c.Filter(func(msg maelstrom.Message) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return !cut || !slices.Contains(c.NodeIDs(), msg.Src) || !slices.Contains(c.NodeIDs(), msg.Dest)
+			})
+			if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "add_item", "cart": "c1", "item": "bread"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := call[map[string]any](t, c, "n1", map[string]any{"type": "remove_item", "cart": "c1", "item": "apple"}); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			cut = false
+			mu.Unlock()
+
+			// Observed-remove keeps both changes, last-writer-wins keeps one cart and drops the other change
+			items := converged()
+			if got := slices.Equal(items, []string{"bread"}); got != (mode == cart.ORSetMode) {
+				t.Fatalf("cart has %v in %s mode", items, mode)
+			}
+		})
+	}
+}
+
+func TestTxnSetShards(t *testing.T) {
+	t.Setenv("TXN_MODE", "primary")
+	c := start(t, 3, txn.Register)
+
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "set_shards", "nodes": []string{"n1"}}); err != nil {
+		t.Fatal(err)
+	}
+	awaitMetadata(t, c, "n2", "txn.shards", []string{"n1"})
+
+	// Every write now goes through n1, and reaches the other copies from there
+	if _, err := call[map[string]any](t, c, "n2", map[string]any{"type": "txn", "txn": [][]any{{"w", 1, 10}, {"w", 2, 20}}}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, func() error {
+		resp, err := call[map[string]any](t, c, "n0", map[string]any{"type": "txn", "txn": [][]any{{"r", 1, nil}, {"r", 2, nil}}})
+		if err != nil || fmt.Sprint(resp["txn"]) != "[[r 1 10] [r 2 20]]" {
+			return fmt.Errorf("n0 read %v, %v", resp["txn"], err)
+		}
+		return nil
+	})
+
+	_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "set_shards", "nodes": []string{"n7"}})
+	if code := errorCode(err); code != maelstrom.MalformedRequest {
+		t.Fatalf("set_shards with an unknown node returned %v, want code %d", err, maelstrom.MalformedRequest)
+	}
+}
+
+func TestTxnShardedTwoPhaseCommit(t *testing.T) {
+	t.Setenv("TXN_MODE", "sharded")
+	c := start(t, 3, txn.Register)
+
+	// Six keys are spread over the three nodes, so the write commits on all of them through two-phase commit
+	var writes, reads [][]any
+	for key := range 6 {
+		writes = append(writes, []any{"w", key, key * 10})
+		reads = append(reads, []any{"r", key, nil})
+	}
+	if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "txn", "txn": writes}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range c.NodeIDs() {
+		resp, err := call[map[string]any](t, c, id, map[string]any{"type": "txn", "txn": reads})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, op := range resp["txn"].([]any) {
+			if value := op.([]any)[2]; value != float64(key*10) {
+				t.Fatalf("%s read key %d as %v, want %d", id, key, value, key*10)
+			}
+		}
+	}
+}
+
+func TestTxnRSM(t *testing.T) {
+	t.Setenv("TXN_MODE", "rsm")
+	c := start(t, 3, txn.Register)
+
+	eventually(t, 5*time.Second, func() error {
+		_, err := call[map[string]any](t, c, "n1", map[string]any{"type": "txn", "txn": [][]any{{"w", 1, 10}}})
+		return err
+	})
+
+	// Every node reads the other nodes' writes as soon as they're acknowledged
+	if _, err := call[map[string]any](t, c, "n2", map[string]any{"type": "txn", "txn": [][]any{{"r", 1, nil}, {"w", 2, 20}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range c.NodeIDs() {
+		resp, err := call[map[string]any](t, c, id, map[string]any{"type": "txn", "txn": [][]any{{"r", 1, nil}, {"r", 2, nil}}})
+		if err != nil || fmt.Sprint(resp["txn"]) != "[[r 1 10] [r 2 20]]" {
+			t.Fatalf("%s read %v, %v", id, resp["txn"], err)
+		}
+	}
+
+	_, err := call[map[string]any](t, c, "n0", map[string]any{"type": "txn_begin"})
+	if code := errorCode(err); code != maelstrom.NotSupported {
+		t.Fatalf("txn_begin returned %v, want code %d", err, maelstrom.NotSupported)
+	}
+
+	// Expiry would differ between nodes, so TTL writes are refused
+	_, err = call[map[string]any](t, c, "n0", map[string]any{"type": "txn", "txn": [][]any{{"w", 3, 30, 1000}}})
+	if code := errorCode(err); code != maelstrom.NotSupported {
+		t.Fatalf("ttl write returned %v, want code %d", err, maelstrom.NotSupported)
+	}
+}
+
+func TestKVService(t *testing.T) {
+	c := start(t, 1, echo.Register)
+
+	if _, err := call[map[string]any](t, c, "lin-kv", map[string]any{"type": "cas", "key": "a", "from": 1, "to": 2}); errorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("cas of a missing key returned %v", err)
+	}
+	if _, err := call[map[string]any](t, c, "lin-kv", map[string]any{"type": "cas", "key": "a", "from": 1, "to": 2, "create_if_not_exists": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call[map[string]any](t, c, "seq-kv", map[string]any{"type": "cas", "key": "a", "from": 1, "to": 3}); errorCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("cas from a stale value returned %v", err)
+	}
+
+	resp, err := call[map[string]any](t, c, "lww-kv", map[string]any{"type": "read", "key": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["value"] != float64(2) {
+		t.Fatalf("read %v, want 2", resp["value"])
+	}
+}
+
+func TestLWWKV(t *testing.T) {
+	// n0 writes 5, then n1 writes 3
+	for resolver, want := range map[string]string{lwwkv.LastWriterWins: "3", lwwkv.FirstWriterWins: "5", lwwkv.MaxValueWins: "5"} {
+		t.Run(resolver, func(t *testing.T) {
+			t.Setenv("LWWKV_RESOLVER", resolver)
+			c := start(t, 3, lwwkv.Register)
+
+			if _, err := call[map[string]any](t, c, "n2", map[string]any{"type": "cas", "key": 1, "from": 1, "to": 2}); errorCode(err) != maelstrom.KeyDoesNotExist {
+				t.Fatalf("cas of a missing key returned %v", err)
+			}
+
+			for i, id := range c.NodeIDs()[:2] {
+				if _, err := call[map[string]any](t, c, id, map[string]any{"type": "write", "key": 1, "value": 5 - 2*i}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Every node settles on the resolver's pick once gossip has caught up
+			eventually(t, 5*time.Second, func() error {
+				for _, id := range c.NodeIDs() {
+					resp, err := call[lwwkv.ReadResponseBody](t, c, id, map[string]any{"type": "read", "key": 1})
+					if err != nil {
+						return err
+					}
+					if string(resp.Value) != want {
+						return fmt.Errorf("%s read %s, want %s", id, resp.Value, want)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestSeqKV(t *testing.T) {
+	c := start(t, 3, seqkvserver.Register)
+
+	// Primaries never get their writes to the other nodes, so only forwarding and reads from the primary
+	// spread them
+	var mu sync.Mutex
+	var primaries []string
+	c.Filter(func(msg maelstrom.Message) bool {
+		var body maelstrom.MessageBody
+		json.Unmarshal(msg.Body, &body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if body.Type == "seqkv_update" {
+			primaries = append(primaries, msg.Dest)
+		}
+		return body.Type != "seqkv_replicate"
+	})
+
+	read := func(id string, key int) (int, error) {
+		resp, err := call[map[string]any](t, c, id, map[string]any{"type": "read", "key": key})
+		if err != nil {
+			return 0, err
+		}
+		return int(resp["value"].(float64)), nil
+	}
+
+	// A key n0 isn't the primary of, so n0's write is forwarded
+	key := 0
+	for ; len(primaries) == 0; key++ {
+		if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": key + 1, "value": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	primary := primaries[0]
+	other := slices.DeleteFunc(slices.Clone(c.NodeIDs()), func(id string) bool { return id == "n0" || id == primary })[0]
+
+	for _, id := range []string{"n0", primary} {
+		if value, err := read(id, key); err != nil || value != 1 {
+			t.Fatalf("%s read %d, %v, want the forwarded write", id, value, err)
+		}
+	}
+	if _, err := read(other, key); errorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("%s read %v, want it to miss the write it was never sent", other, err)
+	}
+
+	// Each node's reads never go back in time, however far behind its copy is
+	last := map[string]int{}
+	for value := 2; value <= 20; value++ {
+		writer := c.NodeIDs()[value%3]
+		if _, err := call[map[string]any](t, c, writer, map[string]any{"type": "write", "key": key, "value": value}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range c.NodeIDs() {
+			got, err := read(id, key)
+			if errorCode(err) == maelstrom.KeyDoesNotExist && last[id] == 0 {
+				continue
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got < last[id] || (id == writer && got != value) {
+				t.Fatalf("%s read %d after %d, with %d just written by %s", id, got, last[id], value, writer)
+			}
+			last[id] = got
+		}
+	}
+
+	// A node whose cas failed against the primary's newer value reads that value from then on
+	for _, id := range c.NodeIDs() {
+		if last[id] == 20 {
+			continue
+		}
+
+		_, err := call[map[string]any](t, c, id, map[string]any{"type": "cas", "key": key, "from": last[id], "to": 0})
+		if errorCode(err) != maelstrom.PreconditionFailed {
+			t.Fatalf("%s cas from stale %d returned %v", id, last[id], err)
+		}
+		if got, err := read(id, key); err != nil || got != 20 {
+			t.Fatalf("%s read %d, %v after its cas failed, want 20", id, got, err)
+		}
+	}
+}
+
+// Runs broadcast on a lossy deterministic network and returns what was sent and what each node read
+func broadcastRun(t *testing.T, config sim.Config) ([]sim.Event, [][]int) {
+	c := startWith(t, 3, broadcast.Register, config)
+
+	topology := map[string][]string{"n0": {"n1"}, "n1": {"n0", "n2"}, "n2": {"n1"}}
+	for _, id := range c.NodeIDs() {
+		if _, err := call[map[string]any](t, c, id, map[string]any{"type": "topology", "topology": topology}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 10 {
+		if _, err := call[map[string]any](t, c, c.NodeIDs()[i%3], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Advance(2 * time.Second)
+
+	var reads [][]int
+	for _, id := range c.NodeIDs() {
+		resp, err := call[broadcast.ReadResponseBody](t, c, id, map[string]any{"type": "read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads = append(reads, resp.Messages)
+	}
+	return c.Trace(), reads
+}
+
+func TestDeterministicRunsRepeat(t *testing.T) {
+	config := sim.Config{Deterministic: true, Seed: 7, MinLatency: time.Millisecond, MaxLatency: 20 * time.Millisecond, DropRate: 0.2}
+
+	trace, reads := broadcastRun(t, config)
+	again, readsAgain := broadcastRun(t, config)
+
+	if !reflect.DeepEqual(trace, again) {
+		t.Fatalf("runs with the same seed differ: sent %d and %d messages", len(trace), len(again))
+	}
+	if !reflect.DeepEqual(reads, readsAgain) {
+		t.Fatalf("runs with the same seed read %v and %v", reads, readsAgain)
+	}
+	for _, read := range reads {
+		if got := slices.Sorted(slices.Values(read)); len(got) != 10 {
+			t.Fatalf("read %v after gossip settled", got)
+		}
+	}
+
+	config.Seed = 8
+	if other, _ := broadcastRun(t, config); reflect.DeepEqual(trace, other) {
+		t.Fatal("runs with different seeds sent the same messages")
+	}
+}
+
+func TestDeterministicRaft(t *testing.T) {
+	run := func() []sim.Event {
+		c := startWith(t, 3, raft.Register, sim.Config{Deterministic: true, Seed: 3, MinLatency: time.Millisecond, MaxLatency: 5 * time.Millisecond})
+
+		// Until a leader is elected, writes fail or time out
+		for {
+			if _, err := call[map[string]any](t, c, "n0", map[string]any{"type": "write", "key": 1, "value": 10}); err == nil {
+				break
+			}
+			c.Advance(100 * time.Millisecond)
+		}
+
+		resp, err := call[raft.ReadResponseBody](t, c, "n2", map[string]any{"type": "read", "key": 1})
+		if err != nil || string(resp.Value) != "10" {
+			t.Fatalf("read %s, %v", resp.Value, err)
+		}
+		return c.Trace()
+	}
+
+	if trace, again := run(), run(); !reflect.DeepEqual(trace, again) {
+		t.Fatalf("runs with the same seed differ: sent %d and %d messages", len(trace), len(again))
+	}
+}
+
+func TestShrink(t *testing.T) {
+	// Fails whenever messages 3 and 17 are both lost
+	fails := func(drops []int) bool {
+		return slices.Contains(drops, 3) && slices.Contains(drops, 17)
+	}
+
+	drops := []int{1, 2, 3, 5, 8, 13, 17, 21, 34}
+	if got := sim.Shrink(drops, fails); !slices.Equal(got, []int{3, 17}) {
+		t.Fatalf("shrunk to %v, want [3 17]", got)
+	}
+	if got := sim.Shrink([]int{3}, func([]int) bool { return true }); len(got) != 0 {
+		t.Fatalf("shrunk to %v, want nothing", got)
+	}
+}
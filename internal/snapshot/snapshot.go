@@ -0,0 +1,249 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Snapshot and restore
+
+Standard admin RPCs capturing a stateful node's state and putting it back, the same way for every
+workload. Each workload names the parts of its state it can save and restore with Provide; a snapshot is
+a JSON object holding each part under its provider's name, and restoring it hands each part back to the
+provider of that name.
+
+A snapshot can be bigger than a message should be, so both directions go in chunks of at most
+SNAPSHOT_CHUNK_BYTES (default 65536), sent base64-encoded:
+  snapshot  snapshot_id, offset    returns the snapshot's bytes from offset, with its total size and CRC32;
+                                   without a snapshot_id a new snapshot is taken, whose id is passed back
+                                   to fetch the rest. Only the latest snapshot is kept
+  restore   offset, data, total,   appends a chunk to the snapshot being restored, which starts over with
+            checksum               offset 0 and is sent in order; once total bytes have arrived and match
+                                   the checksum, the providers are handed their parts
+
+Restoring merges a part into the node's current state the way the workload merges state from its peers,
+so grow-only state keeps what it has and last-write-wins state keeps newer writes. A snapshot with a part
+no provider on the node knows is refused whole.
+*/
+
+var logger = logging.For("snapshot")
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// SNAPSHOT_CHUNK_BYTES, the most snapshot bytes sent in one message (default 65536)
+	ChunkBytes int `env:"SNAPSHOT_CHUNK_BYTES" min:"1"`
+}
+
+type SnapshotRequestBody struct {
+	Type       string `json:"type"`
+	SnapshotID int    `json:"snapshot_id,omitempty"`
+	Offset     int    `json:"offset"`
+}
+
+type SnapshotResponseBody struct {
+	Type       string `json:"type"`
+	SnapshotID int    `json:"snapshot_id"`
+	Offset     int    `json:"offset"`
+	Data       []byte `json:"data"`
+	Total      int    `json:"total"`
+	Checksum   uint32 `json:"checksum"`
+}
+
+type RestoreRequestBody struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Data     []byte `json:"data"`
+	Total    int    `json:"total"`
+	Checksum uint32 `json:"checksum"`
+}
+
+type RestoreResponseBody struct {
+	Type     string `json:"type"`
+	Received int    `json:"received"`
+	Done     bool   `json:"done"`
+}
+
+type provider struct {
+	save    func() (any, error)
+	restore func(data json.RawMessage) error
+}
+
+// A taken snapshot, kept while it's being fetched
+type taken struct {
+	id       int
+	data     []byte
+	checksum uint32
+}
+
+type Snapshots struct {
+	mu        sync.Mutex
+	providers map[string]provider
+	latest    taken
+	restoring []byte // chunks of the snapshot being restored received so far
+}
+
+var (
+	mu        sync.Mutex
+	snapshots = map[*maelstrom.Node]*Snapshots{}
+)
+
+// Returns node's snapshot providers
+func Of(node *maelstrom.Node) *Snapshots {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := snapshots[node]
+	if !ok {
+		s = &Snapshots{providers: make(map[string]provider)}
+		snapshots[node] = s
+	}
+	return s
+}
+
+// Adds name to snapshots: save returns its part, marshalled to JSON, and restore merges a part back in
+func (s *Snapshots) Provide(name string, save func() (any, error), restore func(data json.RawMessage) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[name] = provider{save: save, restore: restore}
+}
+
+// Returns every provider's part, as a JSON object
+func (s *Snapshots) Save() ([]byte, error) {
+	s.mu.Lock()
+	providers := maps.Clone(s.providers)
+	s.mu.Unlock()
+
+	parts := make(map[string]any)
+	for name, p := range providers {
+		part, err := p.save()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		parts[name] = part
+	}
+	return json.Marshal(parts)
+}
+
+// Hands every part of a snapshot Save returned to its provider, in name order
+func (s *Snapshots) Restore(data []byte) error {
+	var parts map[string]json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	providers := maps.Clone(s.providers)
+	s.mu.Unlock()
+
+	for name := range parts {
+		if _, ok := providers[name]; !ok {
+			return fmt.Errorf("no provider for %q", name)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(parts)) {
+		if err := providers[name].restore(parts[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the chunk of the snapshot with id starting at offset, taking a new snapshot if id is 0
+func (s *Snapshots) chunk(id int, offset int, size int) (SnapshotResponseBody, error) {
+	if id == 0 {
+		data, err := s.Save()
+		if err != nil {
+			return SnapshotResponseBody{}, err
+		}
+
+		s.mu.Lock()
+		s.latest = taken{id: s.latest.id + 1, data: data, checksum: crc32.ChecksumIEEE(data)}
+		s.mu.Unlock()
+		logger.Info("took snapshot", "bytes", len(data))
+	}
+
+	s.mu.Lock()
+	latest := s.latest
+	s.mu.Unlock()
+
+	if id != 0 && id != latest.id {
+		return SnapshotResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+			fmt.Sprintf("snapshot %d is gone, the latest is %d", id, latest.id))
+	}
+	if offset < 0 || offset > len(latest.data) {
+		return SnapshotResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest,
+			fmt.Sprintf("offset %d is outside the snapshot's %d bytes", offset, len(latest.data)))
+	}
+
+	return SnapshotResponseBody{
+		SnapshotID: latest.id,
+		Offset:     offset,
+		Data:       latest.data[offset:min(offset+size, len(latest.data))],
+		Total:      len(latest.data),
+		Checksum:   latest.checksum,
+	}, nil
+}
+
+// Adds a chunk of a snapshot being restored, restoring it once the last one is in
+func (s *Snapshots) receive(body RestoreRequestBody) (RestoreResponseBody, error) {
+	s.mu.Lock()
+	if body.Offset == 0 {
+		s.restoring = nil
+	}
+	if body.Offset != len(s.restoring) {
+		received := len(s.restoring)
+		s.mu.Unlock()
+		return RestoreResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+			fmt.Sprintf("expected the chunk at offset %d, got %d", received, body.Offset))
+	}
+
+	s.restoring = append(s.restoring, body.Data...)
+	data := s.restoring
+	if len(data) < body.Total {
+		s.mu.Unlock()
+		return RestoreResponseBody{Received: len(data)}, nil
+	}
+	s.restoring = nil
+	s.mu.Unlock()
+
+	if len(data) != body.Total || crc32.ChecksumIEEE(data) != body.Checksum {
+		return RestoreResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest,
+			"snapshot doesn't match its size and checksum")
+	}
+
+	if err := s.Restore(data); err != nil {
+		return RestoreResponseBody{}, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	logger.Info("restored snapshot", "bytes", len(data))
+	return RestoreResponseBody{Received: len(data), Done: true}, nil
+}
+
+// Registers the snapshot and restore handlers on n
+func Register(n *maelstrom.Node) error {
+	cfg := Config{ChunkBytes: 65536}
+	if err := config.Load("snapshot", &cfg); err != nil {
+		return err
+	}
+	s := Of(n)
+
+	handler.Handle(n, "snapshot", func(msg maelstrom.Message, body SnapshotRequestBody) (SnapshotResponseBody, error) {
+		return s.chunk(body.SnapshotID, body.Offset, cfg.ChunkBytes)
+	})
+
+	handler.Handle(n, "restore", func(msg maelstrom.Message, body RestoreRequestBody) (RestoreResponseBody, error) {
+		return s.receive(body)
+	})
+
+	return nil
+}
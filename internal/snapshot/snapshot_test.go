@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Provides a list of ints that restoring appends to
+func provideList(s *Snapshots, list *[]int) {
+	s.Provide("list", func() (any, error) {
+		return *list, nil
+	}, func(data json.RawMessage) error {
+		var restored []int
+		if err := json.Unmarshal(data, &restored); err != nil {
+			return err
+		}
+		*list = append(*list, restored...)
+		return nil
+	})
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	source := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	from := Of(maelstrom.NewNode())
+	provideList(from, &source)
+
+	var restored []int
+	to := Of(maelstrom.NewNode())
+	provideList(to, &restored)
+
+	// Fetch the snapshot a few bytes at a time and restore it chunk by chunk
+	chunk, err := from.chunk(0, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		resp, err := to.receive(RestoreRequestBody{Offset: chunk.Offset, Data: chunk.Data, Total: chunk.Total, Checksum: chunk.Checksum})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Done {
+			break
+		}
+		if chunk, err = from.chunk(chunk.SnapshotID, resp.Received, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !slices.Equal(restored, source) {
+		t.Fatalf("restored %v, want %v", restored, source)
+	}
+}
+
+func TestRestoreRejects(t *testing.T) {
+	var list []int
+	s := Of(maelstrom.NewNode())
+	provideList(s, &list)
+
+	code := func(err error) int {
+		var rpcErr *maelstrom.RPCError
+		if !errors.As(err, &rpcErr) {
+			return -1
+		}
+		return rpcErr.Code
+	}
+
+	data := []byte(`{"list":[1]}`)
+	if _, err := s.receive(RestoreRequestBody{Offset: 3, Data: data, Total: len(data)}); code(err) != maelstrom.PreconditionFailed {
+		t.Errorf("chunk out of order: %v", err)
+	}
+	if _, err := s.receive(RestoreRequestBody{Data: data, Total: len(data), Checksum: 1}); code(err) != maelstrom.MalformedRequest {
+		t.Errorf("bad checksum: %v", err)
+	}
+
+	snapshot, _ := json.Marshal(map[string]any{"list": []int{1}, "other": 2})
+	if err := s.Restore(snapshot); err == nil {
+		t.Error("restored a snapshot with a part nothing provides")
+	}
+	if len(list) != 0 {
+		t.Errorf("list %v after rejected restores", list)
+	}
+}
+
+func TestOldSnapshotIsGone(t *testing.T) {
+	s := Of(maelstrom.NewNode())
+	first, err := s.chunk(0, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.chunk(0, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.chunk(first.SnapshotID, 1, 1); err == nil {
+		t.Fatal("fetched a chunk of a replaced snapshot")
+	}
+}
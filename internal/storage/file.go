@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+)
+
+/*
+File storage
+
+Values and log records are both written as a line holding the CRC32 of the data in hex, a space and the
+data. A value whose checksum doesn't match is ErrCorrupt. Opening a log reads records up to the first one
+that's incomplete or fails its checksum and truncates the log there, dropping everything after it:
+records are only ever appended, so a bad one can only be the last write before a crash.
+
+Every Put, and every Append with fsync set, reaches the disk before it returns. Put and Discard write the
+new contents next to the old file and rename them over it, so a crash part way leaves one or the other.
+*/
+
+var logger = logging.For("storage")
+
+type File struct {
+	dir   string
+	fsync bool
+}
+
+// Returns a store keeping its files in dir, which must exist
+func NewFile(dir string, fsync bool) *File {
+	return &File{dir: dir, fsync: fsync}
+}
+
+// Keys and log names can hold any characters, they're escaped to make file names
+func (f *File) path(name string) string {
+	return filepath.Join(f.dir, url.PathEscape(name))
+}
+
+func (f *File) Get(key string) ([]byte, error) {
+	line, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, ok := unframe(bytes.TrimSuffix(line, []byte("\n")))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, f.path(key))
+	}
+	return data, nil
+}
+
+func (f *File) Put(key string, value []byte) error {
+	path := f.path(key)
+	if err := writeFileSynced(path+".tmp", frame(value)); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (f *File) Delete(key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *File) OpenLog(name string) (Log, [][]byte, error) {
+	path := f.path(name)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, size, err := readLog(file, path)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	// Drop a torn record at the end so new records aren't appended after garbage
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &fileLog{path: path, file: file, size: size, fsync: f.fsync}, records, nil
+}
+
+// Files are opened as they're used, there's nothing to close
+func (f *File) Close() error {
+	return nil
+}
+
+// Returns data framed with its checksum, as a line
+func frame(data []byte) []byte {
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(data), data)
+}
+
+// Returns the data in a line without its newline, false if its checksum doesn't match
+func unframe(line []byte) ([]byte, bool) {
+	checksum, data, ok := bytes.Cut(line, []byte(" "))
+	if !ok || string(checksum) != fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) {
+		return nil, false
+	}
+	return data, true
+}
+
+// Returns the intact records at the start of r and their total size in bytes
+func readLog(r io.Reader, path string) ([][]byte, int64, error) {
+	records := [][]byte{}
+	reader := bufio.NewReader(r)
+	var size int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logger.Warn("dropping incomplete record", "log", path, "offset", size)
+			}
+			return records, size, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		data, ok := unframe(bytes.TrimSuffix(line, []byte("\n")))
+		if !ok {
+			logger.Warn("dropping corrupt record and everything after it", "log", path, "offset", size)
+			return records, size, nil
+		}
+
+		records = append(records, data)
+		size += int64(len(line))
+	}
+}
+
+type fileLog struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	size  int64 // bytes of intact records in the file
+	fsync bool
+}
+
+func (l *fileLog) Append(record []byte) error {
+	if bytes.IndexByte(record, '\n') >= 0 {
+		return errors.New("storage: log records can't contain newlines")
+	}
+	line := frame(record)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	l.size += int64(len(line))
+
+	if l.fsync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+func (l *fileLog) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// The remaining records are copied to a new file that replaces the log
+func (l *fileLog) Discard(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tail := make([]byte, l.size-offset)
+	if _, err := l.file.ReadAt(tail, offset); err != nil {
+		return err
+	}
+
+	if err := writeFileSynced(l.path+".tmp", tail); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path+".tmp", l.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file.Close()
+	l.file = file
+	l.size -= offset
+	return nil
+}
+
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Writes data to a new file at path and fsyncs it
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
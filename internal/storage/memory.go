@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// Keeps everything in memory, a log's offsets count records rather than bytes
+type Memory struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	logs   map[string]*memoryLog
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		values: make(map[string][]byte),
+		logs:   make(map[string]*memoryLog),
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(value), nil
+}
+
+func (m *Memory) Put(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = slices.Clone(value)
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+// Opening a log again returns the same log, with the records appended to it so far
+func (m *Memory) OpenLog(name string) (Log, [][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.logs[name]
+	if !ok {
+		l = &memoryLog{}
+		m.logs[name] = l
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := make([][]byte, len(l.records))
+	for i, record := range l.records {
+		records[i] = slices.Clone(record)
+	}
+	return l, records, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+type memoryLog struct {
+	mu      sync.Mutex
+	base    int64 // offset of records[0]
+	records [][]byte
+}
+
+func (l *memoryLog) Append(record []byte) error {
+	if bytes.IndexByte(record, '\n') >= 0 {
+		return errors.New("storage: log records can't contain newlines")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, slices.Clone(record))
+	return nil
+}
+
+func (l *memoryLog) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.base + int64(len(l.records))
+}
+
+func (l *memoryLog) Discard(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset < l.base || offset > l.base+int64(len(l.records)) {
+		return errors.New("storage: offset out of range")
+	}
+	l.records = slices.Clone(l.records[offset-l.base:])
+	l.base = offset
+	return nil
+}
+
+func (l *memoryLog) Close() error {
+	return nil
+}
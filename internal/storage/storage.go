@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Storage
+
+Where a node keeps state that has to survive it crashing: a key-value map of blobs, each replaced whole
+by a Put, and append-only logs of records, handed back in order when the log is opened again. Two
+implementations:
+  - File, a directory holding a file per key and per log, named after it (see file.go). A Put writes a
+    new file and renames it over the old one, and log records are appended as lines prefixed with their
+    CRC32, so a torn record at the end of a log, which is what a crash in the middle of an append leaves,
+    is noticed and dropped when the log is opened
+  - Memory, maps and slices that last as long as the process, for tests and nodes that don't need more
+
+Of returns a node's store, picked with STORAGE_BACKEND: memory (default) or file, which keeps the files
+in STORAGE_DIR/<node id> and, with STORAGE_FSYNC set, syncs every Put and Append to disk before it
+returns. It needs the node's ID, so it can only be called once the node has been initialised.
+*/
+
+var (
+	ErrNotFound = errors.New("storage: not found")
+	ErrCorrupt  = errors.New("storage: corrupt")
+)
+
+type Store interface {
+	// Returns the value of key, ErrNotFound if it has none
+	Get(key string) ([]byte, error)
+
+	// Sets key to value, a crash part way leaves either the old value or the new one
+	Put(key string, value []byte) error
+
+	// Removes key, if it has a value
+	Delete(key string) error
+
+	// Opens the log name, creating it if it's new, and returns it with every intact record in it
+	OpenLog(name string) (Log, [][]byte, error)
+
+	Close() error
+}
+
+type Log interface {
+	// Appends a record, which can't contain a newline (JSON without indentation never does)
+	Append(record []byte) error
+
+	// Returns the offset the next record will be appended at
+	Size() int64
+
+	// Drops the records before offset, an offset Size returned earlier, keeping the ones after it
+	Discard(offset int64) error
+
+	Close() error
+}
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// STORAGE_BACKEND, memory (default) or file
+	Backend string `env:"STORAGE_BACKEND" oneof:"memory|file"`
+
+	// STORAGE_DIR, the directory the file backend keeps each node's files under
+	Dir string `env:"STORAGE_DIR"`
+
+	// STORAGE_FSYNC, if true the file backend syncs every write to disk
+	Fsync bool `env:"STORAGE_FSYNC"`
+}
+
+// The file backend needs a directory
+func (c *Config) Validate() error {
+	if c.Backend == "file" && c.Dir == "" {
+		return errors.New("STORAGE_DIR must be set for the file backend")
+	}
+	return nil
+}
+
+var (
+	mu     sync.Mutex
+	stores = map[*maelstrom.Node]Store{}
+)
+
+// Returns node's store, opening it the first time, which has to be after node is initialised
+func Of(node *maelstrom.Node) (Store, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s, ok := stores[node]; ok {
+		return s, nil
+	}
+	if node.ID() == "" {
+		return nil, errors.New("storage: node isn't initialised yet")
+	}
+
+	cfg := Config{Backend: "memory"}
+	if err := config.Load("storage", &cfg); err != nil {
+		return nil, err
+	}
+
+	var s Store = NewMemory()
+	if cfg.Backend == "file" {
+		dir := filepath.Join(cfg.Dir, node.ID())
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+		s = NewFile(dir, cfg.Fsync)
+	}
+
+	stores[node] = s
+	return s, nil
+}
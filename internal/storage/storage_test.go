@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFilePutGet(t *testing.T) {
+	f := NewFile(t.TempDir(), false)
+
+	if _, err := f.Get("a/b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing key: %v, want ErrNotFound", err)
+	}
+	if err := f.Put("a/b", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Put("a/b", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := f.Get("a/b"); err != nil || string(value) != "two" {
+		t.Fatalf("Get = %q, %v, want two", value, err)
+	}
+
+	if err := f.Delete("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Get("a/b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileCorruptValue(t *testing.T) {
+	dir := t.TempDir()
+	f := NewFile(dir, false)
+	if err := f.Put("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte("00000000 value\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Get("key"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Get of a corrupt value: %v, want ErrCorrupt", err)
+	}
+}
+
+func TestFileLogDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	f := NewFile(dir, true)
+
+	log, records, err := f.OpenLog("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("new log has %d records", len(records))
+	}
+	for _, record := range []string{"a", "b"} {
+		if err := log.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// A crash part way through appending a record
+	file, err := os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("1234abcd c"))
+	file.Close()
+
+	log, records, err = f.OpenLog("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	if _, records, err = f.OpenLog("log"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings(records); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("records %q, want [a b c]", got)
+	}
+}
+
+func TestLogDiscard(t *testing.T) {
+	stores := map[string]Store{"file": NewFile(t.TempDir(), false), "memory": NewMemory()}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			log, _, err := store.OpenLog("log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			log.Append([]byte("a"))
+			offset := log.Size()
+			log.Append([]byte("b"))
+
+			if err := log.Discard(offset); err != nil {
+				t.Fatal(err)
+			}
+			log.Append([]byte("c"))
+			log.Close()
+
+			_, records, err := store.OpenLog("log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings(records); !slices.Equal(got, []string{"b", "c"}) {
+				t.Fatalf("records %q, want [b c]", got)
+			}
+		})
+	}
+}
+
+func TestLogRejectsNewlines(t *testing.T) {
+	stores := map[string]Store{"file": NewFile(t.TempDir(), false), "memory": NewMemory()}
+	for name, store := range stores {
+		log, _, err := store.OpenLog("log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Append([]byte("a\nb")); err == nil {
+			t.Errorf("%s: appended a record with a newline", name)
+		}
+		log.Close()
+	}
+}
+
+func strings(records [][]byte) []string {
+	s := make([]string, len(records))
+	for i, record := range records {
+		s[i] = string(record)
+	}
+	return s
+}
@@ -0,0 +1,205 @@
+package text
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/crdt"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/gossip"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Collaborative text
+
+Goal: a text document every node can edit at once without coordinating, that ends up the same on every node
+
+The document is a replicated growable array of characters (see crdt.RGA). An insert or delete at a position
+is applied locally and answered straight away, then gossiped to every other node, which merges it wherever
+its neighbours have moved to by then: a character stays right after the one it was typed after, and
+characters typed concurrently at the same spot are ordered the same way everywhere. Reads are local, so
+they only eventually include edits made on other nodes.
+
+Every edit made on this node is journaled before it's acknowledged and replayed on init (see
+internal/storage), so a restarted node keeps its own edits and numbers new ones past them; edits made
+elsewhere come back through gossip. read_log returns every insert and delete the node has seen.
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// TEXT_INTERVAL_MS, how often new edits are gossiped to the other nodes (default 100)
+	Interval time.Duration `env:"TEXT_INTERVAL_MS" min:"1"`
+}
+
+// Insert RPC, inserts text so it starts at position pos
+type InsertRequestBody struct {
+	Type string `json:"type"`
+	Pos  int    `json:"pos"`
+	Text string `json:"text"`
+}
+
+func (b InsertRequestBody) Validate() error {
+	if b.Pos < 0 {
+		return errors.New("pos must not be negative")
+	}
+	if b.Text == "" {
+		return errors.New("text must not be empty")
+	}
+	return nil
+}
+
+type InsertResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Delete RPC, deletes length characters from position pos on
+type DeleteRequestBody struct {
+	Type   string `json:"type"`
+	Pos    int    `json:"pos"`
+	Length int    `json:"length"`
+}
+
+func (b DeleteRequestBody) Validate() error {
+	if b.Pos < 0 {
+		return errors.New("pos must not be negative")
+	}
+	if b.Length < 1 {
+		return errors.New("length must be at least 1")
+	}
+	return nil
+}
+
+type DeleteResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Read RPC
+type ReadRequestBody struct {
+	Type string `json:"type"`
+}
+
+type ReadResponseBody struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Read log RPC, every op seen, oldest first
+type ReadLogRequestBody struct {
+	Type string `json:"type"`
+}
+
+type ReadLogResponseBody struct {
+	Type string               `json:"type"`
+	Ops  []crdt.RGAOp[string] `json:"ops"`
+}
+
+// Registers the text handlers on n and starts gossiping
+func Register(n *maelstrom.Node) error {
+	cfg := Config{Interval: 100 * time.Millisecond}
+	if err := config.Load("text", &cfg); err != nil {
+		return err
+	}
+
+	document := crdt.NewRGA[string]()
+
+	engine := gossip.New(n, gossip.Store[crdt.RGAState[string]](document), gossip.Config{
+		Name:        "text",
+		Interval:    cfg.Interval,
+		Timeout:     500 * time.Millisecond,
+		DigestEvery: 10,
+	})
+
+	var journalMu sync.Mutex
+	var journal storage.Log
+
+	// Replay this node's own edits, then gossip with every other node
+	n.Handle("init", func(msg maelstrom.Message) error {
+		store, err := storage.Of(n)
+		if err != nil {
+			return err
+		}
+		log, records, err := store.OpenLog("text.ops")
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			var delta crdt.RGAState[string]
+			if err := json.Unmarshal(record, &delta); err != nil {
+				return err
+			}
+			document.Merge(delta)
+		}
+
+		journalMu.Lock()
+		journal = log
+		journalMu.Unlock()
+
+		engine.SetPeers(slices.DeleteFunc(slices.Clone(n.NodeIDs()), func(id string) bool {
+			return id == n.ID()
+		}))
+		return nil
+	})
+
+	// Journals a local edit and queues it for the other nodes. The edit is gossiped even if the journal
+	// fails, it's already visible here and the others should see it too
+	publish := func(delta crdt.RGAState[string]) error {
+		journalMu.Lock()
+		log := journal
+		journalMu.Unlock()
+
+		var err error
+		if log != nil {
+			var data []byte
+			if data, err = json.Marshal(delta); err == nil {
+				err = log.Append(data)
+			}
+		}
+		engine.Update(delta)
+		return err
+	}
+
+	handler.Handle(n, "insert", func(msg maelstrom.Message, body InsertRequestBody) (InsertResponseBody, error) {
+		delta, err := document.Insert(n.ID(), body.Pos, strings.Split(body.Text, "")...)
+		if errors.Is(err, crdt.ErrOutOfRange) {
+			return InsertResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, err.Error())
+		} else if err != nil {
+			return InsertResponseBody{}, err
+		}
+		return InsertResponseBody{}, publish(delta)
+	})
+
+	handler.Handle(n, "delete", func(msg maelstrom.Message, body DeleteRequestBody) (DeleteResponseBody, error) {
+		delta, err := document.Delete(n.ID(), body.Pos, body.Length)
+		if errors.Is(err, crdt.ErrOutOfRange) {
+			return DeleteResponseBody{}, maelstrom.NewRPCError(maelstrom.PreconditionFailed, err.Error())
+		} else if err != nil {
+			return DeleteResponseBody{}, err
+		}
+		return DeleteResponseBody{}, publish(delta)
+	})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body ReadRequestBody) (ReadResponseBody, error) {
+		return ReadResponseBody{Value: strings.Join(document.Elements(), "")}, nil
+	})
+
+	handler.Handle(n, "read_log", func(msg maelstrom.Message, body ReadLogRequestBody) (ReadLogResponseBody, error) {
+		return ReadLogResponseBody{Ops: document.Log()}, nil
+	})
+
+	admin.Of(n).Register("text", admin.Module{Status: func() any {
+		return map[string]any{"length": document.Len(), "ops": len(document.Log())}
+	}})
+
+	lifecycle.Of(n).Go(engine.Run)
+	return nil
+}
@@ -0,0 +1,121 @@
+package truetime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+TrueTime
+
+Spanner's clock API. No node knows the true time exactly, but if its clock is known to be within epsilon
+of it, Now can return an interval [now - epsilon, now + epsilon] that's certain to contain it. After(t) is
+then true only once t has certainly passed, and Before(t) only while it certainly hasn't arrived.
+
+That's enough for external consistency: a transaction takes s = Now().Latest as its commit timestamp and
+doesn't acknowledge the commit until After(s) (commit wait), which takes about 2*epsilon. Any transaction
+that starts after the acknowledgement, on any node, takes a timestamp of at least the true time at its
+start, which is after s, so the order of commit timestamps agrees with the order transactions happened in.
+
+The true time here is the node's clock (see internal/env), which every node in a Maelstrom run shares.
+To simulate clocks that aren't in sync, each node's clock is off from it by a fixed skew drawn from
+[-TRUETIME_SKEW_MS, TRUETIME_SKEW_MS] (default 5), and Now widens that skewed reading by
+TRUETIME_EPSILON_MS (default 5). Setting the skew above epsilon breaks the bound, and commit wait with it.
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// TRUETIME_EPSILON_MS, the uncertainty bound Now reports (default 5)
+	Epsilon time.Duration `env:"TRUETIME_EPSILON_MS" min:"0"`
+
+	// TRUETIME_SKEW_MS, the largest offset of a node's clock from the true time (default 5)
+	Skew time.Duration `env:"TRUETIME_SKEW_MS" min:"0"`
+}
+
+// A range of times the true time is certain to be in, unless a clock is skewed beyond epsilon
+type Interval struct {
+	Earliest time.Time
+	Latest   time.Time
+}
+
+type Clock struct {
+	clock   env.Clock
+	epsilon time.Duration
+	skew    time.Duration // this node's offset from the true time
+}
+
+// Returns a clock reading clock off by skew and reporting epsilon of uncertainty
+func New(clock env.Clock, epsilon time.Duration, skew time.Duration) *Clock {
+	return &Clock{clock: clock, epsilon: epsilon, skew: skew}
+}
+
+var (
+	mu     sync.Mutex
+	clocks = map[*maelstrom.Node]*Clock{}
+)
+
+// Returns node's clock, drawing its skew the first time
+func Of(node *maelstrom.Node) (*Clock, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c, ok := clocks[node]; ok {
+		return c, nil
+	}
+
+	cfg := Config{Epsilon: 5 * time.Millisecond, Skew: 5 * time.Millisecond}
+	if err := config.Load("truetime", &cfg); err != nil {
+		return nil, err
+	}
+
+	e := env.Of(node)
+	skew := time.Duration(0)
+	if cfg.Skew > 0 {
+		skew = time.Duration(e.Rand.Int64N(int64(2*cfg.Skew)+1)) - cfg.Skew
+	}
+
+	c := New(e.Clock, cfg.Epsilon, skew)
+	clocks[node] = c
+	return c, nil
+}
+
+// Returns an interval containing the true time
+func (c *Clock) Now() Interval {
+	now := c.clock.Now().Add(c.skew)
+	return Interval{Earliest: now.Add(-c.epsilon), Latest: now.Add(c.epsilon)}
+}
+
+// Returns true if t has certainly passed
+func (c *Clock) After(t time.Time) bool {
+	return c.Now().Earliest.After(t)
+}
+
+// Returns true if t has certainly not arrived yet
+func (c *Clock) Before(t time.Time) bool {
+	return c.Now().Latest.Before(t)
+}
+
+// Returns once After(t) is true, or with ctx's error if it ends first
+func (c *Clock) WaitUntilAfter(ctx context.Context, t time.Time) error {
+	for !c.After(t) {
+		// Earliest only passes t a moment after it reaches it
+		wait := max(t.Sub(c.Now().Earliest), time.Microsecond)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(wait):
+		}
+	}
+	return nil
+}
+
+// Returns this node's offset from the true time
+func (c *Clock) Skew() time.Duration {
+	return c.skew
+}
@@ -0,0 +1,64 @@
+package truetime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/fake"
+)
+
+func TestNow(t *testing.T) {
+	clock := fake.NewClock(time.UnixMilli(1000))
+	tt := New(clock, 5*time.Millisecond, -2*time.Millisecond)
+
+	now := tt.Now()
+	if want := time.UnixMilli(993); !now.Earliest.Equal(want) {
+		t.Errorf("earliest = %v, want %v", now.Earliest, want)
+	}
+	if want := time.UnixMilli(1003); !now.Latest.Equal(want) {
+		t.Errorf("latest = %v, want %v", now.Latest, want)
+	}
+
+	ts := now.Latest
+	if tt.After(ts) || tt.Before(ts) {
+		t.Fatal("the latest bound is neither certainly past nor certainly future")
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	if tt.After(ts) {
+		t.Fatal("after 2*epsilon the timestamp is certainly past, but only just")
+	}
+	clock.Advance(time.Millisecond)
+	if !tt.After(ts) {
+		t.Fatal("the timestamp should have certainly passed")
+	}
+	if !tt.Before(time.UnixMilli(1100)) {
+		t.Fatal("a time well ahead should certainly not have arrived")
+	}
+}
+
+func TestWaitUntilAfter(t *testing.T) {
+	tt := New(env.Real{}, 5*time.Millisecond, 0)
+
+	start := time.Now()
+	ts := tt.Now().Latest
+	if err := tt.WaitUntilAfter(context.Background(), ts); err != nil {
+		t.Fatal(err)
+	}
+	if !tt.After(ts) {
+		t.Fatal("returned before the timestamp had certainly passed")
+	}
+	if waited := time.Since(start); waited < 10*time.Millisecond {
+		t.Errorf("waited %v, want at least 2*epsilon", waited)
+	}
+
+	// A wait on a clock that never moves ends with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stuck := New(fake.NewClock(time.UnixMilli(1000)), 5*time.Millisecond, 0)
+	if err := stuck.WaitUntilAfter(ctx, time.UnixMilli(1000)); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
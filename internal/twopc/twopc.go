@@ -0,0 +1,477 @@
+package twopc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Two-phase commit
+
+A transaction that changes state on several nodes either takes effect on all of them or on none. The node
+running it is the coordinator, and every node holding part of it is a participant, whose part is
+prepared, committed or aborted by a Resource the module using this package provides:
+  1. prepare: the coordinator sends each participant its part with 2pc_prepare, and the participant's
+     resource validates and locks it and votes yes, or votes no
+  2. the coordinator decides commit if every participant voted yes within Config.PrepareTimeout, abort
+     otherwise
+  3. the decision is sent with 2pc_commit or 2pc_abort, commits are retried every Config.RetryDelay until
+     every participant acknowledges
+
+A participant that has voted yes can't decide on its own. If it hasn't heard the decision within
+Config.ParticipantTimeout it asks the coordinator with 2pc_status. The coordinator answers with its recorded
+decision, and if it has none it answers abort, recording it if the transaction is still collecting votes so
+a late commit decision can never contradict the answer (presumed abort). Only commits therefore need to be
+remembered and retried; a lost abort is recovered by the participant asking. An abort is forgotten as soon
+as it's decided and a commit once every participant has acknowledged it, after which none of them asks.
+
+Remembering commits across a coordinator restart takes the coordinator log, opened with Open: a commit
+decision is appended to it before any participant hears of it, and an end record once they all have. A
+restarted coordinator replays it and sends again the commits without an end record, answering 2pc_status
+for them until they're acknowledged. Every other transaction it was running never decided commit, so presuming abort is
+still right for them. Transaction IDs carry the number of times the log has been opened, so IDs handed out
+before a restart aren't reused after it. Once no commit is waiting for acknowledgements the log is cut
+back to the open count. Without a log a coordinator that restarts answers abort for everything, even for a
+commit some participants already applied.
+*/
+
+var logger = logging.For("twopc")
+
+// A participant's store of transaction parts
+type Resource interface {
+	// Validates and locks the transaction's part, an error votes no
+	Prepare(txnID string, part json.RawMessage) error
+
+	// Applies the decision for a part Prepare voted yes on, once
+	Finish(txnID string, commit bool)
+}
+
+type Config struct {
+	// How long the coordinator waits for a vote, or any other answer to one of its messages
+	PrepareTimeout time.Duration
+
+	// How long a participant that voted yes waits for the decision before asking the coordinator
+	ParticipantTimeout time.Duration
+
+	// How long to wait before sending a commit, or asking for a decision, again
+	RetryDelay time.Duration
+}
+
+// Prepare RPC, the coordinator handing a participant its part of a transaction
+type PrepareRequestBody struct {
+	Type        string          `json:"type"`
+	TxnID       string          `json:"txn_id"`
+	Coordinator string          `json:"coordinator"`
+	Part        json.RawMessage `json:"part"`
+}
+
+type PrepareResponseBody struct {
+	Type string `json:"type"`
+	Vote bool   `json:"vote"`
+}
+
+// Decision RPC, sent by the coordinator with type 2pc_commit or 2pc_abort
+type DecisionRequestBody struct {
+	Type  string `json:"type"`
+	TxnID string `json:"txn_id"`
+}
+
+type DecisionResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Status RPC, sent by a participant that timed out waiting for the decision
+type StatusRequestBody struct {
+	Type  string `json:"type"`
+	TxnID string `json:"txn_id"`
+}
+
+type StatusResponseBody struct {
+	Type      string `json:"type"`
+	Committed bool   `json:"committed"`
+}
+
+// A node's coordinator and participant
+type Committer struct {
+	node     *maelstrom.Node
+	env      *env.Env
+	resource Resource
+	cfg      Config
+	nextID   atomic.Int64
+
+	mu          sync.Mutex
+	log         storage.Log         // coordinator log, nil until opened
+	epoch       int64               // times the log has been opened, part of every transaction ID
+	voting      map[string]bool     // coordinator: transactions still collecting votes
+	decisions   map[string]bool     // coordinator: commits not yet acknowledged, and aborts recorded while voting
+	outstanding map[string][]string // coordinator: commits not yet acknowledged by all their participants
+	prepared    map[string]string   // participant: coordinator of each transaction voted yes on and waiting for the decision
+
+	commits *metrics.Counter
+	aborts  *metrics.Counter
+}
+
+// A coordinator log record: an open, a commit decision or the end of a commit
+type record struct {
+	Epoch        int64    `json:"epoch,omitempty"`
+	TxnID        string   `json:"txn_id,omitempty"`
+	Participants []string `json:"participants,omitempty"` // set on a commit decision
+	End          bool     `json:"end,omitempty"`
+}
+
+// Creates n's committer, preparing and finishing transaction parts in resource, and registers its handlers
+func New(n *maelstrom.Node, resource Resource, cfg Config) *Committer {
+	registry := metrics.Of(n)
+	c := &Committer{
+		node:        n,
+		env:         env.Of(n),
+		resource:    resource,
+		cfg:         cfg,
+		voting:      make(map[string]bool),
+		decisions:   make(map[string]bool),
+		outstanding: make(map[string][]string),
+		prepared:    make(map[string]string),
+		commits:     registry.Counter("twopc.commits"),
+		aborts:      registry.Counter("twopc.aborts"),
+	}
+
+	handler.Handle(n, "2pc_prepare", func(msg maelstrom.Message, body PrepareRequestBody) (PrepareResponseBody, error) {
+		return PrepareResponseBody{Vote: c.prepareLocal(body)}, nil
+	})
+
+	for _, decision := range []string{"2pc_commit", "2pc_abort"} {
+		handler.Handle(n, decision, func(msg maelstrom.Message, body DecisionRequestBody) (DecisionResponseBody, error) {
+			c.finish(body.TxnID, decision == "2pc_commit")
+			return DecisionResponseBody{}, nil
+		})
+	}
+
+	handler.Handle(n, "2pc_status", func(msg maelstrom.Message, body StatusRequestBody) (StatusResponseBody, error) {
+		return StatusResponseBody{Committed: c.Status(body.TxnID)}, nil
+	})
+
+	return c
+}
+
+// Opens the coordinator log name in store and recovers the commits in it, sending the unfinished ones again
+// Has to be called before the node coordinates anything
+func (c *Committer) Open(store storage.Store, name string) error {
+	log, data, err := store.OpenLog(name)
+	if err != nil {
+		return err
+	}
+
+	records := make([]record, len(data))
+	for i := range data {
+		if err := json.Unmarshal(data[i], &records[i]); err != nil {
+			log.Close()
+			return fmt.Errorf("twopc log record %d: %w", i, err)
+		}
+	}
+	epoch, outstanding := replay(records)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.log = log
+	c.epoch = epoch + 1
+	if err := c.appendLocked(record{Epoch: c.epoch}); err != nil {
+		return err
+	}
+
+	for txnID, participants := range outstanding {
+		logger.Info("resending recovered commit", "txn", txnID, "participants", participants)
+		c.decisions[txnID] = true
+		c.outstanding[txnID] = participants
+		go c.deliverAll(txnID, participants, true)
+	}
+
+	return nil
+}
+
+// Returns the latest open count in records and the commits decided in them without an end record,
+// with their participants
+func replay(records []record) (int64, map[string][]string) {
+	epoch := int64(0)
+	outstanding := make(map[string][]string)
+
+	for _, r := range records {
+		switch {
+		case r.Epoch > 0:
+			epoch = max(epoch, r.Epoch)
+		case r.End:
+			delete(outstanding, r.TxnID)
+		case r.TxnID != "":
+			outstanding[r.TxnID] = r.Participants
+		}
+	}
+
+	return epoch, outstanding
+}
+
+// Runs a transaction made of parts, one for each participant node, and returns whether it committed
+// A participant that votes no or doesn't answer in time aborts it, it then had no effects
+func (c *Committer) Commit(parts map[string]json.RawMessage) bool {
+	if len(parts) == 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	txnID := fmt.Sprintf("%s-%d.%d", c.node.ID(), c.epoch, c.nextID.Add(1))
+	c.voting[txnID] = true
+	c.mu.Unlock()
+
+	// Phase 1: collect a vote from every participant
+	votes := make(chan bool, len(parts))
+	participants := make([]string, 0, len(parts))
+	for participant, part := range parts {
+		participants = append(participants, participant)
+		go func() {
+			votes <- c.prepare(participant, PrepareRequestBody{
+				Type:        "2pc_prepare",
+				TxnID:       txnID,
+				Coordinator: c.node.ID(),
+				Part:        part,
+			})
+		}()
+	}
+
+	commit := true
+	for range parts {
+		commit = <-votes && commit
+	}
+
+	// A participant that timed out may already have asked, in which case the transaction is aborted
+	commit = c.decide(txnID, commit, participants)
+
+	// Phase 2: deliver the decision
+	go c.deliverAll(txnID, participants, commit)
+
+	if commit {
+		c.commits.Inc()
+	} else {
+		c.aborts.Inc()
+	}
+	return commit
+}
+
+// Asks a participant to prepare, returns its vote
+// No answer within the prepare timeout counts as a no
+func (c *Committer) prepare(participant string, body PrepareRequestBody) bool {
+	if participant == c.node.ID() {
+		return c.prepareLocal(body)
+	}
+
+	var resp PrepareResponseBody
+	if err := c.call(participant, body, &resp); err != nil {
+		return false
+	}
+	return resp.Vote
+}
+
+// Ends the voting on a transaction and returns its outcome, abort if a participant asked for it first
+// A commit is logged and recorded until it's acknowledged, and decided as abort if it can't be logged
+func (c *Committer) decide(txnID string, commit bool, participants []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.voting, txnID)
+	if _, asked := c.decisions[txnID]; asked {
+		delete(c.decisions, txnID)
+		return false
+	}
+	if !commit {
+		return false
+	}
+
+	if err := c.appendLocked(record{TxnID: txnID, Participants: participants}); err != nil {
+		logger.Error("2pc log append failed, aborting", "txn", txnID, "error", err)
+		return false
+	}
+	c.decisions[txnID] = true
+	c.outstanding[txnID] = participants
+	return true
+}
+
+// Sends the decision to every participant, and for a commit logs its end once they've all acknowledged it
+func (c *Committer) deliverAll(txnID string, participants []string, commit bool) {
+	var wg sync.WaitGroup
+	for _, participant := range participants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.deliver(participant, txnID, commit)
+		}()
+	}
+
+	if !commit {
+		return
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.decisions, txnID)
+	delete(c.outstanding, txnID)
+	if err := c.appendLocked(record{TxnID: txnID, End: true}); err != nil {
+		logger.Error("2pc log append failed", "txn", txnID, "error", err)
+		return
+	}
+	c.compactLocked()
+}
+
+// Sends the decision to a participant
+// Commits are retried until acknowledged, an abort is sent once since the participant can recover it with 2pc_status
+func (c *Committer) deliver(participant string, txnID string, commit bool) {
+	if participant == c.node.ID() {
+		c.finish(txnID, commit)
+		return
+	}
+
+	body := DecisionRequestBody{Type: "2pc_abort", TxnID: txnID}
+	if commit {
+		body.Type = "2pc_commit"
+	}
+
+	for {
+		err := c.call(participant, body, &DecisionResponseBody{})
+		if err == nil || !commit {
+			return
+		}
+
+		logger.Warn("2pc commit failed, retrying", "txn", txnID, "participant", participant, "error", err)
+		<-c.env.Clock.After(c.cfg.RetryDelay)
+	}
+}
+
+// Returns the outcome of a transaction coordinated by this node, presuming abort if it hasn't decided yet
+// or has forgotten the decision, which it only does once no participant can still be waiting on it
+func (c *Committer) Status(txnID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if decided, ok := c.decisions[txnID]; ok {
+		return decided
+	}
+	if c.voting[txnID] {
+		c.decisions[txnID] = false
+	}
+	return false
+}
+
+// Prepares this node's part of a transaction and returns the vote
+// A yes vote leaves the part locked until the decision arrives or is recovered from the coordinator
+func (c *Committer) prepareLocal(body PrepareRequestBody) bool {
+	if err := c.resource.Prepare(body.TxnID, body.Part); err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	c.prepared[body.TxnID] = body.Coordinator
+	c.mu.Unlock()
+
+	go func() {
+		<-c.env.Clock.After(c.cfg.ParticipantTimeout)
+		c.recover(body.Coordinator, body.TxnID)
+	}()
+
+	return true
+}
+
+// Applies the decision for a prepared transaction
+// Does nothing if the transaction isn't prepared here, so duplicate or late decisions are harmless
+func (c *Committer) finish(txnID string, commit bool) {
+	c.mu.Lock()
+	_, ok := c.prepared[txnID]
+	delete(c.prepared, txnID)
+	c.mu.Unlock()
+
+	if ok {
+		c.resource.Finish(txnID, commit)
+	}
+}
+
+// Asks the coordinator for the outcome of a transaction still prepared after the participant timeout
+// Keeps asking while the coordinator is unreachable, the part stays locked until it answers
+func (c *Committer) recover(coordinator string, txnID string) {
+	for {
+		c.mu.Lock()
+		_, ok := c.prepared[txnID]
+		c.mu.Unlock()
+
+		if !ok {
+			return
+		}
+
+		if coordinator == c.node.ID() {
+			c.finish(txnID, c.Status(txnID))
+			return
+		}
+
+		var resp StatusResponseBody
+		err := c.call(coordinator, StatusRequestBody{Type: "2pc_status", TxnID: txnID}, &resp)
+		if err == nil {
+			c.finish(txnID, resp.Committed)
+			return
+		}
+
+		logger.Warn("2pc status check failed, retrying", "txn", txnID, "coordinator", coordinator, "error", err)
+		<-c.env.Clock.After(c.cfg.RetryDelay)
+	}
+}
+
+// Sends body to peer and unmarshals the answer into resp, giving up after the prepare timeout
+func (c *Committer) call(peer string, body any, resp any) error {
+	ctx, cancel := c.env.Clock.WithTimeout(context.Background(), c.cfg.PrepareTimeout)
+	defer cancel()
+
+	msg, err := c.node.SyncRPC(ctx, peer, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Body, resp)
+}
+
+// The following must be called with c.mu held
+
+// Appends r to the coordinator log, if it's open
+func (c *Committer) appendLocked(r record) error {
+	if c.log == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return c.log.Append(data)
+}
+
+// Cuts the coordinator log back to the open count once no commit is waiting for acknowledgements
+// The count is appended again before the records ahead of it are dropped, so a crash part way loses nothing
+func (c *Committer) compactLocked() {
+	if c.log == nil || len(c.outstanding) > 0 {
+		return
+	}
+
+	offset := c.log.Size()
+	if err := c.appendLocked(record{Epoch: c.epoch}); err != nil {
+		logger.Error("2pc log append failed", "error", err)
+		return
+	}
+	if err := c.log.Discard(offset); err != nil {
+		logger.Error("2pc log discard failed", "error", err)
+	}
+}
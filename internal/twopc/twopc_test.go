@@ -0,0 +1,164 @@
+package twopc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestReplay(t *testing.T) {
+	epoch, outstanding := replay([]record{
+		{Epoch: 1},
+		{TxnID: "n0-1.1", Participants: []string{"n0", "n1"}},
+		{TxnID: "n0-1.2", Participants: []string{"n1", "n2"}},
+		{TxnID: "n0-1.1", End: true},
+		{Epoch: 2},
+		{TxnID: "n0-2.1", Participants: []string{"n2"}},
+	})
+
+	if epoch != 2 {
+		t.Errorf("epoch %d, want 2", epoch)
+	}
+	want := map[string][]string{"n0-1.2": {"n1", "n2"}, "n0-2.1": {"n2"}}
+	if !maps.EqualFunc(outstanding, want, slices.Equal) {
+		t.Errorf("outstanding %v, want %v", outstanding, want)
+	}
+}
+
+func TestReplayEmpty(t *testing.T) {
+	epoch, outstanding := replay(nil)
+	if epoch != 0 || len(outstanding) != 0 {
+		t.Errorf("replay of an empty log returned %d, %v", epoch, outstanding)
+	}
+}
+
+func TestOpenRecoversCommits(t *testing.T) {
+	store := storage.NewMemory()
+	log, _, err := store.OpenLog("twopc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{`{"epoch":1}`, `{"txn_id":"n0-1.1","participants":["n1"]}`, `{"txn_id":"n0-1.1","end":true}`} {
+		if err := log.Append([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New(maelstrom.NewNode(), nil, Config{})
+	if err := c.Open(store, "twopc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.epoch != 2 {
+		t.Errorf("epoch %d after reopening, want 2", c.epoch)
+	}
+	if c.Status("n0-1.2") {
+		t.Error("unlogged transaction committed")
+	}
+
+	// Every participant acknowledged the ended commit, so none of them will ask about it
+	c.mu.Lock()
+	remembered := len(c.decisions)
+	c.mu.Unlock()
+	if remembered != 0 {
+		t.Errorf("%d decisions remembered after reopening, want none", remembered)
+	}
+
+	// With nothing outstanding the log is cut back to the open count
+	c.mu.Lock()
+	c.compactLocked()
+	c.mu.Unlock()
+
+	_, records, err := store.OpenLog("twopc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || string(records[0]) != `{"epoch":2}` {
+		t.Errorf("compacted log %q, want just the open count", records)
+	}
+}
+
+// Prepares and finishes parts, voting no on the ones that say so
+type resource struct {
+	mu       sync.Mutex
+	finished map[string]bool
+}
+
+func (r *resource) Prepare(txnID string, part json.RawMessage) error {
+	if string(part) == `"no"` {
+		return errors.New("voted no")
+	}
+	return nil
+}
+
+func (r *resource) Finish(txnID string, commit bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished[txnID] = commit
+}
+
+func TestDecisionsAreForgotten(t *testing.T) {
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init("n0", []string{"n0"})
+	r := &resource{finished: map[string]bool{}}
+	c := New(n, r, Config{PrepareTimeout: time.Second, ParticipantTimeout: time.Second, RetryDelay: time.Millisecond})
+
+	remembered := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.voting) + len(c.decisions) + len(c.outstanding)
+	}
+
+	// An abort is the presumed answer anyway, so it's forgotten straight away
+	if c.Commit(map[string]json.RawMessage{"n0": json.RawMessage(`"no"`)}) {
+		t.Fatal("committed a transaction voted no on")
+	}
+	if n := remembered(); n != 0 {
+		t.Errorf("%d transactions remembered after an abort", n)
+	}
+
+	// A commit is forgotten once its participant has acknowledged it
+	if !c.Commit(map[string]json.RawMessage{"n0": json.RawMessage(`"yes"`)}) {
+		t.Fatal("transaction aborted")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for remembered() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("commit still remembered after 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if committed, ok := r.finished["n0-0.2"]; !ok || !committed {
+		t.Errorf("finished %v, want n0-0.2 committed", r.finished)
+	}
+}
+
+func TestStatusWhileVotingAborts(t *testing.T) {
+	c := New(maelstrom.NewNode(), nil, Config{})
+	c.voting["n0-1.1"] = true
+
+	if c.Status("n0-1.1") {
+		t.Fatal("transaction still voting reported committed")
+	}
+	if c.decide("n0-1.1", true, []string{"n1"}) {
+		t.Error("transaction committed after a participant was told it aborted")
+	}
+	if c.Status("n0-1.1") || len(c.decisions) != 0 {
+		t.Errorf("decisions %v after the vote, want the abort forgotten", c.decisions)
+	}
+
+	// Asking about a transaction that isn't voting records nothing
+	c.Status("n0-1.2")
+	if len(c.decisions) != 0 {
+		t.Errorf("decisions %v, want none recorded", c.decisions)
+	}
+}
@@ -0,0 +1,63 @@
+package txn
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+Admission control
+
+Maelstrom's workloads send transactions faster than a node commits them under contention, and every
+request runs in its own goroutine. Left alone, more and more transactions run at once, each queueing on
+the same stripe locks, conflicting, retrying, and holding the others back further. With
+TXN_MAX_CONCURRENT set, at most that many transactions execute on a node at a time. A transaction that
+can't start within TXN_ADMISSION_WAIT_MS is refused with temporarily-unavailable, which the client may
+retry, instead of adding to the pile.
+*/
+
+// Returned when a transaction couldn't be admitted in time
+var ErrOverloaded = errors.New("overloaded, too many transactions in progress")
+
+// Semaphore bounding the number of transactions executing at once
+type Admission struct {
+	slots chan struct{} // nil if unbounded
+	wait  time.Duration
+}
+
+// Creates a semaphore admitting up to limit transactions at once, 0 for no limit
+func NewAdmission(limit int, wait time.Duration) *Admission {
+	admission := &Admission{wait: wait}
+	if limit > 0 {
+		admission.slots = make(chan struct{}, limit)
+	}
+	return admission
+}
+
+// Waits for a slot for a transaction and returns a function releasing it
+// Returns ErrOverloaded if none became free within the wait
+func (a *Admission) Acquire() (func(), error) {
+	if a.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case a.slots <- struct{}{}:
+		return a.release, nil
+	default:
+	}
+
+	timer := time.NewTimer(a.wait)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+		return a.release, nil
+	case <-timer.C:
+		return nil, ErrOverloaded
+	}
+}
+
+func (a *Admission) release() {
+	<-a.slots
+}
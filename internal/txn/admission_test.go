@@ -0,0 +1,34 @@
+package txn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionBoundsConcurrentTransactions(t *testing.T) {
+	if _, err := NewAdmission(0, 0).Acquire(); err != nil {
+		t.Errorf("unbounded acquire: %s", err)
+	}
+
+	admission := NewAdmission(2, 10*time.Millisecond)
+
+	first, _ := admission.Acquire()
+	if _, err := admission.Acquire(); err != nil {
+		t.Fatalf("second acquire: %s", err)
+	}
+
+	if _, err := admission.Acquire(); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("acquire past the limit: err = %v, want ErrOverloaded", err)
+	}
+
+	// A slot freed while waiting is taken
+	go func() {
+		time.Sleep(time.Millisecond)
+		first()
+	}()
+	admission.wait = time.Second
+	if _, err := admission.Acquire(); err != nil {
+		t.Errorf("acquire after a release: %s", err)
+	}
+}
@@ -0,0 +1,132 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Anti-entropy
+
+Replication retries until every peer acknowledges, but state can still diverge: a node that restarted
+without a log, a write-set dropped along with a crashed node's outgoing queue, a bug. So every
+TXN_ANTI_ENTROPY_INTERVAL_MS each node in a replicating mode compares its keys with a random peer's and
+repairs the difference, without sending the whole store.
+
+The store keeps a Merkle tree over its keys (see internal/merkle), updated as each key changes, with
+merkleDepth levels below the root. The node starting the exchange finds the buckets where its tree and the
+peer's differ by walking down them with merkle_digest, then sends its keys in those buckets with
+anti_entropy_repair; the peer installs the ones newer than its own and answers with its keys in the same
+buckets, which the node installs the same way. Last-write-wins decides every key, so both end up equal.
+
+An expired value hashes as its value until the sweeper replaces it with a tombstone (see ttl.go), so for up
+to TXN_GC_INTERVAL_MS a node that swept a key and one that hasn't can disagree on its bucket. Repairing it
+installs nothing, since the versions' timestamps are the same.
+*/
+
+const (
+	merkleDepth = 8
+
+	antiEntropyTimeout = time.Second
+)
+
+type RepairRequestBody struct {
+	Type    string  `json:"type"`
+	Buckets []int   `json:"buckets"`
+	Writes  []Write `json:"writes"`
+}
+
+type RepairResponseBody struct {
+	Type   string  `json:"type"`
+	Writes []Write `json:"writes"`
+}
+
+// Periodically repairs divergence between this node and its peers
+type AntiEntropy struct {
+	node  *maelstrom.Node
+	store *TxnStore
+}
+
+func NewAntiEntropy(node *maelstrom.Node, store *TxnStore) *AntiEntropy {
+	return &AntiEntropy{node: node, store: store}
+}
+
+// Syncs with a random peer every interval until ctx is cancelled
+func (a *AntiEntropy) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if lifecycle.Paused(ctx) {
+			continue
+		}
+
+		peers := []string{}
+		for _, id := range a.node.NodeIDs() {
+			if id != a.node.ID() {
+				peers = append(peers, id)
+			}
+		}
+		if len(peers) == 0 {
+			continue
+		}
+
+		peer := peers[rand.Intn(len(peers))]
+		if err := a.sync(peer); err != nil {
+			logger.Warn("anti-entropy failed", "peer", peer, "error", err)
+		}
+	}
+}
+
+// Finds the buckets that differ from peer's and exchanges their keys
+func (a *AntiEntropy) sync(peer string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), antiEntropyTimeout)
+	defer cancel()
+
+	buckets, err := a.store.tree.Diff(merkle.Remote(ctx, a.node, peer, "merkle_digest"))
+	if err != nil {
+		return err
+	}
+	return a.repair(peer, buckets)
+}
+
+// Exchanges the keys of the given buckets with peer, each side keeping the newer version of every key
+func (a *AntiEntropy) repair(peer string, buckets []int) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), antiEntropyTimeout)
+	defer cancel()
+
+	msg, err := a.node.SyncRPC(ctx, peer, RepairRequestBody{
+		Type:    "anti_entropy_repair",
+		Buckets: buckets,
+		Writes:  a.store.BucketWrites(buckets),
+	})
+	if err != nil {
+		return err
+	}
+
+	var body RepairResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	repaired, err := a.store.Repair(body.Writes)
+	if repaired > 0 {
+		logger.Info("anti-entropy repaired keys", "peer", peer, "keys", repaired, "buckets", len(buckets))
+	}
+	return err
+}
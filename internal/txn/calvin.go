@@ -0,0 +1,285 @@
+package txn
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Deterministic batched execution (Calvin-style)
+
+With TXN_MODE=calvin nodes don't coordinate per transaction at all. Instead:
+  1. the node receiving a transaction sends it to the sequencer, the first node in sorted ID order
+  2. every calvinBatchInterval the sequencer closes the transactions received so far into a numbered batch
+     and sends it to every node
+  3. every node executes the batches in order, and the transactions of a batch one after another
+
+Since every node starts from the same empty state and executes the same transactions in the same
+order, every node ends up in the same state without replicating a single write. The receiving node
+answers the client with the result of its own execution.
+
+The sequencer ignores a transaction it has already batched, so the receiving node can retransmit it until
+it's acknowledged. It remembers a batch's IDs until the batch has reached every node and
+calvinResultTimeout has passed since, by when their receiving nodes have given up on them.
+
+The sequencer is a single point of failure: while it's unreachable transactions time out.
+*/
+
+const (
+	calvinBatchInterval = 10 * time.Millisecond
+	calvinRPCTimeout    = time.Second
+	calvinResultTimeout = 5 * time.Second
+	calvinRetryDelay    = 100 * time.Millisecond
+)
+
+// A transaction with an ID unique across the cluster, so retransmissions can be recognised
+type SequencedTxn struct {
+	ID          string      `json:"id"`
+	Transaction Transaction `json:"txn"`
+	Time        int64       `json:"time"` // when the receiving node submitted it, TTLs count from it on every node
+}
+
+// Sent to the sequencer to add a transaction to the next batch
+type SequenceRequestBody struct {
+	Type string `json:"type"`
+	SequencedTxn
+}
+
+type SequenceResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Sent by the sequencer to every node
+type BatchRequestBody struct {
+	Type         string         `json:"type"`
+	Seq          int64          `json:"seq"`
+	Transactions []SequencedTxn `json:"txns"`
+}
+
+type BatchResponseBody struct {
+	Type string `json:"type"`
+}
+
+type calvinResult struct {
+	transaction [][]any
+	err         error
+}
+
+// Submits transactions to the sequencer, and executes the batches it sends
+type BatchExecutor struct {
+	node   *maelstrom.Node
+	store  *TxnStore
+	clock  env.Clock
+	nextID atomic.Int64
+
+	// Sequencer state
+	sequenceMu sync.Mutex
+	queue      []SequencedTxn
+	sequenced  map[string]bool // IDs added to a batch that may still be retransmitted
+	nextBatch  int64
+
+	// Executor state
+	executeMu sync.Mutex
+	executed  int64                        // number of the last batch executed
+	buffered  map[int64][]SequencedTxn     // batches received ahead of their turn
+	waiting   map[string]chan calvinResult // transactions received by this node, waiting for their result
+}
+
+func NewBatchExecutor(node *maelstrom.Node, store *TxnStore) *BatchExecutor {
+	return &BatchExecutor{
+		node:      node,
+		store:     store,
+		clock:     env.Of(node).Clock,
+		sequenced: make(map[string]bool),
+		buffered:  make(map[int64][]SequencedTxn),
+		waiting:   make(map[string]chan calvinResult),
+	}
+}
+
+// Returns the node sequencing transactions
+func (c *BatchExecutor) leader() string {
+	return slices.Min(c.node.NodeIDs())
+}
+
+// Starts cutting batches if this node is the sequencer, must be called once the node is initialized
+func (c *BatchExecutor) Start() {
+	if c.leader() == c.node.ID() {
+		lifecycle.Of(c.node).Go(c.runSequencer)
+	}
+}
+
+// Submits a transaction and waits until this node has executed it in its batch
+func (c *BatchExecutor) Execute(transaction [][]any) ([][]any, error) {
+	txn := SequencedTxn{
+		ID:          fmt.Sprintf("%s-%d", c.node.ID(), c.nextID.Add(1)),
+		Transaction: transaction,
+		Time:        time.Now().UnixMilli(),
+	}
+
+	result := make(chan calvinResult, 1)
+	c.executeMu.Lock()
+	c.waiting[txn.ID] = result
+	c.executeMu.Unlock()
+
+	defer func() {
+		c.executeMu.Lock()
+		delete(c.waiting, txn.ID)
+		c.executeMu.Unlock()
+	}()
+
+	// Retransmitting is safe, the sequencer ignores IDs it has already batched
+	deadline := time.After(calvinResultTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), calvinRPCTimeout)
+		_, err := c.node.SyncRPC(ctx, c.leader(), SequenceRequestBody{Type: "sequence", SequencedTxn: txn})
+		cancel()
+
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-deadline:
+			return nil, maelstrom.NewRPCError(maelstrom.Timeout, "sequencer unreachable")
+		case <-time.After(calvinRetryDelay):
+		}
+	}
+
+	select {
+	case r := <-result:
+		return r.transaction, r.err
+	case <-deadline:
+		return nil, maelstrom.NewRPCError(maelstrom.Timeout, "transaction not executed in time")
+	}
+}
+
+// Adds a transaction to the next batch, called on the sequencer
+func (c *BatchExecutor) Sequence(txn SequencedTxn) {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+
+	if c.sequenced[txn.ID] {
+		return
+	}
+
+	c.sequenced[txn.ID] = true
+	c.queue = append(c.queue, txn)
+}
+
+// Closes a batch every calvinBatchInterval and sends it to every node
+func (c *BatchExecutor) runSequencer(ctx context.Context) {
+	ticker := c.clock.NewTicker(calvinBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		c.sequenceMu.Lock()
+		if len(c.queue) == 0 {
+			c.sequenceMu.Unlock()
+			continue
+		}
+
+		c.nextBatch++
+		batch := BatchRequestBody{Type: "batch", Seq: c.nextBatch, Transactions: c.queue}
+		c.queue = nil
+		c.sequenceMu.Unlock()
+
+		lifecycle.Of(c.node).Go(func(ctx context.Context) { c.deliver(ctx, batch) })
+	}
+}
+
+// Sends a batch to every node, then forgets its IDs once nothing can retransmit them any more
+func (c *BatchExecutor) deliver(ctx context.Context, batch BatchRequestBody) {
+	var wg sync.WaitGroup
+	for _, dest := range c.node.NodeIDs() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.sendBatch(ctx, dest, batch)
+		}()
+	}
+	wg.Wait()
+
+	// Every receiving node has executed the batch, but a retransmission sent before it did may still be
+	// on its way
+	select {
+	case <-ctx.Done():
+		return
+	case <-c.clock.After(calvinResultTimeout):
+	}
+
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	for _, txn := range batch.Transactions {
+		delete(c.sequenced, txn.ID)
+	}
+}
+
+// Delivers a batch to a node, retrying until it acknowledges or ctx is done
+func (c *BatchExecutor) sendBatch(ctx context.Context, dest string, batch BatchRequestBody) {
+	if dest == c.node.ID() {
+		c.Receive(batch.Seq, batch.Transactions)
+		return
+	}
+
+	for {
+		rpcCtx, cancel := c.clock.WithTimeout(ctx, calvinRPCTimeout)
+		_, err := c.node.SyncRPC(rpcCtx, dest, batch)
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		logger.Warn("calvin batch failed, retrying", "batch", batch.Seq, "dest", dest, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(calvinRetryDelay):
+		}
+	}
+}
+
+// Executes a batch once every batch before it has been, along with any later batches it unblocks
+func (c *BatchExecutor) Receive(seq int64, transactions []SequencedTxn) {
+	c.executeMu.Lock()
+	defer c.executeMu.Unlock()
+
+	if seq <= c.executed {
+		return
+	}
+	c.buffered[seq] = transactions
+
+	for {
+		batch, ok := c.buffered[c.executed+1]
+		if !ok {
+			return
+		}
+
+		for _, txn := range batch {
+			// Nothing runs concurrently, so the transaction can't conflict, and an error (such as a value
+			// that can't be encoded) is the same on every node
+			result, err := c.store.executeAt(txn.Time, txn.Transaction, ReadCommitted, false, func([]Write) {})
+
+			if waiting, ok := c.waiting[txn.ID]; ok {
+				waiting <- calvinResult{transaction: result, err: err}
+			}
+		}
+
+		delete(c.buffered, c.executed+1)
+		c.executed++
+	}
+}
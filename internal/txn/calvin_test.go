@@ -0,0 +1,62 @@
+package txn
+
+import (
+	"testing"
+)
+
+func TestBatchExecutorRunsBatchesInOrder(t *testing.T) {
+	store := NewTxnStore()
+	c := NewBatchExecutor(nil, store)
+
+	waiting := make(chan calvinResult, 1)
+	c.waiting["n0-1"] = waiting
+
+	first := []SequencedTxn{{ID: "n0-1", Transaction: [][]any{{"w", 1.0, 1.0}, {"r", 1.0, nil}}}}
+	second := []SequencedTxn{{ID: "n1-1", Transaction: [][]any{{"w", 1.0, 2.0}}}}
+
+	// The second batch waits for the first, however they arrive
+	c.Receive(2, second)
+	if store.read("1").Version != 0 {
+		t.Fatal("batch 2 executed before batch 1")
+	}
+
+	c.Receive(1, first)
+	c.Receive(1, first) // retransmission
+
+	if got := store.read("1").Value.Int; got != 2 {
+		t.Errorf("key 1 = %d, want 2 from the later batch", got)
+	}
+
+	if r := <-waiting; r.err != nil || r.transaction[1][2] != 1 {
+		t.Errorf("result = %v %v, want the read to see its own batch's write", r.transaction, r.err)
+	}
+}
+
+func TestBatchExecutorCountsTTLsFromSubmission(t *testing.T) {
+	batch := []SequencedTxn{{ID: "n0-1", Transaction: [][]any{{"w", 1.0, 1.0, 1000.0}}, Time: 5000}}
+	reads := []SequencedTxn{
+		{ID: "n0-2", Transaction: [][]any{{"r", 1.0, nil}}, Time: 5500},
+		{ID: "n0-3", Transaction: [][]any{{"r", 1.0, nil}}, Time: 6000},
+	}
+
+	// Every node writes the same expiry, and sees it expire at the same point, whatever its own clock says
+	for range 2 {
+		store := NewTxnStore()
+		c := NewBatchExecutor(nil, store)
+		live, gone := make(chan calvinResult, 1), make(chan calvinResult, 1)
+		c.waiting["n0-2"], c.waiting["n0-3"] = live, gone
+
+		c.Receive(1, batch)
+		c.Receive(2, reads)
+
+		if expires := store.read("1").Expires; expires != 6000 {
+			t.Errorf("expires = %d, want 6000", expires)
+		}
+		if r := <-live; r.err != nil || r.transaction[0][2] != 1 {
+			t.Errorf("read before expiry = %v %v, want 1", r.transaction, r.err)
+		}
+		if r := <-gone; r.err != nil || r.transaction[0][2] != nil {
+			t.Errorf("read at expiry = %v %v, want nil", r.transaction, r.err)
+		}
+	}
+}
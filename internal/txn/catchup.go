@@ -0,0 +1,101 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Catch-up after a restart
+
+A peer only resends the write-sets it believes a node hasn't applied, and forgets them once every node
+has acknowledged them. A node that comes back without its state (no TXN_WAL_DIR), or that missed
+write-sets while it was down, would therefore never get them and serve stale data indefinitely.
+
+So before it starts serving, a node in a replicating mode asks every peer for its state with catch_up:
+the latest version of every key, and how far that state goes in each origin's sequence, this peer's own
+included. The node merges every answer through last-write-wins, then advances each origin to the highest
+sequence number any answer covered, so replication picks up right after it and nothing is applied twice.
+Peers that don't answer within catchUpTimeout, such as on the first start of the cluster when every node
+is still initializing, are skipped.
+*/
+
+const catchUpTimeout = time.Second
+
+type CatchUpRequestBody struct {
+	Type string `json:"type"`
+}
+
+type CatchUpResponseBody struct {
+	Type    string           `json:"type"`
+	Keys    []Write          `json:"keys"`
+	Origins map[string]int64 `json:"origins"`
+}
+
+// Returns this node's state for a peer catching up
+func CatchUpState(store *TxnStore, replicator *Replicator) CatchUpResponseBody {
+	var self string
+	var committed int64
+
+	// Commits replicate with their stripes locked, so the sequence number read here matches the keys
+	keys, origins := store.capture(func() { self, committed = replicator.Committed() })
+	if committed > 0 {
+		origins[self] = committed
+	}
+
+	return CatchUpResponseBody{Type: "catch_up_ok", Keys: keys, Origins: origins}
+}
+
+// Merges the state of every peer that answers into store and advances replication past it
+// Must be called before the replicator is started and before any transaction runs
+func CatchUp(node *maelstrom.Node, store *TxnStore, replicator *Replicator) {
+	ctx, cancel := context.WithTimeout(context.Background(), catchUpTimeout)
+	defer cancel()
+
+	responses := make(chan *CatchUpResponseBody)
+	peers := 0
+	for _, peer := range node.NodeIDs() {
+		if peer == node.ID() {
+			continue
+		}
+		peers++
+
+		go func() {
+			msg, err := node.SyncRPC(ctx, peer, CatchUpRequestBody{Type: "catch_up"})
+			if err != nil {
+				responses <- nil
+				return
+			}
+
+			var body CatchUpResponseBody
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				responses <- nil
+				return
+			}
+			responses <- &body
+		}()
+	}
+
+	answered := 0
+	for range peers {
+		body := <-responses
+		if body == nil {
+			continue
+		}
+		answered++
+
+		if err := store.Merge(body.Keys, body.Origins); err != nil {
+			logger.Warn("catch-up merge failed", "error", err)
+			continue
+		}
+	}
+
+	for origin, seq := range store.Origins() {
+		replicator.Restore(origin, seq)
+	}
+
+	logger.Info("catch-up merged peer states", "answered", answered, "peers", peers)
+}
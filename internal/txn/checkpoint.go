@@ -0,0 +1,122 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+)
+
+/*
+Checkpoints
+
+Replaying the write-ahead log takes as long as the log is, and the log only grows. So with TXN_WAL_DIR set,
+every TXN_CHECKPOINT_INTERVAL_MS the store writes <dir>/<node>.checkpoint: an on-disk snapshot of the
+latest version of every key (tombstones included, so last-write-wins still works against them after a
+restart), the highest sequence number applied from each origin, and this node's own write-sets that some
+peer hasn't acknowledged yet, so they can be resent after a restart like the ones still in the log.
+
+A checkpoint is taken with every stripe locked, which is also when the log's size is read, so it holds
+exactly the write-sets in the log before that offset. Once the checkpoint is safely on disk those
+records are discarded from the log. Recovery loads the checkpoint, then replays what's left of the log.
+A last checkpoint is written when the node shuts down (see internal/lifecycle), leaving nothing to replay.
+
+Called a checkpoint rather than a snapshot to tell it apart from the versions snapshot isolation reads.
+*/
+
+type Checkpoint struct {
+	Keys    []Write          `json:"keys"`
+	Origins map[string]int64 `json:"origins"`
+	Unacked [][]Write        `json:"unacked,omitempty"`
+}
+
+// Writes a checkpoint of the store to key in files, then drops the write-ahead log records it covers
+// unacked returns this node's write-sets not yet acknowledged by every peer, it's called with every
+// stripe locked so none can be committed meanwhile
+func (s *TxnStore) WriteCheckpoint(files storage.Store, key string, unacked func() [][]Write) error {
+	var checkpoint Checkpoint
+	var covered int64
+	checkpoint.Keys, checkpoint.Origins = s.capture(func() {
+		if unacked != nil {
+			checkpoint.Unacked = unacked()
+		}
+		covered = s.wal.Size()
+	})
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// A crash part way leaves the old one intact
+	if err := files.Put(key, data); err != nil {
+		return err
+	}
+
+	return s.wal.Discard(covered)
+}
+
+// Returns the latest version of every key and the highest sequence number applied from each origin
+// Taken with every stripe locked, so no commit is only partly included; during, if not nil, is called
+// before they're released
+func (s *TxnStore) capture(during func()) ([]Write, map[string]int64) {
+	unlock := s.unlocker(allStripes())
+	for i := range s.stripes {
+		s.stripes[i].mu.Lock()
+	}
+	defer unlock()
+
+	keys := []Write{}
+	for i := range s.stripes {
+		for key := range s.stripes[i].kv {
+			keys = append(keys, s.stripes[i].latest(key).write(key))
+		}
+	}
+
+	if during != nil {
+		during()
+	}
+	return keys, s.Origins()
+}
+
+// Reads the checkpoint at key in files, or returns an empty one if there's none yet
+func ReadCheckpoint(files storage.Store, key string) (Checkpoint, error) {
+	data, err := files.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Checkpoint{}, nil
+	} else if err != nil && !errors.Is(err, storage.ErrCorrupt) {
+		return Checkpoint{}, err
+	}
+
+	// Unlike a torn log record, a corrupt checkpoint can't just be dropped, the log no longer has its writes
+	var checkpoint Checkpoint
+	if err != nil || json.Unmarshal(data, &checkpoint) != nil {
+		return Checkpoint{}, fmt.Errorf("checkpoint %s is corrupt", key)
+	}
+
+	return checkpoint, nil
+}
+
+// Writes a checkpoint every interval until ctx is cancelled
+func (s *TxnStore) RunCheckpoints(ctx context.Context, interval time.Duration, files storage.Store, key string, unacked func() [][]Write) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if lifecycle.Paused(ctx) {
+				continue
+			}
+			if err := s.WriteCheckpoint(files, key, unacked); err != nil {
+				logger.Error("checkpoint failed", "error", err)
+			}
+		}
+	}
+}
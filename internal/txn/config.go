@@ -0,0 +1,175 @@
+package txn
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+)
+
+// Isolation levels selectable with the TXN_ISOLATION environment variable
+const (
+	// Every write is applied and replicated as soon as it executes (challenge 6b)
+	ReadUncommitted = "read-uncommitted"
+
+	// A transaction's writes are buffered and made visible, locally and to other nodes, in one step at commit (challenge 6c)
+	ReadCommitted = "read-committed"
+
+	// Every read sees the store as of the transaction's start, and of two concurrent transactions writing
+	// the same key only the first to commit succeeds
+	SnapshotIsolation = "snapshot"
+
+	// Like read committed, but a transaction only commits if no other transaction committed a write to any
+	// key it read since it read it (optimistic concurrency control), so transactions committed by the same
+	// node, or with two-phase commit, appear to run one at a time
+	Serializable = "serializable"
+)
+
+// Ways of spreading keys across the cluster, selectable with the TXN_MODE environment variable
+const (
+	// Every node holds every key, writes are replicated to all other nodes
+	Replicated = "replicated"
+
+	// Each key lives on exactly one node, transactions spanning several nodes commit with two-phase commit
+	Sharded = "sharded"
+
+	// Every node holds every key, but each key's writes are ordered by its primary node and streamed to
+	// the others, while reads are served by any node from its possibly stale copy
+	Primary = "primary"
+
+	// Transactions are sequenced into batches that every node executes in the same order, see calvin.go
+	Calvin = "calvin"
+
+	// Transactions are commands of a consensus log that every node executes in the same order, see rsm.go
+	RSM = "rsm"
+)
+
+// Consistency of replicated writes across nodes, selectable with the TXN_CONSISTENCY environment variable
+const (
+	// Write-sets from different nodes are applied in whatever order they arrive
+	Eventual = "eventual"
+
+	// A write-set is only applied after every write-set it causally depends on
+	Causal = "causal"
+)
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// TXN_MODE, replicated (default), sharded, primary, calvin or rsm
+	Mode string `env:"TXN_MODE" oneof:"replicated|sharded|primary|calvin|rsm"`
+
+	// TXN_ISOLATION, read-uncommitted (default), read-committed, snapshot or serializable
+	Isolation string `env:"TXN_ISOLATION"`
+
+	// TXN_CONSISTENCY, eventual (default) or causal, only used in modes that replicate
+	Consistency string `env:"TXN_CONSISTENCY" oneof:"eventual|causal"`
+
+	// TXN_VALIDATE_READS, if true a transaction only commits if none of the keys it read changed meanwhile
+	ValidateReads bool `env:"TXN_VALIDATE_READS"`
+
+	// TXN_WAL_DIR, directory holding each node's write-ahead log, persistence is disabled if empty
+	WALDir string `env:"TXN_WAL_DIR"`
+
+	// TXN_WAL_FSYNC, if true the write-ahead log is fsynced after every append
+	WALFsync bool `env:"TXN_WAL_FSYNC"`
+
+	// TXN_CHECKPOINT_INTERVAL_MS, how often the store is checkpointed and the write-ahead log truncated (default 10000)
+	CheckpointInterval time.Duration `env:"TXN_CHECKPOINT_INTERVAL_MS" min:"1"`
+
+	// TXN_ANTI_ENTROPY_INTERVAL_MS, how often a node compares its keys with a random peer's, only used
+	// in modes that replicate (default 5000)
+	AntiEntropyInterval time.Duration `env:"TXN_ANTI_ENTROPY_INTERVAL_MS" min:"1"`
+
+	// TXN_READ_REPLICAS, how many copies of a key a transaction reads, repairing stale ones, only used in
+	// modes that replicate (default 1, just this node's)
+	ReadReplicas int `env:"TXN_READ_REPLICAS" min:"1"`
+
+	// TXN_GC_INTERVAL_MS, how often versions no snapshot can read anymore are dropped (default 1000)
+	GCInterval time.Duration `env:"TXN_GC_INTERVAL_MS" min:"1"`
+
+	// TXN_SESSION_TIMEOUT_MS, how long an interactive transaction may stay idle before it's aborted (default 5000)
+	SessionTimeout time.Duration `env:"TXN_SESSION_TIMEOUT_MS" min:"1"`
+
+	// TXN_LOCK_TIMEOUT_MS, how long a commit waits for locks before it's aborted and retried (default 1000)
+	LockTimeout time.Duration `env:"TXN_LOCK_TIMEOUT_MS" min:"1"`
+
+	// TXN_STATS_INTERVAL_MS, how often the stats are logged to stderr (default 10000)
+	StatsInterval time.Duration `env:"TXN_STATS_INTERVAL_MS" min:"1"`
+
+	// TXN_MAX_CONCURRENT, how many transactions may execute on a node at once (default 0, no limit)
+	MaxConcurrent int `env:"TXN_MAX_CONCURRENT" min:"0"`
+
+	// TXN_ADMISSION_WAIT_MS, how long a transaction waits to start once the limit is reached before it's
+	// refused as overloaded (default 100)
+	AdmissionWait time.Duration `env:"TXN_ADMISSION_WAIT_MS" min:"1"`
+
+	// TXN_MAX_CLOCK_DRIFT_MS, how far ahead of this node's wall clock a write's timestamp may be before
+	// the hybrid clock refuses to follow it (default 10000, 0 for no limit)
+	MaxClockDrift time.Duration `env:"TXN_MAX_CLOCK_DRIFT_MS" min:"0"`
+
+	// TXN_COMMIT_WAIT, if true commit timestamps come from TrueTime (see internal/truetime) and a transaction
+	// that wrote isn't acknowledged until its timestamp has passed, only in replicated mode
+	CommitWait bool `env:"TXN_COMMIT_WAIT"`
+
+	// TXN_MAX_RETRIES, how many times a transaction that failed validation is re-run before
+	// the txn-conflict error is returned to the client (default 3)
+	MaxRetries int `env:"TXN_MAX_RETRIES" min:"0"`
+}
+
+func LoadConfig() (Config, error) {
+	c := Config{
+		Mode:                Replicated,
+		Isolation:           ReadUncommitted,
+		Consistency:         Eventual,
+		GCInterval:          time.Second,
+		CheckpointInterval:  10 * time.Second,
+		AntiEntropyInterval: 5 * time.Second,
+		SessionTimeout:      5 * time.Second,
+		LockTimeout:         time.Second,
+		StatsInterval:       10 * time.Second,
+		ReadReplicas:        1,
+		AdmissionWait:       100 * time.Millisecond,
+		MaxRetries:          3,
+		MaxClockDrift:       10 * time.Second,
+	}
+
+	err := config.Load("txn", &c)
+	return c, err
+}
+
+// The isolation level has to be one the mode supports, and commit wait needs the commit timestamp, which
+// only replicated mode's coordinator knows: in the other modes each participant stamps its own writes
+// The consensus log of rsm mode is replayed in full after a restart, so it can't have a write-ahead log too
+func (c *Config) Validate() error {
+	if c.CommitWait && c.Mode != Replicated {
+		return fmt.Errorf("commit wait is only supported in %s mode", Replicated)
+	}
+	if c.WALDir != "" && c.Mode == RSM {
+		return fmt.Errorf("the write-ahead log isn't supported in %s mode", RSM)
+	}
+	return c.CheckIsolation(c.Isolation)
+}
+
+// Returns true if committed writes are replicated to every node in the configured mode
+func (c Config) Replicates() bool {
+	return c.Mode == Replicated || c.Mode == Primary
+}
+
+// Returns an error if isolation isn't a level transactions can run at in the configured mode
+// Also used for the isolation a txn request asks for
+func (c Config) CheckIsolation(isolation string) error {
+	if isolation != ReadUncommitted && isolation != ReadCommitted && isolation != SnapshotIsolation && isolation != Serializable {
+		return fmt.Errorf("unknown isolation level %q", isolation)
+	}
+
+	if c.Mode != Replicated && isolation == SnapshotIsolation {
+		return fmt.Errorf("snapshot isolation is only supported in %s mode", Replicated)
+	}
+
+	return nil
+}
+
+// Returns true if commits at isolation must check that nothing the transaction read has changed
+func (c Config) Validates(isolation string) bool {
+	return c.ValidateReads || isolation == Serializable
+}
@@ -0,0 +1,134 @@
+package txn
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+)
+
+/*
+Garbage collection of old versions
+
+Every commit adds a version per key it writes, so without collection a long run keeps every value
+ever written. A version can go once a newer version of the same key is also visible to the oldest
+snapshot still in use (the low-water mark): no running or future transaction can read it anymore.
+
+Snapshots are registered while their transaction runs. The newest version at or below the low-water
+mark is always kept since that's what the oldest snapshot reads, even if it's a tombstone: read
+validation still compares its version.
+
+The number of versions reclaimed so far is reported by the stats RPC.
+*/
+
+// Tracks the snapshots of running transactions
+type snapshots struct {
+	mu     sync.Mutex
+	active map[int64]int // number of running transactions per snapshot version
+}
+
+// Registers a transaction starting now and returns its snapshot
+// The version is read under the lock, so it can never be below a low-water mark computed concurrently
+func (s *TxnStore) beginSnapshot() int64 {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	snapshot := s.version.Load()
+	s.snapshots.active[snapshot]++
+	return snapshot
+}
+
+func (s *TxnStore) endSnapshot(snapshot int64) {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	if s.snapshots.active[snapshot]--; s.snapshots.active[snapshot] == 0 {
+		delete(s.snapshots.active, snapshot)
+	}
+}
+
+// Returns the oldest snapshot still in use, the current version if there are none
+func (s *TxnStore) lowWaterMark() int64 {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	low := int64(math.MaxInt64)
+	for snapshot := range s.snapshots.active {
+		low = min(low, snapshot)
+	}
+	return min(low, s.version.Load())
+}
+
+// Drops every version no snapshot can read anymore and returns how many were dropped
+func (s *TxnStore) Collect() int {
+	low := s.lowWaterMark()
+	reclaimed := 0
+
+	for i := range s.stripes {
+		stripe := &s.stripes[i]
+		stripe.mu.Lock()
+
+		for key, versions := range stripe.kv {
+			// Find the newest version the oldest snapshot reads, everything before it is unreachable
+			keep := 0
+			for j := len(versions) - 1; j >= 0; j-- {
+				if versions[j].Version <= low {
+					keep = j
+					break
+				}
+			}
+
+			if keep > 0 {
+				stripe.kv[key] = append([]entry(nil), versions[keep:]...)
+				reclaimed += keep
+			}
+		}
+
+		stripe.mu.Unlock()
+	}
+
+	s.reclaimed.Add(int64(reclaimed))
+	return reclaimed
+}
+
+// Collects old versions every interval until ctx is cancelled, and sweeps expired keys too if sweep is set
+func (s *TxnStore) RunGC(ctx context.Context, interval time.Duration, sweep bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if lifecycle.Paused(ctx) {
+				continue
+			}
+			if n := s.Collect(); n > 0 {
+				logger.Info("gc reclaimed versions", "versions", n)
+			}
+			if !sweep {
+				continue
+			}
+			if n := s.Expire(); n > 0 {
+				logger.Info("gc swept expired keys", "keys", n)
+			}
+		}
+	}
+}
+
+// Returns the number of versions currently stored and the total reclaimed so far
+func (s *TxnStore) VersionStats() (stored int, reclaimed int64) {
+	for i := range s.stripes {
+		stripe := &s.stripes[i]
+		stripe.mu.Lock()
+		for _, versions := range stripe.kv {
+			stored += len(versions)
+		}
+		stripe.mu.Unlock()
+	}
+
+	return stored, s.reclaimed.Load()
+}
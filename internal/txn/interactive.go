@@ -0,0 +1,226 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Interactive transactions
+
+Besides the single-shot txn message, a client can keep a transaction open across messages:
+  txn_begin               starts a transaction and returns its txn_id
+  txn_op     txn_id, op   runs one micro-op and returns it with its result
+  txn_commit txn_id       commits, returning every op run, or fails with txn-conflict
+  txn_abort  txn_id       discards the transaction
+
+Ops run exactly like those of a single-shot transaction: writes are buffered privately and only
+installed at commit. A transaction that sees no message for TXN_SESSION_TIMEOUT_MS is aborted, so
+abandoned clients don't pin snapshots forever. Using its txn_id afterwards returns an abort error.
+*/
+
+// Returned for a txn_id that was never started, already finished or timed out
+var ErrUnknownTransaction = errors.New("unknown transaction, it may have timed out")
+
+type TxnBeginRequestBody struct {
+	Type string `json:"type"`
+}
+
+type TxnBeginResponseBody struct {
+	Type  string `json:"type"`
+	TxnID string `json:"txn_id"`
+}
+
+type TxnOpRequestBody struct {
+	Type  string  `json:"type"`
+	TxnID string  `json:"txn_id"`
+	Op    MicroOp `json:"op"`
+}
+
+type TxnOpResponseBody struct {
+	Type string `json:"type"`
+	Op   []any  `json:"op"`
+}
+
+// Used for both txn_commit and txn_abort
+type TxnEndRequestBody struct {
+	Type  string `json:"type"`
+	TxnID string `json:"txn_id"`
+}
+
+type TxnCommitResponseBody struct {
+	Type        string  `json:"type"`
+	Transaction [][]any `json:"txn"`
+}
+
+type TxnAbortResponseBody struct {
+	Type string `json:"type"`
+}
+
+// An open interactive transaction
+type session struct {
+	mu       sync.Mutex
+	pending  *Pending
+	end      func()
+	lastUsed time.Time
+	done     bool // committed or aborted, set once it's been removed from the table
+}
+
+// Marks the transaction finished and releases what it holds
+// Must be called with s.mu held
+func (s *session) finish() {
+	s.done = true
+	s.end()
+}
+
+type Interactive struct {
+	node    *maelstrom.Node
+	begin   func() (*Pending, func())
+	commit  func(pending *Pending) error
+	timeout time.Duration
+	nextID  atomic.Int64
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// Creates a table of interactive transactions
+// begin starts a transaction and returns a function releasing what it holds, commit installs it
+func NewInteractive(node *maelstrom.Node, begin func() (*Pending, func()), commit func(pending *Pending) error, timeout time.Duration) *Interactive {
+	return &Interactive{
+		node:     node,
+		begin:    begin,
+		commit:   commit,
+		timeout:  timeout,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Starts a transaction and returns its ID
+func (in *Interactive) Begin() string {
+	pending, end := in.begin()
+	txnID := fmt.Sprintf("%s-%d", in.node.ID(), in.nextID.Add(1))
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.sessions[txnID] = &session{pending: pending, end: end, lastUsed: time.Now()}
+	return txnID
+}
+
+// Runs one micro-op in an open transaction
+// An op that fails (for instance on a value that can't be encoded) aborts the transaction
+func (in *Interactive) Do(txnID string, op []any) ([]any, error) {
+	s, ok := in.lookup(txnID)
+	if !ok {
+		return nil, ErrUnknownTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Lost a race with a commit, abort or timeout of the same transaction
+	if s.done {
+		return nil, ErrUnknownTransaction
+	}
+
+	s.lastUsed = time.Now()
+
+	result, err := s.pending.Do(op)
+	if err != nil {
+		if _, ok := in.remove(txnID); ok {
+			s.finish()
+		}
+	}
+	return result, err
+}
+
+// Commits an open transaction and returns its ops with their results
+func (in *Interactive) Commit(txnID string) ([][]any, error) {
+	s, ok := in.remove(txnID)
+	if !ok {
+		return nil, ErrUnknownTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.finish()
+
+	if err := in.commit(s.pending); err != nil {
+		return nil, err
+	}
+	return s.pending.Result, nil
+}
+
+// Discards an open transaction
+func (in *Interactive) Abort(txnID string) error {
+	s, ok := in.remove(txnID)
+	if !ok {
+		return ErrUnknownTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish()
+	return nil
+}
+
+// Aborts transactions that have been idle for longer than the timeout, every half timeout, until ctx is
+// cancelled
+func (in *Interactive) Run(ctx context.Context) {
+	ticker := time.NewTicker(in.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		in.mu.Lock()
+		var expired []string
+		for txnID, s := range in.sessions {
+			if s.mu.TryLock() {
+				if time.Since(s.lastUsed) > in.timeout {
+					expired = append(expired, txnID)
+				}
+				s.mu.Unlock()
+			}
+		}
+		in.mu.Unlock()
+
+		for _, txnID := range expired {
+			if s, ok := in.remove(txnID); ok {
+				s.mu.Lock()
+				s.finish()
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (in *Interactive) lookup(txnID string) (*session, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	s, ok := in.sessions[txnID]
+	return s, ok
+}
+
+// Removes a transaction from the table, the caller finishes it
+// Only one caller gets ok for a given transaction, so each is finished once
+func (in *Interactive) remove(txnID string) (*session, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	s, ok := in.sessions[txnID]
+	delete(in.sessions, txnID)
+	return s, ok
+}
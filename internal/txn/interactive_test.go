@@ -0,0 +1,98 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func newTestInteractive(store *TxnStore) *Interactive {
+	return NewInteractive(maelstrom.NewNode(), func() (*Pending, func()) {
+		return store.Begin(SnapshotIsolation)
+	}, func(pending *Pending) error {
+		return store.Commit(pending, SnapshotIsolation, false, func([]Write) {})
+	}, time.Second)
+}
+
+func TestInteractiveCommit(t *testing.T) {
+	store := NewTxnStore()
+	in := newTestInteractive(store)
+
+	txnID := in.Begin()
+	in.Do(txnID, []any{"w", 1.0, 5.0})
+
+	// Nothing is visible outside the transaction until it commits
+	if got, _ := in.Do(txnID, []any{"r", 1.0, nil}); got[2] != 5 || store.read("1").Version != 0 {
+		t.Fatalf("read own write = %v, store version %d; want 5 and nothing installed", got, store.read("1").Version)
+	}
+
+	result, err := in.Commit(txnID)
+	if err != nil || len(result) != 2 {
+		t.Fatalf("commit = %v %v, want both ops", result, err)
+	}
+
+	if got := store.read("1").Value.Int; got != 5 {
+		t.Errorf("after commit key 1 = %d, want 5", got)
+	}
+
+	if _, err := in.Do(txnID, []any{"r", 1.0, nil}); !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("op after commit: err = %v, want ErrUnknownTransaction", err)
+	}
+
+	if len(store.snapshots.active) != 0 {
+		t.Errorf("%d snapshots still active after commit", len(store.snapshots.active))
+	}
+}
+
+func TestInteractiveAbort(t *testing.T) {
+	store := NewTxnStore()
+	in := newTestInteractive(store)
+
+	txnID := in.Begin()
+	in.Do(txnID, []any{"w", 1.0, 5.0})
+
+	if err := in.Abort(txnID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := in.Commit(txnID); !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("commit after abort: err = %v, want ErrUnknownTransaction", err)
+	}
+
+	if store.read("1").Version != 0 || len(store.snapshots.active) != 0 {
+		t.Errorf("aborted transaction left effects behind")
+	}
+}
+
+func TestInteractiveRunAbortsIdleTransactions(t *testing.T) {
+	store := NewTxnStore()
+	in := NewInteractive(maelstrom.NewNode(), func() (*Pending, func()) {
+		return store.Begin(SnapshotIsolation)
+	}, func(pending *Pending) error {
+		return store.Commit(pending, SnapshotIsolation, false, func([]Write) {})
+	}, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		in.Run(ctx)
+		close(stopped)
+	}()
+
+	txnID := in.Begin()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := in.Do(txnID, []any{"r", 1.0, nil}); !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("op on an idle transaction: err = %v, want ErrUnknownTransaction", err)
+	}
+
+	// Run returns once its context is cancelled
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+}
@@ -0,0 +1,77 @@
+package txn
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Hashes for the store's Merkle tree and the store side of repairs, see antientropy.go
+
+// Returns a hash of a key's latest version for the Merkle tree
+// An expired value hashes as its value until the sweeper makes it a tombstone, see antientropy.go
+func entryHash(e entry) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, []int64{e.Timestamp.Wall, e.Timestamp.Logical})
+	h.Write([]byte(e.Timestamp.Node))
+
+	if e.Deleted {
+		h.Write([]byte{0})
+	} else {
+		value, _ := e.Value.MarshalJSON()
+		h.Write([]byte{1})
+		h.Write(value)
+		binary.Write(h, binary.LittleEndian, e.Expires)
+	}
+	return h.Sum64()
+}
+
+// Returns the latest version of every key in the given buckets
+func (s *TxnStore) BucketWrites(buckets []int) []Write {
+	wanted := make(map[int]bool)
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+
+	writes := []Write{}
+	for i := range s.stripes {
+		st := &s.stripes[i]
+		st.mu.Lock()
+		for key := range st.kv {
+			if wanted[s.tree.Bucket(key)] {
+				writes = append(writes, st.latest(key).write(key))
+			}
+		}
+		st.mu.Unlock()
+	}
+	return writes
+}
+
+// Installs the writes that are newer than what their keys hold, returns how many were
+// Writes already held are skipped rather than installed again, so repeated repairs don't pile up versions
+func (s *TxnStore) Repair(writes []Write) (int, error) {
+	keys := []string{}
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+		s.clock.Update(write.Timestamp)
+	}
+
+	unlock := s.lock(keys)
+	defer unlock()
+
+	newer := []Write{}
+	for _, write := range writes {
+		if s.stripes[stripeOf(write.Key)].latest(write.Key).Timestamp.Less(write.Timestamp) {
+			newer = append(newer, write)
+		}
+	}
+
+	if len(newer) == 0 {
+		return 0, nil
+	}
+
+	if err := s.persist(WALRecord{Writes: newer}); err != nil {
+		return 0, err
+	}
+	s.install(newer)
+	return len(newer), nil
+}
@@ -0,0 +1,43 @@
+package txn
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMerkleTreeFindsDifferingBuckets(t *testing.T) {
+	a, b := NewTxnStore(), NewTxnStore()
+	for i := 0; i < 100; i++ {
+		w := Write{Key: strconv.Itoa(i), Value: Value{Int: i}, Timestamp: a.clock.Now()}
+		a.Apply([]Write{w})
+		b.Apply([]Write{w})
+	}
+
+	if a.tree.Root() != b.tree.Root() {
+		t.Fatal("roots differ for the same keys")
+	}
+
+	// b missed a write
+	a.Apply([]Write{{Key: "7", Value: Value{Int: 70}, Timestamp: a.clock.Now()}})
+
+	differing, err := a.tree.Diff(func(level int, indices []int) ([]uint64, error) {
+		return b.tree.Hashes(level, indices), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(differing) != 1 || differing[0] != a.tree.Bucket("7") {
+		t.Fatalf("differing buckets = %v, want only key 7's", differing)
+	}
+
+	// Exchanging the bucket both ways brings b up to date without touching a
+	if n, _ := b.Repair(a.BucketWrites(differing)); n != 1 {
+		t.Errorf("b repaired %d keys, want 1", n)
+	}
+	if n, _ := a.Repair(b.BucketWrites(differing)); n != 0 {
+		t.Errorf("a repaired %d keys, want 0", n)
+	}
+	if a.tree.Root() != b.tree.Root() {
+		t.Error("roots still differ after the repair")
+	}
+}
@@ -0,0 +1,98 @@
+package txn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Decoding micro-ops
+
+Decoding a request into [][]any turns every JSON number into a float64, which can't hold integers beyond
+2^53 exactly: a large key or value would silently become a neighbouring one. Transactions and micro-ops
+are therefore decoded with json.Number, which keeps a number's text, and ValueOf and KeyOf turn it into
+an exact integer or keep the text as is. A micro-op whose shape doesn't match its function, or whose key KeyOf
+can't make a key of, is rejected when it's decoded with a malformed-request error, rather than skipped.
+*/
+
+// A single micro-op, ["r", key, nil] and so on, see Pending.Run
+type MicroOp []any
+
+// A transaction's micro-ops
+type Transaction [][]any
+
+// Number of elements each micro-op takes, a write may also carry a TTL
+var microOpArity = map[string][]int{
+	"r":    {3},
+	"w":    {3, 4},
+	"d":    {3},
+	"cas":  {4},
+	"inc":  {3},
+	"scan": {3},
+}
+
+func (op *MicroOp) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var elements []any
+	if err := decoder.Decode(&elements); err != nil {
+		return malformed(data, "%v", err)
+	}
+
+	if len(elements) == 0 {
+		return malformed(data, "empty")
+	}
+
+	function, ok := elements[0].(string)
+	if !ok {
+		return malformed(data, "function is not a string")
+	}
+
+	arity, ok := microOpArity[function]
+	if !ok {
+		return malformed(data, "unknown function %q", function)
+	}
+	if !slices.Contains(arity, len(elements)) {
+		return malformed(data, "%s takes %v elements, got %d", function, arity, len(elements))
+	}
+
+	if _, ok := KeyOf(elements[1]); !ok && function != "scan" {
+		return malformed(data, "%v is not a valid key", elements[1])
+	}
+
+	*op = elements
+	return nil
+}
+
+// Returns a malformed-request error about the micro-op data
+func malformed(data []byte, format string, args ...any) error {
+	return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("micro-op %s: ", data)+fmt.Sprintf(format, args...))
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var ops []MicroOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return err
+	}
+
+	*t = make(Transaction, len(ops))
+	for i, op := range ops {
+		(*t)[i] = op
+	}
+	return nil
+}
+
+// Returns true if any of the transaction's micro-ops writes
+func (t Transaction) Writes() bool {
+	for _, op := range t {
+		if op[0] != "r" && op[0] != "scan" {
+			return true
+		}
+	}
+	return false
+}
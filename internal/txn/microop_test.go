@@ -0,0 +1,56 @@
+package txn
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestTransactionKeepsLargeIntegersExact(t *testing.T) {
+	var body TransactionRequestBody
+	if err := json.Unmarshal([]byte(`{"type":"txn","txn":[["w",9007199254740993,9007199254740995],["r",9007199254740993,null]]}`), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewTxnStore()
+	result, err := store.Execute(body.Transaction, ReadCommitted, false, func([]Write) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := store.read("9007199254740993"); e.Value.Int != 9007199254740995 {
+		t.Errorf("stored %+v under key 9007199254740993, want 9007199254740995", e)
+	}
+
+	reply, _ := json.Marshal(result[1])
+	if want := `["r",9007199254740993,9007199254740995]`; string(reply) != want {
+		t.Errorf("read = %s, want %s", reply, want)
+	}
+}
+
+func TestMalformedMicroOps(t *testing.T) {
+	for _, txn := range []string{
+		`[["r"]]`,
+		`[["x", 1, null]]`,
+		`[[1, 1, null]]`,
+		`[["cas", 1, 2]]`,
+		`[[]]`,
+		`[{"r": 1}]`,
+		`[["r", null, null], ["w", 1, 2]]`,
+	} {
+		var transaction Transaction
+		err := json.Unmarshal([]byte(txn), &transaction)
+		var rpcErr *maelstrom.RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.MalformedRequest {
+			t.Errorf("%s decoded as %v, %v, want a malformed-request error", txn, transaction, err)
+		}
+	}
+
+	// An op that didn't go through decoding fails rather than being left out of the result
+	pending := NewPending(func(key string) (entry, error) { return entry{}, nil })
+	if _, err := pending.Do([]any{"r", nil, nil}); err == nil {
+		t.Errorf("read of a nil key returned %v, want an error", pending.Result)
+	}
+}
@@ -0,0 +1,689 @@
+package txn
+
+/*
+Challenge #6: Totally-Available Transactions
+
+Goal: implement a key/value store which implements transactions
+
+Part a) Single-node system
+Part b) Multi-node system with read-uncommitted isolation, writes are replicated asynchronously to every other node
+Part c) Read-committed isolation, enabled by setting TXN_ISOLATION=read-committed, or for a single transaction
+        with the isolation field of its txn message
+
+With TXN_MODE=sharded each key is owned by a single node instead of being replicated everywhere.
+Transactions on a single other node are forwarded to it, ones spanning several nodes are committed
+with two-phase commit (see shard.go and twopc.go). TXN_MODE=primary keeps a copy everywhere but sends
+each key's writes through its primary node, and TXN_MODE=calvin executes the same sequence of transaction
+batches on every node. TXN_MODE=rsm does too, but with the sequence agreed through Raft, Paxos or VR (see
+rsm.go).
+
+In replicated mode, concurrent writes to a key from different nodes are settled by their timestamps, so a
+node whose clock is behind can stamp a write made after another's with an earlier timestamp, and lose to
+it. TXN_COMMIT_WAIT=true takes commit timestamps from TrueTime's upper bound and holds back a transaction's
+acknowledgement until its timestamp has passed (commit wait, see internal/truetime), so a transaction that
+starts after another was acknowledged always gets a later timestamp.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lamport"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metadata"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/rsm"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/truetime"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var logger = logging.For("txn")
+
+type TransactionRequestBody struct {
+	Type        string      `json:"type"`
+	Transaction Transaction `json:"txn"`
+
+	// Set when another node forwarded the transaction here because this node owns all its keys
+	Forwarded bool `json:"forwarded,omitempty"`
+
+	// Isolation level to run this transaction at instead of TXN_ISOLATION, see config.go
+	Isolation string `json:"isolation,omitempty"`
+
+	// Session token from an earlier txn_ok, the transaction waits until this node has applied it
+	Session vclock.VClock `json:"session,omitempty"`
+}
+
+type TransactionResponseBody struct {
+	Type        string        `json:"type"`
+	Transaction [][]any       `json:"txn"`
+	Session     vclock.VClock `json:"session,omitempty"` // only in replicated mode, see replication.go
+}
+
+// Replicate RPC, sent between nodes to apply write-sets committed elsewhere
+type ReplicateRequestBody struct {
+	Type      string     `json:"type"`
+	Origin    string     `json:"origin"`
+	WriteSets []WriteSet `json:"write_sets"`
+}
+
+type ReplicateResponseBody struct {
+	Type  string `json:"type"`
+	Acked int64  `json:"acked"`
+}
+
+// Registers the transaction handlers on node and starts its background tasks
+func Register(node *maelstrom.Node) error {
+	clock := lamport.Attach(node)
+	store := NewTxnStore()
+	stats := &Stats{}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	store.lockTimeout = config.LockTimeout
+	store.clock.SetMaxDrift(config.MaxClockDrift)
+
+	// Timestamps taken from TrueTime's upper bound can be waited out
+	var tt *truetime.Clock
+	if config.CommitWait {
+		if tt, err = truetime.Of(node); err != nil {
+			return err
+		}
+		store.clock.SetWallClock(func() time.Time { return tt.Now().Latest })
+	}
+
+	replicator := NewReplicator(node, store.ApplyReplicated, config.Consistency == Causal)
+	shards := NewShards(node, store)
+
+	// In primary mode the primaries replicate what they commit through two-phase commit
+	var replicatePrepared func(writes []Write)
+	if config.Mode == Primary {
+		replicatePrepared = replicator.Replicate
+	}
+	twoPhaseCommit := NewTwoPhaseCommit(node, store, shards, replicatePrepared)
+	calvin := NewBatchExecutor(node, store)
+
+	// In rsm mode the store also provides the node's snapshot part, through the log
+	var replicated *rsm.RSM
+	if config.Mode == RSM {
+		if replicated, err = rsm.New(node, "txn", NewTxnMachine(store)); err != nil {
+			return err
+		}
+	}
+	antiEntropy := NewAntiEntropy(node, store)
+	admission := NewAdmission(config.MaxConcurrent, config.AdmissionWait)
+
+	if config.Replicates() && config.ReadReplicas > 1 {
+		store.readLatest = NewReadRepair(node, store, config.ReadReplicas).Read
+	}
+
+	// Interactive transactions run against the same store and commit the same way as single-shot ones
+	// They can't be forwarded since their keys aren't known up front, so outside replicated mode they
+	// always read from the owners and commit with two-phase commit
+	interactive := NewInteractive(node, func() (*Pending, func()) {
+		if config.Mode != Replicated {
+			return twoPhaseCommit.Begin(), func() {}
+		}
+		return store.Begin(config.Isolation)
+	}, func(pending *Pending) error {
+		if config.Mode != Replicated {
+			return twoPhaseCommit.Commit(pending, config.Validates(config.Isolation))
+		}
+		return store.Commit(pending, config.Isolation, config.ValidateReads, replicator.Replicate)
+	}, config.SessionTimeout)
+
+	lifecycle.Of(node).Go(func(ctx context.Context) { store.RunGC(ctx, config.GCInterval, config.Mode != Calvin) })
+	lifecycle.Of(node).Go(interactive.Run)
+
+	node.Handle("init", func(msg maelstrom.Message) error {
+		store.clock.SetNode(node.ID())
+		if config.Mode == Primary {
+			lifecycle.Of(node).Go(metadata.Of(node).Run)
+		}
+
+		logger.Info("txn configured", "mode", config.Mode, "isolation", config.Isolation, "consistency", config.Consistency)
+
+		if config.WALDir != "" {
+			files := storage.NewFile(config.WALDir, config.WALFsync)
+			wal, records, err := OpenWAL(files, node.ID()+".wal")
+			if err != nil {
+				return err
+			}
+
+			checkpointKey := node.ID() + ".checkpoint"
+			checkpoint, err := ReadCheckpoint(files, checkpointKey)
+			if err != nil {
+				return err
+			}
+
+			store.Recover(wal, checkpoint, records)
+			logger.Info("txn recovered", "checkpoint_keys", len(checkpoint.Keys), "wal_write_sets", len(records))
+
+			// Finish the commits this node had decided as coordinator before the restart
+			if err := twoPhaseCommit.Open(files, node.ID()+".2pc"); err != nil {
+				return err
+			}
+
+			// Pick up replication where it stopped, then resend this node's own transactions since
+			// any of them may not have reached every peer before the restart (peers skip the ones
+			// they already have, see TxnStore.apply)
+			for origin, seq := range store.Origins() {
+				replicator.Restore(origin, seq)
+			}
+
+			var unacked func() [][]Write
+			if config.Replicates() {
+				for _, writes := range checkpoint.Unacked {
+					replicator.Replicate(writes)
+				}
+				for _, record := range records {
+					if record.Origin == "" {
+						replicator.Replicate(record.Writes)
+					}
+				}
+				unacked = replicator.Unacked
+			}
+
+			lifecycle.Of(node).Go(func(ctx context.Context) {
+				store.RunCheckpoints(ctx, config.CheckpointInterval, files, checkpointKey, unacked)
+			})
+			// A last checkpoint on the way out, so a restart has no log to replay
+			writeCheckpoint := func(ctx context.Context) error {
+				return store.WriteCheckpoint(files, checkpointKey, unacked)
+			}
+			lifecycle.Of(node).OnStop("txn.checkpoint", writeCheckpoint)
+			admin.Of(node).Register("txn.checkpoint", admin.Module{Flush: writeCheckpoint})
+		}
+
+		if config.Replicates() {
+			CatchUp(node, store, replicator)
+			replicator.Start()
+			lifecycle.Of(node).OnStop("txn.replication", replicator.Flush)
+			admin.Of(node).Register("txn.replication", admin.Module{
+				Status: func() any { return map[string]any{"lag": replicator.Lag()} },
+				Flush:  replicator.Flush,
+			})
+			lifecycle.Of(node).Go(func(ctx context.Context) { antiEntropy.Run(ctx, config.AntiEntropyInterval) })
+		}
+		if config.Mode == Calvin {
+			calvin.Start()
+		}
+		if config.Mode == RSM {
+			lifecycle.Of(node).Go(replicated.Run)
+		}
+
+		// The replication lag in the stats needs the node IDs
+		lifecycle.Of(node).Go(func(ctx context.Context) { stats.Run(ctx, config.StatsInterval, store, replicator) })
+		return nil
+	})
+
+	node.Handle("txn", func(msg maelstrom.Message) error {
+		var body TransactionRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		isolation := config.Isolation
+		if body.Isolation != "" {
+			if err := config.CheckIsolation(body.Isolation); err != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+			}
+			isolation = body.Isolation
+		}
+
+		// Sequenced transactions never conflict, so there's nothing to retry
+		if config.Mode == Calvin {
+			release, err := admission.Acquire()
+			if err != nil {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+			}
+			defer release()
+
+			transactionResult, err := calvin.Execute(body.Transaction)
+			stats.Record(len(body.Transaction), 1, err)
+
+			var rpcErr *maelstrom.RPCError
+			if errors.As(err, &rpcErr) {
+				return rpcErr
+			} else if err != nil {
+				return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+			}
+
+			return node.Reply(msg, TransactionResponseBody{
+				Type:        "txn_ok",
+				Transaction: transactionResult,
+			})
+		}
+
+		// Nor do transactions run one at a time through the consensus log
+		if config.Mode == RSM {
+			release, err := admission.Acquire()
+			if err != nil {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+			}
+			defer release()
+
+			transactionResult, err := rsm.Execute[[][]any](context.Background(), replicated, body.Transaction)
+			stats.Record(len(body.Transaction), 1, err)
+
+			var rpcErr *maelstrom.RPCError
+			if errors.As(err, &rpcErr) {
+				return rpcErr
+			} else if err != nil {
+				// The log may or may not have committed it, so the outcome is indefinite
+				return maelstrom.NewRPCError(maelstrom.Timeout, err.Error())
+			}
+
+			return node.Reply(msg, TransactionResponseBody{
+				Type:        "txn_ok",
+				Transaction: transactionResult,
+			})
+		}
+
+		// In sharded mode a transaction entirely on another node runs there, in primary mode one writing
+		// only keys of another node's primary does
+		var owners []string
+		if config.Mode == Sharded {
+			owners = shards.OwnersOf(body.Transaction)
+		} else if config.Mode == Primary {
+			owners = shards.PrimariesOf(body.Transaction)
+		}
+
+		// A forwarded transaction is never forwarded again, even if ownership looks different here
+		if !body.Forwarded {
+			if len(owners) == 1 && owners[0] != node.ID() {
+				transactionResult, err := shards.Forward(owners[0], body.Transaction, body.Isolation)
+
+				var rpcErr *maelstrom.RPCError
+				if errors.As(err, &rpcErr) {
+					return rpcErr
+				} else if err != nil {
+					// The owner may or may not have run it, so the outcome is indefinite
+					return maelstrom.NewRPCError(maelstrom.Timeout, err.Error())
+				}
+
+				return node.Reply(msg, TransactionResponseBody{
+					Type:        "txn_ok",
+					Transaction: transactionResult,
+				})
+			}
+		}
+
+		// Replicas apply write-sets asynchronously, so this node may not have seen the client's earlier writes yet
+		if config.Mode == Replicated && !replicator.WaitFor(body.Session, sessionWaitTimeout) {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not caught up with the session yet")
+		}
+
+		// Transactions forwarded elsewhere above are admitted by the node running them
+		release, err := admission.Acquire()
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+		}
+		defer release()
+
+		// A transaction that lost a race with a concurrent one is simply re-run against the newer state
+		var transactionResult [][]any
+
+		attempts := 0
+		for attempts <= config.MaxRetries {
+			attempts++
+
+			if config.Mode == Sharded || (config.Mode == Primary && len(owners) > 1) {
+				transactionResult, err = twoPhaseCommit.Execute(body.Transaction, config.Validates(isolation))
+			} else {
+				transactionResult, err = store.Execute(body.Transaction, isolation, config.ValidateReads, replicator.Replicate)
+			}
+
+			if !errors.Is(err, ErrConflict) {
+				break
+			}
+		}
+
+		stats.Record(len(body.Transaction), attempts, err)
+
+		if errors.Is(err, ErrConflict) {
+			return maelstrom.NewRPCError(maelstrom.TxnConflict, err.Error())
+		} else if err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		// Commit wait: the transaction's timestamp, and every other this node has issued, is in the past on
+		// every node before the client hears of the commit
+		if tt != nil && body.Transaction.Writes() {
+			tt.WaitUntilAfter(context.Background(), time.UnixMilli(store.clock.Now().Wall+1))
+		}
+
+		resp := TransactionResponseBody{
+			Type:        "txn_ok",
+			Transaction: transactionResult,
+		}
+		if config.Mode == Replicated {
+			resp.Session = replicator.Session()
+		}
+
+		return node.Reply(msg, resp)
+	})
+
+	node.Handle("txn_begin", func(msg maelstrom.Message) error {
+		var body TxnBeginRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		// Batches and the consensus log only hold transactions whose ops are all known when they're submitted
+		if config.Mode == Calvin || config.Mode == RSM {
+			return maelstrom.NewRPCError(maelstrom.NotSupported, "interactive transactions aren't supported in "+config.Mode+" mode")
+		}
+
+		return node.Reply(msg, TxnBeginResponseBody{
+			Type:  "txn_begin_ok",
+			TxnID: interactive.Begin(),
+		})
+	})
+
+	node.Handle("txn_op", func(msg maelstrom.Message) error {
+		var body TxnOpRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		op, err := interactive.Do(body.TxnID, body.Op)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		return node.Reply(msg, TxnOpResponseBody{
+			Type: "txn_op_ok",
+			Op:   op,
+		})
+	})
+
+	node.Handle("txn_commit", func(msg maelstrom.Message) error {
+		var body TxnEndRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		// A refused commit leaves the transaction open, the client can send txn_commit again
+		release, err := admission.Acquire()
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+		}
+		defer release()
+
+		// Unlike a single-shot transaction this can't be retried here, the client decided what to run
+		transactionResult, err := interactive.Commit(body.TxnID)
+		stats.Record(len(transactionResult), 1, err)
+		if errors.Is(err, ErrConflict) {
+			return maelstrom.NewRPCError(maelstrom.TxnConflict, err.Error())
+		} else if err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		return node.Reply(msg, TxnCommitResponseBody{
+			Type:        "txn_commit_ok",
+			Transaction: transactionResult,
+		})
+	})
+
+	node.Handle("txn_abort", func(msg maelstrom.Message) error {
+		var body TxnEndRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		if err := interactive.Abort(body.TxnID); err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		return node.Reply(msg, TxnAbortResponseBody{
+			Type: "txn_abort_ok",
+		})
+	})
+
+	// Apply write-sets committed on another node
+	node.Handle("replicate", func(msg maelstrom.Message) error {
+		var body ReplicateRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		return node.Reply(msg, ReplicateResponseBody{
+			Type:  "replicate_ok",
+			Acked: replicator.Receive(body.Origin, body.WriteSets),
+		})
+	})
+
+	// Primaries are picked from the nodes last set with set_shards, on every node once the set reaches it
+	if config.Mode == Primary {
+		metadata.Of(node).Watch(shardsKey, func(value json.RawMessage) {
+			var members []string
+			if err := json.Unmarshal(value, &members); err != nil {
+				logger.Error("invalid shard set", "nodes", string(value), "error", err)
+				return
+			}
+			shards.SetMembers(members)
+			logger.Info("shards changed", "nodes", members)
+		})
+	}
+
+	node.Handle("set_shards", func(msg maelstrom.Message) error {
+		var body SetShardsRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		if config.Mode != Primary {
+			return maelstrom.NewRPCError(maelstrom.NotSupported, "shards can only be changed in primary mode")
+		}
+		if len(body.Nodes) == 0 {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "nodes must not be empty")
+		}
+		for _, id := range body.Nodes {
+			if !slices.Contains(node.NodeIDs(), id) {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, "unknown node "+id)
+			}
+		}
+
+		if err := metadata.Of(node).Set(shardsKey, body.Nodes); err != nil {
+			return err
+		}
+
+		return node.Reply(msg, SetShardsResponseBody{
+			Type: "set_shards_ok",
+		})
+	})
+
+	// Return the committed state of a key owned by this node
+	node.Handle("shard_read", func(msg maelstrom.Message) error {
+		var body ShardReadRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		return node.Reply(msg, ShardReadResponseBody{
+			Type:  "shard_read_ok",
+			Entry: store.read(body.Key),
+		})
+	})
+
+	// Calvin mode, sequencer side: add a transaction to the next batch
+	node.Handle("sequence", func(msg maelstrom.Message) error {
+		var body SequenceRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		calvin.Sequence(body.SequencedTxn)
+
+		return node.Reply(msg, SequenceResponseBody{
+			Type: "sequence_ok",
+		})
+	})
+
+	// Calvin mode: execute a batch closed by the sequencer
+	node.Handle("batch", func(msg maelstrom.Message) error {
+		var body BatchRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		calvin.Receive(body.Seq, body.Transactions)
+
+		return node.Reply(msg, BatchResponseBody{
+			Type: "batch_ok",
+		})
+	})
+
+	// A restarted peer asking for the state it missed, see catchup.go
+	node.Handle("catch_up", func(msg maelstrom.Message) error {
+		var body CatchUpRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		return node.Reply(msg, CatchUpState(store, replicator))
+	})
+
+	// The newest copy of a key, sent by a node that read this node's stale one, see readrepair.go
+	node.Handle("read_repair", func(msg maelstrom.Message) error {
+		var body ReadRepairRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		if _, err := store.Repair(body.Writes); err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		return node.Reply(msg, ReadRepairResponseBody{Type: "read_repair_ok"})
+	})
+
+	// Anti-entropy, see antientropy.go
+	merkle.Handle(node, "merkle_digest", store.tree)
+
+	node.Handle("anti_entropy_repair", func(msg maelstrom.Message) error {
+		var body RepairRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		// Collect this node's keys before installing the peer's, so both sides get what the other lacked
+		writes := store.BucketWrites(body.Buckets)
+
+		if _, err := store.Repair(body.Writes); err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		return node.Reply(msg, RepairResponseBody{
+			Type:   "anti_entropy_repair_ok",
+			Writes: writes,
+		})
+	})
+
+	// Bulk export and import, see dump.go
+	node.Handle("kv_dump", func(msg maelstrom.Message) error {
+		var body KVDumpRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		keys, next := store.Dump(body.After, body.Limit)
+
+		return node.Reply(msg, KVDumpResponseBody{
+			Type: "kv_dump_ok",
+			Keys: keys,
+			Next: next,
+		})
+	})
+
+	node.Handle("kv_load", func(msg maelstrom.Message) error {
+		var body KVLoadRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		// Keys loaded on one node would be missing on the others
+		if config.Mode == RSM {
+			return maelstrom.NewRPCError(maelstrom.NotSupported, "kv_load isn't supported in rsm mode")
+		}
+
+		writes, err := store.Load(body.Keys)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+		}
+
+		if config.Replicates() {
+			replicator.Replicate(writes)
+		}
+
+		return node.Reply(msg, KVLoadResponseBody{
+			Type:   "kv_load_ok",
+			Loaded: len(writes),
+		})
+	})
+
+	// Node snapshots hold the latest version of every key, restoring one loads them like kv_load
+	// In rsm mode the store's snapshot part goes through the log instead, see rsm.go
+	if config.Mode != RSM {
+		snapshot.Of(node).Provide("txn", func() (any, error) {
+			keys, _ := store.capture(nil)
+			return keys, nil
+		}, func(data json.RawMessage) error {
+			var keys []Write
+			if err := json.Unmarshal(data, &keys); err != nil {
+				return err
+			}
+
+			writes, err := store.Load(keys)
+			if err != nil {
+				return err
+			}
+			if config.Replicates() {
+				replicator.Replicate(writes)
+			}
+			return nil
+		})
+	}
+
+	admin.Of(node).Register("txn", admin.Module{Status: func() any {
+		versions, _ := store.VersionStats()
+		return map[string]any{"mode": config.Mode, "isolation": config.Isolation, "versions": versions}
+	}})
+
+	// Report transaction, locking, replication and version counters through the standard stats RPC
+	metrics.Of(node).Report(func() any {
+		resp := stats.Response(store, replicator)
+		resp.LamportTime = clock.Now()
+		resp.ClockDrifts = store.clock.Drifted()
+		return resp
+	})
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Read repair
+
+In the modes that keep a copy of every key on every node, a node normally reads only its own copy, which
+lags whatever hasn't been replicated to it yet. With TXN_READ_REPLICAS set above 1, a transaction reads
+each key from that many copies instead: its own and those of randomly chosen peers, fetched with
+shard_read. If a peer's copy is newer, by last-write-wins, the node installs it before the transaction
+reads the key, and every copy found older is sent the newest with read_repair without waiting for it to
+be installed. Copies that are read often therefore converge even when replication falls behind.
+
+Peers that don't answer within readRepairTimeout are skipped. Snapshot isolation reads a fixed past
+version, so its reads stay local. Sharded mode keeps a single copy of each key, so there's nothing to
+repair there.
+*/
+
+const readRepairTimeout = 200 * time.Millisecond
+
+type ReadRepairRequestBody struct {
+	Type   string  `json:"type"`
+	Writes []Write `json:"writes"`
+}
+
+type ReadRepairResponseBody struct {
+	Type string `json:"type"`
+}
+
+type ReadRepair struct {
+	node     *maelstrom.Node
+	store    *TxnStore
+	replicas int
+}
+
+// Creates a reader consulting replicas copies of each key, this node's included
+func NewReadRepair(node *maelstrom.Node, store *TxnStore, replicas int) *ReadRepair {
+	return &ReadRepair{node: node, store: store, replicas: replicas}
+}
+
+// Returns the newest of the copies of key read, as installed in this node's store
+func (r *ReadRepair) Read(key string) entry {
+	peers := []string{}
+	for _, id := range r.node.NodeIDs() {
+		if id != r.node.ID() {
+			peers = append(peers, id)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	peers = peers[:min(len(peers), r.replicas-1)]
+
+	copies := make(map[string]entry)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Go(func() {
+			if e, ok := r.readFrom(peer, key); ok {
+				mu.Lock()
+				copies[peer] = e
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	local := r.store.read(key)
+	newest := local
+	for _, e := range copies {
+		if newest.Timestamp.Less(e.Timestamp) {
+			newest = e
+		}
+	}
+
+	if newest.Timestamp != local.Timestamp {
+		r.store.Repair([]Write{newest.write(key)})
+	}
+
+	for peer, e := range copies {
+		if e.Timestamp.Less(newest.Timestamp) {
+			r.node.RPC(peer, ReadRepairRequestBody{
+				Type:   "read_repair",
+				Writes: []Write{newest.write(key)},
+			}, func(maelstrom.Message) error { return nil })
+		}
+	}
+
+	// Read it back, the version has to be this store's for commit validation
+	return r.store.read(key)
+}
+
+// Reads peer's copy of key
+func (r *ReadRepair) readFrom(peer string, key string) (entry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), readRepairTimeout)
+	defer cancel()
+
+	msg, err := r.node.SyncRPC(ctx, peer, ShardReadRequestBody{Type: "shard_read", Key: key})
+	if err != nil {
+		return entry{}, false
+	}
+
+	var body ShardReadResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return entry{}, false
+	}
+	return body.Entry, true
+}
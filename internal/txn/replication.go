@@ -0,0 +1,384 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vclock"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Replication
+
+Every write-set committed on a node is appended to that node's outgoing log under the next sequence
+number. A gossip loop per peer sends the peer every write-set it hasn't acknowledged yet, up to
+replicateBatchSize at a time, in one replicate message.
+
+The receiver tracks, per origin node, the highest sequence number it has applied and only applies the
+write-set directly after it, so duplicates and retransmissions are ignored and write-sets from one origin
+are applied in the order they were committed. It acks with that sequence number, and the sender resends
+everything after it. A partition therefore only delays replication; nothing is lost.
+
+Write-sets acknowledged by every peer are dropped from the outgoing log. A node shutting down waits for
+its outgoing log to empty, up to the shutdown timeout, before writing its last checkpoint.
+
+In causal mode (TXN_CONSISTENCY=causal) each write-set also carries a vector clock of its dependencies:
+for every origin, how many of its write-sets had been applied on the committing node when it committed.
+Anything the transaction read was in one of those, so a receiver buffers the write-set until it has
+applied at least as many from every origin. In eventual mode write-sets from different origins are
+applied as they arrive, and a reader may see an effect before its cause.
+
+In replicated mode every txn_ok also carries a session token: this node's vector clock, including its own
+committed write-sets. A client that sends the token back with its next transaction, to any node, is only
+served once that node has applied everything in it, so it always sees its own earlier writes and never
+reads older state than it already has. A node that doesn't catch up within sessionWaitTimeout refuses the
+transaction as temporarily unavailable rather than serving a stale read.
+*/
+
+const (
+	replicateTimeout   = time.Second
+	replicateInterval  = 100 * time.Millisecond
+	replicateBatchSize = 64
+	sessionWaitTimeout = time.Second
+)
+
+// A committed transaction's writes, numbered in commit order on the node it was committed on
+type WriteSet struct {
+	Seq    int64         `json:"seq"`
+	Writes []Write       `json:"writes"`
+	Deps   vclock.VClock `json:"deps,omitempty"`
+}
+
+type Replicator struct {
+	node   *maelstrom.Node
+	self   string // this incarnation's origin, see Start
+	apply  func(origin string, seq int64, writes []Write) error
+	causal bool
+
+	mu      sync.Mutex
+	wake    *sync.Cond
+	base    int64            // sequence number of pending[0]
+	next    int64            // sequence number of the next write-set committed here
+	pending []WriteSet       // committed here and not yet acknowledged by every peer
+	acked   map[string]int64 // highest sequence number acknowledged by each peer
+
+	// Separate from mu since Replicate is called with the store's locks held and apply takes them
+	receiveMu sync.Mutex
+	received  *sync.Cond                    // broadcast whenever a Receive call finishes, see WaitFor
+	buffered  map[string]map[int64]WriteSet // received write-sets waiting on their dependencies, by origin and seq
+
+	// Guards applied only, and is never held while applying, so Replicate can read it under the store's locks
+	clockMu sync.Mutex
+	applied vclock.VClock // highest sequence number applied from each origin
+}
+
+// Creates a replicator that installs write-sets received from other nodes with apply
+// If causal is set, write-sets are only applied once their causal dependencies have been
+func NewReplicator(node *maelstrom.Node, apply func(origin string, seq int64, writes []Write) error, causal bool) *Replicator {
+	r := &Replicator{
+		node:     node,
+		apply:    apply,
+		causal:   causal,
+		base:     1,
+		next:     1,
+		acked:    make(map[string]int64),
+		buffered: make(map[string]map[int64]WriteSet),
+		applied:  vclock.New(),
+	}
+	r.wake = sync.NewCond(&r.mu)
+	r.received = sync.NewCond(&r.receiveMu)
+	return r
+}
+
+// Starts a gossip loop to every other node, must be called once the node is initialized
+// Write-sets are numbered per incarnation of a node, the origin is its ID plus its start time, so peers
+// that already applied a restarted node's earlier write-sets don't mistake its new ones for duplicates
+func (r *Replicator) Start() {
+	r.self = fmt.Sprintf("%s@%d", r.node.ID(), time.Now().UnixNano())
+
+	for _, peer := range r.node.NodeIDs() {
+		if peer != r.node.ID() {
+			go r.gossip(peer)
+		}
+	}
+}
+
+// Queues writes to be delivered to every other node
+// Returns immediately, the gossip loops keep retrying until every peer acknowledges
+func (r *Replicator) Replicate(writes []Write) {
+	var deps vclock.VClock
+	if r.causal {
+		deps = r.vectorClock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, WriteSet{Seq: r.next, Writes: writes, Deps: deps})
+	r.next++
+	r.wake.Broadcast()
+}
+
+// Applies the write-sets from origin that directly follow the ones already applied
+// In causal mode write-sets whose dependencies haven't been applied yet are buffered instead, and applied
+// as soon as they are, which may be during a later call for a different origin
+// Returns the highest sequence number applied from origin, which the sender treats as an ack
+func (r *Replicator) Receive(origin string, writeSets []WriteSet) int64 {
+	r.receiveMu.Lock()
+	defer r.receiveMu.Unlock()
+	defer r.received.Broadcast()
+
+	if r.buffered[origin] == nil {
+		r.buffered[origin] = make(map[int64]WriteSet)
+	}
+
+	applied := r.vectorClock()
+	for _, ws := range writeSets {
+		if ws.Seq > applied[origin] {
+			r.buffered[origin][ws.Seq] = ws
+		}
+	}
+
+	// Keep applying until no buffered write-set is ready, each one applied may unblock others
+	for progress := true; progress; {
+		progress = false
+
+		for from, buffered := range r.buffered {
+			ws, ok := buffered[applied[from]+1]
+			if !ok || !r.ready(ws, applied) {
+				continue
+			}
+
+			// Count it as applied first, a local transaction that reads its writes then depends on it
+			r.clockMu.Lock()
+			previous := r.applied[from]
+			r.applied[from] = ws.Seq
+			r.clockMu.Unlock()
+
+			if err := r.apply(from, ws.Seq, ws.Writes); err != nil {
+				// Leave it buffered and try again on the next replicate message, it isn't acked meanwhile
+				logger.Error("applying replicated write-set failed", "from", from, "seq", ws.Seq, "error", err)
+				r.clockMu.Lock()
+				r.applied[from] = previous
+				r.clockMu.Unlock()
+				continue
+			}
+
+			delete(buffered, ws.Seq)
+			applied[from] = ws.Seq
+			progress = true
+		}
+	}
+
+	return applied[origin]
+}
+
+// Returns true if every dependency of ws has been applied
+func (r *Replicator) ready(ws WriteSet, applied vclock.VClock) bool {
+	return !r.causal || applied.Descends(r.others(ws.Deps))
+}
+
+// Returns clock without this node's own origins, whose write-sets, from this or an earlier incarnation,
+// are always applied here already
+func (r *Replicator) others(clock vclock.VClock) vclock.VClock {
+	others := clock.Clone()
+	maps.DeleteFunc(others, func(origin string, _ int64) bool {
+		return nodeOf(origin) == nodeOf(r.self)
+	})
+	return others
+}
+
+// Returns the node ID part of an origin
+func nodeOf(origin string) string {
+	node, _, _ := strings.Cut(origin, "@")
+	return node
+}
+
+// Records that write-sets up to seq from origin were applied before a restart, see WALRecord, or
+// through catch-up, see catchup.go
+func (r *Replicator) Restore(origin string, seq int64) {
+	r.clockMu.Lock()
+	defer r.clockMu.Unlock()
+	r.applied[origin] = max(r.applied[origin], seq)
+}
+
+// Returns a session token covering every write-set applied on this node, including those committed here
+func (r *Replicator) Session() vclock.VClock {
+	session := r.vectorClock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next > 1 {
+		session[r.self] = r.next - 1
+	}
+	return session
+}
+
+// Blocks until every write-set in a session token has been applied here, or the timeout passes
+// Returns false if it timed out
+func (r *Replicator) WaitFor(session vclock.VClock, timeout time.Duration) bool {
+	r.receiveMu.Lock()
+	defer r.receiveMu.Unlock()
+
+	// Wake the loop below once more when the time is up
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		r.receiveMu.Lock()
+		defer r.receiveMu.Unlock()
+		expired = true
+		r.received.Broadcast()
+	})
+	defer timer.Stop()
+
+	// applied is only read between Receive calls, when it never counts a write-set still being applied
+	for !r.covers(session) {
+		if expired {
+			return false
+		}
+		r.received.Wait()
+	}
+	return true
+}
+
+// Returns true if every write-set in session has been applied here
+func (r *Replicator) covers(session vclock.VClock) bool {
+	return r.vectorClock().Descends(r.others(session))
+}
+
+// Returns a copy of the highest sequence number applied from each origin
+func (r *Replicator) vectorClock() vclock.VClock {
+	r.clockMu.Lock()
+	defer r.clockMu.Unlock()
+	return r.applied.Clone()
+}
+
+// Sends unacknowledged write-sets to peer until the process exits
+func (r *Replicator) gossip(peer string) {
+	for {
+		batch := r.unacked(peer)
+
+		ctx, cancel := context.WithTimeout(context.Background(), replicateTimeout)
+		msg, err := r.node.SyncRPC(ctx, peer, ReplicateRequestBody{
+			Type:      "replicate",
+			Origin:    r.self,
+			WriteSets: batch,
+		})
+		cancel()
+
+		if err != nil {
+			logger.Warn("replicate failed, retrying", "peer", peer, "error", err)
+			time.Sleep(replicateInterval)
+			continue
+		}
+
+		var body ReplicateResponseBody
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			logger.Warn("replicate reply malformed", "peer", peer, "error", err)
+			continue
+		}
+
+		// The peer is still waiting on write-sets from elsewhere, don't flood it while it does
+		if !r.ack(peer, body.Acked) {
+			time.Sleep(replicateInterval)
+		}
+	}
+}
+
+// Blocks until there's something peer hasn't acknowledged, then returns up to replicateBatchSize write-sets of it
+func (r *Replicator) unacked(peer string) []WriteSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.next-1 <= r.acked[peer] {
+		r.wake.Wait()
+	}
+
+	from := r.acked[peer] + 1 - r.base
+	to := min(from+replicateBatchSize, int64(len(r.pending)))
+	return append([]WriteSet(nil), r.pending[from:to]...)
+}
+
+// Returns this incarnation's origin and the sequence number of the last write-set committed here
+func (r *Replicator) Committed() (string, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.self, r.next - 1
+}
+
+// Returns the writes of every write-set committed here that some peer hasn't acknowledged yet, oldest first
+func (r *Replicator) Unacked() [][]Write {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unacked := [][]Write{}
+	for _, ws := range r.pending {
+		unacked = append(unacked, ws.Writes)
+	}
+	return unacked
+}
+
+// Waits until every peer has acknowledged every write-set committed here, for when the node stops
+func (r *Replicator) Flush(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		pending := len(r.pending)
+		r.mu.Unlock()
+
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d write-sets not acknowledged by every peer: %w", pending, ctx.Err())
+		case <-time.After(replicateInterval):
+		}
+	}
+}
+
+// Returns how many write-sets committed here each peer hasn't acknowledged yet
+func (r *Replicator) Lag() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lag := make(map[string]int64)
+	for _, peer := range r.node.NodeIDs() {
+		if peer != r.node.ID() {
+			lag[peer] = r.next - 1 - r.acked[peer]
+		}
+	}
+	return lag
+}
+
+// Records an ack from peer and drops write-sets every peer has acknowledged
+// Returns false if the ack didn't acknowledge anything new
+func (r *Replicator) ack(peer string, seq int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq <= r.acked[peer] {
+		return false
+	}
+	r.acked[peer] = seq
+
+	lowest := seq
+	for _, id := range r.node.NodeIDs() {
+		if id != r.node.ID() {
+			lowest = min(lowest, r.acked[id])
+		}
+	}
+
+	if drop := lowest + 1 - r.base; drop > 0 {
+		r.pending = r.pending[drop:]
+		r.base += drop
+	}
+
+	return true
+}
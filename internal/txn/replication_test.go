@@ -0,0 +1,139 @@
+package txn
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReceiveAppliesEachWriteSetOnceInOrder(t *testing.T) {
+	var applied []int64
+	r := NewReplicator(nil, func(origin string, seq int64, writes []Write) error {
+		applied = append(applied, int64(writes[0].Value.Int))
+		return nil
+	}, false)
+
+	ws := func(seq int64) WriteSet {
+		return WriteSet{Seq: seq, Writes: []Write{{Key: "1", Value: Value{Int: int(seq)}}}}
+	}
+
+	steps := []struct {
+		writeSets []WriteSet
+		acked     int64
+	}{
+		{[]WriteSet{ws(1), ws(2)}, 2},
+		{[]WriteSet{ws(2), ws(3)}, 3}, // retransmission overlapping what was already applied
+		{[]WriteSet{ws(5)}, 3},        // gap, 4 hasn't arrived yet
+		{[]WriteSet{ws(4), ws(5)}, 5},
+	}
+
+	for i, step := range steps {
+		if got := r.Receive("n1", step.writeSets); got != step.acked {
+			t.Errorf("step %d: acked = %d, want %d", i, got, step.acked)
+		}
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !slices.Equal(applied, want) {
+		t.Errorf("applied = %v, want %v", applied, want)
+	}
+
+	// Origins are numbered independently
+	if got := r.Receive("n2", []WriteSet{ws(1)}); got != 1 {
+		t.Errorf("other origin: acked = %d, want 1", got)
+	}
+}
+
+func TestReceiveCausalBuffersUntilDependenciesApplied(t *testing.T) {
+	var applied []int
+	r := NewReplicator(nil, func(origin string, seq int64, writes []Write) error {
+		applied = append(applied, writes[0].Value.Int)
+		return nil
+	}, true)
+	r.self = "n0@2"
+
+	cause := WriteSet{Seq: 1, Writes: []Write{{Key: "1", Value: Value{Int: 1}}}}
+	effect := WriteSet{Seq: 1, Writes: []Write{{Key: "2", Value: Value{Int: 2}}}, Deps: map[string]int64{"n1": 1, "n0@1": 7}}
+
+	// The effect arrives first and waits, dependencies on this node's own writes, even from before
+	// a restart, are always met
+	if got := r.Receive("n2", []WriteSet{effect}); got != 0 {
+		t.Errorf("effect before cause: acked = %d, want 0", got)
+	}
+
+	if got := r.Receive("n1", []WriteSet{cause}); got != 1 {
+		t.Errorf("cause: acked = %d, want 1", got)
+	}
+
+	if want := []int{1, 2}; !slices.Equal(applied, want) {
+		t.Errorf("applied = %v, want %v", applied, want)
+	}
+
+	// A retransmission of the buffered write-set is acked without applying it again
+	if got := r.Receive("n2", []WriteSet{effect}); got != 1 || len(applied) != 2 {
+		t.Errorf("retransmission: acked = %d with %d applied, want 1 and 2", got, len(applied))
+	}
+}
+
+func TestWaitForSession(t *testing.T) {
+	r := NewReplicator(nil, func(origin string, seq int64, writes []Write) error { return nil }, false)
+	r.self = "n1@1"
+
+	// Nothing to wait for in an empty session, or in write-sets committed by this node
+	if !r.WaitFor(nil, time.Millisecond) || !r.WaitFor(map[string]int64{"n1@0": 7}, time.Millisecond) {
+		t.Fatal("WaitFor timed out on a session already covered")
+	}
+
+	session := map[string]int64{"n2@1": 2}
+	if r.WaitFor(session, time.Millisecond) {
+		t.Fatal("WaitFor returned before the session's write-sets were applied")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Receive("n2@1", []WriteSet{{Seq: 1}, {Seq: 2}})
+	}()
+
+	if !r.WaitFor(session, time.Second) {
+		t.Fatal("WaitFor timed out after the session's write-sets were applied")
+	}
+
+	r.Replicate(nil)
+	if got := r.Session(); got["n2@1"] != 2 || got["n1@1"] != 1 {
+		t.Errorf("session = %v, want n2@1: 2 and n1@1: 1", got)
+	}
+}
+
+func TestCatchUpStateMergesIntoFreshStore(t *testing.T) {
+	peer := NewTxnStore()
+	peerReplicator := NewReplicator(nil, peer.ApplyReplicated, false)
+	peerReplicator.self = "n1@1"
+
+	peer.ApplyReplicated("n2@1", 4, []Write{{Key: "1", Value: Value{Int: 5}, Timestamp: peer.clock.Now()}})
+	peer.Execute([][]any{{"w", 2.0, 6.0}}, ReadCommitted, false, peerReplicator.Replicate)
+
+	state := CatchUpState(peer, peerReplicator)
+	if state.Origins["n2@1"] != 4 || state.Origins["n1@1"] != 1 {
+		t.Fatalf("origins = %v, want n2@1: 4 and n1@1: 1", state.Origins)
+	}
+
+	restarted := NewTxnStore()
+	if err := restarted.Merge(state.Keys, state.Origins); err != nil {
+		t.Fatal(err)
+	}
+
+	if restarted.read("1").Value.Int != 5 || restarted.read("2").Value.Int != 6 {
+		t.Errorf("merged keys 1 and 2 = %+v %+v, want 5 and 6", restarted.read("1"), restarted.read("2"))
+	}
+
+	// Write-sets the state already covers are skipped when the origin resends them
+	r := NewReplicator(nil, restarted.ApplyReplicated, false)
+	for origin, seq := range restarted.Origins() {
+		r.Restore(origin, seq)
+	}
+	if acked := r.Receive("n2@1", []WriteSet{{Seq: 4, Writes: []Write{{Key: "1", Value: Value{Int: 0}}}}}); acked != 4 {
+		t.Errorf("acked = %d, want 4", acked)
+	}
+	if got := restarted.read("1").Value.Int; got != 5 {
+		t.Errorf("key 1 = %d after a resent write-set, want 5", got)
+	}
+}
@@ -0,0 +1,77 @@
+package txn
+
+import (
+	"encoding/json"
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Replicated store
+
+With TXN_MODE=rsm the store is a replicated state machine (see internal/rsm): every transaction is a
+command in a consensus log, and every node executes the committed ones in log order, like calvin mode's
+batches but with the order agreed by Raft, Paxos or VR rather than a single sequencer, so the cluster
+keeps going while a minority of nodes is down. Transactions run one at a time, so they never conflict and
+are always serializable. The client is answered with the result of the leader's execution, which is every
+node's.
+
+Interactive transactions and kv_load aren't supported, their writes wouldn't go through the log, and
+neither are writes with a TTL (see ttl.go), whose expiry every node would check against its own clock. Nor
+is the write-ahead log: a restarted node is sent the whole consensus log again, and replaying it on top of a
+recovered store would run transactions twice.
+*/
+
+// The store as a state machine, executing each committed transaction
+type TxnMachine struct {
+	store *TxnStore
+}
+
+func NewTxnMachine(store *TxnStore) TxnMachine {
+	return TxnMachine{store: store}
+}
+
+func (m TxnMachine) Apply(data json.RawMessage) (any, error) {
+	var transaction Transaction
+	if err := json.Unmarshal(data, &transaction); err != nil {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+
+	// A TTL would expire by each node's own clock, so they're refused the same way everywhere
+	for _, op := range transaction {
+		if len(op) == 4 && op[0] == "w" {
+			return nil, maelstrom.NewRPCError(maelstrom.NotSupported, "writes with a ttl aren't supported in rsm mode")
+		}
+	}
+
+	// An error is the same on every node, such as a value that can't be encoded
+	result, err := m.store.Execute(transaction, ReadCommitted, false, func([]Write) {})
+
+	var rpcErr *maelstrom.RPCError
+	if err != nil && !errors.As(err, &rpcErr) {
+		return nil, maelstrom.NewRPCError(maelstrom.Abort, err.Error())
+	}
+	return result, err
+}
+
+// Returns the latest version of every key
+func (m TxnMachine) Snapshot() (any, error) {
+	keys, _ := m.store.capture(nil)
+	return keys, nil
+}
+
+// Loads the keys of a snapshot over the store
+// Their timestamps are dropped, every node stamps them anew so they replace whatever it has
+func (m TxnMachine) Restore(data json.RawMessage) error {
+	var keys []Write
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i].Timestamp = Timestamp{}
+	}
+	_, err := m.store.Load(keys)
+	return err
+}
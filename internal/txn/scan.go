@@ -0,0 +1,112 @@
+package txn
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+Range reads
+
+The scan micro-op reads every existing key in a range of integer keys at once:
+
+	["scan", [from, to], nil]  fills in the [key, value] pairs of keys from <= key < to, in key order
+
+A point read only has to check at commit that the key it read is unchanged. A scan also depends on the
+keys that weren't there: a transaction inserting a key into the range after the scan creates a phantom
+that validating the scanned keys alone would miss. So a transaction records, for every scan, the version
+of each key it found, and when its reads are validated (TXN_VALIDATE_READS, or serializable isolation) the
+commit locks every stripe, since an insert into the range could land on any of them, and scans the range
+again. Any key added, changed or deleted since makes the commit fail with ErrConflict.
+
+Scans are only supported where the whole keyspace is on one node, so not by two-phase commit.
+*/
+
+// A range scanned by a transaction
+type KeyRange struct {
+	From int64
+	To   int64
+
+	// Version of every key found in the range, tombstones included so a delete changes the result too
+	Keys map[string]int64
+}
+
+// Returns true if key is an integer key within [from, to)
+func inRange(key string, from int64, to int64) bool {
+	k, err := strconv.ParseInt(key, 10, 64)
+	return err == nil && from <= k && k < to
+}
+
+// Returns the range operand of a scan micro-op
+func rangeOf(operand any) (int64, int64, error) {
+	bounds, ok := operand.([]any)
+	if !ok || len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("scan: range %v is not [from, to]", operand)
+	}
+
+	from, err := ValueOf(bounds[0])
+	if err != nil || from.Raw != nil {
+		return 0, 0, fmt.Errorf("scan: range start %v is not an integer", bounds[0])
+	}
+	to, err := ValueOf(bounds[1])
+	if err != nil || to.Raw != nil {
+		return 0, 0, fmt.Errorf("scan: range end %v is not an integer", bounds[1])
+	}
+
+	return int64(from.Int), int64(to.Int), nil
+}
+
+// Returns the state of every key in [from, to) according to at, taking each stripe lock briefly
+// Stripes are visited one at a time, so without locking them all the result may include only some of a
+// concurrent commit's writes, which is what read committed allows; validation catches it otherwise
+func (s *TxnStore) scan(from int64, to int64, at func(st *stripe, key string) entry) map[string]entry {
+	entries := make(map[string]entry)
+	for i := range s.stripes {
+		st := &s.stripes[i]
+		st.mu.Lock()
+		for key := range st.kv {
+			if inRange(key, from, to) {
+				if e := at(st, key); e.Version != 0 {
+					entries[key] = e
+				}
+			}
+		}
+		st.mu.Unlock()
+	}
+	return entries
+}
+
+// Returns true if every range still holds exactly the keys and versions it was scanned with
+// Must be called with every stripe locked
+func (s *TxnStore) validateRanges(ranges []KeyRange) bool {
+	for _, r := range ranges {
+		found := 0
+		for i := range s.stripes {
+			st := &s.stripes[i]
+			for key := range st.kv {
+				if !inRange(key, r.From, r.To) {
+					continue
+				}
+				if st.latest(key).Version != r.Keys[key] {
+					return false
+				}
+				found++
+			}
+		}
+
+		// Keys are never removed, only tombstoned, so a different count means a key was added
+		if found != len(r.Keys) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns every stripe, for commits whose range validation must block inserts anywhere
+func allStripes() []int {
+	stripes := make([]int, stripeCount)
+	for i := range stripes {
+		stripes[i] = i
+	}
+	return stripes
+}
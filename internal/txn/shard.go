@@ -0,0 +1,178 @@
+package txn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	shardReadTimeout = time.Second
+	forwardTimeout   = 5 * time.Second
+
+	// Cluster metadata key of the nodes set with set_shards
+	shardsKey = "txn.shards"
+)
+
+// Reads a key from the node that owns it
+type ShardReadRequestBody struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+type ShardReadResponseBody struct {
+	Type  string `json:"type"`
+	Entry entry  `json:"entry"`
+}
+
+// Admin RPC setting the nodes keys are hashed over, sent to any node
+type SetShardsRequestBody struct {
+	Type  string   `json:"type"`
+	Nodes []string `json:"nodes"`
+}
+
+type SetShardsResponseBody struct {
+	Type string `json:"type"`
+}
+
+/*
+Sharding
+
+In sharded mode every key lives on a single node, chosen by hashing the key over the cluster's nodes.
+A transaction whose keys all live on one other node is forwarded there whole and runs as a local
+transaction on the owner. One touching keys on several nodes is run by the receiving node, reading
+remote keys with shard_read, and committed on every owner with two-phase commit (see twopc.go).
+
+In primary mode every node keeps a full copy, and the same hash picks each key's primary instead.
+Read-only transactions run on whichever node receives them, against its copy, which may lag the
+primaries. A transaction writing keys of a single primary is forwarded there and replicated from
+there, so the primary orders every write to its keys. One writing keys of several primaries reads
+from the primaries and commits on all of them with two-phase commit, each replicating its part.
+
+In primary mode the set_shards admin RPC narrows the nodes the primaries are picked from, say to move
+them off a node that's about to go away. It's kept in the cluster metadata under txn.shards (see
+internal/metadata), so it can be sent to any node and reaches the others through gossip. Sharded mode
+would have to move the keys themselves to the new owners, so it always hashes over every node.
+*/
+
+// Maps keys onto the nodes owning them and reads keys owned elsewhere
+type Shards struct {
+	node  *maelstrom.Node
+	store *TxnStore
+
+	mu      sync.Mutex
+	members []string // sorted nodes set with set_shards, nil for every node
+}
+
+func NewShards(node *maelstrom.Node, store *TxnStore) *Shards {
+	return &Shards{node: node, store: store}
+}
+
+// Sets the nodes keys are hashed over, nil for every node
+func (s *Shards) SetMembers(members []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members = nil
+	if members != nil {
+		s.members = slices.Sorted(slices.Values(members))
+	}
+}
+
+// Returns the node owning key
+// Node IDs are sorted first, so every node agrees regardless of the order Maelstrom listed them in
+func (s *Shards) Owner(key string) string {
+	s.mu.Lock()
+	nodes := s.members
+	s.mu.Unlock()
+	if nodes == nil {
+		nodes = slices.Sorted(slices.Values(s.node.NodeIDs()))
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return nodes[h.Sum32()%uint32(len(nodes))]
+}
+
+// Returns a key's committed state, from the local store or with a shard_read RPC to its owner
+func (s *Shards) Read(key string) (entry, error) {
+	owner := s.Owner(key)
+	if owner == s.node.ID() {
+		return s.store.read(key), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shardReadTimeout)
+	defer cancel()
+
+	msg, err := s.node.SyncRPC(ctx, owner, ShardReadRequestBody{
+		Type: "shard_read",
+		Key:  key,
+	})
+	if err != nil {
+		return entry{}, err
+	}
+
+	var body ShardReadResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return entry{}, err
+	}
+
+	return body.Entry, nil
+}
+
+// Returns the distinct owners of the keys a transaction touches
+func (s *Shards) OwnersOf(transaction [][]any) []string {
+	return s.ownersOf(transaction, false)
+}
+
+// Returns the distinct owners of the keys a transaction may write, its keys' primaries in primary mode
+func (s *Shards) PrimariesOf(transaction [][]any) []string {
+	return s.ownersOf(transaction, true)
+}
+
+func (s *Shards) ownersOf(transaction [][]any, writesOnly bool) []string {
+	owners := []string{}
+	for _, op := range transaction {
+		if len(op) < 2 || (writesOnly && (op[0] == "r" || op[0] == "scan")) {
+			continue
+		}
+		if key, ok := KeyOf(op[1]); ok && !slices.Contains(owners, s.Owner(key)) {
+			owners = append(owners, s.Owner(key))
+		}
+	}
+	return owners
+}
+
+// Sends a whole transaction to the node owning all its keys and returns the owner's result
+// Errors returned by the owner, such as txn-conflict, are passed through unchanged
+func (s *Shards) Forward(owner string, transaction [][]any, isolation string) ([][]any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+
+	msg, err := s.node.SyncRPC(ctx, owner, TransactionRequestBody{
+		Type:        "txn",
+		Transaction: transaction,
+		Isolation:   isolation,
+		Forwarded:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The results are relayed to the client as they are, so large integers must survive the round trip
+	decoder := json.NewDecoder(bytes.NewReader(msg.Body))
+	decoder.UseNumber()
+
+	var body TransactionResponseBody
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body.Transaction, nil
+}
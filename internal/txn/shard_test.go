@@ -0,0 +1,73 @@
+package txn
+
+import (
+	"slices"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestOwnersOf(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n2", "n0", "n1"})
+	shards := NewShards(node, NewTxnStore())
+
+	// Every node agrees on the owner whatever order it was given the node IDs in
+	other := maelstrom.NewNode()
+	other.Init("n1", []string{"n0", "n1", "n2"})
+	for key := range 20 {
+		k, _ := KeyOf(float64(key))
+		if a, b := shards.Owner(k), NewShards(other, nil).Owner(k); a != b {
+			t.Fatalf("key %d: owners %s and %s disagree", key, a, b)
+		}
+	}
+
+	owner, _ := KeyOf(1.0)
+	owners := shards.OwnersOf([][]any{{"r", 1.0, nil}, {"w", 1.0, 2.0}})
+	if !slices.Equal(owners, []string{shards.Owner(owner)}) {
+		t.Errorf("owners of single-key transaction = %v", owners)
+	}
+
+	owners = shards.OwnersOf([][]any{{"r", 1.0, nil}, {"r", 2.0, nil}, {"r", 3.0, nil}, {"r", 4.0, nil}, {"r", 5.0, nil}})
+	if len(owners) < 2 {
+		t.Errorf("owners of five keys = %v, expected them spread over several nodes", owners)
+	}
+
+	// Only written keys have to go through their primary
+	if owners := shards.PrimariesOf([][]any{{"r", 2.0, nil}, {"w", 1.0, 2.0}}); !slices.Equal(owners, []string{shards.Owner(owner)}) {
+		t.Errorf("primaries = %v, want only the written key's", owners)
+	}
+
+	// A scan's range isn't a key to hash
+	if owners := shards.PrimariesOf([][]any{{"scan", []any{0.0, 10.0}, nil}, {"w", 1.0, 2.0}}); !slices.Equal(owners, []string{shards.Owner(owner)}) {
+		t.Errorf("primaries = %v, want only the written key's", owners)
+	}
+
+	if owners := shards.PrimariesOf([][]any{{"r", 1.0, nil}, {"scan", []any{0.0, 10.0}, nil}}); len(owners) != 0 {
+		t.Errorf("primaries of read-only transaction = %v, want none", owners)
+	}
+}
+
+func TestSetMembers(t *testing.T) {
+	node := maelstrom.NewNode()
+	node.Init("n0", []string{"n0", "n1", "n2"})
+	shards := NewShards(node, NewTxnStore())
+
+	shards.SetMembers([]string{"n2", "n1"})
+	for key := range 20 {
+		k, _ := KeyOf(float64(key))
+		if owner := shards.Owner(k); owner == "n0" {
+			t.Fatalf("key %d owned by n0, which isn't a member", key)
+		}
+	}
+
+	// Back to every node
+	shards.SetMembers(nil)
+	var transaction [][]any
+	for key := range 20 {
+		transaction = append(transaction, []any{"r", float64(key), nil})
+	}
+	if owners := shards.OwnersOf(transaction); !slices.Contains(owners, "n0") {
+		t.Errorf("owners = %v, want n0 among them again", owners)
+	}
+}
@@ -0,0 +1,100 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+type StatsResponseBody struct {
+	Type          string  `json:"type"`
+	Committed     int64   `json:"committed"`
+	Aborted       int64   `json:"aborted"`
+	Conflicts     int64   `json:"conflicts"`
+	ConflictRate  float64 `json:"conflict_rate"` // fraction of commit attempts that hit a conflict
+	AvgOps        float64 `json:"avg_ops"`       // average micro-ops per committed transaction
+	LockWaits     int64   `json:"lock_waits"`
+	AvgLockWaitMs float64 `json:"avg_lock_wait_ms"`
+
+	// Write-sets committed here that each peer hasn't acknowledged yet
+	ReplicationLag map[string]int64 `json:"replication_lag"`
+
+	Versions          int   `json:"versions"`
+	ReclaimedVersions int64 `json:"reclaimed_versions"`
+
+	// This node's Lamport clock, see internal/lamport
+	LamportTime int64 `json:"lamport_time"`
+
+	// Write timestamps the hybrid clock refused to follow for being too far ahead, see TXN_MAX_CLOCK_DRIFT_MS
+	ClockDrifts int64 `json:"clock_drifts"`
+}
+
+// Transaction counters reported by the stats RPC and dumped to stderr periodically
+type Stats struct {
+	committed atomic.Int64
+	aborted   atomic.Int64
+	attempts  atomic.Int64
+	conflicts atomic.Int64
+	ops       atomic.Int64
+}
+
+// Counts a transaction that finished after attempts tries, the last of which returned err
+func (s *Stats) Record(ops int, attempts int, err error) {
+	conflicts := attempts - 1
+	if errors.Is(err, ErrConflict) {
+		conflicts++
+	}
+
+	s.attempts.Add(int64(attempts))
+	s.conflicts.Add(int64(conflicts))
+
+	if err != nil {
+		s.aborted.Add(1)
+		return
+	}
+
+	s.committed.Add(1)
+	s.ops.Add(int64(ops))
+}
+
+func (s *Stats) Response(store *TxnStore, replicator *Replicator) StatsResponseBody {
+	resp := StatsResponseBody{
+		Type:           "stats_ok",
+		Committed:      s.committed.Load(),
+		Aborted:        s.aborted.Load(),
+		Conflicts:      s.conflicts.Load(),
+		LockWaits:      store.lockWaits.Load(),
+		ReplicationLag: replicator.Lag(),
+	}
+
+	if attempts := s.attempts.Load(); attempts > 0 {
+		resp.ConflictRate = float64(resp.Conflicts) / float64(attempts)
+	}
+	if resp.Committed > 0 {
+		resp.AvgOps = float64(s.ops.Load()) / float64(resp.Committed)
+	}
+	if resp.LockWaits > 0 {
+		resp.AvgLockWaitMs = float64(store.lockWaitTime.Load()) / float64(resp.LockWaits) / float64(time.Millisecond)
+	}
+
+	resp.Versions, resp.ReclaimedVersions = store.VersionStats()
+	return resp
+}
+
+// Logs the stats every interval until ctx is cancelled
+func (s *Stats) Run(ctx context.Context, interval time.Duration, store *TxnStore, replicator *Replicator) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r := s.Response(store, replicator)
+			logger.Info("stats", "committed", r.Committed, "aborted", r.Aborted, "conflict_rate", r.ConflictRate, "avg_ops", r.AvgOps,
+				"avg_lock_wait_ms", r.AvgLockWaitMs, "replication_lag", r.ReplicationLag, "versions", r.Versions)
+		}
+	}
+}
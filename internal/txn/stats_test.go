@@ -0,0 +1,31 @@
+package txn
+
+import (
+	"errors"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestStatsResponse(t *testing.T) {
+	stats := &Stats{}
+	store := NewTxnStore()
+	replicator := NewReplicator(maelstrom.NewNode(), store.ApplyReplicated, false)
+
+	stats.Record(2, 1, nil)
+	stats.Record(4, 2, nil)
+	stats.Record(3, 1, ErrConflict)
+	stats.Record(1, 1, errors.New("bad value"))
+
+	resp := stats.Response(store, replicator)
+
+	if resp.Committed != 2 || resp.Aborted != 2 {
+		t.Errorf("committed, aborted = %d, %d, want 2, 2", resp.Committed, resp.Aborted)
+	}
+	if resp.Conflicts != 2 || resp.ConflictRate != 0.4 {
+		t.Errorf("conflicts, rate = %d, %v, want 2, 0.4", resp.Conflicts, resp.ConflictRate)
+	}
+	if resp.AvgOps != 3 {
+		t.Errorf("avg ops = %v, want 3", resp.AvgOps)
+	}
+}
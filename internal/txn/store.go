@@ -0,0 +1,566 @@
+package txn
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/hlc"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/merkle"
+)
+
+// Hybrid logical timestamp ordering writes across nodes, see internal/hlc
+type Timestamp = hlc.Timestamp
+
+// Number of lock stripes, keys hash onto a stripe so unrelated transactions rarely share a lock
+const stripeCount = 64
+
+// A shard of the store guarded by its own lock
+type stripe struct {
+	mu sync.Mutex
+	kv map[string][]entry // every version of each key, oldest first
+}
+
+// Returns the newest version of a key, the zero entry if it was never written
+// Must be called with the stripe locked
+func (st *stripe) latest(key string) entry {
+	versions := st.kv[key]
+	if len(versions) == 0 {
+		return entry{}
+	}
+	return versions[len(versions)-1]
+}
+
+// Returns the newest version of a key committed at or before snapshot
+// Must be called with the stripe locked
+func (st *stripe) at(key string, snapshot int64) entry {
+	versions := st.kv[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Version <= snapshot {
+			return versions[i]
+		}
+	}
+	return entry{}
+}
+
+// One version of a key
+// Deleted keys are kept as tombstones so their version still moves forward, otherwise a transaction
+// that read a missing key couldn't tell whether it was created and deleted again before it committed
+type entry struct {
+	Value     Value
+	Version   int64
+	Deleted   bool
+	Timestamp Timestamp
+	Expires   int64 // see Write
+}
+
+// Returns true if the key exists in this version: it was written, isn't deleted and hasn't expired as of now
+// (Unix milliseconds, 0 for this node's clock)
+func (e entry) exists(now int64) bool {
+	return e.Version != 0 && !e.Deleted && !expired(e.Expires, now)
+}
+
+// Returns the write that reproduces this version of key
+func (e entry) write(key string) Write {
+	return Write{Key: key, Value: e.Value, Deleted: e.Deleted, Timestamp: e.Timestamp, Expires: e.Expires}
+}
+
+// Returned when a commit gives up waiting for a lock, see TxnStore.lockTimeout
+// It wraps ErrConflict, so the transaction is retried like any other conflict
+var ErrLockTimeout = fmt.Errorf("%w (timed out waiting for a lock)", ErrConflict)
+
+// Returned by Execute when a key the transaction read (or, under snapshot isolation, wrote) was changed
+// by another transaction before it could commit
+var ErrConflict = errors.New("txn conflict: a key used by the transaction was modified concurrently")
+
+// Transactional key/value store with striped per-key locking and optimistic transactions
+// The storage, locking and MVCC engine, with no dependency on Maelstrom: the handlers and the protocols
+// that run between nodes (replication, sharding, two-phase commit) drive it, so it's tested on its own.
+//
+// A transaction runs without holding any locks, then commits by locking the stripes of the keys it
+// wrote (and, when validating, the keys it read) in ascending order, so two commits can never wait on
+// each other in a cycle. Commits on disjoint stripes run concurrently.
+//
+// Every commit stamps the keys it writes with a new version from a store-wide counter, which is
+// what read validation compares. Old versions are kept (MVCC), so a snapshot transaction can keep
+// reading the state as of the version current when it began while newer commits land.
+//
+// A commit only draws its version once it holds the stripes of every key it installs, and releases
+// them once all are installed. A snapshot that includes the version therefore either reads the keys
+// after they're installed or waits on the stripe lock until they are, and never sees half a commit.
+type TxnStore struct {
+	stripes [stripeCount]stripe
+	version atomic.Int64
+	clock   *hlc.Clock
+	wal     *WAL // nil unless the store is persisted, see Recover
+
+	// Highest sequence number applied from each origin, kept with the data so a checkpoint records both
+	originsMu sync.Mutex
+	origins   map[string]int64
+
+	// Snapshots of running transactions and the number of old versions dropped, see gc.go
+	snapshots snapshots
+	reclaimed atomic.Int64
+
+	// Hashes of every key's latest version, kept up to date as they change, see antientropy.go
+	tree *merkle.Tree
+
+	// Number of lock acquisitions that had to wait and the total time waited, for the stats RPC
+	lockWaits    atomic.Int64
+	lockWaitTime atomic.Int64
+
+	// How long a commit waits for its keys' locks before aborting with ErrLockTimeout
+	// Stripes are always locked in ascending order, so commits on this node can't deadlock each other,
+	// but a transaction prepared by two-phase commit holds its locks until its coordinator decides, which
+	// takes as long as the coordinator is unreachable. Without a timeout every commit queued behind it
+	// would hang its handler too.
+	lockTimeout time.Duration
+
+	// Reads the latest state of a key for transactions, from more than this store, see readrepair.go
+	// Transactions read only this store when nil
+	readLatest func(key string) entry
+
+	// Called between running a transaction's ops and committing it, lets tests interleave other commits
+	beforeCommit func()
+}
+
+func NewTxnStore() *TxnStore {
+	store := &TxnStore{
+		clock:       hlc.New(),
+		origins:     make(map[string]int64),
+		snapshots:   snapshots{active: make(map[int64]int)},
+		lockTimeout: time.Second,
+		tree:        merkle.New(merkleDepth),
+	}
+	for i := range store.stripes {
+		store.stripes[i].kv = make(map[string][]entry)
+	}
+	return store
+}
+
+func stripeOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % stripeCount)
+}
+
+// Returns the distinct stripes of the given keys in ascending order
+func stripesOf(keys []string) []int {
+	stripes := []int{}
+	for _, key := range keys {
+		stripes = append(stripes, stripeOf(key))
+	}
+
+	slices.Sort(stripes)
+	return slices.Compact(stripes)
+}
+
+// Locks the stripes of all given keys in ascending order
+// Returns a function releasing them
+func (s *TxnStore) lock(keys []string) func() {
+	stripes := stripesOf(keys)
+
+	for _, i := range stripes {
+		s.stripes[i].mu.Lock()
+	}
+
+	return s.unlocker(stripes)
+}
+
+// Like lock, but takes stripes in ascending order rather than keys, and gives up if they can't all be
+// acquired within wait
+func (s *TxnStore) tryLock(stripes []int, wait time.Duration) (func(), bool) {
+	start := time.Now()
+	deadline := start.Add(wait)
+
+	for attempt := 0; ; attempt++ {
+		locked := 0
+		for _, i := range stripes {
+			if !s.stripes[i].mu.TryLock() {
+				break
+			}
+			locked++
+		}
+
+		if locked == len(stripes) {
+			if attempt > 0 {
+				s.lockWaits.Add(1)
+				s.lockWaitTime.Add(int64(time.Since(start)))
+			}
+			return s.unlocker(stripes), true
+		}
+
+		// Back off completely so whoever holds the stripe can finish
+		s.unlocker(stripes[:locked])()
+
+		if time.Now().After(deadline) {
+			s.lockWaits.Add(1)
+			s.lockWaitTime.Add(int64(time.Since(start)))
+			return nil, false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *TxnStore) unlocker(stripes []int) func() {
+	return func() {
+		for _, i := range slices.Backward(stripes) {
+			s.stripes[i].mu.Unlock()
+		}
+	}
+}
+
+// A single key's change made by a transaction, either a new value or a deletion
+// Key is the store key produced by KeyOf
+type Write struct {
+	Key       string    `json:"key"`
+	Value     Value     `json:"value"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Timestamp Timestamp `json:"ts"`
+
+	// When the value expires, in Unix milliseconds, 0 if it never does, see ttl.go
+	// An absolute time rather than a TTL, so every node replicating the write expires it at the same moment
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// Returns the latest committed state of a key, taking its stripe lock briefly
+func (s *TxnStore) read(key string) entry {
+	stripe := &s.stripes[stripeOf(key)]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	return stripe.latest(key)
+}
+
+// Returns the state of a key as of a snapshot version, taking its stripe lock briefly
+func (s *TxnStore) readAt(key string, snapshot int64) entry {
+	stripe := &s.stripes[stripeOf(key)]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	return stripe.at(key, snapshot)
+}
+
+// Installs a write unless the key already holds a write with a later timestamp (last write wins)
+// Concurrent writes to a key from different nodes can arrive in any order, comparing their timestamps
+// instead of applying them as they arrive is what makes every replica end up with the same value
+// Must be called with the key's stripe locked
+func (s *TxnStore) apply(write Write, version int64) {
+	stripe := &s.stripes[stripeOf(write.Key)]
+	if write.Timestamp.Less(stripe.latest(write.Key).Timestamp) {
+		return
+	}
+
+	e := entry{
+		Value:     write.Value,
+		Version:   version,
+		Deleted:   write.Deleted,
+		Timestamp: write.Timestamp,
+		Expires:   write.Expires,
+	}
+	stripe.kv[write.Key] = append(stripe.kv[write.Key], e)
+	s.tree.Set(write.Key, entryHash(e))
+}
+
+// Executes a transaction once against this store, returning a copy of its micro-ops with the results filled in
+// See Pending.Run for the micro-ops and how writes are buffered, and Commit for validation and replication
+func (s *TxnStore) Execute(transaction [][]any, isolation string, validate bool, replicate func(writes []Write)) ([][]any, error) {
+	return s.executeAt(0, transaction, isolation, validate, replicate)
+}
+
+// Executes a transaction like Execute, with its TTLs counting and its reads expiring from now (Unix
+// milliseconds) rather than this node's clock
+func (s *TxnStore) executeAt(now int64, transaction [][]any, isolation string, validate bool, replicate func(writes []Write)) ([][]any, error) {
+	pending, end := s.Begin(isolation)
+	defer end()
+	pending.Now = now
+
+	if err := pending.Run(transaction); err != nil {
+		return nil, err
+	}
+
+	if s.beforeCommit != nil {
+		s.beforeCommit()
+	}
+
+	if err := s.Commit(pending, isolation, validate, replicate); err != nil {
+		return nil, err
+	}
+
+	return pending.Result, nil
+}
+
+// Starts a transaction reading from this store
+// Under snapshot isolation every read sees the store as of now, until the returned function is called
+// to release the snapshot once the transaction has committed or been abandoned
+func (s *TxnStore) Begin(isolation string) (*Pending, func()) {
+	if isolation != SnapshotIsolation {
+		read := s.read
+		if s.readLatest != nil {
+			read = s.readLatest
+		}
+
+		pending := NewPending(func(key string) (entry, error) { return read(key), nil })
+		pending.scan = func(from int64, to int64) map[string]entry {
+			return s.scan(from, to, func(st *stripe, key string) entry { return st.latest(key) })
+		}
+		return pending, func() {}
+	}
+
+	snapshot := s.beginSnapshot()
+	pending := NewPending(func(key string) (entry, error) { return s.readAt(key, snapshot), nil })
+	pending.Snapshot = snapshot
+	pending.scan = func(from int64, to int64) map[string]entry {
+		return s.scan(from, to, func(st *stripe, key string) entry { return st.at(key, snapshot) })
+	}
+
+	return pending, func() { s.endSnapshot(snapshot) }
+}
+
+// Installs a transaction's writes into the store in one step
+// Under read committed the installed writes are passed to replicate as a single message; under read
+// uncommitted every write, including ones later overwritten in the same transaction, is replicated on its own
+//
+// If validate is set, or under serializable isolation, the commit fails with ErrConflict when any key the
+// transaction read, or any range it scanned, has changed since it was read (keys read by inc or cas are always
+// checked, see Pending.Checked).
+// Under snapshot isolation it also fails when another transaction committed a write to a key this one
+// writes after this one's snapshot (first committer wins). Either way the transaction had no effects and
+// can simply be executed again.
+func (s *TxnStore) Commit(pending *Pending, isolation string, validate bool, replicate func(writes []Write)) error {
+	reads := pending.Checked
+	var ranges []KeyRange
+	if validate || isolation == Serializable {
+		reads = pending.Reads
+		ranges = pending.Ranges
+	}
+
+	// Nothing to lock when there's nothing to install or validate
+	if len(pending.Log) == 0 && len(reads) == 0 && len(ranges) == 0 {
+		return nil
+	}
+
+	// Lock the written keys and the read keys being validated, or everything if a range is, see scan.go
+	keys := append(pending.writtenKeys(), slices.Collect(maps.Keys(reads))...)
+	stripes := stripesOf(keys)
+	if len(ranges) > 0 {
+		stripes = allStripes()
+	}
+
+	unlock, ok := s.tryLock(stripes, s.lockTimeout)
+	if !ok {
+		return ErrLockTimeout
+	}
+	defer unlock()
+
+	if !s.validate(reads) || !s.validateRanges(ranges) {
+		return ErrConflict
+	}
+
+	if len(pending.Log) == 0 {
+		return nil
+	}
+
+	if isolation == SnapshotIsolation && !s.unchangedSince(keys, pending.Snapshot) {
+		return ErrConflict
+	}
+
+	// Stamp every write with the commit's timestamp before any of them leaves this node
+	ts := s.clock.Now()
+	for i := range pending.Log {
+		pending.Log[i].Timestamp = ts
+	}
+
+	writes := pending.Writes()
+	if err := s.persist(WALRecord{Writes: writes}); err != nil {
+		return err
+	}
+	s.install(writes)
+
+	if isolation == ReadUncommitted {
+		// Read uncommitted: other nodes see each write separately, as it happened
+		for _, w := range pending.Log {
+			replicate([]Write{w})
+		}
+	} else {
+		// Read committed: other nodes receive the whole transaction at once, so they never observe part of it
+		replicate(writes)
+	}
+
+	return nil
+}
+
+// Returns true if every key still has the version it was read at
+// Must be called with the keys' stripes locked
+func (s *TxnStore) validate(reads map[string]int64) bool {
+	for key, version := range reads {
+		if s.stripes[stripeOf(key)].latest(key).Version != version {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if no key has a version committed after snapshot
+// Must be called with the keys' stripes locked
+func (s *TxnStore) unchangedSince(keys []string, snapshot int64) bool {
+	for _, key := range keys {
+		if s.stripes[stripeOf(key)].latest(key).Version > snapshot {
+			return false
+		}
+	}
+	return true
+}
+
+// Installs writes under a single new version
+// Must be called with the keys' stripes locked
+func (s *TxnStore) install(writes []Write) {
+	version := s.version.Add(1)
+	for _, write := range writes {
+		s.apply(write, version)
+	}
+}
+
+// Applies writes replicated from another node atomically
+// Writes older than what a key already holds are skipped, see apply
+func (s *TxnStore) Apply(writes []Write) {
+	s.ApplyReplicated("", 0, writes)
+}
+
+// Like Apply, and records the write-set's origin and sequence number in the write-ahead log
+// Returns an error, with nothing applied, if the write-set couldn't be logged
+func (s *TxnStore) ApplyReplicated(origin string, seq int64, writes []Write) error {
+	keys := []string{}
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+
+		// A timestamp too far ahead doesn't move the clock, but the write is still applied
+		s.clock.Update(write.Timestamp)
+	}
+
+	// An empty write-set still advances its origin, which a checkpoint must see together with the writes
+	// before it, so it takes a stripe like any other
+	if len(keys) == 0 {
+		keys = append(keys, "")
+	}
+
+	unlock := s.lock(keys)
+	defer unlock()
+
+	if err := s.persist(WALRecord{Origin: origin, Seq: seq, Writes: writes}); err != nil {
+		return err
+	}
+
+	s.install(writes)
+
+	if origin != "" {
+		s.originsMu.Lock()
+		s.origins[origin] = max(s.origins[origin], seq)
+		s.originsMu.Unlock()
+	}
+	return nil
+}
+
+// Applies another node's state, which includes every write-set up to the given sequence number of each
+// origin, and records those as applied here, in the write-ahead log too
+func (s *TxnStore) Merge(keys []Write, origins map[string]int64) error {
+	if err := s.ApplyReplicated("", 0, keys); err != nil {
+		return err
+	}
+
+	for origin, seq := range origins {
+		if err := s.ApplyReplicated(origin, seq, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the highest sequence number applied from each origin
+func (s *TxnStore) Origins() map[string]int64 {
+	s.originsMu.Lock()
+	defer s.originsMu.Unlock()
+	return maps.Clone(s.origins)
+}
+
+// Rebuilds the store from a checkpoint and the records of the write-ahead log written after it, then logs
+// every later write-set to the log
+// Must be called before the store is used
+func (s *TxnStore) Recover(wal *WAL, checkpoint Checkpoint, records []WALRecord) {
+	s.Apply(checkpoint.Keys)
+	for origin, seq := range checkpoint.Origins {
+		s.origins[origin] = seq
+	}
+
+	for _, record := range records {
+		s.ApplyReplicated(record.Origin, record.Seq, record.Writes)
+	}
+	s.wal = wal
+}
+
+// Appends a write-set to the write-ahead log, if there is one
+// Called with the keys' stripes locked, so writes to a key are logged in the order they're installed
+func (s *TxnStore) persist(record WALRecord) error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.Append(record)
+}
+
+// Part of a distributed transaction that has been validated on this node and holds its keys' locks
+// until the coordinator decides its outcome
+type Prepared struct {
+	store  *TxnStore
+	writes []Write
+	unlock func()
+}
+
+// Locks the given keys and validates the reads for the first phase of two-phase commit
+// Locks are only tried, never waited on for longer than wait: a prepared transaction holds its locks
+// across network round trips, so two of them waiting on each other on different nodes would otherwise
+// deadlock. Failing to lock the keys in time is reported as ErrConflict, and the coordinator retries.
+func (s *TxnStore) Prepare(reads map[string]int64, writes []Write, wait time.Duration) (*Prepared, error) {
+	keys := slices.Collect(maps.Keys(reads))
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+	}
+
+	unlock, ok := s.tryLock(stripesOf(keys), wait)
+	if !ok {
+		return nil, ErrConflict
+	}
+
+	if !s.validate(reads) {
+		unlock()
+		return nil, ErrConflict
+	}
+
+	return &Prepared{store: s, writes: writes, unlock: unlock}, nil
+}
+
+// Installs the prepared writes and releases the locks
+// Returns the writes as installed, stamped with the commit's timestamp
+func (p *Prepared) Commit() []Write {
+	ts := p.store.clock.Now()
+	for i := range p.writes {
+		p.writes[i].Timestamp = ts
+	}
+
+	// The coordinator has decided, so the writes are installed even if they can't be logged
+	if err := p.store.persist(WALRecord{Writes: p.writes}); err != nil {
+		logger.Error("wal append failed", "error", err)
+	}
+
+	p.store.install(p.writes)
+	p.unlock()
+
+	return p.writes
+}
+
+// Releases the locks without installing anything
+func (p *Prepared) Abort() {
+	p.unlock()
+}
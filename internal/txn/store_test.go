@@ -0,0 +1,670 @@
+package txn
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// Builds a transaction of reads and writes over keys in [base, base+span)
+func randomTransaction(rng *rand.Rand, base int, span int) [][]any {
+	transaction := [][]any{}
+	for i := 0; i < 4; i++ {
+		key := float64(base + rng.Intn(span))
+		if rng.Intn(2) == 0 {
+			transaction = append(transaction, []any{"r", key, nil})
+		} else {
+			transaction = append(transaction, []any{"w", key, float64(rng.Intn(1000))})
+		}
+	}
+	return transaction
+}
+
+func TestExecuteReadsOwnWrites(t *testing.T) {
+	for _, isolation := range []string{ReadUncommitted, ReadCommitted} {
+		store := NewTxnStore()
+
+		result, _ := store.Execute([][]any{{"w", 1.0, 5.0}, {"r", 1.0, nil}}, isolation, false, func([]Write) {})
+
+		if result[1][2] != 5 {
+			t.Errorf("%s: read after write = %v, want 5", isolation, result[1][2])
+		}
+	}
+}
+
+func TestExecuteReadCommittedReplicatesOnce(t *testing.T) {
+	store := NewTxnStore()
+
+	var replicated [][]Write
+	store.Execute([][]any{{"w", 1.0, 5.0}, {"w", 1.0, 6.0}, {"w", 2.0, 7.0}}, ReadCommitted, false, func(writes []Write) {
+		replicated = append(replicated, writes)
+	})
+
+	if len(replicated) != 1 || len(replicated[0]) != 2 || replicated[0][0].Value.Int != 6 {
+		t.Errorf("replicated = %v, want a single message with the final value of each key", replicated)
+	}
+}
+
+func TestExecuteFailureLeavesNoEffects(t *testing.T) {
+	store := NewTxnStore()
+
+	replicated := 0
+	_, err := store.Execute([][]any{{"w", 1.0, 5.0}, {"w", 2.0, math.Inf(1)}}, ReadUncommitted, false, func([]Write) {
+		replicated++
+	})
+
+	if err == nil {
+		t.Fatal("expected an error writing a value that can't be encoded")
+	}
+
+	result, _ := store.Execute([][]any{{"r", 1.0, nil}}, ReadUncommitted, false, func([]Write) {})
+	if result[0][2] != nil || replicated != 0 {
+		t.Errorf("read = %v with %d replications, want no trace of the failed transaction", result[0][2], replicated)
+	}
+}
+
+func TestExecuteReadUncommittedReplicatesEachWrite(t *testing.T) {
+	store := NewTxnStore()
+
+	var replicated [][]Write
+	store.Execute([][]any{{"w", 1.0, 5.0}, {"w", 1.0, 6.0}}, ReadUncommitted, false, func(writes []Write) {
+		replicated = append(replicated, writes)
+	})
+
+	if len(replicated) != 2 || replicated[0][0].Value.Int != 5 || replicated[1][0].Value.Int != 6 {
+		t.Errorf("replicated = %v, want each write as its own message", replicated)
+	}
+}
+
+func TestExecuteDelete(t *testing.T) {
+	for _, isolation := range []string{ReadUncommitted, ReadCommitted} {
+		store := NewTxnStore()
+		store.Apply([]Write{{Key: "1", Value: Value{Int: 5}}})
+
+		var replicated []Write
+		result, _ := store.Execute([][]any{{"d", 1.0, nil}, {"r", 1.0, nil}, {"d", 2.0, nil}}, isolation, false, func(writes []Write) {
+			replicated = append(replicated, writes...)
+		})
+
+		if result[0][2] != true || result[1][2] != nil || result[2][2] != false {
+			t.Errorf("%s: result = %v, want the first delete to find the key and the read to miss it", isolation, result)
+		}
+
+		if len(replicated) != 1 || !replicated[0].Deleted {
+			t.Errorf("%s: replicated = %v, want a single deletion", isolation, replicated)
+		}
+
+		after, _ := store.Execute([][]any{{"r", 1.0, nil}}, isolation, false, func([]Write) {})
+		if after[0][2] != nil {
+			t.Errorf("%s: read after delete = %v, want nil", isolation, after[0][2])
+		}
+	}
+}
+
+func TestExecuteCompareAndSet(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 5}}})
+
+	result, _ := store.Execute([][]any{
+		{"cas", 1.0, 4.0, 9.0},
+		{"cas", 1.0, 5.0, 6.0},
+		{"cas", 2.0, nil, 7.0},
+		{"r", 1.0, nil},
+		{"r", 2.0, nil},
+	}, ReadCommitted, false, func([]Write) {})
+
+	if result[0][4] != false || result[1][4] != true || result[2][4] != true {
+		t.Errorf("cas outcomes = %v %v %v, want false true true", result[0][4], result[1][4], result[2][4])
+	}
+
+	if result[3][2] != 6 || result[4][2] != 7 {
+		t.Errorf("reads = %v %v, want 6 7", result[3][2], result[4][2])
+	}
+}
+
+func TestExecuteIncrement(t *testing.T) {
+	store := NewTxnStore()
+
+	result, err := store.Execute([][]any{{"inc", 1.0, 5.0}, {"inc", 1.0, -2.0}, {"r", 1.0, nil}}, ReadCommitted, false, func([]Write) {})
+	if err != nil || result[0][3] != 5 || result[1][3] != 3 || result[2][2] != 3 {
+		t.Fatalf("result = %v %v, want new values 5 and 3", result, err)
+	}
+
+	if _, err := store.Execute([][]any{{"w", 2.0, "a"}, {"inc", 2.0, 1.0}}, ReadCommitted, false, func([]Write) {}); err == nil {
+		t.Error("incrementing a string should fail")
+	}
+
+	// A commit to the key between the increment's read and its commit fails it, even without read validation
+	interleaved := false
+	store.beforeCommit = func() {
+		if !interleaved {
+			interleaved = true
+			store.Apply([]Write{{Key: "1", Value: Value{Int: 10}, Timestamp: Timestamp{Wall: math.MaxInt64 / 2}}})
+		}
+	}
+
+	if _, err := store.Execute([][]any{{"inc", 1.0, 1.0}}, ReadCommitted, false, func([]Write) {}); !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+}
+
+func TestExecuteCompareAndSetConflict(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 1}}})
+
+	// Another swap from the same value commits between this one's read and its commit
+	interleaved := false
+	store.beforeCommit = func() {
+		if !interleaved {
+			interleaved = true
+			store.Apply([]Write{{Key: "1", Value: Value{Int: 3}, Timestamp: Timestamp{Wall: math.MaxInt64 / 2}}})
+		}
+	}
+
+	if _, err := store.Execute([][]any{{"cas", 1.0, 1.0, 2.0}}, ReadCommitted, false, func([]Write) {}); !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+
+	result, _ := store.Execute([][]any{{"r", 1.0, nil}}, ReadCommitted, false, func([]Write) {})
+	if result[0][2] != 3 {
+		t.Errorf("read %v, want only the interleaved swap to 3 applied", result[0][2])
+	}
+}
+
+func TestExecuteJSONKeysAndValues(t *testing.T) {
+	store := NewTxnStore()
+
+	result, _ := store.Execute([][]any{
+		{"w", "cart", map[string]any{"items": []any{"apple"}}},
+		{"w", 1.0, "one"},
+		{"r", "cart", nil},
+		{"r", 1.0, nil},
+		{"r", "1", nil},
+		{"cas", 1.0, "one", 2.0},
+		{"r", 1.0, nil},
+	}, ReadCommitted, false, func([]Write) {})
+
+	if raw, _ := json.Marshal(result[2][2]); string(raw) != `{"items":["apple"]}` {
+		t.Errorf("read cart = %s", raw)
+	}
+
+	if raw, _ := json.Marshal(result[3][2]); string(raw) != `"one"` {
+		t.Errorf("read 1 = %s", raw)
+	}
+
+	if result[4][2] != nil {
+		t.Errorf("string key \"1\" = %v, want it distinct from the number 1", result[4][2])
+	}
+
+	if result[5][4] != true || result[6][2] != 2 {
+		t.Errorf("cas = %v then read %v, want true then 2", result[5][4], result[6][2])
+	}
+}
+
+func TestExecuteValidateReads(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 5}}})
+
+	// Another transaction commits between this transaction's read and its commit
+	interleaved := false
+	_, err := store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 1.0}}, ReadCommitted, true, func([]Write) {})
+	if err != nil {
+		t.Fatalf("uncontended transaction: %s", err)
+	}
+
+	store.beforeCommit = func() {
+		if !interleaved {
+			interleaved = true
+			store.Apply([]Write{{Key: "1", Value: Value{Int: 6}}})
+		}
+	}
+
+	_, err = store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 2.0}}, ReadCommitted, true, func([]Write) {})
+
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+
+	// Re-running it against the new state succeeds
+	result, err := store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 2.0}}, ReadCommitted, true, func([]Write) {})
+	if err != nil || result[0][2] != 6 {
+		t.Errorf("retry = %v %v, want it to read the interleaved write", result, err)
+	}
+}
+
+func TestExecuteSerializablePreventsWriteSkew(t *testing.T) {
+	for _, tc := range []struct {
+		isolation string
+		conflict  bool
+	}{
+		{ReadCommitted, false},
+		{Serializable, true},
+	} {
+		store := NewTxnStore()
+
+		// Each transaction reads the key the other writes, so they can't both commit in a serial order
+		interleaved := false
+		store.beforeCommit = func() {
+			if !interleaved {
+				interleaved = true
+				if _, err := store.Execute([][]any{{"r", 2.0, nil}, {"w", 1.0, 1.0}}, tc.isolation, false, func([]Write) {}); err != nil {
+					t.Fatalf("%s: interleaved transaction: %s", tc.isolation, err)
+				}
+			}
+		}
+
+		_, err := store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 1.0}}, tc.isolation, false, func([]Write) {})
+		if got := errors.Is(err, ErrConflict); got != tc.conflict {
+			t.Errorf("%s: conflict = %v, want %v", tc.isolation, got, tc.conflict)
+		}
+	}
+}
+
+func TestExecuteScan(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 10}}, {Key: "3", Value: Value{Int: 30}}, {Key: "5", Value: Value{Int: 50}}})
+
+	// Buffered writes and deletes are seen by the transaction's own scan
+	result, err := store.Execute([][]any{{"w", 2.0, 20.0}, {"d", 3.0, nil}, {"scan", []any{1.0, 5.0}, nil}}, ReadCommitted, false, func([]Write) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := json.Marshal([][]any{{1, 10}, {2, 20}})
+	if got, _ := json.Marshal(result[2][2]); string(got) != string(want) {
+		t.Errorf("scan = %s, want %s", got, want)
+	}
+}
+
+func TestExecuteSerializableDetectsPhantoms(t *testing.T) {
+	for _, tc := range []struct {
+		isolation string
+		conflict  bool
+	}{
+		{ReadCommitted, false},
+		{Serializable, true},
+	} {
+		store := NewTxnStore()
+		store.Apply([]Write{{Key: "1", Value: Value{Int: 1}}})
+
+		// A concurrent insert into the scanned range, on a key the transaction never read on its own
+		interleaved := false
+		store.beforeCommit = func() {
+			if !interleaved {
+				interleaved = true
+				store.Apply([]Write{{Key: "7", Value: Value{Int: 1}}})
+			}
+		}
+
+		_, err := store.Execute([][]any{{"scan", []any{0.0, 10.0}, nil}, {"w", 100.0, 1.0}}, tc.isolation, false, func([]Write) {})
+		if got := errors.Is(err, ErrConflict); got != tc.conflict {
+			t.Errorf("%s: conflict = %v, want %v", tc.isolation, got, tc.conflict)
+		}
+	}
+}
+
+func TestExecuteSnapshotIsolation(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 1}}, {Key: "2", Value: Value{Int: 1}}})
+	snapshot := store.version.Load()
+
+	// Later commits don't change what an earlier snapshot reads
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 2}}})
+	if got := store.readAt("1", snapshot).Value.Int; got != 1 {
+		t.Errorf("key 1 at snapshot = %d, want 1", got)
+	}
+
+	// Of two concurrent writers to a key, the second to commit aborts
+	interleaved := false
+	store.beforeCommit = func() {
+		if !interleaved {
+			interleaved = true
+			store.Apply([]Write{{Key: "2", Value: Value{Int: 2}}})
+		}
+	}
+
+	_, err := store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 3.0}}, SnapshotIsolation, false, func([]Write) {})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+
+	// Writing only keys nobody else wrote meanwhile commits, even if keys it read changed
+	interleaved = false
+	store.beforeCommit = func() {
+		if !interleaved {
+			interleaved = true
+			store.Apply([]Write{{Key: "1", Value: Value{Int: 4}}})
+		}
+	}
+
+	result, err := store.Execute([][]any{{"r", 1.0, nil}, {"w", 2.0, 3.0}}, SnapshotIsolation, false, func([]Write) {})
+	if err != nil || result[0][2] != 2 {
+		t.Errorf("result = %v %v, want it to read key 1 as of its snapshot and commit", result, err)
+	}
+}
+
+func TestCollectKeepsVersionsVisibleToActiveSnapshots(t *testing.T) {
+	store := NewTxnStore()
+	for i := 1; i <= 3; i++ {
+		store.Apply([]Write{{Key: "1", Value: Value{Int: i}}})
+	}
+
+	snapshot := store.beginSnapshot()
+
+	for i := 4; i <= 5; i++ {
+		store.Apply([]Write{{Key: "1", Value: Value{Int: i}}})
+	}
+
+	// Versions 1 and 2 are hidden from the snapshot by version 3
+	if n := store.Collect(); n != 2 {
+		t.Errorf("reclaimed %d versions, want 2", n)
+	}
+	if got := store.readAt("1", snapshot).Value.Int; got != 3 {
+		t.Errorf("snapshot read = %d after collection, want 3", got)
+	}
+
+	store.endSnapshot(snapshot)
+
+	// Without snapshots only the latest version is needed
+	if n := store.Collect(); n != 2 {
+		t.Errorf("reclaimed %d versions, want 2", n)
+	}
+
+	stored, reclaimed := store.VersionStats()
+	if stored != 1 || reclaimed != 4 || store.read("1").Value.Int != 5 {
+		t.Errorf("stored %d, reclaimed %d, latest %d; want 1, 4 and 5", stored, reclaimed, store.read("1").Value.Int)
+	}
+}
+
+func TestApplyLastWriteWins(t *testing.T) {
+	older := Write{Key: "1", Value: Value{Int: 1}, Timestamp: Timestamp{Wall: 10, Node: "n2"}}
+	newer := Write{Key: "1", Value: Value{Int: 2}, Timestamp: Timestamp{Wall: 10, Logical: 1, Node: "n1"}}
+	tied := Write{Key: "1", Value: Value{Int: 3}, Timestamp: Timestamp{Wall: 10, Logical: 1, Node: "n3"}}
+
+	// Replicas receiving the same writes in different orders converge on the latest one
+	for _, order := range [][]Write{{older, newer, tied}, {tied, newer, older}, {newer, tied, older}} {
+		store := NewTxnStore()
+		for _, w := range order {
+			store.Apply([]Write{w})
+		}
+
+		if got := store.read("1").Value.Int; got != 3 {
+			t.Errorf("order %v: value = %d, want 3", order, got)
+		}
+	}
+
+	// A local commit is stamped after everything the store has seen, so it wins
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 1}, Timestamp: Timestamp{Wall: math.MaxInt64 / 2}}})
+	store.Execute([][]any{{"w", 1.0, 2.0}}, ReadCommitted, false, func([]Write) {})
+
+	if got := store.read("1").Value.Int; got != 2 {
+		t.Errorf("local write after remote one from the future: value = %d, want 2", got)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	store := NewTxnStore()
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 5}}})
+	version := store.read("1").Version
+
+	prepared, err := store.Prepare(map[string]int64{"1": version}, []Write{{Key: "2", Value: Value{Int: 7}}}, 0)
+	if err != nil {
+		t.Fatalf("prepare: %s", err)
+	}
+
+	// The keys stay locked until the outcome is known, so a second transaction on them votes no
+	if _, err := store.Prepare(nil, []Write{{Key: "2", Value: Value{Int: 8}}}, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("prepare on locked key: err = %v, want ErrConflict", err)
+	}
+
+	prepared.Commit()
+
+	if got := store.read("2").Value.Int; got != 7 {
+		t.Errorf("after commit key 2 = %d, want 7", got)
+	}
+
+	// The read version is now stale
+	if _, err := store.Prepare(map[string]int64{"1": version - 1}, nil, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("prepare with stale read: err = %v, want ErrConflict", err)
+	}
+
+	prepared, err = store.Prepare(nil, []Write{{Key: "2", Value: Value{Int: 9}}}, 0)
+	if err != nil {
+		t.Fatalf("prepare after commit: %s", err)
+	}
+	prepared.Abort()
+
+	if got := store.read("2").Value.Int; got != 7 {
+		t.Errorf("after abort key 2 = %d, want 7", got)
+	}
+}
+
+func TestCommitTimesOutBehindPreparedTransaction(t *testing.T) {
+	store := NewTxnStore()
+	store.lockTimeout = 10 * time.Millisecond
+
+	// A prepared transaction whose coordinator never answers keeps key 1 locked
+	prepared, err := store.Prepare(nil, []Write{{Key: "1", Value: Value{Int: 1}}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Execute([][]any{{"w", 1.0, 2.0}}, ReadCommitted, false, func([]Write) {})
+	if !errors.Is(err, ErrLockTimeout) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want a lock timeout that's retried as a conflict", err)
+	}
+
+	prepared.Abort()
+
+	if _, err := store.Execute([][]any{{"w", 1.0, 2.0}}, ReadCommitted, false, func([]Write) {}); err != nil {
+		t.Errorf("after the lock is released: %s", err)
+	}
+}
+
+// Moves amount from one key to another, reading both first, and returns whether the transaction committed
+func transfer(store *TxnStore, isolation string, from int, to int, amount int) bool {
+	pending, end := store.Begin(isolation)
+	defer end()
+
+	a, _ := pending.Do([]any{"r", float64(from), nil})
+	b, _ := pending.Do([]any{"r", float64(to), nil})
+
+	// Give concurrent transfers a chance to commit in between
+	runtime.Gosched()
+
+	pending.Do([]any{"w", float64(from), float64(a[2].(int) - amount)})
+	pending.Do([]any{"w", float64(to), float64(b[2].(int) + amount)})
+
+	return store.Commit(pending, isolation, false, func([]Write) {}) == nil
+}
+
+// Returns the sum of every balance, as read by a single transaction
+func total(store *TxnStore, isolation string, accounts int) (int, bool) {
+	pending, end := store.Begin(isolation)
+	defer end()
+
+	sum := 0
+	for account := range accounts {
+		op, _ := pending.Do([]any{"r", float64(account), nil})
+		sum += op[2].(int)
+		runtime.Gosched()
+	}
+
+	return sum, store.Commit(pending, isolation, false, func([]Write) {}) == nil
+}
+
+// Property: random concurrent transfers between a few accounts behave like the same transfers applied
+// one at a time. Every transaction that reads all balances and commits sees the initial total, and each
+// final balance is the initial one plus the transfers that committed, none lost or applied twice.
+func TestConcurrentTransfersMatchSequentialModel(t *testing.T) {
+	const accounts, workers, transfers, initial = 4, 8, 50, 100
+
+	for _, isolation := range []string{Serializable, SnapshotIsolation} {
+		property := func(seed int64) bool {
+			store := NewTxnStore()
+			for account := range accounts {
+				store.Apply([]Write{{Key: strconv.Itoa(account), Value: Value{Int: initial}}})
+			}
+
+			var mu sync.Mutex
+			model := make([]int, accounts)
+			ok := true
+
+			var wg sync.WaitGroup
+			for worker := range workers {
+				rng := rand.New(rand.NewSource(seed + int64(worker)))
+				wg.Go(func() {
+					for range transfers {
+						if rng.Intn(4) == 0 {
+							if sum, committed := total(store, isolation, accounts); committed && sum != initial*accounts {
+								t.Logf("%s: read a total of %d", isolation, sum)
+								mu.Lock()
+								ok = false
+								mu.Unlock()
+							}
+							continue
+						}
+
+						from, to, amount := rng.Intn(accounts), rng.Intn(accounts), rng.Intn(10)
+						if from != to && transfer(store, isolation, from, to, amount) {
+							mu.Lock()
+							model[from] -= amount
+							model[to] += amount
+							mu.Unlock()
+						}
+					}
+				})
+			}
+			wg.Wait()
+
+			for account := range accounts {
+				if got := store.read(strconv.Itoa(account)).Value.Int; got != initial+model[account] {
+					t.Logf("%s: account %d = %d, model says %d", isolation, account, got, initial+model[account])
+					ok = false
+				}
+			}
+			return ok
+		}
+
+		if err := quick.Check(property, &quick.Config{MaxCount: 20}); err != nil {
+			t.Errorf("%s: %s", isolation, err)
+		}
+	}
+}
+
+// Runs many concurrent transactions, each goroutine working on its own range of keys
+func benchmarkTransactions(b *testing.B, execute func(transaction [][]any)) {
+	var next sync.Mutex
+	worker := 0
+
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		next.Lock()
+		base := worker * 1000
+		worker++
+		next.Unlock()
+
+		rng := rand.New(rand.NewSource(int64(base)))
+		for pb.Next() {
+			execute(randomTransaction(rng, base, 1000))
+		}
+	})
+}
+
+// Baseline: every transaction serialized behind one mutex, as the handler used to do
+func BenchmarkTransactionsGlobalLock(b *testing.B) {
+	store := NewTxnStore()
+	var mu sync.Mutex
+
+	benchmarkTransactions(b, func(transaction [][]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		store.Execute(transaction, ReadCommitted, false, func([]Write) {})
+	})
+}
+
+func BenchmarkTransactionsPerKeyLock(b *testing.B) {
+	store := NewTxnStore()
+
+	benchmarkTransactions(b, func(transaction [][]any) {
+		store.Execute(transaction, ReadCommitted, false, func([]Write) {})
+	})
+}
+
+func TestDumpAndLoad(t *testing.T) {
+	store := NewTxnStore()
+	for i := 0; i < 5; i++ {
+		store.Apply([]Write{{Key: strconv.Itoa(i), Value: Value{Int: i}, Timestamp: store.clock.Now()}})
+	}
+
+	// Page through in chunks of two
+	dumped := []Write{}
+	after := ""
+	for {
+		keys, next := store.Dump(after, 2)
+		dumped = append(dumped, keys...)
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	if len(dumped) != 5 {
+		t.Fatalf("dumped %d keys, want 5", len(dumped))
+	}
+
+	loaded := NewTxnStore()
+	if _, err := loaded.Load(dumped); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if got := loaded.read(strconv.Itoa(i)); got.Value.Int != i || got.Timestamp != dumped[i].Timestamp {
+			t.Errorf("key %d = %+v, want value %d written at %+v", i, got, i, dumped[i].Timestamp)
+		}
+	}
+
+	// Loading older data doesn't overwrite newer writes
+	loaded.Apply([]Write{{Key: "0", Value: Value{Int: 100}, Timestamp: loaded.clock.Now()}})
+	loaded.Load(dumped[:1])
+	if got := loaded.read("0").Value.Int; got != 100 {
+		t.Errorf("key 0 after reloading = %d, want 100", got)
+	}
+}
+
+func TestExecuteExpiringWrite(t *testing.T) {
+	store := NewTxnStore()
+
+	if _, err := store.Execute([][]any{{"w", 1.0, 5.0, 20.0}, {"w", 2.0, 6.0}}, ReadCommitted, false, func([]Write) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() [][]any {
+		result, _ := store.Execute([][]any{{"r", 1.0, nil}, {"r", 2.0, nil}}, ReadCommitted, false, func([]Write) {})
+		return result
+	}
+
+	if got := read(); got[0][2] != 5 || got[1][2] != 6 {
+		t.Fatalf("before expiry: %v, want 5 and 6", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if got := read(); got[0][2] != nil || got[1][2] != 6 {
+		t.Errorf("after expiry: %v, want key 1 gone and key 2 still 6", got)
+	}
+
+	if swept := store.Expire(); swept != 1 {
+		t.Errorf("swept %d keys, want 1", swept)
+	}
+	if e := store.read("1"); !e.Deleted || e.Version == 0 {
+		t.Errorf("swept key = %+v, want a tombstone keeping its version", e)
+	}
+}
@@ -0,0 +1,73 @@
+package txn
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Expiring keys
+
+A write can carry a TTL in milliseconds as a fourth element, ["w", key, value, ttl]. It's turned into an
+absolute expiry time when the transaction runs, and that time travels with the write through the log,
+replication, checkpoints and dumps, so every node agrees on when the value expires (up to the skew of
+their clocks). In calvin mode, where every node runs the transaction itself, the node that received it
+stamps it with its time before sequencing it, and every node counts the TTL from that and checks expiry
+against it too, so they all write the same expiry and see the same keys as expired however late they run
+the batch. rsm mode refuses TTLs altogether, see rsm.go.
+
+Once expired a value reads exactly like a deleted key: r returns nil, d and cas see it missing and inc
+starts over from 0. Until it's overwritten the expired value still takes up memory, so every
+TXN_GC_INTERVAL_MS the sweeper replaces it with a tombstone of the same version and timestamp. Nothing a
+transaction or last-write-wins compares changes, so the sweep isn't replicated; every node sweeps its own
+copy. Calvin nodes never sweep: a transaction stamped before the expiry may still be waiting in a batch,
+and it must read the value there as everywhere else.
+*/
+
+// Returns the expiry time of a write with the given TTL operand, counting from now or this node's clock if 0
+func expiryOf(ttl any, now int64) (int64, error) {
+	ms, err := ValueOf(ttl)
+	if err != nil || ms.Raw != nil || ms.Int <= 0 {
+		return 0, fmt.Errorf("ttl %v is not a positive number of milliseconds", ttl)
+	}
+	if now == 0 {
+		now = time.Now().UnixMilli()
+	}
+	return now + int64(ms.Int), nil
+}
+
+// Returns true if a value expiring at expires has expired as of now, or this node's clock if now is 0
+// An expires of 0 never does
+func expired(expires int64, now int64) bool {
+	if expires == 0 {
+		return false
+	}
+	if now == 0 {
+		now = time.Now().UnixMilli()
+	}
+	return expires <= now
+}
+
+// Replaces the latest version of every expired key with a tombstone, returns how many were swept
+// Older versions are left for Collect, a snapshot may still read them
+func (s *TxnStore) Expire() int {
+	swept := 0
+
+	for i := range s.stripes {
+		stripe := &s.stripes[i]
+		stripe.mu.Lock()
+
+		for key, versions := range stripe.kv {
+			latest := &versions[len(versions)-1]
+			if !latest.Deleted && expired(latest.Expires, 0) {
+				*latest = entry{Version: latest.Version, Deleted: true, Timestamp: latest.Timestamp}
+				s.tree.Set(key, entryHash(*latest))
+				swept++
+			}
+		}
+
+		stripe.mu.Unlock()
+	}
+
+	return swept
+}
@@ -0,0 +1,179 @@
+package txn
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/twopc"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Two-phase commit
+
+In sharded mode a transaction touching keys on several nodes is committed by the node that received it,
+acting as coordinator, with the two-phase commit in internal/twopc. Each participant's part is the reads
+it validates and the writes it installs: preparing locks and validates them in the store, and a commit
+installs the writes (see TxnStore.Prepare).
+
+With TXN_WAL_DIR set the coordinator's decisions are kept in <dir>/<node>.2pc next to the write-ahead log,
+so a coordinator that restarts still finishes the commits it had decided.
+*/
+
+const (
+	prepareTimeout     = time.Second
+	participantTimeout = time.Second
+	decisionRetryDelay = 100 * time.Millisecond
+
+	// How long a participant waits for keys locked by another prepared transaction before voting no
+	prepareLockWait = 50 * time.Millisecond
+)
+
+// A participant's part of a transaction
+type Part struct {
+	Reads  map[string]int64 `json:"reads,omitempty"`
+	Writes []Write          `json:"writes,omitempty"`
+}
+
+type TwoPhaseCommit struct {
+	store     *TxnStore
+	shards    *Shards
+	replicate func(writes []Write) // nil unless committed writes are also streamed to replicas
+	committer *twopc.Committer
+
+	mu       sync.Mutex
+	prepared map[string]*Prepared // transactions voted yes on and waiting for the decision
+}
+
+// Creates a two-phase commit coordinator and participant, and registers its handlers on node
+// If replicate isn't nil, every participant passes the writes it commits to it
+func NewTwoPhaseCommit(node *maelstrom.Node, store *TxnStore, shards *Shards, replicate func(writes []Write)) *TwoPhaseCommit {
+	c := &TwoPhaseCommit{
+		store:     store,
+		shards:    shards,
+		replicate: replicate,
+		prepared:  make(map[string]*Prepared),
+	}
+	c.committer = twopc.New(node, c, twopc.Config{
+		PrepareTimeout:     prepareTimeout,
+		ParticipantTimeout: participantTimeout,
+		RetryDelay:         decisionRetryDelay,
+	})
+	return c
+}
+
+// Opens the coordinator log name in store, see twopc.Committer.Open
+func (c *TwoPhaseCommit) Open(store storage.Store, name string) error {
+	return c.committer.Open(store, name)
+}
+
+// Executes a transaction whose keys may live on several nodes
+// Returns ErrConflict if any participant voted no or didn't answer in time, the transaction then had no effects
+func (c *TwoPhaseCommit) Execute(transaction [][]any, validate bool) ([][]any, error) {
+	pending := c.Begin()
+	if err := pending.Run(transaction); err != nil {
+		return nil, err
+	}
+
+	if err := c.Commit(pending, validate); err != nil {
+		return nil, err
+	}
+
+	return pending.Result, nil
+}
+
+// Starts a transaction reading keys from the nodes owning them
+func (c *TwoPhaseCommit) Begin() *Pending {
+	return NewPending(c.shards.Read)
+}
+
+// Commits a transaction's writes on every node owning one of its keys
+func (c *TwoPhaseCommit) Commit(pending *Pending, validate bool) error {
+	// Split the reads to validate and the writes to install by owning node
+	reads := make(map[string]map[string]int64)
+	writes := make(map[string][]Write)
+
+	validated := pending.Checked
+	if validate {
+		validated = pending.Reads
+	}
+
+	for key, version := range validated {
+		owner := c.shards.Owner(key)
+		if reads[owner] == nil {
+			reads[owner] = make(map[string]int64)
+		}
+		reads[owner][key] = version
+	}
+
+	for _, w := range pending.Writes() {
+		owner := c.shards.Owner(w.Key)
+		writes[owner] = append(writes[owner], w)
+	}
+
+	participants := make(map[string]bool)
+	for owner := range reads {
+		participants[owner] = true
+	}
+	for owner := range writes {
+		participants[owner] = true
+	}
+
+	parts := make(map[string]json.RawMessage, len(participants))
+	for owner := range participants {
+		part, err := json.Marshal(Part{Reads: reads[owner], Writes: writes[owner]})
+		if err != nil {
+			return err
+		}
+		parts[owner] = part
+	}
+
+	if !c.committer.Commit(parts) {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Prepares this node's part of a transaction, locking its keys until Finish
+func (c *TwoPhaseCommit) Prepare(txnID string, data json.RawMessage) error {
+	var part Part
+	if err := json.Unmarshal(data, &part); err != nil {
+		return err
+	}
+
+	prepared, err := c.store.Prepare(part.Reads, part.Writes, prepareLockWait)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.prepared[txnID] = prepared
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Installs or drops the writes of a prepared transaction and releases its keys
+func (c *TwoPhaseCommit) Finish(txnID string, commit bool) {
+	c.mu.Lock()
+	prepared, ok := c.prepared[txnID]
+	delete(c.prepared, txnID)
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !commit {
+		prepared.Abort()
+		return
+	}
+
+	writes := prepared.Commit()
+	if c.replicate != nil {
+		c.replicate(writes)
+	}
+}
@@ -0,0 +1,288 @@
+package txn
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// A transaction that has run but not committed yet
+type Pending struct {
+	// The transaction's micro-ops with their results filled in
+	Result [][]any
+
+	// Version of every committed key the transaction read, as of its first read
+	Reads map[string]int64
+
+	// Reads that must be validated at commit even when read validation is off, so that the read-modify-write
+	// micro-ops inc and cas stay atomic under every isolation level
+	Checked map[string]int64
+
+	// Every range the transaction scanned, validated along with Reads, see scan.go
+	Ranges []KeyRange
+
+	// Store version the transaction read as of under snapshot isolation
+	Snapshot int64
+
+	// Unix milliseconds TTL writes count from and expiry is checked against, 0 for this node's clock, see ttl.go
+	Now int64
+
+	// Every write in execution order
+	Log []Write
+
+	// Returns a key's committed state
+	read func(key string) (entry, error)
+
+	// Returns the committed state of every key in a range, nil if this transaction can't scan
+	scan func(from int64, to int64) map[string]entry
+
+	// Position of the last write to each key in Log
+	last map[string]int
+}
+
+// Returns the final write to each key, in execution order
+func (p *Pending) Writes() []Write {
+	writes := []Write{}
+	for i, w := range p.Log {
+		if p.last[w.Key] == i {
+			writes = append(writes, w)
+		}
+	}
+	return writes
+}
+
+func (p *Pending) writtenKeys() []string {
+	keys := []string{}
+	for _, w := range p.Writes() {
+		keys = append(keys, w.Key)
+	}
+	return keys
+}
+
+// Creates an empty transaction reading committed state with read
+func NewPending(read func(key string) (entry, error)) *Pending {
+	return &Pending{
+		Result:  [][]any{},
+		Reads:   make(map[string]int64),
+		Checked: make(map[string]int64),
+		read:    read,
+		last:    make(map[string]int),
+	}
+}
+
+// Runs a transaction's micro-ops against committed state, without changing anything
+// Writes go to a private write-set that later reads in the transaction consult first; they are installed
+// in one step when the transaction commits, so a transaction that fails part way leaves no effects.
+//
+// Keys and values may be any JSON value, see KeyOf and Value. Supported micro-ops:
+//
+//	["r", key, nil]    read, fills in the value (nil if the key doesn't exist)
+//	["w", key, value]  write
+//	["w", key, value, ttl]  write that expires after ttl milliseconds, see ttl.go
+//	["d", key, nil]    delete, fills in whether the key existed
+//	["cas", key, expected, new]  sets key to new only if its current value is expected (nil matches
+//	                             a missing key), appends whether it succeeded
+//	["inc", key, delta]  adds an integer delta to an integer value (a missing key counts as 0),
+//	                     appends the new value
+//	["scan", [from, to], nil]  range read over integer keys, see scan.go
+func (p *Pending) Run(transaction [][]any) error {
+	for _, op := range transaction {
+		if _, err := p.Do(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs a single micro-op and returns a copy of it with its result filled in
+func (p *Pending) Do(op []any) ([]any, error) {
+	txn := slices.Clone(op)
+
+	if txn[0] == "scan" {
+		pairs, err := p.scanRange(txn[1])
+		if err != nil {
+			return nil, err
+		}
+
+		txn[2] = pairs
+		p.Result = append(p.Result, txn)
+		return txn, nil
+	}
+
+	if lookupKey, ok := KeyOf(txn[1]); ok {
+		if txn[0] == "r" {
+			val, exists, err := p.lookup(lookupKey)
+			if err != nil {
+				return nil, err
+			}
+
+			if exists {
+				// Key exists, fetch value
+				txn[2] = val.Any()
+			}
+
+		} else if txn[0] == "w" {
+			val, err := ValueOf(txn[2])
+			if err != nil {
+				return nil, err
+			}
+
+			expires := int64(0)
+			if len(txn) == 4 {
+				if expires, err = expiryOf(txn[3], p.Now); err != nil {
+					return nil, err
+				}
+			}
+
+			p.write(Write{Key: lookupKey, Value: val, Expires: expires})
+
+		} else if txn[0] == "d" {
+			_, exists, err := p.lookup(lookupKey)
+			if err != nil {
+				return nil, err
+			}
+
+			txn[2] = exists
+
+			// Deleting a missing key changes nothing, so there's nothing to replicate
+			if exists {
+				p.write(Write{Key: lookupKey, Deleted: true})
+			}
+
+		} else if txn[0] == "cas" && len(txn) == 4 {
+			newValue, err := ValueOf(txn[3])
+			if err != nil {
+				return nil, err
+			}
+
+			current, exists, err := p.lookup(lookupKey)
+			if err != nil {
+				return nil, err
+			}
+
+			// Either both agree the key is missing, or it holds exactly the expected value
+			swapped := !exists && txn[2] == nil
+			if exists && txn[2] != nil {
+				expected, err := ValueOf(txn[2])
+				if err != nil {
+					return nil, err
+				}
+				swapped = current.Equal(expected)
+			}
+
+			// A concurrent commit to the key in between would let two swaps from the same value both succeed
+			if version, read := p.Reads[lookupKey]; read {
+				p.Checked[lookupKey] = version
+			}
+
+			if swapped {
+				p.write(Write{Key: lookupKey, Value: newValue})
+			}
+
+			txn = append(txn, swapped)
+
+		} else if txn[0] == "inc" {
+			delta, err := ValueOf(txn[2])
+			if err != nil {
+				return nil, err
+			}
+			if delta.Raw != nil {
+				return nil, fmt.Errorf("inc on key %s: delta %s is not an integer", lookupKey, delta.Raw)
+			}
+
+			current, exists, err := p.lookup(lookupKey)
+			if err != nil {
+				return nil, err
+			}
+			if exists && current.Raw != nil {
+				return nil, fmt.Errorf("inc on key %s: value %s is not an integer", lookupKey, current.Raw)
+			}
+
+			// A concurrent commit to the key in between would make this increment overwrite it
+			if version, read := p.Reads[lookupKey]; read {
+				p.Checked[lookupKey] = version
+			}
+
+			newValue := current.Int + delta.Int
+			p.write(Write{Key: lookupKey, Value: Value{Int: newValue}})
+
+			txn = append(txn, newValue)
+		}
+		p.Result = append(p.Result, txn)
+		return txn, nil
+	}
+	return nil, fmt.Errorf("%v on key %v: not a valid key", txn[0], txn[1])
+}
+
+// Returns the key's value as seen by this transaction, its own buffered writes first
+func (p *Pending) lookup(key string) (Value, bool, error) {
+	if i, buffered := p.last[key]; buffered {
+		return p.Log[i].Value, !p.Log[i].Deleted && !expired(p.Log[i].Expires, p.Now), nil
+	}
+
+	e, err := p.read(key)
+	if err != nil {
+		return Value{}, false, err
+	}
+
+	if _, seen := p.Reads[key]; !seen {
+		p.Reads[key] = e.Version
+	}
+
+	return e.Value, e.exists(p.Now), nil
+}
+
+// Returns the [key, value] pairs of every key in a range as seen by this transaction, in key order
+func (p *Pending) scanRange(operand any) ([][]any, error) {
+	from, to, err := rangeOf(operand)
+	if err != nil {
+		return nil, err
+	}
+	if p.scan == nil {
+		return nil, fmt.Errorf("scan: range reads aren't supported across nodes")
+	}
+
+	committed := p.scan(from, to)
+
+	scanned := KeyRange{From: from, To: to, Keys: make(map[string]int64)}
+	values := make(map[string]Value)
+	for key, e := range committed {
+		scanned.Keys[key] = e.Version
+		if e.exists(p.Now) {
+			values[key] = e.Value
+		}
+	}
+	p.Ranges = append(p.Ranges, scanned)
+
+	// The transaction's own writes replace what's committed
+	for key, i := range p.last {
+		if inRange(key, from, to) {
+			if p.Log[i].Deleted || expired(p.Log[i].Expires, p.Now) {
+				delete(values, key)
+			} else {
+				values[key] = p.Log[i].Value
+			}
+		}
+	}
+
+	keys := slices.Collect(maps.Keys(values))
+	slices.SortFunc(keys, func(a, b string) int {
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return cmp.Compare(x, y)
+	})
+
+	pairs := [][]any{}
+	for _, key := range keys {
+		k, _ := strconv.ParseInt(key, 10, 64)
+		pairs = append(pairs, []any{k, values[key].Any()})
+	}
+	return pairs, nil
+}
+
+func (p *Pending) write(w Write) {
+	p.last[w.Key] = len(p.Log)
+	p.Log = append(p.Log, w)
+}
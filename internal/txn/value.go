@@ -0,0 +1,113 @@
+package txn
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// A stored value
+// Integers, which is all Maelstrom's workloads use, are kept unboxed so reading, comparing and
+// replicating them never goes through the JSON encoder. Any other JSON value is kept as its raw encoding.
+type Value struct {
+	Int int
+	Raw json.RawMessage // nil for integer values
+}
+
+// Converts a decoded JSON operand into a Value
+func ValueOf(v any) (Value, error) {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return Value{Int: int(f)}, nil
+	}
+
+	if n, ok := v.(json.Number); ok {
+		if i, ok := intOf(n); ok {
+			return Value{Int: int(i)}, nil
+		}
+		return Value{Raw: json.RawMessage(n)}, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{Raw: raw}, nil
+}
+
+// Returns the value in the form placed into a reply
+func (v Value) Any() any {
+	if v.Raw == nil {
+		return v.Int
+	}
+	return v.Raw
+}
+
+// Returns true if both values have the same JSON encoding
+func (v Value) Equal(other Value) bool {
+	if v.Raw == nil && other.Raw == nil {
+		return v.Int == other.Int
+	}
+
+	a, _ := v.MarshalJSON()
+	b, _ := other.MarshalJSON()
+	return bytes.Equal(a, b)
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Raw == nil {
+		return strconv.AppendInt(nil, int64(v.Int), 10), nil
+	}
+	return v.Raw, nil
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	if i, err := strconv.Atoi(string(data)); err == nil {
+		*v = Value{Int: i}
+		return nil
+	}
+
+	*v = Value{Raw: bytes.Clone(data)}
+	return nil
+}
+
+// Converts a decoded JSON key into the string the store is keyed by
+// Keys are identified by their JSON encoding, so the number 1 and the string "1" stay distinct keys.
+// Integer keys take a fast path around the JSON encoder.
+func KeyOf(v any) (string, bool) {
+	switch k := v.(type) {
+	case nil:
+		return "", false
+	case float64:
+		if k == math.Trunc(k) && math.Abs(k) < 1<<53 {
+			return strconv.FormatInt(int64(k), 10), true
+		}
+	case json.Number:
+		if i, ok := intOf(k); ok {
+			return strconv.FormatInt(i, 10), true
+		}
+		return string(k), true
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+
+	return string(raw), true
+}
+
+// Returns the integer a decoded number denotes, exactly, if it's one that fits in 64 bits
+// Integers written with an exponent or a fraction (1e3, 2.0) count too, as long as a float64 holds them exactly
+func intOf(n json.Number) (int64, bool) {
+	if i, err := n.Int64(); err == nil {
+		return i, true
+	}
+
+	f, err := n.Float64()
+	if err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), true
+	}
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package txn
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+)
+
+/*
+Write-ahead log
+
+With TXN_WAL_DIR set, every write-set installed in the store is appended to <dir>/<node>.wal before it
+becomes visible, and the log is replayed at startup, so a restarted node comes back with the state it had.
+Set TXN_WAL_FSYNC=true to fsync after every append; otherwise a crash of the machine (rather than the
+process) can lose the most recent appends.
+
+The log is kept by a storage.File (see internal/storage): each record is one line, the CRC32 of its JSON
+followed by the JSON. Replay stops at the first record that's incomplete or fails its checksum, which is
+what a crash in the middle of an append leaves behind, and truncates the log there.
+
+Write-sets received from other nodes are logged with their origin and sequence number, so replay also
+restores how far replication from each origin had got.
+
+The log would grow forever, so every TXN_CHECKPOINT_INTERVAL_MS the store is written to a checkpoint and
+the records it covers are dropped from the log, see checkpoint.go.
+*/
+
+type WALRecord struct {
+	Origin string  `json:"origin,omitempty"` // empty for transactions committed on this node
+	Seq    int64   `json:"seq,omitempty"`
+	Writes []Write `json:"writes"`
+}
+
+type WAL struct {
+	log storage.Log
+}
+
+// Opens the log name in store, creating it if needed, and returns it with every intact record in it
+func OpenWAL(store storage.Store, name string) (*WAL, []WALRecord, error) {
+	log, data, err := store.OpenLog(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]WALRecord, len(data))
+	for i, record := range data {
+		if err := json.Unmarshal(record, &records[i]); err != nil {
+			log.Close()
+			return nil, nil, fmt.Errorf("wal record %d: %w", i, err)
+		}
+	}
+
+	return &WAL{log: log}, records, nil
+}
+
+// Appends a record, and fsyncs it if the store is configured to
+func (w *WAL) Append(record WALRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return w.log.Append(data)
+}
+
+// Returns the size of the log, the offset the next record is appended at
+func (w *WAL) Size() int64 {
+	return w.log.Size()
+}
+
+// Drops the records before offset, which must be the end of a record, keeping the ones after it
+func (w *WAL) Discard(offset int64) error {
+	return w.log.Discard(offset)
+}
@@ -0,0 +1,104 @@
+package txn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/storage"
+)
+
+func TestWALReplaysIntactRecords(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "n0.wal")
+
+	wal, records, err := OpenWAL(storage.NewFile(dir, true), "n0.wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("new log has %d records", len(records))
+	}
+
+	wal.Append(WALRecord{Writes: []Write{{Key: "1", Value: Value{Int: 5}}}})
+	wal.Append(WALRecord{Origin: "n1@1", Seq: 1, Writes: []Write{{Key: "2", Value: Value{Int: 6}}}})
+	wal.log.Close()
+
+	// A crash in the middle of an append leaves a partial record behind
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	file.WriteString(`00000000 {"writes":[{"key"`)
+	file.Close()
+
+	wal, records, err = OpenWAL(storage.NewFile(dir, false), "n0.wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Origin != "n1@1" || records[1].Writes[0].Value.Int != 6 {
+		t.Fatalf("records = %+v, want the two complete ones", records)
+	}
+
+	// New records go after the intact ones, replacing the partial record
+	wal.Append(WALRecord{Writes: []Write{{Key: "3", Value: Value{Int: 7}}}})
+	wal.log.Close()
+
+	_, records, _ = OpenWAL(storage.NewFile(dir, false), "n0.wal")
+	if len(records) != 3 {
+		t.Errorf("after appending past a torn record: %d records, want 3", len(records))
+	}
+
+	store := NewTxnStore()
+	store.Recover(nil, Checkpoint{}, records)
+	if got := store.read("2").Value.Int; got != 6 {
+		t.Errorf("recovered key 2 = %d, want 6", got)
+	}
+}
+
+func TestCheckpointTruncatesWAL(t *testing.T) {
+	files := storage.NewFile(t.TempDir(), false)
+
+	wal, _, err := OpenWAL(files, "n0.wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewTxnStore()
+	store.Recover(wal, Checkpoint{}, nil)
+	store.Apply([]Write{{Key: "1", Value: Value{Int: 5}}})
+	store.ApplyReplicated("n1@1", 3, []Write{{Key: "2", Value: Value{Int: 6}}})
+
+	if err := store.WriteCheckpoint(files, "n0.checkpoint", func() [][]Write { return [][]Write{{{Key: "1"}}} }); err != nil {
+		t.Fatal(err)
+	}
+	if wal.Size() != 0 {
+		t.Errorf("log size after checkpoint = %d, want 0", wal.Size())
+	}
+
+	// Written after the checkpoint, so only in the log
+	store.Apply([]Write{{Key: "1", Deleted: true, Timestamp: store.clock.Now()}})
+	wal.log.Close()
+
+	wal, records, err := OpenWAL(files, "n0.wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := ReadCheckpoint(files, "n0.checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || len(checkpoint.Unacked) != 1 {
+		t.Fatalf("recovered %d records and %d unacked write-sets, want 1 and 1", len(records), len(checkpoint.Unacked))
+	}
+
+	recovered := NewTxnStore()
+	recovered.Recover(wal, checkpoint, records)
+
+	if e := recovered.read("1"); !e.Deleted {
+		t.Errorf("key 1 = %+v, want it deleted by the record after the checkpoint", e)
+	}
+	if got := recovered.read("2").Value.Int; got != 6 {
+		t.Errorf("key 2 = %d, want 6", got)
+	}
+	if got := recovered.Origins()["n1@1"]; got != 3 {
+		t.Errorf("origin n1@1 applied up to %d, want 3", got)
+	}
+}
@@ -0,0 +1,88 @@
+package uniqueids
+
+import (
+	"sync"
+)
+
+/*
+Collision audit
+
+With AUDIT_IDS=<n> set, a node remembers up to n of the IDs it generates and counts any it generates
+twice (in the same namespace, see namespace.go). The verify RPC reports those counts and the duplicates themselves, so stress tests can check
+uniqueness without parsing Maelstrom's history.
+
+Memory stays bounded: once n IDs are remembered, later ones are only counted, not checked (reported
+as generated but not audited). Only IDs generated by this node are compared, to catch duplicates
+between nodes call verify on each and compare those. A plain set is used rather than a Bloom filter
+because a false positive would report a duplicate that never happened.
+*/
+
+// At most this many duplicates are listed in a verify reply, the count covers the rest
+const maxListedDuplicates = 100
+
+type VerifyResponseBody struct {
+	Type           string `json:"type"`
+	Generated      int64  `json:"generated"`
+	Audited        int    `json:"audited"`
+	DuplicateCount int64  `json:"duplicate_count"`
+	Duplicates     []any  `json:"duplicates"`
+}
+
+type auditedID struct {
+	namespace string
+	id        any
+}
+
+type Audit struct {
+	mu             sync.Mutex
+	capacity       int
+	seen           map[auditedID]struct{}
+	generated      int64
+	duplicateCount int64
+	duplicates     []any
+}
+
+// Returns an audit remembering up to capacity IDs, nil if capacity is 0 and auditing is off
+func NewAudit(capacity int) *Audit {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &Audit{
+		capacity: capacity,
+		seen:     map[auditedID]struct{}{},
+	}
+}
+
+// Records IDs newly generated in namespace
+func (a *Audit) Record(namespace string, ids []any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range ids {
+		a.generated++
+
+		key := auditedID{namespace, id}
+		if _, ok := a.seen[key]; ok {
+			a.duplicateCount++
+			if len(a.duplicates) < maxListedDuplicates {
+				a.duplicates = append(a.duplicates, id)
+			}
+		} else if len(a.seen) < a.capacity {
+			a.seen[key] = struct{}{}
+		}
+	}
+}
+
+func (a *Audit) Response() VerifyResponseBody {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return VerifyResponseBody{
+		Type:           "verify_ok",
+		Generated:      a.generated,
+		Audited:        len(a.seen),
+		DuplicateCount: a.duplicateCount,
+		Duplicates:     append([]any{}, a.duplicates...),
+	}
+}
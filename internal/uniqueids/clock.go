@@ -0,0 +1,105 @@
+package uniqueids
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Clock skew
+
+Snowflake IDs and ULIDs start with a timestamp, so if the clock jumps backwards a node could generate an
+ID it already has, or one that sorts before those it generated earlier. Both remember the last
+millisecond they used and never go below it; what they do while the clock is behind it is set by
+CLOCK_SKEW_POLICY:
+  - borrow (default): keep using the last millisecond, taking the rest of its sequence space. If that runs
+    out, wait for the clock to pass it
+  - wait: wait for the clock to catch up before generating anything
+  - fail: refuse to generate until the clock has caught up
+
+Whatever the policy, a clock more than CLOCK_SKEW_MAX_MS (default 1000) behind fails requests rather than
+borrowing or waiting that long. Requests that fail get TemporarilyUnavailable, so the client can retry.
+Every backwards jump is counted and reported by the stats RPC, along with the largest one seen.
+*/
+
+const (
+	SkewBorrow = "borrow"
+	SkewWait   = "wait"
+	SkewFail   = "fail"
+
+	defaultMaxSkew = time.Second
+)
+
+type ClockGuard struct {
+	policy  string
+	maxSkew int64 // ms
+
+	behind  atomic.Bool  // whether the clock was behind the last time it was checked
+	events  atomic.Int64 // backwards jumps seen
+	largest atomic.Int64 // largest skew seen, in ms
+}
+
+type ClockSkewStats struct {
+	Policy  string `json:"policy"`
+	Events  int64  `json:"events"`
+	Largest int64  `json:"largest_ms"`
+}
+
+// Returns the guard handling backwards jumps with policy, failing requests past maxSkew
+func NewClockGuard(policy string, maxSkew time.Duration) *ClockGuard {
+	return &ClockGuard{
+		policy:  policy,
+		maxSkew: maxSkew.Milliseconds(),
+	}
+}
+
+// Returns the millisecond to generate the next ID in, never below last, the last one used
+// clock returns the current millisecond; the caller holds its generator's lock
+func (c *ClockGuard) Check(last int64, clock func() int64) (int64, error) {
+	now := clock()
+	if now >= last {
+		c.behind.Store(false)
+		return now, nil
+	}
+
+	skew := last - now
+	if !c.behind.Swap(true) {
+		c.events.Add(1)
+	}
+	for {
+		largest := c.largest.Load()
+		if skew <= largest || c.largest.CompareAndSwap(largest, skew) {
+			break
+		}
+	}
+
+	if skew > c.maxSkew || c.policy == SkewFail {
+		return 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("clock is %dms behind the last ID generated", skew))
+	}
+
+	if c.policy == SkewWait {
+		return waitPast(last-1, clock), nil
+	}
+	return last, nil
+}
+
+// Waits for clock to pass ms and returns the new millisecond
+func waitPast(ms int64, clock func() int64) int64 {
+	now := clock()
+	for now <= ms {
+		time.Sleep(100 * time.Microsecond)
+		now = clock()
+	}
+	return now
+}
+
+func (c *ClockGuard) Stats() ClockSkewStats {
+	return ClockSkewStats{
+		Policy:  c.policy,
+		Events:  c.events.Load(),
+		Largest: c.largest.Load(),
+	}
+}
@@ -0,0 +1,84 @@
+package uniqueids
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Returns a clock reading each of readings in turn, then the last one forever
+func readings(readings ...int64) func() int64 {
+	return func() int64 {
+		now := readings[0]
+		if len(readings) > 1 {
+			readings = readings[1:]
+		}
+		return now
+	}
+}
+
+func TestClockGuardCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		clock  func() int64
+		want   int64 // 0 for TemporarilyUnavailable
+	}{
+		{"borrow ahead", SkewBorrow, readings(150), 150},
+		{"borrow behind", SkewBorrow, readings(90), 100},
+		{"wait ahead", SkewWait, readings(150), 150},
+		{"wait behind", SkewWait, readings(90, 95, 100), 100},
+		{"fail ahead", SkewFail, readings(150), 150},
+		{"fail behind", SkewFail, readings(90), 0},
+		{"borrow at max skew", SkewBorrow, readings(50), 100},
+		{"borrow past max skew", SkewBorrow, readings(40), 0},
+		{"wait past max skew", SkewWait, readings(40, 100), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guard := NewClockGuard(test.policy, 50*time.Millisecond)
+			ms, err := guard.Check(100, test.clock)
+
+			var rpcErr *maelstrom.RPCError
+			if test.want == 0 {
+				if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+					t.Errorf("got %d, %v, want TemporarilyUnavailable", ms, err)
+				}
+			} else if err != nil || ms != test.want {
+				t.Errorf("got %d, %v, want %d", ms, err, test.want)
+			}
+		})
+	}
+}
+
+func TestClockGuardCountsEachJumpOnce(t *testing.T) {
+	guard := NewClockGuard(SkewBorrow, time.Second)
+
+	// Two checks while behind are one jump, catching up and falling behind again is another
+	for _, now := range []int64{90, 80, 100, 70} {
+		guard.Check(100, readings(now))
+	}
+	if stats := guard.Stats(); stats.Events != 2 || stats.Largest != 30 {
+		t.Errorf("stats = %+v, want 2 jumps, the largest 30ms", stats)
+	}
+}
+
+func TestSnowflakeExhaustedWhileBorrowing(t *testing.T) {
+	s := newTestSnowflake(1, readings(90, 90, 100, 101))
+	s.lastMs, s.sequence = 100, 1<<snowflakeSequenceBits-1
+
+	// The borrowed millisecond has no sequence numbers left, so the next ID waits for the clock to pass it
+	s.mu.Lock()
+	id, err := s.next()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ms := id >> (snowflakeNodeBits + snowflakeSequenceBits); ms != 101 || s.sequence != 0 || s.exhausted != 1 {
+		t.Errorf("id in ms %d with sequence %d, %d exhaustions; want ms 101, sequence 0, 1 exhaustion", ms, s.sequence, s.exhausted)
+	}
+}
@@ -0,0 +1,449 @@
+package uniqueids
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/election"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Coordinator-leased ranges
+
+Like the lease scheme, nodes hand out integers from blocks they lease, but instead of a lin-kv counter
+the blocks come from one of the nodes, the coordinator, with range_lease. It's a contrast to UUIDs:
+uniqueness here comes from coordination rather than from randomness.
+
+The coordinator is the leader of a bully election among the nodes (see internal/election), the first
+node in sorted order that's up. While it isn't settled, or when the leader doesn't answer, nodes try the
+others in sorted order, skipping those they found unresponsive in the last suspectTimeout, and the first
+that answers takes over. A node that loses the election stops being coordinator, and takes over again,
+with a new epoch, the next time it's asked for a block.
+Each block is only good for COORDINATOR_LEASE_MS (default 10s), then whatever is left of it is dropped
+and a new one leased, so IDs from different nodes stay roughly in the order they were generated.
+
+A new coordinator has to carry on after the last block the old one handed out, even though the old one
+may have died right after, and two nodes may both think they're the coordinator during a partition.
+So the end of the last block handed out, the mark, is kept on a majority of nodes, in the style of
+Paxos:
+  - to take over, a coordinator picks an epoch higher than any it has seen and asks every node for its
+    mark with range_prepare; a node that answers promises to ignore lower epochs from then on
+  - once a majority has answered, it hands out blocks from the highest mark they returned
+  - before handing out a block, it has a majority accept the block's end as the new mark with
+    range_accept. Rejected, because some node promised a higher epoch, it stops being coordinator
+Any majority a new coordinator asks includes a node that accepted every block handed out, so blocks
+never overlap. Without a majority no blocks are handed out, and nodes fail requests once theirs run out.
+*/
+
+const (
+	coordinatorLease   = 10 * time.Second
+	coordinatorTimeout = time.Second
+	quorumTimeout      = 300 * time.Millisecond
+	suspectTimeout     = 5 * time.Second
+)
+
+type RangeLeaseRequestBody struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+type RangeLeaseResponseBody struct {
+	Type  string `json:"type"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+type RangePrepareRequestBody struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace,omitempty"`
+	Epoch     int64  `json:"epoch"`
+}
+
+type RangePrepareResponseBody struct {
+	Type string `json:"type"`
+	Mark int64  `json:"mark"`
+}
+
+type RangeAcceptRequestBody struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace,omitempty"`
+	Epoch     int64  `json:"epoch"`
+	Mark      int64  `json:"mark"`
+}
+
+type RangeAcceptResponseBody struct {
+	Type string `json:"type"`
+}
+
+type Coordinated struct {
+	namespace string
+	node      *maelstrom.Node
+	blockSize int64
+	lease     time.Duration
+	elector   election.Elector // nil until HandleCoordination sets it
+
+	// The blocks this node hands out IDs from
+	mu        sync.Mutex
+	start     int64
+	next      int64
+	end       int64
+	expires   time.Time
+	leases    int64
+	suspected map[string]time.Time // nodes that didn't answer range_lease, and when
+
+	// This node as coordinator, grants are one at a time
+	coordinatorMu sync.Mutex
+	epoch         int64 // 0 when not coordinator
+	granted       int64 // end of the last block handed out
+
+	// This node's copy of the mark
+	markMu   sync.Mutex
+	promised int64
+	mark     int64
+}
+
+func (g *Coordinated) Init(n *maelstrom.Node) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.node = n
+	g.suspected = map[string]time.Time{}
+	return nil
+}
+
+func (g *Coordinated) Generate(count int) ([]any, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]any, count)
+	for i := range ids {
+		if g.next == g.end || time.Now().After(g.expires) {
+			// Lease enough for the rest of the batch in one go if it's bigger than a block
+			if err := g.leaseBlock(max(g.blockSize, int64(count-i))); err != nil {
+				return nil, err
+			}
+		}
+		ids[i] = g.next
+		g.next++
+	}
+	return ids, nil
+}
+
+// Leases a block of size IDs from the first coordinator that grants one, must be called with g.mu held
+func (g *Coordinated) leaseBlock(size int64) error {
+	for _, candidate := range g.candidates() {
+		var start, end int64
+		var err error
+		if candidate == g.node.ID() {
+			start, end, err = g.grant(size)
+		} else {
+			start, end, err = g.leaseFrom(candidate, size)
+		}
+
+		if err != nil {
+			g.suspected[candidate] = time.Now()
+			continue
+		}
+
+		g.start, g.next, g.end = start, start, end
+		g.expires = time.Now().Add(g.lease)
+		g.leases++
+		return nil
+	}
+
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "no coordinator could lease IDs")
+}
+
+// Returns the nodes that could be coordinator, in the order to try them, the elected one first, must be
+// called with g.mu held
+func (g *Coordinated) candidates() []string {
+	leader := ""
+	if g.elector != nil {
+		leader = g.elector.Leader()
+	}
+
+	candidates := []string{}
+	if leader != "" {
+		candidates = append(candidates, leader)
+	}
+	for _, id := range slices.Sorted(slices.Values(g.node.NodeIDs())) {
+		if id != leader && time.Since(g.suspected[id]) >= suspectTimeout {
+			candidates = append(candidates, id)
+		}
+	}
+	return candidates
+}
+
+func (g *Coordinated) leaseFrom(coordinator string, size int64) (int64, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), coordinatorTimeout)
+	defer cancel()
+
+	msg, err := g.node.SyncRPC(ctx, coordinator, RangeLeaseRequestBody{
+		Type:      "range_lease",
+		Namespace: g.namespace,
+		Size:      size,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var body RangeLeaseResponseBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return 0, 0, err
+	}
+	return body.Start, body.End, nil
+}
+
+// Hands out the next block of size IDs as coordinator, taking over first if this node isn't one
+func (g *Coordinated) grant(size int64) (int64, int64, error) {
+	g.coordinatorMu.Lock()
+	defer g.coordinatorMu.Unlock()
+
+	if g.epoch == 0 {
+		if err := g.takeOver(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	mark := g.granted + size
+	accepted := g.quorum(func(peer string) error {
+		if peer == g.node.ID() {
+			return g.accept(g.epoch, mark)
+		}
+		_, err := g.rpc(peer, RangeAcceptRequestBody{Type: "range_accept", Namespace: g.namespace, Epoch: g.epoch, Mark: mark})
+		return err
+	})
+	if !accepted {
+		// Another node may have taken over, take over again next time
+		g.epoch = 0
+		return 0, 0, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "a majority didn't accept the lease")
+	}
+
+	start := g.granted
+	g.granted = mark
+	return start, mark, nil
+}
+
+// Stops being coordinator, the next grant takes over again with a new epoch
+func (g *Coordinated) stepDown() {
+	g.coordinatorMu.Lock()
+	defer g.coordinatorMu.Unlock()
+	g.epoch = 0
+}
+
+// Becomes coordinator with a new epoch, must be called with g.coordinatorMu held
+func (g *Coordinated) takeOver() error {
+	nodes := slices.Sorted(slices.Values(g.node.NodeIDs()))
+
+	// Higher than any epoch this node has promised, and unlike any other node's: epoch % cluster size is
+	// the node's position
+	g.markMu.Lock()
+	epoch := (g.promised/int64(len(nodes))+1)*int64(len(nodes)) + int64(slices.Index(nodes, g.node.ID()))
+	g.markMu.Unlock()
+
+	var mu sync.Mutex
+	highest := int64(0)
+	promised := g.quorum(func(peer string) error {
+		var mark int64
+		if peer == g.node.ID() {
+			var err error
+			if mark, err = g.prepare(epoch); err != nil {
+				return err
+			}
+		} else {
+			msg, err := g.rpc(peer, RangePrepareRequestBody{Type: "range_prepare", Namespace: g.namespace, Epoch: epoch})
+			if err != nil {
+				return err
+			}
+
+			var body RangePrepareResponseBody
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mark = body.Mark
+		}
+
+		mu.Lock()
+		highest = max(highest, mark)
+		mu.Unlock()
+		return nil
+	})
+	if !promised {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "a majority didn't promise the epoch")
+	}
+
+	g.epoch, g.granted = epoch, highest
+	return nil
+}
+
+// Calls send for every node at once and returns whether a majority succeeded
+func (g *Coordinated) quorum(send func(peer string) error) bool {
+	nodes := g.node.NodeIDs()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	succeeded := 0
+	for _, peer := range nodes {
+		wg.Go(func() {
+			if send(peer) == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	return succeeded > len(nodes)/2
+}
+
+func (g *Coordinated) rpc(peer string, body any) (maelstrom.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), quorumTimeout)
+	defer cancel()
+
+	return g.node.SyncRPC(ctx, peer, body)
+}
+
+// Promises to ignore epochs below epoch and returns this node's mark
+func (g *Coordinated) prepare(epoch int64) (int64, error) {
+	g.markMu.Lock()
+	defer g.markMu.Unlock()
+
+	if epoch <= g.promised {
+		return 0, maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("epoch %d is promised", g.promised))
+	}
+	g.promised = epoch
+	return g.mark, nil
+}
+
+// Raises this node's mark to mark, unless a higher epoch was promised
+func (g *Coordinated) accept(epoch int64, mark int64) error {
+	g.markMu.Lock()
+	defer g.markMu.Unlock()
+
+	if epoch < g.promised {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("epoch %d is promised", g.promised))
+	}
+	g.promised = epoch
+	g.mark = max(g.mark, mark)
+	return nil
+}
+
+func (g *Coordinated) Decode(raw json.RawMessage) (DecodedID, error) {
+	if _, err := intID(raw); err != nil {
+		return DecodedID{}, err
+	}
+	return DecodedID{Scheme: "coordinator"}, nil
+}
+
+func (g *Coordinated) Utilization() Utilization {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return Utilization{Used: g.next - g.start, Capacity: g.end - g.start, Exhausted: g.leases}
+}
+
+// Registers the handlers of the coordinator scheme's RPCs and, if it's the node's scheme, starts electing
+// the coordinator
+func HandleCoordination(n *maelstrom.Node, namespaces *Namespaces) error {
+	if namespaces.Scheme() == "coordinator" {
+		elector, err := election.NewBully(n, "range", election.Callbacks{Deposed: func() {
+			for _, g := range namespaces.coordinated() {
+				g.stepDown()
+			}
+		}})
+		if err != nil {
+			return err
+		}
+		namespaces.setElector(elector)
+		lifecycle.Of(n).Go(elector.Run)
+	}
+
+	// Returns the coordinated generator of namespace
+	coordinated := func(namespace string) (*Coordinated, error) {
+		generator, err := namespaces.Get(namespace)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := generator.(*Coordinated)
+		if !ok {
+			return nil, maelstrom.NewRPCError(maelstrom.NotSupported, "this node isn't using the coordinator scheme")
+		}
+		return g, nil
+	}
+
+	n.Handle("range_lease", func(msg maelstrom.Message) error {
+		var body RangeLeaseRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		g, err := coordinated(body.Namespace)
+		if err != nil {
+			return err
+		}
+
+		start, end, err := g.grant(body.Size)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, RangeLeaseResponseBody{
+			Type:  "range_lease_ok",
+			Start: start,
+			End:   end,
+		})
+	})
+
+	n.Handle("range_prepare", func(msg maelstrom.Message) error {
+		var body RangePrepareRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		g, err := coordinated(body.Namespace)
+		if err != nil {
+			return err
+		}
+
+		mark, err := g.prepare(body.Epoch)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, RangePrepareResponseBody{
+			Type: "range_prepare_ok",
+			Mark: mark,
+		})
+	})
+
+	n.Handle("range_accept", func(msg maelstrom.Message) error {
+		var body RangeAcceptRequestBody
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		g, err := coordinated(body.Namespace)
+		if err != nil {
+			return err
+		}
+
+		if err := g.accept(body.Epoch, body.Mark); err != nil {
+			return err
+		}
+
+		return n.Reply(msg, RangeAcceptResponseBody{Type: "range_accept_ok"})
+	})
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package uniqueids
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Returns a coordinated generator on a cluster of one node, which is its own coordinator and majority
+func newTestCoordinated(blockSize int64) *Coordinated {
+	n := maelstrom.NewNode()
+	n.Stdout = io.Discard
+	n.Init("n0", []string{"n0"})
+
+	g := &Coordinated{blockSize: blockSize, lease: time.Minute}
+	g.Init(n)
+	return g
+}
+
+func TestCoordinatedAcceptRejectsLowerEpochs(t *testing.T) {
+	g := newTestCoordinated(10)
+
+	if _, err := g.prepare(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.accept(3, 100); err == nil {
+		t.Error("accepted a mark from an epoch below the one promised")
+	}
+	if g.mark != 0 {
+		t.Errorf("mark = %d after a rejected accept, want 0", g.mark)
+	}
+
+	// The promised epoch and higher ones are accepted, and the mark never goes down
+	if err := g.accept(5, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.accept(8, 10); err != nil {
+		t.Fatal(err)
+	}
+	if g.mark != 20 || g.promised != 8 {
+		t.Errorf("mark %d promised %d, want 20 and 8", g.mark, g.promised)
+	}
+
+	if _, err := g.prepare(8); err == nil {
+		t.Error("prepared an epoch that was already promised")
+	}
+}
+
+func TestCoordinatedGenerateSpansBlocks(t *testing.T) {
+	g := newTestCoordinated(3)
+
+	first, err := g.Generate(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rest of the first block, then the start of a new one
+	second, err := g.Generate(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := append(first, second...)
+	for i, id := range ids {
+		if id != int64(i) {
+			t.Fatalf("ids = %v, want 0 to 4", ids)
+		}
+	}
+	if g.leases != 2 {
+		t.Errorf("leased %d blocks, want 2", g.leases)
+	}
+
+	// What's left of a batch bigger than a block is leased in one go
+	if ids, _ := g.Generate(7); ids[0] != int64(5) || ids[6] != int64(11) || g.leases != 3 {
+		t.Errorf("ids = %v after %d leases, want 5 to 11 with a single third lease", ids, g.leases)
+	}
+}
@@ -0,0 +1,180 @@
+package uniqueids
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Decoding IDs
+
+The decode_id RPC takes an ID generated by this node's scheme and returns what it's made of, which helps
+when debugging why a workload saw IDs in some order: when it was generated (ms since the Unix epoch),
+by which node, and its sequence number among the IDs generated around the same time. Only the
+components a scheme actually encodes are returned, random UUIDs for instance have none but the scheme.
+An ID returned in base62 is decoded with format set to base62 (see format.go).
+*/
+
+type DecodeIDRequestBody struct {
+	Type      string          `json:"type"`
+	MsgID     int             `json:"msg_id"`
+	ID        json.RawMessage `json:"id"`
+	Namespace string          `json:"namespace,omitempty"`
+	Format    string          `json:"format,omitempty"`
+}
+
+type DecodedID struct {
+	Scheme    string `json:"scheme"`
+	Timestamp *int64 `json:"timestamp,omitempty"`
+	Node      any    `json:"node,omitempty"`
+	Sequence  *int64 `json:"sequence,omitempty"`
+}
+
+type DecodeIDResponseBody struct {
+	Type string `json:"type"`
+	DecodedID
+}
+
+// Parses an integer ID, written as a JSON number or a string
+func intID(raw json.RawMessage) (int64, error) {
+	s := string(raw)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, malformedID(raw)
+	}
+	return id, nil
+}
+
+// Parses a string ID
+func stringID(raw json.RawMessage) (string, error) {
+	var id string
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return "", malformedID(raw)
+	}
+	return id, nil
+}
+
+// Returns raw, a base62 ID, as the decimal integer it encodes
+func fromBase62ID(raw json.RawMessage) (json.RawMessage, error) {
+	s, err := stringID(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := fromBase62(s)
+	if !ok {
+		return nil, malformedID(raw)
+	}
+	return strconv.AppendInt(nil, id, 10), nil
+}
+
+func malformedID(raw json.RawMessage) error {
+	return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("%s is not an ID of this node's scheme", raw))
+}
+
+func (g *UUID4) Decode(raw json.RawMessage) (DecodedID, error) {
+	if _, err := parseUUID(raw, 4); err != nil {
+		return DecodedID{}, err
+	}
+	return DecodedID{Scheme: "uuid4"}, nil
+}
+
+// Version 7 UUIDs start with 48 bits of milliseconds, then the version and 12 bits this library
+// uses as a sequence number within the millisecond
+func (g *UUID7) Decode(raw json.RawMessage) (DecodedID, error) {
+	id, err := parseUUID(raw, 7)
+	if err != nil {
+		return DecodedID{}, err
+	}
+
+	timestamp := int64(id[0])<<40 | int64(id[1])<<32 | int64(id[2])<<24 | int64(id[3])<<16 | int64(id[4])<<8 | int64(id[5])
+	sequence := int64(id[6]&0x0f)<<8 | int64(id[7])
+	return DecodedID{Scheme: "uuid7", Timestamp: &timestamp, Sequence: &sequence}, nil
+}
+
+func parseUUID(raw json.RawMessage, version uuid.Version) (uuid.UUID, error) {
+	s, err := stringID(raw)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	id, err := uuid.Parse(s)
+	if err != nil || id.Version() != version {
+		return uuid.UUID{}, malformedID(raw)
+	}
+	return id, nil
+}
+
+func (g *ULID) Decode(raw json.RawMessage) (DecodedID, error) {
+	s, err := stringID(raw)
+	if err != nil {
+		return DecodedID{}, err
+	}
+
+	id, ok := parseULID(s)
+	if !ok {
+		return DecodedID{}, malformedID(raw)
+	}
+
+	timestamp := int64(id[0])<<40 | int64(id[1])<<32 | int64(id[2])<<24 | int64(id[3])<<16 | int64(id[4])<<8 | int64(id[5])
+	return DecodedID{Scheme: "ulid", Timestamp: &timestamp}, nil
+}
+
+func (s *Snowflake) Decode(raw json.RawMessage) (DecodedID, error) {
+	id, err := intID(raw)
+	if err != nil {
+		return DecodedID{}, err
+	}
+
+	timestamp := id>>(snowflakeNodeBits+snowflakeSequenceBits) + snowflakeEpoch.UnixMilli()
+	node := id >> snowflakeSequenceBits & (1<<snowflakeNodeBits - 1)
+	sequence := id & (1<<snowflakeSequenceBits - 1)
+	return DecodedID{Scheme: "snowflake", Timestamp: &timestamp, Node: node, Sequence: &sequence}, nil
+}
+
+// The node is the ID's position among the sorted node IDs, the sequence how many IDs it had generated before
+func (g *Sequential) Decode(raw json.RawMessage) (DecodedID, error) {
+	id, err := intID(raw)
+	if err != nil {
+		return DecodedID{}, err
+	}
+
+	g.mu.Lock()
+	step := g.step
+	g.mu.Unlock()
+
+	sequence := id / step
+	return DecodedID{Scheme: "sequential", Node: id % step, Sequence: &sequence}, nil
+}
+
+func (g *Counter) Decode(raw json.RawMessage) (DecodedID, error) {
+	s, err := stringID(raw)
+	if err != nil {
+		return DecodedID{}, err
+	}
+
+	// Node IDs could contain a -, the counter can't
+	i := strings.LastIndex(s, "-")
+	sequence, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if i < 0 || err != nil {
+		return DecodedID{}, malformedID(raw)
+	}
+	return DecodedID{Scheme: "counter", Node: s[:i], Sequence: &sequence}, nil
+}
+
+// Leased IDs are just numbers, the block they came from isn't recorded anywhere
+func (g *Lease) Decode(raw json.RawMessage) (DecodedID, error) {
+	if _, err := intID(raw); err != nil {
+		return DecodedID{}, err
+	}
+	return DecodedID{Scheme: "lease"}, nil
+}
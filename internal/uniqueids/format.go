@@ -0,0 +1,107 @@
+package uniqueids
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Output formats
+
+By default IDs are returned as each scheme makes them: strings for UUIDs, ULIDs and counter IDs, JSON
+integers for the rest. ID_FORMAT picks another format for every reply, and a generate request's format
+field for just that one:
+  - string: integers are returned as decimal strings too
+  - integer: IDs are returned as JSON integers no larger than 2^53-1, the largest that every JSON reader
+    (JavaScript's included) represents exactly. Sequential and leased IDs stay well below it for any
+    realistic run, snowflake IDs don't fit and string IDs aren't integers, so those requests fail
+  - base62: integer IDs are returned as short strings of digits and letters, for handles such as short
+    URLs. They aren't padded, so they only sort like the integers when they're the same length. decode_id
+    takes them back with its format field set to base62
+
+Formats other than native can't be combined with a payload's ID or a string scheme, except string.
+*/
+
+const (
+	FormatNative  = "native"
+	FormatString  = "string"
+	FormatInteger = "integer"
+	FormatBase62  = "base62"
+
+	base62Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	maxSafeInteger = 1<<53 - 1
+)
+
+// Returns an error if format isn't one of the above, "" means native
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatNative, FormatString, FormatInteger, FormatBase62:
+		return nil
+	default:
+		return fmt.Errorf("unknown ID format %q", format)
+	}
+}
+
+// Returns ids in format
+func formatIDs(ids []any, format string) ([]any, error) {
+	if format == "" || format == FormatNative {
+		return ids, nil
+	}
+
+	formatted := make([]any, len(ids))
+	for i, id := range ids {
+		switch id := id.(type) {
+		case int64:
+			if format == FormatString {
+				formatted[i] = strconv.FormatInt(id, 10)
+			} else if format == FormatBase62 {
+				formatted[i] = toBase62(id)
+			} else if id > maxSafeInteger {
+				return nil, maelstrom.NewRPCError(maelstrom.NotSupported, fmt.Sprintf("ID %d is larger than 2^53-1", id))
+			} else {
+				formatted[i] = id
+			}
+		case string:
+			if format == FormatInteger || format == FormatBase62 {
+				return nil, maelstrom.NewRPCError(maelstrom.NotSupported, fmt.Sprintf("ID %q isn't an integer", id))
+			}
+			formatted[i] = id
+		}
+	}
+	return formatted, nil
+}
+
+func toBase62(id int64) string {
+	if id == 0 {
+		return base62Digits[:1]
+	}
+
+	var digits []byte
+	for ; id > 0; id /= 62 {
+		digits = append(digits, base62Digits[id%62])
+	}
+	slices.Reverse(digits)
+	return string(digits)
+}
+
+func fromBase62(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	id := int64(0)
+	for _, c := range []byte(s) {
+		digit := strings.IndexByte(base62Digits, c)
+		if digit < 0 || id > (math.MaxInt64-int64(digit))/62 {
+			return 0, false
+		}
+		id = id*62 + int64(digit)
+	}
+	return id, true
+}
@@ -0,0 +1,205 @@
+package uniqueids
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+ID schemes
+
+Each way of generating IDs is a Generator, picked by name at startup so the same binary can be run
+against Maelstrom with each of them and compared:
+  - uuid4: random UUIDs, the default
+  - uuid7: UUIDs that start with a millisecond timestamp, so they sort by creation time
+  - ulid: like uuid7, but encoded as 26 characters of Crockford base32 (see ulid.go)
+  - snowflake: 64-bit integers that sort by creation time (see snowflake.go)
+  - sequential: integers counting up in steps of the cluster size, each node starting at its own position
+  - counter: <node_id>-<counter>, the simplest deterministic baseline
+  - lease: integers from blocks leased from a shared lin-kv counter (see lease.go)
+  - coordinator: integers from blocks leased from a coordinator node (see coordinator.go)
+
+Apart from lease and coordinator, none of them need any communication between nodes.
+*/
+
+type Generator interface {
+	// Called once the node knows its ID and the cluster's, before any IDs are generated
+	Init(n *maelstrom.Node) error
+
+	// Returns count new IDs
+	Generate(count int) ([]any, error)
+
+	// Returns the components of an ID generated by this scheme (see decode.go)
+	Decode(id json.RawMessage) (DecodedID, error)
+}
+
+// Returns the generator for cfg's scheme, "" and "uuid" mean uuid4
+// Timestamp-based schemes handle the clock going backwards with guard, and schemes with persisted or
+// shared state keep namespace's apart from the others' (see namespace.go)
+func NewGenerator(cfg Config, namespace string, guard *ClockGuard) (Generator, error) {
+	switch cfg.Scheme {
+	case "", "uuid", "uuid4":
+		return &UUID4{}, nil
+	case "uuid7":
+		return &UUID7{}, nil
+	case "ulid":
+		return &ULID{guard: guard}, nil
+	case "snowflake":
+		return &Snowflake{guard: guard, clock: snowflakeClock}, nil
+	case "sequential":
+		return &Sequential{}, nil
+	case "counter":
+		return &Counter{namespace: namespace, stateDir: cfg.StateDir}, nil
+	case "lease":
+		return &Lease{namespace: namespace, stateDir: cfg.StateDir, blockSize: cfg.LeaseBlockSize}, nil
+	case "coordinator":
+		return &Coordinated{namespace: namespace, blockSize: cfg.LeaseBlockSize, lease: cfg.CoordinatorLease}, nil
+	default:
+		return nil, fmt.Errorf("unknown ID scheme %q", cfg.Scheme)
+	}
+}
+
+// Returns the name scheme is reported as, uuid4 for its aliases
+func canonicalScheme(scheme string) string {
+	if scheme == "" || scheme == "uuid" {
+		return "uuid4"
+	}
+	return scheme
+}
+
+type UUID4 struct{}
+
+func (g *UUID4) Init(n *maelstrom.Node) error { return nil }
+
+func (g *UUID4) Generate(count int) ([]any, error) {
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = uuid.New().String()
+	}
+	return ids, nil
+}
+
+type UUID7 struct{}
+
+func (g *UUID7) Init(n *maelstrom.Node) error { return nil }
+
+func (g *UUID7) Generate(count int) ([]any, error) {
+	ids := make([]any, count)
+	for i := range ids {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
+
+func (s *Snowflake) Init(n *maelstrom.Node) error {
+	return s.SetNode(nodeIndex(n))
+}
+
+func (s *Snowflake) Generate(count int) ([]any, error) {
+	snowflakes, err := s.NextN(count)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]any, count)
+	for i, id := range snowflakes {
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// Generates position, position + size, position + 2*size, ... where size is the number of nodes and
+// position is this node's index among them, so no two nodes ever generate the same number
+type Sequential struct {
+	mu   sync.Mutex
+	next int64
+	step int64
+}
+
+func (g *Sequential) Init(n *maelstrom.Node) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// The position among the sorted node IDs rather than nodeIndex, it has to be below the cluster size
+	g.next = int64(slices.Index(slices.Sorted(slices.Values(n.NodeIDs())), n.ID()))
+	g.step = int64(len(n.NodeIDs()))
+	return nil
+}
+
+func (g *Sequential) Generate(count int) ([]any, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = g.next
+		g.next += g.step
+	}
+	return ids, nil
+}
+
+// Generates <node_id>-1, <node_id>-2, ... node IDs are unique, so no two nodes can generate the same ID,
+// and each node's IDs are in the order it generated them
+// With STATE_DIR set the counter survives restarts (see state.go)
+type Counter struct {
+	mu        sync.Mutex
+	namespace string
+	stateDir  string
+	node      string
+	counter   int64 // last ID issued
+	state     *StateFile
+	reserved  int64 // highest ID that may be issued before the state has to be saved again
+}
+
+type counterState struct {
+	Reserved int64 `json:"reserved"`
+}
+
+func (g *Counter) Init(n *maelstrom.Node) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.node = n.ID()
+	if g.state = openState(n, g.stateDir, "counter", g.namespace); g.state == nil {
+		return nil
+	}
+
+	// Anything up to the saved mark may have been issued before a restart
+	var state counterState
+	if err := g.state.Load(&state); err != nil {
+		return err
+	}
+	g.counter, g.reserved = state.Reserved, state.Reserved
+	return nil
+}
+
+func (g *Counter) Generate(count int) ([]any, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Reserve the whole block at once, so a batch is contiguous
+	last := g.counter + int64(count)
+	if g.state != nil && last > g.reserved {
+		if err := g.state.Save(counterState{Reserved: last + stateMargin}); err != nil {
+			return nil, err
+		}
+		g.reserved = last + stateMargin
+	}
+
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = g.node + "-" + strconv.FormatInt(g.counter+int64(i)+1, 10)
+	}
+	g.counter = last
+	return ids, nil
+}
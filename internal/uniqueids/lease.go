@@ -0,0 +1,142 @@
+package uniqueids
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Leased ID blocks
+
+Integers handed out from a single counter in Maelstrom's lin-kv service. Going to lin-kv for every ID
+would make generating one as slow as a round trip, and impossible during a partition, so instead a
+node leases a whole block of LEASE_BLOCK_SIZE (default 1000) IDs at once by advancing the counter with
+a compare-and-swap, then hands them out locally until the block runs out.
+
+Blocks never overlap, so IDs are globally unique, and since blocks are leased in increasing order IDs
+are roughly ordered across nodes. A node cut off from lin-kv keeps generating IDs until its lease is
+used up, then fails requests until it can lease another block.
+
+A restarted node could just lease a new block, the rest of its old one is never handed out again. With
+STATE_DIR set it picks up where it was in its old block instead (see state.go).
+*/
+
+const (
+	leaseKey       = "id_lease"
+	leaseBlockSize = 1000
+	leaseTimeout   = time.Second
+)
+
+type Lease struct {
+	mu        sync.Mutex
+	namespace string
+	stateDir  string
+	kv        *maelstrom.KV
+	blockSize int64
+	start     int64 // first ID of the current lease
+	next      int64 // next ID of the current lease
+	end       int64 // first ID past the current lease
+	state     *StateFile
+	reserved  int64 // first ID that can't be issued before the state is saved again
+	leases    int64 // blocks leased so far
+}
+
+type leaseState struct {
+	Reserved int64 `json:"reserved"`
+	End      int64 `json:"end"`
+}
+
+func (g *Lease) Init(n *maelstrom.Node) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.kv = maelstrom.NewLinKV(n)
+
+	if g.state = openState(n, g.stateDir, "lease", g.namespace); g.state == nil {
+		return nil
+	}
+
+	// Carry on from the saved mark if it's still within the lease, IDs before it may have been issued
+	var state leaseState
+	if err := g.state.Load(&state); err != nil {
+		return err
+	}
+	if state.Reserved < state.End {
+		g.start, g.next, g.end, g.reserved = state.Reserved, state.Reserved, state.End, state.Reserved
+	}
+	return nil
+}
+
+func (g *Lease) Generate(count int) ([]any, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]any, count)
+	for i := range ids {
+		if g.next == g.end {
+			// Lease enough for the rest of the batch in one go if it's bigger than a block
+			if err := g.lease(max(g.blockSize, int64(count-i))); err != nil {
+				return nil, err
+			}
+		}
+		if g.state != nil && g.next >= g.reserved {
+			reserved := min(g.next+max(stateMargin, int64(count-i)), g.end)
+			if err := g.state.Save(leaseState{Reserved: reserved, End: g.end}); err != nil {
+				return nil, err
+			}
+			g.reserved = reserved
+		}
+		ids[i] = g.next
+		g.next++
+	}
+	return ids, nil
+}
+
+// Leases the next size IDs from lin-kv, must be called with g.mu held
+func (g *Lease) lease(size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+	defer cancel()
+
+	for {
+		start, err := g.kv.ReadInt(ctx, g.key())
+		if err != nil {
+			// If key doesn't exist no block has been leased yet
+			var rpcErr *maelstrom.RPCError
+			if errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.KeyDoesNotExist {
+				start = 0
+			} else {
+				return leaseUnavailable(err)
+			}
+		}
+
+		err = g.kv.CompareAndSwap(ctx, g.key(), start, int64(start)+size, true)
+		if err == nil {
+			g.start, g.next, g.end = int64(start), int64(start), int64(start)+size
+			g.leases++
+			return nil
+		}
+
+		// Another node leased a block in between, try again from its end
+		var rpcErr *maelstrom.RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.PreconditionFailed {
+			return leaseUnavailable(err)
+		}
+	}
+}
+
+// Returns the lin-kv key of the namespace's counter
+func (g *Lease) key() string {
+	if g.namespace == "" {
+		return leaseKey
+	}
+	return leaseKey + "/" + g.namespace
+}
+
+// Lin-kv can't be reached (or timed out), the client may retry once it's back
+func leaseUnavailable(err error) error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "can't lease IDs: "+err.Error())
+}
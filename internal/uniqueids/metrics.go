@@ -0,0 +1,88 @@
+package uniqueids
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Generation metrics
+
+The stats RPC reports how many IDs this node has generated, how many per second lately (averaged over
+the last rateWindow whole seconds), and for schemes that draw from a bounded space how much of it is in
+use: the current millisecond's sequence numbers for snowflake IDs, the current block for leased ones.
+A space that keeps running out (exhausted counts how often it did) is where throughput goes: snowflake
+nodes wait for the next millisecond and lease nodes go to lin-kv.
+*/
+
+const rateWindow = 10 // seconds
+
+type Utilization struct {
+	Used      int64 `json:"used"`
+	Capacity  int64 `json:"capacity"`
+	Exhausted int64 `json:"exhausted"`
+}
+
+// Implemented by generators that draw from a bounded space
+type Utilizer interface {
+	Utilization() Utilization
+}
+
+type Metrics struct {
+	mu        sync.Mutex
+	generated int64
+	buckets   [rateWindow + 1]rateBucket // IDs generated per second, the current second included
+}
+
+type rateBucket struct {
+	second int64
+	count  int64
+}
+
+// Records count newly generated IDs
+func (m *Metrics) Record(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	bucket := &m.buckets[now%int64(len(m.buckets))]
+	if bucket.second != now {
+		*bucket = rateBucket{second: now}
+	}
+	bucket.count += int64(count)
+	m.generated += int64(count)
+}
+
+// Returns the number of IDs generated so far and per second over the last rateWindow whole seconds
+func (m *Metrics) Rate() (generated int64, perSecond float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	var sum int64
+	for _, bucket := range m.buckets {
+		if bucket.second < now && bucket.second >= now-rateWindow {
+			sum += bucket.count
+		}
+	}
+	return m.generated, float64(sum) / rateWindow
+}
+
+func (s *Snowflake) Utilization() Utilization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only this millisecond's sequence numbers count, a new millisecond starts afresh
+	used := int64(0)
+	if s.lastMs >= s.clock() {
+		used = s.sequence + 1
+	}
+	return Utilization{Used: used, Capacity: 1 << snowflakeSequenceBits, Exhausted: s.exhausted}
+}
+
+func (g *Lease) Utilization() Utilization {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return Utilization{Used: g.next - g.start, Capacity: g.end - g.start, Exhausted: g.leases}
+}
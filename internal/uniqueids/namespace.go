@@ -0,0 +1,135 @@
+package uniqueids
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/election"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Namespaces
+
+A generate request (or decode_id) may name a namespace, such as orders or users, and each namespace gets
+its own generator of the node's scheme: its own counter, lease or sequence numbers. IDs are only unique
+within a namespace, the same ID can come up in two of them. Requests without a namespace use the
+default one, "".
+
+Namespaces are created the first time they're used. Their names end up in state file names and lin-kv
+keys, so they're limited to letters, digits, _ and -, and there can be at most maxNamespaces of them.
+*/
+
+const maxNamespaces = 1000
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type Namespaces struct {
+	mu         sync.Mutex
+	node       *maelstrom.Node
+	cfg        Config
+	guard      *ClockGuard
+	generators map[string]Generator
+	elector    election.Elector // the coordinator scheme's, see coordinator.go
+}
+
+// Returns the namespaces generating IDs with cfg's scheme, an error if there's no such scheme
+func NewNamespaces(cfg Config, guard *ClockGuard) (*Namespaces, error) {
+	generator, err := NewGenerator(cfg, "", guard)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Namespaces{
+		cfg:        cfg,
+		guard:      guard,
+		generators: map[string]Generator{"": generator},
+	}, nil
+}
+
+// Returns the name of the scheme IDs are generated with
+func (ns *Namespaces) Scheme() string {
+	return canonicalScheme(ns.cfg.Scheme)
+}
+
+// Initialises the default namespace's generator, the others are initialised as they're created
+func (ns *Namespaces) Init(n *maelstrom.Node) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.node = n
+	return ns.generators[""].Init(n)
+}
+
+// Returns the generator of namespace, creating it if it's new
+func (ns *Namespaces) Get(namespace string) (Generator, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if generator, ok := ns.generators[namespace]; ok {
+		return generator, nil
+	}
+
+	if !namespacePattern.MatchString(namespace) {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid namespace %q", namespace))
+	}
+	if len(ns.generators) > maxNamespaces {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("no more than %d namespaces", maxNamespaces))
+	}
+
+	generator, err := NewGenerator(ns.cfg, namespace, ns.guard)
+	if err != nil {
+		return nil, err
+	}
+	if err := generator.Init(ns.node); err != nil {
+		return nil, err
+	}
+	if g, ok := generator.(*Coordinated); ok {
+		g.elector = ns.elector
+	}
+
+	ns.generators[namespace] = generator
+	return generator, nil
+}
+
+// Has the coordinator scheme's generators follow elector's leader
+func (ns *Namespaces) setElector(elector election.Elector) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.elector = elector
+	for _, generator := range ns.generators {
+		if g, ok := generator.(*Coordinated); ok {
+			g.elector = elector
+		}
+	}
+}
+
+// Returns the coordinator scheme's generators
+func (ns *Namespaces) coordinated() []*Coordinated {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	var coordinated []*Coordinated
+	for _, generator := range ns.generators {
+		if g, ok := generator.(*Coordinated); ok {
+			coordinated = append(coordinated, g)
+		}
+	}
+	return coordinated
+}
+
+// Returns the utilization of every namespace whose generator draws from a bounded space
+func (ns *Namespaces) Utilization() map[string]Utilization {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	utilization := map[string]Utilization{}
+	for namespace, generator := range ns.generators {
+		if utilizer, ok := generator.(Utilizer); ok {
+			utilization[namespace] = utilizer.Utilization()
+		}
+	}
+	return utilization
+}
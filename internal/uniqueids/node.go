@@ -0,0 +1,274 @@
+package uniqueids
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Challenge #2: Unique ID Generation
+
+Goal: generate unique IDs across a distributed system that can withstand network partitions.
+
+UUIDs are a great solution for this because their size ensures uniqueness.
+Since we don't need a ranking or ordering of IDs in our system, generating random UUIDs works fine.
+
+We simply rely on their randomness to ensure uniqueness, so no communication between nodes is required.
+
+Other schemes can be picked with ID_SCHEME, for instance snowflake to generate 64-bit
+integers that sort by creation time instead (see generator.go for the full list).
+
+A generate request may ask for several IDs at once with a count field, they're returned in an ids array
+instead of a single id. Requests with fields the handler doesn't know are rejected as malformed. decode_id returns what a generated ID is made of (see decode.go), and
+verify reports duplicates when auditing is on (see audit.go). stats reports the generation rate (see metrics.go)
+and how often the clock went backwards (see clock.go).
+
+IDs can also be returned as strings or JSON-safe integers regardless of the scheme (see format.go), and
+drawn from separate namespaces (see namespace.go). A request with a payload gets the same ID every time,
+whichever node it's sent to (see content.go).
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// ID_SCHEME, uuid4 (default), uuid7, ulid, snowflake, sequential, counter, lease or coordinator
+	Scheme string `env:"ID_SCHEME"`
+
+	// ID_FORMAT, native (default), string, integer or base62
+	Format string `env:"ID_FORMAT"`
+
+	// LEASE_BLOCK_SIZE, how many IDs the lease and coordinator schemes lease at once (default 1000)
+	LeaseBlockSize int64 `env:"LEASE_BLOCK_SIZE" min:"1"`
+
+	// COORDINATOR_LEASE_MS, how long a block from the coordinator may be used (default 10000)
+	CoordinatorLease time.Duration `env:"COORDINATOR_LEASE_MS" min:"1"`
+
+	// CLOCK_SKEW_POLICY and CLOCK_SKEW_MAX_MS, what timestamp-based schemes do when the clock goes
+	// backwards (see clock.go)
+	ClockSkewPolicy string        `env:"CLOCK_SKEW_POLICY" oneof:"borrow|wait|fail"`
+	ClockSkewMax    time.Duration `env:"CLOCK_SKEW_MAX_MS" min:"0"`
+
+	// STATE_DIR, where the counter and lease schemes persist their state, nothing is persisted if empty
+	StateDir string `env:"STATE_DIR"`
+
+	// AUDIT_IDS, how many generated IDs are remembered to check for duplicates (default 0, none)
+	AuditIDs int `env:"AUDIT_IDS" min:"0"`
+}
+
+// The format has to be a known one
+func (c *Config) Validate() error {
+	return checkFormat(c.Format)
+}
+
+// Upper bound on count, so a single request can't make a reply arbitrarily large
+const maxCount = 10000
+
+type GenerateRequestBody struct {
+	Type      string  `json:"type"`
+	MsgID     int     `json:"msg_id"`
+	Count     *int    `json:"count,omitempty"`
+	Format    string  `json:"format,omitempty"`
+	Namespace *string `json:"namespace,omitempty"`
+
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type GenerateResponseBody struct {
+	Type      string `json:"type"`
+	InReplyTo int    `json:"in_reply_to"`
+	ID        any    `json:"id,omitempty"`
+	IDs       []any  `json:"ids,omitempty"`
+}
+
+type StatsResponseBody struct {
+	Type        string                 `json:"type"`
+	Scheme      string                 `json:"scheme"`
+	Generated   int64                  `json:"generated"`
+	PerSecond   float64                `json:"per_second"`
+	Utilization map[string]Utilization `json:"utilization,omitempty"`
+	ClockSkew   ClockSkewStats         `json:"clock_skew"`
+}
+
+// Registers the ID generation handlers on n
+func Register(n *maelstrom.Node) error {
+
+	cfg := Config{
+		LeaseBlockSize:   leaseBlockSize,
+		CoordinatorLease: coordinatorLease,
+		ClockSkewPolicy:  SkewBorrow,
+		ClockSkewMax:     defaultMaxSkew,
+	}
+	if err := config.Load("uniqueids", &cfg); err != nil {
+		return err
+	}
+	format := cfg.Format
+
+	guard := NewClockGuard(cfg.ClockSkewPolicy, cfg.ClockSkewMax)
+
+	namespaces, err := NewNamespaces(cfg, guard)
+	if err != nil {
+		return err
+	}
+
+	audit := NewAudit(cfg.AuditIDs)
+	rates := &Metrics{}
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		return namespaces.Init(n)
+	})
+
+	n.Handle("generate", func(msg maelstrom.Message) error {
+		var body GenerateRequestBody
+
+		if err := decodeBody(msg, &body); err != nil {
+			return err
+		}
+
+		// The request's format, if any, overrides the node's
+		idFormat := format
+		if body.Format != "" {
+			if err := checkFormat(body.Format); err != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+			}
+			idFormat = body.Format
+		}
+
+		if body.Count != nil && (*body.Count < 1 || *body.Count > maxCount) {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("count must be an integer from 1 to %d", maxCount))
+		}
+		if body.Namespace != nil && *body.Namespace == "" {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "namespace must be a non-empty string")
+		}
+
+		namespace := ""
+		if body.Namespace != nil {
+			namespace = *body.Namespace
+		}
+		generator, err := namespaces.Get(namespace)
+		if err != nil {
+			return err
+		}
+
+		var ids []any
+		if body.Payload != nil {
+			if body.Count != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, "count can't be combined with a payload")
+			}
+
+			id, err := contentID(namespace, body.Payload)
+			if err != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+			}
+			ids = []any{id}
+			rates.Record(1)
+		} else {
+			count := 1
+			if body.Count != nil {
+				count = *body.Count
+			}
+			if ids, err = generator.Generate(count); err != nil {
+				return err
+			}
+			rates.Record(len(ids))
+			if audit != nil {
+				audit.Record(namespace, ids)
+			}
+		}
+		if ids, err = formatIDs(ids, idFormat); err != nil {
+			return err
+		}
+
+		// Create the return message, a single id unless a count was asked for
+		response := GenerateResponseBody{
+			Type:      "generate_ok",
+			InReplyTo: body.MsgID,
+		}
+		if body.Count == nil {
+			response.ID = ids[0]
+		} else {
+			response.IDs = ids
+		}
+
+		// Not n.Reply: it round-trips the body through a map of float64s, which would change integer IDs above 2^53
+		return n.Send(msg.Src, response)
+	})
+
+	if err := HandleCoordination(n, namespaces); err != nil {
+		return err
+	}
+
+	n.Handle("decode_id", func(msg maelstrom.Message) error {
+		var body DecodeIDRequestBody
+
+		if err := decodeBody(msg, &body); err != nil {
+			return err
+		}
+
+		generator, err := namespaces.Get(body.Namespace)
+		if err != nil {
+			return err
+		}
+
+		id := body.ID
+		if body.Format == FormatBase62 {
+			if id, err = fromBase62ID(id); err != nil {
+				return err
+			}
+		}
+
+		decoded, err := generator.Decode(id)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, DecodeIDResponseBody{
+			Type:      "decode_id_ok",
+			DecodedID: decoded,
+		})
+	})
+
+	admin.Of(n).Register("uniqueids", admin.Module{Status: func() any {
+		generated, _ := rates.Rate()
+		return map[string]any{"scheme": namespaces.Scheme(), "generated": generated}
+	}})
+
+	// Answered by the standard stats RPC, see internal/metrics
+	metrics.Of(n).Report(func() any {
+		generated, perSecond := rates.Rate()
+
+		return StatsResponseBody{
+			Type:        "stats_ok",
+			Scheme:      namespaces.Scheme(),
+			Generated:   generated,
+			PerSecond:   perSecond,
+			Utilization: namespaces.Utilization(),
+			ClockSkew:   guard.Stats(),
+		}
+	})
+
+	n.Handle("verify", func(msg maelstrom.Message) error {
+		if audit == nil {
+			return maelstrom.NewRPCError(maelstrom.NotSupported, "auditing is off, set AUDIT_IDS")
+		}
+		return n.Reply(msg, audit.Response())
+	})
+
+	return nil
+}
+
+// Unmarshals a request body, rejecting any field body doesn't have
+func decodeBody(msg maelstrom.Message, body any) error {
+	decoder := json.NewDecoder(bytes.NewReader(msg.Body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(body); err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	return nil
+}
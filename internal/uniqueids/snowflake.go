@@ -0,0 +1,113 @@
+package uniqueids
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Snowflake IDs
+
+A 64-bit integer made of, from the most significant bit down:
+  - 41 bits: milliseconds since snowflakeEpoch, enough for about 69 years
+  - 10 bits: the node's index, so up to 1024 nodes never generate the same ID. A node with a higher
+    index fails its init rather than share another's IDs
+  - 12 bits: a sequence number within the millisecond, up to 4096 IDs per node per millisecond
+
+IDs therefore sort by the time they were generated (k-ordered: IDs from different nodes generated
+within the same millisecond or so may sort either way), and still need no communication between nodes.
+A node that uses up a millisecond's sequence numbers waits for the next millisecond, and one whose clock
+goes backwards is handled by its ClockGuard (see clock.go).
+*/
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+)
+
+// 2024-01-01T00:00:00Z, IDs count milliseconds from here rather than from 1970 to last longer
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type Snowflake struct {
+	mu        sync.Mutex
+	guard     *ClockGuard
+	clock     func() int64 // milliseconds since snowflakeEpoch
+	node      int64
+	lastMs    int64
+	sequence  int64
+	exhausted int64 // times the sequence ran out within a millisecond
+}
+
+// Sets the node index IDs are generated with, must be called before Next
+// Fails if it doesn't fit in snowflakeNodeBits, cut down to fit it would share another node's IDs
+func (s *Snowflake) SetNode(node int64) error {
+	if node < 0 || node >= 1<<snowflakeNodeBits {
+		return fmt.Errorf("snowflake: node index %d doesn't fit in %d bits", node, snowflakeNodeBits)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.node = node
+	return nil
+}
+
+// Returns the next count IDs, in order
+// The block is reserved under a single lock, so it costs little more than one ID per millisecond it spans
+func (s *Snowflake) NextN(count int) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, count)
+	for i := range ids {
+		id, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// Must be called with s.mu held
+func (s *Snowflake) next() (int64, error) {
+	// Never go back to an earlier millisecond, even if the clock does, so IDs can't repeat
+	ms, err := s.guard.Check(s.lastMs, s.clock)
+	if err != nil {
+		return 0, err
+	}
+
+	if ms == s.lastMs {
+		s.sequence++
+		if s.sequence == 1<<snowflakeSequenceBits {
+			// Sequence exhausted, wait for the next millisecond
+			s.exhausted++
+			ms = waitPast(s.lastMs, s.clock)
+			s.sequence = 0
+		}
+	} else {
+		s.sequence = 0
+	}
+
+	s.lastMs = ms
+	return ms<<(snowflakeNodeBits+snowflakeSequenceBits) | s.node<<snowflakeSequenceBits | s.sequence, nil
+}
+
+// Milliseconds since snowflakeEpoch
+func snowflakeClock() int64 {
+	return time.Since(snowflakeEpoch).Milliseconds()
+}
+
+// Returns the index of this node: the number in Maelstrom's n<index> node IDs, or otherwise the node's
+// position among the sorted node IDs
+func nodeIndex(n *maelstrom.Node) int64 {
+	if index, err := strconv.ParseInt(strings.TrimPrefix(n.ID(), "n"), 10, 64); err == nil {
+		return index
+	}
+	return int64(slices.Index(slices.Sorted(slices.Values(n.NodeIDs())), n.ID()))
+}
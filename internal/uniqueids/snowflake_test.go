@@ -0,0 +1,65 @@
+package uniqueids
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSnowflake(node int64, clock func() int64) *Snowflake {
+	s := &Snowflake{guard: NewClockGuard(SkewBorrow, time.Second), clock: clock}
+	s.SetNode(node)
+	return s
+}
+
+func TestSnowflakeNodeIndexMustFit(t *testing.T) {
+	s := &Snowflake{}
+	if err := s.SetNode(1023); err != nil {
+		t.Errorf("node 1023: %v", err)
+	}
+	for _, node := range []int64{1024, 2047, -1} {
+		if err := s.SetNode(node); err == nil {
+			t.Errorf("node %d was accepted, its IDs would collide with another node's", node)
+		}
+	}
+}
+
+func TestSnowflakeOrderedAndUnique(t *testing.T) {
+	now := int64(100)
+	clock := func() int64 { return now }
+	nodes := []*Snowflake{newTestSnowflake(1, clock), newTestSnowflake(2, clock)}
+
+	seen := map[int64]bool{}
+	last := make([]int64, len(nodes))
+	for ms := range 5 {
+		now = 100 + int64(ms)
+		for i, s := range nodes {
+			ids, err := s.NextN(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range ids {
+				if seen[id] {
+					t.Fatalf("duplicate id %d", id)
+				}
+				seen[id] = true
+
+				if id <= last[i] {
+					t.Fatalf("node %d generated %d after %d", i, id, last[i])
+				}
+				last[i] = id
+
+				if got := id >> (snowflakeNodeBits + snowflakeSequenceBits); got != now {
+					t.Errorf("id %d has timestamp %d, want %d", id, got, now)
+				}
+			}
+		}
+	}
+
+	// IDs from a later millisecond sort after every node's earlier ones
+	now = 200
+	ids, _ := nodes[0].NextN(1)
+	if ids[0] <= last[1] {
+		t.Errorf("id %d from a later millisecond sorts before %d", ids[0], last[1])
+	}
+}
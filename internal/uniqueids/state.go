@@ -0,0 +1,75 @@
+package uniqueids
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Persisted state
+
+The counter and lease schemes hand out IDs from a number kept in memory, so a node that crashed and
+restarted would start handing out the same IDs again. With STATE_DIR set they keep that number in
+<dir>/<node>.<scheme>.state instead (<dir>/<node>.<scheme>.<namespace>.state outside the default namespace).
+
+Writing the file for every ID would make each one cost a disk sync, so what's written is a mark up to
+stateMargin IDs ahead of the last one issued, before any ID past the previous mark is returned. After a
+restart the node carries on from the mark, skipping at most stateMargin IDs but never reissuing one.
+*/
+
+// How far ahead of the last issued ID the persisted mark is set
+const stateMargin = 1000
+
+type StateFile struct {
+	path string
+}
+
+// Returns the state file in dir for this node, scheme and namespace, nil if dir is empty (STATE_DIR isn't set)
+func openState(n *maelstrom.Node, dir string, scheme string, namespace string) *StateFile {
+	if dir == "" {
+		return nil
+	}
+
+	name := n.ID() + "." + scheme
+	if namespace != "" {
+		name += "." + namespace
+	}
+	return &StateFile{path: filepath.Join(dir, name+".state")}
+}
+
+// Reads the state into v, leaving it untouched if nothing has been saved yet
+func (f *StateFile) Load(v any) error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Saves v, replacing the old state only once the new one is synced to disk
+func (f *StateFile) Save(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(f.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(f.path+".tmp", f.path)
+}
@@ -0,0 +1,132 @@
+package uniqueids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+ULIDs
+
+128 bits, from the most significant down: 48 bits of milliseconds since the Unix epoch and 80 random
+bits, written as 26 characters of Crockford's base32 so that they sort as strings in the same order.
+
+Within one millisecond the random part is incremented instead of drawn again, so a node's IDs are
+strictly increasing (the "monotonic" variant of the spec). If it would overflow, the generator waits
+for the next millisecond. A clock going backwards is handled by the ClockGuard (see clock.go).
+*/
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ULID struct {
+	mu     sync.Mutex
+	guard  *ClockGuard
+	lastMs int64
+	hi     uint16 // top 16 of the 80 random bits
+	lo     uint64 // bottom 64
+}
+
+func (g *ULID) Init(n *maelstrom.Node) error { return nil }
+
+func (g *ULID) Generate(count int) ([]any, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]any, count)
+	for i := range ids {
+		if err := g.advance(); err != nil {
+			return nil, err
+		}
+		ids[i] = g.encode()
+	}
+	return ids, nil
+}
+
+// Moves on to the next ID, must be called with g.mu held
+func (g *ULID) advance() error {
+	// Like snowflake IDs, never go back to an earlier millisecond
+	ms, err := g.guard.Check(g.lastMs, unixClock)
+	if err != nil {
+		return err
+	}
+
+	if ms == g.lastMs {
+		g.lo++
+		if g.lo == 0 {
+			g.hi++
+		}
+		if g.lo != 0 || g.hi != 0 {
+			return nil
+		}
+
+		// Random part overflowed, wait for the next millisecond
+		ms = waitPast(g.lastMs, unixClock)
+	}
+
+	var random [10]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return err
+	}
+	g.lastMs = ms
+	g.hi = binary.BigEndian.Uint16(random[:2])
+	g.lo = binary.BigEndian.Uint64(random[2:])
+	return nil
+}
+
+func unixClock() int64 {
+	return time.Now().UnixMilli()
+}
+
+// Returns the current ID in base32, 5 bits per character from the most significant down
+// (26 characters hold 130 bits, so the first one only ever uses 3)
+func (g *ULID) encode() string {
+	var id [16]byte
+	binary.BigEndian.PutUint16(id[:2], uint16(g.lastMs>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(g.lastMs))
+	binary.BigEndian.PutUint16(id[6:8], g.hi)
+	binary.BigEndian.PutUint64(id[8:], g.lo)
+
+	var out [26]byte
+	for i := range out {
+		// Bit offset of this character counted from the least significant end
+		shift := 5 * (len(out) - 1 - i)
+		var value byte
+		for b := range 5 {
+			bit := shift + b
+			if bit < 128 && id[15-bit/8]>>(bit%8)&1 == 1 {
+				value |= 1 << b
+			}
+		}
+		out[i] = crockford[value]
+	}
+	return string(out[:])
+}
+
+// Parses a ULID back into its 128 bits, the reverse of encode
+func parseULID(s string) ([16]byte, bool) {
+	var id [16]byte
+	if len(s) != 26 {
+		return id, false
+	}
+
+	for i, c := range strings.ToUpper(s) {
+		value := strings.IndexRune(crockford, c)
+		if value < 0 || i == 0 && value > 7 {
+			return id, false
+		}
+
+		shift := 5 * (len(s) - 1 - i)
+		for b := range 5 {
+			bit := shift + b
+			if bit < 128 && value>>b&1 == 1 {
+				id[15-bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return id, true
+}
@@ -0,0 +1,128 @@
+package vclock
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+)
+
+/*
+Vector clocks
+
+A vector clock counts, for every replica, how many of its events happened before some point. One clock
+happened before another if it's no greater in every entry and smaller in at least one, and two clocks
+where neither happened before the other are concurrent.
+
+Missing entries count as 0, so a clock only lists the replicas it has seen events from. The JSON form is
+an object of replica to count with zero entries left out, which keeps messages carrying clocks small and
+means equal clocks always encode the same.
+*/
+
+type VClock map[string]int64
+
+// Order of two clocks, as returned by Compare
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Concurrent:
+		return "concurrent"
+	}
+	return fmt.Sprintf("Ordering(%d)", int(o))
+}
+
+func New() VClock {
+	return VClock{}
+}
+
+// Counts a new event on replica and returns its count
+func (v VClock) Increment(replica string) int64 {
+	v[replica]++
+	return v[replica]
+}
+
+// Returns the count of replica, 0 if it has none
+func (v VClock) Get(replica string) int64 {
+	return v[replica]
+}
+
+// Raises every entry to at least other's
+func (v VClock) Merge(other VClock) {
+	for replica, count := range other {
+		v[replica] = max(v[replica], count)
+	}
+}
+
+// Returns a copy that can be changed independently, never nil
+func (v VClock) Clone() VClock {
+	if v == nil {
+		return VClock{}
+	}
+	return maps.Clone(v)
+}
+
+// Returns true if every event in other is also counted in v, i.e. other happened before v or equals it
+func (v VClock) Descends(other VClock) bool {
+	for replica, count := range other {
+		if v[replica] < count {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns how a relates to b: Before if a happened before b, After if b happened before a
+func Compare(a, b VClock) Ordering {
+	switch aDescends, bDescends := a.Descends(b), b.Descends(a); {
+	case aDescends && bDescends:
+		return Equal
+	case bDescends:
+		return Before
+	case aDescends:
+		return After
+	default:
+		return Concurrent
+	}
+}
+
+func (v VClock) MarshalJSON() ([]byte, error) {
+	compact := make(map[string]int64, len(v))
+	for replica, count := range v {
+		if count != 0 {
+			compact[replica] = count
+		}
+	}
+	return json.Marshal(compact)
+}
+
+func (v *VClock) UnmarshalJSON(data []byte) error {
+	var counts map[string]int64
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+
+	for replica, count := range counts {
+		if count < 0 {
+			return fmt.Errorf("vclock: negative count %d for %s", count, replica)
+		}
+		if count == 0 {
+			delete(counts, replica)
+		}
+	}
+
+	*v = counts
+	return nil
+}
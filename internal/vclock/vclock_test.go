@@ -0,0 +1,134 @@
+package vclock
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"testing/quick"
+)
+
+// Builds a clock over a few replicas from arbitrary counts, so random clocks are often ordered
+func clockOf(counts map[uint8]uint8) VClock {
+	v := New()
+	for replica, count := range counts {
+		r := "n" + strconv.Itoa(int(replica%4))
+		v[r] = max(v[r], int64(count%8))
+	}
+	return v
+}
+
+func merged(clocks ...VClock) VClock {
+	v := New()
+	for _, clock := range clocks {
+		v.Merge(clock)
+	}
+	return v
+}
+
+func check(t *testing.T, name string, f any) {
+	t.Helper()
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Errorf("%s: %v", name, err)
+	}
+}
+
+func TestCompareIsAPartialOrder(t *testing.T) {
+	check(t, "reflexive", func(a map[uint8]uint8) bool {
+		return Compare(clockOf(a), clockOf(a)) == Equal
+	})
+
+	check(t, "antisymmetric", func(a, b map[uint8]uint8) bool {
+		ab, ba := Compare(clockOf(a), clockOf(b)), Compare(clockOf(b), clockOf(a))
+		switch ab {
+		case Before:
+			return ba == After
+		case After:
+			return ba == Before
+		default:
+			return ba == ab
+		}
+	})
+
+	check(t, "transitive", func(a, b, c map[uint8]uint8, x, y uint8) bool {
+		// Build a chain first <= second <= third, then bump the later ones so it's strict
+		first := clockOf(a)
+		second := merged(first, clockOf(b))
+		second.Increment("n" + strconv.Itoa(int(x%4)))
+		third := merged(second, clockOf(c))
+		third.Increment("n" + strconv.Itoa(int(y%4)))
+
+		return Compare(first, second) == Before && Compare(second, third) == Before && Compare(first, third) == Before
+	})
+
+	check(t, "zero entries are missing entries", func(a map[uint8]uint8) bool {
+		padded := clockOf(a)
+		padded["unseen"] = 0
+		return Compare(padded, clockOf(a)) == Equal
+	})
+}
+
+func TestMergeIsTheLeastUpperBound(t *testing.T) {
+	check(t, "upper bound", func(a, b map[uint8]uint8) bool {
+		m := merged(clockOf(a), clockOf(b))
+		return m.Descends(clockOf(a)) && m.Descends(clockOf(b))
+	})
+
+	check(t, "least", func(a, b, c map[uint8]uint8) bool {
+		upper := merged(clockOf(a), clockOf(b), clockOf(c))
+		return upper.Descends(merged(clockOf(a), clockOf(b)))
+	})
+
+	check(t, "commutative", func(a, b map[uint8]uint8) bool {
+		return Compare(merged(clockOf(a), clockOf(b)), merged(clockOf(b), clockOf(a))) == Equal
+	})
+
+	check(t, "associative", func(a, b, c map[uint8]uint8) bool {
+		left := merged(merged(clockOf(a), clockOf(b)), clockOf(c))
+		right := merged(clockOf(a), merged(clockOf(b), clockOf(c)))
+		return Compare(left, right) == Equal
+	})
+
+	check(t, "idempotent", func(a map[uint8]uint8) bool {
+		return Compare(merged(clockOf(a), clockOf(a)), clockOf(a)) == Equal
+	})
+}
+
+func TestIncrementHappensAfter(t *testing.T) {
+	check(t, "increment", func(a map[uint8]uint8, replica uint8) bool {
+		before := clockOf(a)
+		after := before.Clone()
+		after.Increment("n" + strconv.Itoa(int(replica%4)))
+		return Compare(before, after) == Before && Compare(after, before) == After
+	})
+
+	v := VClock{"n1": 3}
+	w := VClock{"n2": 1}
+	if got := Compare(v, w); got != Concurrent {
+		t.Errorf("Compare(%v, %v) = %s, want concurrent", v, w, got)
+	}
+}
+
+func TestJSONIsCompact(t *testing.T) {
+	data, err := json.Marshal(VClock{"n1": 3, "n2": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"n1":3}` {
+		t.Errorf("marshalled = %s, want zero entries left out", data)
+	}
+
+	check(t, "round trip", func(a map[uint8]uint8) bool {
+		data, err := json.Marshal(clockOf(a))
+		if err != nil {
+			return false
+		}
+
+		var v VClock
+		return json.Unmarshal(data, &v) == nil && Compare(v, clockOf(a)) == Equal
+	})
+
+	var v VClock
+	if err := json.Unmarshal([]byte(`{"n1":-1}`), &v); err == nil {
+		t.Errorf("unmarshalled a negative count")
+	}
+}
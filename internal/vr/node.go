@@ -0,0 +1,168 @@
+package vr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Viewstamped Replication
+
+Goal: the same linearizable key-value store as the Raft and Paxos workloads, serving lin-kv with the same
+state machine (see internal/raft/kv.go), replicated with Viewstamped Replication (see replica.go).
+
+Only the primary runs operations, other replicas forward them to the primary of the view they're in.
+
+A backup starts a view change after VR_VIEW_CHANGE_TIMEOUT_MS to 2*VR_VIEW_CHANGE_TIMEOUT_MS (default 500)
+without hearing from the primary, and an idle primary sends its commit number every VR_COMMIT_MS
+(default 100).
+*/
+
+// Settings read from the environment at startup, see internal/config
+type Config struct {
+	// VR_VIEW_CHANGE_TIMEOUT_MS, the minimum time a backup waits for the primary, and a view change for the
+	// new primary, each timeout is drawn from [timeout, 2*timeout) (default 500)
+	ViewChangeTimeout time.Duration `env:"VR_VIEW_CHANGE_TIMEOUT_MS" min:"1"`
+
+	// VR_COMMIT_MS, how often an idle primary sends its commit number (default 100)
+	CommitInterval time.Duration `env:"VR_COMMIT_MS" min:"1"`
+
+	RPCTimeout time.Duration
+}
+
+// VR status RPC, for watching view changes and how far the log is committed
+type VRStatusRequestBody struct {
+	Type string `json:"type"`
+}
+
+type VRStatusResponseBody struct {
+	Type     string `json:"type"`
+	View     int64  `json:"view"`
+	Status   string `json:"status"`
+	Primary  string `json:"primary"`
+	OpNumber int64  `json:"op_number"`
+	Commit   int64  `json:"commit"`
+	Applied  int64  `json:"applied"`
+	Keys     int    `json:"keys"`
+}
+
+// Returns the VR settings, the defaults overridden by the environment
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		ViewChangeTimeout: 500 * time.Millisecond,
+		CommitInterval:    100 * time.Millisecond,
+		RPCTimeout:        time.Second,
+	}
+	err := config.Load("vr", &cfg)
+	return cfg, err
+}
+
+// Registers the VR and KV handlers on n, view changes can start once n is initialized
+func Register(n *maelstrom.Node) error {
+	ctx := context.Background()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	kv := raft.NewKVStore()
+	replica := NewReplica(n, kv, cfg)
+
+	// The primary of each view depends on the cluster's node IDs, so the timers only start once they're known
+	n.Handle("init", func(msg maelstrom.Message) error {
+		lifecycle.Of(n).Go(replica.Run)
+		return nil
+	})
+
+	status := func() VRStatusResponseBody {
+		view, status, primary := replica.State()
+		op, commit, applied := replica.Indexes()
+
+		return VRStatusResponseBody{
+			View:     view,
+			Status:   status.String(),
+			Primary:  primary,
+			OpNumber: op,
+			Commit:   commit,
+			Applied:  applied,
+			Keys:     kv.Len(),
+		}
+	}
+
+	handler.Handle(n, "vr_status", func(msg maelstrom.Message, body VRStatusRequestBody) (VRStatusResponseBody, error) {
+		return status(), nil
+	})
+
+	// Pausing stops the view change and commit timers, so pausing the primary makes the others change view
+	admin.Of(n).Register("vr", admin.Module{Status: func() any { return status() }})
+
+	handler.Handle(n, "read", func(msg maelstrom.Message, body raft.ReadRequestBody) (raft.ReadResponseBody, error) {
+		value, err := execute(ctx, n, replica, msg, raft.KVCommand{Op: "read", Key: body.Key})
+		if err != nil {
+			return raft.ReadResponseBody{}, err
+		}
+
+		return raft.ReadResponseBody{
+			Value: value,
+		}, nil
+	})
+
+	handler.Handle(n, "write", func(msg maelstrom.Message, body raft.WriteRequestBody) (raft.WriteResponseBody, error) {
+		_, err := execute(ctx, n, replica, msg, raft.KVCommand{Op: "write", Key: body.Key, Value: body.Value})
+		return raft.WriteResponseBody{}, err
+	})
+
+	handler.Handle(n, "cas", func(msg maelstrom.Message, body raft.CASRequestBody) (raft.CASResponseBody, error) {
+		_, err := execute(ctx, n, replica, msg, raft.KVCommand{Op: "cas", Key: body.Key, Value: body.To, From: body.From, CreateIfNotExists: body.CreateIfNotExists})
+		return raft.CASResponseBody{}, err
+	})
+
+	return nil
+}
+
+// Runs cmd through the VR log and returns the value it produced
+// On a replica that isn't the primary the request is forwarded to the primary instead, once: a forwarded
+// request that finds the view has changed again fails as temporarily unavailable, and the client retries
+func execute(ctx context.Context, n *maelstrom.Node, replica *Replica, msg maelstrom.Message, cmd raft.KVCommand) (json.RawMessage, error) {
+	ctx, cancel := replica.env.Clock.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	result, err := replica.Submit(ctx, cmd)
+	if err == nil {
+		kvResult := result.(raft.KVResult)
+		return kvResult.Value, kvResult.Err
+	}
+	if !errors.Is(err, ErrNotPrimary) {
+		return nil, err
+	}
+
+	// Raw values, so the primary sees the client's keys and values exactly
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Body, &request); err != nil {
+		return nil, err
+	}
+
+	_, status, primary := replica.State()
+	if status != Normal || primary == n.ID() || string(request["forwarded"]) == "true" {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("not the primary, try %q", primary))
+	}
+
+	request["forwarded"] = json.RawMessage("true")
+
+	var body raft.ReadResponseBody
+	if err := handler.Call(ctx, n, primary, request, &body); err != nil {
+		return nil, err
+	}
+	return body.Value, nil
+}
@@ -0,0 +1,516 @@
+package vr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/env"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Viewstamped Replication
+
+Replicas are numbered by sorting their node IDs, and views by integers: the primary of view v is replica
+v mod n, so unlike Raft there's no election, only agreeing to move to the next view. A cluster of
+n = 2f+1 replicas keeps going with f of them down.
+
+Normal operation: the primary appends each client's command to its log as the next op number and sends it
+to the backups with vr_prepare. A backup appends the ops in order and answers with the last op number it
+has; once f backups have an op, it's committed, and the primary applies it and answers the client. Each
+vr_prepare carries the commit number, so backups apply ops once they learn they're committed. An idle
+primary still sends vr_prepare with no ops every VR_COMMIT_MS (the paper's commit message), which also
+tells backups it's alive.
+
+State transfer: a replica missing ops, because the ops it's sent start past the end of its log or it has
+learned of a view it hasn't taken part in, asks a replica in that view for them with vr_get_state. On
+learning of a newer view it first drops the ops after its commit number, which the new view may have
+replaced.
+
+View changes (see viewchange.go) replace a primary that backups stop hearing from.
+
+Logs live in memory only and nothing is written to disk: the paper's recovery protocol, which lets a
+restarted replica rejoin safely, isn't implemented, so a replica that restarts may vote in a view change
+with an empty log. Clients aren't deduplicated either, a retried command may be applied twice.
+
+The node's metrics count view changes started and state transfers (vr.view_changes, vr.state_transfers)
+and report the view and commit number as gauges (vr.view, vr.commit_number).
+*/
+
+var logger = logging.For("vr")
+
+const (
+	prepareBatchSize = 64
+	vrTickInterval   = 10 * time.Millisecond
+)
+
+var ErrNotPrimary = errors.New("vr: not the primary")
+
+type Status int
+
+const (
+	Normal Status = iota
+	ViewChange
+)
+
+func (s Status) String() string {
+	return [...]string{"normal", "view-change"}[s]
+}
+
+// Applies committed commands, in op number order, on every replica
+type StateMachine interface {
+	Apply(command json.RawMessage) any
+}
+
+type Entry struct {
+	View    int64           `json:"view"` // the view the op was added to the log in
+	Command json.RawMessage `json:"command"`
+}
+
+// Prepare RPC, the primary sending ops to a backup, or only its commit number
+type PrepareRequestBody struct {
+	Type   string  `json:"type"`
+	View   int64   `json:"view"`
+	First  int64   `json:"first"` // the op number of the first of the ops
+	Ops    []Entry `json:"ops"`
+	Commit int64   `json:"commit"`
+}
+
+// Answers vr_prepare and vr_start_view
+type PrepareResponseBody struct {
+	Type string `json:"type"`
+	View int64  `json:"view"`
+	OK   bool   `json:"ok"`
+	Op   int64  `json:"op"` // the last op number the replica has
+}
+
+// Get state RPC, a replica asking for the ops after the ones it has
+type GetStateRequestBody struct {
+	Type string `json:"type"`
+	View int64  `json:"view"`
+	Op   int64  `json:"op"`
+}
+
+type GetStateResponseBody struct {
+	Type   string  `json:"type"`
+	View   int64   `json:"view"`
+	Ops    []Entry `json:"ops"`
+	Commit int64   `json:"commit"`
+}
+
+// Result of applying an op, handed to the Submit call waiting on it
+type applied struct {
+	view   int64
+	result any
+}
+
+type waiter struct {
+	view int64
+	done chan applied
+}
+
+type Replica struct {
+	node *maelstrom.Node
+	env  *env.Env
+	sm   StateMachine
+	cfg  Config
+
+	mu         sync.Mutex
+	view       int64
+	status     Status
+	lastNormal int64   // the last view the replica's status was normal in
+	log        []Entry // log[0] is a sentinel, so op numbers start at 1
+	commit     int64
+	applied    int64
+
+	deadline  time.Time // when a backup, or a view change that hasn't finished, starts the next view change
+	heartbeat time.Time // when the primary sends the next vr_prepare
+	acked     map[string]int64
+	inFlight  map[string]bool
+	fetching  bool // whether a vr_get_state is outstanding
+
+	startViewChanges map[string]bool                    // replicas that have sent start_view_change for the view
+	doViewChanges    map[string]DoViewChangeRequestBody // the new primary's do_view_change messages for the view
+	sentDoViewChange bool
+
+	waiters map[int64]waiter // Submit calls waiting for the op at each number
+
+	viewChanges, stateTransfers *metrics.Counter
+}
+
+func NewReplica(node *maelstrom.Node, sm StateMachine, cfg Config) *Replica {
+	r := &Replica{
+		node:     node,
+		env:      env.Of(node),
+		sm:       sm,
+		cfg:      cfg,
+		log:      []Entry{{}},
+		acked:    make(map[string]int64),
+		inFlight: make(map[string]bool),
+		waiters:  make(map[int64]waiter),
+	}
+	r.resetTimer()
+
+	registry := metrics.Of(node)
+	r.viewChanges = registry.Counter("vr.view_changes")
+	r.stateTransfers = registry.Counter("vr.state_transfers")
+	registry.GaugeFunc("vr.view", func() float64 {
+		view, _, _ := r.State()
+		return float64(view)
+	})
+	registry.GaugeFunc("vr.commit_number", func() float64 {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return float64(r.commit)
+	})
+
+	handler.Handle(node, "vr_prepare", func(msg maelstrom.Message, body PrepareRequestBody) (PrepareResponseBody, error) {
+		return r.handlePrepare(msg.Src, body), nil
+	})
+
+	handler.Handle(node, "vr_get_state", func(msg maelstrom.Message, body GetStateRequestBody) (GetStateResponseBody, error) {
+		return r.handleGetState(body)
+	})
+
+	handler.Handle(node, "vr_start_view_change", func(msg maelstrom.Message, body StartViewChangeRequestBody) (StartViewChangeResponseBody, error) {
+		r.handleStartViewChange(msg.Src, body)
+		return StartViewChangeResponseBody{}, nil
+	})
+
+	handler.Handle(node, "vr_do_view_change", func(msg maelstrom.Message, body DoViewChangeRequestBody) (DoViewChangeResponseBody, error) {
+		r.handleDoViewChange(msg.Src, body)
+		return DoViewChangeResponseBody{}, nil
+	})
+
+	handler.Handle(node, "vr_start_view", func(msg maelstrom.Message, body StartViewRequestBody) (PrepareResponseBody, error) {
+		return r.handleStartView(msg.Src, body), nil
+	})
+
+	return r
+}
+
+// Runs view changes and commit messages until ctx is cancelled, must be called once the node is initialized
+func (r *Replica) Run(ctx context.Context) {
+	ticker := r.env.Clock.NewTicker(vrTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !lifecycle.Paused(ctx) {
+				r.tick()
+			}
+		}
+	}
+}
+
+func (r *Replica) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.env.Clock.Now()
+	switch {
+	case r.status == Normal && r.isPrimary():
+		if now.After(r.heartbeat) {
+			r.broadcastPrepare()
+		}
+	case now.After(r.deadline):
+		r.startViewChange(r.view + 1)
+	}
+}
+
+// Appends command to the log and blocks until it's committed and applied, returning the state machine's result
+// Returns ErrNotPrimary if this replica isn't the primary of a view in normal status, or a view change
+// replaces the op before it commits
+func (r *Replica) Submit(ctx context.Context, command any) (any, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.status != Normal || !r.isPrimary() {
+		r.mu.Unlock()
+		return nil, ErrNotPrimary
+	}
+
+	view := r.view
+	r.log = append(r.log, Entry{View: view, Command: data})
+	op := r.opNumber()
+	done := make(chan applied, 1)
+	r.waiters[op] = waiter{view: view, done: done}
+
+	// A single replica commits on its own
+	r.advanceCommit()
+	r.broadcastPrepare()
+	r.mu.Unlock()
+
+	select {
+	case result := <-done:
+		if result.view != view {
+			return nil, ErrNotPrimary
+		}
+		return result.result, nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.waiters, op)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the view, the replica's status and the view's primary
+func (r *Replica) State() (int64, Status, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.view, r.status, r.primary(r.view)
+}
+
+// Returns the last op number, the commit number and the last op applied
+func (r *Replica) Indexes() (int64, int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.opNumber(), r.commit, r.applied
+}
+
+// Asks from for the ops after the ones this replica has, if it isn't already asking someone
+func (r *Replica) getState(from string) {
+	r.mu.Lock()
+	if r.fetching || from == r.node.ID() {
+		r.mu.Unlock()
+		return
+	}
+	r.fetching = true
+	req := GetStateRequestBody{Type: "vr_get_state", View: r.view, Op: r.opNumber()}
+	r.mu.Unlock()
+	r.stateTransfers.Inc()
+
+	ctx, cancel := r.env.Clock.WithTimeout(context.Background(), r.cfg.RPCTimeout)
+	defer cancel()
+
+	var resp GetStateResponseBody
+	err := handler.Call(ctx, r.node, from, req, &resp)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetching = false
+
+	// The log may have moved on while the state was on its way
+	if err != nil || resp.View != r.view || r.status != Normal || r.opNumber() != req.Op {
+		logger.Debug("state transfer dropped", "from", from, "view", req.View, "error", err)
+		return
+	}
+	r.log = append(r.log, resp.Ops...)
+	r.commitTo(min(resp.Commit, r.opNumber()))
+}
+
+func (r *Replica) handleGetState(body GetStateRequestBody) (GetStateResponseBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.View != r.view || r.status != Normal {
+		return GetStateResponseBody{}, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "not in normal status in that view")
+	}
+	ops := []Entry{}
+	if body.Op < r.opNumber() {
+		ops = slices.Clone(r.log[body.Op+1:])
+	}
+	return GetStateResponseBody{View: r.view, Ops: ops, Commit: r.commit}, nil
+}
+
+func (r *Replica) handlePrepare(src string, body PrepareRequestBody) PrepareResponseBody {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.View < r.view {
+		return PrepareResponseBody{View: r.view, Op: r.opNumber()}
+	}
+	// A newer view, or this one's view change finished without this replica hearing of it
+	if body.View > r.view || r.status != Normal {
+		r.enterView(body.View)
+		go r.getState(src)
+		return PrepareResponseBody{View: r.view, Op: r.opNumber()}
+	}
+	r.resetTimer()
+
+	// Ops missing before these, the replica fetches them and the primary resends from where it ends
+	if body.First > r.opNumber()+1 {
+		go r.getState(src)
+		return PrepareResponseBody{View: r.view, Op: r.opNumber()}
+	}
+
+	// Within a view every replica's log is a prefix of the primary's, so ops already here are kept
+	for i, entry := range body.Ops {
+		if body.First+int64(i) > r.opNumber() {
+			r.log = append(r.log, entry)
+		}
+	}
+	r.commitTo(min(body.Commit, r.opNumber()))
+
+	return PrepareResponseBody{View: r.view, OK: true, Op: r.opNumber()}
+}
+
+// The following must be called with r.mu held
+
+func (r *Replica) opNumber() int64 {
+	return int64(len(r.log) - 1)
+}
+
+// Returns the replicas in the order that picks each view's primary
+func (r *Replica) replicas() []string {
+	return slices.Sorted(slices.Values(r.node.NodeIDs()))
+}
+
+func (r *Replica) peers() []string {
+	return slices.DeleteFunc(r.replicas(), func(id string) bool {
+		return id == r.node.ID()
+	})
+}
+
+func (r *Replica) primary(view int64) string {
+	replicas := r.replicas()
+	if len(replicas) == 0 {
+		return ""
+	}
+	return replicas[view%int64(len(replicas))]
+}
+
+func (r *Replica) isPrimary() bool {
+	return r.primary(r.view) == r.node.ID()
+}
+
+// Returns f, the number of replicas that can fail, every quorum is f+1 of them
+func (r *Replica) f() int {
+	return (len(r.node.NodeIDs()) - 1) / 2
+}
+
+func (r *Replica) resetTimer() {
+	timeout := r.cfg.ViewChangeTimeout + time.Duration(r.env.Rand.Int64N(int64(r.cfg.ViewChangeTimeout)))
+	r.deadline = r.env.Clock.Now().Add(timeout)
+}
+
+// Moves to view in normal status after hearing from a replica already in it, keeping only committed ops
+// until the rest are fetched, since the view may have replaced the others
+func (r *Replica) enterView(view int64) {
+	logger.Info("entering view", "view", view, "primary", r.primary(view))
+
+	r.view = view
+	r.status = Normal
+	r.lastNormal = view
+	r.log = r.log[:r.commit+1]
+	r.failWaiters(r.commit + 1)
+	r.resetTimer()
+}
+
+// Sends every backup without an outstanding request the ops it's missing, or just the commit number
+func (r *Replica) broadcastPrepare() {
+	r.heartbeat = r.env.Clock.Now().Add(r.cfg.CommitInterval)
+
+	for _, peer := range r.peers() {
+		if !r.inFlight[peer] {
+			r.inFlight[peer] = true
+			go r.replicate(peer, r.prepareRequest(peer))
+		}
+	}
+}
+
+func (r *Replica) prepareRequest(peer string) PrepareRequestBody {
+	first := min(r.acked[peer], r.opNumber()) + 1
+	end := min(r.opNumber()+1, first+prepareBatchSize)
+
+	return PrepareRequestBody{
+		Type:   "vr_prepare",
+		View:   r.view,
+		First:  first,
+		Ops:    slices.Clone(r.log[first:end]),
+		Commit: r.commit,
+	}
+}
+
+// Sends peer a vr_prepare or vr_start_view and records how far its log now goes
+func (r *Replica) replicate(peer string, request any) {
+	ctx, cancel := r.env.Clock.WithTimeout(context.Background(), r.cfg.RPCTimeout)
+	defer cancel()
+
+	var resp PrepareResponseBody
+	err := handler.Call(ctx, r.node, peer, request, &resp)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.inFlight, peer)
+	if err != nil {
+		return
+	}
+
+	if resp.View > r.view {
+		r.enterView(resp.View)
+		go r.getState(peer)
+		return
+	}
+	if resp.View != r.view || r.status != Normal || !r.isPrimary() {
+		return
+	}
+
+	r.acked[peer] = resp.Op
+	r.advanceCommit()
+
+	// Keep going while the backup is behind rather than waiting for the next commit message
+	if resp.OK && r.acked[peer] < r.opNumber() {
+		r.inFlight[peer] = true
+		go r.replicate(peer, r.prepareRequest(peer))
+	}
+}
+
+// Commits the highest op that f backups have as well as the primary
+func (r *Replica) advanceCommit() {
+	for op := r.opNumber(); op > r.commit; op-- {
+		replicas := 1
+		for _, acked := range r.acked {
+			if acked >= op {
+				replicas++
+			}
+		}
+
+		if replicas >= r.f()+1 {
+			r.commitTo(op)
+			return
+		}
+	}
+}
+
+// Applies every op up to commit, which the log must reach
+func (r *Replica) commitTo(commit int64) {
+	r.commit = max(r.commit, commit)
+
+	for r.applied < r.commit {
+		r.applied++
+		entry := r.log[r.applied]
+		result := r.sm.Apply(entry.Command)
+
+		if w, ok := r.waiters[r.applied]; ok {
+			w.done <- applied{view: entry.View, result: result}
+			delete(r.waiters, r.applied)
+		}
+	}
+}
+
+// Tells Submit calls waiting on dropped ops that they'll never commit
+func (r *Replica) failWaiters(from int64) {
+	for op, w := range r.waiters {
+		if op >= from {
+			w.done <- applied{view: -1}
+			delete(r.waiters, op)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package vr
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/handler"
+)
+
+/*
+View changes
+
+A backup that hasn't heard from the primary for its timeout, drawn from
+[VR_VIEW_CHANGE_TIMEOUT_MS, 2*VR_VIEW_CHANGE_TIMEOUT_MS), moves to the next view in view-change status and
+sends vr_start_view_change to every other replica. A replica that hears of a higher view moves to it the
+same way, so they all end up changing to the same view. Once a replica has start_view_change from f
+others, it stops taking part in the old view and sends vr_do_view_change to the new view's primary, with
+its log, the last view it was normal in and its commit number.
+
+The new primary waits for f+1 do_view_change messages, its own among them, and takes the log of the one
+normal in the latest view, the longest of those if there's a tie. Every op committed before is on f+1
+replicas, at least one of which sent a do_view_change, and a replica normal in a later view had its log
+from that view's primary, so the log taken has every committed op. The primary sends the log to the others
+with vr_start_view, and commits the ops past the highest commit number reported once f of them answer.
+
+A view change that hasn't finished by the timeout, because the new primary is down too, moves on to the
+view after it.
+*/
+
+// Start view change RPC, a replica moving to a new view
+type StartViewChangeRequestBody struct {
+	Type string `json:"type"`
+	View int64  `json:"view"`
+}
+
+type StartViewChangeResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Do view change RPC, a replica handing its log to the new view's primary
+type DoViewChangeRequestBody struct {
+	Type       string  `json:"type"`
+	View       int64   `json:"view"`
+	Log        []Entry `json:"log"`
+	LastNormal int64   `json:"last_normal"`
+	Commit     int64   `json:"commit"`
+}
+
+type DoViewChangeResponseBody struct {
+	Type string `json:"type"`
+}
+
+// Start view RPC, the new primary sending the view's log, answered like vr_prepare
+type StartViewRequestBody struct {
+	Type   string  `json:"type"`
+	View   int64   `json:"view"`
+	Log    []Entry `json:"log"`
+	Commit int64   `json:"commit"`
+}
+
+func (r *Replica) handleStartViewChange(src string, body StartViewChangeRequestBody) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.View > r.view {
+		r.startViewChange(body.View)
+	}
+	if body.View == r.view && r.status == ViewChange {
+		r.startViewChanges[src] = true
+		r.sendDoViewChange()
+	}
+}
+
+func (r *Replica) handleDoViewChange(src string, body DoViewChangeRequestBody) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doViewChange(src, body)
+}
+
+func (r *Replica) handleStartView(src string, body StartViewRequestBody) PrepareResponseBody {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if body.View < r.view {
+		return PrepareResponseBody{View: r.view, Op: r.opNumber()}
+	}
+	// Already in the view, through a vr_prepare that got here first, and the log may have grown since
+	if body.View == r.view && r.status == Normal {
+		return PrepareResponseBody{View: r.view, OK: true, Op: r.opNumber()}
+	}
+
+	logger.Info("entering view", "view", body.View, "primary", src)
+	r.view = body.View
+	r.status = Normal
+	r.lastNormal = body.View
+	r.log = append([]Entry{{}}, body.Log...)
+	r.failWaiters(r.opNumber() + 1)
+	r.commitTo(min(body.Commit, r.opNumber()))
+	r.resetTimer()
+
+	return PrepareResponseBody{View: r.view, OK: true, Op: r.opNumber()}
+}
+
+// The following must be called with r.mu held
+
+func (r *Replica) startViewChange(view int64) {
+	logger.Info("starting view change", "view", view, "primary", r.primary(view))
+	r.viewChanges.Inc()
+
+	r.view = view
+	r.status = ViewChange
+	r.startViewChanges = make(map[string]bool)
+	r.doViewChanges = make(map[string]DoViewChangeRequestBody)
+	r.sentDoViewChange = false
+	r.resetTimer()
+
+	for _, peer := range r.peers() {
+		handler.Notify(r.node, peer, StartViewChangeRequestBody{Type: "vr_start_view_change", View: view})
+	}
+	r.sendDoViewChange()
+}
+
+// Hands this replica's log to the new primary once f others have started the view change, if it hasn't yet
+func (r *Replica) sendDoViewChange() {
+	if r.sentDoViewChange || len(r.startViewChanges) < r.f() {
+		return
+	}
+	r.sentDoViewChange = true
+
+	body := DoViewChangeRequestBody{
+		Type:       "vr_do_view_change",
+		View:       r.view,
+		Log:        slices.Clone(r.log[1:]),
+		LastNormal: r.lastNormal,
+		Commit:     r.commit,
+	}
+	if primary := r.primary(r.view); primary != r.node.ID() {
+		handler.Notify(r.node, primary, body)
+		return
+	}
+	r.doViewChange(r.node.ID(), body)
+}
+
+// Counts a do_view_change towards the view this replica is the new primary of, and starts the view once
+// it has f+1 of them
+func (r *Replica) doViewChange(src string, body DoViewChangeRequestBody) {
+	if body.View > r.view {
+		r.startViewChange(body.View)
+	}
+	if body.View != r.view || r.status != ViewChange || !r.isPrimary() {
+		return
+	}
+
+	r.doViewChanges[src] = body
+	if len(r.doViewChanges) < r.f()+1 {
+		return
+	}
+
+	// The log of the latest normal view, the longest among those
+	var best DoViewChangeRequestBody
+	commit := int64(0)
+	for _, dvc := range r.doViewChanges {
+		if c := cmp.Or(cmp.Compare(dvc.LastNormal, best.LastNormal), cmp.Compare(len(dvc.Log), len(best.Log))); c > 0 || best.Type == "" {
+			best = dvc
+		}
+		commit = max(commit, dvc.Commit)
+	}
+
+	logger.Info("starting view", "view", r.view, "ops", len(best.Log), "commit", commit)
+	r.status = Normal
+	r.lastNormal = r.view
+	r.log = append([]Entry{{}}, best.Log...)
+	r.failWaiters(r.opNumber() + 1)
+	r.commitTo(min(commit, r.opNumber()))
+
+	r.acked = make(map[string]int64)
+	r.inFlight = make(map[string]bool)
+	r.heartbeat = r.env.Clock.Now().Add(r.cfg.CommitInterval)
+	for _, peer := range r.peers() {
+		r.inFlight[peer] = true
+		go r.replicate(peer, StartViewRequestBody{Type: "vr_start_view", View: r.view, Log: slices.Clone(r.log[1:]), Commit: r.commit})
+	}
+	// A single replica commits on its own
+	r.advanceCommit()
+}
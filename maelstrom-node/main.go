@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/admin"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/broadcast"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/cart"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/chaos"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/config"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/coordination"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/counter"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/debug"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/dynamo"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/echo"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/kafka"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lifecycle"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/logging"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/lwwkv"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/metrics"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/paxos"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/percolator"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/primarybackup"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/queue"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/raft"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/saga"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/seqkvserver"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/snapshot"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/text"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/txn"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/uniqueids"
+	"github.com/THuitema/Distributed-Systems-Tutorial/internal/vr"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+Maelstrom node
+
+One binary for every challenge: the workload is picked by the first argument, the -workload flag or
+MAELSTROM_WORKLOAD, in that order, e.g.
+
+  ./maelstrom test -w broadcast --bin maelstrom-node/maelstrom-node ...
+
+with MAELSTROM_WORKLOAD=broadcast set, or a wrapper script running `maelstrom-node broadcast`. Each
+workload's package registers its handlers on the node, everything else is configured through that
+workload's own env vars, which -set NAME=VALUE (repeatable) overrides, e.g. -set ID_SCHEME=snowflake.
+The config RPC replies with the settings every module ended up with, see internal/config.
+
+SIGTERM, SIGINT or the end of stdin shut the node down gracefully: in-flight requests are answered,
+background work is stopped and queued gossip, replication and checkpoints are flushed, see internal/lifecycle.
+
+Every workload also answers the standard stats RPC with its metrics, see internal/metrics, and logs
+JSON to stderr at the levels LOG_LEVEL sets, see internal/logging. Stateful ones answer the snapshot
+and restore RPCs too, see internal/snapshot, and every one answers the debug RPC with goroutine and
+heap statistics and pprof profiles, see internal/debug. The chaos RPC turns on delays, dropped replies
+and errors injected into the node's own handling of requests, see internal/chaos, and the admin_* RPCs
+report status, get and set settings, pause and resume background work and flush queues, see internal/admin.
+
+Each workload's design and env vars are described in the doc comment of the package serving it, see
+workloads below.
+*/
+
+var logger = logging.For("main")
+
+var workloads = map[string]func(*maelstrom.Node) error{
+	"echo":           echo.Register,
+	"unique-ids":     uniqueids.Register,
+	"broadcast":      broadcast.Register,
+	"counter":        counter.Register,
+	"kafka":          kafka.Register,
+	"txn":            txn.Register,
+	"raft":           raft.Register,
+	"seq-kv":         seqkvserver.Register,
+	"lww-kv":         lwwkv.Register,
+	"coordination":   coordination.Register,
+	"queue":          queue.Register,
+	"primary-backup": primarybackup.Register,
+	"dynamo":         dynamo.Register,
+	"paxos":          paxos.Register,
+	"vr":             vr.Register,
+	"saga":           saga.Register,
+	"percolator":     percolator.Register,
+	"text":           text.Register,
+	"cart":           cart.Register,
+}
+
+func main() {
+	names := strings.Join(slices.Sorted(maps.Keys(workloads)), ", ")
+	workload := flag.String("workload", os.Getenv("MAELSTROM_WORKLOAD"), "workload to run: "+names)
+	flag.Func("set", "override an env var, as NAME=VALUE (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected NAME=VALUE, got %q", s)
+		}
+		config.Set(name, value)
+		return nil
+	})
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		*workload = flag.Arg(0)
+	}
+
+	register, ok := workloads[*workload]
+	if !ok {
+		fatal(fmt.Errorf("unknown workload %q, expected one of %s", *workload, names))
+	}
+
+	n := maelstrom.NewNode()
+	if err := logging.Setup(n); err != nil {
+		fatal(err)
+	}
+	if err := register(n); err != nil {
+		fatal(err)
+	}
+	if err := metrics.Register(n); err != nil {
+		fatal(err)
+	}
+	if err := config.Register(n); err != nil {
+		fatal(err)
+	}
+	if err := snapshot.Register(n); err != nil {
+		fatal(err)
+	}
+	if err := debug.Register(n); err != nil {
+		fatal(err)
+	}
+	if err := chaos.Register(n); err != nil {
+		fatal(err)
+	}
+	if err := admin.Register(n, *workload); err != nil {
+		fatal(err)
+	}
+
+	if err := lifecycle.Serve(n); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
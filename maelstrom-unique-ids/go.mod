@@ -1,7 +0,0 @@
-module maelstrom-unique-ids
-
-go 1.25.5
-
-require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20251128144731-cb7f07239012
-
-require github.com/google/uuid v1.6.0